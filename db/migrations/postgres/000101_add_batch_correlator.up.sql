@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE batches ADD COLUMN correlator UUID;
+COMMIT;
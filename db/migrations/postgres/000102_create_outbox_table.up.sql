@@ -0,0 +1,15 @@
+BEGIN;
+
+CREATE TABLE outbox (
+  seq            SERIAL          PRIMARY KEY,
+  namespace      VARCHAR(64)     NOT NULL,
+  batch_id       UUID            NOT NULL,
+  dispatcher     VARCHAR(64)     NOT NULL,
+  payload        TEXT            NOT NULL,
+  created        BIGINT          NOT NULL
+);
+
+CREATE INDEX outbox_namespace ON outbox(namespace);
+CREATE INDEX outbox_batch ON outbox(batch_id);
+
+COMMIT;
@@ -0,0 +1,4 @@
+BEGIN;
+DROP INDEX messages_idempotency_key;
+ALTER TABLE messages DROP COLUMN idempotency_key;
+COMMIT;
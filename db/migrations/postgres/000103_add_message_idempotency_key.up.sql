@@ -0,0 +1,4 @@
+BEGIN;
+ALTER TABLE messages ADD COLUMN idempotency_key VARCHAR(256);
+CREATE UNIQUE INDEX messages_idempotency_key ON messages(namespace_local, idempotency_key);
+COMMIT;
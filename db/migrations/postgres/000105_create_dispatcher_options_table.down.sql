@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE IF EXISTS dispatcher_options;
+COMMIT;
@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE messages ADD COLUMN flush BOOLEAN;
+COMMIT;
@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE IF EXISTS assembly_failures;
+COMMIT;
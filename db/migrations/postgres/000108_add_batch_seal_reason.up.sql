@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE batches ADD COLUMN seal_reason VARCHAR(64);
+COMMIT;
@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE batches ADD COLUMN annotations TEXT;
+COMMIT;
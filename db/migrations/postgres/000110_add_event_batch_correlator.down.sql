@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE events DROP COLUMN batch_cid;
+COMMIT;
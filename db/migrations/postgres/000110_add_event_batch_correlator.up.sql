@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE events ADD COLUMN batch_cid UUID;
+COMMIT;
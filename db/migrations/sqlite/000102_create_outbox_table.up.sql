@@ -0,0 +1,11 @@
+CREATE TABLE outbox (
+  seq            INTEGER         PRIMARY KEY AUTOINCREMENT,
+  namespace      VARCHAR(64)     NOT NULL,
+  batch_id       UUID            NOT NULL,
+  dispatcher     VARCHAR(64)     NOT NULL,
+  payload        TEXT            NOT NULL,
+  created        BIGINT          NOT NULL
+);
+
+CREATE INDEX outbox_namespace ON outbox(namespace);
+CREATE INDEX outbox_batch ON outbox(batch_id);
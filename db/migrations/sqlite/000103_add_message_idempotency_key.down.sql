@@ -0,0 +1,2 @@
+DROP INDEX messages_idempotency_key;
+ALTER TABLE messages DROP COLUMN idempotency_key;
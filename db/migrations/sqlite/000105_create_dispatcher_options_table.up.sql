@@ -0,0 +1,9 @@
+CREATE TABLE dispatcher_options (
+  seq            INTEGER         PRIMARY KEY AUTOINCREMENT,
+  namespace      VARCHAR(64)     NOT NULL,
+  dispatcher     VARCHAR(64)     NOT NULL,
+  options        TEXT            NOT NULL,
+  updated        BIGINT          NOT NULL
+);
+
+CREATE UNIQUE INDEX dispatcher_options_name ON dispatcher_options(namespace, dispatcher);
@@ -0,0 +1,12 @@
+CREATE TABLE assembly_failures (
+  seq            INTEGER         PRIMARY KEY AUTOINCREMENT,
+  namespace      VARCHAR(64)     NOT NULL,
+  message_id     UUID            NOT NULL,
+  missing_data   TEXT,
+  attempts       BIGINT          NOT NULL,
+  error          TEXT,
+  created        BIGINT          NOT NULL,
+  updated        BIGINT          NOT NULL
+);
+
+CREATE UNIQUE INDEX assembly_failures_message ON assembly_failures(namespace, message_id);
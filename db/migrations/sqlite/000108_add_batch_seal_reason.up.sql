@@ -0,0 +1 @@
+ALTER TABLE batches ADD COLUMN seal_reason VARCHAR(64);
@@ -0,0 +1 @@
+ALTER TABLE batches DROP COLUMN annotations;
@@ -0,0 +1 @@
+ALTER TABLE batches ADD COLUMN annotations TEXT;
@@ -104,6 +104,9 @@ var routes = append(
 		getPins,
 		getStatus,
 		getStatusBatchManager,
+		getStatusBatchManagerAssemblyFailures,
+		getStatusBatchManagerDispatchers,
+		getStatusBatchManagerDryRun,
 		getSubscriptionByID,
 		getSubscriptions,
 		getTokenAccountPools,
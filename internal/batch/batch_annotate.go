@@ -0,0 +1,45 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// BatchAnnotator returns metadata to attach to a batch, such as the deployment version or a trace ID
+type BatchAnnotator func(batch *core.Batch) fftypes.JSONObject
+
+// SetBatchAnnotator registers a hook that annotates each batch as it is sealed. The annotations are persisted with the
+// batch, so batches can be queried by them. It is called with the assembled batch just before the batch is persisted -
+// on each attempt to seal the batch, after any BatchIDGenerator has assigned its ID.
+func (bm *batchManager) SetBatchAnnotator(annotator BatchAnnotator) {
+	bm.batchAnnotatorMux.Lock()
+	defer bm.batchAnnotatorMux.Unlock()
+	bm.batchAnnotator = annotator
+}
+
+// annotateBatch applies any registered annotator to the batch being sealed
+func (bp *batchProcessor) annotateBatch(state *DispatchState) {
+	bp.bm.batchAnnotatorMux.Lock()
+	annotator := bp.bm.batchAnnotator
+	bp.bm.batchAnnotatorMux.Unlock()
+	if annotator == nil {
+		return
+	}
+	state.Persisted.Annotations = annotator(state.Persisted.GenInflight(state.Messages, state.Data))
+}
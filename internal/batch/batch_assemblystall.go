@@ -0,0 +1,184 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+)
+
+// AssemblyStallHandler is called when assembly of a message has failed repeatedly, because its data has not arrived
+type AssemblyStallHandler func(msgID *fftypes.UUID, attempts int)
+
+type assemblyFailure struct {
+	sequence     int64
+	attempts     int
+	lastReported time.Time
+	created      *fftypes.FFTime
+	persisted    bool
+}
+
+// OnAssemblyStall registers a callback for messages whose assembly has failed for missing data at least the
+// configured threshold of times. Each stalled message is reported at most once per report interval, and the
+// callback is invoked on its own goroutine so it cannot block the sequencer.
+func (bm *batchManager) OnAssemblyStall(handler AssemblyStallHandler) {
+	bm.assemblyStallMux.Lock()
+	defer bm.assemblyStallMux.Unlock()
+	bm.assemblyStallHandler = handler
+}
+
+// recordAssemblyFailure counts a failure to assemble the message, and reports it if it has now stalled. The message
+// is deferred by the caller to be read again, which is when the next attempt is counted.
+// Only the sequencer calls this, so no locking is required for the failure counts - the total is atomic
+// only because it is also read by the metrics collector.
+func (bm *batchManager) recordAssemblyFailure(msgID *fftypes.UUID, seq int64, err error) {
+	failure := bm.assemblyFailures[*msgID]
+	if failure == nil {
+		failure = &assemblyFailure{created: fftypes.Now()}
+		bm.assemblyFailures[*msgID] = failure
+	}
+	failure.sequence = seq
+	failure.attempts++
+	atomic.AddInt64(&bm.assemblyRetries, 1)
+	if failure.attempts < bm.assemblyStallThreshold {
+		return
+	}
+	if bm.assemblyStallPersist {
+		bm.persistAssemblyFailure(msgID, failure, err)
+	}
+	if time.Since(failure.lastReported) < bm.assemblyStallInterval {
+		return
+	}
+
+	bm.assemblyStallMux.Lock()
+	handler := bm.assemblyStallHandler
+	bm.assemblyStallMux.Unlock()
+	log.L(bm.ctx).Warnf("Assembly of message %s stalled after %d attempts waiting for its data", msgID, failure.attempts)
+	failure.lastReported = time.Now()
+	if handler != nil {
+		go handler(msgID, failure.attempts)
+	}
+}
+
+// clearAssemblyFailure forgets any failures to assemble the message, once it has been assembled - or dropped, as it
+// is no longer ready for dispatch
+func (bm *batchManager) clearAssemblyFailure(msgID *fftypes.UUID) {
+	failure := bm.assemblyFailures[*msgID]
+	if failure == nil {
+		return
+	}
+	delete(bm.assemblyFailures, *msgID)
+	if failure.persisted {
+		err := bm.database.DeleteAssemblyFailure(bm.ctx, bm.namespace, msgID)
+		if err != nil && err != database.DeleteRecordNotFound {
+			log.L(bm.ctx).Warnf("Failed to remove the assembly failure record for message %s: %s", msgID, err)
+		}
+	}
+}
+
+// pruneAssemblyFailures forgets the failures of messages that were not read again by a page covering their sequence,
+// as they are no longer ready for dispatch - such as a message that was deleted while waiting for its data. A complete
+// page read every ready message after the offset, so covers every sequence after it.
+func (bm *batchManager) pruneAssemblyFailures(pageOffset int64, entries []*core.IDAndSequence, complete bool) {
+	if len(bm.assemblyFailures) == 0 || (len(entries) == 0 && !complete) {
+		return
+	}
+	read := make(map[fftypes.UUID]bool, len(entries))
+	for _, entry := range entries {
+		read[entry.ID] = true
+	}
+	for msgID, failure := range bm.assemblyFailures {
+		covered := failure.sequence > pageOffset && (complete || failure.sequence <= entries[len(entries)-1].Sequence)
+		if covered && !read[msgID] {
+			dropped := msgID
+			log.L(bm.ctx).Infof("Message %s (seq=%d) waiting for its data is no longer ready for dispatch", &dropped, failure.sequence)
+			bm.clearAssemblyFailure(&dropped)
+		}
+	}
+}
+
+// persistAssemblyFailure records a stalled message in the database, with the data it is waiting for. The record is
+// updated on each further attempt. Failures are logged, and do not block the sequencer.
+func (bm *batchManager) persistAssemblyFailure(msgID *fftypes.UUID, failure *assemblyFailure, err error) {
+	record := &core.AssemblyFailure{
+		Namespace:   bm.namespace,
+		Message:     msgID,
+		MissingData: core.FFStringArray{},
+		Attempts:    int64(failure.attempts),
+		Created:     failure.created,
+		Updated:     fftypes.Now(),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	var dataMissing *ErrMessageDataMissing
+	if errors.As(err, &dataMissing) {
+		for _, dataID := range dataMissing.MissingData {
+			record.MissingData = append(record.MissingData, dataID.String())
+		}
+	}
+	if err := bm.database.UpsertAssemblyFailure(bm.ctx, record); err != nil {
+		log.L(bm.ctx).Warnf("Failed to record the assembly failure of message %s: %s", msgID, err)
+		return
+	}
+	failure.persisted = true
+}
+
+// restoreAssemblyFailures loads the stalled messages recorded on the last run, so their attempts continue to be
+// counted, and their records are removed once they are assembled. The record of a message that is no longer ready
+// for dispatch is removed straight away. Failures are logged, and do not block startup.
+func (bm *batchManager) restoreAssemblyFailures() {
+	fb := database.AssemblyFailureQueryFactory.NewFilter(bm.ctx)
+	records, _, err := bm.database.GetAssemblyFailures(bm.ctx, bm.namespace, fb.And())
+	if err != nil {
+		log.L(bm.ctx).Warnf("Failed to restore assembly failures: %s", err)
+		return
+	}
+	for _, record := range records {
+		failure := &assemblyFailure{
+			sequence:  -1,
+			attempts:  int(record.Attempts),
+			created:   record.Created,
+			persisted: true,
+		}
+		bm.assemblyFailures[*record.Message] = failure
+		msg, err := bm.database.GetMessageByID(bm.ctx, bm.namespace, record.Message)
+		switch {
+		case err != nil:
+			log.L(bm.ctx).Warnf("Failed to check if message %s stalled in assembly is still ready: %s", record.Message, err)
+		case msg == nil || msg.State != core.MessageStateReady:
+			bm.clearAssemblyFailure(record.Message)
+		default:
+			failure.sequence = msg.Sequence
+		}
+	}
+	if len(records) > 0 {
+		log.L(bm.ctx).Infof("Restored %d messages stalled in assembly on the last run", len(records))
+	}
+}
+
+// GetAssemblyFailures queries the messages recorded as stalled in assembly, when persistence of assembly failures is enabled
+func (bm *batchManager) GetAssemblyFailures(ctx context.Context, filter database.Filter) ([]*core.AssemblyFailure, *database.FilterResult, error) {
+	return bm.database.GetAssemblyFailures(ctx, bm.namespace, filter)
+}
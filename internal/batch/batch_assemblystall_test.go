@@ -0,0 +1,370 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/internal/coreconfig"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type assemblyStall struct {
+	msgID    *fftypes.UUID
+	attempts int
+}
+
+func TestAssemblyStallReportedForMissingData(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+	bm.messagePollTimeout = 5 * time.Millisecond
+	stalls := make(chan *assemblyStall, 1)
+	bm.OnAssemblyStall(func(msgID *fftypes.UUID, attempts int) {
+		stalls <- &assemblyStall{msgID: msgID, attempts: attempts}
+	})
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+
+	// The data for the message never arrives, and it is ready for dispatch until it is deleted
+	msg := newTestBroadcastMessage(1001)
+	var deleted, readsAfterDelete int32
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, nil, false, nil)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(func(ctx context.Context, ns string, filter database.Filter) []*core.IDAndSequence {
+		fi, _ := filter.Finalize()
+		if atomic.LoadInt32(&deleted) == 1 {
+			atomic.AddInt32(&readsAfterDelete, 1)
+			return []*core.IDAndSequence{}
+		}
+		if strings.HasPrefix(fi.String(), "( sequence >> 1000 )") {
+			return []*core.IDAndSequence{{ID: *msg.Header.ID, Sequence: msg.Sequence}}
+		}
+		return []*core.IDAndSequence{}
+	}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	// The sequencer reads the message again after each failure, until it is reported as stalled
+	stall := <-stalls
+	assert.Equal(t, msg.Header.ID, stall.msgID)
+	assert.Equal(t, 3, stall.attempts)
+
+	// Further failures within the report interval are not reported again
+	select {
+	case <-stalls:
+		assert.Fail(t, "stall reported again within the interval")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Once the message is deleted, it is no longer read, its failures are forgotten, and the offset moves past it
+	atomic.StoreInt32(&deleted, 1)
+	assert.Eventually(t, func() bool {
+		bm.inflightMux.Lock()
+		defer bm.inflightMux.Unlock()
+		return atomic.LoadInt32(&readsAfterDelete) > 2 && len(bm.deferredSequences) == 0 && bm.calcCommittableOffset() == 1001
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	bm.WaitStop()
+	assert.Empty(t, bm.assemblyFailures)
+}
+
+func TestAssemblyStallMessageRemoved(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	msgID := fftypes.NewUUID()
+	bm.recordAssemblyFailure(msgID, 1001, nil)
+	mdm := bm.data.(*datamocks.Manager)
+	mdm.On("GetMessageWithDataCached", mock.Anything, msgID).Return(nil, nil, false, nil)
+
+	// The message is skipped, rather than deferred waiting for data
+	pending, prepared := bm.preparePage(bm.ctx, []*core.IDAndSequence{{ID: *msgID, Sequence: 1001}}, 1000, time.Time{})
+	assert.Empty(t, pending)
+	assert.Equal(t, 1, prepared)
+	assert.Empty(t, bm.deferredSequences)
+	assert.Empty(t, bm.assemblyFailures)
+}
+
+func TestPruneAssemblyFailures(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	msgIDs := []*fftypes.UUID{fftypes.NewUUID(), fftypes.NewUUID(), fftypes.NewUUID()}
+	for i, msgID := range msgIDs {
+		bm.recordAssemblyFailure(msgID, int64(1001+i), nil)
+	}
+
+	// A partial page only covers the sequences up to the last message read
+	bm.pruneAssemblyFailures(1000, []*core.IDAndSequence{{ID: *msgIDs[1], Sequence: 1002}}, false)
+	assert.Len(t, bm.assemblyFailures, 2)
+	assert.NotNil(t, bm.assemblyFailures[*msgIDs[1]])
+	assert.NotNil(t, bm.assemblyFailures[*msgIDs[2]])
+
+	// An empty partial page covers nothing
+	bm.pruneAssemblyFailures(1000, nil, false)
+	assert.Len(t, bm.assemblyFailures, 2)
+
+	// A complete page covers every sequence after the offset
+	bm.pruneAssemblyFailures(1001, nil, true)
+	assert.Empty(t, bm.assemblyFailures)
+}
+
+func TestAssemblyStallReportInterval(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.assemblyStallThreshold = 1
+	bm.assemblyStallInterval = 0
+	stalls := make(chan int, 2)
+	bm.OnAssemblyStall(func(msgID *fftypes.UUID, attempts int) {
+		stalls <- attempts
+	})
+
+	msgID := fftypes.NewUUID()
+	bm.recordAssemblyFailure(msgID, 1001, nil)
+	bm.recordAssemblyFailure(msgID, 1001, nil)
+	assert.ElementsMatch(t, []int{1, 2}, []int{<-stalls, <-stalls})
+
+	// Once assembled, the failures are forgotten
+	bm.clearAssemblyFailure(msgID)
+	assert.Empty(t, bm.assemblyFailures)
+}
+
+func TestAssemblyStallNoHandler(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.assemblyStallThreshold = 1
+
+	msgID := fftypes.NewUUID()
+	bm.recordAssemblyFailure(msgID, 1001, nil)
+	assert.False(t, bm.assemblyFailures[*msgID].lastReported.IsZero())
+}
+
+func TestAssemblyStallPersisted(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.assemblyStallThreshold = 2
+	bm.assemblyStallPersist = true
+	mdi := bm.database.(*databasemocks.Plugin)
+
+	// The second of two data items never arrives
+	msg := newTestBroadcastMessage(1001)
+	found := &core.Data{ID: fftypes.NewUUID()}
+	missingID := fftypes.NewUUID()
+	msg.Data = core.DataRefs{{ID: found.ID}, {ID: missingID}}
+	mdm := bm.data.(*datamocks.Manager)
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{found}, false, nil).Times(3)
+	entries := []*core.IDAndSequence{{ID: *msg.Header.ID, Sequence: msg.Sequence}}
+
+	// Nothing is recorded until the message has stalled, then the record is updated on each attempt
+	var records []*core.AssemblyFailure
+	mdi.On("UpsertAssemblyFailure", mock.Anything, mock.MatchedBy(func(r *core.AssemblyFailure) bool {
+		records = append(records, r)
+		return true
+	})).Return(nil).Twice()
+	for i := 0; i < 3; i++ {
+		bm.preparePage(bm.ctx, entries, 1000, time.Time{})
+	}
+	assert.Len(t, records, 2)
+	assert.Equal(t, "ns1", records[0].Namespace)
+	assert.Equal(t, msg.Header.ID, records[0].Message)
+	assert.Equal(t, core.FFStringArray{missingID.String()}, records[0].MissingData)
+	assert.Equal(t, int64(2), records[0].Attempts)
+	assert.Regexp(t, "FF10133", records[0].Error)
+	assert.Equal(t, int64(3), records[1].Attempts)
+	assert.Equal(t, records[0].Created, records[1].Created)
+
+	// Once the data arrives the record is removed
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{found, {ID: missingID}}, true, nil)
+	mdi.On("DeleteAssemblyFailure", mock.Anything, "ns1", msg.Header.ID).Return(nil)
+	bm.preparePage(bm.ctx, entries, 1000, time.Time{})
+	assert.Empty(t, bm.assemblyFailures)
+	mdi.AssertExpectations(t)
+}
+
+func TestAssemblyStallPersistFail(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.assemblyStallThreshold = 1
+	bm.assemblyStallPersist = true
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("UpsertAssemblyFailure", mock.Anything, mock.Anything).Return(fmt.Errorf("pop"))
+
+	// A failure to record the stall is logged, and there is nothing to remove once assembled
+	msgID := fftypes.NewUUID()
+	bm.recordAssemblyFailure(msgID, 1001, nil)
+	assert.False(t, bm.assemblyFailures[*msgID].persisted)
+	bm.clearAssemblyFailure(msgID)
+	mdi.AssertExpectations(t)
+}
+
+func TestAssemblyStallDeleteFail(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("DeleteAssemblyFailure", mock.Anything, "ns1", mock.Anything).Return(fmt.Errorf("pop"))
+
+	msgID := fftypes.NewUUID()
+	bm.assemblyFailures[*msgID] = &assemblyFailure{attempts: 5, persisted: true}
+	bm.clearAssemblyFailure(msgID)
+	assert.Empty(t, bm.assemblyFailures)
+	mdi.AssertExpectations(t)
+}
+
+func TestRestoreAssemblyFailures(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+
+	created := fftypes.Now()
+	stalled := &core.AssemblyFailure{Namespace: "ns1", Message: fftypes.NewUUID(), Attempts: 7, Created: created}
+	deleted := &core.AssemblyFailure{Namespace: "ns1", Message: fftypes.NewUUID(), Attempts: 3, Created: created}
+	sent := &core.AssemblyFailure{Namespace: "ns1", Message: fftypes.NewUUID(), Attempts: 3, Created: created}
+	unknown := &core.AssemblyFailure{Namespace: "ns1", Message: fftypes.NewUUID(), Attempts: 3, Created: created}
+	mdi.On("GetAssemblyFailures", mock.Anything, "ns1", mock.Anything).Return([]*core.AssemblyFailure{stalled, deleted, sent, unknown}, nil, nil)
+	mdi.On("GetMessageByID", mock.Anything, "ns1", stalled.Message).Return(&core.Message{State: core.MessageStateReady, Sequence: 1001}, nil)
+	mdi.On("GetMessageByID", mock.Anything, "ns1", deleted.Message).Return(nil, nil)
+	mdi.On("GetMessageByID", mock.Anything, "ns1", sent.Message).Return(&core.Message{State: core.MessageStateSent, Sequence: 1002}, nil)
+	mdi.On("GetMessageByID", mock.Anything, "ns1", unknown.Message).Return(nil, fmt.Errorf("pop"))
+	mdi.On("DeleteAssemblyFailure", mock.Anything, "ns1", deleted.Message).Return(nil)
+	mdi.On("DeleteAssemblyFailure", mock.Anything, "ns1", sent.Message).Return(nil)
+	bm.restoreAssemblyFailures()
+
+	// The records of messages that are no longer ready are removed, and the rest are restored
+	assert.Len(t, bm.assemblyFailures, 2)
+	assert.Equal(t, &assemblyFailure{sequence: 1001, attempts: 7, created: created, persisted: true}, bm.assemblyFailures[*stalled.Message])
+	assert.Equal(t, int64(-1), bm.assemblyFailures[*unknown.Message].sequence)
+
+	// The next failure continues the count from the last run
+	bm.assemblyStallPersist = true
+	mdi.On("UpsertAssemblyFailure", mock.Anything, mock.MatchedBy(func(r *core.AssemblyFailure) bool {
+		return r.Attempts == 8 && r.Created == created
+	})).Return(nil)
+	bm.recordAssemblyFailure(stalled.Message, 1001, nil)
+	mdi.AssertExpectations(t)
+}
+
+func TestAssemblyStallPersistedUntilMessageDeleted(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+	bm.messagePollTimeout = 5 * time.Millisecond
+	bm.assemblyStallThreshold = 2
+	bm.assemblyStallPersist = true
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+
+	// The data for the message never arrives, and it is ready for dispatch until it is deleted
+	msg := newTestBroadcastMessage(1001)
+	var deleted int32
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, nil, false, nil)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(func(ctx context.Context, ns string, filter database.Filter) []*core.IDAndSequence {
+		fi, _ := filter.Finalize()
+		if atomic.LoadInt32(&deleted) == 0 && strings.HasPrefix(fi.String(), "( sequence >> 1000 )") {
+			return []*core.IDAndSequence{{ID: *msg.Header.ID, Sequence: msg.Sequence}}
+		}
+		return []*core.IDAndSequence{}
+	}, nil)
+
+	// The record is updated as the sequencer reads the message again, then removed once the message is deleted
+	attempts := make(chan int64, 10)
+	mdi.On("UpsertAssemblyFailure", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		select {
+		case attempts <- args[1].(*core.AssemblyFailure).Attempts:
+		default:
+		}
+	})
+	removed := make(chan bool)
+	mdi.On("DeleteAssemblyFailure", mock.Anything, "ns1", msg.Header.ID).Return(nil).Run(func(args mock.Arguments) {
+		close(removed)
+	}).Once()
+
+	mdi.On("GetAssemblyFailures", mock.Anything, "ns1", mock.Anything).Return([]*core.AssemblyFailure{}, nil, nil)
+	err := bm.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), <-attempts)
+	assert.Equal(t, int64(3), <-attempts)
+	atomic.StoreInt32(&deleted, 1)
+	<-removed
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestRestoreAssemblyFailuresFail(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetAssemblyFailures", mock.Anything, "ns1", mock.Anything).Return(nil, nil, fmt.Errorf("pop"))
+	bm.restoreAssemblyFailures()
+	assert.Empty(t, bm.assemblyFailures)
+}
+
+func TestStartRestoresAssemblyFailures(t *testing.T) {
+	testConfigReset()
+	config.Set(coreconfig.BatchManagerAssemblyStallPersist, true)
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetAssemblyFailures", mock.Anything, "ns1", mock.Anything).Return([]*core.AssemblyFailure{}, nil, nil)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil).Maybe()
+
+	err := bm.Start()
+	assert.NoError(t, err)
+	cancel()
+	bm.WaitStop()
+	mdi.AssertExpectations(t)
+}
+
+func TestGetAssemblyFailures(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetAssemblyFailures", mock.Anything, "ns1", mock.Anything).Return([]*core.AssemblyFailure{}, nil, nil)
+
+	fb := database.AssemblyFailureQueryFactory.NewFilter(bm.ctx)
+	failures, _, err := bm.GetAssemblyFailures(bm.ctx, fb.Gt("attempts", 5))
+	assert.NoError(t, err)
+	assert.Empty(t, failures)
+}
@@ -0,0 +1,82 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"github.com/hyperledger/firefly-common/pkg/log"
+)
+
+// dispatchBacklogLimits returns the high and low-water marks for the backlog of sealed batches waiting for dispatch,
+// with the low-water mark below the high-water mark
+func dispatchBacklogLimits(highWaterMark, lowWaterMark int) (int, int) {
+	if highWaterMark > 0 && lowWaterMark >= highWaterMark {
+		lowWaterMark = highWaterMark - 1
+	}
+	return highWaterMark, lowWaterMark
+}
+
+// enterDispatchBacklog counts a batch that has been sealed, and is waiting for dispatch
+func (bm *batchManager) enterDispatchBacklog() {
+	bm.backlogMux.Lock()
+	defer bm.backlogMux.Unlock()
+	bm.dispatchBacklog++
+}
+
+// leaveDispatchBacklog is called once the dispatch of a batch has completed, successfully or not, and releases the
+// sequencer if it was waiting for the backlog to drain to the low-water mark
+func (bm *batchManager) leaveDispatchBacklog() {
+	bm.backlogMux.Lock()
+	defer bm.backlogMux.Unlock()
+	bm.dispatchBacklog--
+	if bm.backlogDrained != nil && bm.dispatchBacklog <= bm.backlogLowWaterMark {
+		close(bm.backlogDrained)
+		bm.backlogDrained = nil
+	}
+}
+
+// waitWhileDispatchBacklogHigh blocks while the backlog of sealed batches has reached the high-water mark, until it
+// drains to the low-water mark, returning true if the context closes while waiting
+func (bm *batchManager) waitWhileDispatchBacklogHigh() (done bool) {
+	bm.backlogMux.Lock()
+	high := bm.backlogHighWaterMark > 0 && bm.dispatchBacklog >= bm.backlogHighWaterMark
+	if high && bm.backlogDrained == nil {
+		bm.backlogDrained = make(chan struct{})
+	}
+	drained := bm.backlogDrained
+	backlog := bm.dispatchBacklog
+	bm.backlogMux.Unlock()
+	if !high {
+		return false
+	}
+
+	log.L(bm.ctx).Infof("Assembly paused with %d sealed batches waiting for dispatch", backlog)
+	select {
+	case <-drained:
+		log.L(bm.ctx).Infof("Assembly resumed with the dispatch backlog drained to %d batches", bm.backlogLowWaterMark)
+		return false
+	case <-bm.drain:
+		return false
+	case <-bm.ctx.Done():
+		return true
+	}
+}
+
+func (bm *batchManager) dispatchBacklogStatus(status *ManagerStatus) {
+	bm.backlogMux.Lock()
+	defer bm.backlogMux.Unlock()
+	status.DispatchBacklog = bm.dispatchBacklog
+}
@@ -0,0 +1,142 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly/internal/coreconfig"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func testDispatchBacklog(bm *batchManager) int {
+	status := &ManagerStatus{}
+	bm.dispatchBacklogStatus(status)
+	return status.DispatchBacklog
+}
+
+func TestDispatchBacklogLimits(t *testing.T) {
+	high, low := dispatchBacklogLimits(10, 5)
+	assert.Equal(t, 10, high)
+	assert.Equal(t, 5, low)
+
+	high, low = dispatchBacklogLimits(10, 10)
+	assert.Equal(t, 10, high)
+	assert.Equal(t, 9, low)
+
+	high, low = dispatchBacklogLimits(0, 5)
+	assert.Equal(t, 0, high)
+	assert.Equal(t, 5, low)
+}
+
+func TestDispatchBacklogConfig(t *testing.T) {
+	testConfigReset()
+	defer coreconfig.Reset()
+	config.Set(coreconfig.BatchManagerDispatchBacklogHighWaterMark, 4)
+	config.Set(coreconfig.BatchManagerDispatchBacklogLowWaterMark, 8)
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	assert.Equal(t, 4, bm.backlogHighWaterMark)
+	assert.Equal(t, 3, bm.backlogLowWaterMark)
+}
+
+func TestDispatchBacklogDisabled(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	for i := 0; i < 100; i++ {
+		bm.enterDispatchBacklog()
+	}
+	assert.False(t, bm.waitWhileDispatchBacklogHigh())
+	assert.Equal(t, 100, testDispatchBacklog(bm))
+}
+
+func TestDispatchBacklogPausesUntilLowWaterMark(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.backlogHighWaterMark = 3
+	bm.backlogLowWaterMark = 1
+
+	bm.enterDispatchBacklog()
+	bm.enterDispatchBacklog()
+	assert.False(t, bm.waitWhileDispatchBacklogHigh())
+	bm.enterDispatchBacklog()
+
+	waitDone := make(chan bool)
+	go func() {
+		waitDone <- bm.waitWhileDispatchBacklogHigh()
+	}()
+	select {
+	case <-waitDone:
+		assert.Fail(t, "assembly continued at the high-water mark")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Dropping below the high-water mark is not enough to resume
+	bm.leaveDispatchBacklog()
+	select {
+	case <-waitDone:
+		assert.Fail(t, "assembly continued above the low-water mark")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	bm.leaveDispatchBacklog()
+	assert.False(t, <-waitDone)
+	assert.Equal(t, 1, testDispatchBacklog(bm))
+}
+
+func TestDispatchBacklogWaitContextClosed(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	bm.backlogHighWaterMark = 1
+	bm.enterDispatchBacklog()
+	cancel()
+	assert.True(t, bm.waitWhileDispatchBacklogHigh())
+}
+
+func TestDispatchBacklogCountsBatchesInDispatch(t *testing.T) {
+	bm, _, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	dispatching := make(chan bool)
+	release := make(chan bool)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatching <- true
+			<-release
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:   1,
+			DisposeTimeout: 120 * time.Second,
+		},
+	)
+
+	msg := newTestBroadcastMessage(1001)
+	processor, err := bm.getProcessor(msg.Header.TxType, msg.Header.Type, msg.Header.Group, &msg.Header.SignerRef, 0, "")
+	assert.NoError(t, err)
+	bm.dispatchMessage(bm.ctx, &pendingDispatch{processor: processor, msg: msg})
+
+	<-dispatching
+	assert.Equal(t, 1, testDispatchBacklog(bm))
+	close(release)
+	assert.Eventually(t, func() bool {
+		return testDispatchBacklog(bm) == 0
+	}, 5*time.Second, time.Millisecond)
+}
@@ -0,0 +1,85 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/log"
+)
+
+// Checkpoint is emitted periodically, to allow consumers to track the processing position of the batch manager
+// and detect lag - regardless of whether any batches have been dispatched since the last checkpoint.
+type Checkpoint struct {
+	// Offset is the highest sequence for which all messages read have been dispatched
+	Offset int64
+	// ReadOffset is the highest sequence that has been read, and handed to processors
+	ReadOffset int64
+	Timestamp  *fftypes.FFTime
+}
+
+// Checkpoints returns the channel on which checkpoints are delivered, when batch.manager.checkpoint.interval is set.
+// If the consumer falls behind, only the latest checkpoint is retained. The channel is closed when the manager stops.
+func (bm *batchManager) Checkpoints() <-chan *Checkpoint {
+	return bm.checkpoints
+}
+
+func (bm *batchManager) currentCheckpoint() *Checkpoint {
+	bm.inflightMux.Lock()
+	defer bm.inflightMux.Unlock()
+	return &Checkpoint{
+		Offset:     bm.calcCommittableOffset(),
+		ReadOffset: bm.highestReadOffset,
+		Timestamp:  fftypes.Now(),
+	}
+}
+
+func (bm *batchManager) emitCheckpoint(checkpoint *Checkpoint) {
+	for {
+		select {
+		case bm.checkpoints <- checkpoint:
+			return
+		default:
+			// Discard the stale checkpoint the consumer has not yet picked up, in favor of this one
+			select {
+			case <-bm.checkpoints:
+			default:
+			}
+		}
+	}
+}
+
+func (bm *batchManager) checkpointLoop() {
+	l := log.L(bm.ctx)
+	defer close(bm.checkpointerDone)
+	defer close(bm.checkpoints)
+
+	ticker := time.NewTicker(bm.checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			checkpoint := bm.currentCheckpoint()
+			l.Tracef("Batch manager checkpoint offset=%d readOffset=%d", checkpoint.Offset, checkpoint.ReadOffset)
+			bm.emitCheckpoint(checkpoint)
+		case <-bm.ctx.Done():
+			l.Debugf("Checkpoint loop exiting")
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly/internal/coreconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpointLoop(t *testing.T) {
+	testConfigReset()
+	config.Set(coreconfig.BatchManagerCheckpointInterval, "10ms")
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	bm.markRead(1000)
+	bm.inflightSequences[998] = nil
+	go bm.checkpointLoop()
+
+	checkpoint1 := <-bm.Checkpoints()
+	assert.Equal(t, int64(997), checkpoint1.Offset)
+	assert.Equal(t, int64(1000), checkpoint1.ReadOffset)
+
+	bm.inflightMux.Lock()
+	bm.inflightFlushed = append(bm.inflightFlushed, 998)
+	bm.inflightMux.Unlock()
+	bm.markRead(1010)
+
+	// Drain any checkpoint queued before the update
+	var checkpoint2 *Checkpoint
+	for checkpoint2 = range bm.Checkpoints() {
+		if checkpoint2.ReadOffset == 1010 {
+			break
+		}
+	}
+	assert.Equal(t, int64(1010), checkpoint2.Offset)
+	// Ticks are not evenly spaced under load, so only the ordering of the checkpoints is asserted
+	assert.True(t, time.Time(*checkpoint2.Timestamp).After(time.Time(*checkpoint1.Timestamp)))
+
+	cancel()
+	<-bm.checkpointerDone
+	_, ok := <-bm.Checkpoints()
+	assert.False(t, ok)
+}
+
+func TestEmitCheckpointReplacesStale(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	bm.emitCheckpoint(&Checkpoint{Offset: 1})
+	bm.emitCheckpoint(&Checkpoint{Offset: 2})
+
+	assert.Equal(t, int64(2), (<-bm.Checkpoints()).Offset)
+	assert.Empty(t, bm.checkpoints)
+}
@@ -0,0 +1,201 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"crypto/sha256"
+	"strings"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+)
+
+// The default "all" mode is where a single process assembles and dispatches batches
+const (
+	// batchModeAssemble only assembles and persists batches, leaving their messages in the assembled state
+	batchModeAssemble = "assemble"
+	// batchModeDispatch only claims and dispatches batches that have been assembled by another process
+	batchModeDispatch = "dispatch"
+)
+
+// ClaimAndDispatch claims each batch that has been assembled, and dispatches it using the registered dispatcher.
+// The claim is an atomic transition of the messages in the batch from the assembled state, so where multiple
+// worker processes are polling only one of them will dispatch each batch. Returns the number of batches dispatched.
+func (bm *batchManager) ClaimAndDispatch() (dispatched int, err error) {
+	for {
+		batchID, err := bm.nextAssembledBatch()
+		if err != nil || batchID == nil {
+			return dispatched, err
+		}
+
+		fb := database.MessageQueryFactory.NewFilter(bm.ctx)
+		claimed, err := bm.database.UpdateMessagesCount(bm.ctx, bm.namespace,
+			fb.And(
+				fb.Eq("batch", batchID),
+				fb.Eq("state", core.MessageStateAssembled),
+			),
+			database.MessageQueryFactory.NewUpdate(bm.ctx).Set("state", core.MessageStateBatching),
+		)
+		if err != nil {
+			return dispatched, err
+		}
+		if claimed == 0 {
+			log.L(bm.ctx).Debugf("Batch %s claimed by another process", batchID)
+			continue
+		}
+
+		processor, state, err := bm.loadClaimedBatch(batchID)
+		if err != nil {
+			return dispatched, err
+		}
+		if err := processor.dispatchAndFinalize(state); err != nil {
+			return dispatched, err
+		}
+		dispatched++
+	}
+}
+
+// nextAssembledBatch returns the ID of the batch containing the oldest assembled message, or nil if there are none
+func (bm *batchManager) nextAssembledBatch() (*fftypes.UUID, error) {
+	fb := database.MessageQueryFactory.NewFilterLimit(bm.ctx, 1)
+	ids, err := bm.database.GetMessageIDs(bm.ctx, bm.namespace, fb.And(
+		fb.Eq("state", core.MessageStateAssembled),
+	).Sort("sequence"))
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	msg, err := bm.database.GetMessageByID(bm.ctx, bm.namespace, &ids[0].ID)
+	if err != nil || msg == nil {
+		return nil, err
+	}
+	return msg.BatchID, nil
+}
+
+// loadClaimedBatch rebuilds the dispatch state for a batch assembled by another process, and returns
+// it along with the processor that would have assembled it - for use in dispatch
+func (bm *batchManager) loadClaimedBatch(batchID *fftypes.UUID) (processor *batchProcessor, state *DispatchState, err error) {
+	err = bm.retry.Do(bm.ctx, "load claimed batch", func(attempt int) (retry bool, err error) {
+		persisted, err := bm.database.GetBatchByID(bm.ctx, bm.namespace, batchID)
+		if err != nil {
+			return true, err
+		}
+		if persisted == nil {
+			return false, i18n.NewError(bm.ctx, coremsgs.MsgBatchNotFound, batchID)
+		}
+		fb := database.MessageQueryFactory.NewFilter(bm.ctx)
+		msgs, _, err := bm.database.GetMessages(bm.ctx, bm.namespace, fb.And(
+			fb.Eq("batch", batchID),
+		).Sort("sequence"))
+		if err != nil {
+			return true, err
+		}
+		if len(msgs) == 0 {
+			return false, i18n.NewError(bm.ctx, coremsgs.MsgBatchNotFound, batchID)
+		}
+
+		state = &DispatchState{
+			Persisted: *persisted,
+			claimed:   true,
+		}
+		for _, msg := range msgs {
+			data, foundAll, err := bm.data.GetMessageDataCached(bm.ctx, msg)
+			if err != nil {
+				return true, err
+			}
+			if !foundAll {
+				return false, newMessageDataMissingError(bm.ctx, msg.Header.ID, msg, data)
+			}
+			state.Messages = append(state.Messages, msg.BatchMessage())
+			for _, d := range data {
+				state.Data = append(state.Data, d.BatchData(state.Persisted.Type))
+			}
+		}
+
+		first := msgs[0]
+		if processor, err = bm.getMessageProcessor(first, 0); err != nil {
+			return false, err
+		}
+		if processor.conf.txType == core.TransactionTypeBatchPin {
+			if state.Pins, err = bm.rebuildPins(batchID, msgs); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	})
+	return processor, state, err
+}
+
+// rebuildPins recalculates the contexts (for broadcast) or pins (for private messages) allocated
+// when the batch was sealed, without allocating any new nonces
+func (bm *batchManager) rebuildPins(batchID *fftypes.UUID, msgs []*core.Message) (pins []*fftypes.Bytes32, err error) {
+	for _, msg := range msgs {
+		if msg.Header.Group != nil && len(msg.Pins) != len(msg.Header.Topics) {
+			return nil, i18n.NewError(bm.ctx, coremsgs.MsgBatchMessagePinsMissing, msg.Header.ID, batchID)
+		}
+		for i, topic := range msg.Header.Topics {
+			var pin *fftypes.Bytes32
+			if msg.Header.Group == nil {
+				hashBuilder := sha256.New()
+				hashBuilder.Write([]byte(topic))
+				pin = fftypes.HashResult(hashBuilder)
+			} else if pin, err = fftypes.ParseBytes32(bm.ctx, strings.Split(msg.Pins[i], ":")[0]); err != nil {
+				return nil, err
+			}
+			pins = append(pins, pin)
+		}
+	}
+	return pins, nil
+}
+
+// claimLoop runs in place of the message sequencer when the manager only dispatches batches,
+// polling for batches assembled by another process
+func (bm *batchManager) claimLoop() {
+	l := log.L(bm.ctx)
+	l.Debugf("Started batch claim loop")
+	defer close(bm.done)
+
+	for {
+		bm.reapQuiescing()
+		if bm.waitWhilePaused() || bm.isDraining() {
+			l.Debugf("Exiting claim loop")
+			return
+		}
+		bm.recordProgress()
+		if bm.isDispatchPaused() {
+			if bm.waitForNewMessages() {
+				l.Debugf("Exiting claim loop")
+				return
+			}
+			continue
+		}
+		dispatched, err := bm.ClaimAndDispatch()
+		bm.recordReadResult(err)
+		if err != nil {
+			l.Errorf("Failed to claim and dispatch assembled batches: %s", err)
+		}
+		if dispatched == 0 || err != nil {
+			if bm.waitForNewMessages() {
+				l.Debugf("Exiting claim loop")
+				return
+			}
+		}
+	}
+}
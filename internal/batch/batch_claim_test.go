@@ -0,0 +1,263 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func mockClaimableBatch(mdi *databasemocks.Plugin, mdm *datamocks.Manager, persisted *core.BatchPersisted, msgs ...*core.Message) {
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{{ID: *msgs[0].Header.ID, Sequence: msgs[0].Sequence}}, nil).Once()
+	mdi.On("GetMessageByID", mock.Anything, "ns1", msgs[0].Header.ID).Return(msgs[0], nil).Once()
+	mdi.On("UpdateMessagesCount", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(int64(len(msgs)), nil).Once()
+	mdi.On("GetBatchByID", mock.Anything, "ns1", persisted.ID).Return(persisted, nil).Once()
+	mdi.On("GetMessages", mock.Anything, "ns1", mock.Anything).Return(msgs, nil, nil).Once()
+	for _, msg := range msgs {
+		mdm.On("GetMessageDataCached", mock.Anything, msg).Return(core.DataArray{}, true, nil).Once()
+	}
+}
+
+func TestAssembleOnlyThenClaimAndDispatch(t *testing.T) {
+	// The assembling process persists the batch, without dispatching it, and its offset advances
+	assembler, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	assembler.assembleOnly = true
+	assembler.offsetEnabled = true
+	mdi.On("GetOffset", mock.Anything, core.OffsetTypeBatch, "ff_batch_ns1").Return(&core.Offset{RowID: 12345, Current: 1000}, nil)
+	committed := mockOffsetUpdates(mdi)
+	assembler.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			panic("assembling process must not dispatch")
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+	msg := newTestBroadcastMessage(1001)
+	mockOffsetAheadCheck(mdi, 1001)
+	mockMessagePage(mdi, mdm, msg)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := assembler.Start()
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		c := committed()
+		return len(c) > 0 && c[len(c)-1] == 1001
+	}, 5*time.Second, time.Millisecond)
+	cancel()
+	assembler.WaitStop()
+
+	var persisted *core.BatchPersisted
+	var assembledUpdate string
+	for _, call := range mdi.Calls {
+		switch call.Method {
+		case "UpsertBatch":
+			persisted = call.Arguments[1].(*core.BatchPersisted)
+		case "UpdateMessages":
+			update, _ := call.Arguments[3].(database.Update).Finalize()
+			assembledUpdate = fmt.Sprintf("%s", update.SetOperations[1].Value)
+		}
+	}
+	assert.NotNil(t, persisted)
+	assert.Equal(t, "assembled", assembledUpdate)
+
+	// A worker process claims the assembled batch, and dispatches it
+	worker, wdi, wdm, wcancel := newTestDispatchingBatchManager(t)
+	defer wcancel()
+	worker.dispatchOnly = true
+	dispatched := make(chan *DispatchState, 1)
+	worker.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+	claimedMsg := *msg
+	claimedMsg.BatchID = persisted.ID
+	claimedMsg.State = core.MessageStateAssembled
+	mockClaimableBatch(wdi, wdm, persisted, &claimedMsg)
+	wdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	count, err := worker.ClaimAndDispatch()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	state := <-dispatched
+	assert.Equal(t, persisted.ID, state.Persisted.ID)
+	assert.Equal(t, persisted.Hash, state.Persisted.Hash)
+	assert.Len(t, state.Messages, 1)
+	assert.Equal(t, msg.Header.ID, state.Messages[0].Header.ID)
+	topicHash := sha256.Sum256([]byte("topic1"))
+	assert.Equal(t, []*fftypes.Bytes32{(*fftypes.Bytes32)(&topicHash)}, state.Pins)
+
+	for _, call := range wdi.Calls {
+		switch call.Method {
+		case "UpdateMessagesCount":
+			filter, _ := call.Arguments[2].(database.Filter).Finalize()
+			assert.Equal(t, fmt.Sprintf("( batch == '%s' ) && ( state == 'assembled' )", persisted.ID), filter.String())
+		case "UpdateMessages":
+			filter, _ := call.Arguments[2].(database.Filter).Finalize()
+			assert.Contains(t, filter.String(), "state IN ['ready','batching']")
+		}
+	}
+	// The worker must not re-seal the batch
+	wdi.AssertNotCalled(t, "UpsertBatch", mock.Anything, mock.Anything)
+}
+
+func TestClaimAndDispatchLostClaim(t *testing.T) {
+	bm, mdi, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	msg := newTestBroadcastMessage(1001)
+	msg.BatchID = fftypes.NewUUID()
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{{ID: *msg.Header.ID}}, nil).Once()
+	mdi.On("GetMessageByID", mock.Anything, "ns1", msg.Header.ID).Return(msg, nil).Once()
+	mdi.On("UpdateMessagesCount", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(int64(0), nil).Once()
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil).Once()
+
+	count, err := bm.ClaimAndDispatch()
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+	mdi.AssertNotCalled(t, "GetBatchByID", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestClaimAndDispatchQueryFail(t *testing.T) {
+	bm, mdi, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(nil, fmt.Errorf("pop"))
+
+	_, err := bm.ClaimAndDispatch()
+	assert.Regexp(t, "pop", err)
+}
+
+func TestClaimAndDispatchClaimFail(t *testing.T) {
+	bm, mdi, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	msg := newTestBroadcastMessage(1001)
+	msg.BatchID = fftypes.NewUUID()
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{{ID: *msg.Header.ID}}, nil)
+	mdi.On("GetMessageByID", mock.Anything, "ns1", msg.Header.ID).Return(msg, nil)
+	mdi.On("UpdateMessagesCount", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(int64(-1), fmt.Errorf("pop"))
+
+	_, err := bm.ClaimAndDispatch()
+	assert.Regexp(t, "pop", err)
+}
+
+func TestClaimAndDispatchBatchNotFound(t *testing.T) {
+	bm, mdi, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	msg := newTestBroadcastMessage(1001)
+	msg.BatchID = fftypes.NewUUID()
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{{ID: *msg.Header.ID}}, nil)
+	mdi.On("GetMessageByID", mock.Anything, "ns1", msg.Header.ID).Return(msg, nil)
+	mdi.On("UpdateMessagesCount", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(int64(1), nil)
+	mdi.On("GetBatchByID", mock.Anything, "ns1", msg.BatchID).Return(nil, nil)
+
+	_, err := bm.ClaimAndDispatch()
+	assert.Regexp(t, "FF10209", err)
+}
+
+func TestLoadClaimedBatchNoMessages(t *testing.T) {
+	bm, mdi, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	batchID := fftypes.NewUUID()
+	mdi.On("GetBatchByID", mock.Anything, "ns1", batchID).Return(&core.BatchPersisted{}, nil)
+	mdi.On("GetMessages", mock.Anything, "ns1", mock.Anything).Return([]*core.Message{}, nil, nil)
+
+	_, _, err := bm.loadClaimedBatch(batchID)
+	assert.Regexp(t, "FF10209", err)
+}
+
+func TestLoadClaimedBatchDataNotFound(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	batchID := fftypes.NewUUID()
+	msg := newTestBroadcastMessage(1001)
+	mdi.On("GetBatchByID", mock.Anything, "ns1", batchID).Return(&core.BatchPersisted{}, nil)
+	mdi.On("GetMessages", mock.Anything, "ns1", mock.Anything).Return([]*core.Message{msg}, nil, nil)
+	mdm.On("GetMessageDataCached", mock.Anything, msg).Return(nil, false, nil)
+
+	_, _, err := bm.loadClaimedBatch(batchID)
+	assert.Regexp(t, "FF10133", err)
+}
+
+func TestLoadClaimedBatchUnregisteredDispatcher(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	batchID := fftypes.NewUUID()
+	msg := newTestBroadcastMessage(1001)
+	mdi.On("GetBatchByID", mock.Anything, "ns1", batchID).Return(&core.BatchPersisted{}, nil)
+	mdi.On("GetMessages", mock.Anything, "ns1", mock.Anything).Return([]*core.Message{msg}, nil, nil)
+	mdm.On("GetMessageDataCached", mock.Anything, msg).Return(core.DataArray{}, true, nil)
+
+	_, _, err := bm.loadClaimedBatch(batchID)
+	assert.Regexp(t, "FF10126", err)
+}
+
+func TestRebuildPinsPrivate(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	pin := fftypes.NewRandB32()
+	msg := newTestBroadcastMessage(1001)
+	msg.Header.Group = fftypes.NewRandB32()
+	msg.Pins = core.FFStringArray{fmt.Sprintf("%s:%.16d", pin, 12)}
+
+	pins, err := bm.rebuildPins(fftypes.NewUUID(), []*core.Message{msg})
+	assert.NoError(t, err)
+	assert.Equal(t, []*fftypes.Bytes32{pin}, pins)
+
+	msg.Pins = core.FFStringArray{"bad:0000000000000012"}
+	_, err = bm.rebuildPins(fftypes.NewUUID(), []*core.Message{msg})
+	assert.Regexp(t, "FF00107", err)
+
+	msg.Pins = nil
+	_, err = bm.rebuildPins(fftypes.NewUUID(), []*core.Message{msg})
+	assert.Regexp(t, "FF10430", err)
+}
+
+func TestClaimLoopDispatchOnly(t *testing.T) {
+	bm, mdi, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.dispatchOnly = true
+	polled := make(chan string, 1)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.MatchedBy(func(f database.Filter) bool {
+		fi, _ := f.Finalize()
+		select {
+		case polled <- fi.String():
+		default:
+		}
+		return true
+	})).Return(nil, fmt.Errorf("pop"))
+
+	err := bm.Start()
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(<-polled, "( state == 'assembled' )"))
+
+	cancel()
+	bm.WaitStop()
+}
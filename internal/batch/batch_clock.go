@@ -0,0 +1,68 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"time"
+)
+
+// Clock is the source of time for the batch timeout, maximum age and disposal of each processor, and the polling of
+// the message sequencer - so tests can drive those timeouts deterministically
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single-shot timer created by a Clock, equivalent to time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// wallClock is the default Clock
+type wallClock struct{}
+
+type wallTimer struct {
+	timer *time.Timer
+}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (wallClock) NewTimer(d time.Duration) Timer {
+	return &wallTimer{timer: time.NewTimer(d)}
+}
+
+func (t *wallTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t *wallTimer) Stop() bool {
+	return t.timer.Stop()
+}
+
+// SetClock replaces the wall clock used for the timeouts of the batch manager. It must be called before Start, and
+// before any dispatcher is registered.
+func (bm *batchManager) SetClock(clock Clock) {
+	bm.clock = clock
+}
+
+// since is the time elapsed on the clock of the batch manager since t
+func (bm *batchManager) since(t time.Time) time.Duration {
+	return bm.clock.Now().Sub(t)
+}
@@ -0,0 +1,185 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock only moves forwards when advanced, firing any timers that are then due
+type fakeClock struct {
+	mux    sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	deadline time.Time
+	c        chan time.Time
+	active   bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now()}
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	return fc.now
+}
+
+func (fc *fakeClock) NewTimer(d time.Duration) Timer {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	t := &fakeTimer{clock: fc, deadline: fc.now.Add(d), c: make(chan time.Time, 1), active: true}
+	if d <= 0 {
+		t.fire()
+	} else {
+		fc.timers = append(fc.timers, t)
+	}
+	return t
+}
+
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	fc.now = fc.now.Add(d)
+	pending := make([]*fakeTimer, 0, len(fc.timers))
+	for _, t := range fc.timers {
+		if t.active && !t.deadline.After(fc.now) {
+			t.fire()
+		} else if t.active {
+			pending = append(pending, t)
+		}
+	}
+	fc.timers = pending
+}
+
+// hasTimer returns true if there is an active timer that fires after d
+func (fc *fakeClock) hasTimer(d time.Duration) bool {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	for _, t := range fc.timers {
+		if t.active && t.deadline.Sub(fc.now) == d {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fakeTimer) fire() {
+	t.active = false
+	t.c <- t.deadline
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+func TestWallClock(t *testing.T) {
+	clock := wallClock{}
+	assert.WithinDuration(t, time.Now(), clock.Now(), time.Second)
+	timer := clock.NewTimer(time.Millisecond)
+	<-timer.C()
+	assert.False(t, timer.Stop())
+}
+
+func TestBatchTimeoutWithFakeClock(t *testing.T) {
+	bm, _, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	clock := newFakeClock()
+	bm.SetClock(clock)
+
+	dispatched := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:   10,
+			BatchMaxBytes:  1024 * 1024,
+			BatchTimeout:   time.Minute,
+			DisposeTimeout: time.Hour,
+		},
+	)
+
+	msg := newTestBroadcastMessage(1001)
+	processor, err := bm.getProcessor(msg.Header.TxType, msg.Header.Type, msg.Header.Group, &msg.Header.SignerRef, 0, "")
+	assert.NoError(t, err)
+	bm.dispatchMessage(bm.ctx, &pendingDispatch{processor: processor, msg: msg})
+	assert.Eventually(t, func() bool { return clock.hasTimer(time.Minute) }, 5*time.Second, time.Millisecond)
+
+	// The batch is open until exactly the batch timeout
+	clock.Advance(time.Minute - time.Nanosecond)
+	assert.Equal(t, time.Nanosecond, time.Duration(processor.openBatchTimer().TimeoutRemaining))
+	select {
+	case <-dispatched:
+		assert.Fail(t, "dispatched before the batch timeout")
+	default:
+	}
+	clock.Advance(time.Nanosecond)
+	state := <-dispatched
+	assert.Equal(t, msg.Header.ID, state.Messages[0].Header.ID)
+	assert.Eventually(t, func() bool {
+		return bm.DispatcherStats()[core.MessageTypeBroadcast].FlushedByTimeout == 1
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestWaitForNewMessagesWithFakeClock(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	clock := newFakeClock()
+	bm.SetClock(clock)
+	bm.minimumPollDelay = time.Second
+	bm.messagePollTimeout = time.Minute
+	bm.messagePollJitter = 0
+
+	done := make(chan bool)
+	go func() {
+		done <- bm.waitForNewMessages()
+	}()
+	assert.Eventually(t, func() bool { return clock.hasTimer(time.Second) }, 5*time.Second, time.Millisecond)
+	clock.Advance(time.Second)
+	assert.Eventually(t, func() bool { return clock.hasTimer(time.Minute - time.Second) }, 5*time.Second, time.Millisecond)
+	clock.Advance(time.Minute - time.Second)
+	assert.False(t, <-done)
+
+	// Cancelling during the minimum poll delay exits
+	go func() {
+		done <- bm.waitForNewMessages()
+	}()
+	assert.Eventually(t, func() bool { return clock.hasTimer(time.Second) }, 5*time.Second, time.Millisecond)
+	cancel()
+	assert.True(t, <-done)
+}
@@ -0,0 +1,56 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+)
+
+// handlerState returns the state to pass to the dispatch handler. When the dispatcher clones batches, this is a copy
+// holding a deep copy of the batch, so nothing the handler retains is shared with the manager.
+func (bp *batchProcessor) handlerState(state *DispatchState) *DispatchState {
+	if !bp.conf.CloneBatch {
+		return state
+	}
+	batch := state.Persisted.GenInflight(state.Messages, state.Data).Clone()
+	clone := &DispatchState{
+		Persisted: state.Persisted,
+		Messages:  batch.Payload.Messages,
+		Data:      batch.Payload.Data,
+		lazyData:  state.lazyData,
+	}
+	clone.Persisted.BatchHeader = batch.BatchHeader
+	clone.Persisted.Hash = batch.Hash
+	clone.Persisted.TX = batch.Payload.TX
+	if state.Pins != nil {
+		clone.Pins = make([]*fftypes.Bytes32, len(state.Pins))
+		for i, pin := range state.Pins {
+			if pin != nil {
+				p := *pin
+				clone.Pins[i] = &p
+			}
+		}
+	}
+	if state.Provenance != nil {
+		clone.Provenance = make(map[fftypes.UUID]*MessageProvenance, len(state.Provenance))
+		for id, provenance := range state.Provenance {
+			p := *provenance
+			clone.Provenance[id] = &p
+		}
+	}
+	return clone
+}
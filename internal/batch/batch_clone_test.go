@@ -0,0 +1,86 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCloneState() *DispatchState {
+	msg := newTestBroadcastMessage(1001)
+	msg.Header.Topics = core.FFStringArray{"topic1"}
+	return &DispatchState{
+		Persisted: core.BatchPersisted{
+			BatchHeader: core.BatchHeader{ID: fftypes.NewUUID(), Type: core.BatchTypeBroadcast},
+			Hash:        fftypes.NewRandB32(),
+			TX:          core.TransactionRef{Type: core.TransactionTypeBatchPin, ID: fftypes.NewUUID()},
+		},
+		Messages:   []*core.Message{msg},
+		Data:       core.DataArray{{ID: fftypes.NewUUID(), Value: fftypes.JSONAnyPtr(`"data"`)}},
+		Pins:       []*fftypes.Bytes32{fftypes.NewRandB32()},
+		Provenance: map[fftypes.UUID]*MessageProvenance{*msg.Header.ID: {Source: "api"}},
+	}
+}
+
+func TestDispatchCloneBatch(t *testing.T) {
+	var dispatched *DispatchState
+	cancel, _, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		dispatched = state
+		state.Messages[0].Header.Topics[0] = "changed"
+		state.SignalSlowDown()
+		return nil
+	})
+	defer cancel()
+	bp.conf.CloneBatch = true
+
+	state := newTestCloneState()
+	err := bp.dispatchBatch(state)
+	assert.NoError(t, err)
+
+	// The handler saw an equal, but unshared, copy of the state
+	assert.NotSame(t, state, dispatched)
+	assert.Equal(t, state.Persisted.ID, dispatched.Persisted.ID)
+	assert.NotSame(t, state.Persisted.ID, dispatched.Persisted.ID)
+	assert.NotSame(t, state.Persisted.TX.ID, dispatched.Persisted.TX.ID)
+	assert.NotSame(t, state.Messages[0], dispatched.Messages[0])
+	assert.Equal(t, "topic1", state.Messages[0].Header.Topics[0])
+	assert.Equal(t, state.Data, dispatched.Data)
+	assert.NotSame(t, state.Data[0], dispatched.Data[0])
+	assert.Equal(t, state.Pins, dispatched.Pins)
+	assert.NotSame(t, state.Pins[0], dispatched.Pins[0])
+	assert.Equal(t, state.Provenance, dispatched.Provenance)
+	assert.NotSame(t, state.Provenance[*state.Messages[0].Header.ID], dispatched.Provenance[*state.Messages[0].Header.ID])
+}
+
+func TestDispatchNoCloneBatch(t *testing.T) {
+	var dispatched *DispatchState
+	cancel, _, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		dispatched = state
+		return nil
+	})
+	defer cancel()
+
+	state := newTestCloneState()
+	err := bp.dispatchBatch(state)
+	assert.NoError(t, err)
+	assert.Same(t, state, dispatched)
+}
@@ -0,0 +1,57 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+)
+
+// dispatchConcurrently waits for a free dispatch slot, then dispatches and finalizes the sealed batch on a worker,
+// so the processor can go on to assemble the next batch. The sequences in the batch are only reported as flushed,
+// allowing the offset to advance, once the batch has been dispatched. A batch that fails to dispatch (which only
+// happens when closing, as dispatch is retried) shuts down the processor, just as a failed flush does.
+func (bp *batchProcessor) dispatchConcurrently(state *DispatchState, flushWork []*batchWork, byteSize int64, trigger flushTrigger) error {
+	if bp.ctx.Err() != nil {
+		return i18n.NewError(bp.ctx, coremsgs.MsgContextCanceled)
+	}
+	// The slots are replaced if the dispatcher is retuned, so the worker must release the slot it took
+	slots := bp.dispatchSlots
+	bp.bm.enterDispatchBacklog()
+	select {
+	case slots <- struct{}{}:
+	case <-bp.ctx.Done():
+		bp.bm.leaveDispatchBacklog()
+		return i18n.NewError(bp.ctx, coremsgs.MsgContextCanceled)
+	}
+	bp.dispatchWorkers.Add(1)
+	go func() {
+		defer func() {
+			bp.bm.leaveDispatchBacklog()
+			<-slots
+			bp.dispatchWorkers.Done()
+		}()
+		if err := bp.dispatchAndFinalize(state); err != nil {
+			log.L(bp.ctx).Warnf("Batch processor shutting down after dispatch of batch %s failed: %s", state.Persisted.ID, err)
+			bp.cancelCtx()
+			return
+		}
+		bp.completeFlush(state, flushWork, byteSize, trigger)
+	}()
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/mocks/identitymanagermocks"
+	"github.com/hyperledger/firefly/mocks/txcommonmocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestConcurrentBatchProcessor(t *testing.T, concurrency int, dispatch DispatchHandler) (func(), *databasemocks.Plugin, *batchProcessor) {
+	cancel, mdi, bp := newTestBatchProcessor(t, dispatch)
+	bp.conf.txType = core.TransactionTypeUnpinned
+	bp.dispatchSlots = make(chan struct{}, concurrency)
+	mockRunAsGroupPassthrough(mdi)
+	bp.bm.identity.(*identitymanagermocks.Manager).On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
+	bp.txHelper.(*txcommonmocks.Helper).On("SubmitNewTransaction", mock.Anything, core.TransactionTypeUnpinned).Return(fftypes.NewUUID(), nil)
+	bp.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("InsertEvent", mock.Anything, mock.Anything).Return(nil)
+	return cancel, mdi, bp
+}
+
+func (bp *batchProcessor) flushedSequences() []int64 {
+	bp.bm.inflightMux.Lock()
+	defer bp.bm.inflightMux.Unlock()
+	return append([]int64{}, bp.bm.inflightFlushed...)
+}
+
+func TestDispatchConcurrently(t *testing.T) {
+	// The dispatched messages do not carry their sequence, so are looked up by ID
+	msgs := []*core.Message{newTestBroadcastMessage(1001), newTestBroadcastMessage(1002), newTestBroadcastMessage(1003)}
+	sequences := make(map[fftypes.UUID]int64)
+	release := make(map[int64]chan struct{})
+	for _, msg := range msgs {
+		sequences[*msg.Header.ID] = msg.Sequence
+		release[msg.Sequence] = make(chan struct{})
+	}
+	started := make(chan int64, 3)
+	cancel, _, bp := newTestConcurrentBatchProcessor(t, 2, func(c context.Context, state *DispatchState) error {
+		seq := sequences[*state.Messages[0].Header.ID]
+		started <- seq
+		<-release[seq]
+		return nil
+	})
+	defer cancel()
+
+	flushed := make(chan bool, 3)
+	go func() {
+		for _, msg := range msgs {
+			bp.addWork(&batchWork{msg: msg})
+			err := bp.flush(false, flushTriggerSize)
+			assert.NoError(t, err)
+			flushed <- true
+		}
+	}()
+
+	// Two batches are in dispatch at once, and the third waits for a free slot
+	assert.ElementsMatch(t, []int64{1001, 1002}, []int64{<-started, <-started})
+	<-flushed
+	<-flushed
+	select {
+	case <-flushed:
+		assert.Fail(t, "third flush should wait for a dispatch slot")
+	case <-time.After(10 * time.Millisecond):
+	}
+	assert.Empty(t, bp.flushedSequences())
+
+	// The second batch completes first, and is the only one reported flushed
+	close(release[1002])
+	assert.Equal(t, int64(1003), <-started)
+	<-flushed
+	assert.Equal(t, []int64{1002}, bp.flushedSequences())
+
+	close(release[1001])
+	close(release[1003])
+	bp.dispatchWorkers.Wait()
+	assert.ElementsMatch(t, []int64{1001, 1002, 1003}, bp.flushedSequences())
+}
+
+func TestDispatchConcurrentlyFailureStopsProcessor(t *testing.T) {
+	cancel, _, bp := newTestConcurrentBatchProcessor(t, 2, func(c context.Context, state *DispatchState) error {
+		return fmt.Errorf("pop")
+	})
+	defer cancel()
+	// Dispatch fails when the handler is not retried
+	bp.conf.MaxDispatchAttempts = 1
+	bp.conf.DeadLetter = func(ctx context.Context, batch *core.Batch, err error) error {
+		<-ctx.Done()
+		return err
+	}
+
+	bp.addWork(&batchWork{msg: newTestBroadcastMessage(1001)})
+	err := bp.flush(false, flushTriggerSize)
+	assert.NoError(t, err)
+
+	// The processor closing unblocks the dead-letter callback, and the batch is not reported flushed
+	bp.cancelCtx()
+	<-bp.done
+	assert.Empty(t, bp.flushedSequences())
+
+	err = bp.dispatchConcurrently(&DispatchState{}, nil, 0, flushTriggerSize)
+	assert.Regexp(t, "FF00154", err)
+}
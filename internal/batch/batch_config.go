@@ -0,0 +1,150 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly-common/pkg/retry"
+	"github.com/hyperledger/firefly/internal/coreconfig"
+	"github.com/hyperledger/firefly/internal/data"
+	"github.com/hyperledger/firefly/internal/identity"
+	"github.com/hyperledger/firefly/internal/txcommon"
+	"github.com/hyperledger/firefly/pkg/database"
+)
+
+const (
+	// ConfigReadPageSize is the size of each page of messages read from the database into memory when assembling batches
+	ConfigReadPageSize = "readPageSize"
+	// ConfigPollTimeout is how long without any notifications of new messages to wait, before doing a page query
+	ConfigPollTimeout = "pollTimeout"
+	// ConfigPollJitter is the fraction of the poll timeout by which each poll is randomly brought forward or delayed
+	ConfigPollJitter = "pollJitter"
+	// ConfigMinimumPollDelay is the minimum time to wait between polls on the database
+	ConfigMinimumPollDelay = "minimumPollDelay"
+	// ConfigOffsetName distinguishes the persisted offset of the manager from others in the same namespace. It is used
+	// as given, and defaults to the offset of the namespace if empty
+	ConfigOffsetName = "offsetName"
+
+	// ConfigRetryKey is a sub-key in the config for the retry of database operations
+	ConfigRetryKey = "retry"
+	// ConfigRetryInitDelay is the initial delay before retrying a database operation
+	ConfigRetryInitDelay = "initDelay"
+	// ConfigRetryMaxDelay is the maximum delay between retries of a database operation
+	ConfigRetryMaxDelay = "maxDelay"
+	// ConfigRetryFactor is the backoff factor for retries of database operations
+	ConfigRetryFactor = "factor"
+
+	// ConfigDispatcherKey is a sub-key in the config for the defaults of the options of each dispatcher
+	ConfigDispatcherKey = "dispatcher"
+	// ConfigDispatcherBatchTimeout is the BatchTimeout of dispatchers registered without one
+	ConfigDispatcherBatchTimeout = "batchTimeout"
+	// ConfigDispatcherDisposeTimeout is the DisposeTimeout of dispatchers registered without one
+	ConfigDispatcherDisposeTimeout = "disposeTimeout"
+)
+
+// InitConfig adds the keys read by NewBatchManagerFromConfig to a config section. The paging, polling and retry keys
+// have no defaults of their own - any not set in the section are read from the batch.manager and batch.retry config.
+func InitConfig(conf config.Section) {
+	conf.AddKnownKey(ConfigReadPageSize)
+	conf.AddKnownKey(ConfigPollTimeout)
+	conf.AddKnownKey(ConfigPollJitter)
+	conf.AddKnownKey(ConfigMinimumPollDelay)
+	conf.AddKnownKey(ConfigOffsetName)
+
+	retryConf := conf.SubSection(ConfigRetryKey)
+	retryConf.AddKnownKey(ConfigRetryInitDelay)
+	retryConf.AddKnownKey(ConfigRetryMaxDelay)
+	retryConf.AddKnownKey(ConfigRetryFactor)
+
+	dispatcherConf := conf.SubSection(ConfigDispatcherKey)
+	dispatcherConf.AddKnownKey(ConfigDispatcherBatchTimeout)
+	dispatcherConf.AddKnownKey(ConfigDispatcherDisposeTimeout)
+}
+
+// managerTuning is the tuning of a batch manager that can be configured independently for each manager
+type managerTuning struct {
+	readPageSize       uint
+	messagePollTimeout time.Duration
+	messagePollJitter  float64
+	minimumPollDelay   time.Duration
+	retry              retry.Retry
+	defaultOptions     DispatcherOptions
+}
+
+// NewBatchManagerFromConfig creates a batch manager with its paging, polling and retry tuning, and the defaults for
+// the options of its dispatchers, read from a config section initialized with InitConfig - so several managers can
+// be tuned independently. Keys not set in the section, and any other tuning, are read from the batch.manager and
+// batch.retry config, as with NewBatchManager.
+func NewBatchManagerFromConfig(ctx context.Context, ns string, conf config.Section, di database.Plugin, dm data.Manager, im identity.Manager, txHelper txcommon.Helper) (Manager, error) {
+	tuning := rootTuning()
+	if conf.Get(ConfigReadPageSize) != nil {
+		tuning.readPageSize = conf.GetUint(ConfigReadPageSize)
+	}
+	if conf.Get(ConfigPollTimeout) != nil {
+		tuning.messagePollTimeout = conf.GetDuration(ConfigPollTimeout)
+	}
+	if conf.Get(ConfigPollJitter) != nil {
+		tuning.messagePollJitter = conf.GetFloat64(ConfigPollJitter)
+	}
+	if conf.Get(ConfigMinimumPollDelay) != nil {
+		tuning.minimumPollDelay = conf.GetDuration(ConfigMinimumPollDelay)
+	}
+
+	retryConf := conf.SubSection(ConfigRetryKey)
+	if retryConf.Get(ConfigRetryInitDelay) != nil {
+		tuning.retry.InitialDelay = retryConf.GetDuration(ConfigRetryInitDelay)
+	}
+	if retryConf.Get(ConfigRetryMaxDelay) != nil {
+		tuning.retry.MaximumDelay = retryConf.GetDuration(ConfigRetryMaxDelay)
+	}
+	if retryConf.Get(ConfigRetryFactor) != nil {
+		tuning.retry.Factor = retryConf.GetFloat64(ConfigRetryFactor)
+	}
+
+	dispatcherConf := conf.SubSection(ConfigDispatcherKey)
+	tuning.defaultOptions.BatchTimeout = dispatcherConf.GetDuration(ConfigDispatcherBatchTimeout)
+	tuning.defaultOptions.DisposeTimeout = dispatcherConf.GetDuration(ConfigDispatcherDisposeTimeout)
+	return newBatchManager(ctx, ns, conf.GetString(ConfigOffsetName), tuning, di, dm, im, txHelper)
+}
+
+// rootTuning reads the tuning of a batch manager from the batch.manager and batch.retry config
+func rootTuning() *managerTuning {
+	return &managerTuning{
+		readPageSize:       config.GetUint(coreconfig.BatchManagerReadPageSize),
+		messagePollTimeout: config.GetDuration(coreconfig.BatchManagerReadPollTimeout),
+		messagePollJitter:  config.GetFloat64(coreconfig.BatchManagerReadPollJitter),
+		minimumPollDelay:   config.GetDuration(coreconfig.BatchManagerMinimumPollDelay),
+		retry: retry.Retry{
+			InitialDelay: config.GetDuration(coreconfig.BatchRetryInitDelay),
+			MaximumDelay: config.GetDuration(coreconfig.BatchRetryMaxDelay),
+			Factor:       config.GetFloat64(coreconfig.BatchRetryFactor),
+		},
+	}
+}
+
+// applyDefaultOptions fills in the options a dispatcher was registered without, from the configured defaults
+func (bm *batchManager) applyDefaultOptions(options *DispatcherOptions) {
+	if options.BatchTimeout == 0 {
+		options.BatchTimeout = bm.defaultOptions.BatchTimeout
+	}
+	if options.DisposeTimeout == 0 {
+		options.DisposeTimeout = bm.defaultOptions.DisposeTimeout
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly/internal/coreconfig"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/mocks/identitymanagermocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBatchManagerFromConfigDefaults(t *testing.T) {
+	testConfigReset()
+	config.Set(coreconfig.BatchManagerReadPageSize, 50)
+	config.Set(coreconfig.BatchManagerReadPollTimeout, "10s")
+	config.Set(coreconfig.BatchRetryFactor, 3.0)
+	conf := config.RootSection("ut.batch.defaults")
+	InitConfig(conf)
+
+	m, err := NewBatchManagerFromConfig(context.Background(), "ns1", conf, &databasemocks.Plugin{}, &datamocks.Manager{}, &identitymanagermocks.Manager{}, nil)
+	assert.NoError(t, err)
+	bm := m.(*batchManager)
+	defer bm.cancelCtx()
+
+	// Keys not set in the section are read from the batch.manager and batch.retry config
+	assert.Equal(t, uint64(50), bm.readPageSize)
+	assert.Equal(t, 50, cap(bm.newMessages))
+	assert.Equal(t, 10*time.Second, bm.messagePollTimeout)
+	assert.Equal(t, 0.1, bm.messagePollJitter)
+	assert.Equal(t, time.Duration(0), bm.minimumPollDelay)
+	assert.Equal(t, 250*time.Millisecond, bm.retry.InitialDelay)
+	assert.Equal(t, 30*time.Second, bm.retry.MaximumDelay)
+	assert.Equal(t, 3.0, bm.retry.Factor)
+	assert.Equal(t, DispatcherOptions{}, bm.defaultOptions)
+	assert.Equal(t, "ff_batch_ns1", bm.offsetName)
+}
+
+func TestNewBatchManagerFromConfig(t *testing.T) {
+	testConfigReset()
+	conf := config.RootSection("ut.batch.custom")
+	InitConfig(conf)
+	conf.Set(ConfigReadPageSize, 10)
+	conf.Set(ConfigPollTimeout, "5s")
+	conf.Set(ConfigPollJitter, 0.25)
+	conf.Set(ConfigMinimumPollDelay, "10ms")
+	conf.Set(ConfigOffsetName, "workload1")
+	retryConf := conf.SubSection(ConfigRetryKey)
+	retryConf.Set(ConfigRetryInitDelay, "1ms")
+	retryConf.Set(ConfigRetryMaxDelay, "2s")
+	retryConf.Set(ConfigRetryFactor, 1.5)
+	dispatcherConf := conf.SubSection(ConfigDispatcherKey)
+	dispatcherConf.Set(ConfigDispatcherBatchTimeout, "250ms")
+	dispatcherConf.Set(ConfigDispatcherDisposeTimeout, "1h")
+
+	m, err := NewBatchManagerFromConfig(context.Background(), "ns1", conf, &databasemocks.Plugin{}, &datamocks.Manager{}, &identitymanagermocks.Manager{}, nil)
+	assert.NoError(t, err)
+	bm := m.(*batchManager)
+	defer bm.cancelCtx()
+
+	assert.Equal(t, uint64(10), bm.readPageSize)
+	assert.Equal(t, 10, cap(bm.newMessages))
+	assert.Equal(t, 5*time.Second, bm.messagePollTimeout)
+	assert.Equal(t, 0.25, bm.messagePollJitter)
+	assert.Equal(t, 10*time.Millisecond, bm.minimumPollDelay)
+	assert.Equal(t, time.Millisecond, bm.retry.InitialDelay)
+	assert.Equal(t, 2*time.Second, bm.retry.MaximumDelay)
+	assert.Equal(t, 1.5, bm.retry.Factor)
+	assert.Equal(t, "workload1", bm.offsetName)
+
+	// Dispatchers registered without a batch or dispose timeout get the defaults, and others keep their own
+	err = bm.RegisterDispatcher("utdefaults", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast}, nil, DispatcherOptions{BatchMaxSize: 1})
+	assert.NoError(t, err)
+	err = bm.RegisterDispatcher("utexplicit", core.TransactionTypeNone, []core.MessageType{core.MessageTypePrivate}, nil, DispatcherOptions{BatchMaxSize: 1, BatchTimeout: time.Second, DisposeTimeout: time.Minute})
+	assert.NoError(t, err)
+	assert.Equal(t, 250*time.Millisecond, bm.allDispatchers[0].options.BatchTimeout)
+	assert.Equal(t, time.Hour, bm.allDispatchers[0].options.DisposeTimeout)
+	assert.Equal(t, time.Second, bm.allDispatchers[1].options.BatchTimeout)
+	assert.Equal(t, time.Minute, bm.allDispatchers[1].options.DisposeTimeout)
+}
+
+func TestNewBatchManagerFromConfigFail(t *testing.T) {
+	conf := config.RootSection("ut.batch.fail")
+	InitConfig(conf)
+	_, err := NewBatchManagerFromConfig(context.Background(), "ns1", conf, nil, nil, nil, nil)
+	assert.Regexp(t, "FF10128", err)
+}
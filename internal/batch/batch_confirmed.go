@@ -0,0 +1,50 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// skipIfConfirmedElsewhere consults the ConfirmedElsewhere precheck of the message's dispatcher, and returns true
+// if the message should not be assembled here. A message already handled by another node is skipped, so the offset
+// advances past it, and is marked confirmed so it is not read again. If the precheck fails, the message is deferred
+// and checked again after the read poll timeout, rather than risking a duplicate dispatch.
+func (bm *batchManager) skipIfConfirmedElsewhere(msg *core.Message) bool {
+	dispatcherKey := bm.getDispatcherKey(msg.Header.TxType, msg.Header.Type)
+	bm.dispatcherMux.Lock()
+	d, ok := bm.dispatcherMap[dispatcherKey]
+	bm.dispatcherMux.Unlock()
+	if !ok || d.options.ConfirmedElsewhere == nil {
+		return false
+	}
+
+	confirmed, err := d.options.ConfirmedElsewhere(bm.ctx, msg)
+	switch {
+	case err != nil:
+		log.L(bm.ctx).Errorf("Failed to check if message %s (seq=%d) is confirmed elsewhere - deferring for %s: %s", msg.Header.ID, msg.Sequence, bm.messagePollTimeout, err)
+		bm.deferForRecheck(msg.Sequence, dispatcherKey, bm.messagePollTimeout)
+		return true
+	case confirmed:
+		log.L(bm.ctx).Infof("Skipping message %s (seq=%d) already confirmed elsewhere", msg.Header.ID, msg.Sequence)
+		bm.finalizeSkipped(msg, core.MessageStateConfirmed)
+		return true
+	default:
+		return false
+	}
+}
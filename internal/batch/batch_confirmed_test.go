@@ -0,0 +1,115 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestConfirmedElsewhereSkipped(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+
+	// Another node has already dispatched the first message
+	msg1 := newTestBroadcastMessage(1001)
+	msg2 := newTestBroadcastMessage(1002)
+	dispatched := make(chan *DispatchState, 2)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:   1,
+			DisposeTimeout: 120 * time.Second,
+			ConfirmedElsewhere: func(ctx context.Context, msg *core.Message) (bool, error) {
+				return msg.Header.ID.Equals(msg1.Header.ID), nil
+			},
+		},
+	)
+	mockMessagePage(mdi, mdm, msg1, msg2)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	state := <-dispatched
+	assert.Equal(t, msg2.Header.ID, state.Messages[0].Header.ID)
+	select {
+	case <-dispatched:
+		assert.Fail(t, "confirmed message dispatched")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The offset advances past the skipped message
+	assert.Eventually(t, func() bool {
+		bm.inflightMux.Lock()
+		defer bm.inflightMux.Unlock()
+		return bm.calcCommittableOffset() == 1002
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	bm.WaitStop()
+
+	// The skipped message is marked confirmed, rather than left to be read again
+	assert.Equal(t, core.MessageStateConfirmed, msg1.State)
+	mdi.AssertCalled(t, "UpdateMessages", mock.Anything, "ns1", mock.MatchedBy(func(f database.Filter) bool {
+		fi, _ := f.Finalize()
+		return fi.String() == fmt.Sprintf("( id == '%s' ) && ( state IN ['ready'] )", msg1.Header.ID)
+	}), mock.MatchedBy(func(update database.Update) bool {
+		ui, _ := update.Finalize()
+		v, _ := ui.SetOperations[0].Value.Value()
+		return v == "confirmed"
+	}))
+}
+
+func TestConfirmedElsewhereCheckFail(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{
+			BatchMaxSize: 1,
+			ConfirmedElsewhere: func(ctx context.Context, msg *core.Message) (bool, error) {
+				return false, fmt.Errorf("pop")
+			},
+		},
+	)
+
+	assert.True(t, bm.skipIfConfirmedElsewhere(newTestBroadcastMessage(1001)))
+	assert.Equal(t, bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast), bm.deferredSequences[1001])
+}
+
+func TestConfirmedElsewhereNotSet(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 1},
+	)
+
+	assert.False(t, bm.skipIfConfirmedElsewhere(newTestBroadcastMessage(1001)))
+}
@@ -0,0 +1,59 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"math"
+
+	"github.com/hyperledger/firefly/internal/data"
+)
+
+// DataCacheStatus reports how effective the data cache used while assembling each page of messages has been
+type DataCacheStatus struct {
+	Hits     int64   `ffstruct:"BatchDataCacheStatus" json:"hits"`
+	Misses   int64   `ffstruct:"BatchDataCacheStatus" json:"misses"`
+	HitRatio float64 `ffstruct:"BatchDataCacheStatus" json:"hitRatio"`
+}
+
+// assemblyContext returns the context for looking up the data of messages, which uses the page data cache if enabled
+func (bm *batchManager) assemblyContext(ctx context.Context) context.Context {
+	if bm.dataCache == nil {
+		return ctx
+	}
+	return data.WithDataLookupCache(ctx, bm.dataCache)
+}
+
+// clearDataCache is called before each page is prepared, so the cache only shares data between messages in the same page -
+// unless the cache has a TTL, in which case it is retained across pages
+func (bm *batchManager) clearDataCache() {
+	if bm.dataCache != nil && !bm.dataCache.Retained() {
+		bm.dataCache.Clear()
+	}
+}
+
+func (bm *batchManager) dataCacheStatus() *DataCacheStatus {
+	if bm.dataCache == nil {
+		return nil
+	}
+	hits, misses := bm.dataCache.Stats()
+	status := &DataCacheStatus{Hits: hits, Misses: misses}
+	if hits+misses > 0 {
+		status.HitRatio = math.Round(float64(hits)/float64(hits+misses)*100) / 100
+	}
+	return status
+}
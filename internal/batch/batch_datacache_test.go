@@ -0,0 +1,118 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/internal/cache"
+	"github.com/hyperledger/firefly/internal/coreconfig"
+	"github.com/hyperledger/firefly/internal/data"
+	"github.com/hyperledger/firefly/mocks/cachemocks"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/dataexchangemocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDataCacheSharesDataWithinPage(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("Capabilities").Return(&database.Capabilities{Concurrency: true})
+	cmi := &cachemocks.Manager{}
+	cmi.On("GetCache", mock.Anything).Return(cache.NewUmanagedCache(context.Background(), 100, 5*time.Minute), nil)
+	dm, err := data.NewDataManager(context.Background(), &core.Namespace{Name: "ns1"}, mdi, &dataexchangemocks.Plugin{}, cmi)
+	assert.NoError(t, err)
+	bm.data = dm
+
+	// Every message in the page references the same data
+	d := &core.Data{ID: fftypes.NewUUID(), Hash: fftypes.NewRandB32()}
+	entries := make([]*core.IDAndSequence, 3)
+	for i := range entries {
+		msg := newTestBroadcastMessage(int64(1001 + i))
+		msg.Data = core.DataRefs{{ID: d.ID, Hash: d.Hash}}
+		mdi.On("GetMessageByID", mock.Anything, "ns1", msg.Header.ID).Return(msg, nil)
+		entries[i] = &core.IDAndSequence{ID: *msg.Header.ID, Sequence: msg.Sequence}
+	}
+	mdi.On("GetDataByID", mock.Anything, "ns1", d.ID, true).Return(d, nil).Twice()
+
+	bm.preparePage(bm.ctx, entries[:2], 1000, time.Time{})
+	assert.Equal(t, &DataCacheStatus{Hits: 1, Misses: 1, HitRatio: 0.5}, bm.dataCacheStatus())
+
+	// The cache is cleared between pages
+	bm.preparePage(bm.ctx, entries[2:], 1002, time.Time{})
+	assert.Equal(t, &DataCacheStatus{Hits: 1, Misses: 2, HitRatio: 0.33}, bm.dataCacheStatus())
+	mdi.AssertExpectations(t)
+}
+
+func TestDataCacheDisabled(t *testing.T) {
+	testConfigReset()
+	defer coreconfig.Reset()
+	config.Set(coreconfig.BatchManagerDataCacheMaxEntries, 0)
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	assert.Nil(t, bm.dataCache)
+	assert.Equal(t, bm.ctx, bm.assemblyContext(bm.ctx))
+	assert.Nil(t, bm.dataCacheStatus())
+	bm.clearDataCache()
+}
+
+func TestDataCacheStatusNoLookups(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	assert.Equal(t, &DataCacheStatus{}, bm.dataCacheStatus())
+}
+
+func TestDataCacheRetainedAcrossPagesWithTTL(t *testing.T) {
+	testConfigReset()
+	defer coreconfig.Reset()
+	config.Set(coreconfig.BatchManagerDataCacheTTL, "1m")
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("Capabilities").Return(&database.Capabilities{Concurrency: true})
+	cmi := &cachemocks.Manager{}
+	cmi.On("GetCache", mock.Anything).Return(cache.NewUmanagedCache(context.Background(), 100, 5*time.Minute), nil)
+	dm, err := data.NewDataManager(context.Background(), &core.Namespace{Name: "ns1"}, mdi, &dataexchangemocks.Plugin{}, cmi)
+	assert.NoError(t, err)
+	bm.data = dm
+
+	// Consecutive pages reference the same data, which is only read once
+	d := &core.Data{ID: fftypes.NewUUID(), Hash: fftypes.NewRandB32()}
+	entries := make([]*core.IDAndSequence, 2)
+	for i := range entries {
+		msg := newTestBroadcastMessage(int64(1001 + i))
+		msg.Data = core.DataRefs{{ID: d.ID, Hash: d.Hash}}
+		mdi.On("GetMessageByID", mock.Anything, "ns1", msg.Header.ID).Return(msg, nil)
+		entries[i] = &core.IDAndSequence{ID: *msg.Header.ID, Sequence: msg.Sequence}
+	}
+	mdi.On("GetDataByID", mock.Anything, "ns1", d.ID, true).Return(d, nil).Once()
+
+	bm.preparePage(bm.ctx, entries[:1], 1000, time.Time{})
+	bm.preparePage(bm.ctx, entries[1:], 1001, time.Time{})
+	assert.Equal(t, &DataCacheStatus{Hits: 1, Misses: 1, HitRatio: 0.5}, bm.dataCacheStatus())
+	mdi.AssertExpectations(t)
+}
@@ -0,0 +1,91 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+)
+
+// DataTimingStatus reports the time taken to retrieve messages with their data during assembly, to diagnose whether
+// data resolution is the cause of slow assembly
+type DataTimingStatus struct {
+	LastPage *DataTiming `ffstruct:"BatchDataTimingStatus" json:"lastPage"`
+	Total    *DataTiming `ffstruct:"BatchDataTimingStatus" json:"total"`
+}
+
+type DataTiming struct {
+	Count     int64              `ffstruct:"BatchDataTiming" json:"count"`
+	TotalTime fftypes.FFDuration `ffstruct:"BatchDataTiming" json:"totalTime"`
+	MaxTime   fftypes.FFDuration `ffstruct:"BatchDataTiming" json:"maxTime"`
+}
+
+func (dt *DataTiming) add(elapsed time.Duration) {
+	dt.Count++
+	dt.TotalTime += fftypes.FFDuration(elapsed)
+	if fftypes.FFDuration(elapsed) > dt.MaxTime {
+		dt.MaxTime = fftypes.FFDuration(elapsed)
+	}
+}
+
+// startDataTiming returns the time a retrieval of message data started, or the zero time if timing is disabled
+func (bm *batchManager) startDataTiming() time.Time {
+	if !bm.dataTiming {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// recordDataTiming adds a retrieval of message data to the timings of the page being assembled
+func (bm *batchManager) recordDataTiming(started time.Time) {
+	if started.IsZero() {
+		return
+	}
+	elapsed := time.Since(started)
+	bm.dataTimingMux.Lock()
+	defer bm.dataTimingMux.Unlock()
+	bm.dataTimingPage.add(elapsed)
+}
+
+// completeDataTimingPage is called once a page has been prepared, making its timings those of the last page
+func (bm *batchManager) completeDataTimingPage() {
+	if !bm.dataTiming {
+		return
+	}
+	bm.dataTimingMux.Lock()
+	defer bm.dataTimingMux.Unlock()
+	page := bm.dataTimingPage
+	bm.dataTimingLastPage = page
+	bm.dataTimingTotal.Count += page.Count
+	bm.dataTimingTotal.TotalTime += page.TotalTime
+	if page.MaxTime > bm.dataTimingTotal.MaxTime {
+		bm.dataTimingTotal.MaxTime = page.MaxTime
+	}
+	bm.dataTimingPage = DataTiming{}
+}
+
+func (bm *batchManager) dataTimingStatus() *DataTimingStatus {
+	if !bm.dataTiming {
+		return nil
+	}
+	bm.dataTimingMux.Lock()
+	defer bm.dataTimingMux.Unlock()
+	lastPage := bm.dataTimingLastPage
+	total := bm.dataTimingTotal
+	return &DataTimingStatus{LastPage: &lastPage, Total: &total}
+}
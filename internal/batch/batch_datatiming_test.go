@@ -0,0 +1,95 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/internal/coreconfig"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDataTimingAdd(t *testing.T) {
+	dt := &DataTiming{}
+	dt.add(2 * time.Millisecond)
+	dt.add(5 * time.Millisecond)
+	dt.add(1 * time.Millisecond)
+	assert.Equal(t, int64(3), dt.Count)
+	assert.Equal(t, fftypes.FFDuration(8*time.Millisecond), dt.TotalTime)
+	assert.Equal(t, fftypes.FFDuration(5*time.Millisecond), dt.MaxTime)
+}
+
+func TestDataTimingDisabled(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	assert.False(t, bm.dataTiming)
+	assert.True(t, bm.startDataTiming().IsZero())
+	bm.recordDataTiming(time.Time{})
+	bm.completeDataTimingPage()
+	assert.Nil(t, bm.dataTimingStatus())
+}
+
+func TestDataTimingConfig(t *testing.T) {
+	testConfigReset()
+	defer coreconfig.Reset()
+	config.Set(coreconfig.BatchManagerDataTimingEnabled, true)
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	assert.True(t, bm.dataTiming)
+}
+
+func TestDataTimingPerPage(t *testing.T) {
+	bm, _, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.dataTiming = true
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 10, BatchMaxBytes: 1024 * 1024, BatchTimeout: time.Hour, DisposeTimeout: time.Hour},
+	)
+
+	preparePage := func(delay time.Duration, msgs ...*core.Message) {
+		entries := make([]*core.IDAndSequence, len(msgs))
+		for i, msg := range msgs {
+			entries[i] = &core.IDAndSequence{ID: *msg.Header.ID, Sequence: msg.Sequence}
+			mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil).After(delay)
+		}
+		pending, prepared := bm.preparePage(bm.ctx, entries, 1000, time.Time{})
+		assert.Len(t, pending, len(msgs))
+		assert.Equal(t, len(msgs), prepared)
+	}
+
+	// Each page replaces the timings of the last page, and is added to the totals
+	preparePage(10*time.Millisecond, newTestBroadcastMessage(1001), newTestBroadcastMessage(1002))
+	status := bm.dataTimingStatus()
+	assert.Equal(t, int64(2), status.LastPage.Count)
+	assert.GreaterOrEqual(t, status.LastPage.TotalTime, fftypes.FFDuration(20*time.Millisecond))
+	assert.GreaterOrEqual(t, status.LastPage.MaxTime, fftypes.FFDuration(10*time.Millisecond))
+	assert.Equal(t, *status.LastPage, *status.Total)
+
+	preparePage(0, newTestBroadcastMessage(1003))
+	status = bm.dataTimingStatus()
+	assert.Equal(t, int64(1), status.LastPage.Count)
+	assert.Less(t, status.LastPage.MaxTime, fftypes.FFDuration(10*time.Millisecond))
+	assert.Equal(t, int64(3), status.Total.Count)
+	assert.GreaterOrEqual(t, status.Total.MaxTime, fftypes.FFDuration(10*time.Millisecond))
+}
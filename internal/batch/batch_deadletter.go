@@ -0,0 +1,88 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+)
+
+// dispatchAttemptsExhausted returns true if no further attempt should be made to dispatch a batch
+func (bp *batchProcessor) dispatchAttemptsExhausted(attempt int) bool {
+	return bp.conf.MaxDispatchAttempts > 0 && attempt >= bp.conf.MaxDispatchAttempts
+}
+
+// deadLetterBatch hands a batch that exhausted its dispatch attempts to the dead-letter callback and the quarantine
+// store, then marks its messages failed. Each step is retried until it succeeds, so that the flush only completes -
+// allowing the offset to move past the messages - once the batch has been accepted by the callback and the store.
+func (bp *batchProcessor) deadLetterBatch(state *DispatchState, dispatchErr error) error {
+	id := state.Persisted.ID
+	log.L(bp.ctx).Errorf("Batch %s failed after %d dispatch attempts: %s", id, bp.conf.MaxDispatchAttempts, dispatchErr)
+
+	batch := state.Persisted.GenInflight(state.Messages, state.Data)
+	if bp.conf.DeadLetter != nil {
+		err := bp.retry.Do(bp.ctx, "dead-letter batch", func(attempt int) (retry bool, err error) {
+			return true, bp.conf.DeadLetter(bp.ctx, batch, dispatchErr)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err := bp.retry.Do(bp.ctx, "quarantine batch", func(attempt int) (retry bool, err error) {
+		return true, bp.quarantineBatch(batch, dispatchErr)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = bp.retry.Do(bp.ctx, "mark failed messages", func(attempt int) (retry bool, err error) {
+		err = bp.bm.runAsGroup(bp.ctx, func(ctx context.Context) error {
+			return bp.markMessagesFailed(ctx, state)
+		})
+		return bp.bm.isRetryable(err), err
+	})
+	if err != nil {
+		return err
+	}
+	for _, msg := range state.Messages {
+		msg.BatchID = id
+		msg.State = core.MessageStateFailed
+		bp.data.UpdateMessageIfCached(bp.ctx, msg)
+	}
+	log.L(bp.ctx).Infof("Dead-lettered batch %s", id)
+	return nil
+}
+
+func (bp *batchProcessor) markMessagesFailed(ctx context.Context, state *DispatchState) error {
+	msgIDs := make([]driver.Value, len(state.Messages))
+	for i, msg := range state.Messages {
+		msgIDs[i] = msg.Header.ID
+	}
+	fb := database.MessageQueryFactory.NewFilter(ctx)
+	filter := fb.And(
+		fb.In("id", msgIDs),
+		fb.In("state", []driver.Value{core.MessageStateReady, core.MessageStateDeferred, core.MessageStateAssembled, core.MessageStateBatching}),
+	)
+	update := database.MessageQueryFactory.NewUpdate(ctx).
+		Set("batch", state.Persisted.ID).
+		Set("state", core.MessageStateFailed)
+	return bp.database.UpdateMessages(ctx, bp.bm.namespace, filter, update)
+}
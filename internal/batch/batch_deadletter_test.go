@@ -0,0 +1,127 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestDeadLetterState() *DispatchState {
+	state := &DispatchState{
+		Persisted: core.BatchPersisted{
+			BatchHeader: core.BatchHeader{ID: fftypes.NewUUID()},
+		},
+	}
+	state.Messages = []*core.Message{newTestBroadcastMessage(1001), newTestBroadcastMessage(1002)}
+	return state
+}
+
+func TestDeadLetterAfterMaxDispatchAttempts(t *testing.T) {
+	dispatches := 0
+	cancel, mdi, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		dispatches++
+		return fmt.Errorf("pop")
+	})
+	defer cancel()
+	var deadLettered *core.Batch
+	bp.conf.MaxDispatchAttempts = 2
+	bp.conf.DeadLetter = func(ctx context.Context, batch *core.Batch, err error) error {
+		deadLettered = batch
+		assert.Regexp(t, "pop", err)
+		return nil
+	}
+	mockRunAsGroupPassthrough(mdi)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+	mdm := bp.data.(*datamocks.Manager)
+	mdm.On("UpdateMessageIfCached", mock.Anything, mock.MatchedBy(func(msg *core.Message) bool {
+		return msg.State == core.MessageStateFailed
+	})).Return()
+
+	state := newTestDeadLetterState()
+	err := bp.dispatchAndFinalize(state)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, dispatches)
+	assert.Equal(t, state.Persisted.ID, deadLettered.ID)
+	assert.Len(t, deadLettered.Payload.Messages, 2)
+	mdi.AssertNumberOfCalls(t, "UpdateMessages", 1)
+	mdm.AssertNumberOfCalls(t, "UpdateMessageIfCached", 2)
+	for _, msg := range state.Messages {
+		assert.Equal(t, core.MessageStateFailed, msg.State)
+	}
+}
+
+func TestDeadLetterRetriedUntilAccepted(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		return fmt.Errorf("pop")
+	})
+	defer cancel()
+	deadLetters := 0
+	bp.conf.MaxDispatchAttempts = 1
+	bp.conf.DeadLetter = func(ctx context.Context, batch *core.Batch, err error) error {
+		deadLetters++
+		if deadLetters == 1 {
+			return fmt.Errorf("dead-letter queue unavailable")
+		}
+		return nil
+	}
+	mockRunAsGroupPassthrough(mdi)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(fmt.Errorf("pop")).Once()
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+	bp.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+
+	err := bp.dispatchAndFinalize(newTestDeadLetterState())
+	assert.NoError(t, err)
+
+	// The messages are not marked failed until the callback has accepted the batch
+	assert.Equal(t, 2, deadLetters)
+	mdi.AssertNumberOfCalls(t, "UpdateMessages", 2)
+}
+
+func TestDeadLetterNotUsedOnClose(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		return fmt.Errorf("pop")
+	})
+	bp.conf.MaxDispatchAttempts = 1
+	bp.conf.DeadLetter = func(ctx context.Context, batch *core.Batch, err error) error {
+		t.Fatal("unexpected dead-letter")
+		return nil
+	}
+	cancel()
+	bp.cancelCtx()
+
+	err := bp.dispatchAndFinalize(newTestDeadLetterState())
+	assert.Regexp(t, "pop", err)
+	mdi.AssertNotCalled(t, "UpdateMessages", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDispatchAttemptsExhausted(t *testing.T) {
+	cancel, _, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	assert.False(t, bp.dispatchAttemptsExhausted(100))
+	bp.conf.MaxDispatchAttempts = 3
+	assert.False(t, bp.dispatchAttemptsExhausted(2))
+	assert.True(t, bp.dispatchAttemptsExhausted(3))
+}
@@ -0,0 +1,83 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+type idempotencyEntry struct {
+	key   string
+	msgID *fftypes.UUID
+	seen  time.Time
+}
+
+// idempotencyCache records the idempotency keys seen by the sequencer for one dispatcher within its window, in the
+// order seen, so that expired keys can be pruned from the front
+type idempotencyCache struct {
+	seen  map[string]*idempotencyEntry
+	order []*idempotencyEntry
+}
+
+func (ic *idempotencyCache) prune(window time.Duration) {
+	cutoff := time.Now().Add(-window)
+	for len(ic.order) > 0 && ic.order[0].seen.Before(cutoff) {
+		delete(ic.seen, ic.order[0].key)
+		ic.order = ic.order[1:]
+	}
+}
+
+// isDuplicate returns true if a different message with the same idempotency key has already been read for
+// dispatch within the IdempotencyWindow of the message's dispatcher. Reading the same message again, such as
+// after a rewind, is not a duplicate. A duplicate is moved to the duplicate state, so it is not read again.
+// Only the sequencer calls this, so no locking is required.
+func (bm *batchManager) isDuplicate(msg *core.Message) bool {
+	bm.dispatcherMux.Lock()
+	d, ok := bm.dispatcherMap[bm.getDispatcherKey(msg.Header.TxType, msg.Header.Type)]
+	bm.dispatcherMux.Unlock()
+	if !ok || d.options.IdempotencyKey == nil || d.options.IdempotencyWindow <= 0 {
+		return false
+	}
+	key := d.options.IdempotencyKey(msg)
+	if key == "" {
+		return false
+	}
+
+	// Each dispatcher has a cache of its own, so its keys are only pruned by its own window
+	ic, ok := bm.idempotency[d.name]
+	if !ok {
+		ic = &idempotencyCache{seen: make(map[string]*idempotencyEntry)}
+		bm.idempotency[d.name] = ic
+	}
+	ic.prune(d.options.IdempotencyWindow)
+	if existing, ok := ic.seen[key]; ok {
+		if existing.msgID.Equals(msg.Header.ID) {
+			return false
+		}
+		log.L(bm.ctx).Infof("Skipping message %s (seq=%d) with the same idempotency key '%s' as message %s", msg.Header.ID, msg.Sequence, key, existing.msgID)
+		bm.finalizeSkipped(msg, core.MessageStateDuplicate)
+		return true
+	}
+	entry := &idempotencyEntry{key: key, msgID: msg.Header.ID, seen: time.Now()}
+	ic.seen[key] = entry
+	ic.order = append(ic.order, entry)
+	return false
+}
@@ -0,0 +1,152 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func tagIdempotencyKey(msg *core.Message) string {
+	return msg.Header.Tag
+}
+
+func TestDuplicateIdempotencyKeyDispatchedOnce(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	dispatched := make(chan *DispatchState, 2)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:      1,
+			DisposeTimeout:    120 * time.Second,
+			IdempotencyKey:    tagIdempotencyKey,
+			IdempotencyWindow: time.Minute,
+		},
+	)
+
+	// The producer re-submitted the same logical message
+	msg1 := newTestBroadcastMessage(1001)
+	msg1.Header.Tag = "order-12345"
+	msg2 := newTestBroadcastMessage(1002)
+	msg2.Header.Tag = "order-12345"
+	mockMessagePage(mdi, mdm, msg1, msg2)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	state := <-dispatched
+	assert.Equal(t, msg1.Header.ID, state.Messages[0].Header.ID)
+	select {
+	case <-dispatched:
+		assert.Fail(t, "duplicate dispatched")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	bm.WaitStop()
+
+	// The duplicate is moved out of ready, rather than left to be read again
+	assert.Equal(t, core.MessageStateDuplicate, msg2.State)
+	mdi.AssertCalled(t, "UpdateMessages", mock.Anything, "ns1", mock.MatchedBy(func(f database.Filter) bool {
+		fi, _ := f.Finalize()
+		return fi.String() == fmt.Sprintf("( id == '%s' ) && ( state IN ['ready'] )", msg2.Header.ID)
+	}), mock.MatchedBy(func(update database.Update) bool {
+		ui, _ := update.Finalize()
+		v, _ := ui.SetOperations[0].Value.Value()
+		return v == "duplicate"
+	}))
+}
+
+func TestIsDuplicateWindow(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.database.(*databasemocks.Plugin).On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+	bm.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 1, IdempotencyKey: tagIdempotencyKey, IdempotencyWindow: 10 * time.Millisecond},
+	)
+
+	msg1 := newTestBroadcastMessage(1001)
+	msg1.Header.Tag = "key1"
+	msg2 := newTestBroadcastMessage(1002)
+	msg2.Header.Tag = "key1"
+	assert.False(t, bm.isDuplicate(msg1))
+	assert.False(t, bm.isDuplicate(msg1)) // re-read of the same message
+	assert.True(t, bm.isDuplicate(msg2))
+
+	// Messages without a key are never duplicates
+	msg3 := newTestBroadcastMessage(1003)
+	assert.False(t, bm.isDuplicate(msg3))
+	assert.False(t, bm.isDuplicate(msg3))
+
+	// Once the window has passed, the key is pruned
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, bm.isDuplicate(msg2))
+	assert.Len(t, bm.idempotency["utdispatcher"].order, 1)
+	assert.Len(t, bm.idempotency["utdispatcher"].seen, 1)
+
+	// Unregistered dispatchers do not dedup
+	msg4 := newTestBroadcastMessage(1004)
+	msg4.Header.TxType = core.TransactionTypeUnpinned
+	assert.False(t, bm.isDuplicate(msg4))
+}
+
+func TestIsDuplicateWindowPerDispatcher(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.database.(*databasemocks.Plugin).On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+	bm.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+	bm.RegisterDispatcher("short", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 1, IdempotencyKey: tagIdempotencyKey, IdempotencyWindow: 10 * time.Millisecond},
+	)
+	bm.RegisterDispatcher("long", core.TransactionTypeUnpinned, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 1, IdempotencyKey: tagIdempotencyKey, IdempotencyWindow: time.Minute},
+	)
+
+	newKeyedMessage := func(seq int64, txType core.TransactionType) *core.Message {
+		msg := newTestBroadcastMessage(seq)
+		msg.Header.TxType = txType
+		msg.Header.Tag = "key1"
+		return msg
+	}
+	assert.False(t, bm.isDuplicate(newKeyedMessage(1001, core.TransactionTypeUnpinned)))
+	assert.False(t, bm.isDuplicate(newKeyedMessage(1002, core.TransactionTypeBatchPin)))
+
+	// Pruning by the short window of one dispatcher does not expire the keys of the other
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, bm.isDuplicate(newKeyedMessage(1003, core.TransactionTypeBatchPin)))
+	assert.True(t, bm.isDuplicate(newKeyedMessage(1004, core.TransactionTypeUnpinned)))
+	assert.Len(t, bm.idempotency["long"].seen, 1)
+}
@@ -0,0 +1,38 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// isDeletedMessage returns true for a soft-deleted message - a tombstone left in the messages table
+func isDeletedMessage(msg *core.Message) bool {
+	return msg != nil && msg.State == core.MessageStateDeleted
+}
+
+// skipIfDeleted returns true if the message has been soft-deleted since its ID was read. The tombstone is skipped
+// from batching, but still counts as read - so the offset advances past it, rather than the sequencer stalling on
+// a message whose data might no longer be valid.
+func (bm *batchManager) skipIfDeleted(msg *core.Message) bool {
+	if !isDeletedMessage(msg) {
+		return false
+	}
+	log.L(bm.ctx).Infof("Skipping soft-deleted message %s (seq=%d)", msg.Header.ID, msg.Sequence)
+	return true
+}
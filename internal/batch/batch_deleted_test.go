@@ -0,0 +1,94 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDeletedMessagesSkipped(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+
+	dispatched := make(chan *DispatchState, 4)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:   1,
+			DisposeTimeout: 120 * time.Second,
+		},
+	)
+
+	// Live and soft-deleted messages are interleaved in the page, and the data of the deleted messages is gone
+	msgs := make([]*core.Message, 4)
+	entries := make([]*core.IDAndSequence, len(msgs))
+	for i := range msgs {
+		msgs[i] = newTestBroadcastMessage(int64(1001 + i))
+		entries[i] = &core.IDAndSequence{ID: *msgs[i].Header.ID, Sequence: msgs[i].Sequence}
+		if i%2 == 0 {
+			msgs[i].State = core.MessageStateReady
+			mdm.On("GetMessageWithDataCached", mock.Anything, msgs[i].Header.ID).Return(msgs[i], core.DataArray{}, true, nil)
+		} else {
+			msgs[i].State = core.MessageStateDeleted
+			mdm.On("GetMessageWithDataCached", mock.Anything, msgs[i].Header.ID).Return(msgs[i], nil, false, nil)
+		}
+	}
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(entries, nil).Once()
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	assert.Equal(t, msgs[0].Header.ID, (<-dispatched).Messages[0].Header.ID)
+	assert.Equal(t, msgs[2].Header.ID, (<-dispatched).Messages[0].Header.ID)
+	select {
+	case <-dispatched:
+		assert.Fail(t, "deleted message dispatched")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The offset advances past the trailing deleted message, without any assembly failure recorded
+	assert.Eventually(t, func() bool {
+		bm.inflightMux.Lock()
+		defer bm.inflightMux.Unlock()
+		return bm.calcCommittableOffset() == 1004
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	bm.WaitStop()
+	assert.Empty(t, bm.assemblyFailures)
+}
+
+func TestSkipIfDeleted(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	msg := newTestBroadcastMessage(1001)
+	assert.False(t, bm.skipIfDeleted(msg))
+	msg.State = core.MessageStateDeleted
+	assert.True(t, bm.skipIfDeleted(msg))
+	assert.False(t, isDeletedMessage(nil))
+}
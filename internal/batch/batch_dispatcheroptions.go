@@ -0,0 +1,178 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// persistedDispatcherOptions are the options of a dispatcher that determine the characteristics of its batches, in the
+// form they are persisted. Callbacks cannot be persisted, so only whether each is set is recorded. Every field of
+// DispatcherOptions must be listed here or in the callbacks, unless it is an observer hook that does not affect the
+// batches - TestPersistedDispatcherOptionsComplete enforces this.
+type persistedDispatcherOptions struct {
+	TxType               core.TransactionType    `json:"txType"`
+	MessageTypes         []core.MessageType      `json:"messageTypes"`
+	BatchType            core.BatchType          `json:"batchType,omitempty"`
+	BatchMaxSize         uint                    `json:"batchMaxSize,omitempty"`
+	BatchMaxBytes        int64                   `json:"batchMaxBytes,omitempty"`
+	BatchTimeout         fftypes.FFDuration      `json:"batchTimeout,omitempty"`
+	DisposeTimeout       fftypes.FFDuration      `json:"disposeTimeout,omitempty"`
+	BatchMaxAge          fftypes.FFDuration      `json:"batchMaxAge,omitempty"`
+	BatchMinSize         uint                    `json:"batchMinSize,omitempty"`
+	StallThreshold       fftypes.FFDuration      `json:"stallThreshold,omitempty"`
+	SizeClasses          []int64                 `json:"sizeClasses,omitempty"`
+	IncludeProvenance    bool                    `json:"includeProvenance,omitempty"`
+	SlowDownPageSize     uint64                  `json:"slowDownPageSize,omitempty"`
+	SlowDownPollDelay    fftypes.FFDuration      `json:"slowDownPollDelay,omitempty"`
+	ReadPageSize         uint64                  `json:"readPageSize,omitempty"`
+	CorrelateBatch       bool                    `json:"correlateBatch,omitempty"`
+	MinMessageDwell      fftypes.FFDuration      `json:"minMessageDwell,omitempty"`
+	Outbox               bool                    `json:"outbox,omitempty"`
+	IdempotencyWindow    fftypes.FFDuration      `json:"idempotencyWindow,omitempty"`
+	ReadinessRecheck     fftypes.FFDuration      `json:"readinessRecheck,omitempty"`
+	VerifyReadBack       bool                    `json:"verifyReadBack,omitempty"`
+	MaxDispatchAttempts  int                     `json:"maxDispatchAttempts,omitempty"`
+	DispatchTimeout      fftypes.FFDuration      `json:"dispatchTimeout,omitempty"`
+	DispatchRetry        *persistedDispatchRetry `json:"dispatchRetry,omitempty"`
+	DispatchConcurrency  int                     `json:"dispatchConcurrency,omitempty"`
+	CloneBatch           bool                    `json:"cloneBatch,omitempty"`
+	ConcurrentHandlers   bool                    `json:"concurrentHandlers,omitempty"`
+	ExcludeFlushMarkers  bool                    `json:"excludeFlushMarkers,omitempty"`
+	OrderedDispatch      bool                    `json:"orderedDispatch,omitempty"`
+	DryRun               bool                    `json:"dryRun,omitempty"`
+	PriorityBatchMaxSize uint                    `json:"priorityBatchMaxSize,omitempty"`
+	PriorityBatchTimeout fftypes.FFDuration      `json:"priorityBatchTimeout,omitempty"`
+	Callbacks            []string                `json:"callbacks,omitempty"`
+}
+
+type persistedDispatchRetry struct {
+	InitialDelay fftypes.FFDuration `json:"initialDelay,omitempty"`
+	MaximumDelay fftypes.FFDuration `json:"maximumDelay,omitempty"`
+	Factor       float64            `json:"factor,omitempty"`
+}
+
+func newPersistedDispatcherOptions(d *dispatcher) *persistedDispatcherOptions {
+	o := &d.options
+	p := &persistedDispatcherOptions{
+		TxType:               d.txType,
+		MessageTypes:         d.msgTypes,
+		BatchType:            o.BatchType,
+		BatchMaxSize:         o.BatchMaxSize,
+		BatchMaxBytes:        o.BatchMaxBytes,
+		BatchTimeout:         fftypes.FFDuration(o.BatchTimeout),
+		DisposeTimeout:       fftypes.FFDuration(o.DisposeTimeout),
+		BatchMaxAge:          fftypes.FFDuration(o.BatchMaxAge),
+		BatchMinSize:         o.BatchMinSize,
+		StallThreshold:       fftypes.FFDuration(o.StallThreshold),
+		SizeClasses:          o.SizeClasses,
+		IncludeProvenance:    o.IncludeProvenance,
+		SlowDownPageSize:     o.SlowDownPageSize,
+		SlowDownPollDelay:    fftypes.FFDuration(o.SlowDownPollDelay),
+		ReadPageSize:         o.ReadPageSize,
+		CorrelateBatch:       o.CorrelateBatch,
+		MinMessageDwell:      fftypes.FFDuration(o.MinMessageDwell),
+		Outbox:               o.Outbox,
+		IdempotencyWindow:    fftypes.FFDuration(o.IdempotencyWindow),
+		ReadinessRecheck:     fftypes.FFDuration(o.ReadinessRecheck),
+		VerifyReadBack:       o.VerifyReadBack,
+		MaxDispatchAttempts:  o.MaxDispatchAttempts,
+		DispatchTimeout:      fftypes.FFDuration(o.DispatchTimeout),
+		DispatchConcurrency:  o.DispatchConcurrency,
+		CloneBatch:           o.CloneBatch,
+		ConcurrentHandlers:   o.ConcurrentHandlers,
+		ExcludeFlushMarkers:  o.ExcludeFlushMarkers,
+		OrderedDispatch:      o.OrderedDispatch,
+		DryRun:               o.DryRun,
+		PriorityBatchMaxSize: o.PriorityBatchMaxSize,
+		PriorityBatchTimeout: fftypes.FFDuration(o.PriorityBatchTimeout),
+	}
+	if o.DispatchRetry != (DispatchRetryOptions{}) {
+		p.DispatchRetry = &persistedDispatchRetry{
+			InitialDelay: fftypes.FFDuration(o.DispatchRetry.InitialDelay),
+			MaximumDelay: fftypes.FFDuration(o.DispatchRetry.MaximumDelay),
+			Factor:       o.DispatchRetry.Factor,
+		}
+	}
+	callbacks := []struct {
+		name string
+		set  bool
+	}{
+		{"affinityKey", o.AffinityKey != nil},
+		{"confirmedElsewhere", o.ConfirmedElsewhere != nil},
+		{"deadLetter", o.DeadLetter != nil},
+		{"dispatchWeight", o.DispatchWeight != nil},
+		{"groupBy", o.GroupBy != nil},
+		{"groupMembership", o.GroupMembership != nil},
+		{"idempotencyKey", o.IdempotencyKey != nil},
+		{"readinessGate", o.ReadinessGate != nil},
+		{"txSizeLimitError", o.TxSizeLimitError != nil},
+	}
+	for _, cb := range callbacks {
+		if cb.set {
+			p.Callbacks = append(p.Callbacks, cb.name)
+		}
+	}
+	return p
+}
+
+// checkDispatcherOptions persists the options of each registered dispatcher, logging a warning for any that differ
+// from the options recorded on the last run. Failures are logged, and do not block startup.
+func (bm *batchManager) checkDispatcherOptions() {
+	bm.dispatcherMux.Lock()
+	dispatchers := make([]*dispatcher, len(bm.allDispatchers))
+	copy(dispatchers, bm.allDispatchers)
+	bm.dispatcherMux.Unlock()
+
+	for _, d := range dispatchers {
+		if err := bm.checkDispatcherOptionsFor(d); err != nil {
+			log.L(bm.ctx).Warnf("Failed to persist options for dispatcher '%s': %s", d.name, err)
+		}
+	}
+}
+
+func (bm *batchManager) checkDispatcherOptionsFor(d *dispatcher) error {
+	b, err := json.Marshal(newPersistedDispatcherOptions(d))
+	if err != nil {
+		return err
+	}
+	options := fftypes.JSONAnyPtrBytes(b)
+
+	previous, err := bm.database.GetDispatcherOptions(bm.ctx, bm.namespace, d.name)
+	if err != nil {
+		return err
+	}
+	switch {
+	case previous == nil:
+		log.L(bm.ctx).Infof("Recording options for dispatcher '%s': %s", d.name, options)
+	case previous.Options.String() != options.String():
+		log.L(bm.ctx).Warnf("Options for dispatcher '%s' have changed since %s. previous=%s current=%s", d.name, previous.Updated, previous.Options, options)
+	default:
+		// Unchanged - no need to update the record
+		return nil
+	}
+	return bm.database.UpsertDispatcherOptions(bm.ctx, &core.DispatcherOptionsRecord{
+		Namespace:  bm.namespace,
+		Dispatcher: d.name,
+		Options:    options,
+		Updated:    fftypes.Now(),
+	})
+}
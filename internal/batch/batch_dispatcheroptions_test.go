@@ -0,0 +1,187 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/internal/coreconfig"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func registerTestDispatcher(bm *batchManager, options DispatcherOptions) {
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			return nil
+		},
+		options,
+	)
+}
+
+func TestCheckDispatcherOptionsFirstRun(t *testing.T) {
+	testConfigReset()
+	defer coreconfig.Reset()
+	config.Set(coreconfig.BatchManagerPersistDispatcherOptions, true)
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	registerTestDispatcher(bm, DispatcherOptions{
+		BatchMaxSize: 100,
+		BatchTimeout: 500 * time.Millisecond,
+		AffinityKey:  func(msg *core.Message) string { return "" },
+	})
+
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetDispatcherOptions", mock.Anything, "ns1", "utdispatcher").Return(nil, nil)
+	mdi.On("UpsertDispatcherOptions", mock.Anything, mock.MatchedBy(func(record *core.DispatcherOptionsRecord) bool {
+		return record.Namespace == "ns1" && record.Dispatcher == "utdispatcher" &&
+			record.Options.String() == `{"txType":"batch_pin","messageTypes":["broadcast"],"batchMaxSize":100,"batchTimeout":"500ms","disposeTimeout":"2m0s","callbacks":["affinityKey"]}`
+	})).Return(nil)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+	mdi.AssertCalled(t, "UpsertDispatcherOptions", mock.Anything, mock.Anything)
+}
+
+func TestCheckDispatcherOptionsUnchanged(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	registerTestDispatcher(bm, DispatcherOptions{BatchMaxSize: 100})
+
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetDispatcherOptions", mock.Anything, "ns1", "utdispatcher").Return(&core.DispatcherOptionsRecord{
+		Options: fftypes.JSONAnyPtr(`{"txType":"batch_pin","messageTypes":["broadcast"],"batchMaxSize":100,"disposeTimeout":"2m0s"}`),
+	}, nil)
+
+	bm.checkDispatcherOptions()
+	mdi.AssertNotCalled(t, "UpsertDispatcherOptions", mock.Anything, mock.Anything)
+}
+
+func TestCheckDispatcherOptionsChanged(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	registerTestDispatcher(bm, DispatcherOptions{BatchMaxSize: 200})
+
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetDispatcherOptions", mock.Anything, "ns1", "utdispatcher").Return(&core.DispatcherOptionsRecord{
+		Options: fftypes.JSONAnyPtr(`{"txType":"batch_pin","messageTypes":["broadcast"],"batchMaxSize":100,"disposeTimeout":"2m0s"}`),
+		Updated: fftypes.Now(),
+	}, nil)
+	mdi.On("UpsertDispatcherOptions", mock.Anything, mock.MatchedBy(func(record *core.DispatcherOptionsRecord) bool {
+		return record.Options.String() == `{"txType":"batch_pin","messageTypes":["broadcast"],"batchMaxSize":200,"disposeTimeout":"2m0s"}`
+	})).Return(nil)
+
+	bm.checkDispatcherOptions()
+	mdi.AssertExpectations(t)
+}
+
+func TestCheckDispatcherOptionsReadFailDoesNotBlock(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	registerTestDispatcher(bm, DispatcherOptions{BatchMaxSize: 100})
+
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetDispatcherOptions", mock.Anything, "ns1", "utdispatcher").Return(nil, fmt.Errorf("pop"))
+
+	bm.checkDispatcherOptions()
+	mdi.AssertNotCalled(t, "UpsertDispatcherOptions", mock.Anything, mock.Anything)
+}
+
+func TestCheckDispatcherOptionsDisabled(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	registerTestDispatcher(bm, DispatcherOptions{BatchMaxSize: 100})
+
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+	mdi.AssertNotCalled(t, "GetDispatcherOptions", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPersistedDispatcherOptionsComplete(t *testing.T) {
+	// Observer hooks are deliberately not persisted, as they do not affect the batches of the dispatcher
+	excluded := map[string]bool{
+		"StallHandler":     true,
+		"LatencyHandler":   true,
+		"OnBatchSealed":    true,
+		"OnMessageBatched": true,
+	}
+
+	// Set every option to a non-zero value, so each persisted option appears in the JSON
+	var options DispatcherOptions
+	v := reflect.ValueOf(&options).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		setNonZero(v.Field(i))
+	}
+	b, err := json.Marshal(newPersistedDispatcherOptions(&dispatcher{options: options}))
+	assert.NoError(t, err)
+	var persisted map[string]interface{}
+	err = json.Unmarshal(b, &persisted)
+	assert.NoError(t, err)
+
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		if excluded[name] {
+			continue
+		}
+		jsonName := strings.ToLower(name[:1]) + name[1:]
+		if v.Field(i).Kind() == reflect.Func {
+			assert.Contains(t, persisted["callbacks"], jsonName, "callback DispatcherOptions.%s is not persisted", name)
+		} else {
+			assert.Contains(t, persisted, jsonName, "DispatcherOptions.%s is not persisted", name)
+		}
+	}
+}
+
+func setNonZero(f reflect.Value) {
+	switch f.Kind() {
+	case reflect.Func:
+		f.Set(reflect.MakeFunc(f.Type(), func(args []reflect.Value) []reflect.Value { return nil }))
+	case reflect.Bool:
+		f.SetBool(true)
+	case reflect.Int, reflect.Int64:
+		f.SetInt(1)
+	case reflect.Uint, reflect.Uint64:
+		f.SetUint(1)
+	case reflect.Float64:
+		f.SetFloat(1)
+	case reflect.String:
+		f.SetString("value")
+	case reflect.Slice:
+		f.Set(reflect.MakeSlice(f.Type(), 1, 1))
+	case reflect.Struct:
+		for i := 0; i < f.NumField(); i++ {
+			setNonZero(f.Field(i))
+		}
+	default:
+		panic(fmt.Sprintf("unhandled option kind %s", f.Kind()))
+	}
+}
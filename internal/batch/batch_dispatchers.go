@@ -0,0 +1,73 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// DispatcherDescription describes a registered dispatcher, with the options that determine the characteristics of
+// its batches in the form they are persisted - where callbacks are listed by name
+type DispatcherDescription struct {
+	Name     string           `ffstruct:"BatchDispatcherDescription" json:"name"`
+	Enabled  bool             `ffstruct:"BatchDispatcherDescription" json:"enabled"`
+	NoOp     bool             `ffstruct:"BatchDispatcherDescription" json:"noop,omitempty"`
+	Handlers int              `ffstruct:"BatchDispatcherDescription" json:"handlers"`
+	Options  *fftypes.JSONAny `ffstruct:"BatchDispatcherDescription" json:"options"`
+}
+
+// Dispatchers returns a snapshot of the options of each registered dispatcher, keyed by the message type it handles.
+// A dispatcher handling several message types appears under each of them, and a message type handled for several
+// transaction types (such as pinned and unpinned private messages) lists a dispatcher for each, in the order they
+// were registered. The options are copies, so changing them has no effect on the dispatcher.
+func (bm *batchManager) Dispatchers() map[core.MessageType][]DispatcherOptions {
+	bm.dispatcherMux.Lock()
+	defer bm.dispatcherMux.Unlock()
+	dispatchers := make(map[core.MessageType][]DispatcherOptions)
+	for _, d := range bm.allDispatchers {
+		for _, msgType := range d.msgTypes {
+			options := d.options
+			if options.SizeClasses != nil {
+				options.SizeClasses = append([]int64{}, options.SizeClasses...)
+			}
+			dispatchers[msgType] = append(dispatchers[msgType], options)
+		}
+	}
+	return dispatchers
+}
+
+// DescribeDispatchers describes each registered dispatcher, in the order they were registered
+func (bm *batchManager) DescribeDispatchers() []*DispatcherDescription {
+	bm.dispatcherMux.Lock()
+	defer bm.dispatcherMux.Unlock()
+	descriptions := make([]*DispatcherDescription, 0, len(bm.allDispatchers))
+	for _, d := range bm.allDispatchers {
+		// The persisted form of the options contains only types that can be serialized
+		b, _ := json.Marshal(newPersistedDispatcherOptions(d))
+		descriptions = append(descriptions, &DispatcherDescription{
+			Name:     d.name,
+			Enabled:  !d.disabled,
+			NoOp:     d.noOp,
+			Handlers: len(d.handlers),
+			Options:  fftypes.JSONAnyPtrBytes(b),
+		})
+	}
+	return descriptions
+}
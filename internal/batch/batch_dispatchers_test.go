@@ -0,0 +1,90 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatchersSnapshot(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	err := bm.RegisterDispatcher("utbroadcast", core.TransactionTypeUnpinned, []core.MessageType{core.MessageTypeBroadcast, core.MessageTypeDefinition},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 10, BatchTimeout: time.Second, SizeClasses: []int64{1024, 4096}},
+	)
+	assert.NoError(t, err)
+	err = bm.RegisterDispatcher("utprivate", core.TransactionTypeUnpinned, []core.MessageType{core.MessageTypePrivate},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 5},
+	)
+	assert.NoError(t, err)
+
+	err = bm.RegisterDispatcher("utpinned", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypePrivate},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 20},
+	)
+	assert.NoError(t, err)
+
+	dispatchers := bm.Dispatchers()
+	assert.Len(t, dispatchers, 3)
+	assert.Equal(t, uint(10), dispatchers[core.MessageTypeBroadcast][0].BatchMaxSize)
+	assert.Equal(t, time.Second, dispatchers[core.MessageTypeDefinition][0].BatchTimeout)
+
+	// Private messages are handled by a dispatcher for each transaction type, in the order they were registered
+	assert.Len(t, dispatchers[core.MessageTypePrivate], 2)
+	assert.Equal(t, uint(5), dispatchers[core.MessageTypePrivate][0].BatchMaxSize)
+	assert.Equal(t, uint(20), dispatchers[core.MessageTypePrivate][1].BatchMaxSize)
+
+	// Changing the snapshot does not change the dispatcher
+	options := dispatchers[core.MessageTypeBroadcast][0]
+	options.BatchMaxSize = 1
+	options.SizeClasses[0] = 1
+	assert.Equal(t, uint(10), bm.Dispatchers()[core.MessageTypeBroadcast][0].BatchMaxSize)
+	assert.Equal(t, []int64{1024, 4096}, bm.Dispatchers()[core.MessageTypeBroadcast][0].SizeClasses)
+}
+
+func TestDescribeDispatchers(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	err := bm.RegisterDispatcher("utbroadcast", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 10, ReadinessGate: func(msg *core.Message) (bool, error) { return true, nil }},
+	)
+	assert.NoError(t, err)
+	bm.RegisterNoOpDispatcher("utnoop", core.TransactionTypeUnpinned, []core.MessageType{core.MessageTypePrivate}, DispatcherOptions{BatchMaxSize: 1})
+	bm.EnableDispatcher(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, false)
+
+	descriptions := bm.DescribeDispatchers()
+	assert.Len(t, descriptions, 2)
+	assert.Equal(t, "utbroadcast", descriptions[0].Name)
+	assert.False(t, descriptions[0].Enabled)
+	assert.False(t, descriptions[0].NoOp)
+	assert.Equal(t, 1, descriptions[0].Handlers)
+	options := descriptions[0].Options.JSONObject()
+	assert.Equal(t, float64(10), options["batchMaxSize"])
+	assert.Equal(t, []interface{}{"readinessGate"}, options["callbacks"])
+	assert.Equal(t, "utnoop", descriptions[1].Name)
+	assert.True(t, descriptions[1].Enabled)
+	assert.True(t, descriptions[1].NoOp)
+	assert.Equal(t, 0, descriptions[1].Handlers)
+}
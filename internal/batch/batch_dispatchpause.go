@@ -0,0 +1,139 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/log"
+)
+
+// PauseDispatch pauses the dispatch of batches, such as for a maintenance window on a downstream system, without
+// pausing assembly. Batches sealed while dispatch is paused are persisted with their messages in the assembled state,
+// and queued to be dispatched on resume - up to the configured limit, after which assembly also pauses. Any dispatch
+// already in progress completes, and a pause while resuming takes effect once the queued batches have been dispatched.
+func (bm *batchManager) PauseDispatch() {
+	bm.dispatchPauseMux.Lock()
+	defer bm.dispatchPauseMux.Unlock()
+	if !bm.dispatchPaused {
+		bm.dispatchPaused = true
+		bm.dispatchDrained = make(chan struct{})
+	}
+	bm.dispatchResuming = false
+	log.L(bm.ctx).Infof("Batch dispatch paused")
+}
+
+// ResumeDispatch dispatches the batches queued while dispatch was paused, in the order they were sealed, before
+// dispatch of new batches resumes. Batches still queued when the manager stops remain persisted, and are dispatched
+// on the next resume.
+func (bm *batchManager) ResumeDispatch() {
+	bm.dispatchPauseMux.Lock()
+	defer bm.dispatchPauseMux.Unlock()
+	if !bm.dispatchResuming {
+		bm.dispatchResuming = true
+		if !bm.dispatchPaused {
+			// Queue any new batches behind those left by an earlier run
+			bm.dispatchPaused = true
+			bm.dispatchDrained = make(chan struct{})
+		}
+		log.L(bm.ctx).Infof("Batch dispatch resuming with %d queued batches", bm.queuedBatches)
+		go bm.drainDispatchQueue()
+	}
+}
+
+// drainDispatchQueue claims and dispatches the queued batches until none are left, and only then stops queuing
+// newly sealed batches - so that no new batch overtakes a queued one
+func (bm *batchManager) drainDispatchQueue() {
+	for {
+		dispatched, err := bm.ClaimAndDispatch()
+		if err != nil {
+			log.L(bm.ctx).Errorf("Failed to dispatch queued batches: %s", err)
+		}
+
+		bm.dispatchPauseMux.Lock()
+		bm.queuedBatches -= dispatched
+		if !bm.dispatchResuming {
+			// Paused again while we were draining
+			bm.dispatchPauseMux.Unlock()
+			return
+		}
+		if err == nil && bm.queuedBatches <= 0 {
+			bm.queuedBatches = 0
+			bm.dispatchPaused = false
+			bm.dispatchResuming = false
+			close(bm.dispatchDrained)
+			bm.dispatchPauseMux.Unlock()
+			log.L(bm.ctx).Infof("Batch dispatch resumed")
+			return
+		}
+		bm.dispatchPauseMux.Unlock()
+
+		// A queued batch might still be being sealed, so we wait before looking again
+		select {
+		case <-time.After(bm.retry.InitialDelay):
+		case <-bm.ctx.Done():
+			log.L(bm.ctx).Debugf("Dispatch queue drain exiting due to cancelled context")
+			return
+		}
+	}
+}
+
+// reserveDispatchQueue is called as a batch is flushed, and returns true if dispatch is paused - in which case the
+// batch is counted in the queue, and must be sealed in the assembled state rather than dispatched
+func (bm *batchManager) reserveDispatchQueue() bool {
+	bm.dispatchPauseMux.Lock()
+	defer bm.dispatchPauseMux.Unlock()
+	if bm.dispatchPaused {
+		bm.queuedBatches++
+	}
+	return bm.dispatchPaused
+}
+
+func (bm *batchManager) isDispatchPaused() bool {
+	bm.dispatchPauseMux.Lock()
+	defer bm.dispatchPauseMux.Unlock()
+	return bm.dispatchPaused
+}
+
+// waitWhileDispatchQueueFull blocks while dispatch is paused with the queue at its limit, until the queue has been
+// drained on resume, returning true if the context closes while waiting
+func (bm *batchManager) waitWhileDispatchQueueFull() (done bool) {
+	bm.dispatchPauseMux.Lock()
+	full := bm.dispatchPaused && bm.maxQueuedBatches > 0 && bm.queuedBatches >= bm.maxQueuedBatches
+	drained := bm.dispatchDrained
+	bm.dispatchPauseMux.Unlock()
+	if !full {
+		return false
+	}
+
+	log.L(bm.ctx).Infof("Assembly paused with %d batches queued while dispatch is paused", bm.maxQueuedBatches)
+	select {
+	case <-drained:
+		return false
+	case <-bm.drain:
+		return false
+	case <-bm.ctx.Done():
+		return true
+	}
+}
+
+func (bm *batchManager) dispatchPauseStatus(status *ManagerStatus) {
+	bm.dispatchPauseMux.Lock()
+	defer bm.dispatchPauseMux.Unlock()
+	status.DispatchPaused = bm.dispatchPaused
+	status.QueuedBatches = bm.queuedBatches
+}
@@ -0,0 +1,184 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func testDispatchPauseStatus(bm *batchManager) *ManagerStatus {
+	status := &ManagerStatus{}
+	bm.dispatchPauseStatus(status)
+	return status
+}
+
+func TestPauseDispatchQueuesUntilResume(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	dispatched := make(chan *DispatchState, 1)
+	sealed := make(chan *core.BatchPersisted, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:   1,
+			DisposeTimeout: 120 * time.Second,
+			OnBatchSealed: func(batch *core.Batch) {
+				sealed <- &core.BatchPersisted{BatchHeader: batch.BatchHeader, Hash: batch.Hash, TX: batch.Payload.TX}
+			},
+		},
+	)
+	bm.PauseDispatch()
+	bm.PauseDispatch() // no-op
+
+	// The batch is sealed and queued, rather than dispatched
+	msg := newTestBroadcastMessage(1001)
+	claimedMsg := *msg
+	processor, err := bm.getProcessor(msg.Header.TxType, msg.Header.Type, msg.Header.Group, &msg.Header.SignerRef, 0, "")
+	assert.NoError(t, err)
+	bm.dispatchMessage(bm.ctx, &pendingDispatch{processor: processor, msg: msg})
+	assert.Eventually(t, func() bool {
+		status := testDispatchPauseStatus(bm)
+		return status.DispatchPaused && status.QueuedBatches == 1
+	}, 5*time.Second, time.Millisecond)
+	select {
+	case <-dispatched:
+		assert.Fail(t, "dispatched while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	persisted := <-sealed
+
+	// On resume the queued batch is claimed and dispatched
+	claimedMsg.BatchID = persisted.ID
+	claimedMsg.State = core.MessageStateAssembled
+	mockClaimableBatch(mdi, mdm, persisted, &claimedMsg)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+	bm.ResumeDispatch()
+	bm.ResumeDispatch() // no-op while resuming
+
+	state := <-dispatched
+	assert.Equal(t, persisted.ID, state.Persisted.ID)
+	assert.Eventually(t, func() bool {
+		status := testDispatchPauseStatus(bm)
+		return !status.DispatchPaused && status.QueuedBatches == 0
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestPauseDispatchFullQueuePausesAssembly(t *testing.T) {
+	bm, mdi, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	assert.Equal(t, 100, bm.maxQueuedBatches)
+	bm.maxQueuedBatches = 1
+	assert.False(t, bm.waitWhileDispatchQueueFull())
+
+	bm.PauseDispatch()
+	assert.True(t, bm.reserveDispatchQueue())
+	waitDone := make(chan bool)
+	go func() {
+		waitDone <- bm.waitWhileDispatchQueueFull()
+	}()
+	select {
+	case <-waitDone:
+		assert.Fail(t, "assembly continued with a full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// The queued batch was dispatched elsewhere, so resuming finds nothing to claim
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+	bm.dispatchPauseMux.Lock()
+	bm.queuedBatches = 0
+	bm.dispatchPauseMux.Unlock()
+	bm.ResumeDispatch()
+	assert.False(t, <-waitDone)
+	assert.False(t, bm.isDispatchPaused())
+}
+
+func TestPauseDispatchWhileResuming(t *testing.T) {
+	bm, mdi, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.retry.InitialDelay = time.Millisecond
+	polled := make(chan bool, 1)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil).Run(func(args mock.Arguments) {
+		select {
+		case polled <- true:
+		default:
+		}
+	})
+
+	// The queued batch is still being sealed, so the drain waits for it
+	bm.PauseDispatch()
+	bm.reserveDispatchQueue()
+	bm.ResumeDispatch()
+	<-polled
+	<-polled
+
+	// Pausing again stops the drain, leaving dispatch paused
+	bm.PauseDispatch()
+	time.Sleep(10 * time.Millisecond)
+	status := testDispatchPauseStatus(bm)
+	assert.True(t, status.DispatchPaused)
+	assert.Equal(t, 1, status.QueuedBatches)
+
+	cancel()
+}
+
+func TestResumeDispatchDrainCancelled(t *testing.T) {
+	bm, mdi, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.retry.InitialDelay = time.Hour
+	polled := make(chan bool, 1)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil).Run(func(args mock.Arguments) {
+		select {
+		case polled <- true:
+		default:
+		}
+	})
+
+	bm.PauseDispatch()
+	bm.reserveDispatchQueue()
+	bm.ResumeDispatch()
+	<-polled
+	cancel()
+
+	// A full queue does not block shutdown
+	bm.maxQueuedBatches = 1
+	assert.True(t, bm.waitWhileDispatchQueueFull())
+}
+
+func TestClaimLoopWaitsWhileDispatchPaused(t *testing.T) {
+	bm, mdi, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.dispatchOnly = true
+	bm.PauseDispatch()
+
+	err := bm.Start()
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	bm.WaitStop()
+	mdi.AssertNotCalled(t, "GetMessageIDs", mock.Anything, mock.Anything, mock.Anything)
+}
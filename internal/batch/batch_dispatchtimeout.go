@@ -0,0 +1,44 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+)
+
+// dispatchAttempt makes one attempt to dispatch the batch. With a DispatchTimeout, the handler's context is cancelled
+// once the timeout passes, and an error it returns after that is reported as the attempt timing out.
+func (bp *batchProcessor) dispatchAttempt(ctx context.Context, state *DispatchState) (retry bool, err error) {
+	if bp.conf.DispatchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, bp.conf.DispatchTimeout)
+		defer cancel()
+	}
+	if bp.bm.failFast != nil {
+		retry, err = bp.dispatchFailFast(ctx, state)
+	} else {
+		retry, err = true, bp.conf.dispatch(ctx, state)
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && bp.ctx.Err() == nil {
+		err = i18n.WrapError(bp.ctx, err, coremsgs.MsgBatchDispatchHandlerTimeout, state.Persisted.ID, bp.conf.DispatchTimeout)
+	}
+	return retry, err
+}
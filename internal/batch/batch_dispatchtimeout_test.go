@@ -0,0 +1,106 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDispatchTimeoutDeadLettered(t *testing.T) {
+	dispatches := 0
+	cancel, mdi, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		// The handler hangs until its context is cancelled
+		dispatches++
+		_, hasDeadline := c.Deadline()
+		assert.True(t, hasDeadline)
+		<-c.Done()
+		return c.Err()
+	})
+	defer cancel()
+	var deadLetterErr error
+	bp.conf.DispatchTimeout = time.Millisecond
+	bp.conf.MaxDispatchAttempts = 2
+	bp.conf.DeadLetter = func(ctx context.Context, batch *core.Batch, err error) error {
+		deadLetterErr = err
+		return nil
+	}
+	mockRunAsGroupPassthrough(mdi)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+	bp.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+
+	state := newTestDeadLetterState()
+	err := bp.dispatchAndFinalize(state)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, dispatches)
+	assert.Regexp(t, "FF10445.*"+state.Persisted.ID.String(), deadLetterErr)
+	assert.ErrorIs(t, deadLetterErr, context.DeadlineExceeded)
+}
+
+func TestDispatchTimeoutRetried(t *testing.T) {
+	dispatches := 0
+	cancel, _, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		dispatches++
+		if dispatches == 1 {
+			<-c.Done()
+			return c.Err()
+		}
+		return nil
+	})
+	defer cancel()
+	bp.conf.DispatchTimeout = time.Millisecond
+
+	state := newTestDeadLetterState()
+	err := bp.dispatchBatch(state)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, dispatches)
+}
+
+func TestDispatchAttemptNoTimeout(t *testing.T) {
+	cancel, _, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		_, hasDeadline := c.Deadline()
+		assert.False(t, hasDeadline)
+		return fmt.Errorf("pop")
+	})
+	defer cancel()
+
+	retry, err := bp.dispatchAttempt(bp.ctx, newTestDeadLetterState())
+	assert.True(t, retry)
+	assert.EqualError(t, err, "pop")
+}
+
+func TestDispatchAttemptSucceedsAfterTimeout(t *testing.T) {
+	cancel, _, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		// A handler that completes regardless of the cancellation is successful
+		<-c.Done()
+		return nil
+	})
+	defer cancel()
+	bp.conf.DispatchTimeout = time.Millisecond
+
+	retry, err := bp.dispatchAttempt(bp.ctx, newTestDeadLetterState())
+	assert.True(t, retry)
+	assert.NoError(t, err)
+}
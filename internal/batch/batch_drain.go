@@ -0,0 +1,123 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+)
+
+// DrainAndStop flushes the open batch of every processor immediately - rather than waiting for it to fill or time
+// out - then stops the manager, and persists the offset. If the context is cancelled before the drain completes,
+// the manager is stopped without waiting, and the context error returned. The persisted offset then only covers
+// the batches that were flushed, so it remains consistent.
+func (bm *batchManager) DrainAndStop(ctx context.Context) (err error) {
+	bm.drainOnce.Do(func() { close(bm.drain) })
+	select {
+	case <-bm.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	bm.Close()
+	bm.WaitStop()
+	bm.persistDrainedOffset()
+	return err
+}
+
+func (bm *batchManager) isDraining() bool {
+	select {
+	case <-bm.drain:
+		return true
+	default:
+		return false
+	}
+}
+
+// drainProcessors closes the input of every processor, so each flushes its open batch and exits, then waits for
+// them all to finish. Called on the sequencer goroutine, as that is the only goroutine that dispatches work.
+func (bm *batchManager) drainProcessors() {
+	bm.dispatcherMux.Lock()
+	var draining []*batchProcessor
+	for _, d := range bm.allDispatchers {
+		for k, p := range d.processors {
+			delete(d.processors, k)
+			close(p.newWork)
+			draining = append(draining, p)
+		}
+	}
+	bm.dispatcherMux.Unlock()
+
+	log.L(bm.ctx).Infof("Draining %d batch processors", len(draining))
+	for _, p := range draining {
+		<-p.done
+	}
+}
+
+// persistDrainedOffset writes the offset after everything flushed while draining, once all processors have stopped
+func (bm *batchManager) persistDrainedOffset() {
+	if !bm.offsetEnabled {
+		return
+	}
+	bm.inflightMux.Lock()
+	offset := bm.calcCommittableOffset()
+	bm.inflightMux.Unlock()
+
+	bm.offsetMux.Lock()
+	if offset > bm.pendingOffset {
+		bm.pendingOffset = offset
+	}
+	bm.offsetMux.Unlock()
+	bm.flushOffset()
+}
+
+// WaitStopWithTimeout waits for the manager to stop as WaitStop does, but returns an error if it has not stopped
+// within the timeout - for example because a dispatch handler is blocked - so the caller can force the shutdown.
+// A single goroutine continues to wait for the stop in the background, and logs once it completes.
+func (bm *batchManager) WaitStopWithTimeout(timeout time.Duration) error {
+	stopped := bm.stoppedSignal()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+		return nil
+	case <-timer.C:
+		atomic.StoreInt32(&bm.stopTimedOut, 1)
+		log.L(bm.ctx).Warnf("Batch manager did not stop within %s - a dispatch handler might be blocked", timeout)
+		return i18n.NewError(bm.ctx, coremsgs.MsgBatchManagerStopTimeout, timeout)
+	}
+}
+
+// stoppedSignal returns a channel that is closed once the manager has stopped, starting the goroutine that waits
+// for the stop on the first call
+func (bm *batchManager) stoppedSignal() <-chan struct{} {
+	bm.stoppedOnce.Do(func() {
+		bm.stopped = make(chan struct{})
+		go func() {
+			bm.WaitStop()
+			if atomic.LoadInt32(&bm.stopTimedOut) == 1 {
+				log.L(bm.ctx).Infof("Batch manager stopped, after the wait for it timed out")
+			}
+			close(bm.stopped)
+		}()
+	})
+	return bm.stopped
+}
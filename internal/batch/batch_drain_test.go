@@ -0,0 +1,144 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestDrainingBatchManager(t *testing.T, handler DispatchHandler) (*batchManager, func() []int64, func()) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	bm.offsetEnabled = true
+	bm.offsetCommitAsync = true
+	mdi.On("GetOffset", mock.Anything, core.OffsetTypeBatch, "ff_batch_ns1").Return(&core.Offset{
+		RowID:   12345,
+		Current: 1000,
+	}, nil)
+	committed := mockOffsetUpdates(mdi)
+
+	// The batch would not otherwise fill or time out for the duration of the test
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast}, handler,
+		DispatcherOptions{
+			BatchMaxSize:   10,
+			BatchMaxBytes:  1024 * 1024,
+			BatchTimeout:   time.Hour,
+			DisposeTimeout: time.Hour,
+		},
+	)
+	mockOffsetAheadCheck(mdi, 1002)
+	mockMessagePage(mdi, mdm, newTestBroadcastMessage(1001), newTestBroadcastMessage(1002))
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		bm.inflightMux.Lock()
+		defer bm.inflightMux.Unlock()
+		return bm.highestReadOffset == 1002
+	}, 5*time.Second, time.Millisecond)
+	return bm, committed, cancel
+}
+
+func TestDrainAndStopFlushesOpenBatch(t *testing.T) {
+	dispatched := make(chan *DispatchState, 1)
+	bm, committed, cancel := newTestDrainingBatchManager(t, func(c context.Context, state *DispatchState) error {
+		dispatched <- state
+		return nil
+	})
+	defer cancel()
+
+	err := bm.DrainAndStop(context.Background())
+	assert.NoError(t, err)
+
+	// The partial batch was dispatched, and the offset persisted past it
+	state := <-dispatched
+	assert.Len(t, state.Messages, 2)
+	commits := committed()
+	assert.Equal(t, int64(1002), commits[len(commits)-1])
+	assert.Empty(t, bm.getProcessors())
+}
+
+func TestDrainAndStopContextCancelled(t *testing.T) {
+	bm, committed, cancel := newTestDrainingBatchManager(t, func(c context.Context, state *DispatchState) error {
+		// The dispatch never completes
+		<-c.Done()
+		return c.Err()
+	})
+	defer cancel()
+
+	ctx, cancelDrain := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelDrain()
+	err := bm.DrainAndStop(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// The offset never advances past the batch that was not dispatched
+	for _, offset := range committed() {
+		assert.LessOrEqual(t, offset, int64(1000))
+	}
+}
+
+func TestWaitStopWithTimeout(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	// The dispatch handler is wedged, ignoring the closed context
+	dispatching := make(chan bool)
+	unblock := make(chan bool)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			close(dispatching)
+			<-unblock
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:   1,
+			BatchMaxBytes:  1024 * 1024,
+			DisposeTimeout: time.Hour,
+		},
+	)
+	mockMessagePage(mdi, mdm, newTestBroadcastMessage(1001))
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+	<-dispatching
+	bm.Close()
+
+	err = bm.WaitStopWithTimeout(10 * time.Millisecond)
+	assert.Regexp(t, "FF10440", err)
+	err = bm.WaitStopWithTimeout(10 * time.Millisecond)
+	assert.Regexp(t, "FF10440", err)
+
+	// Once the handler returns the manager stops
+	close(unblock)
+	err = bm.WaitStopWithTimeout(5 * time.Second)
+	assert.NoError(t, err)
+}
+
+func TestWaitStopWithTimeoutStopped(t *testing.T) {
+	bm, _, _, cancel := newTestDispatchingBatchManager(t)
+	bm.Close()
+	cancel()
+	close(bm.done)
+	assert.NoError(t, bm.WaitStopWithTimeout(5*time.Second))
+}
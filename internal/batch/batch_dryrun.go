@@ -0,0 +1,149 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// The upper bounds of the buckets of the dry-run histograms, chosen to span the batch sizes and timeouts in common use
+var (
+	dryRunMessageBuckets = []int64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+	dryRunByteBuckets    = []int64{1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216}
+	dryRunTimeBuckets    = []int64{10, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000}
+)
+
+// DryRunStats describes the shapes of the batches assembled by a dispatcher in dry-run mode
+type DryRunStats struct {
+	Dispatcher       string     `ffstruct:"BatchDryRunStats" json:"dispatcher"`
+	Batches          int64      `ffstruct:"BatchDryRunStats" json:"batches"`
+	Messages         int64      `ffstruct:"BatchDryRunStats" json:"messages"`
+	MessagesPerBatch *Histogram `ffstruct:"BatchDryRunStats" json:"messagesPerBatch"`
+	BytesPerBatch    *Histogram `ffstruct:"BatchDryRunStats" json:"bytesPerBatch"`
+	AssemblyTime     *Histogram `ffstruct:"BatchDryRunStats" json:"assemblyTime"`
+}
+
+// Histogram is a distribution of observations, with cumulative bucket counts in the same form as a Prometheus histogram
+type Histogram struct {
+	Buckets []*HistogramBucket `ffstruct:"BatchHistogram" json:"buckets"`
+	Count   int64              `ffstruct:"BatchHistogram" json:"count"`
+	Sum     int64              `ffstruct:"BatchHistogram" json:"sum"`
+}
+
+type HistogramBucket struct {
+	UpperBound int64 `ffstruct:"BatchHistogramBucket" json:"upperBound"`
+	Count      int64 `ffstruct:"BatchHistogramBucket" json:"count"`
+}
+
+func newHistogram(upperBounds []int64) *Histogram {
+	h := &Histogram{Buckets: make([]*HistogramBucket, len(upperBounds))}
+	for i, upperBound := range upperBounds {
+		h.Buckets[i] = &HistogramBucket{UpperBound: upperBound}
+	}
+	return h
+}
+
+func (h *Histogram) observe(value int64) {
+	h.Count++
+	h.Sum += value
+	for _, b := range h.Buckets {
+		if value <= b.UpperBound {
+			b.Count++
+		}
+	}
+}
+
+func (h *Histogram) copy() *Histogram {
+	c := &Histogram{Buckets: make([]*HistogramBucket, len(h.Buckets)), Count: h.Count, Sum: h.Sum}
+	for i, b := range h.Buckets {
+		c.Buckets[i] = &HistogramBucket{UpperBound: b.UpperBound, Count: b.Count}
+	}
+	return c
+}
+
+func newDryRunStats(dispatcherName string) *DryRunStats {
+	return &DryRunStats{
+		Dispatcher:       dispatcherName,
+		MessagesPerBatch: newHistogram(dryRunMessageBuckets),
+		BytesPerBatch:    newHistogram(dryRunByteBuckets),
+		AssemblyTime:     newHistogram(dryRunTimeBuckets),
+	}
+}
+
+// recordDryRun adds a batch assembled in dry-run mode to the histograms of its dispatcher
+func (bm *batchManager) recordDryRun(dispatcherName string, messages int, byteSize int64, assemblyTime time.Duration) {
+	bm.dryRunMux.Lock()
+	defer bm.dryRunMux.Unlock()
+	stats := bm.dryRunStats[dispatcherName]
+	if stats == nil {
+		stats = newDryRunStats(dispatcherName)
+		bm.dryRunStats[dispatcherName] = stats
+	}
+	stats.Batches++
+	stats.Messages += int64(messages)
+	stats.MessagesPerBatch.observe(int64(messages))
+	stats.BytesPerBatch.observe(byteSize)
+	stats.AssemblyTime.observe(assemblyTime.Milliseconds())
+}
+
+// DryRunStats returns the histograms of the batches assembled by each dispatcher in dry-run mode, ordered by dispatcher name
+func (bm *batchManager) DryRunStats() []*DryRunStats {
+	bm.dryRunMux.Lock()
+	defer bm.dryRunMux.Unlock()
+	stats := make([]*DryRunStats, 0, len(bm.dryRunStats))
+	for _, s := range bm.dryRunStats {
+		stats = append(stats, &DryRunStats{
+			Dispatcher:       s.Dispatcher,
+			Batches:          s.Batches,
+			Messages:         s.Messages,
+			MessagesPerBatch: s.MessagesPerBatch.copy(),
+			BytesPerBatch:    s.BytesPerBatch.copy(),
+			AssemblyTime:     s.AssemblyTime.copy(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Dispatcher < stats[j].Dispatcher })
+	return stats
+}
+
+// flushDryRun completes the flush of a batch assembled by a dispatcher in dry-run mode. The batch is sealed in memory
+// to measure it, but no pins, transaction or batch are persisted, and it is not dispatched. Its messages are given the
+// dry-run state in the message cache only, so the state of the messages in the database is unchanged.
+func (bp *batchProcessor) flushDryRun(id *fftypes.UUID, flushWork []*batchWork, byteSize int64, trigger flushTrigger, assemblyStarted time.Time) error {
+	state := bp.initFlushState(id, flushWork)
+	manifestString := state.Persisted.GenManifest(state.Messages, state.Data).String()
+	state.Persisted.Manifest = fftypes.JSONAnyPtr(manifestString)
+	state.Persisted.Hash = fftypes.HashString(manifestString)
+
+	var assemblyTime time.Duration
+	if !assemblyStarted.IsZero() {
+		assemblyTime = bp.bm.since(assemblyStarted)
+	}
+	bp.bm.recordDryRun(bp.conf.dispatcherName, len(state.Messages), byteSize, assemblyTime)
+	for _, w := range flushWork {
+		w.msg.State = core.MessageStateDryRun
+		bp.data.UpdateMessageIfCached(bp.ctx, w.msg)
+	}
+	log.L(bp.ctx).Debugf("Dry run sealed batch %s with %d messages (%d bytes) without dispatch", id, len(state.Messages), byteSize)
+
+	bp.completeFlush(state, flushWork, byteSize, trigger)
+	return nil
+}
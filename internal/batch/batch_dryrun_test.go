@@ -0,0 +1,126 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/mocks/identitymanagermocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHistogramObserve(t *testing.T) {
+	h := newHistogram([]int64{1, 10, 100})
+	h.observe(1)
+	h.observe(5)
+	h.observe(50)
+	h.observe(500)
+	assert.Equal(t, int64(4), h.Count)
+	assert.Equal(t, int64(556), h.Sum)
+	assert.Equal(t, []*HistogramBucket{
+		{UpperBound: 1, Count: 1},
+		{UpperBound: 10, Count: 2},
+		{UpperBound: 100, Count: 3},
+	}, h.Buckets)
+
+	// Copies are not affected by later observations
+	c := h.copy()
+	h.observe(2)
+	assert.Equal(t, int64(4), c.Count)
+	assert.Equal(t, int64(2), c.Buckets[1].Count)
+}
+
+func TestDryRunDispatcherSkipsDispatch(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdm := bm.data.(*datamocks.Manager)
+	mim := bm.identity.(*identitymanagermocks.Manager)
+	mim.On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
+	mdm.On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+
+	bm.RegisterDispatcher("dryrun", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			assert.Fail(t, "dispatched in dry-run mode")
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:   2,
+			BatchMaxBytes:  1024 * 1024,
+			BatchTimeout:   time.Minute,
+			DisposeTimeout: time.Minute,
+			DryRun:         true,
+		},
+	)
+
+	msgs := []*core.Message{newTestBroadcastMessage(1001), newTestBroadcastMessage(1002), newTestBroadcastMessage(1003), newTestBroadcastMessage(1004)}
+	mockMessagePage(mdi, mdm, msgs...)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	// Two full batches are sealed and measured, and the offset advances past them with nothing persisted
+	assert.Eventually(t, func() bool {
+		bm.inflightMux.Lock()
+		defer bm.inflightMux.Unlock()
+		return bm.calcCommittableOffset() == 1004
+	}, 5*time.Second, time.Millisecond)
+	stats := bm.DryRunStats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, "dryrun", stats[0].Dispatcher)
+	assert.Equal(t, int64(2), stats[0].Batches)
+	assert.Equal(t, int64(4), stats[0].Messages)
+	assert.Equal(t, int64(4), stats[0].MessagesPerBatch.Sum)
+	assert.Equal(t, int64(0), stats[0].MessagesPerBatch.Buckets[0].Count)
+	assert.Equal(t, int64(2), stats[0].MessagesPerBatch.Buckets[1].Count)
+	assert.Equal(t, int64(2), stats[0].BytesPerBatch.Count)
+	assert.Equal(t, int64(2), stats[0].AssemblyTime.Count)
+	for _, msg := range msgs {
+		assert.Equal(t, core.MessageStateDryRun, msg.State)
+	}
+	mdi.AssertNotCalled(t, "UpsertBatch", mock.Anything, mock.Anything)
+	mdi.AssertNotCalled(t, "UpdateMessages", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestDryRunStatsOrdered(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	assert.Empty(t, bm.DryRunStats())
+	bm.recordDryRun("second", 5, 2048, 2*time.Second)
+	bm.recordDryRun("first", 1, 512, 0)
+	bm.recordDryRun("second", 15, 10000, 20*time.Millisecond)
+
+	stats := bm.DryRunStats()
+	assert.Len(t, stats, 2)
+	assert.Equal(t, "first", stats[0].Dispatcher)
+	assert.Equal(t, "second", stats[1].Dispatcher)
+	assert.Equal(t, int64(2), stats[1].Batches)
+	assert.Equal(t, int64(20), stats[1].Messages)
+	assert.Equal(t, int64(2020), stats[1].AssemblyTime.Sum)
+	assert.Equal(t, int64(1), stats[1].AssemblyTime.Buckets[1].Count)
+}
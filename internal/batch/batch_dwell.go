@@ -0,0 +1,48 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// deferUntilDwelled records the message as deferred if it has not yet aged past the MinMessageDwell of its
+// dispatcher, and schedules a rewind to read it again once it has. Returns true if the message was deferred.
+func (bm *batchManager) deferUntilDwelled(msg *core.Message) bool {
+	var dwell time.Duration
+	dispatcherKey := bm.getDispatcherKey(msg.Header.TxType, msg.Header.Type)
+	bm.dispatcherMux.Lock()
+	if d, ok := bm.dispatcherMap[dispatcherKey]; ok {
+		dwell = d.options.MinMessageDwell
+	}
+	bm.dispatcherMux.Unlock()
+
+	if dwell <= 0 || msg.Header.Created == nil {
+		return false
+	}
+	remaining := time.Until(msg.Header.Created.Time().Add(dwell))
+	if remaining <= 0 {
+		return false
+	}
+
+	log.L(bm.ctx).Debugf("Deferring message %s (seq=%d) for %s until it has dwelled for %s", msg.Header.ID, msg.Sequence, remaining, dwell)
+	bm.deferForRecheck(msg.Sequence, dispatcherKey, remaining)
+	return true
+}
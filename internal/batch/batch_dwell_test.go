@@ -0,0 +1,97 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMinMessageDwellDefersYoungMessages(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+
+	dwell := 100 * time.Millisecond
+	dispatched := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second, MinMessageDwell: dwell},
+	)
+
+	// The message is returned each time we read from before it
+	msg := newTestBroadcastMessage(1001)
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(func(ctx context.Context, ns string, filter database.Filter) []*core.IDAndSequence {
+		fi, _ := filter.Finalize()
+		if strings.HasPrefix(fi.String(), "( sequence >> 1000 )") {
+			return []*core.IDAndSequence{{ID: *msg.Header.ID, Sequence: msg.Sequence}}
+		}
+		return []*core.IDAndSequence{}
+	}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	// The freshly created message is deferred, and the offset cannot advance past it
+	assert.Eventually(t, func() bool {
+		bm.inflightMux.Lock()
+		defer bm.inflightMux.Unlock()
+		return bm.deferredSequences[1001] == bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast) && bm.highestReadOffset == 1001
+	}, 5*time.Second, time.Millisecond)
+	bm.inflightMux.Lock()
+	assert.Equal(t, int64(1000), bm.calcCommittableOffset())
+	bm.inflightMux.Unlock()
+
+	// It is only dispatched once it has aged past the minimum dwell
+	state := <-dispatched
+	assert.Equal(t, msg.Header.ID, state.Messages[0].Header.ID)
+	assert.GreaterOrEqual(t, time.Since(*msg.Header.Created.Time()), dwell)
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestMinMessageDwellAgedMessage(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 1, MinMessageDwell: time.Minute},
+	)
+
+	msg := newTestBroadcastMessage(1001)
+	aged := fftypes.FFTime(time.Now().Add(-2 * time.Minute))
+	msg.Header.Created = &aged
+	assert.False(t, bm.deferUntilDwelled(msg))
+	assert.Empty(t, bm.deferredSequences)
+
+	msg.Header.Created = nil
+	assert.False(t, bm.deferUntilDwelled(msg))
+}
@@ -0,0 +1,105 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// EnableDispatcher enables or disables the dispatcher registered for the transaction and message type, without
+// unregistering it. While disabled, messages for the dispatcher - of any of the message types it handles - are
+// deferred: they are not dispatched, and the offset does not advance past them. Any open batches are held.
+// When re-enabled, the deferred messages are read again for assembly.
+func (bm *batchManager) EnableDispatcher(txType core.TransactionType, msgType core.MessageType, enabled bool) {
+	bm.dispatcherMux.Lock()
+	d, ok := bm.dispatcherMap[bm.getDispatcherKey(txType, msgType)]
+	dispatcherKeys := make(map[string]bool)
+	if ok {
+		d.disabled = !enabled
+		for key, other := range bm.dispatcherMap {
+			dispatcherKeys[key] = other == d
+		}
+	}
+	bm.dispatcherMux.Unlock()
+	if !ok {
+		log.L(bm.ctx).Warnf("No dispatcher registered for %s", bm.getDispatcherKey(txType, msgType))
+		return
+	}
+	log.L(bm.ctx).Infof("Dispatcher %s enabled=%t", d.name, enabled)
+
+	if enabled {
+		rewindTo := int64(-1)
+		// The deferred messages are released when the rewind is applied, so the offset holds until they are read again
+		bm.inflightMux.Lock()
+		for seq, dispatcherKey := range bm.deferredSequences {
+			if dispatcherKeys[dispatcherKey] {
+				if rewindTo < 0 || seq < rewindTo {
+					rewindTo = seq
+				}
+			}
+		}
+		bm.inflightMux.Unlock()
+		if rewindTo >= 0 {
+			bm.newMessageNotification(rewindTo)
+		}
+	}
+}
+
+func (bm *batchManager) isDispatcherEnabled(dispatcherKey string) bool {
+	bm.dispatcherMux.Lock()
+	defer bm.dispatcherMux.Unlock()
+	if d, ok := bm.dispatcherMap[dispatcherKey]; ok {
+		return !d.disabled
+	}
+	return true
+}
+
+// deferIfDisabled records the message as deferred if its dispatcher is disabled, and returns true if so
+func (bm *batchManager) deferIfDisabled(msg *core.Message) bool {
+	dispatcherKey := bm.getDispatcherKey(msg.Header.TxType, msg.Header.Type)
+	bm.dispatcherMux.Lock()
+	d, ok := bm.dispatcherMap[dispatcherKey]
+	deferred := ok && d.disabled
+	bm.dispatcherMux.Unlock()
+
+	if deferred {
+		log.L(bm.ctx).Debugf("Deferring message %s (seq=%d) for disabled dispatcher %s", msg.Header.ID, msg.Sequence, d.name)
+		bm.inflightMux.Lock()
+		bm.deferredSequences[msg.Sequence] = dispatcherKey
+		bm.inflightMux.Unlock()
+	}
+	return deferred
+}
+
+// deferForRecheck records the message at the sequence as deferred for the dispatcher key, and schedules a rewind to read it again after the
+// delay - unless a rewind is already scheduled for it. The deferred record is only released when the rewind is applied,
+// so the offset cannot be committed past the message before it is read again.
+func (bm *batchManager) deferForRecheck(seq int64, dispatcherKey string, delay time.Duration) {
+	bm.inflightMux.Lock()
+	_, scheduled := bm.deferredSequences[seq]
+	bm.deferredSequences[seq] = dispatcherKey
+	bm.inflightMux.Unlock()
+
+	if !scheduled {
+		time.AfterFunc(delay, func() {
+			bm.newMessageNotification(seq)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDisabledDispatcherDefersMessages(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+
+	dispatched := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+	bm.EnableDispatcher(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, false)
+
+	// The message is returned each time we read from before it
+	msg := newTestBroadcastMessage(1001)
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(func(ctx context.Context, ns string, filter database.Filter) []*core.IDAndSequence {
+		fi, _ := filter.Finalize()
+		if strings.HasPrefix(fi.String(), "( sequence >> 1000 )") {
+			return []*core.IDAndSequence{{ID: *msg.Header.ID, Sequence: msg.Sequence}}
+		}
+		return []*core.IDAndSequence{}
+	}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	// The message is deferred, and the offset cannot advance past it
+	assert.Eventually(t, func() bool {
+		bm.inflightMux.Lock()
+		defer bm.inflightMux.Unlock()
+		return bm.deferredSequences[1001] == bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast) && bm.highestReadOffset == 1001
+	}, 5*time.Second, time.Millisecond)
+	bm.inflightMux.Lock()
+	assert.Equal(t, int64(1000), bm.calcCommittableOffset())
+	bm.inflightMux.Unlock()
+	select {
+	case <-dispatched:
+		assert.Fail(t, "dispatched while disabled")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Re-enabling rewinds to read the deferred message, which is then dispatched
+	bm.EnableDispatcher(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, true)
+	state := <-dispatched
+	assert.Equal(t, msg.Header.ID, state.Messages[0].Header.ID)
+	assert.Eventually(t, func() bool {
+		bm.inflightMux.Lock()
+		defer bm.inflightMux.Unlock()
+		return bm.calcCommittableOffset() == 1001
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestDisabledDispatcherHoldsOpenBatch(t *testing.T) {
+	bm, _, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	dispatched := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 10, BatchTimeout: 5 * time.Millisecond, DisposeTimeout: 120 * time.Second},
+	)
+	bm.EnableDispatcher(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, false)
+
+	msg := newTestBroadcastMessage(1001)
+	processor, err := bm.getProcessor(msg.Header.TxType, msg.Header.Type, msg.Header.Group, &msg.Header.SignerRef, 0, "")
+	assert.NoError(t, err)
+	bm.dispatchMessage(bm.ctx, &pendingDispatch{processor: processor, msg: msg})
+
+	select {
+	case <-dispatched:
+		assert.Fail(t, "dispatched while disabled")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	bm.EnableDispatcher(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, true)
+	state := <-dispatched
+	assert.Equal(t, msg.Header.ID, state.Messages[0].Header.ID)
+
+	assert.True(t, bm.isDispatcherEnabled("unknown"))
+}
+
+func TestEnableDispatcherByType(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast, core.MessageTypeDefinition},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 1},
+	)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeUnpinned, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 1},
+	)
+
+	// Disabling by one of its types disables the dispatcher for all of them, but not another dispatcher of the same name
+	bm.EnableDispatcher(core.TransactionTypeBatchPin, core.MessageTypeDefinition, false)
+	assert.False(t, bm.isDispatcherEnabled(bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast)))
+	assert.True(t, bm.isDispatcherEnabled(bm.getDispatcherKey(core.TransactionTypeUnpinned, core.MessageTypeBroadcast)))
+
+	msg := newTestBroadcastMessage(1001)
+	assert.True(t, bm.deferIfDisabled(msg))
+	assert.Equal(t, bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast), bm.deferredSequences[1001])
+	bm.deferredSequences[1000] = bm.getDispatcherKey(core.TransactionTypeUnpinned, core.MessageTypeBroadcast)
+
+	// Re-enabling by the other type rewinds to the messages deferred for the dispatcher only
+	bm.EnableDispatcher(core.TransactionTypeBatchPin, core.MessageTypeDefinition, true)
+	assert.Equal(t, int64(1000), bm.rewindOffset)
+
+	// Unregistered types are ignored
+	bm.EnableDispatcher(core.TransactionTypeBatchPin, core.MessageTypePrivate, false)
+	assert.True(t, bm.isDispatcherEnabled(bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypePrivate)))
+}
@@ -0,0 +1,96 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// ErrUnknownMessageType is returned for a message with no dispatcher registered for its transaction type and
+// message type - a configuration error, rather than a transient one. It renders as the FF10126 error.
+type ErrUnknownMessageType struct {
+	TxType core.TransactionType
+	Type   core.MessageType
+	err    error
+}
+
+func (e *ErrUnknownMessageType) Error() string {
+	return e.err.Error()
+}
+
+func (e *ErrUnknownMessageType) Unwrap() error {
+	return e.err
+}
+
+// ErrMessageDataMissing is returned when a message, or some of its data, could not be found - which can be transient,
+// where the data is still being written. DataID is the first data reference of the message that was not found, and
+// is nil if the message itself was not found. MissingData is all the data references of the message that were not
+// found. It renders as the FF10133 error.
+type ErrMessageDataMissing struct {
+	MsgID       *fftypes.UUID
+	DataID      *fftypes.UUID
+	MissingData []*fftypes.UUID
+	msgNotFound bool
+	err         error
+}
+
+func (e *ErrMessageDataMissing) Error() string {
+	return e.err.Error()
+}
+
+func (e *ErrMessageDataMissing) Unwrap() error {
+	return e.err
+}
+
+func (bm *batchManager) newUnknownMessageTypeError(ctx context.Context, txType core.TransactionType, msgType core.MessageType) error {
+	return &ErrUnknownMessageType{
+		TxType: txType,
+		Type:   msgType,
+		err:    i18n.NewError(ctx, coremsgs.MsgUnregisteredBatchType, bm.getDispatcherKey(txType, msgType)),
+	}
+}
+
+// newMessageDataMissingError is for a message whose data was not all found, with the data that was found
+func newMessageDataMissingError(ctx context.Context, msgID *fftypes.UUID, msg *core.Message, found core.DataArray) error {
+	e := &ErrMessageDataMissing{
+		MsgID:       msgID,
+		msgNotFound: msg == nil,
+		err:         i18n.NewError(ctx, coremsgs.MsgDataNotFound, msgID),
+	}
+	if msg != nil {
+		foundIDs := make(map[fftypes.UUID]bool, len(found))
+		for _, d := range found {
+			if d != nil && d.ID != nil {
+				foundIDs[*d.ID] = true
+			}
+		}
+		for _, ref := range msg.Data {
+			if ref.ID != nil && !foundIDs[*ref.ID] {
+				if e.DataID == nil {
+					e.DataID = ref.ID
+				}
+				e.MissingData = append(e.MissingData, ref.ID)
+			}
+		}
+	}
+	return e
+}
@@ -0,0 +1,77 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestUnknownMessageTypeError(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	_, err := bm.getProcessor(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, nil, &core.SignerRef{}, 0, "")
+	assert.Regexp(t, "FF10126.*tx:batch_pin/broadcast", err)
+	var unknownType *ErrUnknownMessageType
+	assert.True(t, errors.As(err, &unknownType))
+	assert.Equal(t, core.TransactionTypeBatchPin, unknownType.TxType)
+	assert.Equal(t, core.MessageTypeBroadcast, unknownType.Type)
+	assert.Regexp(t, "FF10126", errors.Unwrap(err))
+}
+
+func TestMessageDataMissingError(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdm := bm.data.(*datamocks.Manager)
+
+	msg := newTestBroadcastMessage(1001)
+	found := &core.Data{ID: fftypes.NewUUID()}
+	missing := &core.Data{ID: fftypes.NewUUID()}
+	msg.Data = core.DataRefs{{ID: found.ID}, {ID: missing.ID}}
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{found}, false, nil)
+
+	_, _, err := bm.assembleMessageData(bm.ctx, msg.Header.ID)
+	assert.Regexp(t, "FF10133", err)
+	var dataMissing *ErrMessageDataMissing
+	assert.True(t, errors.As(err, &dataMissing))
+	assert.Equal(t, msg.Header.ID, dataMissing.MsgID)
+	assert.Equal(t, missing.ID, dataMissing.DataID)
+	assert.Equal(t, []*fftypes.UUID{missing.ID}, dataMissing.MissingData)
+	assert.Regexp(t, "FF10133", errors.Unwrap(err))
+}
+
+func TestMessageDataMissingErrorNoMessage(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdm := bm.data.(*datamocks.Manager)
+
+	msgID := fftypes.NewUUID()
+	mdm.On("GetMessageWithDataCached", mock.Anything, msgID).Return(nil, nil, false, nil)
+
+	_, _, err := bm.assembleMessageData(bm.ctx, msgID)
+	var dataMissing *ErrMessageDataMissing
+	assert.True(t, errors.As(err, &dataMissing))
+	assert.Equal(t, msgID, dataMissing.MsgID)
+	assert.Nil(t, dataMissing.DataID)
+}
@@ -0,0 +1,57 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+)
+
+// failFastOptions is test-support configuration, that is never set outside of tests.
+// It bounds how long the dispatch path waits on a handler, so that a deadlocked handler is detected
+// and reported with an explicit error, rather than hanging until the test suite times out.
+type failFastOptions struct {
+	timeout time.Duration
+	report  func(err error)
+}
+
+// dispatchFailFast calls the dispatch handler, but gives up without retry if it does not return within the
+// fail-fast timeout. The handler is left running, as there is no way to interrupt it.
+func (bp *batchProcessor) dispatchFailFast(ctx context.Context, state *DispatchState) (retry bool, err error) {
+	result := make(chan error, 1)
+	go func() {
+		result <- bp.conf.dispatch(ctx, state)
+	}()
+
+	timer := time.NewTimer(bp.bm.failFast.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return true, err
+	case <-timer.C:
+		err := i18n.NewError(ctx, coremsgs.MsgBatchDispatchDeadlock, state.Persisted.ID, bp.bm.failFast.timeout)
+		log.L(ctx).Errorf("Fail-fast: %s", err)
+		if bp.bm.failFast.report != nil {
+			bp.bm.failFast.report(err)
+		}
+		return false, err
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestFailFastDetectsDeadlockedHandler(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	deadlocks := make(chan error, 1)
+	bm.failFast = &failFastOptions{
+		timeout: 10 * time.Millisecond,
+		report:  func(err error) { deadlocks <- err },
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			<-release // never released until the test completes
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+
+	msg := newTestBroadcastMessage(1001)
+	mockMessagePage(mdi, mdm, msg)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	select {
+	case err := <-deadlocks:
+		assert.Regexp(t, "FF10431", err)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "deadlocked handler not detected")
+	}
+
+	// The processor shuts down, rather than waiting indefinitely on the handler
+	processors := bm.getProcessors()
+	assert.Len(t, processors, 1)
+	select {
+	case <-processors[0].done:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "processor did not shut down")
+	}
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestFailFastHandlerError(t *testing.T) {
+	cancel, _, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		return fmt.Errorf("pop")
+	})
+	defer cancel()
+	bp.bm.failFast = &failFastOptions{timeout: 5 * time.Second}
+
+	retry, err := bp.dispatchFailFast(context.Background(), &DispatchState{})
+	assert.True(t, retry)
+	assert.Regexp(t, "pop", err)
+}
@@ -0,0 +1,74 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"sync"
+)
+
+// attachHandlers adds the handlers of a dispatcher being registered for a message type that already has a
+// dispatcher, so that every batch of that type fans out to all of them. Must be called with the dispatcherMux held.
+func (d *dispatcher) attachHandlers(handlers []DispatchHandler) {
+	d.handlers = append(d.handlers, handlers...)
+}
+
+// dispatchHandler returns the handler for new processors of the dispatcher. With a single handler it is called
+// directly, and with more than one every handler is called for each batch - and the dispatch only succeeds once
+// all of them have, so a failure in any one of them retries the whole batch.
+func (bm *batchManager) dispatchHandler(d *dispatcher) DispatchHandler {
+	if len(d.handlers) == 0 {
+		return nil
+	}
+	return func(ctx context.Context, state *DispatchState) error {
+		bm.dispatcherMux.Lock()
+		handlers := d.handlers
+		bm.dispatcherMux.Unlock()
+		if len(handlers) == 1 {
+			return handlers[0](ctx, state)
+		}
+		if d.options.ConcurrentHandlers {
+			return fanOutConcurrent(ctx, handlers, state)
+		}
+		for _, handler := range handlers {
+			if err := handler(ctx, state); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// fanOutConcurrent calls all the handlers at once, waits for all of them to complete, and returns the first error
+func fanOutConcurrent(ctx context.Context, handlers []DispatchHandler, state *DispatchState) error {
+	errs := make([]error, len(handlers))
+	var wg sync.WaitGroup
+	for i, handler := range handlers {
+		wg.Add(1)
+		go func(i int, handler DispatchHandler) {
+			defer wg.Done()
+			errs[i] = handler(ctx, state)
+		}(i, handler)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterDispatcherFanOut(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	var calls []string
+	handler := func(name string) DispatchHandler {
+		return func(ctx context.Context, state *DispatchState) error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	bm.RegisterDispatcher("transport", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast}, handler("transport"), DispatcherOptions{BatchMaxSize: 1})
+	bm.RegisterDispatcher("archive", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast, core.MessageTypeDefinition}, handler("archive"), DispatcherOptions{BatchMaxSize: 1})
+
+	transport := bm.dispatcherMap[bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast)]
+	assert.Equal(t, "transport", transport.name)
+	assert.Len(t, transport.handlers, 2)
+
+	// The type without an existing dispatcher gets one of its own
+	archive := bm.dispatcherMap[bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeDefinition)]
+	assert.Equal(t, "archive", archive.name)
+	assert.Equal(t, []core.MessageType{core.MessageTypeDefinition}, archive.msgTypes)
+
+	err := bm.dispatchHandler(transport)(context.Background(), &DispatchState{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"transport", "archive"}, calls)
+}
+
+func TestRegisterDispatcherFanOutAllAttached(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	dispatchers := len(bm.allDispatchers)
+	handler := func(ctx context.Context, state *DispatchState) error { return nil }
+	bm.RegisterDispatcher("transport", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast}, handler, DispatcherOptions{BatchMaxSize: 1})
+	bm.RegisterDispatcher("archive", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast}, handler, DispatcherOptions{BatchMaxSize: 1})
+	assert.Len(t, bm.allDispatchers, dispatchers+1)
+}
+
+func TestRegisterDispatcherReplacesNoOp(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	bm.RegisterNoOpDispatcher("noop", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast}, DispatcherOptions{})
+	d := bm.dispatcherMap[bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast)]
+	assert.Nil(t, bm.dispatchHandler(d))
+
+	bm.RegisterDispatcher("transport", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(ctx context.Context, state *DispatchState) error { return nil }, DispatcherOptions{BatchMaxSize: 1})
+	d = bm.dispatcherMap[bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast)]
+	assert.Equal(t, "transport", d.name)
+	assert.Len(t, d.handlers, 1)
+}
+
+func TestFanOutFailureStopsInOrder(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	var calls []string
+	d := &dispatcher{
+		handlers: []DispatchHandler{
+			func(ctx context.Context, state *DispatchState) error {
+				calls = append(calls, "first")
+				return fmt.Errorf("pop")
+			},
+			func(ctx context.Context, state *DispatchState) error {
+				calls = append(calls, "second")
+				return nil
+			},
+		},
+	}
+	err := bm.dispatchHandler(d)(context.Background(), &DispatchState{})
+	assert.Regexp(t, "pop", err)
+	assert.Equal(t, []string{"first"}, calls)
+}
+
+func TestFanOutConcurrent(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	// Both handlers must be running at once, for either to complete
+	var started sync.WaitGroup
+	started.Add(2)
+	handler := func(err error) DispatchHandler {
+		return func(ctx context.Context, state *DispatchState) error {
+			started.Done()
+			started.Wait()
+			return err
+		}
+	}
+	d := &dispatcher{
+		options:  DispatcherOptions{ConcurrentHandlers: true},
+		handlers: []DispatchHandler{handler(nil), handler(fmt.Errorf("pop"))},
+	}
+	err := bm.dispatchHandler(d)(context.Background(), &DispatchState{})
+	assert.Regexp(t, "pop", err)
+
+	started.Add(2)
+	d.handlers = []DispatchHandler{handler(nil), handler(nil)}
+	err = bm.dispatchHandler(d)(context.Background(), &DispatchState{})
+	assert.NoError(t, err)
+}
+
+func TestFanOutRetriesWholeBatch(t *testing.T) {
+	var transportCalls, archiveCalls int
+	cancel, _, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	d := &dispatcher{
+		handlers: []DispatchHandler{
+			func(ctx context.Context, state *DispatchState) error {
+				transportCalls++
+				return nil
+			},
+			func(ctx context.Context, state *DispatchState) error {
+				archiveCalls++
+				if archiveCalls == 1 {
+					return fmt.Errorf("pop")
+				}
+				return nil
+			},
+		},
+	}
+	bp.conf.dispatch = bp.bm.dispatchHandler(d)
+
+	err := bp.dispatchBatch(newTestCloneState())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, transportCalls)
+	assert.Equal(t, 2, archiveCalls)
+}
@@ -0,0 +1,58 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// filterMessage decides if a message read by the sequencer is dispatched now, or filtered out. Returns true if the
+// message is filtered - either skipped, so the offset advances past it, or deferred to be read again later.
+//
+// The filters are applied in order of precedence, and the first to filter the message stops the rest being consulted:
+//
+//  1. Soft-deleted messages are skipped, as their data might no longer be valid
+//  2. Messages whose data fails integrity verification are skipped
+//  3. Messages for a disabled dispatcher are deferred, before consulting any of its callbacks
+//  4. Messages the ConfirmedElsewhere precheck reports as already handled are skipped
+//  5. Messages younger than the MinMessageDwell are deferred
+//  6. Messages behind an earlier message deferred by the ReadinessGate are held, to keep them in order
+//  7. Messages the ReadinessGate reports as not ready are deferred
+//  8. Messages beyond the slow-down page size of a dispatcher signalling slow-down are deferred, along with the
+//     messages of the dispatcher that follow them
+//  9. Messages with the same idempotency key as one already dispatched are skipped
+//
+// The idempotency check is last, as it records the key of the message - so only a message that is about to be
+// dispatched claims its key. Otherwise a deferred message could claim the key, causing another message with the
+// same key to be skipped, even though the deferred message might itself never be dispatched.
+func (bm *batchManager) filterMessage(msg *core.Message, data core.DataArray) bool {
+	// The case expressions are evaluated in order, stopping at the first that is true
+	switch {
+	case bm.skipIfDeleted(msg),
+		bm.skipIfCorrupt(msg, data),
+		bm.deferIfDisabled(msg),
+		bm.skipIfConfirmedElsewhere(msg),
+		bm.deferUntilDwelled(msg),
+		bm.holdBehindDeferred(msg),
+		bm.deferUntilReady(msg),
+		bm.deferIfSlowedDown(msg),
+		bm.isDuplicate(msg):
+		return true
+	default:
+		return false
+	}
+}
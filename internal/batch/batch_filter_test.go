@@ -0,0 +1,122 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestFilterGatedMessageClaimsIdempotencyKeyWhenReady(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	ready := false
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{
+			BatchMaxSize:      1,
+			IdempotencyKey:    tagIdempotencyKey,
+			IdempotencyWindow: time.Minute,
+			ReadinessRecheck:  time.Hour,
+			ReadinessGate: func(msg *core.Message) (bool, error) {
+				return ready, nil
+			},
+		},
+	)
+
+	msg1 := newTestBroadcastMessage(1001)
+	msg1.Header.Tag = "order-12345"
+	msg2 := newTestBroadcastMessage(1002)
+	msg2.Header.Tag = "order-12345"
+
+	// The deferred message does not claim its idempotency key
+	bm.readOffset = 1000
+	assert.True(t, bm.filterMessage(msg1, nil))
+	assert.Equal(t, bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast), bm.deferredSequences[1001])
+	assert.Nil(t, bm.idempotency["utdispatcher"])
+
+	// When read again once ready, it is dispatched rather than skipped as a duplicate - and claims the key
+	ready = true
+	assert.False(t, bm.filterMessage(msg1, nil))
+	assert.True(t, bm.idempotency["utdispatcher"].seen["order-12345"].msgID.Equals(msg1.Header.ID))
+
+	// So a later message with the same key is the duplicate
+	bm.database.(*databasemocks.Plugin).On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+	bm.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, msg2).Return()
+	assert.True(t, bm.filterMessage(msg2, nil))
+	assert.Equal(t, core.MessageStateDuplicate, msg2.State)
+}
+
+func TestFilterDeferredMessageDoesNotBlockIdempotencyKey(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{
+			BatchMaxSize:      1,
+			IdempotencyKey:    tagIdempotencyKey,
+			IdempotencyWindow: time.Minute,
+			ReadinessRecheck:  time.Hour,
+			ReadinessGate: func(msg *core.Message) (bool, error) {
+				return msg.Sequence != 1001, nil
+			},
+		},
+	)
+
+	// A message from another author, with the same key as a deferred message, is not skipped as a duplicate
+	msg1 := newTestBroadcastMessage(1001)
+	msg1.Header.Tag = "order-12345"
+	msg2 := newTestBroadcastMessage(1002)
+	msg2.Header.Tag = "order-12345"
+	msg2.Header.Author = "did:firefly:org/efgh"
+	bm.readOffset = 1000
+	assert.True(t, bm.filterMessage(msg1, nil))
+	assert.False(t, bm.filterMessage(msg2, nil))
+}
+
+func TestFilterDisabledDispatcherCallbacksNotConsulted(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{
+			BatchMaxSize: 1,
+			ConfirmedElsewhere: func(ctx context.Context, msg *core.Message) (bool, error) {
+				assert.Fail(t, "precheck consulted for disabled dispatcher")
+				return false, nil
+			},
+			ReadinessGate: func(msg *core.Message) (bool, error) {
+				assert.Fail(t, "gate consulted for disabled dispatcher")
+				return true, nil
+			},
+		},
+	)
+	bm.EnableDispatcher(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, false)
+
+	assert.True(t, bm.filterMessage(newTestBroadcastMessage(1001), nil))
+	assert.Equal(t, bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast), bm.deferredSequences[1001])
+}
@@ -0,0 +1,107 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// SignalSlowDown is called by a dispatch handler, alongside a successful dispatch, to ask the manager to ease the rate
+// it assembles messages for the dispatcher - until a later dispatch of the same dispatcher completes without it.
+// It is safe to call from concurrent handlers of the same batch.
+func (state *DispatchState) SignalSlowDown() {
+	atomic.StoreInt32(&state.slowDown, 1)
+}
+
+func (state *DispatchState) slowDownSignalled() bool {
+	return atomic.LoadInt32(&state.slowDown) == 1
+}
+
+// setSlowDown records whether the dispatcher of the key most recently signalled slow-down
+func (bm *batchManager) setSlowDown(dispatcherKey string, slowDown bool) {
+	bm.dispatcherMux.Lock()
+	defer bm.dispatcherMux.Unlock()
+	d, ok := bm.dispatcherMap[dispatcherKey]
+	if ok && d.slowDown != slowDown {
+		d.slowDown = slowDown
+		if slowDown {
+			log.L(bm.ctx).Infof("Dispatcher %s signalled slow-down", d.name)
+		} else {
+			log.L(bm.ctx).Infof("Dispatcher %s cleared slow-down", d.name)
+		}
+	}
+}
+
+// deferIfSlowedDown limits the messages assembled for a dispatcher signalling slow-down to its SlowDownPageSize in
+// each page read, and defers the rest to be read again after its SlowDownPollDelay - so the reads for the other
+// dispatchers are unaffected. Once a message of the dispatcher is deferred, the messages that follow it are held
+// too, until it is read again, so they stay in order. Only the sequencer calls this, so no locking is required.
+func (bm *batchManager) deferIfSlowedDown(msg *core.Message) bool {
+	dispatcherKey := bm.getDispatcherKey(msg.Header.TxType, msg.Header.Type)
+	bm.dispatcherMux.Lock()
+	d, ok := bm.dispatcherMap[dispatcherKey]
+	slowDown := ok && d.slowDown && d.options.SlowDownPageSize > 0
+	var pageSize uint64
+	var pollDelay time.Duration
+	if ok {
+		pageSize, pollDelay = d.options.SlowDownPageSize, d.options.SlowDownPollDelay
+	}
+	bm.dispatcherMux.Unlock()
+	if !ok {
+		return false
+	}
+
+	if deferredSeq, held := bm.slowDownHolds[d]; held {
+		if msg.Sequence > deferredSeq {
+			log.L(bm.ctx).Debugf("Holding message %s (seq=%d) behind message seq=%d deferred for slow-down", msg.Header.ID, msg.Sequence, deferredSeq)
+			return true
+		}
+		// This is the deferred message (or an earlier one) being read again
+		delete(bm.slowDownHolds, d)
+	}
+	if !slowDown {
+		return false
+	}
+	bm.slowDownAssembled[d]++
+	if bm.slowDownAssembled[d] <= pageSize {
+		return false
+	}
+
+	if pollDelay <= 0 {
+		pollDelay = bm.messagePollTimeout
+	}
+	log.L(bm.ctx).Debugf("Deferring message %s (seq=%d) for %s while dispatcher %s signals slow-down", msg.Header.ID, msg.Sequence, pollDelay, d.name)
+	bm.deferForRecheck(msg.Sequence, dispatcherKey, pollDelay)
+	bm.slowDownHolds[d] = msg.Sequence
+	return true
+}
+
+// resetSlowDownPage is called before each page is read, to start counting the messages assembled for each dispatcher
+// signalling slow-down afresh, and to drop the holds of any deferred messages the read offset has been rewound to
+// before - as they will be read again, and deferred again if the dispatcher is still signalling slow-down
+func (bm *batchManager) resetSlowDownPage() {
+	bm.slowDownAssembled = make(map[*dispatcher]uint64)
+	for d, deferredSeq := range bm.slowDownHolds {
+		if deferredSeq > bm.readOffset {
+			delete(bm.slowDownHolds, d)
+		}
+	}
+}
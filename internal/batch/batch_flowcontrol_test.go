@@ -0,0 +1,166 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSlowDownDefersDispatcherUntilCleared(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	pinned := make(chan *DispatchState, 3)
+	signals := []bool{true, false, false}
+	bm.RegisterDispatcher("utpinned", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			var signal bool
+			signal, signals = signals[0], signals[1:]
+			if signal {
+				state.SignalSlowDown()
+			}
+			pinned <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second, SlowDownPageSize: 1, SlowDownPollDelay: 10 * time.Millisecond},
+	)
+	unpinned := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utunpinned", core.TransactionTypeUnpinned, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			unpinned <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+
+	msg1 := newTestBroadcastMessage(1001)
+	msg2 := newTestBroadcastMessage(1002)
+	msg3 := newTestBroadcastMessage(1003)
+	msg3.Header.TxType = core.TransactionTypeUnpinned
+	msg4 := newTestBroadcastMessage(1004)
+	for _, msg := range []*core.Message{msg1, msg2, msg3, msg4} {
+		mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil)
+	}
+
+	// Each read returns the messages available after the offset
+	var msgsMux sync.Mutex
+	msgs := []*core.Message{msg1}
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(func(ctx context.Context, ns string, filter database.Filter) []*core.IDAndSequence {
+		fi, _ := filter.Finalize()
+		var after int64
+		fmt.Sscanf(fi.String(), "( sequence >> %d )", &after)
+		msgsMux.Lock()
+		defer msgsMux.Unlock()
+		ids := []*core.IDAndSequence{}
+		for _, msg := range msgs {
+			if msg.Sequence > after {
+				ids = append(ids, &core.IDAndSequence{ID: *msg.Header.ID, Sequence: msg.Sequence})
+			}
+		}
+		return ids
+	}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, *msg1.Header.ID, *(<-pinned).Messages[0].Header.ID)
+	assert.Eventually(t, func() bool {
+		bm.dispatcherMux.Lock()
+		defer bm.dispatcherMux.Unlock()
+		return bm.dispatcherMap[bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast)].slowDown
+	}, 5*time.Second, time.Millisecond)
+
+	// Only one message of the slowed dispatcher is assembled from the page, while the other dispatcher is unaffected
+	msgsMux.Lock()
+	msgs = append(msgs, msg2, msg3, msg4)
+	msgsMux.Unlock()
+	bm.NewMessages() <- msg4.Sequence
+	assert.Equal(t, *msg2.Header.ID, *(<-pinned).Messages[0].Header.ID)
+	assert.Equal(t, *msg3.Header.ID, *(<-unpinned).Messages[0].Header.ID)
+
+	// The deferred message is read again after the slow-down poll delay, once the signal has cleared
+	assert.Equal(t, *msg4.Header.ID, *(<-pinned).Messages[0].Header.ID)
+	assert.Eventually(t, func() bool {
+		bm.inflightMux.Lock()
+		defer bm.inflightMux.Unlock()
+		return bm.calcCommittableOffset() == 1004
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	bm.WaitStop()
+	assert.False(t, bm.dispatcherMap[bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast)].slowDown)
+}
+
+func TestDeferIfSlowedDownHoldsInOrder(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast, core.MessageTypeDefinition}, nil,
+		DispatcherOptions{BatchMaxSize: 1, SlowDownPageSize: 2})
+	bm.RegisterDispatcher("utignored", core.TransactionTypeUnpinned, []core.MessageType{core.MessageTypeBroadcast}, nil,
+		DispatcherOptions{BatchMaxSize: 1})
+	key := bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast)
+	bm.setSlowDown(key, true)
+	bm.setSlowDown(bm.getDispatcherKey(core.TransactionTypeUnpinned, core.MessageTypeBroadcast), true)
+	bm.setSlowDown(bm.getDispatcherKey(core.TransactionTypeUnpinned, core.MessageTypePrivate), true)
+
+	msg := func(seq int64, msgType core.MessageType, txType core.TransactionType) *core.Message {
+		msg := newTestBroadcastMessage(seq)
+		msg.Header.Type = msgType
+		msg.Header.TxType = txType
+		return msg
+	}
+
+	// The page size is shared by all the types of the dispatcher, and later messages are held behind the deferred one
+	bm.resetSlowDownPage()
+	assert.False(t, bm.deferIfSlowedDown(msg(1001, core.MessageTypeBroadcast, core.TransactionTypeBatchPin)))
+	assert.False(t, bm.deferIfSlowedDown(msg(1002, core.MessageTypeDefinition, core.TransactionTypeBatchPin)))
+	assert.True(t, bm.deferIfSlowedDown(msg(1003, core.MessageTypeBroadcast, core.TransactionTypeBatchPin)))
+	assert.Equal(t, key, bm.deferredSequences[1003])
+	assert.True(t, bm.deferIfSlowedDown(msg(1004, core.MessageTypeDefinition, core.TransactionTypeBatchPin)))
+	assert.NotContains(t, bm.deferredSequences, int64(1004))
+
+	// A dispatcher without a slow-down page size, and a type without a dispatcher, are not slowed
+	assert.False(t, bm.deferIfSlowedDown(msg(1005, core.MessageTypeBroadcast, core.TransactionTypeUnpinned)))
+	assert.False(t, bm.deferIfSlowedDown(msg(1006, core.MessageTypePrivate, core.TransactionTypeUnpinned)))
+
+	// Once the signal clears, the held messages are still held until the deferred message is read again
+	bm.setSlowDown(key, false)
+	bm.readOffset = 1006
+	bm.resetSlowDownPage()
+	assert.True(t, bm.deferIfSlowedDown(msg(1007, core.MessageTypeBroadcast, core.TransactionTypeBatchPin)))
+	assert.False(t, bm.deferIfSlowedDown(msg(1003, core.MessageTypeBroadcast, core.TransactionTypeBatchPin)))
+	assert.False(t, bm.deferIfSlowedDown(msg(1004, core.MessageTypeDefinition, core.TransactionTypeBatchPin)))
+
+	// A rewind to before the deferred message drops its hold
+	bm.setSlowDown(key, true)
+	bm.resetSlowDownPage()
+	bm.slowDownAssembled[bm.dispatcherMap[key]] = 2
+	assert.True(t, bm.deferIfSlowedDown(msg(1008, core.MessageTypeBroadcast, core.TransactionTypeBatchPin)))
+	bm.readOffset = 1007
+	bm.resetSlowDownPage()
+	assert.Empty(t, bm.slowDownHolds)
+}
@@ -0,0 +1,40 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+
+	"github.com/hyperledger/firefly-common/pkg/log"
+)
+
+// markFlush flags the pending dispatch of a message with Flush set in its header, so the open batch of its processor
+// is sealed as soon as the message is reached. The message is assembled into the batch it seals, unless the dispatcher
+// excludes flush markers.
+func markFlush(pd *pendingDispatch) {
+	if pd.msg.Header.Flush {
+		pd.flush = true
+		pd.flushOnly = pd.processor.conf.ExcludeFlushMarkers
+	}
+}
+
+// sealForFlush asks the processor to seal its open batch, for a flush marker excluded from assembly. The marker is
+// moved past without being assembled, or marked in any way, so the offset can advance beyond it.
+func (bm *batchManager) sealForFlush(ctx context.Context, pd *pendingDispatch) {
+	log.L(ctx).Debugf("Sealing open batch of %s batch processor %s for flush marker %s (seq=%d)", pd.msg.Header.Type, pd.processor.conf.name, pd.msg.Header.ID, pd.msg.Sequence)
+	pd.processor.newWork <- &batchWork{seal: true}
+}
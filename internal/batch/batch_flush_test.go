@@ -0,0 +1,89 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func runFlushMarkerTest(t *testing.T, excludeFlushMarkers bool) (*batchManager, *DispatchState, []*core.Message) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+
+	dispatched := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:        10,
+			BatchMaxBytes:       1024 * 1024,
+			BatchTimeout:        time.Hour,
+			DisposeTimeout:      time.Hour,
+			ExcludeFlushMarkers: excludeFlushMarkers,
+		},
+	)
+
+	msgs := []*core.Message{newTestBroadcastMessage(1001), newTestBroadcastMessage(1002), newTestBroadcastMessage(1003)}
+	msgs[1].Header.Flush = true
+	mockMessagePage(mdi, mdm, msgs...)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	// The batch is sealed at the flush marker, leaving the message after it in the open batch
+	state := <-dispatched
+	assert.Eventually(t, func() bool {
+		bm.inflightMux.Lock()
+		defer bm.inflightMux.Unlock()
+		return bm.highestReadOffset == 1003
+	}, 5*time.Second, time.Millisecond)
+	select {
+	case <-dispatched:
+		assert.Fail(t, "message after the flush marker was dispatched")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel()
+	bm.WaitStop()
+	return bm, state, msgs
+}
+
+func TestFlushMarkerSealsBatch(t *testing.T) {
+	bm, state, msgs := runFlushMarkerTest(t, false)
+	assert.Len(t, state.Messages, 2)
+	assert.Equal(t, msgs[0].Header.ID, state.Messages[0].Header.ID)
+	assert.Equal(t, msgs[1].Header.ID, state.Messages[1].Header.ID)
+	assert.Equal(t, int64(1), bm.DispatcherStats()[core.MessageTypeBroadcast].TotalBatches)
+}
+
+func TestFlushMarkerExcluded(t *testing.T) {
+	bm, state, msgs := runFlushMarkerTest(t, true)
+	assert.Len(t, state.Messages, 1)
+	assert.Equal(t, msgs[0].Header.ID, state.Messages[0].Header.ID)
+	_, inflight := bm.inflightSequences[msgs[1].Sequence]
+	assert.False(t, inflight)
+}
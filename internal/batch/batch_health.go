@@ -0,0 +1,68 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+)
+
+// IsHealthy reports whether the batch manager is alive and making progress, for use in liveness and readiness
+// probes. It is unhealthy once stopped, if the sequencer has not completed a poll cycle within the staleness
+// window, or if reading messages from the database has failed repeatedly. A paused manager is healthy.
+func (bm *batchManager) IsHealthy() (bool, error) {
+	select {
+	case <-bm.done:
+		return false, i18n.NewError(bm.ctx, coremsgs.MsgBatchManagerStopped)
+	default:
+	}
+
+	paused := bm.isPaused()
+	bm.healthMux.Lock()
+	defer bm.healthMux.Unlock()
+	if bm.healthMaxReadFailures > 0 && bm.readFailures >= bm.healthMaxReadFailures {
+		return false, i18n.NewError(bm.ctx, coremsgs.MsgBatchManagerReadFailing, bm.readFailures, bm.lastReadError)
+	}
+	if bm.healthStaleness > 0 && !paused {
+		if sinceProgress := time.Since(bm.lastProgress); sinceProgress > bm.healthStaleness {
+			return false, i18n.NewError(bm.ctx, coremsgs.MsgBatchManagerStalled, sinceProgress)
+		}
+	}
+	return true, nil
+}
+
+// recordProgress is called by the sequencer each time it completes a poll cycle
+func (bm *batchManager) recordProgress() {
+	bm.healthMux.Lock()
+	defer bm.healthMux.Unlock()
+	bm.lastProgress = time.Now()
+}
+
+// recordReadResult counts consecutive failures to read messages, resetting on success
+func (bm *batchManager) recordReadResult(err error) {
+	bm.healthMux.Lock()
+	defer bm.healthMux.Unlock()
+	if err != nil {
+		bm.readFailures++
+		bm.lastReadError = err
+	} else {
+		bm.readFailures = 0
+		bm.lastReadError = nil
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly/internal/coreconfig"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestIsHealthyStaleness(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	healthy, err := bm.IsHealthy()
+	assert.True(t, healthy)
+	assert.NoError(t, err)
+
+	bm.lastProgress = time.Now().Add(-bm.healthStaleness - time.Second)
+	healthy, err = bm.IsHealthy()
+	assert.False(t, healthy)
+	assert.Regexp(t, "FF10438", err)
+
+	// Time spent paused is not counted
+	bm.setPaused(true)
+	healthy, err = bm.IsHealthy()
+	assert.True(t, healthy)
+	assert.NoError(t, err)
+	bm.setPaused(false)
+	healthy, err = bm.IsHealthy()
+	assert.True(t, healthy)
+	assert.NoError(t, err)
+
+	bm.healthStaleness = 0
+	bm.lastProgress = time.Time{}
+	healthy, _ = bm.IsHealthy()
+	assert.True(t, healthy)
+}
+
+func TestIsHealthySequencerProgress(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	bm.lastProgress = time.Now().Add(-bm.healthStaleness - time.Second)
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		healthy, _ := bm.IsHealthy()
+		return healthy
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	bm.WaitStop()
+	healthy, err := bm.IsHealthy()
+	assert.False(t, healthy)
+	assert.Regexp(t, "FF10437", err)
+}
+
+func TestIsHealthyReadFailures(t *testing.T) {
+	testConfigReset()
+	defer coreconfig.Reset()
+	config.Set(coreconfig.BatchRetryInitDelay, "1ms")
+	config.Set(coreconfig.BatchRetryMaxDelay, "1ms")
+	config.Set(coreconfig.BatchManagerHealthMaxReadFailures, 3)
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(nil, fmt.Errorf("pop"))
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	var healthErr error
+	assert.Eventually(t, func() bool {
+		var healthy bool
+		healthy, healthErr = bm.IsHealthy()
+		return !healthy
+	}, 5*time.Second, time.Millisecond)
+	assert.Regexp(t, "FF10439.*pop", healthErr)
+
+	// A successful read resets the count
+	bm.recordReadResult(nil)
+	assert.Zero(t, bm.readFailures)
+	assert.Nil(t, bm.lastReadError)
+
+	cancel()
+	bm.WaitStop()
+}
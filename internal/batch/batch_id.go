@@ -0,0 +1,47 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// BatchIDGenerator computes the ID of a batch from its contents, such as deterministically from the hashes of its messages
+type BatchIDGenerator func(batch *core.Batch) *fftypes.UUID
+
+// SetBatchIDGenerator registers a generator for the IDs of batches, replacing the random UUID assigned when assembly
+// of a batch starts. It is called with the assembled batch just before the batch is persisted - on each attempt to
+// seal the batch, as the transaction it is sealed with is also assigned on each attempt.
+func (bm *batchManager) SetBatchIDGenerator(generator BatchIDGenerator) {
+	bm.batchIDMux.Lock()
+	defer bm.batchIDMux.Unlock()
+	bm.batchIDGenerator = generator
+}
+
+// assignBatchID applies any registered generator to assign the ID of the batch being sealed
+func (bp *batchProcessor) assignBatchID(state *DispatchState) {
+	bp.bm.batchIDMux.Lock()
+	generator := bp.bm.batchIDGenerator
+	bp.bm.batchIDMux.Unlock()
+	if generator == nil {
+		return
+	}
+	if id := generator(state.Persisted.GenInflight(state.Messages, state.Data)); id != nil {
+		state.Persisted.ID = id
+	}
+}
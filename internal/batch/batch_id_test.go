@@ -0,0 +1,86 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/mocks/identitymanagermocks"
+	"github.com/hyperledger/firefly/mocks/txcommonmocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func testBatchIDFromMessages(batch *core.Batch) *fftypes.UUID {
+	h := sha256.New()
+	for _, msg := range batch.Payload.Messages {
+		h.Write(msg.Hash[:])
+	}
+	var id fftypes.UUID
+	copy(id[:], h.Sum(nil))
+	return &id
+}
+
+func TestBatchIDGeneratorAssignsIDOnFlush(t *testing.T) {
+	dispatched := make(chan *DispatchState, 1)
+	cancel, mdi, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		dispatched <- state
+		return nil
+	})
+	defer cancel()
+	bp.conf.txType = core.TransactionTypeUnpinned
+	bp.bm.SetBatchIDGenerator(testBatchIDFromMessages)
+	mockRunAsGroupPassthrough(mdi)
+	bp.bm.identity.(*identitymanagermocks.Manager).On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
+	bp.txHelper.(*txcommonmocks.Helper).On("SubmitNewTransaction", mock.Anything, core.TransactionTypeUnpinned).Return(fftypes.NewUUID(), nil)
+	bp.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+	msg := newTestBroadcastMessage(1001)
+	msg.Hash = fftypes.NewRandB32()
+	expectedID := testBatchIDFromMessages(&core.Batch{Payload: core.BatchPayload{Messages: []*core.Message{msg}}})
+	mdi.On("UpsertBatch", mock.Anything, mock.MatchedBy(func(batch *core.BatchPersisted) bool {
+		return batch.ID.Equals(expectedID)
+	})).Return(nil)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("InsertEvent", mock.Anything, mock.Anything).Return(nil)
+
+	bp.addWork(&batchWork{msg: msg})
+
+	err := bp.flush(false, flushTriggerSize)
+	assert.NoError(t, err)
+
+	state := <-dispatched
+	assert.Equal(t, *expectedID, *state.Persisted.ID)
+	assert.Regexp(t, expectedID.String(), state.Persisted.Manifest.String())
+	assert.Equal(t, *expectedID, *msg.BatchID)
+	mdi.AssertExpectations(t)
+}
+
+func TestBatchIDGeneratorNilKeepsAssignedID(t *testing.T) {
+	cancel, _, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	bp.bm.SetBatchIDGenerator(func(batch *core.Batch) *fftypes.UUID { return nil })
+
+	id := fftypes.NewUUID()
+	state := &DispatchState{Persisted: core.BatchPersisted{BatchHeader: core.BatchHeader{ID: id}}}
+	bp.assignBatchID(state)
+	assert.Equal(t, id, state.Persisted.ID)
+}
@@ -0,0 +1,72 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// skipIfCorrupt returns true if data hash verification is enabled, and the data retrieved for the message does not
+// match the hashes it references. The message is counted as an integrity error, and skipped from batching rather than
+// dispatching corrupt data - like a soft-deleted message, it still counts as read, so the offset advances past it.
+func (bm *batchManager) skipIfCorrupt(msg *core.Message, data core.DataArray) bool {
+	if !bm.verifyDataHash || bm.lazyData {
+		return false
+	}
+	if err := verifyMessageData(bm.ctx, msg, data); err != nil {
+		atomic.AddInt64(&bm.integrityErrors, 1)
+		log.L(bm.ctx).Errorf("Skipping message %s (seq=%d) that failed data integrity verification: %s", msg.Header.ID, msg.Sequence, err)
+		return true
+	}
+	return false
+}
+
+// verifyMessageData checks both the hash recorded on each data item, and the hash recomputed from its value and blob
+// reference, against the hash in the data reference of the message
+func verifyMessageData(ctx context.Context, msg *core.Message, data core.DataArray) error {
+	dataByID := make(map[fftypes.UUID]*core.Data, len(data))
+	for _, d := range data {
+		dataByID[*d.ID] = d
+	}
+	for _, ref := range msg.Data {
+		d := dataByID[*ref.ID]
+		if d == nil || !d.Hash.Equals(ref.Hash) {
+			return i18n.NewError(ctx, coremsgs.MsgBatchDataHashMismatch, ref.ID, msg.Header.ID, ref.Hash)
+		}
+		// Computed on a copy, as the data is shared with the cache
+		check := *d
+		hash, err := check.CalcHash(ctx)
+		if err != nil {
+			return err
+		}
+		if !hash.Equals(ref.Hash) {
+			return i18n.NewError(ctx, coremsgs.MsgBatchDataHashMismatch, ref.ID, msg.Header.ID, ref.Hash)
+		}
+	}
+	return nil
+}
+
+func (bm *batchManager) integrityStatus(status *ManagerStatus) {
+	status.IntegrityErrors = atomic.LoadInt64(&bm.integrityErrors)
+}
@@ -0,0 +1,110 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestMessageWithData(seq int64, value string) (*core.Message, *core.Data) {
+	msg := newTestBroadcastMessage(seq)
+	data := &core.Data{ID: fftypes.NewUUID(), Value: fftypes.JSONAnyPtr(value)}
+	data.Hash = data.Value.Hash()
+	msg.Data = core.DataRefs{{ID: data.ID, Hash: data.Hash}}
+	return msg, data
+}
+
+func TestCorruptMessagesSkipped(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.verifyDataHash = true
+	mdm := bm.data.(*datamocks.Manager)
+	bm.RegisterNoOpDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast}, DispatcherOptions{BatchMaxSize: 10})
+
+	good, goodData := newTestMessageWithData(1001, `{"value":1}`)
+	corrupt, corruptData := newTestMessageWithData(1002, `{"value":2}`)
+	corruptData.Value = fftypes.JSONAnyPtr(`{"value":3}`)
+	mdm.On("GetMessageWithDataCached", mock.Anything, good.Header.ID).Return(good, core.DataArray{goodData}, true, nil)
+	mdm.On("GetMessageWithDataCached", mock.Anything, corrupt.Header.ID).Return(corrupt, core.DataArray{corruptData}, true, nil)
+
+	pending, prepared := bm.preparePage(bm.ctx, []*core.IDAndSequence{
+		{ID: *good.Header.ID, Sequence: good.Sequence},
+		{ID: *corrupt.Header.ID, Sequence: corrupt.Sequence},
+	}, 1000, time.Time{})
+	assert.Equal(t, 2, prepared)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, good.Header.ID, pending[0].msg.Header.ID)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&bm.integrityErrors))
+	assert.Empty(t, bm.assemblyFailures)
+}
+
+func TestSkipIfCorruptDisabled(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	msg, data := newTestMessageWithData(1001, `{"value":1}`)
+	data.Hash = fftypes.NewRandB32()
+	assert.False(t, bm.skipIfCorrupt(msg, core.DataArray{data}))
+
+	// Only the references to the data are assembled with lazy data, so there is nothing to verify
+	bm.verifyDataHash = true
+	bm.lazyData = true
+	assert.False(t, bm.skipIfCorrupt(msg, nil))
+
+	bm.lazyData = false
+	assert.True(t, bm.skipIfCorrupt(msg, core.DataArray{data}))
+	status := &ManagerStatus{}
+	bm.integrityStatus(status)
+	assert.Equal(t, int64(1), status.IntegrityErrors)
+}
+
+func TestVerifyMessageData(t *testing.T) {
+	ctx := context.Background()
+	msg, data := newTestMessageWithData(1001, `{"value":1}`)
+	assert.NoError(t, verifyMessageData(ctx, msg, core.DataArray{data}))
+
+	// Data missing from those retrieved
+	assert.Regexp(t, "FF10447", verifyMessageData(ctx, msg, core.DataArray{}))
+
+	// Hash recorded on the data does not match the message
+	mismatched := *data
+	mismatched.Hash = fftypes.NewRandB32()
+	assert.Regexp(t, "FF10447", verifyMessageData(ctx, msg, core.DataArray{&mismatched}))
+
+	// Hash cannot be computed
+	noValue := *data
+	noValue.Value = nil
+	assert.Regexp(t, "FF00", verifyMessageData(ctx, msg, core.DataArray{&noValue}))
+	assert.Nil(t, noValue.Value)
+
+	// Blob reference changed
+	blobMsg, blobData := newTestMessageWithData(1002, `null`)
+	blobData.Blob = &core.BlobRef{Hash: fftypes.NewRandB32()}
+	blobData.Hash = blobData.Blob.Hash
+	blobMsg.Data[0].Hash = blobData.Hash
+	assert.NoError(t, verifyMessageData(ctx, blobMsg, core.DataArray{blobData}))
+	blobData.Blob = &core.BlobRef{Hash: fftypes.NewRandB32()}
+	assert.Regexp(t, "FF10447", verifyMessageData(ctx, blobMsg, core.DataArray{blobData}))
+}
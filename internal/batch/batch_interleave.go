@@ -0,0 +1,117 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/internal/coreconfig"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+const (
+	// interleavePolicySequence dispatches each page of messages strictly in sequence order
+	interleavePolicySequence = "sequence"
+	// interleavePolicyRoundRobin dispatches one message of each message type in turn
+	interleavePolicyRoundRobin = "roundRobin"
+	// interleavePolicyWeighted is round-robin, but each message type dispatches up to its weight of messages per turn
+	interleavePolicyWeighted = "weighted"
+)
+
+func interleaveConfig(ctx context.Context) (policy string, weights map[core.MessageType]int) {
+	policy = config.GetString(coreconfig.BatchManagerInterleavePolicy)
+	if policy == interleavePolicyWeighted {
+		conf := config.GetObject(coreconfig.BatchManagerInterleaveWeights)
+		weights = make(map[core.MessageType]int, len(conf))
+		for msgType, v := range conf {
+			// Values might be strings or numbers, depending on the source of the configuration
+			weight, err := strconv.Atoi(fmt.Sprintf("%v", v))
+			if err != nil {
+				log.L(ctx).Warnf("Ignoring invalid interleave weight '%v' for message type '%s'", v, msgType)
+				continue
+			}
+			weights[core.MessageType(msgType)] = weight
+		}
+	}
+	return policy, weights
+}
+
+func (bm *batchManager) interleaveWeight(msgType core.MessageType) int {
+	if weight := bm.interleaveWeights[msgType]; weight > 1 {
+		return weight
+	}
+	return 1
+}
+
+// interleaveByType re-orders a page of messages so that dispatch alternates between the message types in the page,
+// according to the interleave policy - so a burst of one type does not hold up the dispatch of another type behind it.
+// Messages of each type stay in order, and a message is only pulled forward past earlier messages of other types
+// that share none of its topics, so the ordering constraints on each topic are preserved.
+func (bm *batchManager) interleaveByType(pending []*pendingDispatch) []*pendingDispatch {
+	if bm.interleavePolicy != interleavePolicyRoundRobin && bm.interleavePolicy != interleavePolicyWeighted {
+		return pending
+	}
+
+	// Queue the index of each message by type, with the types in the order first seen in the page
+	var msgTypes []core.MessageType
+	queues := make(map[core.MessageType][]int)
+	for i, pd := range pending {
+		msgType := pd.msg.Header.Type
+		if _, ok := queues[msgType]; !ok {
+			msgTypes = append(msgTypes, msgType)
+		}
+		queues[msgType] = append(queues[msgType], i)
+	}
+	if len(msgTypes) < 2 {
+		return pending
+	}
+
+	dispatched := make([]bool, len(pending))
+	ordered := make([]*pendingDispatch, 0, len(pending))
+	for len(ordered) < len(pending) {
+		// The earliest message not yet dispatched is never held back, so every turn makes progress
+		for _, msgType := range msgTypes {
+			queue := queues[msgType]
+			for n := 0; n < bm.interleaveWeight(msgType) && len(queue) > 0 && !heldBackByTopic(pending, dispatched, queue[0]); n++ {
+				ordered = append(ordered, pending[queue[0]])
+				dispatched[queue[0]] = true
+				queue = queue[1:]
+			}
+			queues[msgType] = queue
+		}
+	}
+	return ordered
+}
+
+// heldBackByTopic returns true if an earlier message, of another type, that shares a topic with the message at the
+// index has not yet been dispatched
+func heldBackByTopic(pending []*pendingDispatch, dispatched []bool, idx int) bool {
+	topics := make(map[string]bool)
+	for _, topic := range pending[idx].msg.Header.Topics {
+		topics[topic] = true
+	}
+	for i := 0; i < idx; i++ {
+		if !dispatched[i] && pending[i].msg.Header.Type != pending[idx].msg.Header.Type && sharesTopic(pending[i].msg, topics) {
+			return true
+		}
+	}
+	return false
+}
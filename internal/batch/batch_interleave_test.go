@@ -0,0 +1,117 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/internal/coreconfig"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func newInterleavePending(seq int64, msgType core.MessageType, topic string) *pendingDispatch {
+	return &pendingDispatch{
+		msg: &core.Message{
+			Header:   core.MessageHeader{ID: fftypes.NewUUID(), Type: msgType, Topics: core.FFStringArray{topic}},
+			Sequence: seq,
+		},
+	}
+}
+
+func interleavedSequences(ordered []*pendingDispatch) []int64 {
+	sequences := make([]int64, len(ordered))
+	for i, pd := range ordered {
+		sequences[i] = pd.msg.Sequence
+	}
+	return sequences
+}
+
+func newInterleavePage() []*pendingDispatch {
+	return []*pendingDispatch{
+		newInterleavePending(1, core.MessageTypeBroadcast, "topic1"),
+		newInterleavePending(2, core.MessageTypeBroadcast, "topic2"),
+		newInterleavePending(3, core.MessageTypeBroadcast, "topic3"),
+		newInterleavePending(4, core.MessageTypeBroadcast, "topic4"),
+		newInterleavePending(5, core.MessageTypePrivate, "topic5"),
+		newInterleavePending(6, core.MessageTypePrivate, "topic6"),
+		newInterleavePending(7, core.MessageTypePrivate, "topic7"),
+	}
+}
+
+func TestInterleaveDefaultSequenceOrder(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	assert.Equal(t, interleavePolicySequence, bm.interleavePolicy)
+
+	ordered := bm.interleaveByType(newInterleavePage())
+	assert.Equal(t, []int64{1, 2, 3, 4, 5, 6, 7}, interleavedSequences(ordered))
+}
+
+func TestInterleaveRoundRobin(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.interleavePolicy = interleavePolicyRoundRobin
+
+	ordered := bm.interleaveByType(newInterleavePage())
+	assert.Equal(t, []int64{1, 5, 2, 6, 3, 7, 4}, interleavedSequences(ordered))
+}
+
+func TestInterleaveWeighted(t *testing.T) {
+	coreconfig.Reset()
+	defer coreconfig.Reset()
+	config.Set(coreconfig.BatchManagerInterleavePolicy, interleavePolicyWeighted)
+	config.Set(coreconfig.BatchManagerInterleaveWeights, map[string]interface{}{"private": 2, "broadcast": "invalid"})
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	assert.Equal(t, 2, bm.interleaveWeight(core.MessageTypePrivate))
+	assert.Equal(t, 1, bm.interleaveWeight(core.MessageTypeBroadcast))
+
+	ordered := bm.interleaveByType(newInterleavePage())
+	assert.Equal(t, []int64{1, 5, 6, 2, 7, 3, 4}, interleavedSequences(ordered))
+}
+
+func TestInterleavePreservesTopicOrder(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.interleavePolicy = interleavePolicyRoundRobin
+
+	// Message 5 shares a topic with message 3, so cannot be pulled forward before it
+	pending := []*pendingDispatch{
+		newInterleavePending(1, core.MessageTypeBroadcast, "topic1"),
+		newInterleavePending(2, core.MessageTypeBroadcast, "topic2"),
+		newInterleavePending(3, core.MessageTypeBroadcast, "shared"),
+		newInterleavePending(4, core.MessageTypeBroadcast, "topic4"),
+		newInterleavePending(5, core.MessageTypePrivate, "shared"),
+		newInterleavePending(6, core.MessageTypePrivate, "topic6"),
+	}
+	ordered := bm.interleaveByType(pending)
+	assert.Equal(t, []int64{1, 2, 3, 5, 4, 6}, interleavedSequences(ordered))
+}
+
+func TestInterleaveSingleType(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.interleavePolicy = interleavePolicyRoundRobin
+
+	pending := newInterleavePage()[0:4]
+	ordered := bm.interleaveByType(pending)
+	assert.Equal(t, []int64{1, 2, 3, 4}, interleavedSequences(ordered))
+}
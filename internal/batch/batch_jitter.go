@@ -0,0 +1,33 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import "time"
+
+// jitteredPollTimeout is the poll timeout, randomly brought forward or delayed by up to the configured fraction of
+// itself - so that several nodes polling the same database, or nodes restarted together, do not poll in step
+func (bm *batchManager) jitteredPollTimeout() time.Duration {
+	jitter := bm.messagePollJitter
+	if jitter <= 0 {
+		return bm.messagePollTimeout
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	offset := float64(bm.messagePollTimeout) * jitter * (2*bm.jitterRand() - 1)
+	return bm.messagePollTimeout + time.Duration(offset)
+}
@@ -0,0 +1,86 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly/internal/coreconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJitteredPollTimeout(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.messagePollTimeout = 10 * time.Second
+
+	tests := []struct {
+		jitter   float64
+		rand     float64
+		expected time.Duration
+	}{
+		{jitter: 0, rand: 0, expected: 10 * time.Second},
+		{jitter: -0.5, rand: 0, expected: 10 * time.Second},
+		{jitter: 0.2, rand: 0, expected: 8 * time.Second},
+		{jitter: 0.2, rand: 0.5, expected: 10 * time.Second},
+		{jitter: 0.2, rand: 0.75, expected: 11 * time.Second},
+		{jitter: 5, rand: 0, expected: 0},
+	}
+	for _, test := range tests {
+		bm.messagePollJitter = test.jitter
+		bm.jitterRand = func() float64 { return test.rand }
+		assert.Equal(t, test.expected, bm.jitteredPollTimeout(), "jitter=%f rand=%f", test.jitter, test.rand)
+	}
+}
+
+func TestJitteredPollTimeoutDefault(t *testing.T) {
+	testConfigReset()
+	config.Set(coreconfig.BatchManagerReadPollTimeout, "10s")
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	assert.Equal(t, 0.1, bm.messagePollJitter)
+	for i := 0; i < 100; i++ {
+		timeout := bm.jitteredPollTimeout()
+		assert.GreaterOrEqual(t, timeout, 9*time.Second)
+		assert.Less(t, timeout, 11*time.Second)
+	}
+}
+
+func TestWaitForNewMessagesJittered(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	clock := newFakeClock()
+	bm.SetClock(clock)
+	bm.minimumPollDelay = time.Second
+	bm.messagePollTimeout = time.Minute
+	bm.messagePollJitter = 0.5
+	bm.jitterRand = func() float64 { return 1 }
+
+	// The poll is delayed by the jitter, after the minimum poll delay
+	done := make(chan bool)
+	go func() {
+		done <- bm.waitForNewMessages()
+	}()
+	assert.Eventually(t, func() bool { return clock.hasTimer(time.Second) }, 5*time.Second, time.Millisecond)
+	clock.Advance(time.Second)
+	assert.Eventually(t, func() bool { return clock.hasTimer(90*time.Second - time.Second) }, 5*time.Second, time.Millisecond)
+	clock.Advance(90*time.Second - time.Second)
+	assert.False(t, <-done)
+}
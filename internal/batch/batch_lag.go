@@ -0,0 +1,80 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/database"
+)
+
+// currentOffset returns the committed offset, or where the offset is not persisted the offset that would be committed
+func (bm *batchManager) currentOffset() int64 {
+	if bm.offsetEnabled {
+		bm.offsetCommitMux.Lock()
+		defer bm.offsetCommitMux.Unlock()
+		return bm.committedOffset
+	}
+	bm.inflightMux.Lock()
+	defer bm.inflightMux.Unlock()
+	return bm.calcCommittableOffset()
+}
+
+// highestSequence queries the sequence of the newest message. If the query fails, the highest sequence read is returned.
+func (bm *batchManager) highestSequence() int64 {
+	fb := database.MessageQueryFactory.NewFilter(bm.ctx)
+	ids, err := bm.database.GetMessageIDs(bm.ctx, bm.namespace, fb.And().Sort("sequence").Descending().Limit(1))
+	if err == nil && len(ids) > 0 {
+		return ids[0].Sequence
+	}
+	if err != nil {
+		log.L(bm.ctx).Warnf("Failed to query the highest message sequence: %s", err)
+	}
+	bm.inflightMux.Lock()
+	defer bm.inflightMux.Unlock()
+	return bm.highestReadOffset
+}
+
+// openBatches counts the processors of each dispatcher that hold messages which have been read, but not yet flushed
+func (bm *batchManager) openBatches() map[string]int {
+	bm.inflightMux.Lock()
+	defer bm.inflightMux.Unlock()
+	flushed := make(map[int64]bool, len(bm.inflightFlushed))
+	for _, seq := range bm.inflightFlushed {
+		flushed[seq] = true
+	}
+	open := make(map[*batchProcessor]bool)
+	for seq, processor := range bm.inflightSequences {
+		if !flushed[seq] {
+			open[processor] = true
+		}
+	}
+	counts := make(map[string]int)
+	for processor := range open {
+		counts[processor.conf.dispatcherName]++
+	}
+	return counts
+}
+
+// lagStatus adds the position of the sequencer relative to the newest message to the status
+func (bm *batchManager) lagStatus(status *ManagerStatus) {
+	status.Offset = bm.currentOffset()
+	status.HighestSequence = bm.highestSequence()
+	if status.HighestSequence > status.Offset {
+		status.Lag = status.HighestSequence - status.Offset
+	}
+	status.OpenBatches = bm.openBatches()
+}
@@ -0,0 +1,88 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestStatusLag(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.MatchedBy(func(f database.Filter) bool {
+		fi, err := f.Finalize()
+		assert.NoError(t, err)
+		return fi.Limit == 1 && len(fi.Sort) == 1 && fi.Sort[0].Field == "sequence" && fi.Sort[0].Descending
+	})).Return([]*core.IDAndSequence{{ID: *fftypes.NewUUID(), Sequence: 1050}}, nil)
+
+	bm.offsetEnabled = true
+	bm.committedOffset = 1000
+	broadcast1 := &batchProcessor{conf: &batchProcessorConf{dispatcherName: "broadcast"}}
+	broadcast2 := &batchProcessor{conf: &batchProcessorConf{dispatcherName: "broadcast"}}
+	private := &batchProcessor{conf: &batchProcessorConf{dispatcherName: "private"}}
+	bm.inflightSequences[1001] = broadcast1
+	bm.inflightSequences[1002] = broadcast1
+	bm.inflightSequences[1003] = broadcast2
+	bm.inflightSequences[1004] = private
+	bm.inflightFlushed = []int64{1004}
+
+	status := bm.Status()
+	assert.Equal(t, int64(1000), status.Offset)
+	assert.Equal(t, int64(1050), status.HighestSequence)
+	assert.Equal(t, int64(50), status.Lag)
+	assert.Equal(t, map[string]int{"broadcast": 2}, status.OpenBatches)
+}
+
+func TestStatusLagQueryFail(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(nil, fmt.Errorf("pop"))
+
+	// Without a persisted offset, the committable offset is reported - and the highest read if the query fails
+	bm.offsetEnabled = false
+	bm.highestReadOffset = 1010
+	bm.inflightSequences[1005] = &batchProcessor{conf: &batchProcessorConf{dispatcherName: "broadcast"}}
+
+	status := bm.Status()
+	assert.Equal(t, int64(1004), status.Offset)
+	assert.Equal(t, int64(1010), status.HighestSequence)
+	assert.Equal(t, int64(6), status.Lag)
+}
+
+func TestStatusNoMessages(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	bm.offsetEnabled = true
+	bm.committedOffset = -1
+	status := bm.Status()
+	assert.Equal(t, int64(-1), status.HighestSequence)
+	assert.Zero(t, status.Lag)
+	assert.Empty(t, status.OpenBatches)
+}
@@ -0,0 +1,79 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/log"
+)
+
+// BatchLatency is a breakdown of where the time went for a dispatched batch, from the first message
+// being added to its assembly, through to the dispatch being committed to the database
+type BatchLatency struct {
+	Assembly time.Duration // first message added, until the flush started
+	Queued   time.Duration // flush started, until the dispatch handler was called - sealing, and waiting for a dispatch slot
+	Handler  time.Duration // in the dispatch handler, including any retries
+	Commit   time.Duration // dispatch handler returned, until the messages were marked dispatched
+	Total    time.Duration
+}
+
+type latencyMarks struct {
+	assemblyStarted time.Time
+	flushStarted    time.Time
+	handlerStarted  time.Time
+	handlerEnded    time.Time
+}
+
+func (lm *latencyMarks) markHandlerStarted() {
+	if lm != nil {
+		lm.handlerStarted = time.Now()
+	}
+}
+
+func (lm *latencyMarks) markHandlerEnded() {
+	if lm != nil {
+		lm.handlerEnded = time.Now()
+	}
+}
+
+func (lm *latencyMarks) breakdown(committed time.Time) *BatchLatency {
+	assemblyStarted := lm.assemblyStarted
+	if assemblyStarted.IsZero() {
+		assemblyStarted = lm.flushStarted
+	}
+	return &BatchLatency{
+		Assembly: lm.flushStarted.Sub(assemblyStarted),
+		Queued:   lm.handlerStarted.Sub(lm.flushStarted),
+		Handler:  lm.handlerEnded.Sub(lm.handlerStarted),
+		Commit:   committed.Sub(lm.handlerEnded),
+		Total:    committed.Sub(assemblyStarted),
+	}
+}
+
+// reportLatency passes the latency breakdown of a committed batch to the LatencyHandler of the dispatcher
+func (bp *batchProcessor) reportLatency(ctx context.Context, id *fftypes.UUID, lm *latencyMarks) {
+	if lm == nil || bp.conf.LatencyHandler == nil {
+		return
+	}
+	latency := lm.breakdown(time.Now())
+	log.L(ctx).Debugf("Batch %s latency total=%s assembly=%s queued=%s handler=%s commit=%s",
+		id, latency.Total, latency.Assembly, latency.Queued, latency.Handler, latency.Commit)
+	bp.conf.LatencyHandler(ctx, id, latency)
+}
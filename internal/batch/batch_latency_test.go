@@ -0,0 +1,101 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/mocks/identitymanagermocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestLatencyBreakdownReported(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdm := bm.data.(*datamocks.Manager)
+	mim := bm.identity.(*identitymanagermocks.Manager)
+	mim.On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
+	mdm.On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+	mockRunAsGroupPassthrough(mdi)
+	mdi.On("InsertTransaction", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("InsertEvent", mock.Anything, mock.Anything).Return(nil)
+
+	// Each phase after assembly takes a measurable amount of time
+	phase := 10 * time.Millisecond
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(nil).After(phase)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil).After(phase)
+
+	reported := make(chan *BatchLatency, 1)
+	var reportedID *fftypes.UUID
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeUnpinned, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			time.Sleep(phase)
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:   10,
+			DisposeTimeout: 120 * time.Second,
+			LatencyHandler: func(ctx context.Context, id *fftypes.UUID, latency *BatchLatency) {
+				reportedID = id
+				reported <- latency
+			},
+		},
+	)
+
+	msg := newTestBroadcastMessage(1001)
+	msg.Header.TxType = core.TransactionTypeUnpinned
+	mockMessagePage(mdi, mdm, msg)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	latency := <-reported
+	assert.Equal(t, msg.BatchID, reportedID)
+	assert.Positive(t, latency.Assembly)
+	assert.GreaterOrEqual(t, latency.Queued, phase)
+	assert.GreaterOrEqual(t, latency.Handler, phase)
+	assert.GreaterOrEqual(t, latency.Commit, phase)
+	sum := latency.Assembly + latency.Queued + latency.Handler + latency.Commit
+	assert.InDelta(t, float64(latency.Total), float64(sum), float64(time.Millisecond))
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestLatencyBreakdownNoAssembly(t *testing.T) {
+	now := time.Now()
+	lm := &latencyMarks{flushStarted: now}
+	lm.markHandlerStarted()
+	lm.markHandlerEnded()
+	latency := lm.breakdown(time.Now())
+	assert.Zero(t, latency.Assembly)
+	assert.Equal(t, latency.Total, latency.Queued+latency.Handler+latency.Commit)
+
+	var nilMarks *latencyMarks
+	nilMarks.markHandlerStarted()
+	nilMarks.markHandlerEnded()
+}
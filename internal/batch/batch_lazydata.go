@@ -0,0 +1,80 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/internal/data"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// assembleMessageRefs retrieves the message for lazy data assembly, returning only references to its data. The
+// message is taken from the cache if it is there, but its data values are never loaded.
+func (bm *batchManager) assembleMessageRefs(ctx context.Context, id *fftypes.UUID) (msg *core.Message, refs core.DataArray, err error) {
+	msg, _ = bm.data.PeekMessageCache(bm.assemblyContext(ctx), id)
+	if msg == nil {
+		err = bm.retry.Do(ctx, "retrieve message", func(attempt int) (retry bool, err error) {
+			msg, err = bm.database.GetMessageByID(ctx, bm.namespace, id)
+			return true, err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if msg == nil {
+			return nil, nil, newMessageDataMissingError(ctx, id, nil, nil)
+		}
+	}
+	refs = make(core.DataArray, len(msg.Data))
+	for i, dataRef := range msg.Data {
+		refs[i] = &core.Data{ID: dataRef.ID, Hash: dataRef.Hash}
+	}
+	return msg, refs, nil
+}
+
+// ResolveData returns the data of the batch. When the batch was assembled with lazy data loading, Data holds only
+// references, and the values are loaded on the first call - otherwise this simply returns Data.
+func (state *DispatchState) ResolveData(ctx context.Context) (core.DataArray, error) {
+	if state.lazyData == nil {
+		return state.Data, nil
+	}
+	resolved := make(core.DataArray, 0, len(state.Data))
+	for _, msg := range state.Messages {
+		msgData, foundAll, err := state.lazyData.GetMessageDataCached(ctx, msg)
+		if err != nil {
+			return nil, err
+		}
+		if !foundAll {
+			return nil, newMessageDataMissingError(ctx, msg.Header.ID, msg, msgData)
+		}
+		for _, d := range msgData {
+			resolved = append(resolved, d.BatchData(state.Persisted.Type))
+		}
+	}
+	state.Data = resolved
+	state.lazyData = nil
+	return state.Data, nil
+}
+
+// lazyDataLoader returns the loader for the values of data in batches, when batches are assembled with lazy data
+func (bm *batchManager) lazyDataLoader() data.Manager {
+	if !bm.lazyData {
+		return nil
+	}
+	return bm.data
+}
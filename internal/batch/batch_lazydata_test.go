@@ -0,0 +1,190 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/internal/coreconfig"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestLazyDataMessage(seq int64) *core.Message {
+	msg := newTestBroadcastMessage(seq)
+	msg.Data = core.DataRefs{{ID: fftypes.NewUUID(), Hash: fftypes.NewRandB32()}}
+	return msg
+}
+
+func TestAssembleMessageRefsFromCache(t *testing.T) {
+	testConfigReset()
+	defer coreconfig.Reset()
+	config.Set(coreconfig.BatchManagerLazyData, true)
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	assert.True(t, bm.lazyData)
+	mdm := bm.data.(*datamocks.Manager)
+
+	msg := newTestLazyDataMessage(1001)
+	mdm.On("PeekMessageCache", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{{ID: msg.Data[0].ID, Value: fftypes.JSONAnyPtr(`"big"`)}})
+
+	assembled, refs, err := bm.assembleMessageData(bm.ctx, msg.Header.ID)
+	assert.NoError(t, err)
+	assert.Same(t, msg, assembled)
+	assert.Equal(t, core.DataArray{{ID: msg.Data[0].ID, Hash: msg.Data[0].Hash}}, refs)
+	mdm.AssertNotCalled(t, "GetMessageWithDataCached", mock.Anything, mock.Anything)
+}
+
+func TestAssembleMessageRefsFromDB(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.lazyData = true
+	mdm := bm.data.(*datamocks.Manager)
+	mdi := bm.database.(*databasemocks.Plugin)
+
+	msg := newTestLazyDataMessage(1001)
+	mdm.On("PeekMessageCache", mock.Anything, msg.Header.ID).Return(nil, nil)
+	mdi.On("GetMessageByID", mock.Anything, "ns1", msg.Header.ID).Return(nil, fmt.Errorf("pop")).Once()
+	mdi.On("GetMessageByID", mock.Anything, "ns1", msg.Header.ID).Return(msg, nil)
+
+	assembled, refs, err := bm.assembleMessageData(bm.ctx, msg.Header.ID)
+	assert.NoError(t, err)
+	assert.Same(t, msg, assembled)
+	assert.Equal(t, core.DataArray{{ID: msg.Data[0].ID, Hash: msg.Data[0].Hash}}, refs)
+}
+
+func TestAssembleMessageRefsNotFound(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.lazyData = true
+	mdm := bm.data.(*datamocks.Manager)
+	mdi := bm.database.(*databasemocks.Plugin)
+
+	mdm.On("PeekMessageCache", mock.Anything, mock.Anything).Return(nil, nil)
+	mdi.On("GetMessageByID", mock.Anything, "ns1", mock.Anything).Return(nil, nil)
+
+	_, _, err := bm.assembleMessageData(bm.ctx, fftypes.NewUUID())
+	assert.Regexp(t, "FF10133", err)
+}
+
+func TestAssembleMessageRefsCancelled(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	bm.lazyData = true
+	mdm := bm.data.(*datamocks.Manager)
+	mdi := bm.database.(*databasemocks.Plugin)
+
+	cancel()
+	mdm.On("PeekMessageCache", mock.Anything, mock.Anything).Return(nil, nil)
+	mdi.On("GetMessageByID", mock.Anything, "ns1", mock.Anything).Return(nil, fmt.Errorf("pop"))
+
+	_, _, err := bm.assembleMessageData(bm.ctx, fftypes.NewUUID())
+	assert.Error(t, err)
+}
+
+func TestResolveDataEager(t *testing.T) {
+	state := &DispatchState{Data: core.DataArray{{ID: fftypes.NewUUID(), Value: fftypes.JSONAnyPtr(`"value"`)}}}
+	data, err := state.ResolveData(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, state.Data, data)
+}
+
+func TestResolveDataLazy(t *testing.T) {
+	mdm := &datamocks.Manager{}
+	msg1 := newTestLazyDataMessage(1001)
+	msg2 := newTestLazyDataMessage(1002)
+	data1 := &core.Data{ID: msg1.Data[0].ID, Hash: msg1.Data[0].Hash, Value: fftypes.JSONAnyPtr(`"one"`)}
+	data2 := &core.Data{ID: msg2.Data[0].ID, Hash: msg2.Data[0].Hash, Value: fftypes.JSONAnyPtr(`"two"`)}
+	mdm.On("GetMessageDataCached", mock.Anything, msg1).Return(core.DataArray{data1}, true, nil).Once()
+	mdm.On("GetMessageDataCached", mock.Anything, msg2).Return(core.DataArray{data2}, true, nil).Once()
+
+	state := &DispatchState{
+		Persisted: core.BatchPersisted{BatchHeader: core.BatchHeader{Type: core.BatchTypeBroadcast}},
+		Messages:  []*core.Message{msg1, msg2},
+		Data:      core.DataArray{{ID: data1.ID, Hash: data1.Hash}, {ID: data2.ID, Hash: data2.Hash}},
+		lazyData:  mdm,
+	}
+	data, err := state.ResolveData(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, core.DataArray{data1.BatchData(core.BatchTypeBroadcast), data2.BatchData(core.BatchTypeBroadcast)}, data)
+	assert.Equal(t, data, state.Data)
+
+	// Resolved once only
+	data, err = state.ResolveData(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, data, 2)
+	mdm.AssertExpectations(t)
+}
+
+func TestResolveDataLazyNotFound(t *testing.T) {
+	mdm := &datamocks.Manager{}
+	msg := newTestLazyDataMessage(1001)
+	mdm.On("GetMessageDataCached", mock.Anything, msg).Return(nil, false, nil)
+
+	state := &DispatchState{Messages: []*core.Message{msg}, lazyData: mdm}
+	_, err := state.ResolveData(context.Background())
+	assert.Regexp(t, "FF10133", err)
+}
+
+func TestResolveDataLazyFail(t *testing.T) {
+	mdm := &datamocks.Manager{}
+	msg := newTestLazyDataMessage(1001)
+	mdm.On("GetMessageDataCached", mock.Anything, msg).Return(nil, false, fmt.Errorf("pop"))
+
+	state := &DispatchState{Messages: []*core.Message{msg}, lazyData: mdm}
+	_, err := state.ResolveData(context.Background())
+	assert.Regexp(t, "pop", err)
+}
+
+func TestDispatchLazyData(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.lazyData = true
+
+	dispatched := make(chan core.DataArray, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			assert.Nil(t, state.Data[0].Value)
+			data, err := state.ResolveData(c)
+			dispatched <- data
+			return err
+		},
+		DispatcherOptions{BatchMaxSize: 1, BatchMaxBytes: 1024 * 1024, BatchTimeout: 10 * time.Millisecond, DisposeTimeout: 120 * time.Second},
+	)
+
+	msg := newTestLazyDataMessage(1001)
+	value := &core.Data{ID: msg.Data[0].ID, Hash: msg.Data[0].Hash, Value: fftypes.JSONAnyPtr(`"value"`)}
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{{ID: *msg.Header.ID, Sequence: 1001}}, nil).Once()
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+	mdm.On("PeekMessageCache", mock.Anything, msg.Header.ID).Return(msg, nil)
+	mdm.On("GetMessageDataCached", mock.Anything, mock.Anything).Return(core.DataArray{value}, true, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+	data := <-dispatched
+	assert.Equal(t, core.DataArray{value.BatchData(core.BatchTypeBroadcast)}, data)
+
+	cancel()
+	bm.WaitStop()
+}
@@ -18,7 +18,10 @@ package batch
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"strconv"
 	"sync"
 	"time"
 
@@ -34,14 +37,25 @@ import (
 	"github.com/hyperledger/firefly/internal/txcommon"
 	"github.com/hyperledger/firefly/pkg/core"
 	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-func NewBatchManager(ctx context.Context, ns string, di database.Plugin, dm data.Manager, im identity.Manager, txHelper txcommon.Helper) (Manager, error) {
+// NewBatchManager creates the batch manager for a namespace. The offset name distinguishes the persisted offset of each
+// of several managers running independently in the same namespace, and is used as given. If empty, it defaults to
+// the offset of the namespace.
+func NewBatchManager(ctx context.Context, ns, offsetName string, di database.Plugin, dm data.Manager, im identity.Manager, txHelper txcommon.Helper) (Manager, error) {
+	return newBatchManager(ctx, ns, offsetName, rootTuning(), di, dm, im, txHelper)
+}
+
+func newBatchManager(ctx context.Context, ns, offsetName string, tuning *managerTuning, di database.Plugin, dm data.Manager, im identity.Manager, txHelper txcommon.Helper) (Manager, error) {
 	if di == nil || dm == nil || im == nil {
 		return nil, i18n.NewError(ctx, coremsgs.MsgInitializationNilDepError, "BatchManager")
 	}
 	pCtx, cancelCtx := context.WithCancel(log.WithLogField(ctx, "role", "batchmgr"))
-	readPageSize := config.GetUint(coreconfig.BatchManagerReadPageSize)
+	readPageSize := tuning.readPageSize
+	if offsetName == "" {
+		offsetName = fmt.Sprintf("%s_%s", msgBatchOffsetName, ns)
+	}
 	bm := &batchManager{
 		ctx:                        pCtx,
 		cancelCtx:                  cancelCtx,
@@ -52,36 +66,152 @@ func NewBatchManager(ctx context.Context, ns string, di database.Plugin, dm data
 		txHelper:                   txHelper,
 		readOffset:                 -1, // On restart we trawl for all ready messages
 		readPageSize:               uint64(readPageSize),
-		minimumPollDelay:           config.GetDuration(coreconfig.BatchManagerMinimumPollDelay),
-		messagePollTimeout:         config.GetDuration(coreconfig.BatchManagerReadPollTimeout),
+		minimumPollDelay:           tuning.minimumPollDelay,
+		messagePollTimeout:         tuning.messagePollTimeout,
+		messagePollJitter:          tuning.messagePollJitter,
+		defaultOptions:             tuning.defaultOptions,
+		jitterRand:                 rand.Float64,
+		iterationBudget:            config.GetDuration(coreconfig.BatchManagerIterationBudget),
+		lazyData:                   config.GetBool(coreconfig.BatchManagerLazyData),
+		verifyDataHash:             config.GetBool(coreconfig.BatchManagerVerifyDataHash),
 		startupOffsetRetryAttempts: config.GetInt(coreconfig.OrchestratorStartupAttempts),
+		offsetEnabled:              config.GetBool(coreconfig.BatchManagerOffsetEnabled),
+		offsetCommitAsync:          config.GetBool(coreconfig.BatchManagerOffsetCommitAsync),
+		offsetCommitInterval:       config.GetDuration(coreconfig.BatchManagerOffsetCommitInterval),
+		offsetCommitMessages:       config.GetInt64(coreconfig.BatchManagerOffsetCommitMessages),
+		offsetCompactionInterval:   config.GetDuration(coreconfig.BatchManagerOffsetCompactionInterval),
+		resumeFromLastBatch:        config.GetString(coreconfig.BatchManagerOffsetResumeFrom) == resumeFromLastBatch,
+		offsetAheadCheck:           config.GetString(coreconfig.BatchManagerOffsetAheadCheck),
+		offsetStartupTimeout:       config.GetDuration(coreconfig.BatchManagerOffsetStartupTimeout),
+		recoveryEnabled:            config.GetBool(coreconfig.BatchManagerRecoveryEnabled),
+		onUnknownType:              config.GetString(coreconfig.BatchManagerOnUnknownType),
+		persistDispatcherOptions:   config.GetBool(coreconfig.BatchManagerPersistDispatcherOptions),
+		healthStaleness:            config.GetDuration(coreconfig.BatchManagerHealthStaleness),
+		healthMaxReadFailures:      config.GetInt(coreconfig.BatchManagerHealthMaxReadFailures),
+		lastProgress:               time.Now(),
+		maxQueuedBatches:           config.GetInt(coreconfig.BatchManagerDispatchMaxQueuedBatches),
+		assembleOnly:               config.GetString(coreconfig.BatchManagerMode) == batchModeAssemble,
+		dispatchOnly:               config.GetString(coreconfig.BatchManagerMode) == batchModeDispatch,
+		checkpointInterval:         config.GetDuration(coreconfig.BatchManagerCheckpointInterval),
+		checkpoints:                make(chan *Checkpoint, 1),
+		checkpointerDone:           make(chan struct{}),
+		offsetName:                 offsetName,
+		committedOffset:            -1,
+		pendingOffset:              -1,
+		highestReadOffset:          -1,
+		rereadOffset:               -1,
+		offsetCommits:              make(chan bool, 1),
+		offsetCommitterDone:        make(chan struct{}),
+		offsetCompactorDone:        make(chan struct{}),
 		dispatcherMap:              make(map[string]*dispatcher),
 		allDispatchers:             make([]*dispatcher, 0),
 		newMessages:                make(chan int64, readPageSize),
+		notifications:              newNotificationBuffer(config.GetInt(coreconfig.BatchManagerNotificationsBufferSize), config.GetString(coreconfig.BatchManagerNotificationsPolicy)),
 		inflightSequences:          make(map[int64]*batchProcessor),
+		deferredSequences:          make(map[int64]string),
+		dispatcherStats:            make(map[core.MessageType]*dispatcherCounters),
+		dryRunStats:                make(map[string]*DryRunStats),
+		dataTiming:                 config.GetBool(coreconfig.BatchManagerDataTimingEnabled),
+		clock:                      wallClock{},
+		messageSource:              &dbMessageSource{database: di, namespace: ns},
+		replayConcurrency:          config.GetInt(coreconfig.BatchManagerReplayConcurrency),
+		drain:                      make(chan struct{}),
+		assemblyFailures:           make(map[fftypes.UUID]*assemblyFailure),
+		assemblyStallThreshold:     config.GetInt(coreconfig.BatchManagerAssemblyStallThreshold),
+		assemblyStallInterval:      config.GetDuration(coreconfig.BatchManagerAssemblyStallReportInterval),
+		assemblyStallPersist:       config.GetBool(coreconfig.BatchManagerAssemblyStallPersist),
+		idempotency:                make(map[string]*idempotencyCache),
+		readinessHolds:             make(map[string]int64),
+		slowDownHolds:              make(map[*dispatcher]int64),
+		slowDownAssembled:          make(map[*dispatcher]uint64),
 		shoulderTap:                make(chan bool, 1),
+		pauseSignals:               make(chan bool, 1),
 		rewindOffset:               -1,
+		rewinds:                    make(chan *rewindRequest),
 		done:                       make(chan struct{}),
-		retry: &retry.Retry{
-			InitialDelay: config.GetDuration(coreconfig.BatchRetryInitDelay),
-			MaximumDelay: config.GetDuration(coreconfig.BatchRetryMaxDelay),
-			Factor:       config.GetFloat64(coreconfig.BatchRetryFactor),
-		},
+		retry:                      &tuning.retry,
+	}
+	if maxConcurrentTx := config.GetInt(coreconfig.BatchManagerMaxConcurrentTransactions); maxConcurrentTx > 0 {
+		bm.txSemaphore = make(chan struct{}, maxConcurrentTx)
+	}
+	if bm.offsetCommitInterval > 0 || bm.offsetCommitMessages > 0 {
+		// Coalesced commits are made by the offset committer
+		bm.offsetCommitAsync = true
+	}
+	if bm.replayConcurrency < 1 {
+		bm.replayConcurrency = 1
+	}
+	if dispatchConcurrency := config.GetInt(coreconfig.BatchManagerDispatchConcurrency); dispatchConcurrency > 0 {
+		bm.scheduler = newDispatchScheduler(dispatchConcurrency, config.GetString(coreconfig.BatchManagerDispatchPolicy))
+	}
+	bm.backlogHighWaterMark, bm.backlogLowWaterMark = dispatchBacklogLimits(
+		config.GetInt(coreconfig.BatchManagerDispatchBacklogHighWaterMark),
+		config.GetInt(coreconfig.BatchManagerDispatchBacklogLowWaterMark),
+	)
+	bm.interleavePolicy, bm.interleaveWeights = interleaveConfig(ctx)
+	if maxEntries := config.GetInt(coreconfig.BatchManagerDataCacheMaxEntries); maxEntries > 0 {
+		bm.dataCache = data.NewDataLookupCacheWithTTL(maxEntries, config.GetDuration(coreconfig.BatchManagerDataCacheTTL))
 	}
 	return bm, nil
 }
 
 type Manager interface {
-	RegisterDispatcher(name string, txType core.TransactionType, msgTypes []core.MessageType, handler DispatchHandler, batchOptions DispatcherOptions)
+	RegisterDispatcher(name string, txType core.TransactionType, msgTypes []core.MessageType, handler DispatchHandler, batchOptions DispatcherOptions) error
 	NewMessages() chan<- int64
+	NotifyNewMessage(seq int64)
+	Checkpoints() <-chan *Checkpoint
+	TapDispatches() <-chan *core.Batch
 	Start() error
 	Close()
 	WaitStop()
+	WaitStopWithTimeout(timeout time.Duration) error
 	Status() *ManagerStatus
+	ClaimAndDispatch() (dispatched int, err error)
+	EnableDispatcher(txType core.TransactionType, msgType core.MessageType, enabled bool)
+	Pause() chan<- bool
+	DispatcherStats() map[core.MessageType]*DispatcherStats
+	DryRunStats() []*DryRunStats
+	Dispatchers() map[core.MessageType][]DispatcherOptions
+	DescribeDispatchers() []*DispatcherDescription
+	UpdateDispatcherOptions(msgType core.MessageType, options DispatcherOptions) error
+	ResetDispatcherStats()
+	DrainAndStop(ctx context.Context) error
+	OnAssemblyStall(handler AssemblyStallHandler)
+	GetAssemblyFailures(ctx context.Context, filter database.Filter) ([]*core.AssemblyFailure, *database.FilterResult, error)
+	OnOffsetCommitted(handler OffsetCommittedHandler)
+	RegisterMetrics(registry *prometheus.Registry)
+	SetBatchAnnotator(annotator BatchAnnotator)
+	SetBatchIDGenerator(generator BatchIDGenerator)
+	SetClock(clock Clock)
+	SetMessageSource(source MessageSource)
+	SetQuarantineStore(store QuarantineStore)
+	SetRetryableError(classifier RetryableErrorClassifier)
+	RegisterNoOpDispatcher(name string, txType core.TransactionType, msgTypes []core.MessageType, options DispatcherOptions)
+	ReleaseDeferred(ctx context.Context, msgTypes []core.MessageType) error
+	RedispatchMessage(ctx context.Context, msgID *fftypes.UUID) error
+	ReplayBatches(ctx context.Context, filter database.Filter, handler ReplayHandler) error
+	ReplayQuarantined(ctx context.Context, batchID *fftypes.UUID, handler ReplayHandler) error
+	Rewind(ctx context.Context, toSequence int64) error
+	IsHealthy() (bool, error)
+	PauseDispatch()
+	ResumeDispatch()
 }
 
 type ManagerStatus struct {
-	Processors []*ProcessorStatus `ffstruct:"BatchManagerStatus" json:"processors"`
+	Processors             []*ProcessorStatus `ffstruct:"BatchManagerStatus" json:"processors"`
+	Offset                 int64              `ffstruct:"BatchManagerStatus" json:"offset"`
+	HighestSequence        int64              `ffstruct:"BatchManagerStatus" json:"highestSequence"`
+	Lag                    int64              `ffstruct:"BatchManagerStatus" json:"lag"`
+	OpenBatches            map[string]int     `ffstruct:"BatchManagerStatus" json:"openBatches"`
+	OpenBatchTimers        []*OpenBatchTimer  `ffstruct:"BatchManagerStatus" json:"openBatchTimers"`
+	DataCache              *DataCacheStatus   `ffstruct:"BatchManagerStatus" json:"dataCache,omitempty"`
+	DataTiming             *DataTimingStatus  `ffstruct:"BatchManagerStatus" json:"dataTiming,omitempty"`
+	DispatchPaused         bool               `ffstruct:"BatchManagerStatus" json:"dispatchPaused"`
+	QueuedBatches          int                `ffstruct:"BatchManagerStatus" json:"queuedBatches"`
+	DispatchBacklog        int                `ffstruct:"BatchManagerStatus" json:"dispatchBacklog"`
+	NotificationsCoalesced int64              `ffstruct:"BatchManagerStatus" json:"notificationsCoalesced"`
+	TapDropped             int64              `ffstruct:"BatchManagerStatus" json:"tapDropped"`
+	IntegrityErrors        int64              `ffstruct:"BatchManagerStatus" json:"integrityErrors"`
 }
 
 type ProcessorStatus struct {
@@ -99,69 +229,453 @@ type batchManager struct {
 	data                       data.Manager
 	txHelper                   txcommon.Helper
 	dispatcherMux              sync.Mutex
+	retuneMux                  sync.Mutex
 	dispatcherMap              map[string]*dispatcher
+	dispatcherStats            map[core.MessageType]*dispatcherCounters
 	allDispatchers             []*dispatcher
 	newMessages                chan int64
+	notifications              *notificationBuffer
 	done                       chan struct{}
 	retry                      *retry.Retry
 	readOffset                 int64
+	lastPageFull               bool
+	pageYielded                bool
 	rewindOffsetMux            sync.Mutex
 	rewindOffset               int64
+	rewinds                    chan *rewindRequest
 	inflightMux                sync.Mutex
 	inflightSequences          map[int64]*batchProcessor
 	inflightFlushed            []int64
+	deferredSequences          map[int64]string
 	shoulderTap                chan bool
 	readPageSize               uint64
 	minimumPollDelay           time.Duration
 	messagePollTimeout         time.Duration
+	messagePollJitter          float64
+	defaultOptions             DispatcherOptions
+	jitterRand                 func() float64
+	iterationBudget            time.Duration
+	lazyData                   bool
+	verifyDataHash             bool
+	integrityErrors            int64
 	startupOffsetRetryAttempts int
+	offsetEnabled              bool
+	offsetCommitAsync          bool
+	offsetCommitInterval       time.Duration
+	offsetCommitMessages       int64
+	offsetCompactionInterval   time.Duration
+	resumeFromLastBatch        bool
+	offsetAheadCheck           string
+	offsetStartupTimeout       time.Duration
+	recoveryEnabled            bool
+	assembleOnly               bool
+	dispatchOnly               bool
+	txSemaphore                chan struct{}
+	scheduler                  *dispatchScheduler
+	interleavePolicy           string
+	interleaveWeights          map[core.MessageType]int
+	onUnknownType              string
+	persistDispatcherOptions   bool
+	healthMux                  sync.Mutex
+	healthStaleness            time.Duration
+	healthMaxReadFailures      int
+	lastProgress               time.Time
+	readFailures               int
+	lastReadError              error
+	dispatchPauseMux           sync.Mutex
+	dispatchPaused             bool
+	dispatchResuming           bool
+	dispatchDrained            chan struct{}
+	queuedBatches              int
+	maxQueuedBatches           int
+	backlogMux                 sync.Mutex
+	dispatchBacklog            int
+	backlogHighWaterMark       int
+	backlogLowWaterMark        int
+	backlogDrained             chan struct{}
+	dryRunMux                  sync.Mutex
+	dryRunStats                map[string]*DryRunStats
+	idempotency                map[string]*idempotencyCache
+	readinessHolds             map[string]int64
+	slowDownHolds              map[*dispatcher]int64
+	slowDownAssembled          map[*dispatcher]uint64
+	dataCache                  *data.DataLookupCache
+	checkpointInterval         time.Duration
+	checkpoints                chan *Checkpoint
+	checkpointerDone           chan struct{}
+	failFast                   *failFastOptions
+	pauseSignals               chan bool
+	pauseMux                   sync.Mutex
+	paused                     bool
+	resumed                    chan struct{}
+	drain                      chan struct{}
+	assemblyFailures           map[fftypes.UUID]*assemblyFailure
+	assemblyRetries            int64
+	assemblyStallThreshold     int
+	assemblyStallInterval      time.Duration
+	assemblyStallPersist       bool
+	assemblyStallMux           sync.Mutex
+	assemblyStallHandler       AssemblyStallHandler
+	batchIDMux                 sync.Mutex
+	batchIDGenerator           BatchIDGenerator
+	batchAnnotatorMux          sync.Mutex
+	batchAnnotator             BatchAnnotator
+	retryableErrorMux          sync.Mutex
+	retryableError             RetryableErrorClassifier
+	drainOnce                  sync.Once
+	dataTiming                 bool
+	dataTimingMux              sync.Mutex
+	dataTimingPage             DataTiming
+	dataTimingLastPage         DataTiming
+	dataTimingTotal            DataTiming
+	replayConcurrency          int
+	clock                      Clock
+	messageSource              MessageSource
+	quarantineStore            QuarantineStore
+	tapMux                     sync.Mutex
+	tap                        chan *core.Batch
+	tapDropped                 int64
+	stoppedOnce                sync.Once
+	stopped                    chan struct{}
+	stopTimedOut               int32
+	offsetName                 string
+	offsetRowID                int64
+	offsetMux                  sync.Mutex
+	pendingOffset              int64
+	offsetCommitMux            sync.Mutex
+	committedOffset            int64
+	offsetCommittedMux         sync.Mutex
+	offsetCommittedHandler     OffsetCommittedHandler
+	highestReadOffset          int64
+	rereadOffset               int64
+	offsetCommits              chan bool
+	started                    bool
+	offsetCommitterDone        chan struct{}
+	offsetCompactorDone        chan struct{}
 }
 
 type DispatchHandler func(context.Context, *DispatchState) error
 
+type DispatchRetryOptions struct {
+	InitialDelay time.Duration
+	MaximumDelay time.Duration
+	Factor       float64
+}
+
 type DispatcherOptions struct {
 	BatchType      core.BatchType
 	BatchMaxSize   uint
 	BatchMaxBytes  int64
 	BatchTimeout   time.Duration
 	DisposeTimeout time.Duration
+	// BatchMaxAge is the longest the first message of an open batch waits in assembly, before the batch is sealed
+	// regardless of the batch timeout - which can be re-armed while a batch is held, or after it is split. Whichever
+	// fires first flushes the batch. Zero is no maximum age.
+	BatchMaxAge time.Duration
+	// BatchMinSize is the number of messages an open batch prefers to wait for. Once the batch holds at least this many,
+	// it is sealed as soon as no further work is waiting for the processor, rather than waiting for the batch timeout.
+	// The batch timeout remains the longest a batch waits, so a batch under the minimum is sealed when it fires.
+	// Zero waits for the batch timeout (or maximum size) as before.
+	BatchMinSize uint
+	// AffinityKey is an optional function that returns a key for each message, such that messages sharing
+	// a key are preferentially assembled into the same batch (for downstream keyed caches).
+	AffinityKey func(msg *core.Message) string
+	// GroupBy is an optional function that partitions the messages of the dispatcher into independent batch groups,
+	// keyed by the returned string - for example by topic. Each group has its own open batch, with the same options,
+	// so messages in different groups are never in the same batch. An empty key is the default group.
+	// The groups are dispatched independently, so messages are only kept in order within a group. As such, it can only
+	// be set for unpinned dispatchers.
+	GroupBy func(msg *core.Message) string
+	// GroupMembership is an optional function that returns the groups an author is a member of, to enforce that a
+	// batch never mixes messages whose authors are not all members of a common group. It is consulted before each
+	// batch is sealed - a batch that would violate the constraint is split at the first message that does, with the
+	// rest returned to the assembly for the next batch, so messages stay in order. Errors are retried.
+	GroupMembership func(ctx context.Context, author string) ([]string, error)
+	// StallThreshold is how long a batch dispatch can go without succeeding, before the dispatch
+	// is reported as stalled. Zero disables stall detection.
+	StallThreshold time.Duration
+	// StallHandler is an optional callback invoked each time a dispatch is detected as stalled
+	StallHandler func(ctx context.Context, status *ProcessorStatus)
+	// SizeClasses is an optional ascending list of upper bounds on the estimated size of a message.
+	// When set, messages are bucketed into classes by size, and each class is assembled into its own batches.
+	// The batches of each class are dispatched independently, so a later message in a smaller class can be dispatched
+	// ahead of an earlier message of the same author in a larger class. As such, it can only be set for unpinned dispatchers.
+	SizeClasses []int64
+	// IncludeProvenance attaches provenance metadata for each message to the dispatch state
+	IncludeProvenance bool
+	// SlowDownPageSize and SlowDownPollDelay ease the assembly of this dispatcher's messages while its dispatch handler
+	// is signalling slow-down, by calling SignalSlowDown on the state of a successful dispatch. At most SlowDownPageSize
+	// of its messages are assembled from each page read, and the rest are read again after SlowDownPollDelay (or the
+	// read poll timeout, if zero). A zero SlowDownPageSize ignores the signal. Other dispatchers are unaffected.
+	SlowDownPageSize  uint64
+	SlowDownPollDelay time.Duration
+	// ReadPageSize overrides the global read page size for this dispatcher's message types. When any dispatcher
+	// sets it, the sequencer reads a separate page for each dispatcher, so messages of types without a registered
+	// dispatcher are no longer read.
+	ReadPageSize uint64
+	// CorrelateBatch assigns each batch a correlation ID - the CID of its first message that has one, or a new ID -
+	// that is stored on the batch, and set as the batch correlator on all confirmation events for its messages.
+	// Each event keeps the CID of its own message as its correlator.
+	CorrelateBatch bool
+	// MinMessageDwell is the minimum time a message must have aged since it was created, before it is eligible
+	// for assembly into a batch. Younger messages are deferred until they have aged, giving a cancellation window.
+	MinMessageDwell time.Duration
+	// Outbox writes each sealed batch to the outbox table, in the same database transaction that marks its messages
+	// as dispatched, for a separate relay to hand off. The dispatch handler is optional when this is set.
+	Outbox bool
+	// DispatchWeight optionally returns the weight of a grouping key (processor name), for the weighted dispatch
+	// scheduling policy. Keys without a weight, or a weight less than one, have a weight of one.
+	DispatchWeight func(key string) int
+	// IdempotencyKey optionally returns a key for each message, such that a message re-submitted by a producer with
+	// the same key as a message already read within the IdempotencyWindow is skipped, rather than dispatched again.
+	// The keys seen are held in memory, so a restart that re-reads ready messages does not detect duplicates.
+	IdempotencyKey    func(msg *core.Message) string
+	IdempotencyWindow time.Duration
+	// ReadinessGate is optionally consulted for each message before it is assembled into a batch. Messages that are
+	// not yet ready are deferred, and the gate is consulted again after ReadinessRecheck (defaulting to the read poll
+	// timeout). An error from the gate is logged, and blocks the message in the same way until the gate succeeds.
+	ReadinessGate    func(msg *core.Message) (ready bool, err error)
+	ReadinessRecheck time.Duration
+	// LatencyHandler is an optional callback invoked with a breakdown of the time spent in each phase
+	// of assembling and dispatching a batch, once the dispatch has been committed
+	LatencyHandler func(ctx context.Context, id *fftypes.UUID, latency *BatchLatency)
+	// ConfirmedElsewhere is an optional precheck against a shared source of confirmed messages, such as in a
+	// multi-node deployment with shared storage. Messages it reports as already handled are skipped, rather than
+	// assembled into a batch and dispatched a second time.
+	ConfirmedElsewhere func(ctx context.Context, msg *core.Message) (bool, error)
+	// TxSizeLimitError optionally classifies an error as the database rejecting a transaction for its size. When set,
+	// a batch too large to seal is split - returning the remainder to the assembly for the next batch - and the
+	// update marking a batch dispatched is retried in smaller chunks. The maximum size of future batches is reduced to match.
+	// The chunks of the update are applied in one transaction, so the batch is marked dispatched atomically, which
+	// relieves a limit on the size of a single statement only. A batch that has been dispatched is never split.
+	TxSizeLimitError func(err error) bool
+	// VerifyReadBack reads back the batch and its messages within the transaction that marks them dispatched, and
+	// fails the transaction - rolling it back to be retried - if they were not persisted as expected
+	VerifyReadBack bool
+	// MaxDispatchAttempts bounds the attempts to dispatch a batch, after which the batch is handed to the optional
+	// DeadLetter callback and its messages are marked failed, so the dispatcher can move on. Zero retries indefinitely.
+	// The messages are only marked failed, and passed by the offset, once the callback returns without error.
+	// It cannot be set for a pinned dispatcher of private messages, as the nonces assigned to the messages of a
+	// dead-lettered batch would never be delivered, and the members of the group would wait on them forever.
+	MaxDispatchAttempts int
+	DeadLetter          func(ctx context.Context, batch *core.Batch, err error) error
+	// OnBatchSealed is an optional hook invoked with the full batch, once it has been persisted within the database
+	// transaction that seals it, and before its messages are updated. It is invoked again if the transaction is retried.
+	OnBatchSealed func(batch *core.Batch)
+	// OnMessageBatched is an optional callback invoked for each message once the batch it was assembled into has
+	// been sealed, so the batch it is assigned to is final. Messages returned to the assembly when a batch is split,
+	// or whose seal is retried, are reported once only - with the batch they are sealed into.
+	OnMessageBatched func(msgID, batchID *fftypes.UUID)
+	// DispatchTimeout optionally bounds each attempt to dispatch a batch. The context passed to the handler is cancelled
+	// once it passes, and the attempt fails - to be retried, or handed to DeadLetter, like any other failure. Handlers
+	// are expected to return promptly once their context is cancelled, as the attempt only ends when they do.
+	DispatchTimeout time.Duration
+	// DispatchRetry optionally overrides the backoff of the retry loop around the dispatch handler, independently of
+	// the retry of database operations. Fields that are not set inherit the manager configuration.
+	DispatchRetry DispatchRetryOptions
+	// DispatchConcurrency is the number of sealed batches from each processor that can be in dispatch at once. When
+	// greater than one, a processor continues to assemble and seal batches while earlier batches are dispatched,
+	// so batches of the same processor might complete out of order. The offset only advances past a message
+	// once the batch it is in, and every batch containing an earlier message, has been dispatched.
+	DispatchConcurrency int
+	// CloneBatch hands the dispatch handler a deep copy of the batch header, messages and data, rather than those held
+	// by the manager, so a handler that retains them beyond the call cannot race with the manager. This is most useful
+	// alongside DispatchConcurrency, where the manager continues to work on other batches while the handler runs.
+	CloneBatch bool
+	// OrderedDispatch guarantees the batches of the dispatcher are dispatched strictly in sequence order, even across
+	// processors (different authors, groups and size classes). Without it, that is only the case for the batches of
+	// each processor. A batch is sealed early if the next message is for another processor, so batches never
+	// interleave, and its dispatch waits for every batch with earlier messages. DispatchConcurrency and separate
+	// priority batches are not used. Batches queued by PauseDispatch, or assembled for a separate dispatch process,
+	// are not covered.
+	OrderedDispatch bool
+	// ConcurrentHandlers calls all the handlers attached to the dispatcher at once for each batch, rather than one
+	// after another in the order they were registered. Either way the batch is only dispatched once all succeed.
+	ConcurrentHandlers bool
+	// ExcludeFlushMarkers controls the handling of messages with Flush set in their header, which seal the open batch
+	// of their processor as soon as they are reached. By default the flush marker is assembled as the last message of
+	// the batch it seals. When excluded, the marker only seals the batch, and is moved past without being dispatched.
+	ExcludeFlushMarkers bool
+	// DryRun assembles and seals batches as normal, but records their sizes and assembly times in histograms for
+	// capacity planning, rather than dispatching them. Nothing about the batch is persisted, and its messages are
+	// marked with a transient dry-run state in the message cache only - so dry-run traffic is safe to measure on a
+	// live node, but the messages are not dispatched later should the dispatcher be registered without DryRun.
+	DryRun bool
+	// PriorityBatchMaxSize and PriorityBatchTimeout apply to the separate batches that high priority messages are
+	// assembled into, so they are flushed quickly, ahead of bulk traffic of the same type. A zero size inherits
+	// BatchMaxSize, and a zero timeout flushes each batch as soon as its first message is assembled. Being in separate
+	// batches, high priority messages can be dispatched ahead of earlier messages on the same topic.
+	PriorityBatchMaxSize uint
+	PriorityBatchTimeout time.Duration
+}
+
+// MessageProvenance records where a message came from, and when and where it was read for batch assembly
+type MessageProvenance struct {
+	Ingested   *fftypes.FFTime `json:"ingested,omitempty"`
+	Source     string          `json:"source,omitempty"`
+	ReadOffset int64           `json:"readOffset"`
+}
+
+type pendingDispatch struct {
+	processor  *batchProcessor
+	msg        *core.Message
+	data       core.DataArray
+	provenance *MessageProvenance
+	flush      bool // seal the open batch once the message is reached
+	flushOnly  bool // seal the open batch, without assembling the message
 }
 
 type dispatcher struct {
 	name       string
-	handler    DispatchHandler
+	txType     core.TransactionType
+	msgTypes   []core.MessageType
+	handlers   []DispatchHandler
 	processors map[string]*batchProcessor
 	options    DispatcherOptions
+	slowDown   bool
+	disabled   bool
+	noOp       bool
+	order      *dispatchOrder
+}
+
+// processorOptions are the options for a processor of the dispatcher, which differ from those of the dispatcher
+// for ordered dispatch and for the processors of high priority messages
+func processorOptions(d *dispatcher, highPriority bool) DispatcherOptions {
+	options := d.options
+	if d.order != nil {
+		// Batches of an ordered dispatcher are dispatched one at a time, in sequence order
+		options.DispatchConcurrency = 0
+	} else if highPriority {
+		if options.PriorityBatchMaxSize > 0 {
+			options.BatchMaxSize = options.PriorityBatchMaxSize
+		}
+		options.BatchTimeout = options.PriorityBatchTimeout
+	}
+	return options
 }
 
+// getProcessorKey partitions messages by author and group. As each batch is assembled by a single processor,
+// a batch never mixes messages from different authors, or private messages from different groups.
 func (bm *batchManager) getProcessorKey(identity *core.SignerRef, groupID *fftypes.Bytes32) string {
 	return fmt.Sprintf("%s|%v", identity.Author, groupID)
 }
 
+// getSizeClass returns the index of the first size class the message fits within, or the
+// number of classes if it is larger than all of them
+func getSizeClass(sizeClasses []int64, size int64) int {
+	for i, limit := range sizeClasses {
+		if size <= limit {
+			return i
+		}
+	}
+	return len(sizeClasses)
+}
+
 func (bm *batchManager) getDispatcherKey(txType core.TransactionType, msgType core.MessageType) string {
 	return fmt.Sprintf("tx:%s/%s", txType, msgType)
 }
 
-func (bm *batchManager) RegisterDispatcher(name string, txType core.TransactionType, msgTypes []core.MessageType, handler DispatchHandler, options DispatcherOptions) {
-	bm.dispatcherMux.Lock()
-	defer bm.dispatcherMux.Unlock()
-
-	dispatcher := &dispatcher{
+// RegisterDispatcher registers a handler for batches of the given message types. Registering a further handler for
+// a message type that already has a (non no-op) dispatcher attaches it to that dispatcher, rather than replacing it,
+// so that each batch fans out to every handler - with the options of the first registration applying to all of them.
+// Nonsensical options are rejected with an error, and nothing is registered.
+func (bm *batchManager) RegisterDispatcher(name string, txType core.TransactionType, msgTypes []core.MessageType, handler DispatchHandler, options DispatcherOptions) error {
+	bm.applyDefaultOptions(&options)
+	if err := validateDispatcherOptions(bm.ctx, name, txType, msgTypes, &options); err != nil {
+		return err
+	}
+	var handlers []DispatchHandler
+	if handler != nil {
+		handlers = []DispatchHandler{handler}
+	}
+	bm.registerDispatcher(&dispatcher{
 		name:       name,
-		handler:    handler,
+		txType:     txType,
+		msgTypes:   msgTypes,
+		handlers:   handlers,
 		options:    options,
 		processors: make(map[string]*batchProcessor),
+	})
+	return nil
+}
+
+func (bm *batchManager) registerDispatcher(dispatcher *dispatcher) {
+	if dispatcher.options.OrderedDispatch {
+		dispatcher.order = newDispatchOrder()
 	}
-	bm.allDispatchers = append(bm.allDispatchers, dispatcher)
-	for _, msgType := range msgTypes {
-		bm.dispatcherMap[bm.getDispatcherKey(txType, msgType)] = dispatcher
+	bm.dispatcherMux.Lock()
+	allMsgTypes := dispatcher.msgTypes
+	dispatcher.msgTypes = nil
+	attached := make(map[string]bool)
+	for _, msgType := range allMsgTypes {
+		key := bm.getDispatcherKey(dispatcher.txType, msgType)
+		if existing, ok := bm.dispatcherMap[key]; ok && !existing.noOp && !dispatcher.noOp {
+			if !attached[existing.name] {
+				log.L(bm.ctx).Infof("Attaching dispatcher '%s' to '%s' for %s", dispatcher.name, existing.name, key)
+				existing.attachHandlers(dispatcher.handlers)
+				attached[existing.name] = true
+			}
+			continue
+		}
+		dispatcher.msgTypes = append(dispatcher.msgTypes, msgType)
+		bm.dispatcherMap[key] = dispatcher
+		if _, ok := bm.dispatcherStats[msgType]; !ok {
+			bm.dispatcherStats[msgType] = &dispatcherCounters{}
+		}
 	}
+	if len(dispatcher.msgTypes) > 0 {
+		bm.allDispatchers = append(bm.allDispatchers, dispatcher)
+	}
+	bm.dispatcherMux.Unlock()
+
+	bm.releaseUnknownType(dispatcher.txType, allMsgTypes)
 }
 
+// Start establishes the offset, and then launches the background goroutines. If the offset cannot be established no
+// goroutine has been launched, so WaitStop returns as soon as the manager is stopped.
 func (bm *batchManager) Start() error {
-	go bm.messageSequencer()
+	if bm.offsetEnabled {
+		if err := bm.restoreOffset(); err != nil {
+			close(bm.done)
+			return err
+		}
+	}
+	if bm.resumeFromLastBatch {
+		if err := bm.resumeFromLastDispatchedBatch(); err != nil {
+			close(bm.done)
+			return err
+		}
+	}
+	if bm.offsetEnabled && !bm.dispatchOnly {
+		if err := bm.checkOffsetAhead(); err != nil {
+			close(bm.done)
+			return err
+		}
+	}
+	bm.started = true
+	if bm.offsetEnabled && bm.offsetCommitAsync {
+		go bm.offsetCommitLoop()
+	}
+	if bm.offsetEnabled && bm.offsetCompactionInterval > 0 {
+		go bm.offsetCompactionLoop()
+	}
+	if bm.persistDispatcherOptions {
+		bm.checkDispatcherOptions()
+	}
+	if bm.assemblyStallPersist {
+		bm.restoreAssemblyFailures()
+	}
+	if bm.checkpointInterval > 0 {
+		go bm.checkpointLoop()
+	}
+	if bm.dispatchOnly {
+		go bm.claimLoop()
+	} else {
+		go bm.messageSequencer()
+	}
 	// We must be always ready to process DB events, or we block commits. So we have a dedicated worker for that
 	go bm.newMessageNotifier()
+	go bm.pauseWatcher()
 	return nil
 }
 
@@ -169,28 +683,55 @@ func (bm *batchManager) NewMessages() chan<- int64 {
 	return bm.newMessages
 }
 
-func (bm *batchManager) getProcessor(txType core.TransactionType, msgType core.MessageType, group *fftypes.Bytes32, signer *core.SignerRef) (*batchProcessor, error) {
+func (bm *batchManager) getProcessor(txType core.TransactionType, msgType core.MessageType, group *fftypes.Bytes32, signer *core.SignerRef, size int64, priority core.MessagePriority) (*batchProcessor, error) {
+	return bm.getGroupedProcessor(txType, msgType, group, signer, size, priority, nil)
+}
+
+// getMessageProcessor returns the processor for the message, within the batch group it is assigned to by the GroupBy
+// function of its dispatcher
+func (bm *batchManager) getMessageProcessor(msg *core.Message, size int64) (*batchProcessor, error) {
+	return bm.getGroupedProcessor(msg.Header.TxType, msg.Header.Type, msg.Header.Group, &msg.Header.SignerRef, size, msg.Header.Priority, msg)
+}
+
+func (bm *batchManager) getGroupedProcessor(txType core.TransactionType, msgType core.MessageType, group *fftypes.Bytes32, signer *core.SignerRef, size int64, priority core.MessagePriority, msg *core.Message) (*batchProcessor, error) {
 	bm.dispatcherMux.Lock()
 	defer bm.dispatcherMux.Unlock()
 
 	dispatcherKey := bm.getDispatcherKey(txType, msgType)
 	dispatcher, ok := bm.dispatcherMap[dispatcherKey]
 	if !ok {
-		return nil, i18n.NewError(bm.ctx, coremsgs.MsgUnregisteredBatchType, dispatcherKey)
+		return nil, bm.newUnknownMessageTypeError(bm.ctx, txType, msgType)
 	}
 	name := bm.getProcessorKey(signer, group)
+	if dispatcher.options.GroupBy != nil && msg != nil {
+		if groupKey := dispatcher.options.GroupBy(msg); groupKey != "" {
+			name = fmt.Sprintf("%s|groupBy=%s", name, groupKey)
+		}
+	}
+	if len(dispatcher.options.SizeClasses) > 0 {
+		name = fmt.Sprintf("%s|class%d", name, getSizeClass(dispatcher.options.SizeClasses, size))
+	}
+	highPriority := dispatcher.order == nil && priority == core.MessagePriorityHigh
+	if highPriority {
+		// High priority messages are assembled separately, into small batches with a short timeout
+		name = fmt.Sprintf("%s|priority", name)
+	}
 	processor, ok := dispatcher.processors[name]
 	if !ok {
 		processor = newBatchProcessor(
 			bm,
 			&batchProcessorConf{
-				DispatcherOptions: dispatcher.options,
+				DispatcherOptions: processorOptions(dispatcher, highPriority),
 				name:              name,
+				highPriority:      highPriority,
 				txType:            txType,
 				dispatcherName:    dispatcher.name,
+				dispatcherKey:     dispatcherKey,
 				signer:            *signer,
 				group:             group,
-				dispatch:          dispatcher.handler,
+				dispatch:          bm.dispatchHandler(dispatcher),
+				noOp:              dispatcher.noOp,
+				order:             dispatcher.order,
 			},
 			bm.retry,
 			bm.txHelper,
@@ -201,18 +742,29 @@ func (bm *batchManager) getProcessor(txType core.TransactionType, msgType core.M
 	return processor, nil
 }
 
-func (bm *batchManager) assembleMessageData(id *fftypes.UUID) (msg *core.Message, retData core.DataArray, err error) {
+func (bm *batchManager) assembleMessageData(ctx context.Context, id *fftypes.UUID) (msg *core.Message, retData core.DataArray, err error) {
 	var foundAll = false
-	err = bm.retry.Do(bm.ctx, "retrieve message", func(attempt int) (retry bool, err error) {
-		msg, retData, foundAll, err = bm.data.GetMessageWithDataCached(bm.ctx, id)
+	ctx = log.WithLogField(ctx, "msg", id.String())
+	if bm.lazyData {
+		return bm.assembleMessageRefs(ctx, id)
+	}
+	lookupCtx := bm.assemblyContext(ctx)
+	err = bm.retry.Do(ctx, "retrieve message", func(attempt int) (retry bool, err error) {
+		started := bm.startDataTiming()
+		msg, retData, foundAll, err = bm.data.GetMessageWithDataCached(lookupCtx, id)
+		bm.recordDataTiming(started)
 		// continual retry for persistence error (distinct from not-found)
 		return true, err
 	})
 	if err != nil {
 		return nil, nil, err
 	}
+	if isDeletedMessage(msg) {
+		// The data of a soft-deleted message might have been removed, but it is never assembled
+		return msg, nil, nil
+	}
 	if !foundAll {
-		return nil, nil, i18n.NewError(bm.ctx, coremsgs.MsgDataNotFound, id)
+		return nil, nil, newMessageDataMissingError(ctx, id, msg, retData)
 	}
 	return msg, retData, nil
 }
@@ -222,6 +774,9 @@ func (bm *batchManager) popRewind() {
 	bm.rewindOffsetMux.Lock()
 	if bm.rewindOffset >= 0 && bm.rewindOffset < bm.readOffset {
 		bm.readOffset = bm.rewindOffset
+		bm.inflightMux.Lock()
+		bm.rereadFrom(bm.readOffset)
+		bm.inflightMux.Unlock()
 	}
 	bm.rewindOffset = -1
 	bm.rewindOffsetMux.Unlock()
@@ -257,88 +812,269 @@ func (bm *batchManager) notifyFlushed(sequences []int64) {
 	bm.inflightMux.Lock()
 	bm.inflightFlushed = append(bm.inflightFlushed, sequences...)
 	bm.inflightMux.Unlock()
+
+	bm.checkpointOffset()
 }
 
-func (bm *batchManager) readPage(lastPageFull bool) ([]*core.IDAndSequence, bool, error) {
+func (bm *batchManager) readPage(ctx context.Context, lastPageFull bool) ([]*core.IDAndSequence, bool, error) {
 
 	// Pop out any rewind that has been queued, but each time we read to the front before we rewind
 	if !lastPageFull {
 		bm.popRewind()
 	}
+	bm.releaseReadinessHolds()
+	bm.resetSlowDownPage()
 
 	// Read a page from the DB
 	var ids []*core.IDAndSequence
-	err := bm.retry.Do(bm.ctx, "retrieve messages", func(attempt int) (retry bool, err error) {
-		fb := database.MessageQueryFactory.NewFilterLimit(bm.ctx, bm.readPageSize)
-		ids, err = bm.database.GetMessageIDs(bm.ctx, bm.namespace, fb.And(
-			fb.Gt("sequence", bm.readOffset),
-			fb.Eq("state", core.MessageStateReady),
-		).Sort("sequence").Limit(bm.readPageSize))
+	var fullPage bool
+	pageSize := uint64(bm.readPageSize)
+	dispatcherPages := bm.getDispatcherPages()
+	err := bm.retry.Do(ctx, "retrieve messages", func(attempt int) (retry bool, err error) {
+		defer func() { bm.recordReadResult(err) }()
+		if dispatcherPages != nil {
+			ids, fullPage, err = bm.readDispatcherPages(dispatcherPages)
+			return true, err
+		}
+		ids, err = bm.messageSource.ReadPage(ctx, &MessagePage{AfterSequence: bm.readOffset, Limit: pageSize})
+		// Calculate if this was a full page we read (so should immediately re-poll) before we remove flushed IDs
+		fullPage = (len(ids) == int(pageSize))
 		return true, err
 	})
-
-	// Calculate if this was a full page we read (so should immediately re-poll) before we remove flushed IDs
 	pageReadLength := len(ids)
-	fullPage := (pageReadLength == int(bm.readPageSize))
 
 	// Remove any flushed IDs from the list, and then update our flushed map
 	ids = bm.filterFlushed(ids)
 
-	log.L(bm.ctx).Debugf("Read %d records from offset %d. filtered=%d fullPage=%t", pageReadLength, bm.readOffset, len(ids), fullPage)
+	log.L(ctx).Debugf("Read %d records from offset %d. filtered=%d fullPage=%t", pageReadLength, bm.readOffset, len(ids), fullPage)
 	return ids, fullPage, err
 }
 
+// preparePage retrieves the full message and data for each entry in a page, and determines the processor each
+// message should be dispatched to. Messages that cannot be retrieved or dispatched are logged and skipped.
+// If a non-zero deadline passes part way through the page, the remaining entries are left unprepared - the
+// number of entries consumed is returned, so the caller can resume after the last one.
+func (bm *batchManager) preparePage(ctx context.Context, entries []*core.IDAndSequence, pageOffset int64, deadline time.Time) (pending []*pendingDispatch, prepared int) {
+	l := log.L(ctx)
+	pending = make([]*pendingDispatch, 0, len(entries))
+	bm.clearDataCache()
+	for _, entry := range entries {
+		if prepared > 0 && !deadline.IsZero() && time.Now().After(deadline) {
+			l.Debugf("Sequencer time budget exhausted after %d of %d messages in page", prepared, len(entries))
+			break
+		}
+		prepared++
+
+		msg, data, err := bm.assembleMessageData(ctx, &entry.ID)
+		var dataMissing *ErrMessageDataMissing
+		if errors.As(err, &dataMissing) && dataMissing.msgNotFound {
+			// The message has been removed since its ID was read, so there is nothing to wait for
+			l.Infof("Skipping message %s (seq=%d) that no longer exists", entry.ID, entry.Sequence)
+			bm.clearAssemblyFailure(&entry.ID)
+			continue
+		}
+		if err != nil {
+			l.Errorf("Failed to retrieve message data for %s (seq=%d) - retrying in %s: %s", entry.ID, entry.Sequence, bm.messagePollTimeout, err)
+			bm.recordAssemblyFailure(&entry.ID, entry.Sequence, err)
+			bm.deferForRecheck(entry.Sequence, "", bm.messagePollTimeout)
+			continue
+		}
+		bm.clearAssemblyFailure(&entry.ID)
+
+		// We likely retrieved this message from the cache, which is written by the message-writer before
+		// the database store. Meaning we cannot rely on the sequence having been set.
+		msg.Sequence = entry.Sequence
+
+		if bm.filterMessage(msg, data) {
+			continue
+		}
+
+		size := (&batchWork{msg: msg, data: data}).estimateSize()
+		processor, err := bm.getMessageProcessor(msg, size)
+		if err != nil {
+			bm.handleUnknownType(msg, err)
+			continue
+		}
+
+		pd := &pendingDispatch{processor: processor, msg: msg, data: data}
+		if processor.conf.IncludeProvenance {
+			pd.provenance = &MessageProvenance{
+				Ingested:   msg.Header.Created,
+				Source:     msg.Header.Author,
+				ReadOffset: pageOffset,
+			}
+		}
+		markFlush(pd)
+		pending = append(pending, pd)
+	}
+	bm.completeDataTimingPage()
+	return pending, prepared
+}
+
 func (bm *batchManager) messageSequencer() {
-	l := log.L(bm.ctx)
+	// The namespace is included in every line logged by the sequencer, as the sequencers of all namespaces interleave
+	ctx := log.WithLogField(bm.ctx, "ns", bm.namespace)
+	l := log.L(ctx)
 	l.Debugf("Started batch assembly message sequencer")
 	defer close(bm.done)
 
-	lastPageFull := false
+	if bm.recoveryEnabled {
+		if err := bm.recoverBatching(); err != nil {
+			l.Debugf("Exiting: %s", err)
+			return
+		}
+	}
+
 	for {
 		// Each time round the loop we check for quiescing processors
 		bm.reapQuiescing()
 
-		// Read messages from the DB - in an error condition we retry until success, or a closed context
-		entries, fullPage, err := bm.readPage(lastPageFull)
-		if err != nil {
+		// Apply any rewind that has been requested, now that we are between pages
+		bm.checkRewind()
+
+		// Assembly stops reading messages while the manager is paused, the queue of batches held while
+		// dispatch is paused is full, or the backlog of sealed batches waiting for dispatch is high
+		if done := bm.waitWhilePaused(); done {
+			l.Debugf("Exiting: paused when context closed")
+			return
+		}
+		if done := bm.waitWhileDispatchQueueFull(); done {
+			l.Debugf("Exiting: dispatch queue full when context closed")
+			return
+		}
+		if done := bm.waitWhileDispatchBacklogHigh(); done {
+			l.Debugf("Exiting: dispatch backlog high when context closed")
+			return
+		}
+
+		// When draining, we stop reading messages and flush the open batches
+		if bm.isDraining() {
+			bm.drainProcessors()
+			l.Debugf("Exiting: drained")
+			return
+		}
+		bm.recordProgress()
+
+		if _, err := bm.processPage(ctx); err != nil {
 			l.Debugf("Exiting: %s", err)
 			return
 		}
 
-		if len(entries) > 0 {
-			for _, entry := range entries {
-				msg, data, err := bm.assembleMessageData(&entry.ID)
-				if err != nil {
-					l.Errorf("Failed to retrieve message data for %s (seq=%d): %s", entry.ID, entry.Sequence, err)
-					continue
-				}
+		// There is more to read straight away - either the rest of a page we ran out of time on, or the next
+		// page after a full one
+		if bm.pageYielded || bm.lastPageFull {
+			continue
+		}
 
-				// We likely retrieved this message from the cache, which is written by the message-writer before
-				// the database store. Meaning we cannot rely on the sequence having been set.
-				msg.Sequence = entry.Sequence
+		// Wait to be woken again
+		if done := bm.waitForNewMessages(); done {
+			l.Debugf("Exiting: context closed waiting for new messages")
+			return
+		}
+	}
+}
 
-				processor, err := bm.getProcessor(msg.Header.TxType, msg.Header.Type, msg.Header.Group, &msg.Header.SignerRef)
-				if err != nil {
-					l.Errorf("Failed to dispatch message %s: %s", msg.Header.ID, err)
-					continue
-				}
+// processPage performs exactly one page of sequencer work - reading a page of messages from the DB, then
+// assembling and dispatching them to their processors. It returns the number of messages dispatched.
+//
+// Whether there is more work to do immediately is recorded for the next call: lastPageFull is set when a
+// full page was read, and pageYielded when the iteration budget ran out part way through the page.
+// Waiting for new messages, and checking for pause, drain and rewind, are left to the caller. The context
+// is checked when yielding part way through a page.
+func (bm *batchManager) processPage(ctx context.Context) (processed int, err error) {
+	// The time budget for this iteration covers the read, as well as assembly and dispatch
+	var deadline time.Time
+	if bm.iterationBudget > 0 {
+		deadline = time.Now().Add(bm.iterationBudget)
+	}
 
-				bm.dispatchMessage(processor, msg, data)
-			}
+	// Every line logged while processing the page carries a correlation ID for the page, and the offset it was read from
+	ctx = log.WithLogField(ctx, "page", fftypes.NewUUID().String())
 
-			// Next time round only read after the messages we just processed (unless we get a tap to rewind)
-			bm.readOffset = entries[len(entries)-1].Sequence
+	// Read messages from the DB - in an error condition we retry until success, or a closed context
+	entries, fullPage, err := bm.readPage(ctx, bm.lastPageFull)
+	pageOffset := bm.readOffset
+	if err != nil {
+		return 0, err
+	}
+	ctx = log.WithLogField(ctx, "offset", strconv.FormatInt(pageOffset, 10))
+
+	bm.pageYielded = false
+	prepared := 0
+	if len(entries) > 0 {
+		var pending []*pendingDispatch
+		pending, prepared = bm.preparePage(ctx, entries, pageOffset, deadline)
+		for _, pd := range bm.interleaveByType(bm.clusterByAffinity(pending)) {
+			bm.dispatchMessage(ctx, pd)
 		}
+		processed = len(pending)
 
-		// Wait to be woken again
-		if !fullPage {
-			if done := bm.waitForNewMessages(); done {
-				l.Debugf("Exiting: %s", err)
-				return
+		// Next time round only read after the messages we just processed (unless we get a tap to rewind)
+		bm.readOffset = entries[prepared-1].Sequence
+		bm.markRead(bm.readOffset)
+		bm.checkpointOffset()
+
+		// If we ran out of time part way through the page, we read the rest of it before any rewind - but
+		// first yield to check for close
+		bm.pageYielded = prepared < len(entries)
+		if bm.pageYielded && ctx.Err() != nil {
+			return processed, i18n.NewError(ctx, coremsgs.MsgContextCanceled)
+		}
+	}
+	if !fullPage && !bm.pageYielded {
+		bm.markReadComplete()
+	}
+	bm.pruneAssemblyFailures(pageOffset, entries[:prepared], !fullPage && !bm.pageYielded)
+	bm.lastPageFull = fullPage && !bm.pageYielded
+	return processed, nil
+}
+
+func affinityKey(pd *pendingDispatch) string {
+	if pd.processor.conf.AffinityKey == nil {
+		return ""
+	}
+	return pd.processor.conf.AffinityKey(pd.msg)
+}
+
+func sharesTopic(msg *core.Message, topics map[string]bool) bool {
+	for _, topic := range msg.Header.Topics {
+		if topics[topic] {
+			return true
+		}
+	}
+	return false
+}
+
+// clusterByAffinity re-orders a page of messages so that messages going to the same processor, with the
+// same affinity key, are dispatched together - meaning they fill the same batch where capacity allows.
+// A message is only pulled forward past messages that share none of its topics, so the ordering
+// constraints on each topic are preserved.
+func (bm *batchManager) clusterByAffinity(pending []*pendingDispatch) []*pendingDispatch {
+	ordered := make([]*pendingDispatch, 0, len(pending))
+	remaining := pending
+	for len(remaining) > 0 {
+		head := remaining[0]
+		ordered = append(ordered, head)
+		remaining = remaining[1:]
+		key := affinityKey(head)
+		if key == "" {
+			continue
+		}
+		skippedTopics := make(map[string]bool)
+		kept := make([]*pendingDispatch, 0, len(remaining))
+		for _, pd := range remaining {
+			if pd.processor == head.processor && affinityKey(pd) == key && !sharesTopic(pd.msg, skippedTopics) {
+				ordered = append(ordered, pd)
+			} else {
+				kept = append(kept, pd)
+				for _, topic := range pd.msg.Header.Topics {
+					skippedTopics[topic] = true
+				}
 			}
 		}
-		lastPageFull = fullPage
+		remaining = kept
 	}
+	return ordered
 }
 
 func (bm *batchManager) newMessageNotification(seq int64) {
@@ -368,6 +1104,10 @@ func (bm *batchManager) newMessageNotifier() {
 		select {
 		case seq := <-bm.newMessages:
 			bm.newMessageNotification(seq)
+		case <-bm.notifications.ready:
+			for _, seq := range bm.notifications.popAll() {
+				bm.newMessageNotification(seq)
+			}
 		case <-bm.ctx.Done():
 			l.Debugf("Exiting due to cancelled context")
 			return
@@ -379,35 +1119,81 @@ func (bm *batchManager) waitForNewMessages() (done bool) {
 	l := log.L(bm.ctx)
 
 	// We have a short minimum timeout, to stop us thrashing the DB
-	time.Sleep(bm.minimumPollDelay)
+	pollDelay := bm.minimumPollDelay
+	if pollDelay > 0 {
+		delay := bm.clock.NewTimer(pollDelay)
+		select {
+		case <-delay.C():
+		case <-bm.ctx.Done():
+			delay.Stop()
+			l.Debugf("Exiting due to cancelled context")
+			return true
+		}
+	}
 
-	timeout := time.NewTimer(bm.messagePollTimeout - bm.minimumPollDelay)
+	timeout := bm.clock.NewTimer(bm.jitteredPollTimeout() - pollDelay)
 	select {
 	case <-bm.shoulderTap:
 		timeout.Stop()
 		return false
-	case <-timeout.C:
+	case req := <-bm.rewinds:
+		timeout.Stop()
+		bm.applyRewind(req)
+		return false
+	case <-timeout.C():
 		l.Debugf("Woken after poll timeout")
 		return false
+	case <-bm.drain:
+		timeout.Stop()
+		return false
 	case <-bm.ctx.Done():
 		l.Debugf("Exiting due to cancelled context")
 		return true
 	}
 }
 
-func (bm *batchManager) dispatchMessage(processor *batchProcessor, msg *core.Message, data core.DataArray) {
-	l := log.L(bm.ctx)
-	l.Debugf("Dispatching message %s (seq=%d) to %s batch processor %s", msg.Header.ID, msg.Sequence, msg.Header.Type, processor.conf.name)
+// runAsGroup runs a database transaction, limiting the number of transactions the batch
+// manager initiates concurrently when batch.manager.maxConcurrentTransactions is set
+func (bm *batchManager) runAsGroup(ctx context.Context, fn func(ctx context.Context) error) error {
+	if bm.txSemaphore != nil {
+		select {
+		case bm.txSemaphore <- struct{}{}:
+			defer func() { <-bm.txSemaphore }()
+		case <-ctx.Done():
+			return i18n.NewError(ctx, coremsgs.MsgContextCanceled)
+		}
+	}
+	return bm.database.RunAsGroup(ctx, fn)
+}
+
+func (bm *batchManager) dispatchMessage(ctx context.Context, pd *pendingDispatch) {
+	processor, msg := pd.processor, pd.msg
+	ctx = log.WithLogField(log.WithLogField(ctx, "msg", msg.Header.ID.String()), "mtype", msg.Header.Type.String())
+	if pd.flushOnly {
+		bm.sealForFlush(ctx, pd)
+		return
+	}
+	log.L(ctx).Debugf("Dispatching message %s (seq=%d) to %s batch processor %s", msg.Header.ID, msg.Sequence, msg.Header.Type, processor.conf.name)
 
 	bm.inflightMux.Lock()
 	bm.inflightSequences[msg.Sequence] = processor
 	bm.inflightMux.Unlock()
 
+	if order := processor.conf.order; order != nil {
+		if previous := order.assign(msg.Sequence, processor); previous != nil {
+			bm.sealForOrdering(previous)
+		}
+	}
+
 	work := &batchWork{
-		msg:  msg,
-		data: data,
+		msg:        msg,
+		data:       pd.data,
+		provenance: pd.provenance,
 	}
 	processor.newWork <- work
+	if pd.flush {
+		processor.newWork <- &batchWork{seal: true}
+	}
 }
 
 func (bm *batchManager) reapQuiescing() {
@@ -455,9 +1241,19 @@ func (bm *batchManager) Status() *ManagerStatus {
 	for i, p := range processors {
 		pStatus[i] = p.status()
 	}
-	return &ManagerStatus{
-		Processors: pStatus,
+	status := &ManagerStatus{
+		Processors:      pStatus,
+		OpenBatchTimers: bm.openBatchTimers(processors),
+		DataCache:       bm.dataCacheStatus(),
+		DataTiming:      bm.dataTimingStatus(),
 	}
+	bm.lagStatus(status)
+	bm.dispatchPauseStatus(status)
+	bm.dispatchBacklogStatus(status)
+	status.NotificationsCoalesced = bm.notifications.getCoalesced()
+	bm.tapStatus(status)
+	bm.integrityStatus(status)
+	return status
 }
 
 func (bm *batchManager) Close() {
@@ -470,4 +1266,18 @@ func (bm *batchManager) WaitStop() {
 	for _, p := range processors {
 		<-p.done
 	}
+	if !bm.started {
+		// Start failed before launching the background goroutines, and the offset was never established
+		return
+	}
+	if bm.offsetEnabled && bm.offsetCommitAsync {
+		<-bm.offsetCommitterDone
+		bm.flushOffset()
+	}
+	if bm.offsetEnabled && bm.offsetCompactionInterval > 0 {
+		<-bm.offsetCompactorDone
+	}
+	if bm.checkpointInterval > 0 {
+		<-bm.checkpointerDone
+	}
 }
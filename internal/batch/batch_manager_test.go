@@ -20,6 +20,9 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -35,6 +38,7 @@ import (
 	"github.com/hyperledger/firefly/mocks/identitymanagermocks"
 	"github.com/hyperledger/firefly/pkg/core"
 	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -53,11 +57,62 @@ func newTestBatchManager(t *testing.T) (*batchManager, func()) {
 	cmi := &cachemocks.Manager{}
 	cmi.On("GetCache", mock.Anything).Return(cache.NewUmanagedCache(ctx, 100, 5*time.Minute), nil)
 	txHelper, _ := txcommon.NewTransactionHelper(ctx, "ns1", mdi, mdm, cmi)
-	bm, err := NewBatchManager(context.Background(), "ns1", mdi, mdm, mim, txHelper)
+	bm, err := NewBatchManager(context.Background(), "ns1", "", mdi, mdm, mim, txHelper)
 	assert.NoError(t, err)
 	return bm.(*batchManager), bm.(*batchManager).cancelCtx
 }
 
+// newTestDispatchingBatchManager returns a batch manager with mocks set up for the happy path of
+// reading pages of messages, sealing, dispatching and finalizing batches
+func newTestDispatchingBatchManager(t *testing.T) (*batchManager, *databasemocks.Plugin, *datamocks.Manager, func()) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdm := bm.data.(*datamocks.Manager)
+	mim := bm.identity.(*identitymanagermocks.Manager)
+	mim.On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
+	mdm.On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+	mockRunAsGroupPassthrough(mdi)
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("InsertTransaction", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("InsertEvent", mock.Anything, mock.Anything).Return(nil)
+	return bm, mdi, mdm, cancel
+}
+
+// enableFailFast bounds each dispatch handler call, so a deadlocked handler fails the test rather than hanging it
+func enableFailFast(t *testing.T, bm *batchManager) {
+	bm.failFast = &failFastOptions{
+		timeout: 10 * time.Second,
+		report:  func(err error) { t.Error(err) },
+	}
+}
+
+// mockMessagePage sets up the next page read to return the supplied messages, which will be returned from the data manager
+func mockMessagePage(mdi *databasemocks.Plugin, mdm *datamocks.Manager, msgs ...*core.Message) {
+	entries := make([]*core.IDAndSequence, len(msgs))
+	for i, msg := range msgs {
+		entries[i] = &core.IDAndSequence{ID: *msg.Header.ID, Sequence: msg.Sequence}
+		mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil)
+	}
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(entries, nil).Once()
+}
+
+func newTestBroadcastMessage(seq int64) *core.Message {
+	return &core.Message{
+		Header: core.MessageHeader{
+			ID:        fftypes.NewUUID(),
+			TxType:    core.TransactionTypeBatchPin,
+			Type:      core.MessageTypeBroadcast,
+			Namespace: "ns1",
+			Topics:    core.FFStringArray{"topic1"},
+			SignerRef: core.SignerRef{Author: "did:firefly:org/abcd", Key: "0x12345"},
+			Created:   fftypes.Now(),
+		},
+		Sequence: seq,
+	}
+}
+
 func TestE2EDispatchBroadcast(t *testing.T) {
 	testConfigReset()
 
@@ -98,9 +153,10 @@ func TestE2EDispatchBroadcast(t *testing.T) {
 		return nil
 	}
 	ctx, cancel := context.WithCancel(context.Background())
-	bmi, _ := NewBatchManager(ctx, "ns1", mdi, mdm, mim, txHelper)
+	bmi, _ := NewBatchManager(ctx, "ns1", "", mdi, mdm, mim, txHelper)
 	bm := bmi.(*batchManager)
 	bm.readOffset = 1000
+	enableFailFast(t, bm)
 
 	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast}, handler, DispatcherOptions{
 		BatchMaxSize:   2,
@@ -144,7 +200,7 @@ func TestE2EDispatchBroadcast(t *testing.T) {
 	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.MatchedBy(func(f database.Filter) bool {
 		fi, err := f.Finalize()
 		assert.NoError(t, err)
-		assert.Equal(t, fmt.Sprintf("( id IN ['%s'] ) && ( state == 'ready' )", msg.Header.ID.String()), fi.String())
+		assert.Equal(t, fmt.Sprintf("( id IN ['%s'] ) && ( state IN ['ready'] )", msg.Header.ID.String()), fi.String())
 		return true
 	}), mock.Anything).Return(nil)
 	mdi.On("InsertTransaction", mock.Anything, mock.Anything).Return(nil)
@@ -221,8 +277,9 @@ func TestE2EDispatchPrivateUnpinned(t *testing.T) {
 		return nil
 	}
 	ctx, cancel := context.WithCancel(context.Background())
-	bmi, _ := NewBatchManager(ctx, "ns1", mdi, mdm, mim, txHelper)
+	bmi, _ := NewBatchManager(ctx, "ns1", "", mdi, mdm, mim, txHelper)
 	bm := bmi.(*batchManager)
+	enableFailFast(t, bm)
 
 	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypePrivate}, handler, DispatcherOptions{
 		BatchMaxSize:   2,
@@ -266,7 +323,7 @@ func TestE2EDispatchPrivateUnpinned(t *testing.T) {
 	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.MatchedBy(func(f database.Filter) bool {
 		fi, err := f.Finalize()
 		assert.NoError(t, err)
-		assert.Equal(t, fmt.Sprintf("( id IN ['%s'] ) && ( state == 'ready' )", msg.Header.ID.String()), fi.String())
+		assert.Equal(t, fmt.Sprintf("( id IN ['%s'] ) && ( state IN ['ready'] )", msg.Header.ID.String()), fi.String())
 		return true
 	}), mock.Anything).Return(nil)
 	mdi.On("GetNonce", mock.Anything, mock.Anything).Return(&core.Nonce{
@@ -304,7 +361,7 @@ func TestDispatchUnknownType(t *testing.T) {
 	cmi.On("GetCache", mock.Anything).Return(cache.NewUmanagedCache(ctx, 100, 5*time.Minute), nil)
 	txHelper, _ := txcommon.NewTransactionHelper(ctx, "ns1", mdi, mdm, cmi)
 	ctx, cancel := context.WithCancel(context.Background())
-	bmi, _ := NewBatchManager(ctx, "ns1", mdi, mdm, mim, txHelper)
+	bmi, _ := NewBatchManager(ctx, "ns1", "", mdi, mdm, mim, txHelper)
 	bm := bmi.(*batchManager)
 
 	msg := &core.Message{
@@ -324,7 +381,7 @@ func TestDispatchUnknownType(t *testing.T) {
 }
 
 func TestInitFailNoPersistence(t *testing.T) {
-	_, err := NewBatchManager(context.Background(), "", nil, nil, nil, nil)
+	_, err := NewBatchManager(context.Background(), "", "", nil, nil, nil, nil)
 	assert.Error(t, err)
 }
 
@@ -337,9 +394,9 @@ func TestGetInvalidBatchTypeMsg(t *testing.T) {
 	cmi := &cachemocks.Manager{}
 	cmi.On("GetCache", mock.Anything).Return(cache.NewUmanagedCache(ctx, 100, 5*time.Minute), nil)
 	txHelper, _ := txcommon.NewTransactionHelper(ctx, "ns1", mdi, mdm, cmi)
-	bm, _ := NewBatchManager(context.Background(), "ns1", mdi, mdm, mim, txHelper)
+	bm, _ := NewBatchManager(context.Background(), "ns1", "", mdi, mdm, mim, txHelper)
 	defer bm.Close()
-	_, err := bm.(*batchManager).getProcessor(core.BatchTypeBroadcast, "wrong", nil, &core.SignerRef{})
+	_, err := bm.(*batchManager).getProcessor(core.BatchTypeBroadcast, "wrong", nil, &core.SignerRef{}, 0, "")
 	assert.Regexp(t, "FF10126", err)
 }
 
@@ -352,7 +409,7 @@ func TestMessageSequencerCancelledContext(t *testing.T) {
 	cmi.On("GetCache", mock.Anything).Return(cache.NewUmanagedCache(ctx, 100, 5*time.Minute), nil)
 	txHelper, _ := txcommon.NewTransactionHelper(ctx, "ns1", mdi, mdm, cmi)
 	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(nil, fmt.Errorf("pop")).Once()
-	bm, _ := NewBatchManager(context.Background(), "ns1", mdi, mdm, mim, txHelper)
+	bm, _ := NewBatchManager(context.Background(), "ns1", "", mdi, mdm, mim, txHelper)
 	defer bm.Close()
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
@@ -369,12 +426,12 @@ func TestMessageSequencerMissingMessageData(t *testing.T) {
 	cmi := &cachemocks.Manager{}
 	cmi.On("GetCache", mock.Anything).Return(cache.NewUmanagedCache(ctx, 100, 5*time.Minute), nil)
 	txHelper, _ := txcommon.NewTransactionHelper(ctx, "ns1", mdi, mdm, cmi)
-	bm, _ := NewBatchManager(context.Background(), "ns1", mdi, mdm, mim, txHelper)
+	bm, _ := NewBatchManager(context.Background(), "ns1", "", mdi, mdm, mim, txHelper)
 	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeNone, []core.MessageType{core.MessageTypeBroadcast},
 		func(c context.Context, state *DispatchState) error {
 			return nil
 		},
-		DispatcherOptions{BatchType: core.BatchTypeBroadcast},
+		DispatcherOptions{BatchMaxSize: 1, BatchType: core.BatchTypeBroadcast},
 	)
 
 	dataID := fftypes.NewUUID()
@@ -416,7 +473,7 @@ func TestMessageSequencerUpdateMessagesFail(t *testing.T) {
 	txHelper, _ := txcommon.NewTransactionHelper(ctx, "ns1", mdi, mdm, cmi)
 	mim.On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
 	ctx, cancelCtx := context.WithCancel(context.Background())
-	bm, _ := NewBatchManager(ctx, "ns1", mdi, mdm, mim, txHelper)
+	bm, _ := NewBatchManager(ctx, "ns1", "", mdi, mdm, mim, txHelper)
 	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
 		func(c context.Context, state *DispatchState) error {
 			return nil
@@ -474,7 +531,7 @@ func TestMessageSequencerDispatchFail(t *testing.T) {
 	txHelper, _ := txcommon.NewTransactionHelper(ctx, "ns1", mdi, mdm, cmi)
 	mim.On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
 	ctx, cancelCtx := context.WithCancel(context.Background())
-	bm, _ := NewBatchManager(ctx, "ns1", mdi, mdm, mim, txHelper)
+	bm, _ := NewBatchManager(ctx, "ns1", "", mdi, mdm, mim, txHelper)
 	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
 		func(c context.Context, state *DispatchState) error {
 			cancelCtx()
@@ -516,7 +573,7 @@ func TestMessageSequencerUpdateBatchFail(t *testing.T) {
 	cmi.On("GetCache", mock.Anything).Return(cache.NewUmanagedCache(ctx, 100, 5*time.Minute), nil)
 	txHelper, _ := txcommon.NewTransactionHelper(ctx, "ns1", mdi, mdm, cmi)
 	mim.On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
-	bm, _ := NewBatchManager(ctx, "ns1", mdi, mdm, mim, txHelper)
+	bm, _ := NewBatchManager(ctx, "ns1", "", mdi, mdm, mim, txHelper)
 	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
 		func(c context.Context, state *DispatchState) error {
 			return nil
@@ -590,7 +647,7 @@ func TestRewindForNewMessage(t *testing.T) {
 		assert.Equal(t, int64(12344), v)
 		return true
 	})).Return(nil, nil)
-	_, _, err := bm.readPage(false)
+	_, _, err := bm.readPage(bm.ctx, false)
 	assert.NoError(t, err)
 }
 
@@ -602,10 +659,10 @@ func TestAssembleMessageDataNilData(t *testing.T) {
 	cmi := &cachemocks.Manager{}
 	cmi.On("GetCache", mock.Anything).Return(cache.NewUmanagedCache(ctx, 100, 5*time.Minute), nil)
 	txHelper, _ := txcommon.NewTransactionHelper(ctx, "ns1", mdi, mdm, cmi)
-	bm, _ := NewBatchManager(context.Background(), "ns1", mdi, mdm, mim, txHelper)
+	bm, _ := NewBatchManager(context.Background(), "ns1", "", mdi, mdm, mim, txHelper)
 	bm.Close()
 	mdm.On("GetMessageWithDataCached", mock.Anything, mock.Anything).Return(nil, nil, false, nil)
-	_, _, err := bm.(*batchManager).assembleMessageData(fftypes.NewUUID())
+	_, _, err := bm.(*batchManager).assembleMessageData(bm.(*batchManager).ctx, fftypes.NewUUID())
 	assert.Regexp(t, "FF10133", err)
 }
 
@@ -617,10 +674,10 @@ func TestGetMessageDataFail(t *testing.T) {
 	cmi := &cachemocks.Manager{}
 	cmi.On("GetCache", mock.Anything).Return(cache.NewUmanagedCache(ctx, 100, 5*time.Minute), nil)
 	txHelper, _ := txcommon.NewTransactionHelper(ctx, "ns1", mdi, mdm, cmi)
-	bm, _ := NewBatchManager(context.Background(), "ns1", mdi, mdm, mim, txHelper)
+	bm, _ := NewBatchManager(context.Background(), "ns1", "", mdi, mdm, mim, txHelper)
 	mdm.On("GetMessageWithDataCached", mock.Anything, mock.Anything).Return(nil, nil, false, fmt.Errorf("pop"))
 	bm.Close()
-	_, _, err := bm.(*batchManager).assembleMessageData(fftypes.NewUUID())
+	_, _, err := bm.(*batchManager).assembleMessageData(bm.(*batchManager).ctx, fftypes.NewUUID())
 	assert.Regexp(t, "FF00154", err)
 	mdm.AssertExpectations(t)
 }
@@ -633,10 +690,10 @@ func TestGetMessageNotFound(t *testing.T) {
 	cmi := &cachemocks.Manager{}
 	cmi.On("GetCache", mock.Anything).Return(cache.NewUmanagedCache(ctx, 100, 5*time.Minute), nil)
 	txHelper, _ := txcommon.NewTransactionHelper(ctx, "ns1", mdi, mdm, cmi)
-	bm, _ := NewBatchManager(context.Background(), "ns1", mdi, mdm, mim, txHelper)
+	bm, _ := NewBatchManager(context.Background(), "ns1", "", mdi, mdm, mim, txHelper)
 	mdm.On("GetMessageWithDataCached", mock.Anything, mock.Anything).Return(nil, nil, false, nil)
 	bm.Close()
-	_, _, err := bm.(*batchManager).assembleMessageData(fftypes.NewUUID())
+	_, _, err := bm.(*batchManager).assembleMessageData(bm.(*batchManager).ctx, fftypes.NewUUID())
 	assert.Regexp(t, "FF10133", err)
 }
 
@@ -653,3 +710,728 @@ func TestDoubleTap(t *testing.T) {
 		time.Sleep(1 * time.Microsecond)
 	}
 }
+
+func TestClusterByAffinity(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	bp := &batchProcessor{conf: &batchProcessorConf{
+		DispatcherOptions: DispatcherOptions{
+			BatchMaxSize: 2,
+			AffinityKey: func(msg *core.Message) string {
+				return msg.Header.Tag
+			},
+		},
+	}}
+	newPending := func(seq int64, tag, topic string) *pendingDispatch {
+		return &pendingDispatch{
+			processor: bp,
+			msg: &core.Message{
+				Header:   core.MessageHeader{ID: fftypes.NewUUID(), Tag: tag, Topics: core.FFStringArray{topic}},
+				Sequence: seq,
+			},
+		}
+	}
+
+	pending := []*pendingDispatch{
+		newPending(1, "a", "topic1"),
+		newPending(2, "b", "topic2"),
+		newPending(3, "a", "topic3"),
+		newPending(4, "b", "topic4"),
+	}
+	ordered := bm.clusterByAffinity(pending)
+	sequences := make([]int64, len(ordered))
+	for i, pd := range ordered {
+		sequences[i] = pd.msg.Sequence
+	}
+	// Messages sharing an affinity key are adjacent, so they fill the same batch of 2
+	assert.Equal(t, []int64{1, 3, 2, 4}, sequences)
+}
+
+func TestClusterByAffinityPreservesTopicOrder(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	bp := &batchProcessor{conf: &batchProcessorConf{
+		DispatcherOptions: DispatcherOptions{
+			AffinityKey: func(msg *core.Message) string {
+				return msg.Header.Tag
+			},
+		},
+	}}
+	noAffinity := &batchProcessor{conf: &batchProcessorConf{}}
+
+	pending := []*pendingDispatch{
+		{processor: bp, msg: &core.Message{Header: core.MessageHeader{Tag: "a", Topics: core.FFStringArray{"topic1"}}, Sequence: 1}},
+		{processor: noAffinity, msg: &core.Message{Header: core.MessageHeader{Tag: "a", Topics: core.FFStringArray{"topic2"}}, Sequence: 2}},
+		{processor: bp, msg: &core.Message{Header: core.MessageHeader{Tag: "b", Topics: core.FFStringArray{"topic2"}}, Sequence: 3}},
+		{processor: bp, msg: &core.Message{Header: core.MessageHeader{Tag: "a", Topics: core.FFStringArray{"topic2"}}, Sequence: 4}},
+	}
+	ordered := bm.clusterByAffinity(pending)
+	sequences := make([]int64, len(ordered))
+	for i, pd := range ordered {
+		sequences[i] = pd.msg.Sequence
+	}
+	// Sequence 4 cannot jump ahead of 2 or 3, as they share topic2
+	assert.Equal(t, []int64{1, 2, 3, 4}, sequences)
+}
+
+func TestClusterByAffinitySealsBatches(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	dispatched := make(chan *DispatchState, 2)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:   2,
+			BatchMaxBytes:  1024 * 1024,
+			BatchTimeout:   time.Minute,
+			DisposeTimeout: 120 * time.Second,
+			AffinityKey: func(msg *core.Message) string {
+				return msg.Header.Tag
+			},
+		},
+	)
+
+	// The affinity keys alternate in the page, on separate topics
+	msgs := make([]*core.Message, 4)
+	for i := range msgs {
+		msgs[i] = newTestBroadcastMessage(int64(1001 + i))
+		msgs[i].Header.Tag = fmt.Sprintf("tag%d", i%2)
+		msgs[i].Header.Topics = core.FFStringArray{fmt.Sprintf("topic%d", i)}
+	}
+	mockMessagePage(mdi, mdm, msgs...)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	// Each sealed batch holds the messages of one affinity key, in sequence order
+	batch1 := <-dispatched
+	batch2 := <-dispatched
+	batchIDs := func(state *DispatchState) []*fftypes.UUID {
+		ids := make([]*fftypes.UUID, len(state.Messages))
+		for i, msg := range state.Messages {
+			ids[i] = msg.Header.ID
+		}
+		return ids
+	}
+	assert.Equal(t, []*fftypes.UUID{msgs[0].Header.ID, msgs[2].Header.ID}, batchIDs(batch1))
+	assert.Equal(t, []*fftypes.UUID{msgs[1].Header.ID, msgs[3].Header.ID}, batchIDs(batch2))
+	assert.NotEqual(t, batch1.Persisted.ID, batch2.Persisted.ID)
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestGetProcessorSizeClasses(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeUnpinned, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 10, DisposeTimeout: 120 * time.Second, SizeClasses: []int64{1024, 65536}},
+	)
+
+	signer := &core.SignerRef{Author: "did:firefly:org/abcd"}
+	small := &batchWork{msg: &core.Message{Header: core.MessageHeader{ID: fftypes.NewUUID()}}}
+	large := &batchWork{
+		msg:  &core.Message{Header: core.MessageHeader{ID: fftypes.NewUUID()}},
+		data: core.DataArray{{ID: fftypes.NewUUID(), Value: fftypes.JSONAnyPtr(fmt.Sprintf(`"%0100000d"`, 0))}},
+	}
+
+	smallProcessor1, err := bm.getProcessor(core.TransactionTypeUnpinned, core.MessageTypeBroadcast, nil, signer, small.estimateSize(), "")
+	assert.NoError(t, err)
+	smallProcessor2, err := bm.getProcessor(core.TransactionTypeUnpinned, core.MessageTypeBroadcast, nil, signer, small.estimateSize(), "")
+	assert.NoError(t, err)
+	largeProcessor, err := bm.getProcessor(core.TransactionTypeUnpinned, core.MessageTypeBroadcast, nil, signer, large.estimateSize(), "")
+	assert.NoError(t, err)
+
+	assert.Same(t, smallProcessor1, smallProcessor2)
+	assert.NotSame(t, smallProcessor1, largeProcessor)
+	assert.Equal(t, "did:firefly:org/abcd||class0", smallProcessor1.conf.name)
+	assert.Equal(t, "did:firefly:org/abcd||class2", largeProcessor.conf.name)
+}
+
+func TestGetProcessorPriority(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 100, BatchTimeout: 1 * time.Second, DisposeTimeout: 120 * time.Second, PriorityBatchMaxSize: 5, PriorityBatchTimeout: 10 * time.Millisecond},
+	)
+
+	signer := &core.SignerRef{Author: "did:firefly:org/abcd"}
+	normalProcessor, err := bm.getProcessor(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, nil, signer, 0, core.MessagePriorityNormal)
+	assert.NoError(t, err)
+	defaultProcessor, err := bm.getProcessor(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, nil, signer, 0, "")
+	assert.NoError(t, err)
+	priorityProcessor, err := bm.getProcessor(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, nil, signer, 0, core.MessagePriorityHigh)
+	assert.NoError(t, err)
+
+	assert.Same(t, normalProcessor, defaultProcessor)
+	assert.NotSame(t, normalProcessor, priorityProcessor)
+	assert.Equal(t, "did:firefly:org/abcd||priority", priorityProcessor.conf.name)
+	assert.Equal(t, uint(100), normalProcessor.conf.BatchMaxSize)
+	assert.Equal(t, 1*time.Second, normalProcessor.conf.BatchTimeout)
+	assert.Equal(t, uint(5), priorityProcessor.conf.BatchMaxSize)
+	assert.Equal(t, 10*time.Millisecond, priorityProcessor.conf.BatchTimeout)
+}
+
+func TestDispatchPriorityAheadOfBulk(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+
+	dispatched := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 10, BatchMaxBytes: 1024 * 1024, BatchTimeout: 10 * time.Second, DisposeTimeout: 120 * time.Second, PriorityBatchMaxSize: 1},
+	)
+
+	// The bulk message waits for its batch to fill, while the urgent message is flushed in its own batch
+	bulk := newTestBroadcastMessage(1001)
+	urgent := newTestBroadcastMessage(1002)
+	urgent.Header.Priority = core.MessagePriorityHigh
+	mockMessagePage(mdi, mdm, bulk, urgent)
+
+	processed, err := bm.processPage(bm.ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, processed)
+
+	state := <-dispatched
+	assert.Len(t, state.Messages, 1)
+	assert.Equal(t, urgent.Header.ID, state.Messages[0].Header.ID)
+}
+
+func TestDispatchWithProvenance(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+
+	dispatched := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second, IncludeProvenance: true},
+	)
+
+	msg := newTestBroadcastMessage(1001)
+	mockMessagePage(mdi, mdm, msg)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	state := <-dispatched
+	provenance := state.Provenance[*msg.Header.ID]
+	assert.NotNil(t, provenance)
+	assert.Equal(t, msg.Header.Created, provenance.Ingested)
+	assert.Equal(t, "did:firefly:org/abcd", provenance.Source)
+	assert.Equal(t, int64(1000), provenance.ReadOffset)
+	// The provenance is persisted with the batch, keyed by message ID
+	assert.Equal(t, provenance, state.Persisted.Provenance[msg.Header.ID.String()])
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestRunAsGroupMaxConcurrentTransactions(t *testing.T) {
+	testConfigReset()
+	config.Set(coreconfig.BatchManagerMaxConcurrentTransactions, 2)
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+
+	var active, maxActive int32
+	mdi.On("RunAsGroup", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		current := atomic.AddInt32(&active, 1)
+		for {
+			highest := atomic.LoadInt32(&maxActive)
+			if current <= highest || atomic.CompareAndSwapInt32(&maxActive, highest, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+	}).Return(nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := bm.runAsGroup(bm.ctx, func(ctx context.Context) error { return nil })
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(2), maxActive)
+	mdi.AssertNumberOfCalls(t, "RunAsGroup", 10)
+}
+
+func TestRunAsGroupMaxConcurrentTransactionsClosed(t *testing.T) {
+	testConfigReset()
+	config.Set(coreconfig.BatchManagerMaxConcurrentTransactions, 1)
+	bm, cancel := newTestBatchManager(t)
+	bm.txSemaphore <- struct{}{}
+	cancel()
+
+	err := bm.runAsGroup(bm.ctx, func(ctx context.Context) error { return nil })
+	assert.Regexp(t, "FF00154", err)
+}
+
+func TestDispatchSplitsBatchesByAuthor(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	dispatched := make(chan *DispatchState, 2)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 2, BatchTimeout: 10 * time.Millisecond, DisposeTimeout: 120 * time.Second},
+	)
+
+	msg1 := newTestBroadcastMessage(1001)
+	msg2 := newTestBroadcastMessage(1002)
+	msg2.Header.Author = "did:firefly:org/efgh"
+	mockMessagePage(mdi, mdm, msg1, msg2)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	authors := map[string]*fftypes.UUID{}
+	for i := 0; i < 2; i++ {
+		state := <-dispatched
+		assert.Len(t, state.Messages, 1)
+		assert.Equal(t, state.Persisted.Author, state.Messages[0].Header.Author)
+		authors[state.Persisted.Author] = state.Messages[0].Header.ID
+	}
+	assert.Equal(t, msg1.Header.ID, authors["did:firefly:org/abcd"])
+	assert.Equal(t, msg2.Header.ID, authors["did:firefly:org/efgh"])
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestDispatchCorrelateBatch(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	dispatched := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeUnpinned, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 2, BatchMaxBytes: 1024 * 1024, BatchTimeout: time.Hour, DisposeTimeout: 120 * time.Second, CorrelateBatch: true},
+	)
+
+	msg1 := newTestBroadcastMessage(1001)
+	msg1.Header.TxType = core.TransactionTypeUnpinned
+	msg1.Header.CID = fftypes.NewUUID()
+	msg2 := newTestBroadcastMessage(1002)
+	msg2.Header.TxType = core.TransactionTypeUnpinned
+	msg2.Header.CID = fftypes.NewUUID()
+	mockMessagePage(mdi, mdm, msg1, msg2)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	state := <-dispatched
+	assert.Equal(t, msg1.Header.CID, state.Persisted.Correlator)
+	assert.Len(t, state.Messages, 2)
+
+	cancel()
+	bm.WaitStop()
+
+	var batchCorrelators []*fftypes.UUID
+	confirmCorrelators := make(map[fftypes.UUID]*fftypes.UUID)
+	for _, call := range mdi.Calls {
+		switch call.Method {
+		case "UpsertBatch":
+			batchCorrelators = append(batchCorrelators, call.Arguments[1].(*core.BatchPersisted).Correlator)
+		case "InsertEvent":
+			if event := call.Arguments[1].(*core.Event); event.Type == core.EventTypeMessageConfirmed {
+				// Each event keeps its own message's CID, and carries the batch correlator separately
+				assert.Equal(t, msg1.Header.CID, event.BatchCorrelator)
+				confirmCorrelators[*event.Reference] = event.Correlator
+			}
+		}
+	}
+	assert.Equal(t, []*fftypes.UUID{msg1.Header.CID}, batchCorrelators)
+	assert.Equal(t, map[fftypes.UUID]*fftypes.UUID{
+		*msg1.Header.ID: msg1.Header.CID,
+		*msg2.Header.ID: msg2.Header.CID,
+	}, confirmCorrelators)
+}
+
+func TestBatchCorrelator(t *testing.T) {
+	msg1 := newTestBroadcastMessage(1001)
+	msg2 := newTestBroadcastMessage(1002)
+	msg2.Header.CID = fftypes.NewUUID()
+	assert.Equal(t, msg2.Header.CID, batchCorrelator([]*batchWork{{msg: msg1}, {msg: msg2}}))
+	assert.NotNil(t, batchCorrelator([]*batchWork{{msg: msg1}}))
+}
+
+func TestSequencerYieldsWhenIterationBudgetExhausted(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+	bm.iterationBudget = 10 * time.Millisecond
+
+	dispatched := make(chan *DispatchState, 3)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+
+	// The first message is slow to retrieve, exhausting the budget
+	msgs := []*core.Message{newTestBroadcastMessage(1001), newTestBroadcastMessage(1002), newTestBroadcastMessage(1003)}
+	mdm.On("GetMessageWithDataCached", mock.Anything, msgs[0].Header.ID).Return(msgs[0], core.DataArray{}, true, nil).Run(func(args mock.Arguments) {
+		time.Sleep(20 * time.Millisecond)
+	})
+	for _, msg := range msgs[1:] {
+		mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil)
+	}
+	var readsMux sync.Mutex
+	var reads []string
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(func(ctx context.Context, ns string, filter database.Filter) []*core.IDAndSequence {
+		fi, _ := filter.Finalize()
+		readsMux.Lock()
+		defer readsMux.Unlock()
+		reads = append(reads, fi.String())
+		var page []*core.IDAndSequence
+		for _, msg := range msgs {
+			if strings.HasPrefix(fi.String(), fmt.Sprintf("( sequence >> %d )", msg.Sequence-1)) {
+				for _, m := range msgs[msg.Sequence-1001:] {
+					page = append(page, &core.IDAndSequence{ID: *m.Header.ID, Sequence: m.Sequence})
+				}
+			}
+		}
+		return page
+	}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	for _, msg := range msgs {
+		state := <-dispatched
+		assert.Equal(t, msg.Header.ID, state.Messages[0].Header.ID)
+	}
+
+	cancel()
+	bm.WaitStop()
+
+	// After the slow message we yielded, and read the rest of the page again
+	readsMux.Lock()
+	defer readsMux.Unlock()
+	assert.True(t, strings.HasPrefix(reads[0], "( sequence >> 1000 )"))
+	assert.True(t, strings.HasPrefix(reads[1], "( sequence >> 1001 )"))
+}
+
+func TestSequencerYieldExitsOnClose(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.iterationBudget = 1 * time.Millisecond
+
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+
+	// Close while we are working on the first message of the page
+	msg1 := newTestBroadcastMessage(1001)
+	msg2 := newTestBroadcastMessage(1002)
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg1.Header.ID).Return(msg1, core.DataArray{}, true, nil).Run(func(args mock.Arguments) {
+		cancel()
+		time.Sleep(5 * time.Millisecond)
+	})
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{
+		{ID: *msg1.Header.ID, Sequence: msg1.Sequence},
+		{ID: *msg2.Header.ID, Sequence: msg2.Sequence},
+	}, nil).Once()
+
+	err := bm.Start()
+	assert.NoError(t, err)
+	<-bm.done
+
+	assert.Equal(t, int64(1001), bm.readOffset)
+	mdm.AssertNotCalled(t, "GetMessageWithDataCached", mock.Anything, msg2.Header.ID)
+}
+
+// processUntilCaughtUp drives the sequencer synchronously, one page at a time, until it has caught up
+// with the messages in the DB - returning the total number of messages dispatched
+func processUntilCaughtUp(t *testing.T, bm *batchManager) int {
+	total := 0
+	for {
+		processed, err := bm.processPage(bm.ctx)
+		assert.NoError(t, err)
+		total += processed
+		if err != nil || (!bm.lastPageFull && !bm.pageYielded) {
+			return total
+		}
+	}
+}
+
+func TestProcessPage(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+
+	dispatched := make(chan *DispatchState, 2)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+
+	msg1 := newTestBroadcastMessage(1001)
+	msg2 := newTestBroadcastMessage(1002)
+	mockMessagePage(mdi, mdm, msg1, msg2)
+
+	processed, err := bm.processPage(bm.ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, processed)
+	assert.Equal(t, int64(1002), bm.readOffset)
+	assert.False(t, bm.lastPageFull)
+	assert.False(t, bm.pageYielded)
+
+	assert.Equal(t, msg1.Header.ID, (<-dispatched).Messages[0].Header.ID)
+	assert.Equal(t, msg2.Header.ID, (<-dispatched).Messages[0].Header.ID)
+}
+
+func TestProcessPageUntilCaughtUp(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+	bm.readPageSize = 2
+
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 10, DisposeTimeout: 120 * time.Second},
+	)
+
+	// A full page, followed by a partial page
+	mockMessagePage(mdi, mdm, newTestBroadcastMessage(1001), newTestBroadcastMessage(1002))
+	mockMessagePage(mdi, mdm, newTestBroadcastMessage(1003))
+
+	assert.Equal(t, 3, processUntilCaughtUp(t, bm))
+	assert.Equal(t, int64(1003), bm.readOffset)
+	mdi.AssertNumberOfCalls(t, "GetMessageIDs", 2)
+}
+
+func TestProcessPageEmpty(t *testing.T) {
+	bm, mdi, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	processed, err := bm.processPage(bm.ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, processed)
+	assert.Equal(t, int64(1000), bm.readOffset)
+}
+
+func TestProcessPageYieldCancelled(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+	bm.iterationBudget = 1 * time.Millisecond
+
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+
+	// Close while we are working on the first message of the page
+	msg1 := newTestBroadcastMessage(1001)
+	msg2 := newTestBroadcastMessage(1002)
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg1.Header.ID).Return(msg1, core.DataArray{}, true, nil).Run(func(args mock.Arguments) {
+		cancel()
+		time.Sleep(5 * time.Millisecond)
+	})
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{
+		{ID: *msg1.Header.ID, Sequence: msg1.Sequence},
+		{ID: *msg2.Header.ID, Sequence: msg2.Sequence},
+	}, nil).Once()
+
+	processed, err := bm.processPage(bm.ctx)
+	assert.Regexp(t, "FF00154", err)
+	assert.Equal(t, 1, processed)
+	assert.True(t, bm.pageYielded)
+	assert.Equal(t, int64(1001), bm.readOffset)
+}
+
+func TestProcessPageReadFail(t *testing.T) {
+	bm, mdi, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.retry.MaximumDelay = 1 * time.Microsecond
+
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(nil, fmt.Errorf("pop")).Run(func(args mock.Arguments) {
+		cancel()
+	})
+
+	_, err := bm.processPage(bm.ctx)
+	assert.Error(t, err)
+}
+
+func TestProcessPageLogFields(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+
+	var readFields, assembleFields []logrus.Fields
+	msg := newTestBroadcastMessage(1001)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{{ID: *msg.Header.ID, Sequence: msg.Sequence}}, nil).Once().Run(func(args mock.Arguments) {
+		readFields = append(readFields, log.L(args[0].(context.Context)).Data)
+	})
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil).Once().Run(func(args mock.Arguments) {
+		readFields = append(readFields, log.L(args[0].(context.Context)).Data)
+	})
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil).Run(func(args mock.Arguments) {
+		assembleFields = append(assembleFields, log.L(args[0].(context.Context)).Data)
+	})
+
+	ctx := log.WithLogField(bm.ctx, "ns", "ns1")
+	_, err := bm.processPage(ctx)
+	assert.NoError(t, err)
+	_, err = bm.processPage(ctx)
+	assert.NoError(t, err)
+
+	// The correlation ID is carried through assembly of the page, and regenerated for the next page
+	assert.Len(t, readFields, 2)
+	assert.Equal(t, "ns1", readFields[0]["ns"])
+	assert.NotEmpty(t, readFields[0]["page"])
+	assert.NotEqual(t, readFields[0]["page"], readFields[1]["page"])
+	assert.Len(t, assembleFields, 1)
+	assert.Equal(t, readFields[0]["page"], assembleFields[0]["page"])
+	assert.Equal(t, "1000", assembleFields[0]["offset"])
+	assert.Equal(t, msg.Header.ID.String(), assembleFields[0]["msg"])
+}
+
+func TestGroupByProcessors(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeUnpinned, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{
+			BatchMaxSize: 2,
+			GroupBy: func(msg *core.Message) string {
+				return msg.Header.Topics[0]
+			},
+		},
+	)
+
+	msg1 := newTestBroadcastMessage(1001)
+	msg2 := newTestBroadcastMessage(1002)
+	msg2.Header.Topics = core.FFStringArray{"topic2"}
+	msg3 := newTestBroadcastMessage(1003)
+	msg3.Header.Topics = core.FFStringArray{""}
+	for _, msg := range []*core.Message{msg1, msg2, msg3} {
+		msg.Header.TxType = core.TransactionTypeUnpinned
+	}
+
+	bp1, err := bm.getMessageProcessor(msg1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "did:firefly:org/abcd||groupBy=topic1", bp1.conf.name)
+	assert.Equal(t, uint(2), bp1.conf.BatchMaxSize)
+	bp2, err := bm.getMessageProcessor(msg2, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "did:firefly:org/abcd||groupBy=topic2", bp2.conf.name)
+
+	// An empty key, or no message, is the default group
+	bp3, err := bm.getMessageProcessor(msg3, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "did:firefly:org/abcd|", bp3.conf.name)
+	bp4, err := bm.getProcessor(msg1.Header.TxType, msg1.Header.Type, nil, &msg1.Header.SignerRef, 0, "")
+	assert.NoError(t, err)
+	assert.Equal(t, bp3, bp4)
+}
+
+func TestGroupByPartitionsBatches(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	dispatched := make(chan *DispatchState, 2)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeUnpinned, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:   2,
+			BatchMaxBytes:  1024 * 1024,
+			BatchTimeout:   time.Minute,
+			DisposeTimeout: 120 * time.Second,
+			GroupBy: func(msg *core.Message) string {
+				return msg.Header.Topics[0]
+			},
+		},
+	)
+
+	// Messages for two topics interleave in the page, but are never co-batched
+	msgs := make([]*core.Message, 4)
+	for i := range msgs {
+		msgs[i] = newTestBroadcastMessage(int64(1001 + i))
+		msgs[i].Header.TxType = core.TransactionTypeUnpinned
+		msgs[i].Header.Topics = core.FFStringArray{fmt.Sprintf("topic%d", i%2)}
+	}
+	mockMessagePage(mdi, mdm, msgs...)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	batchTopics := make(map[string][]*fftypes.UUID)
+	for i := 0; i < 2; i++ {
+		state := <-dispatched
+		assert.Len(t, state.Messages, 2)
+		topic := state.Messages[0].Header.Topics[0]
+		for _, msg := range state.Messages {
+			assert.Equal(t, topic, msg.Header.Topics[0])
+			batchTopics[topic] = append(batchTopics[topic], msg.Header.ID)
+		}
+	}
+	assert.Equal(t, []*fftypes.UUID{msgs[0].Header.ID, msgs[2].Header.ID}, batchTopics["topic0"])
+	assert.Equal(t, []*fftypes.UUID{msgs[1].Header.ID, msgs[3].Header.ID}, batchTopics["topic1"])
+
+	cancel()
+	bm.WaitStop()
+}
@@ -0,0 +1,54 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+)
+
+// batchMaxAgeTimer is armed for each open batch of a processor with a BatchMaxAge, and is only used by the
+// assembly loop
+type batchMaxAgeTimer struct {
+	timer      Timer
+	assemblyID *fftypes.UUID
+}
+
+func (t *batchMaxAgeTimer) stop() {
+	if t.timer != nil {
+		_ = t.timer.Stop()
+		t.timer = nil
+		t.assemblyID = nil
+	}
+}
+
+// maxAgeExpiry returns a channel that fires when the open batch reaches the BatchMaxAge, measured from when its
+// first message was assembled, or nil if there is no open batch or no maximum age. The timer is armed once for
+// each batch, so it is not affected by the batch timeout being re-armed.
+func (bp *batchProcessor) maxAgeExpiry() <-chan time.Time {
+	if bp.conf.BatchMaxAge <= 0 || len(bp.assemblyQueue) == 0 {
+		bp.maxAge.stop()
+		return nil
+	}
+	if bp.maxAge.timer == nil || !bp.maxAge.assemblyID.Equals(bp.assemblyID) {
+		bp.maxAge.stop()
+		bp.maxAge.assemblyID = bp.assemblyID
+		bp.maxAge.timer = bp.bm.clock.NewTimer(bp.conf.BatchMaxAge - bp.bm.since(bp.assemblyStarted))
+	}
+	return bp.maxAge.timer.C()
+}
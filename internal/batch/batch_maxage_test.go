@@ -0,0 +1,94 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchMaxAgeSealsBeforeTimeout(t *testing.T) {
+	bm, _, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	dispatched := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:   10,
+			BatchMaxBytes:  1024 * 1024,
+			BatchTimeout:   time.Hour,
+			BatchMaxAge:    10 * time.Millisecond,
+			DisposeTimeout: 120 * time.Second,
+		},
+	)
+
+	msg := newTestBroadcastMessage(1001)
+	processor, err := bm.getProcessor(msg.Header.TxType, msg.Header.Type, msg.Header.Group, &msg.Header.SignerRef, 0, "")
+	assert.NoError(t, err)
+	bm.dispatchMessage(bm.ctx, &pendingDispatch{processor: processor, msg: msg})
+
+	state := <-dispatched
+	assert.Len(t, state.Messages, 1)
+	assert.Eventually(t, func() bool {
+		return bm.DispatcherStats()[core.MessageTypeBroadcast].FlushedByMaxAge == 1
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestBatchMaxAgeExpiry(t *testing.T) {
+	cancel, _, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+
+	// No expiry without a maximum age, or without an open batch
+	bp.addWork(&batchWork{msg: newTestBroadcastMessage(1001)})
+	assert.Nil(t, bp.maxAgeExpiry())
+	bp.conf.BatchMaxAge = time.Hour
+	bp.newAssembly()
+	assert.Nil(t, bp.maxAgeExpiry())
+
+	// The timer is armed once for each batch
+	bp.addWork(&batchWork{msg: newTestBroadcastMessage(1002)})
+	expiry := bp.maxAgeExpiry()
+	assert.NotNil(t, expiry)
+	bp.addWork(&batchWork{msg: newTestBroadcastMessage(1003)})
+	assert.Equal(t, expiry, bp.maxAgeExpiry())
+	bp.startFlush(true)
+	assert.NotEqual(t, expiry, bp.maxAgeExpiry())
+
+	bp.maxAge.stop()
+	assert.Nil(t, bp.maxAge.timer)
+}
+
+func TestOpenBatchTimerMaxAge(t *testing.T) {
+	cancel, _, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	bp.conf.BatchTimeout = time.Hour
+	bp.conf.BatchMaxAge = time.Minute
+
+	bp.addWork(&batchWork{msg: newTestBroadcastMessage(1001)})
+	timer := bp.openBatchTimer()
+	assert.Greater(t, timer.TimeoutRemaining, fftypes.FFDuration(0))
+	assert.LessOrEqual(t, timer.TimeoutRemaining, fftypes.FFDuration(time.Minute))
+}
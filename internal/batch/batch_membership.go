@@ -0,0 +1,64 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"github.com/hyperledger/firefly-common/pkg/log"
+)
+
+// splitByGroupMembership applies the GroupMembership constraint of the dispatcher to a batch about to be sealed. The
+// batch is kept up to the first message whose author shares no group with the authors before it, and the rest of the
+// work is returned to the front of the assembly - so it is assembled into the next batch, in order.
+func (bp *batchProcessor) splitByGroupMembership(flushWork []*batchWork, byteSize int64) ([]*batchWork, int64, error) {
+	if bp.conf.GroupMembership == nil || len(flushWork) < 2 {
+		return flushWork, byteSize, nil
+	}
+
+	var common map[string]bool
+	authors := make(map[string]bool)
+	for i, w := range flushWork {
+		author := w.msg.Header.Author
+		if authors[author] {
+			continue
+		}
+		var groups []string
+		err := bp.retry.Do(bp.ctx, "group membership", func(attempt int) (retry bool, err error) {
+			groups, err = bp.conf.GroupMembership(bp.ctx, author)
+			return true, err
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		inCommon := make(map[string]bool, len(groups))
+		for _, group := range groups {
+			if common == nil || common[group] {
+				inCommon[group] = true
+			}
+		}
+		if common != nil && len(inCommon) == 0 {
+			log.L(bp.ctx).Infof("Splitting batch at message %s, as author '%s' shares no group with the %d authors before it", w.msg.Header.ID, author, len(authors))
+			bp.requeueWork(flushWork[i:])
+			for _, r := range flushWork[i:] {
+				byteSize -= r.estimateSize()
+			}
+			return flushWork[:i], byteSize, nil
+		}
+		common = inCommon
+		authors[author] = true
+	}
+	return flushWork, byteSize, nil
+}
@@ -0,0 +1,113 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/mocks/identitymanagermocks"
+	"github.com/hyperledger/firefly/mocks/txcommonmocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var testGroupMembership = map[string][]string{
+	"did:firefly:org/abcd": {"group1", "group2"},
+	"did:firefly:org/efgh": {"group1"},
+	"did:firefly:org/ijkl": {"group2"},
+}
+
+func lookupTestGroupMembership(ctx context.Context, author string) ([]string, error) {
+	return testGroupMembership[author], nil
+}
+
+func TestGroupMembershipSplitsBatch(t *testing.T) {
+	dispatched := make(chan *DispatchState, 2)
+	cancel, mdi, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		dispatched <- state
+		return nil
+	})
+	defer cancel()
+	bp.conf.GroupMembership = lookupTestGroupMembership
+	mockRunAsGroupPassthrough(mdi)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	bp.txHelper.(*txcommonmocks.Helper).On("SubmitNewTransaction", mock.Anything, core.TransactionTypeBatchPin).Return(fftypes.NewUUID(), nil)
+	bp.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+	bp.bm.identity.(*identitymanagermocks.Manager).On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
+
+	// The first two authors are both in group1, but the third is only in group2 - so cannot join them
+	authors := []string{"did:firefly:org/abcd", "did:firefly:org/efgh", "did:firefly:org/abcd", "did:firefly:org/ijkl"}
+	msgs := make([]*core.Message, len(authors))
+	for i, author := range authors {
+		msgs[i] = &core.Message{Header: core.MessageHeader{ID: fftypes.NewUUID(), SignerRef: core.SignerRef{Author: author}}, Sequence: int64(1001 + i)}
+		bp.newWork <- &batchWork{msg: msgs[i]}
+	}
+
+	// The batch is split along group lines before dispatch, keeping the messages in order
+	state1 := <-dispatched
+	state2 := <-dispatched
+	assert.Len(t, state1.Messages, 3)
+	for i, msg := range state1.Messages {
+		assert.Equal(t, msgs[i].Header.ID, msg.Header.ID)
+	}
+	assert.Len(t, state2.Messages, 1)
+	assert.Equal(t, msgs[3].Header.ID, state2.Messages[0].Header.ID)
+
+	bp.cancelCtx()
+	<-bp.done
+}
+
+func TestGroupMembershipNoCommonGroup(t *testing.T) {
+	cancel, _, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	bp.conf.GroupMembership = lookupTestGroupMembership
+
+	// An author in no group can only be batched with their own messages
+	flushWork := []*batchWork{
+		{msg: &core.Message{Header: core.MessageHeader{ID: fftypes.NewUUID(), SignerRef: core.SignerRef{Author: "did:firefly:org/mnop"}}}},
+		{msg: &core.Message{Header: core.MessageHeader{ID: fftypes.NewUUID(), SignerRef: core.SignerRef{Author: "did:firefly:org/mnop"}}}},
+		{msg: &core.Message{Header: core.MessageHeader{ID: fftypes.NewUUID(), SignerRef: core.SignerRef{Author: "did:firefly:org/abcd"}}}},
+	}
+	byteSize := flushWork[0].estimateSize() + flushWork[1].estimateSize() + flushWork[2].estimateSize()
+	kept, keptSize, err := bp.splitByGroupMembership(flushWork, byteSize)
+	assert.NoError(t, err)
+	assert.Equal(t, flushWork[:2], kept)
+	assert.Equal(t, byteSize-flushWork[2].estimateSize(), keptSize)
+	assert.Equal(t, flushWork[2:], bp.assemblyQueue)
+}
+
+func TestGroupMembershipLookupFail(t *testing.T) {
+	cancel, _, bp := newTestBatchProcessor(t, nil)
+	bp.conf.GroupMembership = func(ctx context.Context, author string) ([]string, error) {
+		return nil, fmt.Errorf("pop")
+	}
+	cancel()
+	bp.cancelCtx()
+
+	flushWork := []*batchWork{
+		{msg: &core.Message{Header: core.MessageHeader{ID: fftypes.NewUUID(), SignerRef: core.SignerRef{Author: "did:firefly:org/abcd"}}}},
+		{msg: &core.Message{Header: core.MessageHeader{ID: fftypes.NewUUID(), SignerRef: core.SignerRef{Author: "did:firefly:org/efgh"}}}},
+	}
+	_, _, err := bp.splitByGroupMembership(flushWork, 0)
+	assert.Regexp(t, "FF00154", err)
+}
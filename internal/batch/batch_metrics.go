@@ -0,0 +1,92 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// MetricsOffsetName is the prometheus metric for the sequence the batch manager has read up to
+	MetricsOffsetName = "ff_batch_manager_offset"
+	// MetricsLagName is the prometheus metric for the number of sequences between the offset and the highest message
+	MetricsLagName = "ff_batch_manager_lag"
+	// MetricsBatchesSealedName is the prometheus metric for the total number of batches sealed
+	MetricsBatchesSealedName = "ff_batch_manager_batches_sealed_total"
+	// MetricsMessagesDispatchedName is the prometheus metric for the total number of messages dispatched in batches
+	MetricsMessagesDispatchedName = "ff_batch_manager_messages_dispatched_total"
+	// MetricsDispatchErrorsName is the prometheus metric for the total number of failed batch dispatch attempts
+	MetricsDispatchErrorsName = "ff_batch_manager_dispatch_errors_total"
+	// MetricsAssemblyRetriesName is the prometheus metric for the total number of failures to assemble a message
+	MetricsAssemblyRetriesName = "ff_batch_manager_assembly_retries_total"
+
+	NamespaceLabelName   = "ns"
+	MessageTypeLabelName = "type"
+)
+
+// metricsCollector reads the batch manager's own counters each time it is scraped, rather than maintaining a
+// second copy of them. Message types are only known once assembled, so assembly retries are labeled by namespace
+// alone. Note that ResetDispatcherStats also resets the per-type counters, which Prometheus treats as a restart.
+type metricsCollector struct {
+	bm                 *batchManager
+	offset             *prometheus.Desc
+	lag                *prometheus.Desc
+	batchesSealed      *prometheus.Desc
+	messagesDispatched *prometheus.Desc
+	dispatchErrors     *prometheus.Desc
+	assemblyRetries    *prometheus.Desc
+}
+
+// RegisterMetrics registers a collector for the internals of the batch manager with the supplied registry
+func (bm *batchManager) RegisterMetrics(registry *prometheus.Registry) {
+	nsLabels := []string{NamespaceLabelName}
+	typeLabels := []string{NamespaceLabelName, MessageTypeLabelName}
+	registry.MustRegister(&metricsCollector{
+		bm:                 bm,
+		offset:             prometheus.NewDesc(MetricsOffsetName, "Sequence the batch manager has read up to", nsLabels, nil),
+		lag:                prometheus.NewDesc(MetricsLagName, "Number of sequences between the batch manager offset and the highest message", nsLabels, nil),
+		batchesSealed:      prometheus.NewDesc(MetricsBatchesSealedName, "Number of batches sealed", typeLabels, nil),
+		messagesDispatched: prometheus.NewDesc(MetricsMessagesDispatchedName, "Number of messages dispatched in batches", typeLabels, nil),
+		dispatchErrors:     prometheus.NewDesc(MetricsDispatchErrorsName, "Number of failed batch dispatch attempts", typeLabels, nil),
+		assemblyRetries:    prometheus.NewDesc(MetricsAssemblyRetriesName, "Number of failures to assemble a message, each of which is retried", nsLabels, nil),
+	})
+}
+
+func (mc *metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- mc.offset
+	ch <- mc.lag
+	ch <- mc.batchesSealed
+	ch <- mc.messagesDispatched
+	ch <- mc.dispatchErrors
+	ch <- mc.assemblyRetries
+}
+
+func (mc *metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	bm := mc.bm
+	status := &ManagerStatus{}
+	bm.lagStatus(status)
+	ch <- prometheus.MustNewConstMetric(mc.offset, prometheus.GaugeValue, float64(status.Offset), bm.namespace)
+	ch <- prometheus.MustNewConstMetric(mc.lag, prometheus.GaugeValue, float64(status.Lag), bm.namespace)
+	for msgType, stats := range bm.DispatcherStats() {
+		ch <- prometheus.MustNewConstMetric(mc.batchesSealed, prometheus.CounterValue, float64(stats.TotalBatches), bm.namespace, msgType.String())
+		ch <- prometheus.MustNewConstMetric(mc.messagesDispatched, prometheus.CounterValue, float64(stats.TotalMessages), bm.namespace, msgType.String())
+		ch <- prometheus.MustNewConstMetric(mc.dispatchErrors, prometheus.CounterValue, float64(stats.DispatchErrors), bm.namespace, msgType.String())
+	}
+	ch <- prometheus.MustNewConstMetric(mc.assemblyRetries, prometheus.CounterValue, float64(atomic.LoadInt64(&bm.assemblyRetries)), bm.namespace)
+}
@@ -0,0 +1,83 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func gatherTestMetrics(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, m := range family.Metric {
+			name := family.GetName()
+			for _, label := range m.Label {
+				name = fmt.Sprintf("%s|%s=%s", name, label.GetName(), label.GetValue())
+			}
+			if m.Gauge != nil {
+				values[name] = m.Gauge.GetValue()
+			} else {
+				values[name] = m.Counter.GetValue()
+			}
+		}
+	}
+	return values
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 1},
+	)
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{{ID: *fftypes.NewUUID(), Sequence: 1010}}, nil)
+	bm.offsetEnabled = true
+	bm.committedOffset = 1000
+
+	bm.recordFlush([]*core.Message{newTestBroadcastMessage(1001), newTestBroadcastMessage(1002)}, flushTriggerSize)
+	bm.recordDispatchError([]*core.Message{newTestBroadcastMessage(1001)})
+	bm.recordAssemblyFailure(fftypes.NewUUID(), 1001, nil)
+
+	registry := prometheus.NewRegistry()
+	bm.RegisterMetrics(registry)
+	assert.Equal(t, map[string]float64{
+		"ff_batch_manager_offset|ns=ns1":                                   1000,
+		"ff_batch_manager_lag|ns=ns1":                                      10,
+		"ff_batch_manager_batches_sealed_total|ns=ns1|type=broadcast":      1,
+		"ff_batch_manager_messages_dispatched_total|ns=ns1|type=broadcast": 2,
+		"ff_batch_manager_dispatch_errors_total|ns=ns1|type=broadcast":     1,
+		"ff_batch_manager_assembly_retries_total|ns=ns1":                   1,
+	}, gatherTestMetrics(t, registry))
+
+	// A second registration with the same registry is rejected
+	assert.Panics(t, func() {
+		bm.RegisterMetrics(registry)
+	})
+}
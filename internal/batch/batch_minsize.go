@@ -0,0 +1,24 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+// minSizeReached is true once the open batch holds at least the BatchMinSize, and no further work is waiting for the
+// processor - so under load the batch continues to fill towards the maximum, but in quiet periods it is sealed without
+// waiting for the batch timeout
+func (bp *batchProcessor) minSizeReached() bool {
+	return bp.conf.BatchMinSize > 0 && len(bp.assemblyQueue) >= int(bp.conf.BatchMinSize) && len(bp.newWork) == 0
+}
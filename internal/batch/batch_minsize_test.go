@@ -0,0 +1,102 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func registerMinSizeDispatcher(bm *batchManager, dispatched chan *DispatchState, minSize uint, timeout time.Duration) {
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:   10,
+			BatchMinSize:   minSize,
+			BatchMaxBytes:  1024 * 1024,
+			BatchTimeout:   timeout,
+			DisposeTimeout: 120 * time.Second,
+		},
+	)
+}
+
+func TestBatchMinSizeSealsBeforeTimeout(t *testing.T) {
+	bm, _, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	dispatched := make(chan *DispatchState, 1)
+	registerMinSizeDispatcher(bm, dispatched, 2, time.Hour)
+
+	for _, msg := range []*core.Message{newTestBroadcastMessage(1001), newTestBroadcastMessage(1002)} {
+		processor, err := bm.getProcessor(msg.Header.TxType, msg.Header.Type, msg.Header.Group, &msg.Header.SignerRef, 0, "")
+		assert.NoError(t, err)
+		bm.dispatchMessage(bm.ctx, &pendingDispatch{processor: processor, msg: msg})
+	}
+
+	state := <-dispatched
+	assert.Len(t, state.Messages, 2)
+	assert.Equal(t, core.BatchSealReasonSize, state.Persisted.SealReason)
+	assert.Eventually(t, func() bool {
+		return bm.DispatcherStats()[core.MessageTypeBroadcast].FlushedBySize == 1
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestBatchMinSizeTimeoutUnderMinimum(t *testing.T) {
+	bm, _, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	dispatched := make(chan *DispatchState, 1)
+	registerMinSizeDispatcher(bm, dispatched, 5, 10*time.Millisecond)
+
+	msg := newTestBroadcastMessage(1001)
+	processor, err := bm.getProcessor(msg.Header.TxType, msg.Header.Type, msg.Header.Group, &msg.Header.SignerRef, 0, "")
+	assert.NoError(t, err)
+	bm.dispatchMessage(bm.ctx, &pendingDispatch{processor: processor, msg: msg})
+
+	state := <-dispatched
+	assert.Len(t, state.Messages, 1)
+	assert.Equal(t, core.BatchSealReasonTimeout, state.Persisted.SealReason)
+	assert.Eventually(t, func() bool {
+		return bm.DispatcherStats()[core.MessageTypeBroadcast].FlushedByTimeout == 1
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestMinSizeReached(t *testing.T) {
+	bp := &batchProcessor{
+		conf:    &batchProcessorConf{DispatcherOptions: DispatcherOptions{BatchMinSize: 2}},
+		newWork: make(chan *batchWork, 1),
+	}
+	bp.assemblyQueue = []*batchWork{{msg: newTestBroadcastMessage(1001)}}
+	assert.False(t, bp.minSizeReached())
+
+	// Work waiting for the processor is added to the batch before it is sealed
+	bp.assemblyQueue = append(bp.assemblyQueue, &batchWork{msg: newTestBroadcastMessage(1002)})
+	bp.newWork <- &batchWork{msg: newTestBroadcastMessage(1003)}
+	assert.False(t, bp.minSizeReached())
+	<-bp.newWork
+	assert.True(t, bp.minSizeReached())
+
+	bp.conf.BatchMinSize = 0
+	assert.False(t, bp.minSizeReached())
+}
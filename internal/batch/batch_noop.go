@@ -0,0 +1,112 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+)
+
+// readableMessageStates are the states of the messages the sequencer reads. Deferred messages are excluded, so they
+// are only read again once ReleaseDeferred has returned them to ready - otherwise a no-op dispatcher would re-read
+// and re-defer them on every rewind.
+var readableMessageStates = []driver.Value{core.MessageStateReady}
+
+// RegisterNoOpDispatcher registers a dispatcher for message types that do not have a transport yet. Their messages
+// are batched as normal, but instead of being sealed and dispatched each batch is marked deferred - so the offset
+// advances past them without them being sent anywhere. Registering a real dispatcher for the types later replaces
+// the no-op dispatcher, and ReleaseDeferred then replays the deferred messages.
+func (bm *batchManager) RegisterNoOpDispatcher(name string, txType core.TransactionType, msgTypes []core.MessageType, options DispatcherOptions) {
+	bm.registerDispatcher(&dispatcher{
+		name:       name,
+		txType:     txType,
+		msgTypes:   msgTypes,
+		options:    options,
+		processors: make(map[string]*batchProcessor),
+		noOp:       true,
+	})
+}
+
+// flushDeferred completes the flush of a batch assembled by a no-op dispatcher, marking its messages deferred
+func (bp *batchProcessor) flushDeferred(flushWork []*batchWork, byteSize int64, trigger flushTrigger) error {
+	state := &DispatchState{}
+	for _, w := range flushWork {
+		state.Messages = append(state.Messages, w.msg)
+		state.Data = append(state.Data, w.data...)
+	}
+	err := bp.retry.Do(bp.ctx, "mark deferred messages", func(attempt int) (retry bool, err error) {
+		err = bp.bm.runAsGroup(bp.ctx, func(ctx context.Context) error {
+			return bp.markMessagesDeferred(ctx, state.Messages)
+		})
+		return bp.bm.isRetryable(err), err
+	})
+	if err != nil {
+		return err
+	}
+	for _, msg := range state.Messages {
+		msg.State = core.MessageStateDeferred
+		bp.data.UpdateMessageIfCached(bp.ctx, msg)
+	}
+	log.L(bp.ctx).Debugf("Deferred %d messages with no transport", len(state.Messages))
+
+	bp.completeFlush(state, flushWork, byteSize, trigger)
+	return nil
+}
+
+func (bp *batchProcessor) markMessagesDeferred(ctx context.Context, msgs []*core.Message) error {
+	msgIDs := make([]driver.Value, len(msgs))
+	for i, msg := range msgs {
+		msgIDs[i] = msg.Header.ID
+	}
+	fb := database.MessageQueryFactory.NewFilter(ctx)
+	filter := fb.And(
+		fb.In("id", msgIDs),
+		fb.In("state", readableMessageStates),
+	)
+	update := database.MessageQueryFactory.NewUpdate(ctx).Set("state", core.MessageStateDeferred)
+	return bp.database.UpdateMessages(ctx, bp.bm.namespace, filter, update)
+}
+
+// ReleaseDeferred returns the messages of the given types that were deferred by a no-op dispatcher to ready, and
+// rewinds the manager to read them again. It should be called once a real dispatcher has been registered for the
+// types - while a no-op dispatcher is still registered, the released messages are simply deferred again.
+func (bm *batchManager) ReleaseDeferred(ctx context.Context, msgTypes []core.MessageType) error {
+	types := make([]driver.Value, len(msgTypes))
+	for i, msgType := range msgTypes {
+		types[i] = msgType
+	}
+	fb := database.MessageQueryFactory.NewFilterLimit(ctx, 1)
+	filter := fb.And(
+		fb.Eq("state", core.MessageStateDeferred),
+		fb.In("type", types),
+	)
+	earliest, err := bm.database.GetMessageIDs(ctx, bm.namespace, filter.Sort("sequence").Limit(1))
+	if err != nil || len(earliest) == 0 {
+		return err
+	}
+
+	update := database.MessageQueryFactory.NewUpdate(ctx).Set("state", core.MessageStateReady)
+	if err := bm.database.UpdateMessages(ctx, bm.namespace, filter, update); err != nil {
+		return err
+	}
+	log.L(ctx).Infof("Released deferred messages of types %v from sequence %d", msgTypes, earliest[0].Sequence)
+	return bm.Rewind(ctx, earliest[0].Sequence-1)
+}
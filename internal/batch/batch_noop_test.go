@@ -0,0 +1,177 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNoOpDispatcherDefersMessages(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdm := bm.data.(*datamocks.Manager)
+	mockRunAsGroupPassthrough(mdi)
+	mdm.On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+
+	deferred := make(chan string, 1)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		fi, _ := args[2].(database.Filter).Finalize()
+		ui, _ := args[3].(database.Update).Finalize()
+		v, _ := ui.SetOperations[0].Value.Value()
+		deferred <- fmt.Sprintf("%s -> %s", fi, v)
+	}).Return(nil)
+
+	bm.RegisterNoOpDispatcher("pending", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		DispatcherOptions{
+			BatchMaxSize:   2,
+			BatchMaxBytes:  1024 * 1024,
+			BatchTimeout:   time.Minute,
+			DisposeTimeout: time.Minute,
+		},
+	)
+
+	msgs := []*core.Message{newTestBroadcastMessage(1001), newTestBroadcastMessage(1002)}
+	mockMessagePage(mdi, mdm, msgs...)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	// The batch is marked deferred without being sealed, and the offset advances past it
+	assert.Equal(t, fmt.Sprintf("( id IN ['%s','%s'] ) && ( state IN ['ready'] ) -> deferred", msgs[0].Header.ID, msgs[1].Header.ID), <-deferred)
+	for {
+		bm.inflightMux.Lock()
+		offset := bm.calcCommittableOffset()
+		bm.inflightMux.Unlock()
+		if offset == 1002 {
+			break
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+	assert.Equal(t, core.MessageStateDeferred, msgs[0].State)
+	mdi.AssertNotCalled(t, "UpsertBatch", mock.Anything, mock.Anything)
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestNoOpDispatcherReplacedByRealDispatcher(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.RegisterNoOpDispatcher("pending", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast}, DispatcherOptions{})
+	msg := newTestBroadcastMessage(1001)
+
+	bp, err := bm.getProcessor(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, nil, &msg.Header.SignerRef, 0, "")
+	assert.NoError(t, err)
+	assert.True(t, bp.conf.noOp)
+
+	bm.RegisterDispatcher("real", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil }, DispatcherOptions{BatchMaxSize: 1})
+	bp, err = bm.getProcessor(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, nil, &msg.Header.SignerRef, 0, "")
+	assert.NoError(t, err)
+	assert.False(t, bp.conf.noOp)
+	assert.Equal(t, "real", bp.conf.dispatcherName)
+}
+
+func TestFlushDeferredFail(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	bp.conf.noOp = true
+	bp.bm.SetRetryableError(isTestRetryable)
+	mockRunAsGroupPassthrough(mdi)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(errTestPermanent).Once()
+
+	bp.addWork(&batchWork{msg: newTestBroadcastMessage(1001)})
+	err := bp.flush(false, flushTriggerSize)
+	assert.ErrorIs(t, err, errTestPermanent)
+	assert.Empty(t, bp.bm.inflightFlushed)
+	mdi.AssertExpectations(t)
+}
+
+func TestReleaseDeferredRewindsToEarliest(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	bm.readOffset = 2000
+
+	// The earliest deferred message of the types is found, then all of them are returned to ready
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.MatchedBy(func(filter database.Filter) bool {
+		fi, _ := filter.Finalize()
+		return fi.String() == "( state == 'deferred' ) && ( type IN ['broadcast'] ) sort=sequence limit=1"
+	})).Return([]*core.IDAndSequence{{ID: *fftypes.NewUUID(), Sequence: 1001}}, nil)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.MatchedBy(func(update database.Update) bool {
+		ui, _ := update.Finalize()
+		v, _ := ui.SetOperations[0].Value.Value()
+		return v == "ready"
+	})).Return(nil)
+
+	released := make(chan error)
+	go func() {
+		released <- bm.ReleaseDeferred(context.Background(), []core.MessageType{core.MessageTypeBroadcast})
+	}()
+	bm.applyRewind(<-bm.rewinds)
+	assert.NoError(t, <-released)
+
+	// The sequencer reads the released messages again
+	assert.Equal(t, int64(1000), bm.readOffset)
+	mdi.AssertExpectations(t)
+}
+
+func TestReleaseDeferredNoneDeferred(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.ReleaseDeferred(context.Background(), []core.MessageType{core.MessageTypeBroadcast})
+	assert.NoError(t, err)
+	mdi.AssertNotCalled(t, "UpdateMessages", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestReleaseDeferredQueryFail(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(nil, fmt.Errorf("pop"))
+
+	err := bm.ReleaseDeferred(context.Background(), []core.MessageType{core.MessageTypeBroadcast})
+	assert.Regexp(t, "pop", err)
+}
+
+func TestReleaseDeferredUpdateFail(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{{ID: *fftypes.NewUUID(), Sequence: 1001}}, nil)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(fmt.Errorf("pop"))
+
+	err := bm.ReleaseDeferred(context.Background(), []core.MessageType{core.MessageTypeBroadcast})
+	assert.Regexp(t, "pop", err)
+}
@@ -0,0 +1,129 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"sync"
+)
+
+const (
+	// notificationPolicyCoalesce merges a notification that arrives when the buffer is full into the newest buffered notification
+	notificationPolicyCoalesce = "coalesce"
+	// notificationPolicyDropOldest drops the oldest buffered notification to make room for a notification that arrives when the buffer is full
+	notificationPolicyDropOldest = "dropOldest"
+)
+
+// notificationBuffer is a bounded ring buffer of the sequences of new messages, so that notifying the batch manager of
+// new messages never blocks message insertion. The notifications are only a wake-up, with a rewind to the lowest sequence,
+// so notifications can be merged by keeping the lower sequence. Duplicate sequences are always coalesced, and notifications
+// that overflow the buffer are merged according to the policy - meaning a notification is never lost.
+type notificationBuffer struct {
+	mux       sync.Mutex
+	policy    string
+	entries   []int64
+	head      int
+	count     int
+	buffered  map[int64]bool
+	coalesced int64
+	ready     chan struct{}
+}
+
+func newNotificationBuffer(size int, policy string) *notificationBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &notificationBuffer{
+		policy:   policy,
+		entries:  make([]int64, size),
+		buffered: make(map[int64]bool),
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+func minSequence(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (nb *notificationBuffer) push(seq int64) {
+	nb.mux.Lock()
+	defer func() {
+		nb.mux.Unlock()
+		select {
+		case nb.ready <- struct{}{}:
+		default:
+		}
+	}()
+
+	if nb.buffered[seq] {
+		nb.coalesced++
+		return
+	}
+	size := len(nb.entries)
+	if nb.count == size {
+		nb.coalesced++
+		if nb.policy == notificationPolicyDropOldest && size > 1 {
+			// Drop the oldest - folding its sequence into the next oldest, so a rewind is never lost
+			oldest := nb.entries[nb.head]
+			delete(nb.buffered, oldest)
+			nb.head = (nb.head + 1) % size
+			nb.count--
+			next := nb.entries[nb.head]
+			if oldest < next {
+				delete(nb.buffered, next)
+				nb.entries[nb.head] = oldest
+				nb.buffered[oldest] = true
+			}
+		} else {
+			// Merge into the newest notification, keeping the lower of the sequences
+			newest := (nb.head + nb.count - 1) % size
+			delete(nb.buffered, nb.entries[newest])
+			nb.entries[newest] = minSequence(nb.entries[newest], seq)
+			nb.buffered[nb.entries[newest]] = true
+			return
+		}
+	}
+	nb.entries[(nb.head+nb.count)%size] = seq
+	nb.buffered[seq] = true
+	nb.count++
+}
+
+// popAll removes and returns all buffered notifications, oldest first
+func (nb *notificationBuffer) popAll() []int64 {
+	nb.mux.Lock()
+	defer nb.mux.Unlock()
+	seqs := make([]int64, nb.count)
+	for i := range seqs {
+		seqs[i] = nb.entries[(nb.head+i)%len(nb.entries)]
+	}
+	nb.head, nb.count = 0, 0
+	nb.buffered = make(map[int64]bool)
+	return seqs
+}
+
+func (nb *notificationBuffer) getCoalesced() int64 {
+	nb.mux.Lock()
+	defer nb.mux.Unlock()
+	return nb.coalesced
+}
+
+// NotifyNewMessage notifies the batch manager of a new message, without blocking
+func (bm *batchManager) NotifyNewMessage(seq int64) {
+	bm.notifications.push(seq)
+}
@@ -0,0 +1,104 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly/internal/coreconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotificationBufferCoalescesDuplicates(t *testing.T) {
+	nb := newNotificationBuffer(10, notificationPolicyCoalesce)
+	nb.push(1001)
+	nb.push(1002)
+	nb.push(1001)
+	assert.Equal(t, []int64{1001, 1002}, nb.popAll())
+	assert.Equal(t, int64(1), nb.getCoalesced())
+
+	// Once popped, the sequence is no longer buffered
+	nb.push(1001)
+	assert.Equal(t, []int64{1001}, nb.popAll())
+	assert.Empty(t, nb.popAll())
+}
+
+func TestNotificationBufferCoalesceWhenFull(t *testing.T) {
+	nb := newNotificationBuffer(2, notificationPolicyCoalesce)
+	nb.push(1001)
+	nb.push(1005)
+	nb.push(1003)
+	nb.push(1004)
+	assert.Equal(t, []int64{1001, 1003}, nb.popAll())
+	assert.Equal(t, int64(2), nb.getCoalesced())
+}
+
+func TestNotificationBufferDropOldestWhenFull(t *testing.T) {
+	nb := newNotificationBuffer(3, notificationPolicyDropOldest)
+	nb.push(1001)
+	nb.push(1002)
+	nb.push(1003)
+	nb.push(1004)
+	// The oldest is folded into the next, as it is the lower sequence
+	assert.Equal(t, []int64{1001, 1003, 1004}, nb.popAll())
+
+	nb.push(1003)
+	nb.push(1001)
+	nb.push(1002)
+	nb.push(1004)
+	assert.Equal(t, []int64{1001, 1002, 1004}, nb.popAll())
+	assert.Equal(t, int64(2), nb.getCoalesced())
+}
+
+func TestNotificationBufferMinimumSize(t *testing.T) {
+	nb := newNotificationBuffer(0, notificationPolicyDropOldest)
+	nb.push(1002)
+	nb.push(1001)
+	nb.push(1003)
+	assert.Equal(t, []int64{1001}, nb.popAll())
+	assert.Equal(t, int64(2), nb.getCoalesced())
+}
+
+func TestNotifyNewMessageNeverBlocks(t *testing.T) {
+	testConfigReset()
+	defer coreconfig.Reset()
+	config.Set(coreconfig.BatchManagerNotificationsBufferSize, 5)
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.readOffset = 2000
+
+	// With nothing consuming the notifications, we can notify far beyond the buffer size
+	for i := int64(0); i < 100; i++ {
+		bm.NotifyNewMessage(1100 - i)
+	}
+	assert.Equal(t, int64(95), bm.notifications.getCoalesced())
+
+	// Once consumed, the rewind is to the lowest sequence notified
+	go bm.newMessageNotifier()
+	<-bm.shoulderTap
+	for {
+		bm.rewindOffsetMux.Lock()
+		rewindOffset := bm.rewindOffset
+		bm.rewindOffsetMux.Unlock()
+		if rewindOffset == 1000 {
+			break
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"database/sql/driver"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+)
+
+// OffsetCommittedHandler is called after the offset of the batch manager advances in the DB
+type OffsetCommittedHandler func(previous, current int64)
+
+const (
+	msgBatchOffsetName = "ff_batch"
+
+	resumeFromLastBatch = "lastBatch"
+)
+
+// restoreOffset reads the persisted offset for this batch manager, creating it if it does not exist yet.
+// The retries are bounded by the startup timeout, if set, so a dead database fails start rather than blocking it.
+func (bm *batchManager) restoreOffset() error {
+	ctx := bm.ctx
+	if bm.offsetStartupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(bm.ctx, bm.offsetStartupTimeout)
+		defer cancel()
+	}
+	var lastErr error
+	err := bm.retry.Do(ctx, "restore offset", func(attempt int) (retry bool, err error) {
+		retry = bm.startupOffsetRetryAttempts == 0 || attempt <= bm.startupOffsetRetryAttempts
+		defer func() { lastErr = err }()
+		offset, err := bm.database.GetOffset(ctx, core.OffsetTypeBatch, bm.offsetName)
+		if err != nil {
+			return retry, err
+		}
+		if offset == nil {
+			offset = &core.Offset{
+				Type:    core.OffsetTypeBatch,
+				Name:    bm.offsetName,
+				Current: -1,
+			}
+			if err = bm.database.UpsertOffset(ctx, offset, false); err != nil {
+				return retry, err
+			}
+		}
+		bm.offsetRowID = offset.RowID
+		bm.readOffset = offset.Current
+		bm.committedOffset = offset.Current
+		bm.pendingOffset = offset.Current
+		bm.highestReadOffset = offset.Current
+		log.L(bm.ctx).Infof("Batch manager offset restored %d", offset.Current)
+		return false, nil
+	})
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return i18n.NewError(bm.ctx, coremsgs.MsgBatchOffsetRestoreTimeout, bm.offsetStartupTimeout, lastErr)
+	}
+	return err
+}
+
+// getLastDispatchedSequence returns the highest sequence of the messages in the most recent batch the local node
+// dispatched, or -1 if there is no such batch.
+func (bm *batchManager) getLastDispatchedSequence() (int64, error) {
+	node, err := bm.identity.GetLocalNode(bm.ctx)
+	if err != nil || node == nil {
+		return -1, err
+	}
+	bfb := database.BatchQueryFactory.NewFilterLimit(bm.ctx, 1)
+	batches, _, err := bm.database.GetBatches(bm.ctx, bm.namespace, bfb.And(
+		bfb.Eq("node", node.ID),
+	).Sort("-created"))
+	if err != nil || len(batches) == 0 {
+		return -1, err
+	}
+	mfb := database.MessageQueryFactory.NewFilterLimit(bm.ctx, 1)
+	ids, err := bm.database.GetMessageIDs(bm.ctx, bm.namespace, mfb.And(
+		mfb.Eq("batch", batches[0].ID),
+		mfb.In("state", []driver.Value{core.MessageStateSent, core.MessageStateConfirmed}),
+	).Sort("-sequence"))
+	if err != nil || len(ids) == 0 {
+		return -1, err
+	}
+	return ids[0].Sequence, nil
+}
+
+// resumeFromLastDispatchedBatch sets the read offset from the last batch dispatched by the local node, rather
+// than (or in addition to) the persisted offset - logging any discrepancy where both are available
+func (bm *batchManager) resumeFromLastDispatchedBatch() error {
+	return bm.retry.Do(bm.ctx, "resume from last batch", func(attempt int) (retry bool, err error) {
+		retry = bm.startupOffsetRetryAttempts == 0 || attempt <= bm.startupOffsetRetryAttempts
+		lastSequence, err := bm.getLastDispatchedSequence()
+		if err != nil {
+			return retry, err
+		}
+		if bm.offsetEnabled && lastSequence != bm.readOffset {
+			log.L(bm.ctx).Warnf("Batch manager offset %d does not match last dispatched batch sequence %d", bm.readOffset, lastSequence)
+		}
+		bm.readOffset = lastSequence
+		bm.inflightMux.Lock()
+		bm.highestReadOffset = lastSequence
+		bm.inflightMux.Unlock()
+		log.L(bm.ctx).Infof("Batch manager resuming from last dispatched batch sequence %d", lastSequence)
+		return false, nil
+	})
+}
+
+// markRead records the highest sequence that has been read, and handed to processors
+func (bm *batchManager) markRead(offset int64) {
+	bm.inflightMux.Lock()
+	if bm.rereadOffset >= 0 {
+		if offset >= bm.highestReadOffset {
+			bm.rereadOffset = -1
+		} else if offset > bm.rereadOffset {
+			bm.rereadOffset = offset
+		}
+	}
+	if offset > bm.highestReadOffset {
+		bm.highestReadOffset = offset
+	}
+	bm.inflightMux.Unlock()
+}
+
+// markReadComplete is called after a page that read every ready message after the read offset, so the sequencer has
+// read back up to where it was before any rewind
+func (bm *batchManager) markReadComplete() {
+	bm.inflightMux.Lock()
+	bm.rereadOffset = -1
+	bm.inflightMux.Unlock()
+}
+
+// calcCommittableOffset returns the highest sequence for which every message read at, or below, that
+// sequence has been flushed by its processor - and not deferred to be read again. Must be called holding the inflightMux.
+func (bm *batchManager) calcCommittableOffset() int64 {
+	flushed := make(map[int64]bool, len(bm.inflightFlushed))
+	for _, seq := range bm.inflightFlushed {
+		flushed[seq] = true
+	}
+	offset := bm.highestReadOffset
+	if bm.rereadOffset >= 0 && bm.rereadOffset < offset {
+		offset = bm.rereadOffset
+	}
+	for seq := range bm.inflightSequences {
+		if !flushed[seq] && seq <= offset {
+			offset = seq - 1
+		}
+	}
+	for seq := range bm.deferredSequences {
+		if seq <= offset {
+			offset = seq - 1
+		}
+	}
+	return offset
+}
+
+// checkpointOffset is called each time progress is made, to commit the offset if it has advanced.
+// In async mode this just queues the commit to the offset committer, so the caller is not blocked on the DB.
+func (bm *batchManager) checkpointOffset() {
+	if !bm.offsetEnabled {
+		return
+	}
+	bm.inflightMux.Lock()
+	offset := bm.calcCommittableOffset()
+	bm.inflightMux.Unlock()
+
+	if !bm.offsetCommitAsync {
+		_ = bm.commitOffset(bm.ctx, offset)
+		return
+	}
+
+	bm.offsetMux.Lock()
+	if offset > bm.pendingOffset {
+		bm.pendingOffset = offset
+	}
+	bm.offsetMux.Unlock()
+	select {
+	case bm.offsetCommits <- true:
+	default:
+	}
+}
+
+func (bm *batchManager) getPendingOffset() int64 {
+	bm.offsetMux.Lock()
+	defer bm.offsetMux.Unlock()
+	return bm.pendingOffset
+}
+
+// writeOffset performs a single attempt to update the offset in the DB. Writes are serialized, and
+// never move the offset backwards, so commits are always monotonic.
+func (bm *batchManager) writeOffset(ctx context.Context, offset int64) error {
+	bm.offsetCommitMux.Lock()
+	defer bm.offsetCommitMux.Unlock()
+	if offset <= bm.committedOffset {
+		return nil
+	}
+	u := database.OffsetQueryFactory.NewUpdate(ctx).Set("current", offset)
+	if err := bm.database.UpdateOffset(ctx, bm.offsetRowID, u); err != nil {
+		return err
+	}
+	previous := bm.committedOffset
+	bm.committedOffset = offset
+	log.L(ctx).Debugf("Batch manager offset committed %d", offset)
+	if handler := bm.getOffsetCommittedHandler(); handler != nil {
+		handler(previous, offset)
+	}
+	return nil
+}
+
+// OnOffsetCommitted registers a callback invoked each time the offset advances in the DB, with the previous and new
+// offset - so an external cursor can be kept in sync. It is only called for genuine advances, not for writes that are
+// skipped because the offset has not moved, nor when a rewind moves the offset backwards. The callback is called
+// synchronously as each commit completes, so it sees the advances strictly in order, and must return promptly.
+func (bm *batchManager) OnOffsetCommitted(handler OffsetCommittedHandler) {
+	bm.offsetCommittedMux.Lock()
+	defer bm.offsetCommittedMux.Unlock()
+	bm.offsetCommittedHandler = handler
+}
+
+func (bm *batchManager) getOffsetCommittedHandler() OffsetCommittedHandler {
+	bm.offsetCommittedMux.Lock()
+	defer bm.offsetCommittedMux.Unlock()
+	return bm.offsetCommittedHandler
+}
+
+func (bm *batchManager) commitOffset(ctx context.Context, offset int64) error {
+	return bm.retry.Do(ctx, "commit offset", func(attempt int) (retry bool, err error) {
+		err = bm.writeOffset(ctx, offset)
+		return bm.isRetryable(err), err
+	})
+}
+
+// offsetCommitDue returns whether the pending offset should be committed now, or held back to coalesce with
+// later progress - until the commit interval has passed, or the offset has advanced by the commit messages limit
+func (bm *batchManager) offsetCommitDue(lastCommit time.Time) bool {
+	if bm.offsetCommitInterval <= 0 && bm.offsetCommitMessages <= 0 {
+		return true
+	}
+	if bm.offsetCommitInterval > 0 && time.Since(lastCommit) >= bm.offsetCommitInterval {
+		return true
+	}
+	if bm.offsetCommitMessages > 0 {
+		bm.offsetCommitMux.Lock()
+		committed := bm.committedOffset
+		bm.offsetCommitMux.Unlock()
+		return bm.getPendingOffset()-committed >= bm.offsetCommitMessages
+	}
+	return false
+}
+
+// offsetCommitLoop is the dedicated goroutine for async offset commits. Notifications are coalesced,
+// so only the latest pending offset is written each time round the loop. When a commit is held back
+// for the commit interval, a timer ensures it is made once the interval has passed.
+func (bm *batchManager) offsetCommitLoop() {
+	defer close(bm.offsetCommitterDone)
+	var lastCommit time.Time
+	var deferred *time.Timer
+	var deferredC <-chan time.Time
+	for {
+		select {
+		case <-bm.offsetCommits:
+			if !bm.offsetCommitDue(lastCommit) {
+				if deferred == nil && bm.offsetCommitInterval > 0 {
+					deferred = time.NewTimer(bm.offsetCommitInterval - time.Since(lastCommit))
+					deferredC = deferred.C
+				}
+				continue
+			}
+		case <-deferredC:
+		case <-bm.ctx.Done():
+			if deferred != nil {
+				deferred.Stop()
+			}
+			log.L(bm.ctx).Debugf("Offset committer exiting due to cancelled context")
+			return
+		}
+		if deferred != nil {
+			deferred.Stop()
+			deferred, deferredC = nil, nil
+		}
+		_ = bm.commitOffset(bm.ctx, bm.getPendingOffset())
+		lastCommit = time.Now()
+	}
+}
+
+// compactOffset prunes any historical rows the database plugin has accumulated for the offset, retaining only the
+// current row. Failures are logged, and the compaction is simply attempted again on the next interval.
+func (bm *batchManager) compactOffset() {
+	if err := bm.database.DeleteOffsetHistory(bm.ctx, core.OffsetTypeBatch, bm.offsetName, bm.offsetRowID); err != nil {
+		log.L(bm.ctx).Warnf("Failed to compact batch manager offset history: %s", err)
+	}
+}
+
+func (bm *batchManager) offsetCompactionLoop() {
+	defer close(bm.offsetCompactorDone)
+	ticker := time.NewTicker(bm.offsetCompactionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			bm.compactOffset()
+		case <-bm.ctx.Done():
+			log.L(bm.ctx).Debugf("Offset compactor exiting due to cancelled context")
+			return
+		}
+	}
+}
+
+// flushOffset is called on shutdown, after all processors have stopped, to write the final pending offset.
+// The manager context is cancelled at this point, so we make a single attempt on a fresh context.
+func (bm *batchManager) flushOffset() {
+	ctx := log.WithLogField(context.Background(), "role", "batchmgr")
+	offset := bm.getPendingOffset()
+	if err := bm.writeOffset(ctx, offset); err != nil {
+		log.L(ctx).Errorf("Failed to flush batch manager offset %d on shutdown: %s", offset, err)
+	}
+}
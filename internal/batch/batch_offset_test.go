@@ -0,0 +1,625 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/internal/coreconfig"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/identitymanagermocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func mockOffsetUpdates(mdi *databasemocks.Plugin) func() []int64 {
+	var mux sync.Mutex
+	var committed []int64
+	mdi.On("UpdateOffset", mock.Anything, int64(12345), mock.MatchedBy(func(u database.Update) bool {
+		info, _ := u.Finalize()
+		v, _ := info.SetOperations[0].Value.Value()
+		mux.Lock()
+		committed = append(committed, v.(int64))
+		mux.Unlock()
+		return true
+	})).Return(nil)
+	return func() []int64 {
+		mux.Lock()
+		defer mux.Unlock()
+		return append([]int64{}, committed...)
+	}
+}
+
+func TestRestoreOffsetExisting(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetOffset", mock.Anything, core.OffsetTypeBatch, "ff_batch_ns1").Return(&core.Offset{
+		RowID:   12345,
+		Current: 10,
+	}, nil)
+
+	err := bm.restoreOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), bm.readOffset)
+	assert.Equal(t, int64(10), bm.committedOffset)
+	assert.Equal(t, int64(12345), bm.offsetRowID)
+}
+
+func TestRestoreOffsetCreate(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetOffset", mock.Anything, core.OffsetTypeBatch, "ff_batch_ns1").Return(nil, nil)
+	mdi.On("UpsertOffset", mock.Anything, mock.MatchedBy(func(o *core.Offset) bool {
+		o.RowID = 12345
+		return o.Current == -1 && o.Name == "ff_batch_ns1"
+	}), false).Return(nil)
+
+	err := bm.restoreOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-1), bm.readOffset)
+	assert.Equal(t, int64(12345), bm.offsetRowID)
+}
+
+func TestRestoreOffsetPerNamespace(t *testing.T) {
+	bm1, cancel1 := newTestBatchManager(t)
+	defer cancel1()
+	mdi := bm1.database.(*databasemocks.Plugin)
+	ns2, err := NewBatchManager(context.Background(), "ns2", "", mdi, bm1.data, bm1.identity, bm1.txHelper)
+	assert.NoError(t, err)
+	bm2 := ns2.(*batchManager)
+	defer bm2.cancelCtx()
+
+	// Each namespace has its own batch manager, restoring its own offset
+	mdi.On("GetOffset", mock.Anything, core.OffsetTypeBatch, "ff_batch_ns1").Return(&core.Offset{RowID: 1, Current: 10}, nil)
+	mdi.On("GetOffset", mock.Anything, core.OffsetTypeBatch, "ff_batch_ns2").Return(&core.Offset{RowID: 2, Current: 20}, nil)
+	assert.NoError(t, bm1.restoreOffset())
+	assert.NoError(t, bm2.restoreOffset())
+	assert.Equal(t, int64(10), bm1.readOffset)
+	assert.Equal(t, int64(20), bm2.readOffset)
+
+	// Progress in one namespace is committed to its offset alone
+	mdi.On("UpdateOffset", mock.Anything, int64(2), mock.Anything).Return(nil).Once()
+	assert.NoError(t, bm2.writeOffset(bm2.ctx, 25))
+	assert.Equal(t, int64(25), bm2.committedOffset)
+	assert.Equal(t, int64(10), bm1.committedOffset)
+	mdi.AssertExpectations(t)
+}
+
+func TestRestoreOffsetPerOffsetName(t *testing.T) {
+	bm1, cancel1 := newTestBatchManager(t)
+	defer cancel1()
+	mdi := bm1.database.(*databasemocks.Plugin)
+	workload, err := NewBatchManager(context.Background(), "ns1", "workload1", mdi, bm1.data, bm1.identity, bm1.txHelper)
+	assert.NoError(t, err)
+	bm2 := workload.(*batchManager)
+	defer bm2.cancelCtx()
+
+	// Managers in the same namespace with different offset names restore their own offsets. The default offset is
+	// suffixed with the namespace, and an explicit offset name is used exactly as given
+	mdi.On("GetOffset", mock.Anything, core.OffsetTypeBatch, "ff_batch_ns1").Return(&core.Offset{RowID: 1, Current: 10}, nil)
+	mdi.On("GetOffset", mock.Anything, core.OffsetTypeBatch, "workload1").Return(nil, nil)
+	mdi.On("UpsertOffset", mock.Anything, mock.MatchedBy(func(offset *core.Offset) bool {
+		return offset.Name == "workload1"
+	}), false).Return(nil)
+	assert.NoError(t, bm1.restoreOffset())
+	assert.NoError(t, bm2.restoreOffset())
+	assert.Equal(t, int64(10), bm1.readOffset)
+	assert.Equal(t, int64(-1), bm2.readOffset)
+	mdi.AssertExpectations(t)
+}
+
+func TestStartRestoreOffsetFail(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetEnabled = true
+	bm.startupOffsetRetryAttempts = 1
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetOffset", mock.Anything, core.OffsetTypeBatch, "ff_batch_ns1").Return(nil, fmt.Errorf("pop"))
+
+	err := bm.Start()
+	assert.Regexp(t, "pop", err)
+	bm.WaitStop()
+}
+
+func TestStartRestoreOffsetTimeout(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetEnabled = true
+	bm.startupOffsetRetryAttempts = 0
+	bm.offsetStartupTimeout = 50 * time.Millisecond
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetOffset", mock.Anything, core.OffsetTypeBatch, "ff_batch_ns1").Return(nil, fmt.Errorf("pop"))
+
+	err := bm.Start()
+	assert.Regexp(t, "FF10448.*50ms.*pop", err)
+	bm.WaitStop()
+}
+
+func TestStartRestoreOffsetFailAsync(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetEnabled = true
+	bm.offsetCommitAsync = true
+	bm.offsetCompactionInterval = time.Minute
+	bm.checkpointInterval = time.Minute
+	bm.startupOffsetRetryAttempts = 1
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetOffset", mock.Anything, core.OffsetTypeBatch, "ff_batch_ns1").Return(nil, fmt.Errorf("pop"))
+
+	err := bm.Start()
+	assert.Regexp(t, "pop", err)
+	assert.NoError(t, bm.WaitStopWithTimeout(5*time.Second))
+	mdi.AssertNotCalled(t, "UpdateOffset", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestStartRestoreOffsetTimeoutAsync(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetEnabled = true
+	bm.offsetCommitAsync = true
+	bm.offsetCompactionInterval = time.Minute
+	bm.startupOffsetRetryAttempts = 0
+	bm.offsetStartupTimeout = 50 * time.Millisecond
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetOffset", mock.Anything, core.OffsetTypeBatch, "ff_batch_ns1").Return(nil, fmt.Errorf("pop"))
+
+	err := bm.Start()
+	assert.Regexp(t, "FF10448", err)
+	assert.NoError(t, bm.WaitStopWithTimeout(5*time.Second))
+}
+
+func TestStartResumeFromLastBatchFailAsync(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetEnabled = true
+	bm.offsetCommitAsync = true
+	bm.resumeFromLastBatch = true
+	bm.startupOffsetRetryAttempts = 1
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetOffset", mock.Anything, core.OffsetTypeBatch, "ff_batch_ns1").Return(&core.Offset{RowID: 12345, Current: 1000}, nil)
+	bm.identity.(*identitymanagermocks.Manager).On("GetLocalNode", mock.Anything).Return(nil, fmt.Errorf("pop"))
+
+	err := bm.Start()
+	assert.Regexp(t, "pop", err)
+	assert.NoError(t, bm.WaitStopWithTimeout(5*time.Second))
+}
+
+func TestSyncOffsetCommit(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetEnabled = true
+	bm.offsetRowID = 12345
+	mdi := bm.database.(*databasemocks.Plugin)
+	committed := mockOffsetUpdates(mdi)
+
+	bm.inflightSequences[11] = nil
+	bm.inflightSequences[12] = nil
+	bm.markRead(12)
+
+	bm.notifyFlushed([]int64{12})
+	assert.Equal(t, []int64{10}, committed())
+
+	bm.notifyFlushed([]int64{11})
+	assert.Equal(t, []int64{10, 12}, committed())
+
+	// No-op if the offset has not advanced
+	bm.checkpointOffset()
+	assert.Equal(t, []int64{10, 12}, committed())
+}
+
+func TestAsyncOffsetCommitMonotonicAndFlushedOnClose(t *testing.T) {
+	bm, _ := newTestBatchManager(t)
+	bm.offsetEnabled = true
+	bm.offsetCommitAsync = true
+	bm.offsetRowID = 12345
+	bm.committedOffset = 10
+	bm.pendingOffset = 10
+	mdi := bm.database.(*databasemocks.Plugin)
+	committed := mockOffsetUpdates(mdi)
+	bm.started = true
+	go bm.offsetCommitLoop()
+
+	for seq := int64(11); seq <= 20; seq++ {
+		bm.inflightSequences[seq] = nil
+	}
+	bm.markRead(20)
+
+	// Flush out of order, so the committable offset is held back by the lowest unflushed sequence
+	for _, seq := range []int64{20, 12, 11, 15, 14, 13, 19, 17, 16, 18} {
+		bm.notifyFlushed([]int64{seq})
+	}
+
+	// Close, which must flush the final offset
+	close(bm.done)
+	bm.cancelCtx()
+	bm.WaitStop()
+
+	commits := committed()
+	assert.NotEmpty(t, commits)
+	for i := 1; i < len(commits); i++ {
+		assert.Greater(t, commits[i], commits[i-1])
+	}
+	assert.Equal(t, int64(20), commits[len(commits)-1])
+	assert.Equal(t, int64(20), bm.committedOffset)
+}
+
+func TestFlushOffsetFail(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetRowID = 12345
+	bm.pendingOffset = 20
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("UpdateOffset", mock.Anything, int64(12345), mock.Anything).Return(fmt.Errorf("pop"))
+
+	bm.flushOffset()
+	assert.Equal(t, int64(-1), bm.committedOffset)
+}
+
+func TestOffsetCompaction(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	bm.offsetEnabled = true
+	bm.offsetCompactionInterval = 1 * time.Millisecond
+	bm.offsetRowID = 12345
+	mdi := bm.database.(*databasemocks.Plugin)
+
+	// Simulate a plugin that has accumulated history rows for the offset
+	var mux sync.Mutex
+	rows := map[int64]*core.Offset{
+		12300: {RowID: 12300, Type: core.OffsetTypeBatch, Name: "ff_batch_ns1", Current: 5},
+		12301: {RowID: 12301, Type: core.OffsetTypeBatch, Name: "ff_batch_ns1", Current: 8},
+		12345: {RowID: 12345, Type: core.OffsetTypeBatch, Name: "ff_batch_ns1", Current: 10},
+	}
+	mdi.On("DeleteOffsetHistory", mock.Anything, core.OffsetTypeBatch, "ff_batch_ns1", int64(12345)).Return(fmt.Errorf("pop")).Once()
+	mdi.On("DeleteOffsetHistory", mock.Anything, core.OffsetTypeBatch, "ff_batch_ns1", int64(12345)).Return(nil).Run(func(args mock.Arguments) {
+		mux.Lock()
+		defer mux.Unlock()
+		for rowID := range rows {
+			if rowID != args[3].(int64) {
+				delete(rows, rowID)
+			}
+		}
+	})
+	bm.started = true
+	go bm.offsetCompactionLoop()
+
+	// The stale rows are pruned, after retrying the failure on the next interval
+	assert.Eventually(t, func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+		return len(rows) == 1
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, int64(10), rows[12345].Current)
+
+	close(bm.done)
+	cancel()
+	bm.WaitStop()
+}
+
+func TestResumeFromLastBatch(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.resumeFromLastBatch = true
+	mdi := bm.database.(*databasemocks.Plugin)
+	mim := bm.identity.(*identitymanagermocks.Manager)
+	nodeID := fftypes.NewUUID()
+	batchID := fftypes.NewUUID()
+	mim.On("GetLocalNode", mock.Anything).Return(&core.Identity{IdentityBase: core.IdentityBase{ID: nodeID}}, nil)
+	mdi.On("GetBatches", mock.Anything, "ns1", mock.MatchedBy(func(f database.Filter) bool {
+		fi, _ := f.Finalize()
+		return fi.String() == fmt.Sprintf("( node == '%s' ) sort=-created limit=1", nodeID)
+	})).Return([]*core.BatchPersisted{{BatchHeader: core.BatchHeader{ID: batchID}}}, nil, nil)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.MatchedBy(func(f database.Filter) bool {
+		fi, _ := f.Finalize()
+		return fi.String() == fmt.Sprintf("( batch == '%s' ) && ( state IN ['sent','confirmed'] ) sort=-sequence limit=1", batchID)
+	})).Return([]*core.IDAndSequence{{ID: *fftypes.NewUUID(), Sequence: 25}}, nil).Once()
+
+	readFrom := make(chan string, 1)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.MatchedBy(func(f database.Filter) bool {
+		fi, _ := f.Finalize()
+		select {
+		case readFrom <- fi.String():
+		default:
+		}
+		return true
+	})).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "( sequence >> 25 ) && ( state IN ['ready'] ) sort=sequence limit=100", <-readFrom)
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestResumeFromLastBatchLogsOffsetDiscrepancy(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetEnabled = true
+	bm.readOffset = 30
+	bm.highestReadOffset = 30
+	mdi := bm.database.(*databasemocks.Plugin)
+	mim := bm.identity.(*identitymanagermocks.Manager)
+	mim.On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
+	mdi.On("GetBatches", mock.Anything, "ns1", mock.Anything).Return([]*core.BatchPersisted{{}}, nil, nil)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{{Sequence: 25}}, nil)
+
+	err := bm.resumeFromLastDispatchedBatch()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(25), bm.readOffset)
+	assert.Equal(t, int64(25), bm.highestReadOffset)
+}
+
+func TestResumeFromLastBatchNoBatches(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mim := bm.identity.(*identitymanagermocks.Manager)
+	mim.On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
+	mdi.On("GetBatches", mock.Anything, "ns1", mock.Anything).Return([]*core.BatchPersisted{}, nil, nil)
+
+	err := bm.resumeFromLastDispatchedBatch()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-1), bm.readOffset)
+}
+
+func TestResumeFromLastBatchNoDispatchedMessages(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mim := bm.identity.(*identitymanagermocks.Manager)
+	mim.On("GetLocalNode", mock.Anything).Return(nil, nil)
+
+	seq, err := bm.getLastDispatchedSequence()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-1), seq)
+
+	mim.ExpectedCalls = nil
+	mim.On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
+	mdi.On("GetBatches", mock.Anything, "ns1", mock.Anything).Return([]*core.BatchPersisted{{}}, nil, nil)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	seq, err = bm.getLastDispatchedSequence()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-1), seq)
+}
+
+func TestStartResumeFromLastBatchFail(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.resumeFromLastBatch = true
+	bm.startupOffsetRetryAttempts = 1
+	mim := bm.identity.(*identitymanagermocks.Manager)
+	mim.On("GetLocalNode", mock.Anything).Return(nil, fmt.Errorf("pop"))
+
+	err := bm.Start()
+	assert.Regexp(t, "pop", err)
+	bm.WaitStop()
+}
+
+func TestOffsetCommitsCoalesced(t *testing.T) {
+	bm, _ := newTestBatchManager(t)
+	bm.offsetEnabled = true
+	bm.offsetCommitAsync = true
+	bm.offsetCommitInterval = 200 * time.Millisecond
+	bm.offsetCommitMessages = 5
+	bm.offsetRowID = 12345
+	bm.committedOffset = 10
+	bm.pendingOffset = 10
+	mdi := bm.database.(*databasemocks.Plugin)
+	committed := mockOffsetUpdates(mdi)
+	bm.started = true
+	go bm.offsetCommitLoop()
+
+	for seq := int64(11); seq <= 30; seq++ {
+		bm.inflightSequences[seq] = nil
+	}
+	bm.markRead(30)
+	flush := func(from, to int64) {
+		for seq := from; seq <= to; seq++ {
+			bm.notifyFlushed([]int64{seq})
+		}
+	}
+
+	// The first progress is committed immediately
+	flush(11, 11)
+	assert.Eventually(t, func() bool { return len(committed()) == 1 }, 5*time.Second, time.Millisecond)
+
+	// Progress within the interval is held back until the offset advances by the message limit
+	flush(12, 14)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, []int64{11}, committed())
+	flush(15, 16)
+	assert.Eventually(t, func() bool { return len(committed()) == 2 }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, int64(16), committed()[1])
+
+	// Or until the interval has passed
+	flush(17, 18)
+	assert.Eventually(t, func() bool { return len(committed()) == 3 }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, int64(18), committed()[2])
+
+	// Shutdown forces a final commit of anything held back
+	flush(19, 20)
+	close(bm.done)
+	bm.cancelCtx()
+	bm.WaitStop()
+	commits := committed()
+	assert.Equal(t, int64(20), commits[len(commits)-1])
+}
+
+func TestOffsetCommitCoalescingImpliesAsync(t *testing.T) {
+	coreconfig.Reset()
+	defer coreconfig.Reset()
+	config.Set(coreconfig.BatchManagerOffsetCommitMessages, 100)
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	assert.True(t, bm.offsetCommitAsync)
+}
+
+func TestOffsetCommittedHandler(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetEnabled = true
+	bm.offsetRowID = 12345
+	mdi := bm.database.(*databasemocks.Plugin)
+	mockOffsetUpdates(mdi)
+	var advances [][2]int64
+	bm.OnOffsetCommitted(func(previous, current int64) {
+		advances = append(advances, [2]int64{previous, current})
+	})
+
+	bm.committedOffset = 10
+	assert.NoError(t, bm.commitOffset(bm.ctx, 12))
+	assert.NoError(t, bm.commitOffset(bm.ctx, 15))
+	assert.Equal(t, [][2]int64{{10, 12}, {12, 15}}, advances)
+
+	// Writes that do not advance the offset are not reported
+	assert.NoError(t, bm.commitOffset(bm.ctx, 15))
+	assert.NoError(t, bm.commitOffset(bm.ctx, 14))
+	assert.Len(t, advances, 2)
+
+	// Nor is a rewind, but the next advance is reported from the rewound offset
+	assert.NoError(t, bm.resetOffset(5))
+	assert.Len(t, advances, 2)
+	assert.NoError(t, bm.commitOffset(bm.ctx, 8))
+	assert.Equal(t, [][2]int64{{10, 12}, {12, 15}, {5, 8}}, advances)
+}
+
+func TestOffsetCommittedHandlerNotCalledOnFailure(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetRowID = 12345
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("UpdateOffset", mock.Anything, int64(12345), mock.Anything).Return(fmt.Errorf("pop"))
+	bm.OnOffsetCommitted(func(previous, current int64) {
+		assert.Fail(t, "reported a failed commit")
+	})
+
+	err := bm.writeOffset(bm.ctx, 12)
+	assert.Regexp(t, "pop", err)
+	assert.Equal(t, int64(-1), bm.committedOffset)
+}
+
+// runOffsetRestart starts a batch manager from the committed offset, with the given messages ready for dispatch - and
+// returns the messages it dispatched, and the offset it committed, once it has settled
+func runOffsetRestart(t *testing.T, committedOffset int64, ready []*core.Message, missingData *core.Message) ([]*fftypes.UUID, int64) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.offsetEnabled = true
+	bm.offsetAheadCheck = offsetAheadCheckOff
+	bm.messagePollTimeout = 10 * time.Millisecond
+	mdi.On("GetOffset", mock.Anything, core.OffsetTypeBatch, "ff_batch_ns1").Return(&core.Offset{
+		RowID:   12345,
+		Current: committedOffset,
+	}, nil)
+	committed := mockOffsetUpdates(mdi)
+
+	var mux sync.Mutex
+	dispatched := []*fftypes.UUID{}
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			mux.Lock()
+			defer mux.Unlock()
+			for _, msg := range state.Messages {
+				dispatched = append(dispatched, msg.Header.ID)
+			}
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+
+	// Each message is ready until it is dispatched
+	var missingReads int32
+	for _, msg := range ready {
+		if msg == missingData {
+			mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, false, nil).
+				Run(func(args mock.Arguments) { atomic.AddInt32(&missingReads, 1) })
+		} else {
+			mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil)
+		}
+	}
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(func(ctx context.Context, ns string, filter database.Filter) []*core.IDAndSequence {
+		fi, _ := filter.Finalize()
+		var after int64
+		fmt.Sscanf(fi.String(), "( sequence >> %d )", &after)
+		mux.Lock()
+		defer mux.Unlock()
+		ids := []*core.IDAndSequence{}
+		for _, msg := range ready {
+			isDispatched := false
+			for _, id := range dispatched {
+				isDispatched = isDispatched || id.Equals(msg.Header.ID)
+			}
+			if msg.Sequence > after && !isDispatched {
+				ids = append(ids, &core.IDAndSequence{ID: *msg.Header.ID, Sequence: msg.Sequence})
+			}
+		}
+		return ids
+	}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	// Wait until every message with its data is dispatched, and the message missing its data has been read again
+	assert.Eventually(t, func() bool {
+		mux.Lock()
+		dispatchedCount := len(dispatched)
+		mux.Unlock()
+		bm.inflightMux.Lock()
+		defer bm.inflightMux.Unlock()
+		settled := len(bm.inflightSequences) == 0 && len(bm.inflightFlushed) == 0
+		if missingData == nil {
+			return settled && dispatchedCount == len(ready)
+		}
+		return settled && dispatchedCount == len(ready)-1 && atomic.LoadInt32(&missingReads) > 1
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	bm.WaitStop()
+
+	offset := committedOffset
+	if commits := committed(); len(commits) > 0 {
+		offset = commits[len(commits)-1]
+	}
+	return dispatched, offset
+}
+
+func TestOffsetHeldAtUnassembledMessageAcrossRestart(t *testing.T) {
+	msg1 := newTestBroadcastMessage(1001)
+	msg2 := newTestBroadcastMessage(1002)
+
+	// The data of the first message has not arrived, so the offset is not committed past it
+	dispatched, offset := runOffsetRestart(t, 1000, []*core.Message{msg1, msg2}, msg1)
+	assert.Equal(t, []*fftypes.UUID{msg2.Header.ID}, dispatched)
+	assert.Equal(t, int64(1000), offset)
+
+	// After a restart it is read again, and dispatched now its data has arrived
+	dispatched, offset = runOffsetRestart(t, offset, []*core.Message{msg1}, nil)
+	assert.Equal(t, []*fftypes.UUID{msg1.Header.ID}, dispatched)
+	assert.Equal(t, int64(1001), offset)
+}
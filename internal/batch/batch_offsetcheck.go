@@ -0,0 +1,71 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/database"
+)
+
+const (
+	offsetAheadCheckOff   = "off"
+	offsetAheadCheckClamp = "clamp"
+)
+
+// getMaxMessageSequence returns the highest sequence of any message in the namespace, or -1 if there are none
+func (bm *batchManager) getMaxMessageSequence() (int64, error) {
+	fb := database.MessageQueryFactory.NewFilterLimit(bm.ctx, 1)
+	ids, err := bm.database.GetMessageIDs(bm.ctx, bm.namespace, fb.And().Sort("-sequence"))
+	if err != nil || len(ids) == 0 {
+		return -1, err
+	}
+	return ids[0].Sequence, nil
+}
+
+// checkOffsetAhead is a self-check on start, for an offset that is ahead of the highest message sequence - such as
+// after a partial restore from backup. The sequencer would otherwise wait silently until new messages pass the offset,
+// skipping all of those before it. The offset is clamped back to the highest sequence if configured, or a warning logged.
+func (bm *batchManager) checkOffsetAhead() error {
+	if bm.offsetAheadCheck == offsetAheadCheckOff || bm.readOffset < 0 {
+		return nil
+	}
+	var maxSequence int64
+	err := bm.retry.Do(bm.ctx, "check offset", func(attempt int) (retry bool, err error) {
+		retry = bm.startupOffsetRetryAttempts == 0 || attempt <= bm.startupOffsetRetryAttempts
+		maxSequence, err = bm.getMaxMessageSequence()
+		if err != nil {
+			return retry, err
+		}
+		return false, nil
+	})
+	if err != nil || bm.readOffset <= maxSequence {
+		return err
+	}
+	if bm.offsetAheadCheck != offsetAheadCheckClamp {
+		log.L(bm.ctx).Warnf("Batch manager offset %d is ahead of the highest message sequence %d - messages at or below the offset will not be dispatched", bm.readOffset, maxSequence)
+		return nil
+	}
+	log.L(bm.ctx).Warnf("Batch manager offset %d is ahead of the highest message sequence %d - clamping the offset", bm.readOffset, maxSequence)
+	if err := bm.resetOffset(maxSequence); err != nil {
+		return err
+	}
+	bm.readOffset = maxSequence
+	bm.inflightMux.Lock()
+	bm.highestReadOffset = maxSequence
+	bm.inflightMux.Unlock()
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// mockOffsetAheadCheck sets up the read of the highest message sequence, for the offset self-check on start
+func mockOffsetAheadCheck(mdi *databasemocks.Plugin, maxSequence int64) {
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{{Sequence: maxSequence}}, nil).Once()
+}
+
+func TestCheckOffsetAheadWarns(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+	mockOffsetAheadCheck(bm.database.(*databasemocks.Plugin), 500)
+
+	err := bm.checkOffsetAhead()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), bm.readOffset)
+}
+
+func TestCheckOffsetAheadClamps(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetAheadCheck = offsetAheadCheckClamp
+	bm.offsetRowID = 12345
+	bm.readOffset = 1000
+	bm.highestReadOffset = 1000
+	bm.committedOffset = 1000
+	mdi := bm.database.(*databasemocks.Plugin)
+	mockOffsetAheadCheck(mdi, 500)
+	mdi.On("UpdateOffset", mock.Anything, int64(12345), mock.Anything).Return(nil)
+
+	err := bm.checkOffsetAhead()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500), bm.readOffset)
+	assert.Equal(t, int64(500), bm.highestReadOffset)
+	assert.Equal(t, int64(500), bm.committedOffset)
+	assert.Equal(t, int64(500), bm.getPendingOffset())
+}
+
+func TestCheckOffsetAheadClampsNoMessages(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetAheadCheck = offsetAheadCheckClamp
+	bm.readOffset = 1000
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+	mdi.On("UpdateOffset", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	err := bm.checkOffsetAhead()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-1), bm.readOffset)
+}
+
+func TestCheckOffsetAheadClampFail(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetAheadCheck = offsetAheadCheckClamp
+	bm.readOffset = 1000
+	mdi := bm.database.(*databasemocks.Plugin)
+	mockOffsetAheadCheck(mdi, 500)
+	mdi.On("UpdateOffset", mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("pop"))
+	bm.retry.MaximumDelay = 0
+	cancel()
+
+	err := bm.checkOffsetAhead()
+	assert.Error(t, err)
+	assert.Equal(t, int64(1000), bm.readOffset)
+}
+
+func TestCheckOffsetNotAhead(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetAheadCheck = offsetAheadCheckClamp
+	bm.readOffset = 500
+	mockOffsetAheadCheck(bm.database.(*databasemocks.Plugin), 500)
+
+	err := bm.checkOffsetAhead()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500), bm.readOffset)
+}
+
+func TestCheckOffsetAheadSkipped(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.readOffset = -1
+	assert.NoError(t, bm.checkOffsetAhead())
+
+	bm.readOffset = 1000
+	bm.offsetAheadCheck = offsetAheadCheckOff
+	assert.NoError(t, bm.checkOffsetAhead())
+}
+
+func TestCheckOffsetAheadQueryFail(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+	bm.startupOffsetRetryAttempts = 1
+	bm.database.(*databasemocks.Plugin).On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(nil, fmt.Errorf("pop"))
+
+	err := bm.checkOffsetAhead()
+	assert.Regexp(t, "pop", err)
+}
+
+func TestStartOffsetAheadCheckFail(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetEnabled = true
+	bm.startupOffsetRetryAttempts = 1
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetOffset", mock.Anything, core.OffsetTypeBatch, "ff_batch_ns1").Return(&core.Offset{RowID: 12345, Current: 1000}, nil)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(nil, fmt.Errorf("pop"))
+
+	err := bm.Start()
+	assert.Regexp(t, "pop", err)
+}
@@ -0,0 +1,125 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+)
+
+// dispatchOrder tracks the messages of an OrderedDispatch dispatcher that have been handed to a processor, but whose
+// batch has not yet been dispatched - so that each batch can wait for every batch holding earlier messages.
+type dispatchOrder struct {
+	mux           sync.Mutex
+	pending       map[int64]bool
+	lastProcessor *batchProcessor
+	released      chan struct{}
+}
+
+func newDispatchOrder() *dispatchOrder {
+	return &dispatchOrder{
+		pending:  make(map[int64]bool),
+		released: make(chan struct{}),
+	}
+}
+
+// assign records the message as pending. If the previous message of the dispatcher was assigned to a different
+// processor, that processor is returned, as its open batch must be sealed before this message is batched.
+func (o *dispatchOrder) assign(seq int64, processor *batchProcessor) (previous *batchProcessor) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	o.pending[seq] = true
+	if o.lastProcessor != processor {
+		previous = o.lastProcessor
+		o.lastProcessor = processor
+	}
+	return previous
+}
+
+// release removes the messages of a batch once it has been dispatched, waking any batches waiting on them
+func (o *dispatchOrder) release(flushWork []*batchWork) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	for _, w := range flushWork {
+		delete(o.pending, w.msg.Sequence)
+	}
+	close(o.released)
+	o.released = make(chan struct{})
+}
+
+// waitForEarlier blocks until no message before the given sequence is pending dispatch in another batch
+func (o *dispatchOrder) waitForEarlier(ctx context.Context, seq int64) error {
+	for {
+		o.mux.Lock()
+		earlier := false
+		for pendingSeq := range o.pending {
+			if pendingSeq < seq {
+				earlier = true
+				break
+			}
+		}
+		released := o.released
+		o.mux.Unlock()
+		if !earlier {
+			return nil
+		}
+		log.L(ctx).Debugf("Waiting for batches with messages before sequence %d to be dispatched", seq)
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return i18n.NewError(ctx, coremsgs.MsgContextCanceled)
+		}
+	}
+}
+
+// waitForEarlierBatches holds the dispatch of an OrderedDispatch batch, until every batch holding an earlier
+// message of the dispatcher has been dispatched
+func (bp *batchProcessor) waitForEarlierBatches(flushWork []*batchWork) error {
+	if bp.conf.order == nil {
+		return nil
+	}
+	first := flushWork[0].msg.Sequence
+	for _, w := range flushWork {
+		if w.msg.Sequence < first {
+			first = w.msg.Sequence
+		}
+	}
+	return bp.conf.order.waitForEarlier(bp.ctx, first)
+}
+
+// sealForOrdering asks the processor that the previous message of an OrderedDispatch dispatcher was assigned to,
+// to seal its open batch - so the runs of messages in the batches of different processors never interleave.
+// This is called on the sequencer goroutine, which is also the one that reaps processors, so a processor that is
+// still registered cannot have had its work channel closed.
+func (bm *batchManager) sealForOrdering(processor *batchProcessor) {
+	bm.dispatcherMux.Lock()
+	registered := false
+	for _, d := range bm.allDispatchers {
+		if d.processors[processor.conf.name] == processor {
+			registered = true
+			break
+		}
+	}
+	bm.dispatcherMux.Unlock()
+	if registered {
+		processor.newWork <- &batchWork{seal: true}
+	}
+}
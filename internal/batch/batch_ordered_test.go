@@ -0,0 +1,178 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// runOrderingTest dispatches three pages of messages from the given authors, with a handler that is slower for the
+// second author, and returns the sequences in the order they were dispatched
+func runOrderingTest(t *testing.T, authors []string, options DispatcherOptions) []int64 {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readPageSize = uint64(len(authors) / 3)
+
+	msgs := make([]*core.Message, len(authors))
+	sequences := make(map[fftypes.UUID]int64)
+	for i, author := range authors {
+		msgs[i] = newTestBroadcastMessage(int64(1001 + i))
+		msgs[i].Header.Author = author
+		sequences[*msgs[i].Header.ID] = msgs[i].Sequence
+	}
+
+	var mux sync.Mutex
+	var dispatched []int64
+	done := make(chan struct{})
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			if state.Messages[0].Header.Author == "did:firefly:org/slow" {
+				time.Sleep(20 * time.Millisecond)
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			for _, msg := range state.Messages {
+				dispatched = append(dispatched, sequences[*msg.Header.ID])
+			}
+			if len(dispatched) == len(authors) {
+				close(done)
+			}
+			return nil
+		},
+		options,
+	)
+
+	for i := 0; i < len(msgs); i += int(bm.readPageSize) {
+		mockMessagePage(mdi, mdm, msgs[i:i+int(bm.readPageSize)]...)
+	}
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+	<-done
+
+	cancel()
+	bm.WaitStop()
+	return dispatched
+}
+
+func TestDispatchOrderWithinProcessor(t *testing.T) {
+	// Without OrderedDispatch, the batches of a single processor are dispatched in sequence order - across pages,
+	// and batches flushed by both size and timeout
+	authors := make([]string, 12)
+	for i := range authors {
+		authors[i] = "did:firefly:org/slow"
+	}
+	dispatched := runOrderingTest(t, authors, DispatcherOptions{
+		BatchMaxSize:   3,
+		BatchMaxBytes:  1024 * 1024,
+		BatchTimeout:   5 * time.Millisecond,
+		DisposeTimeout: 120 * time.Second,
+	})
+	for i, seq := range dispatched {
+		assert.Equal(t, int64(1001+i), seq)
+	}
+}
+
+func TestOrderedDispatchAcrossProcessors(t *testing.T) {
+	// The runs of messages of each author interleave, and the slow author would fall behind without ordering
+	authors := []string{
+		"did:firefly:org/slow", "did:firefly:org/slow", "did:firefly:org/fast", "did:firefly:org/slow",
+		"did:firefly:org/fast", "did:firefly:org/fast", "did:firefly:org/fast", "did:firefly:org/slow",
+		"did:firefly:org/slow", "did:firefly:org/fast", "did:firefly:org/slow", "did:firefly:org/fast",
+	}
+	dispatched := runOrderingTest(t, authors, DispatcherOptions{
+		BatchMaxSize:        5,
+		BatchMaxBytes:       1024 * 1024,
+		BatchTimeout:        5 * time.Millisecond,
+		DisposeTimeout:      120 * time.Second,
+		DispatchConcurrency: 2,
+		OrderedDispatch:     true,
+	})
+	for i, seq := range dispatched {
+		assert.Equal(t, int64(1001+i), seq)
+	}
+}
+
+func TestOrderedDispatchIgnoresPriority(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{OrderedDispatch: true, DispatchConcurrency: 5, BatchMaxSize: 10, DisposeTimeout: 120 * time.Second},
+	)
+	msg := newTestBroadcastMessage(1001)
+	normal, err := bm.getProcessor(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, nil, &msg.Header.SignerRef, 0, "")
+	assert.NoError(t, err)
+	priority, err := bm.getProcessor(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, nil, &msg.Header.SignerRef, 0, core.MessagePriorityHigh)
+	assert.NoError(t, err)
+	assert.Same(t, normal, priority)
+	assert.Nil(t, normal.dispatchSlots)
+	assert.NotNil(t, normal.conf.order)
+}
+
+func TestDispatchOrderWaitForEarlier(t *testing.T) {
+	o := newDispatchOrder()
+	p1, p2 := &batchProcessor{}, &batchProcessor{}
+	assert.Nil(t, o.assign(1001, p1))
+	assert.Nil(t, o.assign(1002, p1))
+	assert.Same(t, p1, o.assign(1003, p2))
+	assert.Nil(t, o.assign(1004, p2))
+
+	// Nothing is earlier than the first batch
+	err := o.waitForEarlier(context.Background(), 1001)
+	assert.NoError(t, err)
+
+	waited := make(chan error)
+	go func() {
+		waited <- o.waitForEarlier(context.Background(), 1003)
+	}()
+	o.release([]*batchWork{{msg: newTestBroadcastMessage(1001)}})
+	select {
+	case <-waited:
+		assert.Fail(t, "Waited for only some of the earlier messages")
+	case <-time.After(10 * time.Millisecond):
+	}
+	o.release([]*batchWork{{msg: newTestBroadcastMessage(1002)}})
+	assert.NoError(t, <-waited)
+}
+
+func TestDispatchOrderWaitCancelled(t *testing.T) {
+	o := newDispatchOrder()
+	o.assign(1001, &batchProcessor{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := o.waitForEarlier(ctx, 1002)
+	assert.Regexp(t, "FF00154", err)
+}
+
+func TestSealForOrderingReaped(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	// A processor that is no longer registered is not sent the seal, as its work channel might be closed
+	bp := &batchProcessor{conf: &batchProcessorConf{name: "reaped"}}
+	bm.sealForOrdering(bp)
+}
@@ -0,0 +1,41 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// writeOutbox writes the sealed batch to the outbox. It is called within the same database transaction as the
+// message state update, so a relay sees the batch if, and only if, its messages have been marked dispatched.
+func (bp *batchProcessor) writeOutbox(ctx context.Context, state *DispatchState) error {
+	payload, err := json.Marshal(state.Persisted.GenInflight(state.Messages, state.Data))
+	if err != nil {
+		return err
+	}
+	return bp.database.InsertOutboxEntry(ctx, &core.OutboxEntry{
+		Namespace:  bp.bm.namespace,
+		Batch:      state.Persisted.ID,
+		Dispatcher: bp.conf.dispatcherName,
+		Payload:    fftypes.JSONAnyPtrBytes(payload),
+		Created:    fftypes.Now(),
+	})
+}
@@ -0,0 +1,114 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/mocks/identitymanagermocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type testTxKey struct{}
+
+func TestOutboxWrittenInMessageUpdateTransaction(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdm := bm.data.(*datamocks.Manager)
+	mim := bm.identity.(*identitymanagermocks.Manager)
+	mim.On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
+	mdm.On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("InsertTransaction", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("InsertEvent", mock.Anything, mock.Anything).Return(nil)
+
+	// Each group runs in its own numbered transaction
+	txCount := 0
+	rag := mdi.On("RunAsGroup", mock.Anything, mock.Anything)
+	rag.RunFn = func(a mock.Arguments) {
+		txCount++
+		fn := a[1].(func(context.Context) error)
+		rag.ReturnArguments = mock.Arguments{fn(context.WithValue(a[0].(context.Context), testTxKey{}, txCount))}
+	}
+	var updateTx, outboxTx interface{}
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil).Run(func(a mock.Arguments) {
+		updateTx = a[0].(context.Context).Value(testTxKey{})
+	})
+	outboxEntries := make(chan *core.OutboxEntry, 1)
+	mdi.On("InsertOutboxEntry", mock.Anything, mock.Anything).Return(nil).Run(func(a mock.Arguments) {
+		outboxTx = a[0].(context.Context).Value(testTxKey{})
+		outboxEntries <- a[1].(*core.OutboxEntry)
+	})
+
+	// No handler - the outbox is the hand-off
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeUnpinned, []core.MessageType{core.MessageTypeBroadcast}, nil,
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second, Outbox: true},
+	)
+
+	msg := newTestBroadcastMessage(1001)
+	msg.Header.TxType = core.TransactionTypeUnpinned
+	mockMessagePage(mdi, mdm, msg)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	entry := <-outboxEntries
+	assert.Equal(t, "ns1", entry.Namespace)
+	assert.Equal(t, "utdispatcher", entry.Dispatcher)
+	var batch core.Batch
+	err = json.Unmarshal(entry.Payload.Bytes(), &batch)
+	assert.NoError(t, err)
+	assert.Equal(t, entry.Batch, batch.ID)
+	assert.Equal(t, msg.Header.ID, batch.Payload.Messages[0].Header.ID)
+
+	cancel()
+	bm.WaitStop()
+
+	assert.NotNil(t, updateTx)
+	assert.Equal(t, updateTx, outboxTx)
+}
+
+func TestOutboxWriteFail(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	bp.conf.Outbox = true
+	mockRunAsGroupPassthrough(mdi)
+	bp.retry.MaximumDelay = 1 * time.Microsecond
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("InsertOutboxEntry", mock.Anything, mock.Anything).Return(fmt.Errorf("pop")).Once()
+	mdi.On("InsertOutboxEntry", mock.Anything, mock.Anything).Return(nil)
+
+	state := &DispatchState{
+		Persisted: core.BatchPersisted{BatchHeader: core.BatchHeader{ID: fftypes.NewUUID()}},
+		Messages:  []*core.Message{},
+	}
+	err := bp.markPayloadDispatched(state)
+	assert.NoError(t, err)
+	mdi.AssertNumberOfCalls(t, "InsertOutboxEntry", 2)
+}
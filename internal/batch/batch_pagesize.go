@@ -0,0 +1,87 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"sort"
+
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+type dispatcherPage struct {
+	txType   core.TransactionType
+	msgTypes []core.MessageType
+	pageSize uint64
+}
+
+// getDispatcherPages returns the page to read for each dispatcher, if any dispatcher overrides the read page size,
+// or nil if all dispatchers share a single page
+func (bm *batchManager) getDispatcherPages() []*dispatcherPage {
+	bm.dispatcherMux.Lock()
+	defer bm.dispatcherMux.Unlock()
+	override := false
+	pages := make([]*dispatcherPage, 0, len(bm.allDispatchers))
+	for _, d := range bm.allDispatchers {
+		pageSize := bm.readPageSize
+		if d.options.ReadPageSize > 0 {
+			pageSize = d.options.ReadPageSize
+			override = true
+		}
+		pages = append(pages, &dispatcherPage{txType: d.txType, msgTypes: d.msgTypes, pageSize: pageSize})
+	}
+	if !override {
+		return nil
+	}
+	return pages
+}
+
+// readDispatcherPages reads a page for each dispatcher, and merges them in sequence order. Messages after the end
+// of the shortest full page are dropped, to be read again with the next page - so the read offset never advances
+// past a message that has not been read.
+func (bm *batchManager) readDispatcherPages(pages []*dispatcherPage) (ids []*core.IDAndSequence, fullPage bool, err error) {
+	cutoff := int64(-1)
+	for _, p := range pages {
+		page, err := bm.messageSource.ReadPage(bm.ctx, &MessagePage{
+			AfterSequence: bm.readOffset,
+			Limit:         p.pageSize,
+			TxType:        p.txType,
+			MessageTypes:  p.msgTypes,
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		if len(page) > 0 && len(page) == int(p.pageSize) {
+			fullPage = true
+			if last := page[len(page)-1].Sequence; cutoff < 0 || last < cutoff {
+				cutoff = last
+			}
+		}
+		ids = append(ids, page...)
+	}
+
+	// Dispatchers registered for the same types read the same messages, so we de-duplicate as we merge
+	seen := make(map[int64]bool, len(ids))
+	merged := make([]*core.IDAndSequence, 0, len(ids))
+	for _, id := range ids {
+		if (cutoff < 0 || id.Sequence <= cutoff) && !seen[id.Sequence] {
+			seen[id.Sequence] = true
+			merged = append(merged, id)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Sequence < merged[j].Sequence })
+	return merged, fullPage, nil
+}
@@ -0,0 +1,134 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func registerPageSizeDispatchers(bm *batchManager, broadcastPageSize uint64) {
+	noop := func(c context.Context, state *DispatchState) error { return nil }
+	bm.RegisterDispatcher("broadcast", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast}, noop,
+		DispatcherOptions{BatchMaxSize: 1, ReadPageSize: broadcastPageSize},
+	)
+	bm.RegisterDispatcher("private", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypePrivate}, noop,
+		DispatcherOptions{BatchMaxSize: 1, SlowDownPageSize: 1},
+	)
+}
+
+func testIDs(seqs ...int64) []*core.IDAndSequence {
+	ids := make([]*core.IDAndSequence, len(seqs))
+	for i, seq := range seqs {
+		ids[i] = &core.IDAndSequence{ID: *fftypes.NewUUID(), Sequence: seq}
+	}
+	return ids
+}
+
+func TestReadPageSizePerDispatcher(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+	registerPageSizeDispatchers(bm, 2)
+
+	// Broadcasts fill their smaller page, while private messages have a page to spare
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(func(ctx context.Context, ns string, filter database.Filter) []*core.IDAndSequence {
+		fi, _ := filter.Finalize()
+		switch {
+		case strings.Contains(fi.String(), "( type IN ['broadcast'] ) sort=sequence limit=2"):
+			return testIDs(1001, 1003)
+		case strings.Contains(fi.String(), fmt.Sprintf("( type IN ['private'] ) sort=sequence limit=%d", bm.readPageSize)):
+			return testIDs(1002, 1004)
+		}
+		return nil
+	}, nil)
+
+	ids, fullPage, err := bm.readPage(bm.ctx, false)
+	assert.NoError(t, err)
+	assert.True(t, fullPage)
+
+	// The private message after the end of the broadcast page is left to be read with the next page
+	seqs := make([]int64, len(ids))
+	for i, id := range ids {
+		seqs[i] = id.Sequence
+	}
+	assert.Equal(t, []int64{1001, 1002, 1003}, seqs)
+}
+
+func TestReadPageSizeSharedDefault(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	registerPageSizeDispatchers(bm, 0)
+
+	assert.Nil(t, bm.getDispatcherPages())
+}
+
+func TestReadPageSizeUnaffectedBySlowDown(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	registerPageSizeDispatchers(bm, 500)
+
+	// Slow-down is applied to the assembly of the dispatcher's messages, rather than the pages read for all of them
+	bm.setSlowDown(bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypePrivate), true)
+	pages := bm.getDispatcherPages()
+	assert.Equal(t, uint64(500), pages[0].pageSize)
+	assert.Equal(t, bm.readPageSize, pages[1].pageSize)
+}
+
+func TestReadDispatcherPagesFail(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	registerPageSizeDispatchers(bm, 2)
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(nil, fmt.Errorf("pop"))
+
+	_, _, err := bm.readDispatcherPages(bm.getDispatcherPages())
+	assert.EqualError(t, err, "pop")
+}
+
+func TestReadDispatcherPagesDuplicateTypes(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	registerPageSizeDispatchers(bm, 2)
+	bm.RegisterDispatcher("broadcast2", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 1},
+	)
+	shared := testIDs(1001)
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(shared, nil)
+
+	ids, fullPage, err := bm.readDispatcherPages(bm.getDispatcherPages())
+	assert.NoError(t, err)
+	assert.False(t, fullPage)
+	assert.Equal(t, shared, ids)
+}
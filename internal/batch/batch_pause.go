@@ -0,0 +1,87 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"github.com/hyperledger/firefly-common/pkg/log"
+)
+
+// Pause returns the channel on which a shared pause signal is delivered to the manager. Sending true pauses
+// assembly and dispatch across all dispatchers - the sequencer stops reading messages, and processors hold
+// their open batches - and sending false resumes. Any dispatch already in progress completes, and no state is lost.
+func (bm *batchManager) Pause() chan<- bool {
+	return bm.pauseSignals
+}
+
+func (bm *batchManager) pauseWatcher() {
+	for {
+		select {
+		case paused := <-bm.pauseSignals:
+			bm.setPaused(paused)
+		case <-bm.ctx.Done():
+			log.L(bm.ctx).Debugf("Pause watcher exiting due to cancelled context")
+			return
+		}
+	}
+}
+
+func (bm *batchManager) setPaused(paused bool) {
+	bm.pauseMux.Lock()
+	defer bm.pauseMux.Unlock()
+	if paused == bm.paused {
+		return
+	}
+	bm.paused = paused
+	if paused {
+		bm.resumed = make(chan struct{})
+	} else {
+		close(bm.resumed)
+		// Processors holding batches re-check on their batch timeout, and the sequencer picks up where it left off
+		bm.recordProgress()
+	}
+	log.L(bm.ctx).Infof("Batch manager paused=%t", paused)
+}
+
+func (bm *batchManager) isPaused() bool {
+	bm.pauseMux.Lock()
+	defer bm.pauseMux.Unlock()
+	return bm.paused
+}
+
+// waitWhilePaused blocks until the manager is not paused, returning true if the context closes while waiting
+func (bm *batchManager) waitWhilePaused() (done bool) {
+	bm.pauseMux.Lock()
+	paused, resumed := bm.paused, bm.resumed
+	bm.pauseMux.Unlock()
+	if !paused {
+		return false
+	}
+
+	log.L(bm.ctx).Debugf("Waiting for batch manager to resume")
+	select {
+	case <-resumed:
+		return false
+	case req := <-bm.rewinds:
+		// The rewind is applied while paused, and reading resumes from it
+		bm.applyRewind(req)
+		return false
+	case <-bm.drain:
+		return false
+	case <-bm.ctx.Done():
+		return true
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPauseSignalHaltsAndResumesDispatch(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+
+	dispatched := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+
+	// The message only becomes available after we have paused
+	var available int32
+	msg := newTestBroadcastMessage(1001)
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(func(ctx context.Context, ns string, filter database.Filter) []*core.IDAndSequence {
+		fi, _ := filter.Finalize()
+		if atomic.LoadInt32(&available) == 1 && strings.HasPrefix(fi.String(), "( sequence >> 1000 )") {
+			return []*core.IDAndSequence{{ID: *msg.Header.ID, Sequence: msg.Sequence}}
+		}
+		return []*core.IDAndSequence{}
+	}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	bm.Pause() <- true
+	assert.Eventually(t, bm.isPaused, 5*time.Second, time.Millisecond)
+	atomic.StoreInt32(&available, 1)
+	bm.NewMessages() <- msg.Sequence
+
+	select {
+	case <-dispatched:
+		assert.Fail(t, "dispatched while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Clearing the signal resumes from where we left off
+	bm.Pause() <- false
+	state := <-dispatched
+	assert.Equal(t, msg.Header.ID, state.Messages[0].Header.ID)
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestPauseHoldsOpenBatch(t *testing.T) {
+	bm, _, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	dispatched := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 10, BatchTimeout: 5 * time.Millisecond, DisposeTimeout: 120 * time.Second},
+	)
+	bm.setPaused(true)
+	bm.setPaused(true) // no-op
+
+	msg := newTestBroadcastMessage(1001)
+	processor, err := bm.getProcessor(msg.Header.TxType, msg.Header.Type, msg.Header.Group, &msg.Header.SignerRef, 0, "")
+	assert.NoError(t, err)
+	bm.dispatchMessage(bm.ctx, &pendingDispatch{processor: processor, msg: msg})
+
+	select {
+	case <-dispatched:
+		assert.Fail(t, "dispatched while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	bm.setPaused(false)
+	state := <-dispatched
+	assert.Equal(t, msg.Header.ID, state.Messages[0].Header.ID)
+}
+
+func TestWaitWhilePausedContextClosed(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	bm.setPaused(true)
+	cancel()
+	assert.True(t, bm.waitWhilePaused())
+
+	go bm.pauseWatcher()
+	bm.setPaused(false)
+	assert.False(t, bm.waitWhilePaused())
+}
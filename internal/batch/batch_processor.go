@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hyperledger/firefly-common/pkg/fftypes"
@@ -37,18 +38,24 @@ import (
 )
 
 type batchWork struct {
-	msg  *core.Message
-	data core.DataArray
+	msg        *core.Message
+	data       core.DataArray
+	provenance *MessageProvenance
+	seal       bool // carries no message, but asks the processor to seal its open batch
 }
 
 type batchProcessorConf struct {
 	DispatcherOptions
 	name           string
 	dispatcherName string
+	dispatcherKey  string
 	txType         core.TransactionType
 	signer         core.SignerRef
 	group          *fftypes.Bytes32
 	dispatch       DispatchHandler
+	noOp           bool
+	order          *dispatchOrder
+	highPriority   bool
 }
 
 // FlushStatus is an object that can be returned on REST queries to understand the status
@@ -65,6 +72,8 @@ type FlushStatus struct {
 	AverageFlushTimeMS   int64           `ffstruct:"BatchFlushStatus" json:"averageFlushTimeMS"`
 	TotalBatches         int64           `ffstruct:"BatchFlushStatus" json:"totalBatches"`
 	TotalErrors          int64           `ffstruct:"BatchFlushStatus" json:"totalErrors"`
+	Stalled              bool            `ffstruct:"BatchFlushStatus" json:"stalled"`
+	TotalStalls          int64           `ffstruct:"BatchFlushStatus" json:"totalStalls"`
 
 	totalBytesFlushed    int64
 	totalMessagesFlushed int64
@@ -85,9 +94,17 @@ type batchProcessor struct {
 	assemblyID         *fftypes.UUID
 	assemblyQueue      []*batchWork
 	assemblyQueueBytes int64
+	assemblyStarted    time.Time
+	reducedMaxSize     uint
+	retuned            *DispatcherOptions
 	statusMux          sync.Mutex
 	flushStatus        FlushStatus
+	openBatch          *OpenBatchTimer
+	maxAge             batchMaxAgeTimer
 	retry              *retry.Retry
+	dispatchRetry      *retry.Retry
+	dispatchSlots      chan struct{}
+	dispatchWorkers    sync.WaitGroup
 	conf               *batchProcessorConf
 }
 
@@ -97,12 +114,21 @@ type nonceState struct {
 }
 
 type DispatchState struct {
-	Persisted      core.BatchPersisted
-	Messages       []*core.Message
-	Data           core.DataArray
-	Pins           []*fftypes.Bytes32
+	Persisted  core.BatchPersisted
+	Messages   []*core.Message
+	Data       core.DataArray
+	Pins       []*fftypes.Bytes32
+	Provenance map[fftypes.UUID]*MessageProvenance
+	// Redispatch is set on a batch built by RedispatchMessage, to repeat the dispatch of a single message. The batch is
+	// not persisted, and nothing is written back once it has been dispatched
+	Redispatch     bool
+	slowDown       int32
+	claimed        bool
+	queued         bool
+	latency        *latencyMarks
 	noncesAssigned map[fftypes.Bytes32]*nonceState
 	msgPins        map[fftypes.UUID]core.FFStringArray
+	lazyData       data.Manager
 }
 
 const batchSizeEstimateBase = int64(512)
@@ -130,14 +156,38 @@ func newBatchProcessor(bm *batchManager, conf *batchProcessorConf, baseRetryConf
 			LastFlushTime: fftypes.Now(),
 		},
 	}
+	bp.dispatchRetry = newDispatchRetry(bp.retry, &conf.DispatchRetry)
+	if conf.DispatchConcurrency > 1 {
+		bp.dispatchSlots = make(chan struct{}, conf.DispatchConcurrency)
+	}
 	// Capture flush errors for our status
 	bp.retry.ErrCallback = bp.captureFlushError
+	bp.dispatchRetry.ErrCallback = bp.captureFlushError
 	bp.newAssembly()
 	go bp.assemblyLoop()
 	log.L(pCtx).Infof("Batch processor created")
 	return bp
 }
 
+// newDispatchRetry applies any backoff configured for the dispatcher, over the base retry configuration
+func newDispatchRetry(base *retry.Retry, conf *DispatchRetryOptions) *retry.Retry {
+	r := &retry.Retry{
+		InitialDelay: base.InitialDelay,
+		MaximumDelay: base.MaximumDelay,
+		Factor:       base.Factor,
+	}
+	if conf.InitialDelay > 0 {
+		r.InitialDelay = conf.InitialDelay
+	}
+	if conf.MaximumDelay > 0 {
+		r.MaximumDelay = conf.MaximumDelay
+	}
+	if conf.Factor > 0 {
+		r.Factor = conf.Factor
+	}
+	return r
+}
+
 func (bw *batchWork) estimateSize() int64 {
 	sizeEstimate := bw.msg.EstimateSize(false /* we calculate data size separately, as we have the full data objects */)
 	for _, d := range bw.data {
@@ -160,6 +210,13 @@ func (bp *batchProcessor) newAssembly(initalWork ...*batchWork) {
 	bp.assemblyID = fftypes.NewUUID()
 	bp.assemblyQueue = append([]*batchWork{}, initalWork...)
 	bp.assemblyQueueBytes = batchSizeEstimateBase
+	bp.assemblyStarted = time.Time{}
+	if len(initalWork) > 0 {
+		bp.assemblyStarted = bp.bm.clock.Now()
+	}
+	for _, w := range initalWork {
+		bp.assemblyQueueBytes += w.estimateSize()
+	}
 }
 
 // addWork adds the work to the assemblyQueue, and calculates if we have overflowed with this work.
@@ -168,6 +225,12 @@ func (bp *batchProcessor) newAssembly(initalWork ...*batchWork) {
 // With a sufficient batch size and batch timeout, the batch will still dispatch the messages
 // in DB sequence order (although this is not guaranteed).
 func (bp *batchProcessor) addWork(newWork *batchWork) (full, overflow bool) {
+	if len(bp.assemblyQueue) == 0 {
+		bp.assemblyStarted = bp.bm.clock.Now()
+		bp.statusMux.Lock()
+		bp.applyRetune()
+		bp.statusMux.Unlock()
+	}
 	newQueue := make([]*batchWork, 0, len(bp.assemblyQueue)+1)
 	added := false
 	// Build the new sorted work list
@@ -182,9 +245,17 @@ func (bp *batchProcessor) addWork(newWork *batchWork) (full, overflow bool) {
 		newQueue = append(newQueue, newWork)
 	}
 	log.L(bp.ctx).Debugf("Added message %s sequence=%d to in-flight batch assembly %s", newWork.msg.Header.ID, newWork.msg.Sequence, bp.assemblyID)
-	bp.assemblyQueueBytes += newWork.estimateSize()
+	size := newWork.estimateSize()
+	if batchSizeEstimateBase+size > bp.conf.BatchMaxBytes {
+		// There is no batch the message can fit in, so it is dispatched in a batch on its own
+		log.L(bp.ctx).Warnf("Message %s estimated size %d exceeds the maximum batch size of %d bytes - dispatching in a batch of one", newWork.msg.Header.ID, size, bp.conf.BatchMaxBytes)
+	}
+	bp.assemblyQueueBytes += size
 	bp.assemblyQueue = newQueue
-	full = len(bp.assemblyQueue) >= int(bp.conf.BatchMaxSize) || (bp.assemblyQueueBytes >= bp.conf.BatchMaxBytes)
+	bp.statusMux.Lock()
+	bp.updateOpenBatchTimer()
+	bp.statusMux.Unlock()
+	full = len(bp.assemblyQueue) >= int(bp.maxBatchSize()) || (bp.assemblyQueueBytes >= bp.conf.BatchMaxBytes)
 	overflow = len(bp.assemblyQueue) > 1 && (bp.assemblyQueueBytes > bp.conf.BatchMaxBytes)
 	return full, overflow
 }
@@ -201,6 +272,7 @@ func (bp *batchProcessor) startFlush(overflow bool) (id *fftypes.UUID, flushAsse
 		lastElem := len(bp.assemblyQueue) - 1
 		flushAssembly = append(flushAssembly, bp.assemblyQueue[:lastElem]...)
 		overflowWork = append(overflowWork, bp.assemblyQueue[lastElem])
+		bp.assemblyQueueBytes -= bp.assemblyQueue[lastElem].estimateSize()
 	} else {
 		flushAssembly = bp.assemblyQueue
 	}
@@ -208,7 +280,9 @@ func (bp *batchProcessor) startFlush(overflow bool) (id *fftypes.UUID, flushAsse
 	id = bp.assemblyID
 	byteSize = bp.assemblyQueueBytes
 	bp.flushStatus.Flushing = id
+	bp.applyRetune()
 	bp.newAssembly(overflowWork...)
+	bp.updateOpenBatchTimer()
 	return id, flushAssembly, byteSize
 }
 
@@ -269,21 +343,29 @@ func (bp *batchProcessor) startQuiesce() {
 // flushing the batch. The newWork channel has up to one batch of slots queue length,
 // so that we can have one batch of work queuing for assembly, while we have one batch flushing.
 func (bp *batchProcessor) assemblyLoop() {
-	defer close(bp.done)
+	defer func() {
+		bp.maxAge.stop()
+		// Batches still in dispatch must complete before the processor is done
+		bp.dispatchWorkers.Wait()
+		close(bp.done)
+	}()
 	l := log.L(bp.ctx)
 
-	var batchTimeout = time.NewTimer(bp.conf.DisposeTimeout)
+	var batchTimeout = bp.bm.clock.NewTimer(bp.conf.DisposeTimeout)
 	idle := true
 	quescing := false
 	for !quescing {
 
-		var timedout, full, overflow bool
+		var timedout, agedOut, full, overflow, sealed, minReached bool
 		select {
 		case <-bp.ctx.Done():
 			l.Tracef("Batch processor shutting down")
 			_ = batchTimeout.Stop()
 			return
-		case <-batchTimeout.C:
+		case <-bp.maxAgeExpiry():
+			l.Debugf("Batch maximum age reached")
+			agedOut = len(bp.assemblyQueue) > 0
+		case <-batchTimeout.C():
 			l.Debugf("Batch timer popped")
 			if len(bp.assemblyQueue) == 0 {
 				bp.startQuiesce()
@@ -294,27 +376,59 @@ func (bp *batchProcessor) assemblyLoop() {
 		case work, ok := <-bp.newWork:
 			if !ok {
 				quescing = true
+			} else if work.seal {
+				sealed = len(bp.assemblyQueue) > 0
 			} else {
 				full, overflow = bp.addWork(work)
+				minReached = !full && bp.minSizeReached()
 				if idle {
 					// We've hit a message while we were idle - we now need to wait for the batch to time out.
 					_ = batchTimeout.Stop()
-					batchTimeout = time.NewTimer(bp.conf.BatchTimeout)
+					batchTimeout = bp.bm.clock.NewTimer(bp.conf.BatchTimeout)
 					idle = false
 				}
 			}
 		}
-		if (full || timedout || quescing) && len(bp.assemblyQueue) > 0 {
+		if (full || timedout || agedOut || sealed || minReached) && !quescing && (!bp.bm.isDispatcherEnabled(bp.conf.dispatcherKey) || bp.bm.isPaused()) {
+			// Hold the open batch while the dispatcher is disabled, or the manager paused, checking again after the batch timeout
+			// (but no more often than the minimum poll delay, so a zero batch timeout does not spin)
+			if timedout || agedOut {
+				_ = batchTimeout.Stop()
+				recheck := bp.conf.BatchTimeout
+				if recheck < bp.bm.minimumPollDelay {
+					recheck = bp.bm.minimumPollDelay
+				}
+				batchTimeout = bp.bm.clock.NewTimer(recheck)
+			}
+			continue
+		}
+		if (full || timedout || agedOut || sealed || minReached || quescing) && len(bp.assemblyQueue) > 0 {
 			// Let Go GC the old timer
 			_ = batchTimeout.Stop()
 
 			// If we are in overflow, start the clock for the next batch to start before we do the flush
 			// (even though we won't check it until after).
 			if overflow {
-				batchTimeout = time.NewTimer(bp.conf.BatchTimeout)
+				batchTimeout = bp.bm.clock.NewTimer(bp.conf.BatchTimeout)
 			}
 
-			err := bp.flush(overflow)
+			trigger := flushTriggerQuiesce
+			if full {
+				trigger = bp.fullTrigger(overflow)
+			} else if timedout {
+				trigger = flushTriggerTimeout
+			} else if agedOut {
+				trigger = flushTriggerMaxAge
+			} else if sealed {
+				trigger = flushTriggerSeal
+			} else if minReached {
+				trigger = flushTriggerMinSize
+			}
+			err := bp.flush(overflow, trigger)
+			for err == nil && quescing && len(bp.assemblyQueue) > 0 {
+				// Flush any work returned to the assembly by splitting the batch, before we exit
+				err = bp.flush(false, flushTriggerQuiesce)
+			}
 			if err != nil {
 				l.Warnf("Batch processor shutting down: %s", err)
 				_ = batchTimeout.Stop()
@@ -324,33 +438,130 @@ func (bp *batchProcessor) assemblyLoop() {
 			// If we didn't overflow, then just go back to idle - we don't know if we have more work to come, so
 			// either we'll pop straight away (and move to the batch timeout) or wait for the dispose timeout
 			if !overflow && !quescing {
-				batchTimeout = time.NewTimer(bp.conf.DisposeTimeout)
-				idle = true
+				if len(bp.assemblyQueue) > 0 {
+					// Work was returned to the assembly by splitting the batch - start the clock to flush it
+					batchTimeout = bp.bm.clock.NewTimer(bp.conf.BatchTimeout)
+				} else {
+					batchTimeout = bp.bm.clock.NewTimer(bp.conf.DisposeTimeout)
+					idle = true
+				}
 			}
 		}
 	}
 }
 
-func (bp *batchProcessor) flush(overflow bool) error {
+func (bp *batchProcessor) flush(overflow bool, trigger flushTrigger) error {
+	assemblyStarted := bp.assemblyStarted
 	id, flushWork, byteSize := bp.startFlush(overflow)
+	flushWork, byteSize, err := bp.splitByGroupMembership(flushWork, byteSize)
+	if err != nil {
+		return err
+	}
+
+	if bp.conf.noOp {
+		return bp.flushDeferred(flushWork, byteSize, trigger)
+	}
+	if bp.conf.DryRun {
+		return bp.flushDryRun(id, flushWork, byteSize, trigger, assemblyStarted)
+	}
 
 	log.L(bp.ctx).Debugf("Flushing batch %s", id)
+	queued := bp.bm.reserveDispatchQueue()
 	state := bp.initFlushState(id, flushWork)
+	state.Persisted.SealReason = trigger.sealReason()
+	state.queued = queued
+	if bp.conf.LatencyHandler != nil {
+		state.latency = &latencyMarks{assemblyStarted: assemblyStarted, flushStarted: time.Now()}
+	}
 
 	// Sealing phase: assigns persisted pins to messages, and finalizes the manifest
-	err := bp.sealBatch(state)
+	err = bp.sealBatch(state)
+	for err != nil && len(flushWork) > 1 && bp.isTxSizeLimit(err) {
+		// Split the batch, returning the remainder to the assembly, and try again with the smaller batch
+		keep := (len(flushWork) + 1) / 2
+		log.L(bp.ctx).Warnf("Batch %s with %d messages exceeded the database transaction size limit - splitting: %s", id, len(flushWork), err)
+		bp.reduceBatchSize(uint(keep))
+		bp.requeueWork(flushWork[keep:])
+		for _, w := range flushWork[keep:] {
+			byteSize -= w.estimateSize()
+		}
+		flushWork = flushWork[:keep]
+		latency := state.latency
+		state = bp.initFlushState(id, flushWork)
+		state.latency = latency
+		state.queued = queued
+		err = bp.sealBatch(state)
+	}
 	if err != nil {
 		return err
 	}
+	if !id.Equals(state.Persisted.ID) {
+		// The batch was assigned its ID as it was sealed
+		id = state.Persisted.ID
+		for _, w := range flushWork {
+			w.msg.BatchID = id
+		}
+	}
 	log.L(bp.ctx).Debugf("Sealed batch %s", id)
+	if bp.conf.OnMessageBatched != nil {
+		for _, msg := range state.Messages {
+			bp.conf.OnMessageBatched(msg.Header.ID, id)
+		}
+	}
+
+	if bp.bm.assembleOnly {
+		// Dispatch is performed by a separate process, that claims the assembled batch
+		log.L(bp.ctx).Debugf("Assembled batch %s", id)
+	} else if state.queued {
+		// Dispatch is paused, and the assembled batch is claimed for dispatch on resume
+		log.L(bp.ctx).Debugf("Queued batch %s while dispatch is paused", id)
+	} else if bp.dispatchSlots != nil {
+		// The flush completes once the batch has been dispatched by a worker
+		return bp.dispatchConcurrently(state, flushWork, byteSize, trigger)
+	} else {
+		bp.bm.enterDispatchBacklog()
+		if err = bp.waitForEarlierBatches(flushWork); err == nil {
+			err = bp.dispatchAndFinalize(state)
+		}
+		bp.bm.leaveDispatchBacklog()
+		if err != nil {
+			return err
+		}
+	}
+
+	bp.completeFlush(state, flushWork, byteSize, trigger)
+	return nil
+}
+
+func (bp *batchProcessor) completeFlush(state *DispatchState, flushWork []*batchWork, byteSize int64, trigger flushTrigger) {
+	// Notify the manager that we've flushed these sequences
+	bp.notifyFlushComplete(flushWork)
+	if bp.conf.order != nil {
+		bp.conf.order.release(flushWork)
+	}
+
+	// Update our stats
+	bp.updateFlushStats(state, byteSize)
+	bp.bm.recordFlush(state.Messages, trigger)
+}
+
+func (bp *batchProcessor) dispatchAndFinalize(state *DispatchState) error {
+	id := state.Persisted.ID
 
 	// Dispatch phase: the heavy lifting work - calling plugins to do the hard work of the batch.
 	//   The dispatcher can update the state, such as appending to the BlobsPublished array,
 	//   to affect DB updates as part of the finalization phase.
-	err = bp.dispatchBatch(state)
+	err := bp.dispatchBatch(state)
 	if err != nil {
+		if bp.isBatchTooLarge(state, err) {
+			return bp.splitAndDispatch(state, err)
+		}
+		if bp.conf.MaxDispatchAttempts > 0 && bp.ctx.Err() == nil {
+			return bp.deadLetterBatch(state, err)
+		}
 		return err
 	}
+	state.latency.markHandlerEnded()
 	log.L(bp.ctx).Debugf("Dispatched batch %s", id)
 
 	// Finalization phase: Writes back the changes to the DB, so that these messages will not be
@@ -360,13 +571,33 @@ func (bp *batchProcessor) flush(overflow bool) error {
 		return err
 	}
 	log.L(bp.ctx).Debugf("Finalized batch %s", id)
+	bp.bm.tapDispatch(state)
+	bp.reportLatency(bp.ctx, id, state.latency)
+	return nil
+}
 
-	// Notify the manager that we've flushed these sequences
-	bp.notifyFlushComplete(flushWork)
+// batchCorrelator returns the correlation ID of the first message in the batch that has one, or generates one
+func batchCorrelator(flushWork []*batchWork) *fftypes.UUID {
+	for _, w := range flushWork {
+		if w.msg != nil && w.msg.Header.CID != nil {
+			return w.msg.Header.CID
+		}
+	}
+	return fftypes.NewUUID()
+}
 
-	// Update our stats
-	bp.updateFlushStats(state, byteSize)
-	return nil
+// batchProvenance returns the provenance of the messages in the batch, keyed by message ID, to persist with the batch
+func batchProvenance(state *DispatchState) fftypes.JSONObject {
+	var provenance fftypes.JSONObject
+	for _, msg := range state.Messages {
+		if p := state.Provenance[*msg.Header.ID]; p != nil {
+			if provenance == nil {
+				provenance = fftypes.JSONObject{}
+			}
+			provenance[msg.Header.ID.String()] = p
+		}
+	}
+	return provenance
 }
 
 func (bp *batchProcessor) initFlushState(id *fftypes.UUID, flushWork []*batchWork) *DispatchState {
@@ -381,15 +612,25 @@ func (bp *batchProcessor) initFlushState(id *fftypes.UUID, flushWork []*batchWor
 				Created:   fftypes.Now(),
 			},
 		},
+		lazyData: bp.bm.lazyDataLoader(),
 	}
 	localNode, err := bp.bm.identity.GetLocalNode(bp.ctx)
 	if err == nil && localNode != nil {
 		state.Persisted.BatchHeader.Node = localNode.ID
 	}
+	if bp.conf.CorrelateBatch {
+		state.Persisted.Correlator = batchCorrelator(flushWork)
+	}
 	for _, w := range flushWork {
 		if w.msg != nil {
 			w.msg.BatchID = id
 			state.Messages = append(state.Messages, w.msg.BatchMessage())
+			if w.provenance != nil {
+				if state.Provenance == nil {
+					state.Provenance = make(map[fftypes.UUID]*MessageProvenance)
+				}
+				state.Provenance[*w.msg.Header.ID] = w.provenance
+			}
 		}
 		for _, d := range w.data {
 			log.L(bp.ctx).Debugf("Adding data '%s' to batch '%s' for message '%s'", d.ID, id, w.msg.Header.ID)
@@ -544,7 +785,7 @@ func (bp *batchProcessor) flushNonceState(ctx context.Context, state *DispatchSt
 
 func (bp *batchProcessor) sealBatch(state *DispatchState) (err error) {
 	err = bp.retry.Do(bp.ctx, "batch persist", func(attempt int) (retry bool, err error) {
-		return true, bp.database.RunAsGroup(bp.ctx, func(ctx context.Context) (err error) {
+		err = bp.bm.runAsGroup(bp.ctx, func(ctx context.Context) (err error) {
 
 			// Clear state from any previous retry. We need to do fresh queries against the DB for nonces.
 			state.noncesAssigned = make(map[fftypes.Bytes32]*nonceState)
@@ -565,6 +806,9 @@ func (bp *batchProcessor) sealBatch(state *DispatchState) (err error) {
 			if state.Persisted.TX.ID, err = bp.txHelper.SubmitNewTransaction(ctx, bp.conf.txType); err != nil {
 				return err
 			}
+			bp.assignBatchID(state)
+			bp.annotateBatch(state)
+			state.Persisted.Provenance = batchProvenance(state)
 			manifest := state.Persisted.GenManifest(state.Messages, state.Data)
 
 			// The hash of the batch, is the hash of the manifest to minimize the compute cost.
@@ -576,8 +820,26 @@ func (bp *batchProcessor) sealBatch(state *DispatchState) (err error) {
 			log.L(ctx).Debugf("Batch %s sealed. Hash=%s", state.Persisted.ID, state.Persisted.Hash)
 
 			// At this point the manifest of the batch is finalized. We write it to the database
-			return bp.database.UpsertBatch(ctx, &state.Persisted)
+			if err = bp.database.UpsertBatch(ctx, &state.Persisted); err != nil {
+				return err
+			}
+			if bp.conf.OnBatchSealed != nil {
+				bp.conf.OnBatchSealed(state.Persisted.GenInflight(state.Messages, state.Data))
+			}
+
+			switch {
+			case bp.bm.assembleOnly || state.queued:
+				// Record that the messages are assembled into this batch, ready to be claimed for dispatch
+				return bp.markPayloadState(ctx, state, core.MessageStateAssembled)
+			case bp.bm.recoveryEnabled:
+				// Record that the messages are in-flight in this batch, so they can be rebuilt after a restart
+				return bp.markPayloadState(ctx, state, core.MessageStateBatching)
+			default:
+				return nil
+			}
 		})
+		// A batch that is too large for a single transaction is returned to be split, rather than retried as-is
+		return (len(state.Messages) <= 1 || !bp.isTxSizeLimit(err)) && bp.bm.isRetryable(err), err
 	})
 	if err != nil {
 		return err
@@ -595,73 +857,197 @@ func (bp *batchProcessor) sealBatch(state *DispatchState) (err error) {
 	return nil
 }
 
+// dispatchStalled is called when a dispatch has been running for longer than the configured
+// stall threshold without succeeding - such as a handler that is blocked, or retrying indefinitely.
+func (bp *batchProcessor) dispatchStalled() {
+	bp.statusMux.Lock()
+	bp.flushStatus.Stalled = true
+	bp.flushStatus.TotalStalls++
+	bp.statusMux.Unlock()
+
+	status := bp.status()
+	log.L(bp.ctx).Warnf("Dispatch of batch %s stalled for over %s", status.Status.Flushing, bp.conf.StallThreshold)
+	if bp.conf.StallHandler != nil {
+		bp.conf.StallHandler(bp.ctx, status)
+	}
+}
+
+func (bp *batchProcessor) clearStalled() {
+	bp.statusMux.Lock()
+	defer bp.statusMux.Unlock()
+	bp.flushStatus.Stalled = false
+}
+
+func (bp *batchProcessor) dispatchWeight() int {
+	if bp.conf.DispatchWeight == nil {
+		return 1
+	}
+	return bp.conf.DispatchWeight(bp.conf.name)
+}
+
 func (bp *batchProcessor) dispatchBatch(state *DispatchState) error {
+	if bp.conf.dispatch == nil {
+		// Outbox only - the hand-off happens when the payload is marked dispatched
+		state.latency.markHandlerStarted()
+		return nil
+	}
+	if bp.bm.scheduler != nil {
+		if err := bp.bm.scheduler.acquire(bp.ctx, bp.conf.name, bp.dispatchWeight()); err != nil {
+			return err
+		}
+		defer bp.bm.scheduler.release()
+	}
+	if bp.conf.StallThreshold > 0 {
+		stallTimer := time.AfterFunc(bp.conf.StallThreshold, bp.dispatchStalled)
+		defer func() {
+			stallTimer.Stop()
+			bp.clearStalled()
+		}()
+	}
+
 	// Call the dispatcher to do the heavy lifting - will only exit if we're closed
-	return operations.RunWithOperationContext(bp.ctx, func(ctx context.Context) error {
-		return bp.retry.Do(ctx, "batch dispatch", func(attempt int) (retry bool, err error) {
-			return true, bp.conf.dispatch(ctx, state)
+	handlerState := bp.handlerState(state)
+	state.latency.markHandlerStarted()
+	err := operations.RunWithOperationContext(bp.ctx, func(ctx context.Context) error {
+		return bp.dispatchRetry.Do(ctx, "batch dispatch", func(attempt int) (retry bool, err error) {
+			// Only the signal of the attempt that succeeds counts
+			atomic.StoreInt32(&handlerState.slowDown, 0)
+			retry, err = bp.dispatchAttempt(ctx, handlerState)
+			if err != nil {
+				bp.bm.recordDispatchError(state.Messages)
+			}
+			if bp.isBatchTooLarge(state, err) {
+				// Split rather than retry
+				return false, err
+			}
+			return retry && !bp.dispatchAttemptsExhausted(attempt), err
 		})
 	})
+	if err == nil {
+		bp.bm.setSlowDown(bp.conf.dispatcherKey, handlerState.slowDownSignalled())
+	}
+	return err
+}
+
+func (bp *batchProcessor) markPayloadState(ctx context.Context, state *DispatchState, msgState core.MessageState) error {
+	msgIDs := make([]driver.Value, len(state.Messages))
+	for i, msg := range state.Messages {
+		msgIDs[i] = msg.Header.ID
+	}
+	fb := database.MessageQueryFactory.NewFilter(ctx)
+	filter := fb.And(
+		fb.In("id", msgIDs),
+		fb.In("state", []driver.Value{core.MessageStateReady, core.MessageStateDeferred, core.MessageStateBatching}),
+	)
+	update := database.MessageQueryFactory.NewUpdate(ctx).
+		Set("batch", state.Persisted.ID).
+		Set("state", msgState)
+	return bp.database.UpdateMessages(ctx, bp.bm.namespace, filter, update)
 }
 
 func (bp *batchProcessor) markPayloadDispatched(state *DispatchState) error {
+	// The messages are normally all marked with one update statement. If the database rejects that for its size,
+	// the whole transaction is retried with the update split into smaller chunks - so the batch is always marked
+	// dispatched atomically, along with its outbox entry, confirmation events and read-back verification.
+	// The chunks share the transaction, so this relieves a limit on the size of a statement (such as the number of
+	// bind parameters for the message IDs) - not a limit on the size of the transaction. Committing each chunk
+	// separately would leave a batch part-marked on failure. Instead the maximum size of future batches is reduced,
+	// which bounds the size of their transactions.
+	chunkSize := len(state.Messages)
 	return bp.retry.Do(bp.ctx, "mark dispatched messages", func(attempt int) (retry bool, err error) {
-		return true, bp.database.RunAsGroup(bp.ctx, func(ctx context.Context) (err error) {
-			// Update all the messages in the batch with the batch ID
-			msgIDs := make([]driver.Value, len(state.Messages))
-			confirmTime := fftypes.Now()
-			for i, msg := range state.Messages {
-				msgIDs[i] = msg.Header.ID
-				msg.BatchID = state.Persisted.ID
-				if bp.conf.txType == core.TransactionTypeBatchPin {
-					msg.State = core.MessageStateSent
-				} else {
-					msg.State = core.MessageStateConfirmed
-					msg.Confirmed = confirmTime
-				}
-				// We don't want to have to read the DB again if we want to query for the batch ID, or pins,
-				// so ensure the copy in our cache gets updated.
-				bp.data.UpdateMessageIfCached(ctx, msg)
-			}
-			fb := database.MessageQueryFactory.NewFilter(ctx)
-			filter := fb.And(
-				fb.In("id", msgIDs),
-				fb.Eq("state", core.MessageStateReady), // In the outside chance the next state transition happens first (which supersedes this)
-			)
+		err = bp.bm.runAsGroup(bp.ctx, func(ctx context.Context) error {
+			return bp.markMessagesDispatched(ctx, state, chunkSize)
+		})
+		if err != nil && chunkSize > 1 && bp.isTxSizeLimit(err) {
+			chunkSize = (chunkSize + 1) / 2
+			bp.reduceBatchSize(uint(chunkSize))
+		}
+		return bp.bm.isRetryable(err), err
+	})
+}
 
-			var allMsgsUpdate database.Update
-			if bp.conf.txType == core.TransactionTypeBatchPin {
-				// Sent state waiting for confirm
-				allMsgsUpdate = database.MessageQueryFactory.NewUpdate(ctx).
-					Set("batch", state.Persisted.ID).   // Mark the batch they are in
-					Set("state", core.MessageStateSent) // Set them sent, so they won't be picked up and re-sent after restart/rewind
-			} else {
-				// Immediate confirmation if no batch pinning
-				allMsgsUpdate = database.MessageQueryFactory.NewUpdate(ctx).
-					Set("batch", state.Persisted.ID).
-					Set("state", core.MessageStateConfirmed).
-					Set("confirmed", confirmTime)
-			}
+// markMessagesDispatched marks all the messages in the batch dispatched, within a database transaction. The update
+// is split into statements of at most chunkSize messages.
+func (bp *batchProcessor) markMessagesDispatched(ctx context.Context, state *DispatchState, chunkSize int) (err error) {
+	// Update all the messages in the batch with the batch ID
+	msgs := state.Messages
+	msgIDs := make([]driver.Value, len(msgs))
+	confirmTime := fftypes.Now()
+	for i, msg := range msgs {
+		msgIDs[i] = msg.Header.ID
+		msg.BatchID = state.Persisted.ID
+		if bp.conf.txType == core.TransactionTypeBatchPin {
+			msg.State = core.MessageStateSent
+		} else {
+			msg.State = core.MessageStateConfirmed
+			msg.Confirmed = confirmTime
+		}
+		// We don't want to have to read the DB again if we want to query for the batch ID, or pins,
+		// so ensure the copy in our cache gets updated.
+		bp.data.UpdateMessageIfCached(ctx, msg)
+	}
 
-			if err = bp.database.UpdateMessages(ctx, bp.bm.namespace, filter, allMsgsUpdate); err != nil {
-				return err
-			}
+	var allMsgsUpdate database.Update
+	if bp.conf.txType == core.TransactionTypeBatchPin {
+		// Sent state waiting for confirm
+		allMsgsUpdate = database.MessageQueryFactory.NewUpdate(ctx).
+			Set("batch", state.Persisted.ID).   // Mark the batch they are in
+			Set("state", core.MessageStateSent) // Set them sent, so they won't be picked up and re-sent after restart/rewind
+	} else {
+		// Immediate confirmation if no batch pinning
+		allMsgsUpdate = database.MessageQueryFactory.NewUpdate(ctx).
+			Set("batch", state.Persisted.ID).
+			Set("state", core.MessageStateConfirmed).
+			Set("confirmed", confirmTime)
+	}
 
-			if bp.conf.txType == core.TransactionTypeUnpinned {
-				for _, msg := range state.Messages {
-					// Emit a confirmation event locally immediately
-					for _, topic := range msg.Header.Topics {
-						// One event per topic
-						event := core.NewEvent(core.EventTypeMessageConfirmed, state.Persisted.Namespace, msg.Header.ID, state.Persisted.TX.ID, topic)
-						event.Correlator = msg.Header.CID
-						if err := bp.database.InsertEvent(ctx, event); err != nil {
-							return err
-						}
-					}
+	for start := 0; start < len(msgIDs); start += chunkSize {
+		end := start + chunkSize
+		if end > len(msgIDs) {
+			end = len(msgIDs)
+		}
+		if err = bp.database.UpdateMessages(ctx, bp.bm.namespace, bp.dispatchedFilter(ctx, state, msgIDs[start:end]), allMsgsUpdate); err != nil {
+			return err
+		}
+	}
+
+	if bp.conf.Outbox {
+		if err = bp.writeOutbox(ctx, state); err != nil {
+			return err
+		}
+	}
+
+	if bp.conf.txType == core.TransactionTypeUnpinned {
+		for _, msg := range msgs {
+			// Emit a confirmation event locally immediately
+			for _, topic := range msg.Header.Topics {
+				// One event per topic
+				event := core.NewEvent(core.EventTypeMessageConfirmed, state.Persisted.Namespace, msg.Header.ID, state.Persisted.TX.ID, topic)
+				event.Correlator = msg.Header.CID
+				event.BatchCorrelator = state.Persisted.Correlator
+				if err := bp.database.InsertEvent(ctx, event); err != nil {
+					return err
 				}
 			}
+		}
+	}
 
-			return nil
-		})
-	})
+	if bp.conf.VerifyReadBack {
+		return bp.verifyDispatched(ctx, state, msgs, msgIDs)
+	}
+	return nil
+}
+
+// dispatchedFilter selects the messages to mark dispatched, in the states they can be marked from
+func (bp *batchProcessor) dispatchedFilter(ctx context.Context, state *DispatchState, msgIDs []driver.Value) database.Filter {
+	fb := database.MessageQueryFactory.NewFilter(ctx)
+	// In the outside chance the next state transition happens first (which supersedes this)
+	stateFilter := fb.In("state", readableMessageStates)
+	if bp.bm.recoveryEnabled || state.claimed {
+		stateFilter = fb.In("state", []driver.Value{core.MessageStateReady, core.MessageStateBatching})
+	}
+	return fb.And(
+		fb.In("id", msgIDs),
+		stateFilter,
+	)
 }
@@ -650,3 +650,194 @@ func TestBigBatchEstimate(t *testing.T) {
 
 	assert.Greater(t, sizeEstimate, int64(len(bd)))
 }
+
+func TestDispatchStalledAlert(t *testing.T) {
+	unblock := make(chan struct{})
+	cancel, _, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		<-unblock
+		return nil
+	})
+	defer cancel()
+
+	stalled := make(chan *ProcessorStatus, 1)
+	bp.conf.StallThreshold = 10 * time.Millisecond
+	bp.conf.StallHandler = func(ctx context.Context, status *ProcessorStatus) {
+		stalled <- status
+	}
+
+	dispatched := make(chan error)
+	go func() {
+		dispatched <- bp.dispatchBatch(&DispatchState{})
+	}()
+
+	status := <-stalled
+	assert.True(t, status.Status.Stalled)
+	assert.Equal(t, int64(1), status.Status.TotalStalls)
+	assert.Equal(t, int64(0), status.Status.TotalErrors)
+
+	close(unblock)
+	assert.NoError(t, <-dispatched)
+	assert.False(t, bp.status().Status.Stalled)
+	assert.Equal(t, int64(1), bp.status().Status.TotalStalls)
+}
+
+func TestOnBatchSealedWithinTransaction(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	bp.conf.txType = core.TransactionTypeUnpinned
+	bp.bm.recoveryEnabled = true
+	mockRunAsGroupPassthrough(mdi)
+	bp.txHelper.(*txcommonmocks.Helper).On("SubmitNewTransaction", mock.Anything, core.TransactionTypeUnpinned).Return(fftypes.NewUUID(), nil)
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+
+	var sealed *core.Batch
+	bp.conf.OnBatchSealed = func(batch *core.Batch) {
+		// The batch is persisted, but the messages are not yet updated
+		mdi.AssertNumberOfCalls(t, "UpsertBatch", 1)
+		mdi.AssertNotCalled(t, "UpdateMessages", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		sealed = batch
+	}
+
+	state := &DispatchState{
+		Persisted: core.BatchPersisted{BatchHeader: core.BatchHeader{ID: fftypes.NewUUID()}},
+		Messages:  []*core.Message{newTestBroadcastMessage(1001)},
+		Data:      core.DataArray{{ID: fftypes.NewUUID()}},
+	}
+	err := bp.sealBatch(state)
+	assert.NoError(t, err)
+
+	assert.Equal(t, state.Persisted.ID, sealed.ID)
+	assert.Equal(t, state.Persisted.Hash, sealed.Hash)
+	assert.Len(t, sealed.Payload.Messages, 1)
+	assert.Len(t, sealed.Payload.Data, 1)
+	mdi.AssertNumberOfCalls(t, "UpdateMessages", 1)
+}
+
+func TestDispatchRetryInheritsUnsetFields(t *testing.T) {
+	base := &retry.Retry{InitialDelay: 1 * time.Second, MaximumDelay: 1 * time.Minute, Factor: 2.0}
+
+	r := newDispatchRetry(base, &DispatchRetryOptions{})
+	assert.Equal(t, 1*time.Second, r.InitialDelay)
+	assert.Equal(t, 1*time.Minute, r.MaximumDelay)
+	assert.Equal(t, 2.0, r.Factor)
+
+	r = newDispatchRetry(base, &DispatchRetryOptions{InitialDelay: 10 * time.Millisecond, Factor: 1.5})
+	assert.Equal(t, 10*time.Millisecond, r.InitialDelay)
+	assert.Equal(t, 1*time.Minute, r.MaximumDelay)
+	assert.Equal(t, 1.5, r.Factor)
+
+	r = newDispatchRetry(base, &DispatchRetryOptions{MaximumDelay: 100 * time.Millisecond})
+	assert.Equal(t, 100*time.Millisecond, r.MaximumDelay)
+}
+
+func TestDispatchRetrySeparateFromDatabaseRetry(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		return fmt.Errorf("pop")
+	})
+	defer cancel()
+	// The database retry is patient, but the dispatch retries on its own backoff, set when the processor was created
+	bp.retry.InitialDelay = 1 * time.Hour
+	bp.retry.MaximumDelay = 1 * time.Hour
+	bp.conf.MaxDispatchAttempts = 2
+	mockRunAsGroupPassthrough(mdi)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+	bp.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+
+	err := bp.dispatchAndFinalize(&DispatchState{
+		Persisted: core.BatchPersisted{BatchHeader: core.BatchHeader{ID: fftypes.NewUUID()}},
+		Messages:  []*core.Message{newTestBroadcastMessage(1001)},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), bp.status().Status.TotalErrors)
+}
+
+func TestOnMessageBatchedOncePerMessageAcrossSplit(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		return nil
+	})
+	defer cancel()
+	bp.conf.txType = core.TransactionTypeUnpinned
+	bp.conf.TxSizeLimitError = isTestTxTooLarge
+	mockRunAsGroupPassthrough(mdi)
+	bp.bm.identity.(*identitymanagermocks.Manager).On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
+	bp.txHelper.(*txcommonmocks.Helper).On("SubmitNewTransaction", mock.Anything, core.TransactionTypeUnpinned).Return(fftypes.NewUUID(), nil)
+	bp.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(errTestTxTooLarge).Once()
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("InsertEvent", mock.Anything, mock.Anything).Return(nil)
+
+	batched := make(map[fftypes.UUID][]*fftypes.UUID)
+	bp.conf.OnMessageBatched = func(msgID, batchID *fftypes.UUID) {
+		batched[*msgID] = append(batched[*msgID], batchID)
+	}
+
+	msgs := make([]*core.Message, 4)
+	for i := range msgs {
+		msgs[i] = newTestBroadcastMessage(int64(1001 + i))
+		bp.addWork(&batchWork{msg: msgs[i]})
+	}
+
+	// The first seal is rejected for its size, so half the messages are returned to the assembly
+	err := bp.flush(false, flushTriggerSize)
+	assert.NoError(t, err)
+	assert.Len(t, batched, 2)
+	err = bp.flush(false, flushTriggerQuiesce)
+	assert.NoError(t, err)
+	assert.Len(t, batched, 4)
+
+	for _, msg := range msgs {
+		assert.Len(t, batched[*msg.Header.ID], 1)
+		assert.Equal(t, msg.BatchID, batched[*msg.Header.ID][0])
+	}
+	assert.NotEqual(t, *msgs[0].BatchID, *msgs[3].BatchID)
+}
+
+func TestOversizedMessageDispatchedAlone(t *testing.T) {
+	dispatched := make(chan *DispatchState, 2)
+	cancel, mdi, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		dispatched <- state
+		return nil
+	})
+	defer cancel()
+	bp.conf.txType = core.TransactionTypeUnpinned
+	bp.conf.BatchMaxBytes = 2048
+	mockRunAsGroupPassthrough(mdi)
+	bp.bm.identity.(*identitymanagermocks.Manager).On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
+	bp.txHelper.(*txcommonmocks.Helper).On("SubmitNewTransaction", mock.Anything, core.TransactionTypeUnpinned).Return(fftypes.NewUUID(), nil)
+	bp.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("InsertEvent", mock.Anything, mock.Anything).Return(nil)
+
+	small := &batchWork{msg: newTestBroadcastMessage(1001)}
+	large := &batchWork{
+		msg:  newTestBroadcastMessage(1002),
+		data: core.DataArray{{ID: fftypes.NewUUID(), Value: fftypes.JSONAnyPtr(fmt.Sprintf(`"%0*d"`, 4096, 0))}},
+	}
+	assert.Greater(t, large.estimateSize(), bp.conf.BatchMaxBytes)
+
+	// The oversized message overflows the batch it was added to
+	full, overflow := bp.addWork(small)
+	assert.False(t, full)
+	assert.False(t, overflow)
+	full, overflow = bp.addWork(large)
+	assert.True(t, full)
+	assert.True(t, overflow)
+	err := bp.flush(overflow, flushTriggerSize)
+	assert.NoError(t, err)
+	state := <-dispatched
+	assert.Len(t, state.Messages, 1)
+	assert.Equal(t, small.msg.Header.ID, state.Messages[0].Header.ID)
+
+	// Then is flushed in a batch on its own, rather than retried
+	assert.Len(t, bp.assemblyQueue, 1)
+	assert.Greater(t, bp.assemblyQueueBytes, bp.conf.BatchMaxBytes)
+	err = bp.flush(false, flushTriggerSize)
+	assert.NoError(t, err)
+	state = <-dispatched
+	assert.Len(t, state.Messages, 1)
+	assert.Equal(t, large.msg.Header.ID, state.Messages[0].Header.ID)
+	assert.Empty(t, bp.assemblyQueue)
+}
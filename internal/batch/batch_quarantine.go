@@ -0,0 +1,81 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// QuarantinedBatch is the full content of a dead-lettered batch, preserved along with the error that failed it
+type QuarantinedBatch struct {
+	Dispatcher  string
+	Batch       *core.Batch
+	Error       string
+	Quarantined *fftypes.FFTime
+}
+
+// QuarantineStore preserves the content of dead-lettered batches, so they can be analysed and replayed even once the
+// original messages and data have been purged
+type QuarantineStore interface {
+	// Put stores a dead-lettered batch, replacing any earlier entry for the same batch ID
+	Put(ctx context.Context, entry *QuarantinedBatch) error
+	// Get returns the entry for the batch, or nil if the batch is not in the store
+	Get(ctx context.Context, batchID *fftypes.UUID) (*QuarantinedBatch, error)
+}
+
+// SetQuarantineStore sets the store the content of each dead-lettered batch is written to. The write is retried
+// along with the DeadLetter callback, so the messages of the batch are only marked failed - and the offset only
+// advances past them - once the batch is in the store. It must be called before Start.
+func (bm *batchManager) SetQuarantineStore(store QuarantineStore) {
+	bm.quarantineStore = store
+}
+
+// quarantineBatch writes a dead-lettered batch to the quarantine store, if one is set
+func (bp *batchProcessor) quarantineBatch(batch *core.Batch, dispatchErr error) error {
+	if bp.bm.quarantineStore == nil {
+		return nil
+	}
+	return bp.bm.quarantineStore.Put(bp.ctx, &QuarantinedBatch{
+		Dispatcher:  bp.conf.dispatcherName,
+		Batch:       batch,
+		Error:       dispatchErr.Error(),
+		Quarantined: fftypes.Now(),
+	})
+}
+
+// ReplayQuarantined reads a dead-lettered batch from the quarantine store, and passes it to the handler exactly as it
+// was dispatched. Neither the offset nor the state of any message is affected.
+func (bm *batchManager) ReplayQuarantined(ctx context.Context, batchID *fftypes.UUID, handler ReplayHandler) error {
+	if bm.quarantineStore == nil {
+		return i18n.NewError(ctx, coremsgs.MsgQuarantineStoreNotSet)
+	}
+	entry, err := bm.quarantineStore.Get(ctx, batchID)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return i18n.NewError(ctx, coremsgs.MsgQuarantinedBatchNotFound, batchID)
+	}
+	log.L(ctx).Infof("Replaying quarantined batch %s of dispatcher %s, which failed with: %s", batchID, entry.Dispatcher, entry.Error)
+	return handler(ctx, entry.Batch)
+}
@@ -0,0 +1,139 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// memoryQuarantineStore is a QuarantineStore holding its entries in memory, failing the given number of puts first
+type memoryQuarantineStore struct {
+	entries  map[fftypes.UUID]*QuarantinedBatch
+	failPuts int
+}
+
+func (s *memoryQuarantineStore) Put(ctx context.Context, entry *QuarantinedBatch) error {
+	if s.failPuts > 0 {
+		s.failPuts--
+		return fmt.Errorf("quarantine store unavailable")
+	}
+	s.entries[*entry.Batch.ID] = entry
+	return nil
+}
+
+func (s *memoryQuarantineStore) Get(ctx context.Context, batchID *fftypes.UUID) (*QuarantinedBatch, error) {
+	return s.entries[*batchID], nil
+}
+
+func TestDeadLetteredBatchQuarantinedAndReplayed(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		return fmt.Errorf("pop")
+	})
+	defer cancel()
+	bp.conf.dispatcherName = "utdispatcher"
+	bp.conf.MaxDispatchAttempts = 1
+	store := &memoryQuarantineStore{entries: make(map[fftypes.UUID]*QuarantinedBatch), failPuts: 1}
+	bp.bm.SetQuarantineStore(store)
+	mockRunAsGroupPassthrough(mdi)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		// The messages are only marked failed once the batch is in the store
+		assert.Len(t, store.entries, 1)
+	}).Return(nil)
+	bp.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+
+	state := newTestDeadLetterState()
+	state.Data = core.DataArray{{ID: fftypes.NewUUID(), Value: fftypes.JSONAnyPtr(`"data"`)}}
+	err := bp.dispatchAndFinalize(state)
+	assert.NoError(t, err)
+
+	entry := store.entries[*state.Persisted.ID]
+	assert.Equal(t, "utdispatcher", entry.Dispatcher)
+	assert.Regexp(t, "pop", entry.Error)
+	assert.NotNil(t, entry.Quarantined)
+
+	// The full content of the batch is replayed from the store
+	var replayed *core.Batch
+	err = bp.bm.ReplayQuarantined(context.Background(), state.Persisted.ID, func(ctx context.Context, batch *core.Batch) error {
+		replayed = batch
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, state.Persisted.ID, replayed.ID)
+	assert.Len(t, replayed.Payload.Messages, 2)
+	assert.Equal(t, state.Messages[0].Header.ID, replayed.Payload.Messages[0].Header.ID)
+	assert.Equal(t, `"data"`, replayed.Payload.Data[0].Value.String())
+}
+
+func TestReplayQuarantinedNoStore(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	err := bm.ReplayQuarantined(context.Background(), fftypes.NewUUID(), func(ctx context.Context, batch *core.Batch) error {
+		return nil
+	})
+	assert.Regexp(t, "FF10450", err)
+}
+
+func TestReplayQuarantinedNotFound(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.SetQuarantineStore(&memoryQuarantineStore{entries: make(map[fftypes.UUID]*QuarantinedBatch)})
+
+	err := bm.ReplayQuarantined(context.Background(), fftypes.NewUUID(), func(ctx context.Context, batch *core.Batch) error {
+		return nil
+	})
+	assert.Regexp(t, "FF10451", err)
+}
+
+func TestReplayQuarantinedGetFail(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.SetQuarantineStore(&failingQuarantineStore{})
+
+	err := bm.ReplayQuarantined(context.Background(), fftypes.NewUUID(), func(ctx context.Context, batch *core.Batch) error {
+		return nil
+	})
+	assert.Regexp(t, "pop", err)
+}
+
+func TestQuarantineFailsOnClose(t *testing.T) {
+	cancel, _, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	bp.bm.SetQuarantineStore(&failingQuarantineStore{})
+	bp.cancelCtx()
+
+	err := bp.deadLetterBatch(newTestDeadLetterState(), fmt.Errorf("pop"))
+	assert.Error(t, err)
+}
+
+type failingQuarantineStore struct{}
+
+func (s *failingQuarantineStore) Put(ctx context.Context, entry *QuarantinedBatch) error {
+	return fmt.Errorf("pop")
+}
+
+func (s *failingQuarantineStore) Get(ctx context.Context, batchID *fftypes.UUID) (*QuarantinedBatch, error) {
+	return nil, fmt.Errorf("pop")
+}
@@ -0,0 +1,91 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// deferUntilReady consults the ReadinessGate of the message's dispatcher, and if the message is not yet ready
+// (or the gate fails) records it as deferred, with a rewind scheduled to consult the gate again.
+// Returns true if the message was deferred.
+func (bm *batchManager) deferUntilReady(msg *core.Message) bool {
+	var gate func(msg *core.Message) (bool, error)
+	recheck := bm.messagePollTimeout
+	dispatcherKey := bm.getDispatcherKey(msg.Header.TxType, msg.Header.Type)
+	bm.dispatcherMux.Lock()
+	if d, ok := bm.dispatcherMap[dispatcherKey]; ok {
+		gate = d.options.ReadinessGate
+		if d.options.ReadinessRecheck > 0 {
+			recheck = d.options.ReadinessRecheck
+		}
+	}
+	bm.dispatcherMux.Unlock()
+
+	if gate == nil {
+		return false
+	}
+	ready, err := gate(msg)
+	switch {
+	case err != nil:
+		log.L(bm.ctx).Errorf("Readiness gate failed for message %s (seq=%d) - blocking for %s: %s", msg.Header.ID, msg.Sequence, recheck, err)
+	case !ready:
+		log.L(bm.ctx).Debugf("Deferring message %s (seq=%d) for %s until it is ready", msg.Header.ID, msg.Sequence, recheck)
+	default:
+		return false
+	}
+	bm.deferForRecheck(msg.Sequence, dispatcherKey, recheck)
+	bm.readinessHolds[bm.readinessHoldKey(msg)] = msg.Sequence
+	return true
+}
+
+// readinessHoldKey identifies the messages that must be dispatched in order, behind a message deferred by the
+// ReadinessGate of its dispatcher
+func (bm *batchManager) readinessHoldKey(msg *core.Message) string {
+	return fmt.Sprintf("%s|%s", bm.getDispatcherKey(msg.Header.TxType, msg.Header.Type), bm.getProcessorKey(&msg.Header.SignerRef, msg.Header.Group))
+}
+
+// holdBehindDeferred returns true if an earlier message for the same author and group has been deferred by the
+// ReadinessGate, so this message must wait to be read again after it - rather than be dispatched ahead of it.
+// Only the sequencer calls this, so no locking is required.
+func (bm *batchManager) holdBehindDeferred(msg *core.Message) bool {
+	key := bm.readinessHoldKey(msg)
+	deferredSeq, held := bm.readinessHolds[key]
+	if !held {
+		return false
+	}
+	if msg.Sequence <= deferredSeq {
+		// This is the deferred message (or an earlier one) being read again, so the gate decides afresh
+		delete(bm.readinessHolds, key)
+		return false
+	}
+	log.L(bm.ctx).Debugf("Holding message %s (seq=%d) behind deferred message seq=%d", msg.Header.ID, msg.Sequence, deferredSeq)
+	return true
+}
+
+// releaseReadinessHolds is called before each page is read, to drop the holds of any deferred messages the read
+// offset has been rewound to before - as they will be read again, and held again if still not ready
+func (bm *batchManager) releaseReadinessHolds() {
+	for key, deferredSeq := range bm.readinessHolds {
+		if deferredSeq > bm.readOffset {
+			delete(bm.readinessHolds, key)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestReadinessGateDefersUntilReady(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+
+	var ready, checks int32
+	dispatched := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:     1,
+			DisposeTimeout:   120 * time.Second,
+			ReadinessRecheck: 10 * time.Millisecond,
+			ReadinessGate: func(msg *core.Message) (bool, error) {
+				atomic.AddInt32(&checks, 1)
+				return atomic.LoadInt32(&ready) == 1, nil
+			},
+		},
+	)
+
+	// The message is returned each time we read from before it
+	msg := newTestBroadcastMessage(1001)
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(func(ctx context.Context, ns string, filter database.Filter) []*core.IDAndSequence {
+		fi, _ := filter.Finalize()
+		if strings.HasPrefix(fi.String(), "( sequence >> 1000 )") {
+			return []*core.IDAndSequence{{ID: *msg.Header.ID, Sequence: msg.Sequence}}
+		}
+		return []*core.IDAndSequence{}
+	}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	// The gated message is deferred and re-checked, and the offset cannot advance past it
+	assert.Eventually(t, func() bool {
+		bm.inflightMux.Lock()
+		defer bm.inflightMux.Unlock()
+		return bm.deferredSequences[1001] == bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast) && bm.highestReadOffset == 1001 && atomic.LoadInt32(&checks) > 1
+	}, 5*time.Second, time.Millisecond)
+	bm.inflightMux.Lock()
+	assert.Equal(t, int64(1000), bm.calcCommittableOffset())
+	bm.inflightMux.Unlock()
+	select {
+	case <-dispatched:
+		assert.Fail(t, "dispatched before ready")
+	default:
+	}
+
+	// It is dispatched once the gate reports ready
+	atomic.StoreInt32(&ready, 1)
+	state := <-dispatched
+	assert.Equal(t, msg.Header.ID, state.Messages[0].Header.ID)
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestReadinessGateErrorBlocks(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{
+			BatchMaxSize:     1,
+			ReadinessRecheck: time.Hour,
+			ReadinessGate: func(msg *core.Message) (bool, error) {
+				return false, fmt.Errorf("pop")
+			},
+		},
+	)
+
+	msg := newTestBroadcastMessage(1001)
+	assert.True(t, bm.deferUntilReady(msg))
+	assert.Equal(t, bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast), bm.deferredSequences[1001])
+}
+
+func TestReadinessGateNotSet(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 1},
+	)
+
+	assert.False(t, bm.deferUntilReady(newTestBroadcastMessage(1001)))
+	assert.Empty(t, bm.deferredSequences)
+}
+
+func TestReadinessGateHoldsLaterMessagesInOrder(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+
+	msg1 := newTestBroadcastMessage(1001)
+	msg2 := newTestBroadcastMessage(1002)
+	var ready int32
+	dispatched := make(chan *DispatchState, 2)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:     1,
+			DisposeTimeout:   120 * time.Second,
+			ReadinessRecheck: 10 * time.Millisecond,
+			ReadinessGate: func(msg *core.Message) (bool, error) {
+				// Only the first message is gated - the second is always ready
+				return !msg.Header.ID.Equals(msg1.Header.ID) || atomic.LoadInt32(&ready) == 1, nil
+			},
+		},
+	)
+
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg1.Header.ID).Return(msg1, core.DataArray{}, true, nil)
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg2.Header.ID).Return(msg2, core.DataArray{}, true, nil)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(func(ctx context.Context, ns string, filter database.Filter) []*core.IDAndSequence {
+		fi, _ := filter.Finalize()
+		switch {
+		case strings.HasPrefix(fi.String(), "( sequence >> 1000 )"):
+			return []*core.IDAndSequence{{ID: *msg1.Header.ID, Sequence: 1001}, {ID: *msg2.Header.ID, Sequence: 1002}}
+		case strings.HasPrefix(fi.String(), "( sequence >> 1001 )"):
+			return []*core.IDAndSequence{{ID: *msg2.Header.ID, Sequence: 1002}}
+		}
+		return []*core.IDAndSequence{}
+	}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	// The second message is held behind the deferred first message, on every re-read
+	assert.Eventually(t, func() bool {
+		bm.inflightMux.Lock()
+		defer bm.inflightMux.Unlock()
+		return bm.deferredSequences[1001] == bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast) && bm.highestReadOffset == 1002
+	}, 5*time.Second, time.Millisecond)
+	select {
+	case <-dispatched:
+		assert.Fail(t, "dispatched ahead of the deferred message")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Once the first message is ready, both are dispatched in order
+	atomic.StoreInt32(&ready, 1)
+	state := <-dispatched
+	assert.Equal(t, msg1.Header.ID, state.Messages[0].Header.ID)
+	state = <-dispatched
+	assert.Equal(t, msg2.Header.ID, state.Messages[0].Header.ID)
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestReadinessHoldScope(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{
+			BatchMaxSize:     1,
+			ReadinessRecheck: time.Hour,
+			ReadinessGate: func(msg *core.Message) (bool, error) {
+				return msg.Sequence != 1001, nil
+			},
+		},
+	)
+
+	bm.readOffset = 1000
+	assert.True(t, bm.deferUntilReady(newTestBroadcastMessage(1001)))
+
+	// Only later messages from the same author are held
+	assert.True(t, bm.holdBehindDeferred(newTestBroadcastMessage(1002)))
+	otherAuthor := newTestBroadcastMessage(1003)
+	otherAuthor.Header.Author = "did:firefly:org/efgh"
+	assert.False(t, bm.holdBehindDeferred(otherAuthor))
+
+	// The hold remains as the sequencer reads on, but is released by a rewind to before the deferred message
+	bm.readOffset = 1003
+	bm.releaseReadinessHolds()
+	assert.True(t, bm.holdBehindDeferred(newTestBroadcastMessage(1004)))
+	bm.readOffset = 1000
+	bm.releaseReadinessHolds()
+	assert.False(t, bm.holdBehindDeferred(newTestBroadcastMessage(1002)))
+
+	// Reading the deferred message again releases the hold, for the gate to decide afresh
+	assert.True(t, bm.deferUntilReady(newTestBroadcastMessage(1001)))
+	assert.False(t, bm.holdBehindDeferred(newTestBroadcastMessage(1001)))
+	assert.Empty(t, bm.readinessHolds)
+}
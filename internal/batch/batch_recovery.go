@@ -0,0 +1,65 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+)
+
+// recoverBatching finds any messages that were sealed into a batch, but where dispatch of that
+// batch did not complete before we stopped (such as after a crash). The messages are rebuilt into
+// new batches via the normal dispatch path, before we start reading ready messages.
+func (bm *batchManager) recoverBatching() error {
+	l := log.L(bm.ctx)
+	lastSequence := int64(-1)
+	recovered := 0
+	for {
+		var entries []*core.IDAndSequence
+		err := bm.retry.Do(bm.ctx, "retrieve batching messages", func(attempt int) (retry bool, err error) {
+			fb := database.MessageQueryFactory.NewFilterLimit(bm.ctx, bm.readPageSize)
+			entries, err = bm.database.GetMessageIDs(bm.ctx, bm.namespace, fb.And(
+				fb.Gt("sequence", lastSequence),
+				fb.Eq("state", core.MessageStateBatching),
+			).Sort("sequence").Limit(bm.readPageSize))
+			return true, err
+		})
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			break
+		}
+
+		pending, _ := bm.preparePage(bm.ctx, entries, lastSequence, time.Time{})
+		for _, pd := range bm.clusterByAffinity(pending) {
+			bm.dispatchMessage(bm.ctx, pd)
+		}
+		recovered += len(entries)
+		lastSequence = entries[len(entries)-1].Sequence
+		if len(entries) < int(bm.readPageSize) {
+			break
+		}
+	}
+	if recovered > 0 {
+		l.Infof("Recovered %d in-flight messages into new batches", recovered)
+	}
+	return nil
+}
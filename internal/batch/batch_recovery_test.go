@@ -0,0 +1,123 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRecoverBatchingOnStart(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.recoveryEnabled = true
+
+	dispatched := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+
+	msg := newTestBroadcastMessage(1001)
+	mockMessagePage(mdi, mdm, msg)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	state := <-dispatched
+	assert.Len(t, state.Messages, 1)
+	assert.Equal(t, *msg.Header.ID, *state.Messages[0].Header.ID)
+
+	cancel()
+	bm.WaitStop()
+
+	// The first query must be for the batching messages
+	recoveryFilter, _ := mdi.Calls[0].Arguments[2].(database.Filter).Finalize()
+	assert.Contains(t, recoveryFilter.String(), "state == 'batching'")
+
+	// The batching state must be set on seal, and must be accepted when marking dispatched
+	var stateUpdates []string
+	for _, call := range mdi.Calls {
+		if call.Method == "UpdateMessages" {
+			filter, _ := call.Arguments[2].(database.Filter).Finalize()
+			update, _ := call.Arguments[3].(database.Update).Finalize()
+			stateUpdates = append(stateUpdates, fmt.Sprintf("%s -> %s", filter.String(), update.SetOperations[1].Value))
+		}
+	}
+	assert.Len(t, stateUpdates, 2)
+	assert.True(t, strings.HasSuffix(stateUpdates[0], "-> batching"), stateUpdates[0])
+	assert.Contains(t, stateUpdates[1], "state IN ['ready','batching']")
+	assert.True(t, strings.HasSuffix(stateUpdates[1], "-> sent"), stateUpdates[1])
+}
+
+func TestRecoverBatchingMultiplePages(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	bm.recoveryEnabled = true
+	bm.readPageSize = 1
+
+	dispatched := make(chan *DispatchState, 2)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+
+	msg1 := newTestBroadcastMessage(1001)
+	msg2 := newTestBroadcastMessage(1002)
+	mockMessagePage(mdi, mdm, msg1)
+	mockMessagePage(mdi, mdm, msg2)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	assert.Equal(t, *msg1.Header.ID, *(<-dispatched).Messages[0].Header.ID)
+	assert.Equal(t, *msg2.Header.ID, *(<-dispatched).Messages[0].Header.ID)
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestRecoverBatchingFailClosed(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	bm.recoveryEnabled = true
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(nil, fmt.Errorf("pop"))
+	cancel()
+
+	err := bm.recoverBatching()
+	assert.Regexp(t, "FF00154", err)
+
+	bm.messageSequencer()
+	<-bm.done
+}
@@ -0,0 +1,89 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// RedispatchMessage re-reads a single message and its data, and dispatches it on its own in a new batch, using the
+// dispatcher registered for its type. This is for targeted recovery, where the downstream side-effect of one message
+// failed even though its batch was dispatched successfully. The dispatch is out-of-band: the batch is not persisted,
+// the offset is not affected, and the state of the message is not updated. It is attempted once, with the error from
+// the dispatch handler returned to the caller.
+func (bm *batchManager) RedispatchMessage(ctx context.Context, msgID *fftypes.UUID) error {
+	msg, data, err := bm.assembleMessageData(ctx, msgID)
+	if err != nil {
+		return err
+	}
+	processor, err := bm.getMessageProcessor(msg, 0)
+	if err != nil {
+		return err
+	}
+	if processor.conf.dispatch == nil {
+		// No-op dispatchers have no handler to dispatch to
+		return bm.newUnknownMessageTypeError(ctx, msg.Header.TxType, msg.Header.Type)
+	}
+
+	state, err := processor.initRedispatchState(msg, data)
+	if err != nil {
+		return err
+	}
+	log.L(ctx).Infof("Redispatching message %s in batch %s", msgID, state.Persisted.ID)
+	return processor.conf.dispatch(ctx, state)
+}
+
+// initRedispatchState builds the dispatch state for a new batch holding only the message, with the pins allocated when
+// the batch it was originally dispatched in was sealed
+func (bp *batchProcessor) initRedispatchState(msg *core.Message, data core.DataArray) (*DispatchState, error) {
+	state := &DispatchState{
+		Persisted: core.BatchPersisted{
+			BatchHeader: core.BatchHeader{
+				ID:        fftypes.NewUUID(),
+				Type:      bp.conf.DispatcherOptions.BatchType,
+				Namespace: bp.bm.namespace,
+				SignerRef: bp.conf.signer,
+				Group:     bp.conf.group,
+				Created:   fftypes.Now(),
+			},
+			TX: core.TransactionRef{
+				Type: bp.conf.txType,
+			},
+		},
+		Messages:   []*core.Message{msg.BatchMessage()},
+		Redispatch: true,
+		lazyData:   bp.bm.lazyDataLoader(),
+	}
+	for _, d := range data {
+		state.Data = append(state.Data, d.BatchData(state.Persisted.Type))
+	}
+	if bp.conf.txType == core.TransactionTypeBatchPin {
+		pins, err := bp.bm.rebuildPins(msg.BatchID, []*core.Message{msg})
+		if err != nil {
+			return nil, err
+		}
+		state.Pins = pins
+	}
+	manifestString := state.Persisted.GenManifest(state.Messages, state.Data).String()
+	state.Persisted.Manifest = fftypes.JSONAnyPtr(manifestString)
+	state.Persisted.Hash = fftypes.HashString(manifestString)
+	return state, nil
+}
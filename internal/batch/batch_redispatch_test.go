@@ -0,0 +1,144 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRedispatchMessage(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdm := bm.data.(*datamocks.Manager)
+	bm.readOffset = 1000
+
+	dispatched := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, BatchType: core.BatchTypeBroadcast, DisposeTimeout: time.Minute},
+	)
+
+	// The message was sent in an earlier batch
+	msg := newTestBroadcastMessage(1001)
+	msg.BatchID = fftypes.NewUUID()
+	msg.State = core.MessageStateSent
+	data := &core.Data{ID: fftypes.NewUUID(), Hash: fftypes.NewRandB32(), Value: fftypes.JSONAnyPtr(`"value"`)}
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{data}, true, nil)
+
+	err := bm.RedispatchMessage(context.Background(), msg.Header.ID)
+	assert.NoError(t, err)
+
+	// It is dispatched alone in a new batch, with nothing persisted and the offset unchanged
+	state := <-dispatched
+	assert.True(t, state.Redispatch)
+	assert.NotEqual(t, msg.BatchID, state.Persisted.ID)
+	assert.Equal(t, core.BatchTypeBroadcast, state.Persisted.Type)
+	assert.Equal(t, core.TransactionTypeBatchPin, state.Persisted.TX.Type)
+	assert.NotNil(t, state.Persisted.Hash)
+	assert.Len(t, state.Messages, 1)
+	assert.Equal(t, msg.Header.ID, state.Messages[0].Header.ID)
+	assert.Len(t, state.Data, 1)
+	assert.Equal(t, data.ID, state.Data[0].ID)
+	assert.Len(t, state.Pins, 1)
+	assert.Equal(t, core.MessageStateSent, msg.State)
+	assert.Equal(t, int64(1000), bm.readOffset)
+}
+
+func TestRedispatchMessageHandlerFail(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdm := bm.data.(*datamocks.Manager)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			return fmt.Errorf("pop")
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: time.Minute},
+	)
+	msg := newTestBroadcastMessage(1001)
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil)
+
+	err := bm.RedispatchMessage(context.Background(), msg.Header.ID)
+	assert.Regexp(t, "pop", err)
+}
+
+func TestRedispatchMessageNotFound(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdm := bm.data.(*datamocks.Manager)
+	msgID := fftypes.NewUUID()
+	mdm.On("GetMessageWithDataCached", mock.Anything, msgID).Return(nil, nil, false, nil)
+
+	err := bm.RedispatchMessage(context.Background(), msgID)
+	assert.Regexp(t, "FF10133", err)
+}
+
+func TestRedispatchMessageUnregisteredType(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdm := bm.data.(*datamocks.Manager)
+	msg := newTestBroadcastMessage(1001)
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil)
+
+	err := bm.RedispatchMessage(context.Background(), msg.Header.ID)
+	assert.Regexp(t, "FF10126", err)
+}
+
+func TestRedispatchMessageNoOpDispatcher(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdm := bm.data.(*datamocks.Manager)
+	bm.RegisterNoOpDispatcher("pending", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast}, DispatcherOptions{DisposeTimeout: time.Minute})
+	msg := newTestBroadcastMessage(1001)
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil)
+
+	err := bm.RedispatchMessage(context.Background(), msg.Header.ID)
+	assert.Regexp(t, "FF10126", err)
+}
+
+func TestRedispatchMessagePinsMissing(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdm := bm.data.(*datamocks.Manager)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypePrivate},
+		func(c context.Context, state *DispatchState) error {
+			assert.Fail(t, "dispatched without pins")
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: time.Minute},
+	)
+
+	// A private message that was never sealed into a batch has no pins to dispatch with
+	msg := newTestBroadcastMessage(1001)
+	msg.Header.Type = core.MessageTypePrivate
+	msg.Header.Group = fftypes.NewRandB32()
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil)
+
+	err := bm.RedispatchMessage(context.Background(), msg.Header.ID)
+	assert.Regexp(t, "FF10430", err)
+}
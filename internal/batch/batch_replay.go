@@ -0,0 +1,98 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+)
+
+// ReplayHandler is called with each persisted batch replayed by ReplayBatches
+type ReplayHandler func(ctx context.Context, batch *core.Batch) error
+
+// ReplayBatches reads the persisted batches matching the filter a page at a time, hydrates each with its messages
+// and data, and passes it to the handler - with up to the configured replay concurrency of handlers in flight. This
+// re-delivers batches exactly as they were sealed, without re-assembling them from messages, so the filter should
+// sort the batches for the paging to be stable. Neither the offset nor the state of any message is affected.
+// The first error from reading, hydrating or the handler stops the replay, and is returned.
+func (bm *batchManager) ReplayBatches(ctx context.Context, filter database.Filter, handler ReplayHandler) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errMux sync.Mutex
+	var firstErr error
+	fail := func(err error) {
+		errMux.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		errMux.Unlock()
+		cancel()
+	}
+	slots := make(chan struct{}, bm.replayConcurrency)
+
+	replayed := 0
+	for skip := uint64(0); ctx.Err() == nil; skip += bm.readPageSize {
+		batches, _, err := bm.database.GetBatches(ctx, bm.namespace, filter.Skip(skip).Limit(bm.readPageSize))
+		if err != nil {
+			fail(err)
+			break
+		}
+		for _, persisted := range batches {
+			batch, err := bm.data.HydrateBatch(ctx, persisted)
+			if err != nil {
+				fail(err)
+				break
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			replayed++
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				if err := handler(ctx, batch); err != nil {
+					fail(err)
+				}
+			}()
+		}
+		if uint64(len(batches)) < bm.readPageSize {
+			break
+		}
+	}
+	wg.Wait()
+
+	errMux.Lock()
+	defer errMux.Unlock()
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	log.L(ctx).Infof("Replayed %d batches (err=%v)", replayed, firstErr)
+	return firstErr
+}
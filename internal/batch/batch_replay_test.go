@@ -0,0 +1,158 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/internal/coreconfig"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestReplayBatches(count int) []*core.BatchPersisted {
+	batches := make([]*core.BatchPersisted, count)
+	for i := range batches {
+		batches[i] = &core.BatchPersisted{BatchHeader: core.BatchHeader{ID: fftypes.NewUUID()}}
+	}
+	return batches
+}
+
+func mockHydrateBatches(mdm *datamocks.Manager, batches []*core.BatchPersisted) {
+	for _, persisted := range batches {
+		mdm.On("HydrateBatch", mock.Anything, persisted).Return(&core.Batch{BatchHeader: persisted.BatchHeader}, nil).Once()
+	}
+}
+
+func TestReplayBatches(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.readPageSize = 2
+	bm.replayConcurrency = 2
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdm := bm.data.(*datamocks.Manager)
+
+	batches := newTestReplayBatches(3)
+	mdi.On("GetBatches", mock.Anything, "ns1", mock.Anything).Return(batches[0:2], nil, nil).Once()
+	mdi.On("GetBatches", mock.Anything, "ns1", mock.Anything).Return(batches[2:], nil, nil).Once()
+	mockHydrateBatches(mdm, batches)
+
+	var replayMux sync.Mutex
+	replayed := make(map[fftypes.UUID]bool)
+	fb := database.BatchQueryFactory.NewFilter(context.Background())
+	err := bm.ReplayBatches(context.Background(), fb.And().Sort("created"), func(ctx context.Context, batch *core.Batch) error {
+		replayMux.Lock()
+		defer replayMux.Unlock()
+		replayed[*batch.ID] = true
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, replayed, 3)
+	for _, persisted := range batches {
+		assert.True(t, replayed[*persisted.ID])
+	}
+
+	mdi.AssertExpectations(t)
+	mdm.AssertExpectations(t)
+	mdi.AssertNotCalled(t, "UpdateMessages", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mdi.AssertNotCalled(t, "UpdateOffset", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestReplayBatchesGetBatchesFail(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetBatches", mock.Anything, "ns1", mock.Anything).Return(nil, nil, fmt.Errorf("pop"))
+
+	fb := database.BatchQueryFactory.NewFilter(context.Background())
+	err := bm.ReplayBatches(context.Background(), fb.And(), func(ctx context.Context, batch *core.Batch) error {
+		return nil
+	})
+	assert.Regexp(t, "pop", err)
+}
+
+func TestReplayBatchesHydrateFail(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdm := bm.data.(*datamocks.Manager)
+	batches := newTestReplayBatches(1)
+	mdi.On("GetBatches", mock.Anything, "ns1", mock.Anything).Return(batches, nil, nil)
+	mdm.On("HydrateBatch", mock.Anything, batches[0]).Return(nil, fmt.Errorf("pop"))
+
+	fb := database.BatchQueryFactory.NewFilter(context.Background())
+	err := bm.ReplayBatches(context.Background(), fb.And(), func(ctx context.Context, batch *core.Batch) error {
+		assert.Fail(t, "replayed a batch that failed to hydrate")
+		return nil
+	})
+	assert.Regexp(t, "pop", err)
+}
+
+func TestReplayBatchesHandlerFailStopsReplay(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.replayConcurrency = 1
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdm := bm.data.(*datamocks.Manager)
+	batches := newTestReplayBatches(2)
+	mdi.On("GetBatches", mock.Anything, "ns1", mock.Anything).Return(batches, nil, nil).Once()
+	mockHydrateBatches(mdm, batches)
+
+	calls := 0
+	fb := database.BatchQueryFactory.NewFilter(context.Background())
+	err := bm.ReplayBatches(context.Background(), fb.And(), func(ctx context.Context, batch *core.Batch) error {
+		calls++
+		return fmt.Errorf("pop")
+	})
+	assert.Regexp(t, "pop", err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestReplayBatchesContextCancelled(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	cancelCtx()
+	fb := database.BatchQueryFactory.NewFilter(context.Background())
+	err := bm.ReplayBatches(ctx, fb.And(), func(ctx context.Context, batch *core.Batch) error {
+		return nil
+	})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestReplayConcurrencyMinimum(t *testing.T) {
+	testConfigReset()
+	config.Set(coreconfig.BatchManagerReplayConcurrency, 0)
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	assert.Equal(t, 1, bm.replayConcurrency)
+}
@@ -0,0 +1,37 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+// RetryableErrorClassifier decides whether a database error is worth retrying. Permanent failures, such as
+// a constraint violation, should return false so the error is surfaced rather than retried with backoff.
+type RetryableErrorClassifier func(err error) bool
+
+// SetRetryableError registers a classifier, consulted before each retry of persisting a batch, updating the
+// state of its messages, or committing the offset. When no classifier is registered, all errors are retried.
+func (bm *batchManager) SetRetryableError(classifier RetryableErrorClassifier) {
+	bm.retryableErrorMux.Lock()
+	defer bm.retryableErrorMux.Unlock()
+	bm.retryableError = classifier
+}
+
+// isRetryable returns whether the retry loop should re-attempt after the error
+func (bm *batchManager) isRetryable(err error) bool {
+	bm.retryableErrorMux.Lock()
+	classifier := bm.retryableError
+	bm.retryableErrorMux.Unlock()
+	return err == nil || classifier == nil || classifier(err)
+}
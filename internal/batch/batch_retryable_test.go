@@ -0,0 +1,98 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/mocks/identitymanagermocks"
+	"github.com/hyperledger/firefly/mocks/txcommonmocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var errTestPermanent = errors.New("unique constraint violated")
+
+func isTestRetryable(err error) bool {
+	return !errors.Is(err, errTestPermanent)
+}
+
+func TestIsRetryableDefault(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	assert.True(t, bm.isRetryable(fmt.Errorf("pop")))
+	assert.True(t, bm.isRetryable(errTestPermanent))
+
+	bm.SetRetryableError(isTestRetryable)
+	assert.True(t, bm.isRetryable(nil))
+	assert.True(t, bm.isRetryable(fmt.Errorf("pop")))
+	assert.False(t, bm.isRetryable(errTestPermanent))
+}
+
+func TestSealBatchPermanentErrorNotRetried(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	bp.conf.txType = core.TransactionTypeUnpinned
+	bp.bm.SetRetryableError(isTestRetryable)
+	mockRunAsGroupPassthrough(mdi)
+	bp.bm.identity.(*identitymanagermocks.Manager).On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
+	bp.txHelper.(*txcommonmocks.Helper).On("SubmitNewTransaction", mock.Anything, core.TransactionTypeUnpinned).Return(fftypes.NewUUID(), nil)
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(errTestPermanent).Once()
+
+	bp.addWork(&batchWork{msg: newTestBroadcastMessage(1001)})
+
+	err := bp.flush(false, flushTriggerSize)
+	assert.ErrorIs(t, err, errTestPermanent)
+	mdi.AssertExpectations(t)
+}
+
+func TestMarkPayloadDispatchedPermanentErrorNotRetried(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	bp.bm.SetRetryableError(isTestRetryable)
+	mockRunAsGroupPassthrough(mdi)
+	bp.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(errTestPermanent).Once()
+
+	state := &DispatchState{
+		Persisted: core.BatchPersisted{BatchHeader: core.BatchHeader{ID: fftypes.NewUUID()}},
+		Messages:  []*core.Message{newTestBroadcastMessage(1001)},
+	}
+	err := bp.markPayloadDispatched(state)
+	assert.ErrorIs(t, err, errTestPermanent)
+	mdi.AssertExpectations(t)
+}
+
+func TestCommitOffsetPermanentErrorNotRetried(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetRowID = 12345
+	bm.SetRetryableError(isTestRetryable)
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("UpdateOffset", mock.Anything, int64(12345), mock.Anything).Return(errTestPermanent).Once()
+
+	err := bm.commitOffset(bm.ctx, 20)
+	assert.ErrorIs(t, err, errTestPermanent)
+	assert.Equal(t, int64(-1), bm.committedOffset)
+	mdi.AssertExpectations(t)
+}
@@ -0,0 +1,133 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// UpdateDispatcherOptions retunes the running dispatchers of the message type, without a restart. The batch sizes,
+// timeouts and dispatch concurrency are taken from the options, and the rest of the options are kept as registered.
+// The options are validated just as they are on registration. Each processor adopts them as it starts its next
+// batch, so the open batch, and any batch in flight, is assembled and dispatched as before.
+func (bm *batchManager) UpdateDispatcherOptions(msgType core.MessageType, options DispatcherOptions) error {
+	bm.retuneMux.Lock()
+	defer bm.retuneMux.Unlock()
+
+	bm.dispatcherMux.Lock()
+	var dispatchers []*dispatcher
+	var retuned []DispatcherOptions
+	for _, d := range bm.allDispatchers {
+		if !handlesMessageType(d, msgType) {
+			continue
+		}
+		updated := d.options
+		updated.BatchMaxSize = options.BatchMaxSize
+		updated.BatchMaxBytes = options.BatchMaxBytes
+		updated.BatchTimeout = options.BatchTimeout
+		updated.BatchMaxAge = options.BatchMaxAge
+		updated.BatchMinSize = options.BatchMinSize
+		updated.DisposeTimeout = options.DisposeTimeout
+		updated.DispatchConcurrency = options.DispatchConcurrency
+		updated.PriorityBatchMaxSize = options.PriorityBatchMaxSize
+		updated.PriorityBatchTimeout = options.PriorityBatchTimeout
+		bm.applyDefaultOptions(&updated)
+		if err := validateDispatcherOptions(bm.ctx, d.name, d.txType, d.msgTypes, &updated); err != nil {
+			bm.dispatcherMux.Unlock()
+			return err
+		}
+		dispatchers = append(dispatchers, d)
+		retuned = append(retuned, updated)
+	}
+	if len(dispatchers) == 0 {
+		bm.dispatcherMux.Unlock()
+		return i18n.NewError(bm.ctx, coremsgs.MsgUnregisteredBatchType, msgType)
+	}
+	for i, d := range dispatchers {
+		// Only the tuned fields are written, as the other options are read without the lock during dispatch
+		o := &d.options
+		o.BatchMaxSize = retuned[i].BatchMaxSize
+		o.BatchMaxBytes = retuned[i].BatchMaxBytes
+		o.BatchTimeout = retuned[i].BatchTimeout
+		o.BatchMaxAge = retuned[i].BatchMaxAge
+		o.BatchMinSize = retuned[i].BatchMinSize
+		o.DisposeTimeout = retuned[i].DisposeTimeout
+		o.DispatchConcurrency = retuned[i].DispatchConcurrency
+		o.PriorityBatchMaxSize = retuned[i].PriorityBatchMaxSize
+		o.PriorityBatchTimeout = retuned[i].PriorityBatchTimeout
+		for _, processor := range d.processors {
+			processor.retune(processorOptions(d, processor.conf.highPriority))
+		}
+		log.L(bm.ctx).Infof("Retuned dispatcher '%s': batchMaxSize=%d batchMaxBytes=%d batchTimeout=%s dispatchConcurrency=%d",
+			d.name, o.BatchMaxSize, o.BatchMaxBytes, o.BatchTimeout, o.DispatchConcurrency)
+	}
+	bm.dispatcherMux.Unlock()
+
+	if bm.persistDispatcherOptions {
+		for _, d := range dispatchers {
+			if err := bm.checkDispatcherOptionsFor(d); err != nil {
+				log.L(bm.ctx).Warnf("Failed to persist options for dispatcher '%s': %s", d.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func handlesMessageType(d *dispatcher, msgType core.MessageType) bool {
+	for _, t := range d.msgTypes {
+		if t == msgType {
+			return true
+		}
+	}
+	return false
+}
+
+// retune records the options for the processor to adopt when it starts its next batch
+func (bp *batchProcessor) retune(options DispatcherOptions) {
+	bp.statusMux.Lock()
+	defer bp.statusMux.Unlock()
+	bp.retuned = &options
+}
+
+// applyRetune adopts any options the processor has been retuned with, as it cycles to the next assembly or the first
+// work arrives in an empty one. It is only called on the assembly loop, holding the statusMux, so the options do not
+// change while a batch is assembled.
+func (bp *batchProcessor) applyRetune() {
+	options := bp.retuned
+	if options == nil {
+		return
+	}
+	bp.retuned = nil
+	bp.conf.BatchMaxSize = options.BatchMaxSize
+	bp.conf.BatchMaxBytes = options.BatchMaxBytes
+	bp.conf.BatchTimeout = options.BatchTimeout
+	bp.conf.BatchMaxAge = options.BatchMaxAge
+	bp.conf.BatchMinSize = options.BatchMinSize
+	bp.conf.DisposeTimeout = options.DisposeTimeout
+	if options.DispatchConcurrency != bp.conf.DispatchConcurrency {
+		// Workers dispatching earlier batches release the slots they hold in the previous channel
+		bp.conf.DispatchConcurrency = options.DispatchConcurrency
+		bp.dispatchSlots = nil
+		if options.DispatchConcurrency > 1 {
+			bp.dispatchSlots = make(chan struct{}, options.DispatchConcurrency)
+		}
+	}
+	log.L(bp.ctx).Infof("Batch processor retuned: batchMaxSize=%d batchMaxBytes=%d batchTimeout=%s", bp.conf.BatchMaxSize, bp.conf.BatchMaxBytes, bp.conf.BatchTimeout)
+}
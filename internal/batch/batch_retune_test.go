@@ -0,0 +1,206 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func registerRetuneTestDispatcher(bm *batchManager, handler DispatchHandler) {
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		handler,
+		DispatcherOptions{
+			BatchMaxSize:         10,
+			BatchMaxBytes:        1024 * 1024,
+			BatchTimeout:         time.Minute,
+			DisposeTimeout:       time.Hour,
+			PriorityBatchMaxSize: 2,
+			PriorityBatchTimeout: time.Millisecond,
+		},
+	)
+}
+
+func TestUpdateDispatcherOptionsNextBatch(t *testing.T) {
+	bm, _, _, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	clock := newFakeClock()
+	bm.SetClock(clock)
+
+	dispatched := make(chan *DispatchState, 2)
+	registerRetuneTestDispatcher(bm, func(c context.Context, state *DispatchState) error {
+		dispatched <- state
+		return nil
+	})
+
+	msg1 := newTestBroadcastMessage(1001)
+	processor, err := bm.getProcessor(msg1.Header.TxType, msg1.Header.Type, msg1.Header.Group, &msg1.Header.SignerRef, 0, "")
+	assert.NoError(t, err)
+	bm.dispatchMessage(bm.ctx, &pendingDispatch{processor: processor, msg: msg1})
+	assert.Eventually(t, func() bool { return clock.hasTimer(time.Minute) }, 5*time.Second, time.Millisecond)
+
+	err = bm.UpdateDispatcherOptions(core.MessageTypeBroadcast, DispatcherOptions{
+		BatchMaxSize:  5,
+		BatchMaxBytes: 64 * 1024,
+		BatchTimeout:  time.Second,
+	})
+	assert.NoError(t, err)
+
+	// The open batch keeps the timeout it was opened with
+	assert.Equal(t, time.Minute, time.Duration(processor.openBatchTimer().TimeoutRemaining))
+	clock.Advance(time.Second)
+	select {
+	case <-dispatched:
+		assert.Fail(t, "dispatched at the retuned timeout")
+	default:
+	}
+	clock.Advance(time.Minute - time.Second)
+	state := <-dispatched
+	assert.Equal(t, msg1.Header.ID, state.Messages[0].Header.ID)
+
+	// The next batch is assembled with the retuned options
+	msg2 := newTestBroadcastMessage(1002)
+	bm.dispatchMessage(bm.ctx, &pendingDispatch{processor: processor, msg: msg2})
+	assert.Eventually(t, func() bool { return clock.hasTimer(time.Second) }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, uint(5), processor.maxBatchSize())
+	clock.Advance(time.Second)
+	state = <-dispatched
+	assert.Equal(t, msg2.Header.ID, state.Messages[0].Header.ID)
+
+	// The dispatcher keeps the options it was not retuned with, and defaults those that were not set
+	options := bm.Dispatchers()[core.MessageTypeBroadcast][0]
+	assert.Equal(t, uint(5), options.BatchMaxSize)
+	assert.Equal(t, int64(64*1024), options.BatchMaxBytes)
+	assert.Equal(t, time.Second, options.BatchTimeout)
+	assert.Equal(t, defaultDisposeTimeout, options.DisposeTimeout)
+	assert.Equal(t, uint(0), options.PriorityBatchMaxSize)
+}
+
+func TestUpdateDispatcherOptionsPriorityProcessor(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	registerRetuneTestDispatcher(bm, func(c context.Context, state *DispatchState) error { return nil })
+
+	signer := &core.SignerRef{Author: "did:firefly:org/abcd"}
+	normalProcessor, err := bm.getProcessor(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, nil, signer, 0, "")
+	assert.NoError(t, err)
+	priorityProcessor, err := bm.getProcessor(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, nil, signer, 0, core.MessagePriorityHigh)
+	assert.NoError(t, err)
+
+	err = bm.UpdateDispatcherOptions(core.MessageTypeBroadcast, DispatcherOptions{
+		BatchMaxSize:         50,
+		BatchTimeout:         time.Second,
+		DispatchConcurrency:  3,
+		PriorityBatchMaxSize: 4,
+		PriorityBatchTimeout: 5 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+
+	normalProcessor.statusMux.Lock()
+	assert.Equal(t, uint(50), normalProcessor.retuned.BatchMaxSize)
+	assert.Equal(t, time.Second, normalProcessor.retuned.BatchTimeout)
+	normalProcessor.statusMux.Unlock()
+	priorityProcessor.statusMux.Lock()
+	assert.Equal(t, uint(4), priorityProcessor.retuned.BatchMaxSize)
+	assert.Equal(t, 5*time.Millisecond, priorityProcessor.retuned.BatchTimeout)
+	priorityProcessor.statusMux.Unlock()
+
+	// A processor created after the update has the retuned options from the start
+	newProcessor, err := bm.getProcessor(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, nil, &core.SignerRef{Author: "did:firefly:org/efgh"}, 0, "")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(50), newProcessor.conf.BatchMaxSize)
+	assert.Equal(t, 3, cap(newProcessor.dispatchSlots))
+}
+
+func TestUpdateDispatcherOptionsInvalid(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	registerRetuneTestDispatcher(bm, func(c context.Context, state *DispatchState) error { return nil })
+
+	err := bm.UpdateDispatcherOptions(core.MessageTypeBroadcast, DispatcherOptions{BatchMaxSize: 0})
+	assert.Regexp(t, "FF10441.*utdispatcher", err)
+	err = bm.UpdateDispatcherOptions(core.MessageTypeBroadcast, DispatcherOptions{BatchMaxSize: 1, BatchTimeout: -1})
+	assert.Regexp(t, "FF10442.*BatchTimeout", err)
+
+	options := bm.Dispatchers()[core.MessageTypeBroadcast][0]
+	assert.Equal(t, uint(10), options.BatchMaxSize)
+	assert.Equal(t, time.Minute, options.BatchTimeout)
+}
+
+func TestUpdateDispatcherOptionsUnknownType(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	registerRetuneTestDispatcher(bm, func(c context.Context, state *DispatchState) error { return nil })
+
+	err := bm.UpdateDispatcherOptions(core.MessageTypeGroupInit, DispatcherOptions{BatchMaxSize: 1})
+	assert.Regexp(t, "FF10126.*groupinit", err)
+}
+
+func TestUpdateDispatcherOptionsPersisted(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.persistDispatcherOptions = true
+	registerRetuneTestDispatcher(bm, func(c context.Context, state *DispatchState) error { return nil })
+
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetDispatcherOptions", mock.Anything, "ns1", "utdispatcher").Return(nil, nil)
+	mdi.On("UpsertDispatcherOptions", mock.Anything, mock.MatchedBy(func(record *core.DispatcherOptionsRecord) bool {
+		return record.Dispatcher == "utdispatcher" && record.Options.JSONObject().GetInt64("batchMaxSize") == 20
+	})).Return(nil)
+
+	err := bm.UpdateDispatcherOptions(core.MessageTypeBroadcast, DispatcherOptions{BatchMaxSize: 20})
+	assert.NoError(t, err)
+	mdi.AssertExpectations(t)
+}
+
+func TestApplyRetune(t *testing.T) {
+	slots := make(chan struct{}, 2)
+	bp := &batchProcessor{
+		ctx: context.Background(),
+		conf: &batchProcessorConf{
+			DispatcherOptions: DispatcherOptions{BatchMaxSize: 10, DispatchConcurrency: 2},
+		},
+		dispatchSlots: slots,
+	}
+
+	bp.statusMux.Lock()
+	bp.applyRetune()
+	bp.statusMux.Unlock()
+	assert.Equal(t, uint(10), bp.conf.BatchMaxSize)
+
+	bp.retune(DispatcherOptions{BatchMaxSize: 3, BatchMaxBytes: 2048, BatchTimeout: time.Second, DisposeTimeout: time.Minute, DispatchConcurrency: 2})
+	bp.statusMux.Lock()
+	bp.applyRetune()
+	bp.statusMux.Unlock()
+	assert.Equal(t, uint(3), bp.conf.BatchMaxSize)
+	assert.Equal(t, int64(2048), bp.conf.BatchMaxBytes)
+	assert.Equal(t, time.Minute, bp.conf.DisposeTimeout)
+	assert.Nil(t, bp.retuned)
+	assert.Equal(t, slots, bp.dispatchSlots)
+
+	bp.retune(DispatcherOptions{BatchMaxSize: 3, DispatchConcurrency: 1})
+	bp.statusMux.Lock()
+	bp.applyRetune()
+	bp.statusMux.Unlock()
+	assert.Nil(t, bp.dispatchSlots)
+}
@@ -0,0 +1,120 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+	"github.com/hyperledger/firefly/pkg/database"
+)
+
+type rewindRequest struct {
+	sequence int64
+	done     chan error
+}
+
+// Rewind resets the batch manager to read again from just after the given sequence, for example to re-dispatch
+// a range of messages after fixing a downstream problem. The rewind is applied by the sequencer between pages,
+// so it never interrupts a page part way through - including while the manager is paused. The persisted offset
+// is reset along with the in-memory one. Only messages in ready state are read, so any that have already been
+// dispatched must be returned to that state to be dispatched again. Messages that are still in-flight in an
+// open batch are not dispatched twice.
+func (bm *batchManager) Rewind(ctx context.Context, toSequence int64) error {
+	if toSequence < -1 {
+		return i18n.NewError(ctx, coremsgs.MsgInvalidRewindSequence, toSequence)
+	}
+	req := &rewindRequest{
+		sequence: toSequence,
+		done:     make(chan error, 1),
+	}
+	select {
+	case bm.rewinds <- req:
+	case <-bm.done:
+		return i18n.NewError(ctx, coremsgs.MsgContextCanceled)
+	case <-ctx.Done():
+		return i18n.NewError(ctx, coremsgs.MsgContextCanceled)
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return i18n.NewError(ctx, coremsgs.MsgContextCanceled)
+	}
+}
+
+// checkRewind applies a rewind that has been requested, without blocking if there is none
+func (bm *batchManager) checkRewind() {
+	select {
+	case req := <-bm.rewinds:
+		bm.applyRewind(req)
+	default:
+	}
+}
+
+// applyRewind is called on the sequencer goroutine between pages, to reset the offset to the requested sequence
+func (bm *batchManager) applyRewind(req *rewindRequest) {
+	if bm.offsetEnabled {
+		if err := bm.resetOffset(req.sequence); err != nil {
+			req.done <- err
+			return
+		}
+	}
+
+	bm.readOffset = req.sequence
+	bm.inflightMux.Lock()
+	bm.rereadFrom(req.sequence)
+	bm.highestReadOffset = req.sequence
+	bm.inflightMux.Unlock()
+	log.L(bm.ctx).Infof("Batch manager rewound to sequence %d", req.sequence)
+	req.done <- nil
+}
+
+// rereadFrom is called when the read offset is rewound, to release the messages deferred after it - as they are read
+// again, and deferred again if they still cannot be dispatched. Until the sequencer has read back up to where it was,
+// the committable offset is held at the read offset. Must be called holding the inflightMux.
+func (bm *batchManager) rereadFrom(offset int64) {
+	for seq := range bm.deferredSequences {
+		if seq > offset {
+			delete(bm.deferredSequences, seq)
+		}
+	}
+	if offset < bm.highestReadOffset && (bm.rereadOffset < 0 || offset < bm.rereadOffset) {
+		bm.rereadOffset = offset
+	}
+}
+
+// resetOffset writes the offset to the DB, even if that moves it backwards - unlike a commit. Any commit still
+// pending for a later offset is discarded.
+func (bm *batchManager) resetOffset(offset int64) error {
+	bm.offsetMux.Lock()
+	bm.pendingOffset = offset
+	bm.offsetMux.Unlock()
+
+	bm.offsetCommitMux.Lock()
+	defer bm.offsetCommitMux.Unlock()
+	return bm.retry.Do(bm.ctx, "rewind offset", func(attempt int) (retry bool, err error) {
+		u := database.OffsetQueryFactory.NewUpdate(bm.ctx).Set("current", offset)
+		if err = bm.database.UpdateOffset(bm.ctx, bm.offsetRowID, u); err != nil {
+			return bm.isRetryable(err), err
+		}
+		bm.committedOffset = offset
+		return false, nil
+	})
+}
@@ -0,0 +1,207 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRewindResetsOffsets(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetEnabled = true
+	bm.offsetRowID = 12345
+	mdi := bm.database.(*databasemocks.Plugin)
+	committed := mockOffsetUpdates(mdi)
+	bm.readOffset = 100
+	bm.markRead(100)
+	bm.checkpointOffset()
+	assert.Equal(t, []int64{100}, committed())
+
+	rewound := make(chan error)
+	go func() {
+		rewound <- bm.Rewind(context.Background(), 50)
+	}()
+	bm.applyRewind(<-bm.rewinds)
+	assert.NoError(t, <-rewound)
+
+	assert.Equal(t, []int64{100, 50}, committed())
+	assert.Equal(t, int64(50), bm.readOffset)
+	assert.Equal(t, int64(50), bm.highestReadOffset)
+	assert.Equal(t, int64(50), bm.committedOffset)
+	assert.Equal(t, int64(50), bm.getPendingOffset())
+
+	// Progress is committed from the rewound offset
+	bm.markRead(60)
+	bm.checkpointOffset()
+	assert.Equal(t, []int64{100, 50, 60}, committed())
+}
+
+func TestRewindInvalidSequence(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	err := bm.Rewind(context.Background(), -2)
+	assert.Regexp(t, "FF10435", err)
+}
+
+func TestRewindOffsetFail(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.offsetEnabled = true
+	bm.offsetRowID = 12345
+	bm.readOffset = 100
+	bm.SetRetryableError(isTestRetryable)
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("UpdateOffset", mock.Anything, int64(12345), mock.Anything).Return(errTestPermanent).Once()
+
+	req := &rewindRequest{sequence: 50, done: make(chan error, 1)}
+	bm.applyRewind(req)
+	assert.ErrorIs(t, <-req.done, errTestPermanent)
+	assert.Equal(t, int64(100), bm.readOffset)
+	mdi.AssertExpectations(t)
+}
+
+func TestRewindContextCancelled(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	cancelCtx()
+	err := bm.Rewind(ctx, 50)
+	assert.Regexp(t, "FF00154", err)
+}
+
+func TestRewindCancelledWaitingForSequencer(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	rewound := make(chan error)
+	go func() {
+		rewound <- bm.Rewind(ctx, 50)
+	}()
+	req := <-bm.rewinds
+	cancelCtx()
+	assert.Regexp(t, "FF00154", <-rewound)
+	assert.Equal(t, int64(50), req.sequence)
+}
+
+func TestRewindSequencerStopped(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	close(bm.done)
+	err := bm.Rewind(context.Background(), 50)
+	assert.Regexp(t, "FF00154", err)
+}
+
+func TestRewindWhileWaitingForNewMessages(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.readOffset = 100
+	bm.messagePollTimeout = time.Minute
+
+	woken := make(chan bool)
+	go func() {
+		woken <- bm.waitForNewMessages()
+	}()
+	assert.NoError(t, bm.Rewind(context.Background(), 50))
+	assert.False(t, <-woken)
+	assert.Equal(t, int64(50), bm.readOffset)
+}
+
+func TestRewindWhilePaused(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.readOffset = 100
+	bm.setPaused(true)
+
+	woken := make(chan bool)
+	go func() {
+		woken <- bm.waitWhilePaused()
+	}()
+	assert.NoError(t, bm.Rewind(context.Background(), 50))
+	assert.False(t, <-woken)
+	assert.Equal(t, int64(50), bm.readOffset)
+	assert.True(t, bm.isPaused())
+}
+
+func TestCheckRewindBetweenPages(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.readOffset = 100
+
+	// No-op with no rewind requested
+	bm.checkRewind()
+	assert.Equal(t, int64(100), bm.readOffset)
+
+	rewound := make(chan error)
+	go func() {
+		rewound <- bm.Rewind(context.Background(), -1)
+	}()
+	for bm.readOffset != -1 {
+		bm.checkRewind()
+	}
+	assert.NoError(t, <-rewound)
+}
+
+func TestRewindHoldsOffsetUntilReadAgain(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	bm.readOffset = 1005
+	bm.markRead(1005)
+	bm.deferForRecheck(1001, bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast), time.Hour)
+	bm.deferredSequences[1003] = bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast)
+	assert.Equal(t, int64(1000), bm.calcCommittableOffset())
+
+	// The deferred messages are released when the rewind is applied, but the offset holds at the read offset
+	bm.newMessageNotification(1001)
+	bm.popRewind()
+	assert.Empty(t, bm.deferredSequences)
+	assert.Equal(t, int64(1000), bm.calcCommittableOffset())
+
+	// It advances as the messages are read again, and is released once the sequencer reads back to where it was
+	bm.markRead(1002)
+	assert.Equal(t, int64(1002), bm.calcCommittableOffset())
+	bm.markRead(1005)
+	assert.Equal(t, int64(-1), bm.rereadOffset)
+	assert.Equal(t, int64(1005), bm.calcCommittableOffset())
+}
+
+func TestRewindToEmptyPageReleasesOffset(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	bm.readOffset = 1001
+	bm.markRead(1001)
+	bm.deferForRecheck(1001, bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast), time.Hour)
+
+	// The deferred message is no longer ready when it is read again, so the offset moves past it
+	bm.newMessageNotification(1001)
+	_, err := bm.processPage(bm.ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), bm.readOffset)
+	assert.Equal(t, int64(1001), bm.calcCommittableOffset())
+}
@@ -0,0 +1,164 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+)
+
+const (
+	// dispatchPolicyFIFO grants dispatch to ready batches in the order they were sealed
+	dispatchPolicyFIFO = "fifo"
+	// dispatchPolicyRoundRobin grants dispatch to each grouping key with a ready batch in turn
+	dispatchPolicyRoundRobin = "roundRobin"
+	// dispatchPolicyWeighted is round-robin, but each key is granted up to its weight of dispatches per turn
+	dispatchPolicyWeighted = "weighted"
+)
+
+type schedulerWaiter struct {
+	key     string
+	weight  int
+	granted chan struct{}
+}
+
+// dispatchScheduler limits the number of batches dispatched concurrently, and decides which of the processors
+// waiting with a ready batch is granted the next free slot - so that when many grouping keys each have a ready
+// batch, keys are not starved by whichever happened to seal first.
+type dispatchScheduler struct {
+	mux       sync.Mutex
+	policy    string
+	available int
+	waiting   []*schedulerWaiter
+	keys      []string // each key in the order first seen, which is the round-robin order
+	turn      int      // index in keys of the key whose turn it is - wrapping to the start when it passes the end
+	turnCount int      // dispatches granted to the current key in this turn
+}
+
+func newDispatchScheduler(concurrency int, policy string) *dispatchScheduler {
+	return &dispatchScheduler{
+		policy:    policy,
+		available: concurrency,
+	}
+}
+
+// acquire blocks until a dispatch slot is granted to the key, or the context is closed
+func (ds *dispatchScheduler) acquire(ctx context.Context, key string, weight int) error {
+	ds.mux.Lock()
+	ds.trackKey(key)
+	if ds.available > 0 && len(ds.waiting) == 0 {
+		ds.available--
+		ds.served(key, weight)
+		ds.mux.Unlock()
+		return nil
+	}
+	w := &schedulerWaiter{key: key, weight: weight, granted: make(chan struct{})}
+	ds.waiting = append(ds.waiting, w)
+	ds.mux.Unlock()
+
+	select {
+	case <-w.granted:
+		return nil
+	case <-ctx.Done():
+		ds.mux.Lock()
+		defer ds.mux.Unlock()
+		for i, waiter := range ds.waiting {
+			if waiter == w {
+				ds.waiting = append(ds.waiting[:i], ds.waiting[i+1:]...)
+				return i18n.NewError(ctx, coremsgs.MsgContextCanceled)
+			}
+		}
+		// We were granted the slot as we closed, so pass it on
+		ds.grantNext()
+		return i18n.NewError(ctx, coremsgs.MsgContextCanceled)
+	}
+}
+
+// release returns a dispatch slot, granting it to the next waiter according to the policy
+func (ds *dispatchScheduler) release() {
+	ds.mux.Lock()
+	defer ds.mux.Unlock()
+	ds.grantNext()
+}
+
+func (ds *dispatchScheduler) trackKey(key string) {
+	for _, k := range ds.keys {
+		if k == key {
+			return
+		}
+	}
+	ds.keys = append(ds.keys, key)
+}
+
+// grantNext must be called holding the mutex, with a slot to give away
+func (ds *dispatchScheduler) grantNext() {
+	if len(ds.waiting) == 0 {
+		ds.available++
+		return
+	}
+	idx := 0
+	if ds.policy != dispatchPolicyFIFO {
+		idx = ds.nextInTurn()
+	}
+	w := ds.waiting[idx]
+	ds.waiting = append(ds.waiting[:idx], ds.waiting[idx+1:]...)
+	close(w.granted)
+}
+
+// nextInTurn returns the index of the oldest waiter for the next key, from the one whose turn it is, that has a waiter
+func (ds *dispatchScheduler) nextInTurn() int {
+	for i := 0; i < len(ds.keys); i++ {
+		key := ds.keys[(ds.turn+i)%len(ds.keys)]
+		for idx, w := range ds.waiting {
+			if w.key == key {
+				ds.served(w.key, w.weight)
+				return idx
+			}
+		}
+	}
+	return 0
+}
+
+// served records a dispatch granted to a key, moving the turn on to the next key once the key has had
+// its weight of dispatches in this turn (always one for round-robin)
+func (ds *dispatchScheduler) served(key string, weight int) {
+	if ds.policy == dispatchPolicyFIFO {
+		return
+	}
+	if ds.policy != dispatchPolicyWeighted || weight < 1 {
+		weight = 1
+	}
+	idx := 0
+	for i, k := range ds.keys {
+		if k == key {
+			idx = i
+		}
+	}
+	if idx != ds.turn%len(ds.keys) {
+		ds.turn = idx
+		ds.turnCount = 0
+	}
+	ds.turnCount++
+	if ds.turnCount >= weight {
+		// We do not wrap here, as keys seen for the first time are appended after this one
+		ds.turn = idx + 1
+		ds.turnCount = 0
+	}
+}
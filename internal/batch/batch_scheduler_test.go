@@ -0,0 +1,121 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scheduleWaiters holds the only slot for the first key, then queues the remaining keys in order, and returns
+// the keys in the order they are granted the slot as it is released
+func scheduleWaiters(t *testing.T, ds *dispatchScheduler, weights map[string]int, keys ...string) []string {
+	err := ds.acquire(context.Background(), keys[0], weights[keys[0]])
+	assert.NoError(t, err)
+
+	granted := make(chan string)
+	for i, key := range keys[1:] {
+		go func(key string) {
+			err := ds.acquire(context.Background(), key, weights[key])
+			assert.NoError(t, err)
+			granted <- key
+		}(key)
+		assert.Eventually(t, func() bool {
+			ds.mux.Lock()
+			defer ds.mux.Unlock()
+			return len(ds.waiting) == i+1
+		}, 5*time.Second, time.Millisecond)
+	}
+
+	order := make([]string, 0, len(keys)-1)
+	for range keys[1:] {
+		ds.release()
+		order = append(order, <-granted)
+	}
+	ds.release()
+	assert.Equal(t, 1, ds.available)
+	return order
+}
+
+func TestDispatchSchedulerRoundRobin(t *testing.T) {
+	ds := newDispatchScheduler(1, dispatchPolicyRoundRobin)
+	order := scheduleWaiters(t, ds, nil, "a", "a", "a", "b", "b", "c", "c")
+	assert.Equal(t, []string{"b", "c", "a", "b", "c", "a"}, order)
+}
+
+func TestDispatchSchedulerFIFO(t *testing.T) {
+	ds := newDispatchScheduler(1, dispatchPolicyFIFO)
+	order := scheduleWaiters(t, ds, nil, "a", "a", "a", "b", "b", "c", "c")
+	assert.Equal(t, []string{"a", "a", "b", "b", "c", "c"}, order)
+}
+
+func TestDispatchSchedulerWeighted(t *testing.T) {
+	ds := newDispatchScheduler(1, dispatchPolicyWeighted)
+	order := scheduleWaiters(t, ds, map[string]int{"a": 2}, "a", "a", "a", "b", "b", "c", "c")
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, order)
+}
+
+func TestDispatchSchedulerAcquireContextClosed(t *testing.T) {
+	ds := newDispatchScheduler(1, dispatchPolicyRoundRobin)
+	err := ds.acquire(context.Background(), "a", 1)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = ds.acquire(ctx, "b", 1)
+	assert.Regexp(t, "FF00154", err)
+	assert.Empty(t, ds.waiting)
+
+	ds.release()
+	assert.Equal(t, 1, ds.available)
+}
+
+func TestDispatchLimitedByScheduler(t *testing.T) {
+	cancel, _, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		return nil
+	})
+	defer cancel()
+	bp.bm.scheduler = newDispatchScheduler(1, dispatchPolicyWeighted)
+	bp.conf.DispatchWeight = func(key string) int { return 3 }
+	assert.Equal(t, 3, bp.dispatchWeight())
+
+	// Dispatch waits for the slot
+	err := bp.bm.scheduler.acquire(context.Background(), "other", 1)
+	assert.NoError(t, err)
+	dispatched := make(chan error)
+	go func() {
+		dispatched <- bp.dispatchBatch(&DispatchState{})
+	}()
+	select {
+	case <-dispatched:
+		assert.Fail(t, "dispatched without a slot")
+	case <-time.After(10 * time.Millisecond):
+	}
+	bp.bm.scheduler.release()
+	assert.NoError(t, <-dispatched)
+	assert.Equal(t, 1, bp.bm.scheduler.available)
+
+	// Closing while waiting for a slot fails the dispatch
+	err = bp.bm.scheduler.acquire(context.Background(), "other", 1)
+	assert.NoError(t, err)
+	bp.cancelCtx()
+	err = bp.dispatchBatch(&DispatchState{})
+	assert.Regexp(t, "FF00154", err)
+}
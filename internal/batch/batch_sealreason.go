@@ -0,0 +1,46 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import "github.com/hyperledger/firefly/pkg/core"
+
+// fullTrigger distinguishes a batch that is full by its number of messages from one that is full by its size in
+// bytes. A batch that overflowed can only have done so on bytes.
+func (bp *batchProcessor) fullTrigger(overflow bool) flushTrigger {
+	if !overflow && len(bp.assemblyQueue) >= int(bp.maxBatchSize()) {
+		return flushTriggerSize
+	}
+	return flushTriggerBytes
+}
+
+// sealReason is the reason recorded on the batch for the flush, so analytics can correlate it with latency
+func (t flushTrigger) sealReason() core.BatchSealReason {
+	switch t {
+	case flushTriggerSize, flushTriggerMinSize:
+		return core.BatchSealReasonSize
+	case flushTriggerBytes:
+		return core.BatchSealReasonBytes
+	case flushTriggerTimeout:
+		return core.BatchSealReasonTimeout
+	case flushTriggerMaxAge:
+		return core.BatchSealReasonAge
+	case flushTriggerSeal:
+		return core.BatchSealReasonFlush
+	default:
+		return core.BatchSealReasonShutdown
+	}
+}
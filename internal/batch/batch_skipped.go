@@ -0,0 +1,46 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+)
+
+// finalizeSkipped moves a message the sequencer has skipped out of ready, into the given final state - so it is not
+// left among the messages waiting to be batched, to be read again after every rewind. Only a message still in a
+// readable state is updated. The update is retried until it succeeds or the context closes, in which case the message
+// is left ready, to be skipped again when it is next read.
+func (bm *batchManager) finalizeSkipped(msg *core.Message, state core.MessageState) {
+	fb := database.MessageQueryFactory.NewFilter(bm.ctx)
+	filter := fb.And(
+		fb.Eq("id", msg.Header.ID),
+		fb.In("state", readableMessageStates),
+	)
+	update := database.MessageQueryFactory.NewUpdate(bm.ctx).Set("state", state)
+	err := bm.retry.Do(bm.ctx, "finalize skipped message", func(attempt int) (retry bool, err error) {
+		err = bm.database.UpdateMessages(bm.ctx, bm.namespace, filter, update)
+		return bm.isRetryable(err), err
+	})
+	if err != nil {
+		log.L(bm.ctx).Warnf("Failed to move skipped message %s (seq=%d) to state %s: %s", msg.Header.ID, msg.Sequence, state, err)
+		return
+	}
+	msg.State = state
+	bm.data.UpdateMessageIfCached(bm.ctx, msg)
+}
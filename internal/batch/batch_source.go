@@ -0,0 +1,76 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+)
+
+// MessagePage is a request to a MessageSource for a page of messages that are ready to be batched
+type MessagePage struct {
+	// AfterSequence is the sequence the page starts after
+	AfterSequence int64
+	// Limit is the maximum number of messages in the page
+	Limit uint64
+	// TxType restricts the page to messages of a transaction type, if set
+	TxType core.TransactionType
+	// MessageTypes restricts the page to messages of the given types, if set
+	MessageTypes []core.MessageType
+}
+
+// MessageSource supplies the sequencer with the messages to assemble into batches, so the batch manager can be
+// driven by a source other than the database - such as an in-memory queue, or a replay file - through the same
+// assembly and dispatch pipeline. The messages and their data are still retrieved through the data manager.
+type MessageSource interface {
+	// ReadPage returns the messages matching the page, in ascending sequence order
+	ReadPage(ctx context.Context, page *MessagePage) ([]*core.IDAndSequence, error)
+}
+
+// dbMessageSource is the default MessageSource, reading the messages of the namespace that are ready to be batched
+type dbMessageSource struct {
+	database  database.Plugin
+	namespace string
+}
+
+func (s *dbMessageSource) ReadPage(ctx context.Context, page *MessagePage) ([]*core.IDAndSequence, error) {
+	fb := database.MessageQueryFactory.NewFilterLimit(ctx, page.Limit)
+	conditions := []database.Filter{
+		fb.Gt("sequence", page.AfterSequence),
+		fb.In("state", readableMessageStates),
+	}
+	if page.TxType != "" {
+		conditions = append(conditions, fb.Eq("txtype", page.TxType))
+	}
+	if len(page.MessageTypes) > 0 {
+		msgTypes := make([]driver.Value, len(page.MessageTypes))
+		for i, msgType := range page.MessageTypes {
+			msgTypes[i] = msgType
+		}
+		conditions = append(conditions, fb.In("type", msgTypes))
+	}
+	return s.database.GetMessageIDs(ctx, s.namespace, fb.And(conditions...).Sort("sequence").Limit(page.Limit))
+}
+
+// SetMessageSource replaces the database as the source of the messages the sequencer reads. It must be called
+// before Start.
+func (bm *batchManager) SetMessageSource(source MessageSource) {
+	bm.messageSource = source
+}
@@ -0,0 +1,141 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// memoryMessageSource is an in-memory queue of messages, in sequence order
+type memoryMessageSource struct {
+	mux  sync.Mutex
+	msgs []*core.Message
+}
+
+func (s *memoryMessageSource) ReadPage(ctx context.Context, page *MessagePage) ([]*core.IDAndSequence, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	ids := []*core.IDAndSequence{}
+	for _, msg := range s.msgs {
+		if uint64(len(ids)) >= page.Limit {
+			break
+		}
+		if msg.Sequence <= page.AfterSequence || (page.TxType != "" && msg.Header.TxType != page.TxType) {
+			continue
+		}
+		matched := len(page.MessageTypes) == 0
+		for _, msgType := range page.MessageTypes {
+			matched = matched || msg.Header.Type == msgType
+		}
+		if matched {
+			ids = append(ids, &core.IDAndSequence{ID: *msg.Header.ID, Sequence: msg.Sequence})
+		}
+	}
+	return ids, nil
+}
+
+func TestMessageSourceDrivesDispatch(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	dispatched := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 2, BatchMaxBytes: 1024 * 1024, BatchTimeout: time.Minute, DisposeTimeout: 120 * time.Second},
+	)
+	msg1 := newTestBroadcastMessage(1001)
+	msg2 := newTestBroadcastMessage(1002)
+	source := &memoryMessageSource{msgs: []*core.Message{msg1, msg2}}
+	for _, msg := range source.msgs {
+		mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil)
+	}
+	bm.SetMessageSource(source)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	state := <-dispatched
+	assert.Len(t, state.Messages, 2)
+	assert.Equal(t, msg1.Header.ID, state.Messages[0].Header.ID)
+	assert.Equal(t, msg2.Header.ID, state.Messages[1].Header.ID)
+	mdi.AssertNotCalled(t, "GetMessageIDs", mock.Anything, mock.Anything, mock.Anything)
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestMessageSourceDispatcherPages(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.readOffset = 1000
+	bm.RegisterNoOpDispatcher("broadcast", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast}, DispatcherOptions{BatchMaxSize: 1, ReadPageSize: 1})
+	bm.RegisterNoOpDispatcher("private", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypePrivate}, DispatcherOptions{BatchMaxSize: 1, ReadPageSize: 5})
+
+	private := newTestBroadcastMessage(1001)
+	private.Header.Type = core.MessageTypePrivate
+	bm.SetMessageSource(&memoryMessageSource{msgs: []*core.Message{
+		private, newTestBroadcastMessage(1002), newTestBroadcastMessage(1003),
+	}})
+
+	ids, fullPage, err := bm.readDispatcherPages(bm.getDispatcherPages())
+	assert.NoError(t, err)
+	assert.True(t, fullPage)
+	assert.Len(t, ids, 2)
+	assert.Equal(t, int64(1001), ids[0].Sequence)
+	assert.Equal(t, int64(1002), ids[1].Sequence)
+}
+
+func TestDBMessageSourceReadPage(t *testing.T) {
+	mdi := &databasemocks.Plugin{}
+	source := &dbMessageSource{database: mdi, namespace: "ns1"}
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.MatchedBy(func(filter database.Filter) bool {
+		f, _ := filter.Finalize()
+		return f.String() == "( sequence >> 1000 ) && ( state IN ['ready'] ) && ( txtype == 'batch_pin' ) && ( type IN ['broadcast'] ) sort=sequence limit=10"
+	})).Return([]*core.IDAndSequence{{Sequence: 1001}}, nil)
+
+	ids, err := source.ReadPage(context.Background(), &MessagePage{
+		AfterSequence: 1000,
+		Limit:         10,
+		TxType:        core.TransactionTypeBatchPin,
+		MessageTypes:  []core.MessageType{core.MessageTypeBroadcast},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, ids, 1)
+	mdi.AssertExpectations(t)
+}
+
+func TestDBMessageSourceReadPageFail(t *testing.T) {
+	mdi := &databasemocks.Plugin{}
+	source := &dbMessageSource{database: mdi, namespace: "ns1"}
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(nil, fmt.Errorf("pop"))
+
+	_, err := source.ReadPage(context.Background(), &MessagePage{AfterSequence: -1, Limit: 10})
+	assert.Regexp(t, "pop", err)
+}
@@ -0,0 +1,95 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// ErrBatchTooLarge sentinel error, returned by a dispatch handler (optionally wrapped) when the batch is too large
+// for its transport. The batch is then split in half, and each half dispatched as a batch of its own.
+var ErrBatchTooLarge = i18n.NewError(context.Background(), coremsgs.MsgBatchTooLargeForTransport)
+
+// isBatchTooLarge returns true if the handler reported the batch too large, and it can be split
+func (bp *batchProcessor) isBatchTooLarge(state *DispatchState, err error) bool {
+	return len(state.Messages) > 1 && errors.Is(err, ErrBatchTooLarge)
+}
+
+// splitAndDispatch splits a batch the handler reported too large in half, then seals and dispatches each half as
+// a new batch - splitting further as required, down to single messages. Pins already allocated to the messages are
+// kept, so no new nonces are spent. The caller only reports the sequences as flushed once every part succeeds.
+func (bp *batchProcessor) splitAndDispatch(state *DispatchState, dispatchErr error) error {
+	keep := (len(state.Messages) + 1) / 2
+	log.L(bp.ctx).Warnf("Batch %s with %d messages is too large for the transport - splitting: %s", state.Persisted.ID, len(state.Messages), dispatchErr)
+	for _, msgs := range [][]*core.Message{state.Messages[:keep], state.Messages[keep:]} {
+		part := splitState(state, msgs)
+		if err := bp.sealBatch(part); err != nil {
+			return err
+		}
+		if bp.conf.txType == core.TransactionTypeBatchPin {
+			// Sealing skips the private messages that already have pins, so rebuild the full set from the messages
+			var err error
+			if part.Pins, err = bp.bm.rebuildPins(part.Persisted.ID, part.Messages); err != nil {
+				return err
+			}
+		}
+		log.L(bp.ctx).Debugf("Sealed batch %s split from batch %s", part.Persisted.ID, state.Persisted.ID)
+		if bp.conf.OnMessageBatched != nil {
+			for _, msg := range part.Messages {
+				bp.conf.OnMessageBatched(msg.Header.ID, part.Persisted.ID)
+			}
+		}
+		if err := bp.dispatchAndFinalize(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitState builds the state for a new batch, with a subset of the messages of a sealed batch and their data
+func splitState(state *DispatchState, msgs []*core.Message) *DispatchState {
+	part := &DispatchState{
+		Persisted: core.BatchPersisted{
+			BatchHeader: state.Persisted.BatchHeader,
+			Correlator:  state.Persisted.Correlator,
+		},
+		Messages:   msgs,
+		Provenance: state.Provenance,
+		claimed:    state.claimed,
+	}
+	part.Persisted.ID = fftypes.NewUUID()
+	part.Persisted.Created = fftypes.Now()
+	dataIDs := make(map[fftypes.UUID]bool)
+	for _, msg := range msgs {
+		for _, d := range msg.Data {
+			dataIDs[*d.ID] = true
+		}
+	}
+	for _, d := range state.Data {
+		if dataIDs[*d.ID] {
+			part.Data = append(part.Data, d)
+		}
+	}
+	return part
+}
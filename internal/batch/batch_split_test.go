@@ -0,0 +1,167 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/mocks/identitymanagermocks"
+	"github.com/hyperledger/firefly/mocks/txcommonmocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// newTestSplittingBatchProcessor returns a processor whose handler rejects batches of more than maxMessages
+func newTestSplittingBatchProcessor(t *testing.T, txType core.TransactionType, maxMessages int) (func(), *databasemocks.Plugin, *batchProcessor, chan *DispatchState) {
+	dispatched := make(chan *DispatchState, 10)
+	cancel, mdi, bp := newTestBatchProcessor(t, func(c context.Context, state *DispatchState) error {
+		if len(state.Messages) > maxMessages {
+			return fmt.Errorf("transport limit: %w", ErrBatchTooLarge)
+		}
+		dispatched <- state
+		return nil
+	})
+	bp.conf.txType = txType
+	mockRunAsGroupPassthrough(mdi)
+	bp.bm.identity.(*identitymanagermocks.Manager).On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
+	bp.txHelper.(*txcommonmocks.Helper).On("SubmitNewTransaction", mock.Anything, txType).Return(fftypes.NewUUID(), nil)
+	bp.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("InsertEvent", mock.Anything, mock.Anything).Return(nil)
+	return cancel, mdi, bp, dispatched
+}
+
+func TestDispatchSplitsBatchTooLarge(t *testing.T) {
+	cancel, mdi, bp, dispatched := newTestSplittingBatchProcessor(t, core.TransactionTypeUnpinned, 2)
+	defer cancel()
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(nil)
+	var batched []*fftypes.UUID
+	bp.conf.OnMessageBatched = func(msgID, batchID *fftypes.UUID) {
+		batched = append(batched, batchID)
+	}
+
+	msgs := make([]*core.Message, 4)
+	for i := range msgs {
+		msgs[i] = newTestBroadcastMessage(int64(1001 + i))
+		msgs[i].Data = core.DataRefs{{ID: fftypes.NewUUID()}}
+		bp.addWork(&batchWork{msg: msgs[i], data: core.DataArray{{ID: msgs[i].Data[0].ID}}})
+	}
+
+	err := bp.flush(false, flushTriggerSize)
+	assert.NoError(t, err)
+
+	// Each half is dispatched in a new batch of its own, with just the data of its messages
+	first, second := <-dispatched, <-dispatched
+	assert.Len(t, first.Messages, 2)
+	assert.Len(t, second.Messages, 2)
+	assert.Equal(t, msgs[0].Header.ID, first.Messages[0].Header.ID)
+	assert.Equal(t, msgs[2].Header.ID, second.Messages[0].Header.ID)
+	assert.Equal(t, core.DataArray{{ID: msgs[2].Data[0].ID}, {ID: msgs[3].Data[0].ID}}, second.Data)
+	assert.NotEqual(t, *first.Persisted.ID, *second.Persisted.ID)
+	assert.Len(t, batched, 8)
+	assert.Equal(t, first.Persisted.ID, batched[4])
+	assert.Equal(t, second.Persisted.ID, batched[6])
+	mdi.AssertNumberOfCalls(t, "UpsertBatch", 3)
+
+	// The sequences are only reported flushed once every part has been dispatched
+	assert.Len(t, bp.bm.inflightFlushed, 4)
+}
+
+func TestDispatchSplitsBatchTooLargeToSingleMessages(t *testing.T) {
+	cancel, mdi, bp, dispatched := newTestSplittingBatchProcessor(t, core.TransactionTypeUnpinned, 1)
+	defer cancel()
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(nil)
+
+	for i := 0; i < 3; i++ {
+		bp.addWork(&batchWork{msg: newTestBroadcastMessage(int64(1001 + i))})
+	}
+
+	err := bp.flush(false, flushTriggerSize)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		assert.Len(t, (<-dispatched).Messages, 1)
+	}
+}
+
+func TestDispatchSplitKeepsAllocatedPins(t *testing.T) {
+	cancel, mdi, bp, dispatched := newTestSplittingBatchProcessor(t, core.TransactionTypeBatchPin, 1)
+	defer cancel()
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(nil)
+
+	group := fftypes.NewRandB32()
+	pins := []*fftypes.Bytes32{fftypes.NewRandB32(), fftypes.NewRandB32()}
+	for i, pin := range pins {
+		msg := newTestBroadcastMessage(int64(1001 + i))
+		msg.Header.Group = group
+		msg.Pins = core.FFStringArray{fmt.Sprintf("%s:%.16d", pin, i)}
+		bp.addWork(&batchWork{msg: msg})
+	}
+
+	err := bp.flush(false, flushTriggerSize)
+	assert.NoError(t, err)
+	for _, pin := range pins {
+		assert.Equal(t, []*fftypes.Bytes32{pin}, (<-dispatched).Pins)
+	}
+	mdi.AssertNotCalled(t, "UpdateNonce", mock.Anything, mock.Anything)
+}
+
+func TestDispatchSplitSealFail(t *testing.T) {
+	cancel, mdi, bp, _ := newTestSplittingBatchProcessor(t, core.TransactionTypeUnpinned, 1)
+	bp.bm.SetRetryableError(isTestRetryable)
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(nil).Once()
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(errTestPermanent)
+	defer cancel()
+
+	bp.addWork(&batchWork{msg: newTestBroadcastMessage(1001)})
+	bp.addWork(&batchWork{msg: newTestBroadcastMessage(1002)})
+
+	err := bp.flush(false, flushTriggerSize)
+	assert.ErrorIs(t, err, errTestPermanent)
+	assert.Empty(t, bp.bm.inflightFlushed)
+}
+
+func TestDispatchSplitRebuildPinsFail(t *testing.T) {
+	cancel, mdi, bp, _ := newTestSplittingBatchProcessor(t, core.TransactionTypeBatchPin, 1)
+	defer cancel()
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(nil)
+
+	for i := 0; i < 2; i++ {
+		msg := newTestBroadcastMessage(int64(1001 + i))
+		msg.Header.Group = fftypes.NewRandB32()
+		msg.Pins = core.FFStringArray{"bad:0000000000000012"}
+		bp.addWork(&batchWork{msg: msg})
+	}
+
+	err := bp.flush(false, flushTriggerSize)
+	assert.Regexp(t, "FF00107", err)
+}
+
+func TestIsBatchTooLargeSingleMessage(t *testing.T) {
+	cancel, _, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	state := &DispatchState{Messages: []*core.Message{newTestBroadcastMessage(1001)}}
+	assert.False(t, bp.isBatchTooLarge(state, ErrBatchTooLarge))
+	state.Messages = append(state.Messages, newTestBroadcastMessage(1002))
+	assert.True(t, bp.isBatchTooLarge(state, ErrBatchTooLarge))
+	assert.False(t, bp.isBatchTooLarge(state, fmt.Errorf("pop")))
+}
@@ -0,0 +1,139 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"sync/atomic"
+
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+type flushTrigger int
+
+const (
+	// flushTriggerQuiesce is a flush of the remaining work when a processor shuts down
+	flushTriggerQuiesce flushTrigger = iota
+	// flushTriggerSize is a flush because the batch reached its maximum size in messages
+	flushTriggerSize
+	// flushTriggerTimeout is a flush because the batch timeout expired
+	flushTriggerTimeout
+	// flushTriggerSeal is a flush of an OrderedDispatch batch because the next message is for another processor, or a
+	// flush because a flush marker message was reached
+	flushTriggerSeal
+	// flushTriggerMaxAge is a flush because the first message of the batch reached the BatchMaxAge
+	flushTriggerMaxAge
+	// flushTriggerBytes is a flush because the batch reached its maximum size in bytes
+	flushTriggerBytes
+	// flushTriggerMinSize is a flush because the batch reached its BatchMinSize, with no further work waiting
+	flushTriggerMinSize
+)
+
+// DispatcherStats counts the batches flushed for a message type, and what triggered each flush.
+// A batch containing messages of more than one type is counted against each of those types.
+type DispatcherStats struct {
+	FlushedBySize    int64 `json:"flushedBySize"`
+	FlushedByTimeout int64 `json:"flushedByTimeout"`
+	FlushedByMaxAge  int64 `json:"flushedByMaxAge"`
+	TotalMessages    int64 `json:"totalMessages"`
+	TotalBatches     int64 `json:"totalBatches"`
+	DispatchErrors   int64 `json:"dispatchErrors"`
+}
+
+// dispatcherCounters are updated atomically by the processors, so can be read while batches are dispatched
+type dispatcherCounters struct {
+	flushedBySize    int64
+	flushedByTimeout int64
+	flushedByMaxAge  int64
+	totalMessages    int64
+	totalBatches     int64
+	dispatchErrors   int64
+}
+
+func (bm *batchManager) getDispatcherCounters(msgType core.MessageType) *dispatcherCounters {
+	bm.dispatcherMux.Lock()
+	defer bm.dispatcherMux.Unlock()
+	return bm.dispatcherStats[msgType]
+}
+
+// recordFlush counts a flushed batch against each of the message types it contains
+func (bm *batchManager) recordFlush(msgs []*core.Message, trigger flushTrigger) {
+	msgsByType := make(map[core.MessageType]int64)
+	for _, msg := range msgs {
+		msgsByType[msg.Header.Type]++
+	}
+	for msgType, count := range msgsByType {
+		counters := bm.getDispatcherCounters(msgType)
+		if counters == nil {
+			continue
+		}
+		switch trigger {
+		case flushTriggerSize, flushTriggerBytes, flushTriggerMinSize:
+			atomic.AddInt64(&counters.flushedBySize, 1)
+		case flushTriggerTimeout:
+			atomic.AddInt64(&counters.flushedByTimeout, 1)
+		case flushTriggerMaxAge:
+			atomic.AddInt64(&counters.flushedByMaxAge, 1)
+		}
+		atomic.AddInt64(&counters.totalMessages, count)
+		atomic.AddInt64(&counters.totalBatches, 1)
+	}
+}
+
+// recordDispatchError counts a failed attempt to dispatch a batch against each of the message types it contains
+func (bm *batchManager) recordDispatchError(msgs []*core.Message) {
+	msgTypes := make(map[core.MessageType]bool)
+	for _, msg := range msgs {
+		msgTypes[msg.Header.Type] = true
+	}
+	for msgType := range msgTypes {
+		if counters := bm.getDispatcherCounters(msgType); counters != nil {
+			atomic.AddInt64(&counters.dispatchErrors, 1)
+		}
+	}
+}
+
+// DispatcherStats returns a snapshot of the flush counters for each message type with a registered dispatcher
+func (bm *batchManager) DispatcherStats() map[core.MessageType]*DispatcherStats {
+	bm.dispatcherMux.Lock()
+	defer bm.dispatcherMux.Unlock()
+	stats := make(map[core.MessageType]*DispatcherStats, len(bm.dispatcherStats))
+	for msgType, counters := range bm.dispatcherStats {
+		stats[msgType] = &DispatcherStats{
+			FlushedBySize:    atomic.LoadInt64(&counters.flushedBySize),
+			FlushedByTimeout: atomic.LoadInt64(&counters.flushedByTimeout),
+			FlushedByMaxAge:  atomic.LoadInt64(&counters.flushedByMaxAge),
+			TotalMessages:    atomic.LoadInt64(&counters.totalMessages),
+			TotalBatches:     atomic.LoadInt64(&counters.totalBatches),
+			DispatchErrors:   atomic.LoadInt64(&counters.dispatchErrors),
+		}
+	}
+	return stats
+}
+
+// ResetDispatcherStats sets the flush counters for all message types back to zero
+func (bm *batchManager) ResetDispatcherStats() {
+	bm.dispatcherMux.Lock()
+	defer bm.dispatcherMux.Unlock()
+	for _, counters := range bm.dispatcherStats {
+		atomic.StoreInt64(&counters.flushedBySize, 0)
+		atomic.StoreInt64(&counters.flushedByTimeout, 0)
+		atomic.StoreInt64(&counters.flushedByMaxAge, 0)
+		atomic.StoreInt64(&counters.totalMessages, 0)
+		atomic.StoreInt64(&counters.totalBatches, 0)
+		atomic.StoreInt64(&counters.dispatchErrors, 0)
+	}
+}
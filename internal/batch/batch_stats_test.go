@@ -0,0 +1,109 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDispatcherStatsFlushTriggers(t *testing.T) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	dispatched := make(chan *DispatchState, 2)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:   2,
+			BatchMaxBytes:  1024 * 1024,
+			BatchTimeout:   10 * time.Millisecond,
+			DisposeTimeout: 120 * time.Second,
+		},
+	)
+
+	// The first two messages fill a batch, and the last is flushed on the timeout
+	mockMessagePage(mdi, mdm, newTestBroadcastMessage(1001), newTestBroadcastMessage(1002), newTestBroadcastMessage(1003))
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+	<-dispatched
+	<-dispatched
+
+	assert.Eventually(t, func() bool {
+		return bm.DispatcherStats()[core.MessageTypeBroadcast].TotalBatches == 2
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, &DispatcherStats{
+		FlushedBySize:    1,
+		FlushedByTimeout: 1,
+		TotalMessages:    3,
+		TotalBatches:     2,
+	}, bm.DispatcherStats()[core.MessageTypeBroadcast])
+
+	bm.ResetDispatcherStats()
+	assert.Equal(t, &DispatcherStats{}, bm.DispatcherStats()[core.MessageTypeBroadcast])
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestDispatcherStatsMixedTypes(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast, core.MessageTypeDefinition},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 1},
+	)
+
+	definition := newTestBroadcastMessage(1002)
+	definition.Header.Type = core.MessageTypeDefinition
+	private := newTestBroadcastMessage(1003)
+	private.Header.Type = core.MessageTypePrivate
+	bm.recordFlush([]*core.Message{newTestBroadcastMessage(1001), definition, private}, flushTriggerQuiesce)
+
+	stats := bm.DispatcherStats()
+	assert.Len(t, stats, 2)
+	assert.Equal(t, &DispatcherStats{TotalMessages: 1, TotalBatches: 1}, stats[core.MessageTypeBroadcast])
+	assert.Equal(t, &DispatcherStats{TotalMessages: 1, TotalBatches: 1}, stats[core.MessageTypeDefinition])
+}
+
+func TestDispatcherStatsDispatchErrors(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error { return nil },
+		DispatcherOptions{BatchMaxSize: 1},
+	)
+
+	private := newTestBroadcastMessage(1003)
+	private.Header.Type = core.MessageTypePrivate
+	bm.recordDispatchError([]*core.Message{newTestBroadcastMessage(1001), newTestBroadcastMessage(1002), private})
+	bm.recordDispatchError([]*core.Message{newTestBroadcastMessage(1004)})
+	assert.Equal(t, &DispatcherStats{DispatchErrors: 2}, bm.DispatcherStats()[core.MessageTypeBroadcast])
+
+	bm.ResetDispatcherStats()
+	assert.Equal(t, &DispatcherStats{}, bm.DispatcherStats()[core.MessageTypeBroadcast])
+}
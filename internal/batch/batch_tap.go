@@ -0,0 +1,60 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"sync/atomic"
+
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// dispatchTapBufferSize is how many dispatched batches the tap holds for an observer that has not yet received them
+const dispatchTapBufferSize = 100
+
+// TapDispatches returns a channel that receives a copy of every batch dispatched from now on, so integration tests
+// can observe dispatch without wrapping the handlers. The tap is opt-in - created by the first call, with later calls
+// returning the same channel - and has no effect on the handlers. When its buffer is full, batches are dropped and
+// counted in the status, rather than blocking dispatch. The channel is never closed.
+func (bm *batchManager) TapDispatches() <-chan *core.Batch {
+	bm.tapMux.Lock()
+	defer bm.tapMux.Unlock()
+	if bm.tap == nil {
+		bm.tap = make(chan *core.Batch, dispatchTapBufferSize)
+	}
+	return bm.tap
+}
+
+// tapDispatch delivers a copy of a batch that has been dispatched and finalized to the tap, if there is one
+func (bm *batchManager) tapDispatch(state *DispatchState) {
+	bm.tapMux.Lock()
+	tap := bm.tap
+	bm.tapMux.Unlock()
+	if tap == nil {
+		return
+	}
+	select {
+	case tap <- state.Persisted.GenInflight(state.Messages, state.Data).Clone():
+	default:
+		atomic.AddInt64(&bm.tapDropped, 1)
+		log.L(bm.ctx).Debugf("Dispatch tap full - dropped batch %s", state.Persisted.ID)
+	}
+}
+
+func (bm *batchManager) tapStatus(status *ManagerStatus) {
+	status.TapDropped = atomic.LoadInt64(&bm.tapDropped)
+}
@@ -0,0 +1,89 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestTapDispatchesReceivesDispatchedBatch(t *testing.T) {
+	bm, _, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+
+	dispatched := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 2, BatchMaxBytes: 1024 * 1024, BatchTimeout: time.Minute, DisposeTimeout: 120 * time.Second},
+	)
+	msg1 := newTestBroadcastMessage(1001)
+	msg2 := newTestBroadcastMessage(1002)
+	source := &memoryMessageSource{msgs: []*core.Message{msg1, msg2}}
+	for _, msg := range source.msgs {
+		mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil)
+	}
+	bm.SetMessageSource(source)
+	tap := bm.TapDispatches()
+	assert.Equal(t, tap, bm.TapDispatches())
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	state := <-dispatched
+	batch := <-tap
+	assert.Equal(t, state.Persisted.ID, batch.ID)
+	assert.Len(t, batch.Payload.Messages, 2)
+	assert.Equal(t, msg1.Header.ID, batch.Payload.Messages[0].Header.ID)
+	assert.Equal(t, msg2.Header.ID, batch.Payload.Messages[1].Header.ID)
+	assert.Zero(t, atomic.LoadInt64(&bm.tapDropped))
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestTapDispatchNoTap(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	bm.tapDispatch(&DispatchState{Persisted: core.BatchPersisted{BatchHeader: core.BatchHeader{ID: fftypes.NewUUID()}}})
+	assert.Nil(t, bm.tap)
+	assert.Zero(t, atomic.LoadInt64(&bm.tapDropped))
+}
+
+func TestTapDispatchFullDrops(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+
+	tap := bm.TapDispatches()
+	for i := 0; i < dispatchTapBufferSize+2; i++ {
+		bm.tapDispatch(&DispatchState{Persisted: core.BatchPersisted{BatchHeader: core.BatchHeader{ID: fftypes.NewUUID()}}})
+	}
+	assert.Len(t, tap, dispatchTapBufferSize)
+	status := &ManagerStatus{}
+	bm.tapStatus(status)
+	assert.Equal(t, int64(2), status.TapDropped)
+}
@@ -0,0 +1,84 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// OpenBatchTimer describes a batch being assembled by a processor, and how long until its batch timeout flushes it
+type OpenBatchTimer struct {
+	Dispatcher       string             `ffstruct:"BatchOpenBatchTimer" json:"dispatcher"`
+	Processor        string             `ffstruct:"BatchOpenBatchTimer" json:"processor"`
+	MessageType      core.MessageType   `ffstruct:"BatchOpenBatchTimer" json:"messageType"`
+	Messages         int                `ffstruct:"BatchOpenBatchTimer" json:"messages"`
+	Bytes            int64              `ffstruct:"BatchOpenBatchTimer" json:"bytes"`
+	Started          *fftypes.FFTime    `ffstruct:"BatchOpenBatchTimer" json:"started"`
+	TimeoutRemaining fftypes.FFDuration `ffstruct:"BatchOpenBatchTimer" json:"timeoutRemaining"`
+}
+
+// updateOpenBatchTimer records the current assembly for status reporting. Called on the assembly goroutine,
+// holding the statusMux, whenever the assembly changes.
+func (bp *batchProcessor) updateOpenBatchTimer() {
+	if len(bp.assemblyQueue) == 0 {
+		bp.openBatch = nil
+		return
+	}
+	started := fftypes.FFTime(bp.assemblyStarted)
+	bp.openBatch = &OpenBatchTimer{
+		Dispatcher:  bp.conf.dispatcherName,
+		Processor:   bp.conf.name,
+		MessageType: bp.assemblyQueue[0].msg.Header.Type,
+		Messages:    len(bp.assemblyQueue),
+		Bytes:       bp.assemblyQueueBytes,
+		Started:     &started,
+	}
+}
+
+// openBatchTimer returns the open batch of the processor, if any, with the time remaining until its batch timeout -
+// or its maximum age, if that is sooner. A batch held past its timeout, while its dispatcher is disabled or the
+// manager is paused, has none remaining.
+func (bp *batchProcessor) openBatchTimer() *OpenBatchTimer {
+	bp.statusMux.Lock()
+	defer bp.statusMux.Unlock()
+	if bp.openBatch == nil {
+		return nil
+	}
+	timer := *bp.openBatch
+	timeout := bp.conf.BatchTimeout
+	if bp.conf.BatchMaxAge > 0 && bp.conf.BatchMaxAge < timeout {
+		timeout = bp.conf.BatchMaxAge
+	}
+	if remaining := timeout - bp.bm.since(time.Time(*timer.Started)); remaining > 0 {
+		timer.TimeoutRemaining = fftypes.FFDuration(remaining)
+	}
+	return &timer
+}
+
+// openBatchTimers lists the open batch of each processor that has one
+func (bm *batchManager) openBatchTimers(processors []*batchProcessor) []*OpenBatchTimer {
+	timers := make([]*OpenBatchTimer, 0)
+	for _, p := range processors {
+		if timer := p.openBatchTimer(); timer != nil {
+			timers = append(timers, timer)
+		}
+	}
+	return timers
+}
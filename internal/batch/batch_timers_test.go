@@ -0,0 +1,118 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestOpenBatchTimer(t *testing.T) {
+	cancel, _, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	bp.conf.dispatcherName = "utdispatcher"
+	bp.conf.BatchTimeout = time.Minute
+	assert.Nil(t, bp.openBatchTimer())
+
+	msg1 := &batchWork{msg: newTestBroadcastMessage(1001)}
+	msg2 := &batchWork{msg: newTestBroadcastMessage(1002)}
+	bp.addWork(msg1)
+	bp.addWork(msg2)
+
+	timer := bp.openBatchTimer()
+	assert.Equal(t, "utdispatcher", timer.Dispatcher)
+	assert.Equal(t, bp.conf.name, timer.Processor)
+	assert.Equal(t, core.MessageTypeBroadcast, timer.MessageType)
+	assert.Equal(t, 2, timer.Messages)
+	assert.Equal(t, batchSizeEstimateBase+msg1.estimateSize()+msg2.estimateSize(), timer.Bytes)
+	assert.Equal(t, bp.assemblyStarted, time.Time(*timer.Started))
+	assert.Greater(t, timer.TimeoutRemaining, fftypes.FFDuration(0))
+	assert.LessOrEqual(t, timer.TimeoutRemaining, fftypes.FFDuration(time.Minute))
+
+	// The batch is no longer open once its flush starts
+	bp.startFlush(false)
+	assert.Nil(t, bp.openBatchTimer())
+}
+
+func TestOpenBatchTimerHeldPastTimeout(t *testing.T) {
+	cancel, _, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	bp.conf.BatchTimeout = time.Minute
+	bp.addWork(&batchWork{msg: newTestBroadcastMessage(1001)})
+
+	started := fftypes.FFTime(time.Now().Add(-2 * time.Minute))
+	bp.statusMux.Lock()
+	bp.openBatch.Started = &started
+	bp.statusMux.Unlock()
+	assert.Equal(t, fftypes.FFDuration(0), bp.openBatchTimer().TimeoutRemaining)
+}
+
+func TestOpenBatchTimerOverflow(t *testing.T) {
+	cancel, _, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	bp.conf.BatchMaxBytes = batchSizeEstimateBase + 1
+	bp.addWork(&batchWork{msg: newTestBroadcastMessage(1001)})
+	overflowed := &batchWork{msg: newTestBroadcastMessage(1002)}
+	bp.addWork(overflowed)
+
+	// The message that overflowed the batch is carried over as the next open batch
+	bp.startFlush(true)
+	timer := bp.openBatchTimer()
+	assert.Equal(t, 1, timer.Messages)
+	assert.Equal(t, batchSizeEstimateBase+overflowed.estimateSize(), timer.Bytes)
+}
+
+func TestStatusOpenBatchTimers(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:   10,
+			BatchMaxBytes:  1024 * 1024,
+			BatchTimeout:   time.Minute,
+			DisposeTimeout: time.Minute,
+		},
+	)
+	assert.Empty(t, bm.Status().OpenBatchTimers)
+
+	msg := newTestBroadcastMessage(1001)
+	bp, err := bm.getProcessor(core.TransactionTypeBatchPin, core.MessageTypeBroadcast, nil, &msg.Header.SignerRef, 0, "")
+	assert.NoError(t, err)
+	bp.newWork <- &batchWork{msg: msg}
+
+	var timers []*OpenBatchTimer
+	for len(timers) == 0 {
+		time.Sleep(1 * time.Millisecond)
+		timers = bm.Status().OpenBatchTimers
+	}
+	assert.Equal(t, "utdispatcher", timers[0].Dispatcher)
+	assert.Equal(t, 1, timers[0].Messages)
+	assert.Greater(t, timers[0].TimeoutRemaining, fftypes.FFDuration(0))
+}
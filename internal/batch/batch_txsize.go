@@ -0,0 +1,64 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"github.com/hyperledger/firefly-common/pkg/log"
+)
+
+// isTxSizeLimit returns true if the dispatcher classifies the error as the database rejecting a transaction for its size
+func (bp *batchProcessor) isTxSizeLimit(err error) bool {
+	return err != nil && bp.conf.TxSizeLimitError != nil && bp.conf.TxSizeLimitError(err)
+}
+
+// maxBatchSize is the effective maximum number of messages in a batch, which starts at BatchMaxSize and is
+// reduced each time the database rejects a transaction for its size
+func (bp *batchProcessor) maxBatchSize() uint {
+	bp.statusMux.Lock()
+	defer bp.statusMux.Unlock()
+	if bp.reducedMaxSize > 0 && bp.reducedMaxSize < bp.conf.BatchMaxSize {
+		return bp.reducedMaxSize
+	}
+	return bp.conf.BatchMaxSize
+}
+
+func (bp *batchProcessor) reduceBatchSize(size uint) {
+	if size < 1 {
+		size = 1
+	}
+	if size >= bp.maxBatchSize() {
+		return
+	}
+	log.L(bp.ctx).Warnf("Reducing maximum batch size to %d after a database transaction size limit error", size)
+	bp.statusMux.Lock()
+	bp.reducedMaxSize = size
+	bp.statusMux.Unlock()
+}
+
+// requeueWork returns work split off a batch that could not be sealed to the front of the assembly, for the next batch
+func (bp *batchProcessor) requeueWork(work []*batchWork) {
+	if len(bp.assemblyQueue) == 0 {
+		bp.assemblyStarted = bp.bm.clock.Now()
+	}
+	for _, w := range work {
+		bp.assemblyQueueBytes += w.estimateSize()
+	}
+	bp.assemblyQueue = append(append([]*batchWork{}, work...), bp.assemblyQueue...)
+	bp.statusMux.Lock()
+	bp.updateOpenBatchTimer()
+	bp.statusMux.Unlock()
+}
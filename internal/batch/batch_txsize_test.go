@@ -0,0 +1,182 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/mocks/identitymanagermocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var errTestTxTooLarge = errors.New("transaction too large")
+
+func isTestTxTooLarge(err error) bool {
+	return errors.Is(err, errTestTxTooLarge)
+}
+
+func TestTxSizeLimitSplitsBatch(t *testing.T) {
+	testConfigReset()
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	mdi := bm.database.(*databasemocks.Plugin)
+	mdm := bm.data.(*datamocks.Manager)
+	mim := bm.identity.(*identitymanagermocks.Manager)
+	mim.On("GetLocalNode", mock.Anything).Return(&core.Identity{}, nil)
+	mdm.On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+	mockRunAsGroupPassthrough(mdi)
+	mdi.On("InsertTransaction", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("InsertEvent", mock.Anything, mock.Anything).Return(nil)
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+
+	// The database rejects the first batch for its size
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(errTestTxTooLarge).Once()
+	mdi.On("UpsertBatch", mock.Anything, mock.Anything).Return(nil)
+
+	dispatched := make(chan *DispatchState, 3)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeUnpinned, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatched <- state
+			return nil
+		},
+		DispatcherOptions{
+			BatchMaxSize:     4,
+			BatchMaxBytes:    1024 * 1024,
+			BatchTimeout:     10 * time.Millisecond,
+			DisposeTimeout:   120 * time.Second,
+			TxSizeLimitError: isTestTxTooLarge,
+		},
+	)
+
+	msgs := make([]*core.Message, 4)
+	for i := range msgs {
+		msgs[i] = newTestBroadcastMessage(int64(1001 + i))
+		msgs[i].Header.TxType = core.TransactionTypeUnpinned
+	}
+	mockMessagePage(mdi, mdm, msgs...)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return([]*core.IDAndSequence{}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	// The batch is split in two, and all the messages are still dispatched in order
+	state1 := <-dispatched
+	state2 := <-dispatched
+	assert.Len(t, state1.Messages, 2)
+	assert.Len(t, state2.Messages, 2)
+	assert.Equal(t, msgs[0].Header.ID, state1.Messages[0].Header.ID)
+	assert.Equal(t, msgs[2].Header.ID, state2.Messages[0].Header.ID)
+
+	// Future batches are assembled at the reduced size
+	processors := bm.getProcessors()
+	assert.Equal(t, uint(2), processors[0].maxBatchSize())
+
+	cancel()
+	bm.WaitStop()
+}
+
+func TestTxSizeLimitChunksMarkDispatched(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	bp.conf.TxSizeLimitError = isTestTxTooLarge
+	mockRunAsGroupPassthrough(mdi)
+	bp.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(errTestTxTooLarge).Once()
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+
+	state := &DispatchState{}
+	for i := 0; i < 4; i++ {
+		state.Messages = append(state.Messages, newTestBroadcastMessage(int64(1001+i)))
+	}
+	err := bp.markPayloadDispatched(state)
+	assert.NoError(t, err)
+
+	// One rejected transaction for all four, then one transaction with two chunks of two
+	mdi.AssertNumberOfCalls(t, "UpdateMessages", 3)
+	assert.Equal(t, uint(2), bp.maxBatchSize())
+}
+
+func TestTxSizeLimitChunkFailureRollsBackBatch(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	bp.conf.TxSizeLimitError = isTestTxTooLarge
+	bp.conf.Outbox = true
+	bp.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+
+	// Track the statements run in each transaction, only keeping those of transactions that commit
+	var pending, committed []string
+	rag := mdi.On("RunAsGroup", mock.Anything, mock.Anything)
+	rag.RunFn = func(a mock.Arguments) {
+		pending = nil
+		err := a[1].(func(context.Context) error)(a[0].(context.Context))
+		if err == nil {
+			committed = append(committed, pending...)
+		}
+		rag.ReturnArguments = mock.Arguments{err}
+	}
+	updates := 0
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(func(ctx context.Context, ns string, filter database.Filter, update database.Update) error {
+		updates++
+		if updates == 1 || updates == 3 {
+			// Rejected for all four, then for the second chunk of two
+			return errTestTxTooLarge
+		}
+		pending = append(pending, "update")
+		return nil
+	})
+	mdi.On("InsertOutboxEntry", mock.Anything, mock.Anything).Return(func(ctx context.Context, entry *core.OutboxEntry) error {
+		pending = append(pending, "outbox")
+		return nil
+	})
+
+	state := &DispatchState{}
+	for i := 0; i < 4; i++ {
+		state.Messages = append(state.Messages, newTestBroadcastMessage(int64(1001+i)))
+	}
+	err := bp.markPayloadDispatched(state)
+	assert.NoError(t, err)
+
+	// The first chunk of two was rolled back with the failure of the second, so only the final transaction
+	// commits - with all four messages, one per statement, and the outbox entry
+	mdi.AssertNumberOfCalls(t, "RunAsGroup", 3)
+	assert.Equal(t, []string{"update", "update", "update", "update", "outbox"}, committed)
+	assert.Equal(t, uint(1), bp.maxBatchSize())
+}
+
+func TestTxSizeLimitNotClassified(t *testing.T) {
+	cancel, _, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+
+	assert.False(t, bp.isTxSizeLimit(errTestTxTooLarge))
+	bp.conf.TxSizeLimitError = isTestTxTooLarge
+	assert.False(t, bp.isTxSizeLimit(nil))
+	assert.True(t, bp.isTxSizeLimit(errTestTxTooLarge))
+
+	// The batch size is never reduced to less than one message, or increased
+	bp.reduceBatchSize(0)
+	assert.Equal(t, uint(1), bp.maxBatchSize())
+	bp.reduceBatchSize(5)
+	assert.Equal(t, uint(1), bp.maxBatchSize())
+}
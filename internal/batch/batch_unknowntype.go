@@ -0,0 +1,71 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+const (
+	// unknownTypeFail logs an error for a message with no registered dispatcher, and holds the offset at it - reading
+	// it again after the read poll timeout, or as soon as a dispatcher for its type is registered
+	unknownTypeFail = "fail"
+	// unknownTypeSkip moves past a message with no registered dispatcher, without error
+	unknownTypeSkip = "skip"
+	// unknownTypeDefer holds the offset at a message with no registered dispatcher, until one is registered
+	unknownTypeDefer = "defer"
+)
+
+// handleUnknownType applies the configured policy to a message whose type has no registered dispatcher
+func (bm *batchManager) handleUnknownType(msg *core.Message, err error) {
+	switch bm.onUnknownType {
+	case unknownTypeSkip:
+		log.L(bm.ctx).Infof("Skipping message %s (seq=%d) with no registered dispatcher: %s", msg.Header.ID, msg.Sequence, err)
+	case unknownTypeDefer:
+		log.L(bm.ctx).Debugf("Deferring message %s (seq=%d) with no registered dispatcher: %s", msg.Header.ID, msg.Sequence, err)
+		bm.inflightMux.Lock()
+		bm.deferredSequences[msg.Sequence] = bm.getDispatcherKey(msg.Header.TxType, msg.Header.Type)
+		bm.inflightMux.Unlock()
+	default:
+		log.L(bm.ctx).Errorf("Failed to dispatch message %s (seq=%d) - retrying in %s: %s", msg.Header.ID, msg.Sequence, bm.messagePollTimeout, err)
+		bm.deferForRecheck(msg.Sequence, bm.getDispatcherKey(msg.Header.TxType, msg.Header.Type), bm.messagePollTimeout)
+	}
+}
+
+// releaseUnknownType reads again any messages deferred for having no registered dispatcher, now that one
+// has been registered for the given transaction and message types
+func (bm *batchManager) releaseUnknownType(txType core.TransactionType, msgTypes []core.MessageType) {
+	keys := make(map[string]bool, len(msgTypes))
+	for _, msgType := range msgTypes {
+		keys[bm.getDispatcherKey(txType, msgType)] = true
+	}
+
+	rewindTo := int64(-1)
+	bm.inflightMux.Lock()
+	for seq, key := range bm.deferredSequences {
+		if keys[key] {
+			if rewindTo < 0 || seq < rewindTo {
+				rewindTo = seq
+			}
+		}
+	}
+	bm.inflightMux.Unlock()
+	if rewindTo >= 0 {
+		bm.newMessageNotification(rewindTo)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// runUnknownTypeTest reads a message before its dispatcher is registered, then registers the dispatcher,
+// and returns whether the message was dispatched as a result
+func runUnknownTypeTest(t *testing.T, policy string) (deferred bool, dispatched bool) {
+	bm, mdi, mdm, cancel := newTestDispatchingBatchManager(t)
+	defer cancel()
+	assert.Equal(t, unknownTypeFail, bm.onUnknownType)
+	bm.onUnknownType = policy
+	bm.readOffset = 1000
+
+	// The message is returned each time we read from before it
+	msg := newTestBroadcastMessage(1001)
+	mdm.On("GetMessageWithDataCached", mock.Anything, msg.Header.ID).Return(msg, core.DataArray{}, true, nil)
+	mdi.On("GetMessageIDs", mock.Anything, "ns1", mock.Anything).Return(func(ctx context.Context, ns string, filter database.Filter) []*core.IDAndSequence {
+		fi, _ := filter.Finalize()
+		if strings.HasPrefix(fi.String(), "( sequence >> 1000 )") {
+			return []*core.IDAndSequence{{ID: *msg.Header.ID, Sequence: msg.Sequence}}
+		}
+		return []*core.IDAndSequence{}
+	}, nil)
+
+	err := bm.Start()
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		bm.inflightMux.Lock()
+		defer bm.inflightMux.Unlock()
+		return bm.highestReadOffset == 1001
+	}, 5*time.Second, time.Millisecond)
+	bm.inflightMux.Lock()
+	_, deferred = bm.deferredSequences[1001]
+	if deferred {
+		assert.Equal(t, int64(1000), bm.calcCommittableOffset())
+	} else {
+		assert.Equal(t, int64(1001), bm.calcCommittableOffset())
+	}
+	bm.inflightMux.Unlock()
+
+	dispatches := make(chan *DispatchState, 1)
+	bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			dispatches <- state
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: 120 * time.Second},
+	)
+
+	select {
+	case state := <-dispatches:
+		assert.Equal(t, msg.Header.ID, state.Messages[0].Header.ID)
+		dispatched = true
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	bm.WaitStop()
+	return deferred, dispatched
+}
+
+func TestUnknownTypeFailHoldsOffset(t *testing.T) {
+	deferred, dispatched := runUnknownTypeTest(t, unknownTypeFail)
+	assert.True(t, deferred)
+	assert.True(t, dispatched)
+}
+
+func TestUnknownTypeSkip(t *testing.T) {
+	deferred, dispatched := runUnknownTypeTest(t, unknownTypeSkip)
+	assert.False(t, deferred)
+	assert.False(t, dispatched)
+}
+
+func TestUnknownTypeDeferReplaysOnRegister(t *testing.T) {
+	deferred, dispatched := runUnknownTypeTest(t, unknownTypeDefer)
+	assert.True(t, deferred)
+	assert.True(t, dispatched)
+}
+
+func TestReleaseUnknownTypeOtherType(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	bm.deferredSequences[1001] = bm.getDispatcherKey(core.TransactionTypeBatchPin, core.MessageTypeBroadcast)
+
+	bm.releaseUnknownType(core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypePrivate})
+	assert.Len(t, bm.deferredSequences, 1)
+	assert.Equal(t, int64(-1), bm.rewindOffset)
+
+	bm.releaseUnknownType(core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast})
+	assert.Len(t, bm.deferredSequences, 1)
+	assert.Equal(t, int64(1000), bm.rewindOffset)
+
+	// The deferred message is released when the rewind is applied
+	bm.readOffset = 1001
+	bm.highestReadOffset = 1001
+	bm.popRewind()
+	assert.Empty(t, bm.deferredSequences)
+	assert.Equal(t, int64(1000), bm.readOffset)
+}
@@ -0,0 +1,101 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// defaultDisposeTimeout applies to dispatchers registered with a zero DisposeTimeout, which would otherwise dispose of
+// each processor as soon as it is idle - only to create it again for the next message
+const defaultDisposeTimeout = 2 * time.Minute
+
+// isPinnedPrivate returns true for a dispatcher that assigns nonces from the pinned sequence of each group to the
+// messages it seals, so every batch it seals must be delivered for the group to process the messages that follow
+func isPinnedPrivate(txType core.TransactionType, msgTypes []core.MessageType) bool {
+	if txType != core.TransactionTypeBatchPin {
+		return false
+	}
+	for _, msgType := range msgTypes {
+		switch msgType {
+		case core.MessageTypePrivate, core.MessageTypeGroupInit, core.MessageTypeTransferPrivate:
+			return true
+		}
+	}
+	return false
+}
+
+// validateDispatcherOptions rejects options that are nonsensical, rather than leaving them to cause confusing
+// behavior at runtime, and applies defaults to zero values where there is a sensible default
+func validateDispatcherOptions(ctx context.Context, name string, txType core.TransactionType, msgTypes []core.MessageType, options *DispatcherOptions) error {
+	if options.BatchMaxSize == 0 {
+		return i18n.NewError(ctx, coremsgs.MsgDispatcherBatchMaxSizeZero, name)
+	}
+	if options.BatchMinSize > options.BatchMaxSize {
+		return i18n.NewError(ctx, coremsgs.MsgDispatcherBatchMinSizeExceedsMax, name, options.BatchMinSize, options.BatchMaxSize)
+	}
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"BatchTimeout", options.BatchTimeout},
+		{"DisposeTimeout", options.DisposeTimeout},
+		{"BatchMaxAge", options.BatchMaxAge},
+		{"StallThreshold", options.StallThreshold},
+		{"DispatchTimeout", options.DispatchTimeout},
+		{"MinMessageDwell", options.MinMessageDwell},
+		{"IdempotencyWindow", options.IdempotencyWindow},
+		{"ReadinessRecheck", options.ReadinessRecheck},
+		{"PriorityBatchTimeout", options.PriorityBatchTimeout},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			return i18n.NewError(ctx, coremsgs.MsgDispatcherNegativeOption, name, d.name)
+		}
+	}
+	if options.MaxDispatchAttempts > 0 && isPinnedPrivate(txType, msgTypes) {
+		// A dead-lettered batch would leave a gap in the nonces of its groups, that the members would wait on forever
+		return i18n.NewError(ctx, coremsgs.MsgDispatcherPinnedPrivateDeadLetter, name)
+	}
+	if options.BatchMaxBytes < 0 {
+		return i18n.NewError(ctx, coremsgs.MsgDispatcherNegativeOption, name, "BatchMaxBytes")
+	}
+	if options.BatchMaxBytes > 0 && options.BatchMaxBytes <= batchSizeEstimateBase {
+		return i18n.NewError(ctx, coremsgs.MsgDispatcherBatchMaxBytesTooSmall, name, options.BatchMaxBytes, batchSizeEstimateBase)
+	}
+	if len(options.SizeClasses) > 0 && txType != core.TransactionTypeUnpinned {
+		return i18n.NewError(ctx, coremsgs.MsgDispatcherPinnedSplitsBatches, name, "SizeClasses")
+	}
+	if options.GroupBy != nil && txType != core.TransactionTypeUnpinned {
+		return i18n.NewError(ctx, coremsgs.MsgDispatcherPinnedSplitsBatches, name, "GroupBy")
+	}
+	for i, limit := range options.SizeClasses {
+		if limit <= 0 || (i > 0 && limit <= options.SizeClasses[i-1]) || (options.BatchMaxBytes > 0 && limit > options.BatchMaxBytes) {
+			return i18n.NewError(ctx, coremsgs.MsgDispatcherSizeClassesInvalid, name)
+		}
+	}
+
+	if options.DisposeTimeout == 0 {
+		options.DisposeTimeout = defaultDisposeTimeout
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDispatcherOptionsDefaults(t *testing.T) {
+	options := &DispatcherOptions{BatchMaxSize: 1}
+	err := validateDispatcherOptions(context.Background(), "utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast}, options)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultDisposeTimeout, options.DisposeTimeout)
+
+	options = &DispatcherOptions{
+		BatchMaxSize:   10,
+		BatchMaxBytes:  1024,
+		DisposeTimeout: time.Second,
+		SizeClasses:    []int64{600, 800, 1024},
+	}
+	err = validateDispatcherOptions(context.Background(), "utdispatcher", core.TransactionTypeUnpinned, []core.MessageType{core.MessageTypeBroadcast}, options)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, options.DisposeTimeout)
+
+	// Unpinned private batches carry no nonces, so can be dead-lettered
+	options = &DispatcherOptions{BatchMaxSize: 1, MaxDispatchAttempts: 3}
+	err = validateDispatcherOptions(context.Background(), "utdispatcher", core.TransactionTypeUnpinned, []core.MessageType{core.MessageTypePrivate}, options)
+	assert.NoError(t, err)
+}
+
+func TestValidateDispatcherOptionsInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		options  DispatcherOptions
+		txType   core.TransactionType
+		msgTypes []core.MessageType
+		errRE    string
+	}{
+		{DispatcherOptions{}, core.TransactionTypeBatchPin, nil, "FF10441"},
+		{DispatcherOptions{BatchMaxSize: 1, BatchMinSize: 2}, core.TransactionTypeBatchPin, nil, "FF10446"},
+		{DispatcherOptions{BatchMaxSize: 1, BatchTimeout: -1}, core.TransactionTypeBatchPin, nil, "FF10442.*BatchTimeout"},
+		{DispatcherOptions{BatchMaxSize: 1, DisposeTimeout: -1}, core.TransactionTypeBatchPin, nil, "FF10442.*DisposeTimeout"},
+		{DispatcherOptions{BatchMaxSize: 1, BatchMaxAge: -1}, core.TransactionTypeBatchPin, nil, "FF10442.*BatchMaxAge"},
+		{DispatcherOptions{BatchMaxSize: 1, StallThreshold: -1}, core.TransactionTypeBatchPin, nil, "FF10442.*StallThreshold"},
+		{DispatcherOptions{BatchMaxSize: 1, DispatchTimeout: -1}, core.TransactionTypeBatchPin, nil, "FF10442.*DispatchTimeout"},
+		{DispatcherOptions{BatchMaxSize: 1, MinMessageDwell: -1}, core.TransactionTypeBatchPin, nil, "FF10442.*MinMessageDwell"},
+		{DispatcherOptions{BatchMaxSize: 1, IdempotencyWindow: -1}, core.TransactionTypeBatchPin, nil, "FF10442.*IdempotencyWindow"},
+		{DispatcherOptions{BatchMaxSize: 1, ReadinessRecheck: -1}, core.TransactionTypeBatchPin, nil, "FF10442.*ReadinessRecheck"},
+		{DispatcherOptions{BatchMaxSize: 1, PriorityBatchTimeout: -1}, core.TransactionTypeBatchPin, nil, "FF10442.*PriorityBatchTimeout"},
+		{DispatcherOptions{BatchMaxSize: 1, BatchMaxBytes: -1}, core.TransactionTypeBatchPin, nil, "FF10442.*BatchMaxBytes"},
+		{DispatcherOptions{BatchMaxSize: 1, BatchMaxBytes: batchSizeEstimateBase}, core.TransactionTypeBatchPin, nil, "FF10443"},
+		{DispatcherOptions{BatchMaxSize: 1, SizeClasses: []int64{0}}, core.TransactionTypeUnpinned, nil, "FF10444"},
+		{DispatcherOptions{BatchMaxSize: 1, SizeClasses: []int64{800, 600}}, core.TransactionTypeUnpinned, nil, "FF10444"},
+		{DispatcherOptions{BatchMaxSize: 1, BatchMaxBytes: 1024, SizeClasses: []int64{2048}}, core.TransactionTypeUnpinned, nil, "FF10444"},
+		{DispatcherOptions{BatchMaxSize: 1, SizeClasses: []int64{1024}}, core.TransactionTypeBatchPin, nil, "FF10453.*SizeClasses"},
+		{DispatcherOptions{BatchMaxSize: 1, GroupBy: func(msg *core.Message) string { return "" }}, core.TransactionTypeBatchPin, nil, "FF10453.*GroupBy"},
+		{DispatcherOptions{BatchMaxSize: 1, MaxDispatchAttempts: 3}, core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypePrivate}, "FF10452"},
+		{DispatcherOptions{BatchMaxSize: 1, MaxDispatchAttempts: 3}, core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeGroupInit}, "FF10452"},
+	} {
+		err := validateDispatcherOptions(context.Background(), "utdispatcher", tc.txType, tc.msgTypes, &tc.options)
+		assert.Regexp(t, tc.errRE, err)
+		assert.Regexp(t, "utdispatcher", err)
+	}
+}
+
+func TestRegisterDispatcherInvalidOptions(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	err := bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeBroadcast},
+		func(c context.Context, state *DispatchState) error {
+			return nil
+		},
+		DispatcherOptions{},
+	)
+	assert.Regexp(t, "FF10441", err)
+	assert.Empty(t, bm.dispatcherMap)
+}
+
+func TestRegisterPinnedPrivateDispatcherDeadLetter(t *testing.T) {
+	bm, cancel := newTestBatchManager(t)
+	defer cancel()
+	err := bm.RegisterDispatcher("utdispatcher", core.TransactionTypeBatchPin, []core.MessageType{core.MessageTypeGroupInit, core.MessageTypePrivate},
+		func(c context.Context, state *DispatchState) error {
+			return nil
+		},
+		DispatcherOptions{BatchMaxSize: 1, MaxDispatchAttempts: 3, DeadLetter: func(ctx context.Context, batch *core.Batch, err error) error {
+			return nil
+		}},
+	)
+	assert.Regexp(t, "FF10452", err)
+	assert.Empty(t, bm.dispatcherMap)
+}
@@ -0,0 +1,58 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+)
+
+// verifyDispatched reads back the batch, and the messages just marked dispatched, to check they were persisted
+// as expected. Messages of a pinned batch might already have been confirmed, which supersedes being sent.
+func (bp *batchProcessor) verifyDispatched(ctx context.Context, state *DispatchState, dispatched []*core.Message, msgIDs []driver.Value) error {
+	batch, err := bp.database.GetBatchByID(ctx, bp.bm.namespace, state.Persisted.ID)
+	if err != nil {
+		return err
+	}
+	if batch == nil || !batch.Hash.Equals(state.Persisted.Hash) {
+		return i18n.NewError(ctx, coremsgs.MsgBatchVerifyBatchMismatch, state.Persisted.ID)
+	}
+
+	fb := database.MessageQueryFactory.NewFilter(ctx)
+	msgs, _, err := bp.database.GetMessages(ctx, bp.bm.namespace, fb.In("id", msgIDs))
+	if err != nil {
+		return err
+	}
+	persisted := make(map[fftypes.UUID]*core.Message, len(msgs))
+	for _, msg := range msgs {
+		persisted[*msg.Header.ID] = msg
+	}
+	for _, d := range dispatched {
+		msg := persisted[*d.Header.ID]
+		if msg == nil || !msg.BatchID.Equals(state.Persisted.ID) ||
+			(msg.State != core.MessageStateConfirmed && (bp.conf.txType != core.TransactionTypeBatchPin || msg.State != core.MessageStateSent)) {
+			return i18n.NewError(ctx, coremsgs.MsgBatchVerifyMessageMismatch, d.Header.ID, state.Persisted.ID)
+		}
+	}
+	return nil
+}
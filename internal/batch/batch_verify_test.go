@@ -0,0 +1,124 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batch
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/datamocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestVerifyState() *DispatchState {
+	state := &DispatchState{
+		Persisted: core.BatchPersisted{
+			BatchHeader: core.BatchHeader{ID: fftypes.NewUUID()},
+			Hash:        fftypes.NewRandB32(),
+		},
+	}
+	state.Messages = []*core.Message{newTestBroadcastMessage(1001), newTestBroadcastMessage(1002)}
+	return state
+}
+
+func persistedCopies(state *DispatchState, msgState core.MessageState) []*core.Message {
+	msgs := make([]*core.Message, len(state.Messages))
+	for i, msg := range state.Messages {
+		msgs[i] = &core.Message{Header: msg.Header, BatchID: state.Persisted.ID, State: msgState}
+	}
+	return msgs
+}
+
+func TestVerifyReadBackMismatchFailsDispatch(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	bp.conf.VerifyReadBack = true
+	mockRunAsGroupPassthrough(mdi)
+	bp.data.(*datamocks.Manager).On("UpdateMessageIfCached", mock.Anything, mock.Anything).Return()
+	mdi.On("UpdateMessages", mock.Anything, "ns1", mock.Anything, mock.Anything).Return(nil)
+
+	state := newTestVerifyState()
+	mdi.On("GetBatchByID", mock.Anything, "ns1", state.Persisted.ID).Return(&state.Persisted, nil)
+
+	// The first read-back finds a message that was silently not updated
+	stale := persistedCopies(state, core.MessageStateSent)
+	stale[1].BatchID = nil
+	stale[1].State = core.MessageStateReady
+	mdi.On("GetMessages", mock.Anything, "ns1", mock.Anything).Return(stale, nil, nil).Once()
+	mdi.On("GetMessages", mock.Anything, "ns1", mock.Anything).Return(persistedCopies(state, core.MessageStateSent), nil, nil)
+
+	err := bp.markPayloadDispatched(state)
+	assert.NoError(t, err)
+
+	// The mismatch failed the transaction, so it was retried before the dispatch completed
+	mdi.AssertNumberOfCalls(t, "UpdateMessages", 2)
+	status := bp.status().Status
+	assert.Equal(t, int64(1), status.TotalErrors)
+	assert.Regexp(t, "FF10433.*"+state.Messages[1].Header.ID.String(), status.LastFlushError)
+}
+
+func TestVerifyReadBackConfirmedSupersedesSent(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	state := newTestVerifyState()
+	mdi.On("GetBatchByID", mock.Anything, "ns1", state.Persisted.ID).Return(&state.Persisted, nil)
+	mdi.On("GetMessages", mock.Anything, "ns1", mock.Anything).Return(persistedCopies(state, core.MessageStateConfirmed), nil, nil)
+
+	err := bp.verifyDispatched(bp.ctx, state, state.Messages, nil)
+	assert.NoError(t, err)
+}
+
+func TestVerifyReadBackMissingMessage(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	state := newTestVerifyState()
+	mdi.On("GetBatchByID", mock.Anything, "ns1", state.Persisted.ID).Return(&state.Persisted, nil)
+	mdi.On("GetMessages", mock.Anything, "ns1", mock.Anything).Return(persistedCopies(state, core.MessageStateSent)[:1], nil, nil)
+
+	err := bp.verifyDispatched(bp.ctx, state, state.Messages, nil)
+	assert.Regexp(t, "FF10433", err)
+}
+
+func TestVerifyReadBackBatchMismatch(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	state := newTestVerifyState()
+	mdi.On("GetBatchByID", mock.Anything, "ns1", state.Persisted.ID).Return(&core.BatchPersisted{Hash: fftypes.NewRandB32()}, nil).Once()
+	mdi.On("GetBatchByID", mock.Anything, "ns1", state.Persisted.ID).Return(nil, nil).Once()
+
+	err := bp.verifyDispatched(bp.ctx, state, state.Messages, nil)
+	assert.Regexp(t, "FF10432", err)
+	err = bp.verifyDispatched(bp.ctx, state, state.Messages, nil)
+	assert.Regexp(t, "FF10432", err)
+}
+
+func TestVerifyReadBackQueryFail(t *testing.T) {
+	cancel, mdi, bp := newTestBatchProcessor(t, nil)
+	defer cancel()
+	state := newTestVerifyState()
+	mdi.On("GetBatchByID", mock.Anything, "ns1", state.Persisted.ID).Return(nil, fmt.Errorf("pop")).Once()
+	mdi.On("GetBatchByID", mock.Anything, "ns1", state.Persisted.ID).Return(&state.Persisted, nil)
+	mdi.On("GetMessages", mock.Anything, "ns1", mock.Anything).Return(nil, nil, fmt.Errorf("pop"))
+
+	err := bp.verifyDispatched(bp.ctx, state, state.Messages, nil)
+	assert.EqualError(t, err, "pop")
+	err = bp.verifyDispatched(bp.ctx, state, state.Messages, nil)
+	assert.EqualError(t, err, "pop")
+}
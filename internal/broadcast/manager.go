@@ -97,20 +97,25 @@ func NewBroadcastManager(ctx context.Context, ns *core.Namespace, di database.Pl
 
 	if ba != nil && mult != nil {
 		bo := batch.DispatcherOptions{
-			BatchType:      core.BatchTypeBroadcast,
-			BatchMaxSize:   config.GetUint(coreconfig.BroadcastBatchSize),
-			BatchMaxBytes:  bm.maxBatchPayloadLength,
-			BatchTimeout:   config.GetDuration(coreconfig.BroadcastBatchTimeout),
-			DisposeTimeout: config.GetDuration(coreconfig.BroadcastBatchAgentTimeout),
+			BatchType:            core.BatchTypeBroadcast,
+			BatchMaxSize:         config.GetUint(coreconfig.BroadcastBatchSize),
+			BatchMaxBytes:        bm.maxBatchPayloadLength,
+			BatchTimeout:         config.GetDuration(coreconfig.BroadcastBatchTimeout),
+			DisposeTimeout:       config.GetDuration(coreconfig.BroadcastBatchAgentTimeout),
+			PriorityBatchMaxSize: config.GetUint(coreconfig.BroadcastBatchPrioritySize),
+			PriorityBatchTimeout: config.GetDuration(coreconfig.BroadcastBatchPriorityTimeout),
 		}
 
-		ba.RegisterDispatcher(broadcastDispatcherName,
+		err := ba.RegisterDispatcher(broadcastDispatcherName,
 			core.TransactionTypeBatchPin,
 			[]core.MessageType{
 				core.MessageTypeBroadcast,
 				core.MessageTypeDefinition,
 				core.MessageTypeTransferBroadcast,
 			}, bm.dispatchBatch, bo)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	om.RegisterHandler(ctx, bm, []core.OpType{
@@ -67,7 +67,7 @@ func newTestBroadcastCommon(t *testing.T, metricsEnabled bool) (*broadcastManage
 			core.MessageTypeBroadcast,
 			core.MessageTypeDefinition,
 			core.MessageTypeTransferBroadcast,
-		}, mock.Anything, mock.Anything).Return()
+		}, mock.Anything, mock.Anything).Return(nil)
 	mom.On("RegisterHandler", mock.Anything, mock.Anything, mock.Anything)
 
 	rag := mdi.On("RunAsGroup", mock.Anything, mock.Anything).Maybe()
@@ -100,6 +100,20 @@ func TestInitFail(t *testing.T) {
 	assert.Regexp(t, "FF10128", err)
 }
 
+func TestInitRegisterDispatcherFail(t *testing.T) {
+	coreconfig.Reset()
+	mbi := &blockchainmocks.Plugin{}
+	mba := &batchmocks.Manager{}
+	mbi.On("Name").Return("ut_blockchain").Maybe()
+	mba.On("RegisterDispatcher", broadcastDispatcherName, core.TransactionTypeBatchPin, mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("pop"))
+	ns := &core.Namespace{Name: "ns1", NetworkName: "ns1"}
+	_, err := NewBroadcastManager(context.Background(), ns, &databasemocks.Plugin{}, mbi, &dataexchangemocks.Plugin{}, &sharedstoragemocks.Plugin{},
+		&identitymanagermocks.Manager{}, &datamocks.Manager{}, mba, &syncasyncmocks.Bridge{}, &multipartymocks.Manager{},
+		&metricsmocks.Manager{}, &operationmocks.Manager{}, &txcommonmocks.Helper{})
+	assert.Regexp(t, "pop", err)
+	mba.AssertExpectations(t)
+}
+
 func TestName(t *testing.T) {
 	bm, cancel := newTestBroadcast(t)
 	defer cancel()
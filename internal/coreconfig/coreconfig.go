@@ -41,6 +41,8 @@ const (
 	NamespaceDefaultKey = "defaultKey"
 	// NamespaceAssetKeyNormalization mechanism to normalize keys before using them. Valid options: "blockchain_plugin" - use blockchain plugin (default), "none" - do not attempt normalization
 	NamespaceAssetKeyNormalization = "asset.manager.keyNormalization"
+	// NamespaceBatch contains the tuning of the batch manager for a namespace
+	NamespaceBatch = "batch"
 	// NamespaceMultiparty contains the multiparty configuration for a namespace
 	NamespaceMultiparty = "multiparty"
 	// NamespaceMultipartyEnabled specifies if multi-party mode is enabled for a namespace
@@ -80,12 +82,84 @@ var (
 	APIRequestMaxTimeout = ffc("api.requestMaxTimeout")
 	// APIOASPanicOnMissingDescription controls whether the OpenAPI Spec generator will strongly enforce descriptions on every field or not
 	APIOASPanicOnMissingDescription = ffc("api.oas.panicOnMissingDescription")
+	// BatchManagerAssemblyStallThreshold is the number of times assembly of a message can fail for missing data, before the message is reported as stalled
+	BatchManagerAssemblyStallThreshold = ffc("batch.manager.assemblyStall.threshold")
+	// BatchManagerAssemblyStallPersist is whether stalled messages are recorded in the database, so they can be queried
+	BatchManagerAssemblyStallPersist = ffc("batch.manager.assemblyStall.persist")
+	// BatchManagerAssemblyStallReportInterval is the minimum time between repeated stall reports for the same message
+	BatchManagerAssemblyStallReportInterval = ffc("batch.manager.assemblyStall.reportInterval")
+	// BatchManagerCheckpointInterval is how often the batch manager emits a checkpoint of its processing position. Zero disables checkpoints
+	BatchManagerCheckpointInterval = ffc("batch.manager.checkpoint.interval")
 	// BatchManagerReadPageSize is the size of each page of messages read from the database into memory when assembling batches
 	BatchManagerReadPageSize = ffc("batch.manager.readPageSize")
 	// BatchManagerReadPollTimeout is how long without any notifications of new messages to wait, before doing a page query
 	BatchManagerReadPollTimeout = ffc("batch.manager.pollTimeout")
+	// BatchManagerReadPollJitter is the fraction of the poll timeout by which each poll is randomly brought forward or delayed, so nodes polling the same database do not synchronize
+	BatchManagerReadPollJitter = ffc("batch.manager.pollJitter")
 	// BatchManagerMinimumPollDelay is the minimum time the batch manager waits between polls on the DB - to prevent thrashing
 	BatchManagerMinimumPollDelay = ffc("batch.manager.minimumPollDelay")
+	// BatchManagerDataCacheMaxEntries is the maximum number of data entries cached while assembling each page of messages, so data referenced by many messages is read once. Zero disables the cache
+	BatchManagerDataCacheMaxEntries = ffc("batch.manager.dataCache.maxEntries")
+	// BatchManagerDataCacheTTL is how long data is retained in the data cache across pages. Zero clears the cache between pages
+	BatchManagerDataCacheTTL = ffc("batch.manager.dataCache.ttl")
+	// BatchManagerDataTimingEnabled is whether the time taken to retrieve each message and its data during assembly is measured, and reported in the status
+	BatchManagerDataTimingEnabled = ffc("batch.manager.dataTiming.enabled")
+	// BatchManagerDispatchBacklogHighWaterMark is the number of sealed batches waiting for dispatch, at which the batch manager stops reading messages until the backlog drains to the low-water mark. Zero disables the limit
+	BatchManagerDispatchBacklogHighWaterMark = ffc("batch.manager.dispatch.backlogHighWaterMark")
+	// BatchManagerDispatchBacklogLowWaterMark is the number of sealed batches waiting for dispatch, at or below which the batch manager resumes reading messages after reaching the high-water mark
+	BatchManagerDispatchBacklogLowWaterMark = ffc("batch.manager.dispatch.backlogLowWaterMark")
+	// BatchManagerDispatchConcurrency is the maximum number of batches dispatched concurrently, with the next batch chosen by the dispatch policy. Zero is unlimited
+	BatchManagerDispatchConcurrency = ffc("batch.manager.dispatch.concurrency")
+	// BatchManagerDispatchMaxQueuedBatches is the number of sealed batches held while dispatch is paused, after which assembly also pauses. Zero is unlimited
+	BatchManagerDispatchMaxQueuedBatches = ffc("batch.manager.dispatch.maxQueuedBatches")
+	// BatchManagerDispatchPolicy is how the next batch to dispatch is chosen, when dispatch concurrency is limited. Valid options: "fifo" (default), "roundRobin", "weighted"
+	BatchManagerDispatchPolicy = ffc("batch.manager.dispatch.policy")
+	// BatchManagerHealthMaxReadFailures is how many consecutive failures to read messages cause the batch manager to report itself unhealthy. Zero disables the check
+	BatchManagerHealthMaxReadFailures = ffc("batch.manager.health.maxReadFailures")
+	// BatchManagerHealthStaleness is how long the batch manager can go without completing a poll cycle before it reports itself unhealthy. Zero disables the check
+	BatchManagerHealthStaleness = ffc("batch.manager.health.staleness")
+	// BatchManagerInterleavePolicy is how dispatch of a page of messages is interleaved across message types. Valid options: "sequence" (default), "roundRobin", "weighted"
+	BatchManagerInterleavePolicy = ffc("batch.manager.interleave.policy")
+	// BatchManagerInterleaveWeights is a map of message type to the number of messages of that type dispatched per turn, for the weighted interleave policy
+	BatchManagerInterleaveWeights = ffc("batch.manager.interleave.weights")
+	// BatchManagerIterationBudget is the wall-clock time budget for each iteration of the message sequencer, after which it yields even if more work remains. Zero is unlimited
+	BatchManagerIterationBudget = ffc("batch.manager.iterationBudget")
+	// BatchManagerLazyData is whether batches are assembled with only references to the message data, with the values loaded only if the dispatch handler resolves them
+	BatchManagerLazyData = ffc("batch.manager.lazyData")
+	// BatchManagerMaxConcurrentTransactions is the maximum number of database transactions the batch manager runs concurrently
+	BatchManagerMaxConcurrentTransactions = ffc("batch.manager.maxConcurrentTransactions")
+	// BatchManagerMode is whether the batch manager assembles and dispatches batches. Valid options: "all" - both (default), "assemble" - only assemble, "dispatch" - only claim and dispatch assembled batches
+	BatchManagerMode = ffc("batch.manager.mode")
+	// BatchManagerNotificationsBufferSize is the number of new message notifications buffered for the batch manager, before they are merged according to the policy
+	BatchManagerNotificationsBufferSize = ffc("batch.manager.notifications.bufferSize")
+	// BatchManagerNotificationsPolicy is how a new message notification that arrives when the buffer is full is handled. Valid options: "coalesce" (default), "dropOldest"
+	BatchManagerNotificationsPolicy = ffc("batch.manager.notifications.policy")
+	// BatchManagerOffsetEnabled is whether the batch manager persists its read offset, to resume from on restart
+	BatchManagerOffsetEnabled = ffc("batch.manager.offset.enabled")
+	// BatchManagerOffsetCommitAsync is whether offset commits happen on a dedicated goroutine, decoupled from dispatch
+	BatchManagerOffsetCommitAsync = ffc("batch.manager.offset.commitAsync")
+	// BatchManagerOffsetCommitInterval is the minimum time between offset commits, with progress in between coalesced. Zero commits on every change
+	BatchManagerOffsetCommitInterval = ffc("batch.manager.offset.commitInterval")
+	// BatchManagerOffsetCommitMessages is how far the offset can advance before it is committed, regardless of the commit interval. Zero disables the limit
+	BatchManagerOffsetCommitMessages = ffc("batch.manager.offset.commitMessages")
+	// BatchManagerOffsetCompactionInterval is how often the batch manager prunes historical rows for its offset. Zero disables compaction
+	BatchManagerOffsetCompactionInterval = ffc("batch.manager.offset.compactionInterval")
+	// BatchManagerOffsetResumeFrom is where the batch manager resumes reading on start. Valid options: "offset" - the persisted offset (default), "lastBatch" - the highest sequence in the last dispatched batch
+	BatchManagerOffsetResumeFrom = ffc("batch.manager.offset.resumeFrom")
+	// BatchManagerOffsetAheadCheck is what the batch manager does on start if its offset is ahead of the highest message sequence. Valid options: "warn" (default), "clamp", "off"
+	BatchManagerOffsetAheadCheck = ffc("batch.manager.offset.aheadCheck")
+	// BatchManagerOffsetStartupTimeout bounds the time the batch manager retries restoring its offset on start, before start fails. Zero is unbounded
+	BatchManagerOffsetStartupTimeout = ffc("batch.manager.offset.startupTimeout")
+	// BatchManagerOnUnknownType is what the batch manager does with a message whose type has no registered dispatcher. Valid options: "fail" (default), "skip", "defer"
+	BatchManagerOnUnknownType = ffc("batch.manager.onUnknownType")
+	// BatchManagerPersistDispatcherOptions is whether the options of each dispatcher are persisted on start, with a warning logged if they changed since the last run
+	BatchManagerPersistDispatcherOptions = ffc("batch.manager.persistDispatcherOptions")
+	// BatchManagerRecoveryEnabled is whether messages left in-flight in a batch are rebuilt into new batches on start
+	BatchManagerRecoveryEnabled = ffc("batch.manager.recovery.enabled")
+	// BatchManagerReplayConcurrency is the maximum number of batches replayed to the handler concurrently
+	BatchManagerReplayConcurrency = ffc("batch.manager.replay.concurrency")
+	// BatchManagerVerifyDataHash is whether the hash of each data item is verified against the message as it is assembled, skipping messages that do not match
+	BatchManagerVerifyDataHash = ffc("batch.manager.verifyDataHash")
 	// BatchRetryFactor is the retry backoff factor for database operations performed by the batch manager
 	BatchRetryFactor = ffc("batch.retry.factor")
 	// BatchRetryInitDelay is the retry initial delay for database operations
@@ -113,6 +187,10 @@ var (
 	BroadcastBatchPayloadLimit = ffc("broadcast.batch.payloadLimit")
 	// BroadcastBatchTimeout is the timeout to wait for a batch to fill, before sending
 	BroadcastBatchTimeout = ffc("broadcast.batch.timeout")
+	// BroadcastBatchPrioritySize is the maximum number of high priority messages that can be packed into a batch
+	BroadcastBatchPrioritySize = ffc("broadcast.batch.prioritySize")
+	// BroadcastBatchPriorityTimeout is the timeout to wait for a batch of high priority messages to fill, before sending
+	BroadcastBatchPriorityTimeout = ffc("broadcast.batch.priorityTimeout")
 
 	// CacheEnabled determines whether cache will be enabled or not, default to true
 	CacheEnabled = ffc("cache.enabled")
@@ -333,7 +411,42 @@ func setDefaults() {
 	viper.SetDefault(string(CacheBatchTTL), "5m")
 	viper.SetDefault(string(BatchManagerReadPageSize), 100)
 	viper.SetDefault(string(BatchManagerReadPollTimeout), "30s")
+	viper.SetDefault(string(BatchManagerReadPollJitter), 0.1)
 	viper.SetDefault(string(BatchManagerMinimumPollDelay), "100ms")
+	viper.SetDefault(string(BatchManagerAssemblyStallThreshold), 3)
+	viper.SetDefault(string(BatchManagerAssemblyStallPersist), false)
+	viper.SetDefault(string(BatchManagerAssemblyStallReportInterval), "5m")
+	viper.SetDefault(string(BatchManagerCheckpointInterval), "0s")
+	viper.SetDefault(string(BatchManagerDataCacheMaxEntries), 100)
+	viper.SetDefault(string(BatchManagerDataCacheTTL), "0s")
+	viper.SetDefault(string(BatchManagerDataTimingEnabled), false)
+	viper.SetDefault(string(BatchManagerDispatchBacklogHighWaterMark), 0)
+	viper.SetDefault(string(BatchManagerDispatchBacklogLowWaterMark), 0)
+	viper.SetDefault(string(BatchManagerDispatchConcurrency), 0)
+	viper.SetDefault(string(BatchManagerDispatchMaxQueuedBatches), 100)
+	viper.SetDefault(string(BatchManagerDispatchPolicy), "fifo")
+	viper.SetDefault(string(BatchManagerHealthMaxReadFailures), 5)
+	viper.SetDefault(string(BatchManagerHealthStaleness), "2m")
+	viper.SetDefault(string(BatchManagerInterleavePolicy), "sequence")
+	viper.SetDefault(string(BatchManagerIterationBudget), "0s")
+	viper.SetDefault(string(BatchManagerLazyData), false)
+	viper.SetDefault(string(BatchManagerMaxConcurrentTransactions), 0)
+	viper.SetDefault(string(BatchManagerMode), "all")
+	viper.SetDefault(string(BatchManagerNotificationsBufferSize), 1000)
+	viper.SetDefault(string(BatchManagerNotificationsPolicy), "coalesce")
+	viper.SetDefault(string(BatchManagerOffsetEnabled), false)
+	viper.SetDefault(string(BatchManagerOffsetCommitAsync), false)
+	viper.SetDefault(string(BatchManagerOffsetCommitInterval), "0s")
+	viper.SetDefault(string(BatchManagerOffsetCommitMessages), 0)
+	viper.SetDefault(string(BatchManagerOffsetCompactionInterval), "0s")
+	viper.SetDefault(string(BatchManagerOffsetResumeFrom), "offset")
+	viper.SetDefault(string(BatchManagerOffsetAheadCheck), "warn")
+	viper.SetDefault(string(BatchManagerOffsetStartupTimeout), "0s")
+	viper.SetDefault(string(BatchManagerOnUnknownType), "fail")
+	viper.SetDefault(string(BatchManagerPersistDispatcherOptions), false)
+	viper.SetDefault(string(BatchManagerRecoveryEnabled), false)
+	viper.SetDefault(string(BatchManagerReplayConcurrency), 5)
+	viper.SetDefault(string(BatchManagerVerifyDataHash), false)
 	viper.SetDefault(string(BatchRetryFactor), 2.0)
 	viper.SetDefault(string(BatchRetryFactor), 2.0)
 	viper.SetDefault(string(BatchRetryInitDelay), "250ms")
@@ -352,6 +465,8 @@ func setDefaults() {
 	viper.SetDefault(string(BroadcastBatchSize), 200)
 	viper.SetDefault(string(BroadcastBatchPayloadLimit), "800Kb")
 	viper.SetDefault(string(BroadcastBatchTimeout), "1s")
+	viper.SetDefault(string(BroadcastBatchPrioritySize), 10)
+	viper.SetDefault(string(BroadcastBatchPriorityTimeout), "50ms")
 	viper.SetDefault(string(CacheBlockchainLimit), 100)
 	viper.SetDefault(string(CacheBlockchainTTL), "5m")
 	viper.SetDefault(string(CacheAddressResolverLimit), 1000)
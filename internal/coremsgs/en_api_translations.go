@@ -121,6 +121,9 @@ var (
 	APIEndpointsGetOpByID                       = ffm("api.endpoints.getOpByID", "Gets an operation by ID")
 	APIEndpointsGetOps                          = ffm("api.endpoints.getOps", "Gets a a list of operations")
 	APIEndpointsGetStatusBatchManager           = ffm("api.endpoints.getStatusBatchManager", "Gets the status of the batch manager")
+	APIEndpointsGetStatusAssemblyFailures       = ffm("api.endpoints.getStatusBatchManagerAssemblyFailures", "Gets the messages recorded as stalled in batch assembly because their data has not arrived, if persistence of assembly failures is enabled")
+	APIEndpointsGetStatusDispatchers            = ffm("api.endpoints.getStatusBatchManagerDispatchers", "Gets the dispatchers registered with the batch manager, with the options that determine their batches")
+	APIEndpointsGetStatusBatchManagerDryRun     = ffm("api.endpoints.getStatusBatchManagerDryRun", "Gets histograms of the sizes and assembly times of the batches assembled by dispatchers in dry-run mode")
 	APIEndpointsGetPins                         = ffm("api.endpoints.getPins", "Queries the list of pins received from the blockchain")
 	APIEndpointsGetWebSockets                   = ffm("api.endpoints.getStatusWebSockets", "Gets a list of the current WebSocket connections to this node")
 	APIEndpointsGetStatus                       = ffm("api.endpoints.getStatus", "Gets the status of this namespace")
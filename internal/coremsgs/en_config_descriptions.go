@@ -44,9 +44,45 @@ var (
 	ConfigAPIRequestMaxTimeout         = ffc("config.api.requestMaxTimeout", "The maximum amount of time that an HTTP client can specify in a `Request-Timeout` header to keep a specific request open", i18n.TimeDurationType)
 	ConfigAssetManagerKeyNormalization = ffc("config.asset.manager.keyNormalization", "Mechanism to normalize keys before using them. Valid options are `blockchain_plugin` - use blockchain plugin (default) or `none` - do not attempt normalization (deprecated - use namespaces.predefined[].asset.manager.keyNormalization)", i18n.StringType)
 
-	ConfigBatchManagerMinimumPollDelay = ffc("config.batch.manager.minimumPollDelay", "The minimum time the batch manager waits between polls on the DB - to prevent thrashing", i18n.TimeDurationType)
-	ConfigBatchManagerPollTimeout      = ffc("config.batch.manager.pollTimeout", "How long to wait without any notifications of new messages before doing a page query", i18n.TimeDurationType)
-	ConfigBatchManagerReadPageSize     = ffc("config.batch.manager.readPageSize", "The size of each page of messages read from the database into memory when assembling batches", i18n.IntType)
+	ConfigBatchManagerAssemblyStallThreshold       = ffc("config.batch.manager.assemblyStall.threshold", "The number of times assembly of a message can fail because its data has not arrived, before the message is reported to the assembly stall callback", i18n.IntType)
+	ConfigBatchManagerAssemblyStallPersist         = ffc("config.batch.manager.assemblyStall.persist", "Whether messages that have stalled in assembly are recorded in the database, with their missing data and number of attempts, so they can be queried. A record is updated on each further attempt, and removed once the message is assembled", i18n.BooleanType)
+	ConfigBatchManagerAssemblyStallReportInterval  = ffc("config.batch.manager.assemblyStall.reportInterval", "The minimum time between repeated reports to the assembly stall callback for the same stalled message", i18n.TimeDurationType)
+	ConfigBatchManagerCheckpointInterval           = ffc("config.batch.manager.checkpoint.interval", "How often the batch manager emits a checkpoint event with its current processing offset, even when no batches are being dispatched. A value of 0 disables checkpoints", i18n.TimeDurationType)
+	ConfigBatchManagerDataCacheMaxEntries          = ffc("config.batch.manager.dataCache.maxEntries", "The maximum number of data entries cached while assembling each page of messages read, so data referenced by many messages in the page is only read once. The cache is cleared between pages, unless a TTL is set. A value of 0 disables the cache", i18n.IntType)
+	ConfigBatchManagerDataCacheTTL                 = ffc("config.batch.manager.dataCache.ttl", "How long data is retained in the data cache, so that data referenced by consecutive pages is only read once. When full, the least recently used data is evicted. Cached data is read again if a message references it with a different hash. A value of 0 clears the cache between pages", i18n.TimeDurationType)
+	ConfigBatchManagerDataTimingEnabled            = ffc("config.batch.manager.dataTiming.enabled", "Whether the time taken to retrieve each message with its data during assembly is measured, and reported per page in the status of the batch manager - to diagnose whether data resolution is the cause of slow assembly. Disabled by default to avoid the overhead", i18n.BooleanType)
+	ConfigBatchManagerDispatchBacklogHighWaterMark = ffc("config.batch.manager.dispatch.backlogHighWaterMark", "The number of sealed batches waiting to be dispatched, at which the batch manager stops reading new messages - bounding the memory held when dispatch is slower than assembly. Batches already open can still be flushed, so the backlog might exceed the mark by the number of batch processors. A value of 0 disables the limit", i18n.IntType)
+	ConfigBatchManagerDispatchBacklogLowWaterMark  = ffc("config.batch.manager.dispatch.backlogLowWaterMark", "The number of sealed batches waiting to be dispatched, at or below which the batch manager resumes reading messages after the backlog reached the high-water mark. Must be less than the high-water mark", i18n.IntType)
+	ConfigBatchManagerDispatchConcurrency          = ffc("config.batch.manager.dispatch.concurrency", "The maximum number of batches dispatched concurrently across all grouping keys. When limited, the next batch to dispatch is chosen by the dispatch policy. A value of 0 is unlimited", i18n.IntType)
+	ConfigBatchManagerDispatchMaxQueuedBatches     = ffc("config.batch.manager.dispatch.maxQueuedBatches", "The number of sealed batches persisted to be dispatched later while dispatch is paused, after which assembly also pauses - so no more messages are read until dispatch resumes. Open batches can still be flushed when the limit is reached, so it might be exceeded by the number of batch processors. A value of 0 is unlimited", i18n.IntType)
+	ConfigBatchManagerDispatchPolicy               = ffc("config.batch.manager.dispatch.policy", "How the next ready batch to dispatch is chosen, when dispatch concurrency is limited. Valid options are `fifo` - in the order batches were sealed, `roundRobin` - each grouping key with a ready batch in turn, or `weighted` - round-robin, but with each key dispatching up to its weight of batches per turn", i18n.StringType)
+	ConfigBatchManagerHealthMaxReadFailures        = ffc("config.batch.manager.health.maxReadFailures", "How many consecutive failures to read messages from the database cause the batch manager to report itself unhealthy. A value of 0 disables the check", i18n.IntType)
+	ConfigBatchManagerHealthStaleness              = ffc("config.batch.manager.health.staleness", "How long the batch manager can go without completing a poll cycle before it reports itself unhealthy. This must be longer than the poll timeout, as an idle batch manager completes a cycle each time it polls. Time spent paused is not counted. A value of 0 disables the check", i18n.TimeDurationType)
+	ConfigBatchManagerInterleavePolicy             = ffc("config.batch.manager.interleave.policy", "How the dispatch of each page of messages read is interleaved across message types. Valid options are `sequence` - strictly in sequence order, `roundRobin` - one message of each type in turn, or `weighted` - each type in turn, dispatching up to its weight of messages per turn. Messages of a type are always dispatched in sequence order, and never ahead of an earlier message of another type that shares a topic", i18n.StringType)
+	ConfigBatchManagerInterleaveWeights            = ffc("config.batch.manager.interleave.weights", "A map of message type to its weight for the `weighted` interleave policy - the number of messages of that type dispatched per turn. Types without a weight have a weight of 1", i18n.MapStringStringType)
+	ConfigBatchManagerIterationBudget              = ffc("config.batch.manager.iterationBudget", "The wall-clock time budget for each iteration of the message sequencer, covering the read, assembly and dispatch of a page of messages. When exceeded part way through a page, the sequencer yields to check for shutdown and rewinds, before continuing with the rest of the page. A value of 0 is unlimited", i18n.TimeDurationType)
+	ConfigBatchManagerLazyData                     = ffc("config.batch.manager.lazyData", "Whether batches are assembled holding only references to the data of their messages, rather than the full values. The values are then only loaded if the dispatch handler resolves them, reducing the memory used for large payloads. Messages are assembled without waiting for their data to arrive, so dispatch is retried until the data can be resolved", i18n.BooleanType)
+	ConfigBatchManagerMaxConcurrentTransactions    = ffc("config.batch.manager.maxConcurrentTransactions", "The maximum number of database transactions the batch manager runs concurrently when sealing and dispatching batches. A value of 0 is unlimited", i18n.IntType)
+	ConfigBatchManagerMinimumPollDelay             = ffc("config.batch.manager.minimumPollDelay", "The minimum time the batch manager waits between polls on the DB - to prevent thrashing", i18n.TimeDurationType)
+	ConfigBatchManagerMode                         = ffc("config.batch.manager.mode", "Whether this process assembles and dispatches batches. Valid options are `all` - assemble and dispatch, `assemble` - only assemble and persist batches, or `dispatch` - only claim and dispatch batches persisted by an assembling process", i18n.StringType)
+	ConfigBatchManagerNotificationsBufferSize      = ffc("config.batch.manager.notifications.bufferSize", "The number of new message notifications buffered for the batch manager, so notifying it of new messages never blocks message insertion. Notifications for a sequence already buffered are always coalesced", i18n.IntType)
+	ConfigBatchManagerNotificationsPolicy          = ffc("config.batch.manager.notifications.policy", "How a new message notification that arrives when the buffer is full is handled. Valid options are `coalesce` - merge it into the newest buffered notification, or `dropOldest` - drop the oldest buffered notification, merging it into the next. As the notifications are only a wake-up, no notification is lost by merging", i18n.StringType)
+	ConfigBatchManagerOffsetAheadCheck             = ffc("config.batch.manager.offset.aheadCheck", "What the batch manager does on start if its persisted offset is ahead of the highest message sequence, such as after a partial restore from backup, when it would otherwise wait for messages that never arrive. Valid options are `warn` - log a warning, `clamp` - log a warning and move the offset back to the highest message sequence, or `off`", i18n.StringType)
+	ConfigBatchManagerOffsetCommitAsync            = ffc("config.batch.manager.offset.commitAsync", "Whether offset commits happen on a dedicated goroutine, decoupled from the dispatch path. Commits are still monotonic, but a restart might re-read a bounded window of messages", i18n.BooleanType)
+	ConfigBatchManagerOffsetCommitInterval         = ffc("config.batch.manager.offset.commitInterval", "The minimum time between commits of the offset, with any progress in between coalesced into a single commit. Setting this, or commitMessages, implies commitAsync. A value of 0 commits on every change", i18n.TimeDurationType)
+	ConfigBatchManagerOffsetCommitMessages         = ffc("config.batch.manager.offset.commitMessages", "How many sequences the offset can advance beyond the last commit, before it is committed regardless of the commit interval. Setting this, or commitInterval, implies commitAsync. A value of 0 disables the limit", i18n.IntType)
+	ConfigBatchManagerOffsetCompactionInterval     = ffc("config.batch.manager.offset.compactionInterval", "How often the batch manager prunes any historical rows for its persisted offset, retaining only the latest committed offset. A value of 0 disables compaction", i18n.TimeDurationType)
+	ConfigBatchManagerOffsetEnabled                = ffc("config.batch.manager.offset.enabled", "Whether the batch manager persists its read offset, so that on restart it resumes from that offset rather than re-reading all ready messages", i18n.BooleanType)
+	ConfigBatchManagerOffsetResumeFrom             = ffc("config.batch.manager.offset.resumeFrom", "Where the batch manager resumes reading messages on start. Valid options are `offset` - the persisted offset, or `lastBatch` - the highest sequence message in the last batch dispatched by the local node. When both are available any discrepancy between them is logged", i18n.StringType)
+	ConfigBatchManagerOffsetStartupTimeout         = ffc("config.batch.manager.offset.startupTimeout", "The longest the batch manager retries restoring its persisted offset on start, such as while the database is unavailable, before start fails with an error - so the process can exit and be restarted rather than hang. The number of attempts is also bounded by orchestrator.startupAttempts. A value of 0 retries without a time limit", i18n.TimeDurationType)
+	ConfigBatchManagerOnUnknownType                = ffc("config.batch.manager.onUnknownType", "What the batch manager does with a message whose type has no registered dispatcher. Valid options are `fail` - log an error and hold the offset at the message, reading it again after the read poll timeout, `skip` - move past the message without error, or `defer` - hold the offset at the message, and read it again when a dispatcher for its type is registered", i18n.StringType)
+	ConfigBatchManagerPersistDispatcherOptions     = ffc("config.batch.manager.persistDispatcherOptions", "Whether the batch manager persists the options each dispatcher is registered with on start, logging a warning if they differ from the options recorded on the last run. A mismatch, or a failure to persist the options, does not block startup", i18n.BooleanType)
+	ConfigBatchManagerPollJitter                   = ffc("config.batch.manager.pollJitter", "The fraction of the poll timeout, between 0 and 1, by which each poll is randomly brought forward or delayed - so that several nodes polling the same database do not synchronize their queries. Zero disables the jitter", i18n.FloatType)
+	ConfigBatchManagerPollTimeout                  = ffc("config.batch.manager.pollTimeout", "How long to wait without any notifications of new messages before doing a page query", i18n.TimeDurationType)
+	ConfigBatchManagerReadPageSize                 = ffc("config.batch.manager.readPageSize", "The size of each page of messages read from the database into memory when assembling batches", i18n.IntType)
+	ConfigBatchManagerRecoveryEnabled              = ffc("config.batch.manager.recovery.enabled", "Whether messages are marked as batching while their batch is dispatched, so that on start any left in-flight by a crash are rebuilt into new batches and dispatched", i18n.BooleanType)
+	ConfigBatchManagerReplayConcurrency            = ffc("config.batch.manager.replay.concurrency", "The maximum number of persisted batches passed to the handler concurrently, when replaying batches. Values below 1 are treated as 1", i18n.IntType)
+	ConfigBatchManagerVerifyDataHash               = ffc("config.batch.manager.verifyDataHash", "Whether the data of each message is checked against the hashes in the message as it is assembled - recomputing the hash of each value and blob reference. Messages that do not match are skipped and counted as integrity errors, rather than dispatched. Not applied when lazyData is enabled", i18n.BooleanType)
 
 	ConfigBlobreceiverWorkerBatchMaxInserts = ffc("config.blobreceiver.worker.batchMaxInserts", "The maximum number of items the blob receiver worker will insert in a batch", i18n.IntType)
 	ConfigBlobreceiverWorkerBatchTimeout    = ffc("config.blobreceiver.worker.batchTimeout", "The maximum amount of the the blob receiver worker will wait", i18n.TimeDurationType)
@@ -179,10 +215,12 @@ var (
 	ConfigPluginBlockchainFabricFabconnectChaincode    = ffc("config.plugins.blockchain[].fabric.fabconnect.chaincode", "The name of the Fabric chaincode that FireFly will use for BatchPin transactions (deprecated - use fireflyContract[].chaincode)", i18n.StringType)
 	ConfigPluginBlockchainFabricFabconnectChannel      = ffc("config.plugins.blockchain[].fabric.fabconnect.channel", "The Fabric channel that FireFly will use for BatchPin transactions", i18n.StringType)
 
-	ConfigBroadcastBatchAgentTimeout = ffc("config.broadcast.batch.agentTimeout", "How long to keep around a batching agent for a sending identity before disposal", i18n.StringType)
-	ConfigBroadcastBatchPayloadLimit = ffc("config.broadcast.batch.payloadLimit", "The maximum payload size of a batch for broadcast messages", i18n.ByteSizeType)
-	ConfigBroadcastBatchSize         = ffc("config.broadcast.batch.size", "The maximum number of messages that can be packed into a batch", i18n.IntType)
-	ConfigBroadcastBatchTimeout      = ffc("config.broadcast.batch.timeout", "The timeout to wait for a batch to fill, before sending", i18n.TimeDurationType)
+	ConfigBroadcastBatchAgentTimeout    = ffc("config.broadcast.batch.agentTimeout", "How long to keep around a batching agent for a sending identity before disposal", i18n.StringType)
+	ConfigBroadcastBatchPayloadLimit    = ffc("config.broadcast.batch.payloadLimit", "The maximum payload size of a batch for broadcast messages", i18n.ByteSizeType)
+	ConfigBroadcastBatchPrioritySize    = ffc("config.broadcast.batch.prioritySize", "The maximum number of high priority messages that can be packed into a batch. High priority messages are assembled into separate batches from other messages", i18n.IntType)
+	ConfigBroadcastBatchPriorityTimeout = ffc("config.broadcast.batch.priorityTimeout", "The timeout to wait for a batch of high priority messages to fill, before sending", i18n.TimeDurationType)
+	ConfigBroadcastBatchSize            = ffc("config.broadcast.batch.size", "The maximum number of messages that can be packed into a batch", i18n.IntType)
+	ConfigBroadcastBatchTimeout         = ffc("config.broadcast.batch.timeout", "The timeout to wait for a batch to fill, before sending", i18n.TimeDurationType)
 
 	ConfigDatabaseType = ffc("config.database.type", "The type of the database interface plugin to use", i18n.IntType)
 
@@ -275,23 +313,33 @@ var (
 	ConfigMetricsReadTimeout  = ffc("config.metrics.readTimeout", "The maximum time to wait when reading from an HTTP connection", i18n.TimeDurationType)
 	ConfigMetricsWriteTimeout = ffc("config.metrics.writeTimeout", "The maximum time to wait when writing to an HTTP connection", i18n.TimeDurationType)
 
-	ConfigNamespacesDefault                      = ffc("config.namespaces.default", "The default namespace - must be in the predefined list", i18n.StringType)
-	ConfigNamespacesPredefined                   = ffc("config.namespaces.predefined", "A list of namespaces to ensure exists, without requiring a broadcast from the network", "List "+i18n.StringType)
-	ConfigNamespacesPredefinedName               = ffc("config.namespaces.predefined[].name", "The name of the namespace (must be unique)", i18n.StringType)
-	ConfigNamespacesPredefinedDescription        = ffc("config.namespaces.predefined[].description", "A description for the namespace", i18n.StringType)
-	ConfigNamespacesPredefinedPlugins            = ffc("config.namespaces.predefined[].plugins", "The list of plugins for this namespace", i18n.StringType)
-	ConfigNamespacesPredefinedDefaultKey         = ffc("config.namespaces.predefined[].defaultKey", "A default signing key for blockchain transactions within this namespace", i18n.StringType)
-	ConfigNamespacesPredefinedKeyNormalization   = ffc("config.namespaces.predefined[].asset.manager.keyNormalization", "Mechanism to normalize keys before using them. Valid options are `blockchain_plugin` - use blockchain plugin (default) or `none` - do not attempt normalization", i18n.StringType)
-	ConfigNamespacesMultipartyEnabled            = ffc("config.namespaces.predefined[].multiparty.enabled", "Enables multi-party mode for this namespace (defaults to true if an org name or key is configured, either here or at the root level)", i18n.BooleanType)
-	ConfigNamespacesMultipartyNetworkNamespace   = ffc("config.namespaces.predefined[].multiparty.networknamespace", "The shared namespace name to be sent in multiparty messages, if it differs from the local namespace name", i18n.StringType)
-	ConfigNamespacesMultipartyOrgName            = ffc("config.namespaces.predefined[].multiparty.org.name", "A short name for the local root organization within this namespace", i18n.StringType)
-	ConfigNamespacesMultipartyOrgDesc            = ffc("config.namespaces.predefined[].multiparty.org.description", "A description for the local root organization within this namespace", i18n.StringType)
-	ConfigNamespacesMultipartyOrgKey             = ffc("config.namespaces.predefined[].multiparty.org.key", "The signing key allocated to the root organization within this namespace", i18n.StringType)
-	ConfigNamespacesMultipartyNodeName           = ffc("config.namespaces.predefined[].multiparty.node.name", "The node name for this namespace", i18n.StringType)
-	ConfigNamespacesMultipartyNodeDescription    = ffc("config.namespaces.predefined[].multiparty.node.description", "A description for the node in this namespace", i18n.StringType)
-	ConfigNamespacesMultipartyContract           = ffc("config.namespaces.predefined[].contract", "A list containing configuration for the multi-party blockchain contract", i18n.StringType)
-	ConfigNamespacesMultipartyContractFirstEvent = ffc("config.namespaces.predefined[].multiparty.contract[].firstEvent", "The first event the contract should process. Valid options are `oldest` or `newest`", i18n.StringType)
-	ConfigNamespacesMultipartyContractLocation   = ffc("config.namespaces.predefined[].multiparty.contract[].location", "A blockchain-specific contract location. For example, an Ethereum contract address, or a Fabric chaincode name and channe", i18n.StringType)
+	ConfigNamespacesDefault                       = ffc("config.namespaces.default", "The default namespace - must be in the predefined list", i18n.StringType)
+	ConfigNamespacesPredefined                    = ffc("config.namespaces.predefined", "A list of namespaces to ensure exists, without requiring a broadcast from the network", "List "+i18n.StringType)
+	ConfigNamespacesPredefinedName                = ffc("config.namespaces.predefined[].name", "The name of the namespace (must be unique)", i18n.StringType)
+	ConfigNamespacesPredefinedDescription         = ffc("config.namespaces.predefined[].description", "A description for the namespace", i18n.StringType)
+	ConfigNamespacesPredefinedPlugins             = ffc("config.namespaces.predefined[].plugins", "The list of plugins for this namespace", i18n.StringType)
+	ConfigNamespacesPredefinedDefaultKey          = ffc("config.namespaces.predefined[].defaultKey", "A default signing key for blockchain transactions within this namespace", i18n.StringType)
+	ConfigNamespacesBatchMinimumPollDelay         = ffc("config.namespaces.predefined[].batch.minimumPollDelay", "The minimum time the batch manager of this namespace waits between polls on the DB. Defaults to batch.manager.minimumPollDelay", i18n.TimeDurationType)
+	ConfigNamespacesBatchOffsetName               = ffc("config.namespaces.predefined[].batch.offsetName", "The name of the persisted offset of the batch manager of this namespace, used as given. Defaults to the offset of the namespace", i18n.StringType)
+	ConfigNamespacesBatchPollJitter               = ffc("config.namespaces.predefined[].batch.pollJitter", "The fraction of the poll timeout by which each poll of the batch manager of this namespace is randomly brought forward or delayed. Defaults to batch.manager.pollJitter", i18n.FloatType)
+	ConfigNamespacesBatchPollTimeout              = ffc("config.namespaces.predefined[].batch.pollTimeout", "How long the batch manager of this namespace waits without any notifications of new messages before doing a page query. Defaults to batch.manager.pollTimeout", i18n.TimeDurationType)
+	ConfigNamespacesBatchReadPageSize             = ffc("config.namespaces.predefined[].batch.readPageSize", "The size of each page of messages the batch manager of this namespace reads from the database. Defaults to batch.manager.readPageSize", i18n.IntType)
+	ConfigNamespacesBatchRetryFactor              = ffc("config.namespaces.predefined[].batch.retry.factor", "The retry backoff factor for the database operations of the batch manager of this namespace. Defaults to batch.retry.factor", i18n.FloatType)
+	ConfigNamespacesBatchRetryInitDelay           = ffc("config.namespaces.predefined[].batch.retry.initDelay", "The initial retry delay for the database operations of the batch manager of this namespace. Defaults to batch.retry.initDelay", i18n.TimeDurationType)
+	ConfigNamespacesBatchRetryMaxDelay            = ffc("config.namespaces.predefined[].batch.retry.maxDelay", "The maximum retry delay for the database operations of the batch manager of this namespace. Defaults to batch.retry.maxDelay", i18n.TimeDurationType)
+	ConfigNamespacesBatchDispatcherBatchTimeout   = ffc("config.namespaces.predefined[].batch.dispatcher.batchTimeout", "The batch timeout of dispatchers registered with the batch manager of this namespace without one", i18n.TimeDurationType)
+	ConfigNamespacesBatchDispatcherDisposeTimeout = ffc("config.namespaces.predefined[].batch.dispatcher.disposeTimeout", "The dispose timeout of dispatchers registered with the batch manager of this namespace without one", i18n.TimeDurationType)
+	ConfigNamespacesPredefinedKeyNormalization    = ffc("config.namespaces.predefined[].asset.manager.keyNormalization", "Mechanism to normalize keys before using them. Valid options are `blockchain_plugin` - use blockchain plugin (default) or `none` - do not attempt normalization", i18n.StringType)
+	ConfigNamespacesMultipartyEnabled             = ffc("config.namespaces.predefined[].multiparty.enabled", "Enables multi-party mode for this namespace (defaults to true if an org name or key is configured, either here or at the root level)", i18n.BooleanType)
+	ConfigNamespacesMultipartyNetworkNamespace    = ffc("config.namespaces.predefined[].multiparty.networknamespace", "The shared namespace name to be sent in multiparty messages, if it differs from the local namespace name", i18n.StringType)
+	ConfigNamespacesMultipartyOrgName             = ffc("config.namespaces.predefined[].multiparty.org.name", "A short name for the local root organization within this namespace", i18n.StringType)
+	ConfigNamespacesMultipartyOrgDesc             = ffc("config.namespaces.predefined[].multiparty.org.description", "A description for the local root organization within this namespace", i18n.StringType)
+	ConfigNamespacesMultipartyOrgKey              = ffc("config.namespaces.predefined[].multiparty.org.key", "The signing key allocated to the root organization within this namespace", i18n.StringType)
+	ConfigNamespacesMultipartyNodeName            = ffc("config.namespaces.predefined[].multiparty.node.name", "The node name for this namespace", i18n.StringType)
+	ConfigNamespacesMultipartyNodeDescription     = ffc("config.namespaces.predefined[].multiparty.node.description", "A description for the node in this namespace", i18n.StringType)
+	ConfigNamespacesMultipartyContract            = ffc("config.namespaces.predefined[].contract", "A list containing configuration for the multi-party blockchain contract", i18n.StringType)
+	ConfigNamespacesMultipartyContractFirstEvent  = ffc("config.namespaces.predefined[].multiparty.contract[].firstEvent", "The first event the contract should process. Valid options are `oldest` or `newest`", i18n.StringType)
+	ConfigNamespacesMultipartyContractLocation    = ffc("config.namespaces.predefined[].multiparty.contract[].location", "A blockchain-specific contract location. For example, an Ethereum contract address, or a Fabric chaincode name and channe", i18n.StringType)
 
 	ConfigNodeDescription = ffc("config.node.description", "The description of this FireFly node", i18n.StringType)
 	ConfigNodeName        = ffc("config.node.name", "The name of this FireFly node", i18n.StringType)
@@ -268,4 +268,27 @@ var (
 	MsgCacheUnexpectedSizeKeyNameInternal = ffe("FF10427", "could not initialize cache - '%s' is not an expected size configuration key suffix. Expected values are: 'size', 'limit'")
 	MsgUnknownVerifierType                = ffe("FF10428", "Unknown verifier type", 400)
 	MsgNotSupportedByBlockchainPlugin     = ffe("FF10429", "Not supported by blockchain plugin", 400)
+	MsgBatchMessagePinsMissing            = ffe("FF10430", "Pins have not been allocated for message '%s' in batch '%s'")
+	MsgBatchDispatchDeadlock              = ffe("FF10431", "Dispatch of batch '%s' did not complete within %s")
+	MsgBatchVerifyBatchMismatch           = ffe("FF10432", "Read-back of batch '%s' did not match the dispatched batch")
+	MsgBatchVerifyMessageMismatch         = ffe("FF10433", "Read-back of message '%s' did not match its dispatch in batch '%s'")
+	MsgInvalidMessageCursor               = ffe("FF10434", "Invalid message cursor '%s'", 400)
+	MsgInvalidRewindSequence              = ffe("FF10435", "Invalid sequence %d to rewind the batch manager to", 400)
+	MsgBatchTooLargeForTransport          = ffe("FF10436", "Batch is too large for the transport to dispatch")
+	MsgBatchManagerStopped                = ffe("FF10437", "Batch manager is stopped")
+	MsgBatchManagerStalled                = ffe("FF10438", "Batch manager has made no progress for %s")
+	MsgBatchManagerReadFailing            = ffe("FF10439", "Batch manager has failed to read messages %d consecutive times: %s")
+	MsgBatchManagerStopTimeout            = ffe("FF10440", "Batch manager did not stop within %s")
+	MsgDispatcherBatchMaxSizeZero         = ffe("FF10441", "Dispatcher '%s' must have a BatchMaxSize greater than zero")
+	MsgDispatcherNegativeOption           = ffe("FF10442", "Dispatcher '%s' has a negative %s")
+	MsgDispatcherBatchMaxBytesTooSmall    = ffe("FF10443", "Dispatcher '%s' has a BatchMaxBytes of %d, which does not leave room for any message beyond the batch overhead of %d bytes")
+	MsgDispatcherSizeClassesInvalid       = ffe("FF10444", "Dispatcher '%s' must have positive size classes in ascending order, no larger than BatchMaxBytes")
+	MsgBatchDispatchHandlerTimeout        = ffe("FF10445", "Dispatch of batch '%s' was cancelled after the dispatch timeout of %s")
+	MsgDispatcherBatchMinSizeExceedsMax   = ffe("FF10446", "Dispatcher '%s' has a BatchMinSize of %d, which is greater than its BatchMaxSize of %d")
+	MsgBatchDataHashMismatch              = ffe("FF10447", "Data '%s' of message '%s' does not match the hash '%s' in the message")
+	MsgBatchOffsetRestoreTimeout          = ffe("FF10448", "Batch manager could not restore its offset within the startup timeout of %s: %v")
+	MsgQuarantineStoreNotSet              = ffe("FF10450", "No quarantine store has been set for the batch manager")
+	MsgQuarantinedBatchNotFound           = ffe("FF10451", "Batch '%s' was not found in the quarantine store")
+	MsgDispatcherPinnedPrivateDeadLetter  = ffe("FF10452", "Dispatcher '%s' seals pinned private messages, so cannot set MaxDispatchAttempts, as a dead-lettered batch would leave a gap in the nonces of its groups")
+	MsgDispatcherPinnedSplitsBatches      = ffe("FF10453", "Dispatcher '%s' is pinned, so cannot set %s, as messages assembled into separate batches could be pinned out of order")
 )
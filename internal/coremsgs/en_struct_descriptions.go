@@ -57,6 +57,8 @@ var (
 	MessageHeaderGroup     = ffm("MessageHeader.group", "Private messages only - the identifier hash of the privacy group. Derived from the name and member list of the group")
 	MessageHeaderTopics    = ffm("MessageHeader.topics", "A message topic associates this message with an ordered stream of data. A custom topic should be assigned - using the default topic is discouraged")
 	MessageHeaderTag       = ffm("MessageHeader.tag", "The message tag indicates the purpose of the message to the applications that process it")
+	MessageHeaderPriority  = ffm("MessageHeader.priority", "The priority of the message. High priority messages are assembled into separate small batches that are flushed quickly, ahead of bulk traffic")
+	MessageHeaderFlush     = ffm("MessageHeader.flush", "Set on a control message to seal the open batch of its type as soon as the message is reached, rather than waiting for the batch to fill or time out")
 	MessageHeaderDataHash  = ffm("MessageHeader.datahash", "A single hash representing all data in the message. Derived from the array of data ids+hashes attached to this message")
 
 	// Message field descriptions
@@ -68,6 +70,7 @@ var (
 	MessageConfirmed      = ffm("Message.confirmed", "The timestamp of when the message was confirmed/rejected")
 	MessageData           = ffm("Message.data", "The list of data elements attached to the message")
 	MessagePins           = ffm("Message.pins", "For private messages, a unique pin hash:nonce is assigned for each topic")
+	MessageIdempotencyKey = ffm("Message.idempotencyKey", "An optional key supplied by the client, unique within the namespace. Submitting a message with the key of an existing message returns the existing message, rather than creating a duplicate. Local to this node, so is not part of the message hash")
 
 	// MessageInOut field descriptions
 	MessageInOutData  = ffm("MessageInOut.data", "For input allows you to specify data in-line in the message, that will be turned into data attachments. For output when fetchdata is used on API calls, includes the in-line data payloads of all data attachments")
@@ -163,11 +166,15 @@ var (
 	BatchManifestData     = ffm("BatchManifest.data", "Array of manifest entries, succinctly summarizing the data in the batch")
 
 	// BatchPersisted field descriptions
-	BatchPersistedHash       = ffm("Batch.hash", "The hash of the manifest of the batch")
-	BatchPersistedManifest   = ffm("Batch.manifest", "The manifest of the batch")
-	BatchPersistedTX         = ffm("Batch.tx", "The FireFly transaction associated with this batch")
-	BatchPersistedPayloadRef = ffm("Batch.payloadRef", "For broadcast batches, this is the reference to the binary batch in shared storage")
-	BatchPersistedConfirmed  = ffm("Batch.confirmed", "The time when the batch was confirmed")
+	BatchPersistedHash        = ffm("Batch.hash", "The hash of the manifest of the batch")
+	BatchPersistedManifest    = ffm("Batch.manifest", "The manifest of the batch")
+	BatchPersistedTX          = ffm("Batch.tx", "The FireFly transaction associated with this batch")
+	BatchPersistedPayloadRef  = ffm("Batch.payloadRef", "For broadcast batches, this is the reference to the binary batch in shared storage")
+	BatchPersistedConfirmed   = ffm("Batch.confirmed", "The time when the batch was confirmed")
+	BatchPersistedCorrelator  = ffm("Batch.correlator", "An ID shared by the batch and the events emitted when it is dispatched, for correlation with the messages it contains")
+	BatchPersistedAnnotations = ffm("Batch.annotations", "Metadata attached to the batch by the node when it was sealed, such as the deployment version or a trace ID, for correlation with other systems")
+	BatchPersistedProvenance  = ffm("Batch.provenance", "Where each message in the batch came from, and when and where it was read for assembly, keyed by message ID. Only set for dispatchers with includeProvenance")
+	BatchPersistedSealReason  = ffm("Batch.sealReason", "Why the batch manager sealed the batch - when it reached its maximum size in messages or bytes, its batch timeout or maximum age, was flushed on request, or at shutdown")
 
 	// Transaction field descriptions
 	TransactionID            = ffm("Transaction.id", "The UUID of the FireFly transaction")
@@ -307,15 +314,16 @@ var (
 	DIDVerificationMethodDataExchangePeerID  = ffm("DIDVerificationMethod.dataExchangePeerID", "A string provided by your Data Exchange plugin, that it uses a technology specific mechanism to validate against when messages arrive from this identity")
 
 	// Event field descriptions
-	EventID          = ffm("Event.id", "The UUID assigned to this event by your local FireFly node")
-	EventSequence    = ffm("Event.sequence", "A sequence indicating the order in which events are delivered to your application. Assure to be unique per event in your local FireFly database (unlike the created timestamp)")
-	EventType        = ffm("Event.type", "All interesting activity in FireFly is emitted as a FireFly event, of a given type. The 'type' combined with the 'reference' can be used to determine how to process the event within your application")
-	EventNamespace   = ffm("Event.namespace", "The namespace of the event. Your application must subscribe to events within a namespace")
-	EventReference   = ffm("Event.reference", "The UUID of an resource that is the subject of this event. The event type determines what type of resource is referenced, and whether this field might be unset")
-	EventCorrelator  = ffm("Event.correlator", "For message events, this is the 'header.cid' field from the referenced message. For certain other event types, a secondary object is referenced such as a token pool")
-	EventTransaction = ffm("Event.tx", "The UUID of a transaction that is event is part of. Not all events are part of a transaction")
-	EventTopic       = ffm("Event.topic", "A stream of information this event relates to. For message confirmation events, a separate event is emitted for each topic in the message. For blockchain events, the listener specifies the topic. Rules exist for how the topic is set for other event types")
-	EventCreated     = ffm("Event.created", "The time the event was emitted. Not guaranteed to be unique, or to increase between events in the same order as the final sequence events are delivered to your application. As such, the 'sequence' field should be used instead of the 'created' field for querying events in the exact order they are delivered to applications")
+	EventID              = ffm("Event.id", "The UUID assigned to this event by your local FireFly node")
+	EventSequence        = ffm("Event.sequence", "A sequence indicating the order in which events are delivered to your application. Assure to be unique per event in your local FireFly database (unlike the created timestamp)")
+	EventType            = ffm("Event.type", "All interesting activity in FireFly is emitted as a FireFly event, of a given type. The 'type' combined with the 'reference' can be used to determine how to process the event within your application")
+	EventNamespace       = ffm("Event.namespace", "The namespace of the event. Your application must subscribe to events within a namespace")
+	EventReference       = ffm("Event.reference", "The UUID of an resource that is the subject of this event. The event type determines what type of resource is referenced, and whether this field might be unset")
+	EventCorrelator      = ffm("Event.correlator", "For message events, this is the 'header.cid' field from the referenced message. For certain other event types, a secondary object is referenced such as a token pool")
+	EventBatchCorrelator = ffm("Event.batchCorrelator", "For message events dispatched in a batch sealed with a batch correlator, this is the correlator assigned to that batch. Every event for the messages in the batch carries the same value")
+	EventTransaction     = ffm("Event.tx", "The UUID of a transaction that is event is part of. Not all events are part of a transaction")
+	EventTopic           = ffm("Event.topic", "A stream of information this event relates to. For message confirmation events, a separate event is emitted for each topic in the message. For blockchain events, the listener specifies the topic. Rules exist for how the topic is set for other event types")
+	EventCreated         = ffm("Event.created", "The time the event was emitted. Not guaranteed to be unique, or to increase between events in the same order as the final sequence events are delivered to your application. As such, the 'sequence' field should be used instead of the 'created' field for querying events in the exact order they are delivered to applications")
 
 	// EnrichedEvent field descriptions
 	EnrichedEventBlockchainEvent   = ffm("EnrichedEvent.blockchainEvent", "A blockchain event if referenced by the FireFly event")
@@ -431,8 +439,77 @@ var (
 	NamespaceMultipartyEnabled  = ffm("NamespaceStatusMultiparty.enabled", "Whether multi-party mode is enabled for this namespace")
 	NamespaceMultipartyContract = ffm("NamespaceStatusMultiparty.contract", "Information about the multi-party smart contract configured for this namespace")
 
+	// AssemblyFailure field descriptions
+	AssemblyFailureNamespace   = ffm("AssemblyFailure.namespace", "The namespace of the message")
+	AssemblyFailureMessage     = ffm("AssemblyFailure.message", "The UUID of the message that is failing to assemble")
+	AssemblyFailureMissingData = ffm("AssemblyFailure.missingData", "The UUIDs of the data of the message that were not found on the last attempt")
+	AssemblyFailureAttempts    = ffm("AssemblyFailure.attempts", "The number of attempts to assemble the message that have failed")
+	AssemblyFailureError       = ffm("AssemblyFailure.error", "The error from the last attempt to assemble the message")
+	AssemblyFailureCreated     = ffm("AssemblyFailure.created", "The time the message was first recorded as stalled")
+	AssemblyFailureUpdated     = ffm("AssemblyFailure.updated", "The time of the last attempt to assemble the message")
+
 	// BatchManagerStatus field descriptions
-	BatchManagerStatusProcessors = ffm("BatchManagerStatus.processors", "An array of currently active batch processors")
+	BatchManagerStatusProcessors             = ffm("BatchManagerStatus.processors", "An array of currently active batch processors")
+	BatchManagerStatusOffset                 = ffm("BatchManagerStatus.offset", "The committed offset of the batch manager - the highest sequence for which all messages read have been dispatched")
+	BatchManagerStatusHighestSequence        = ffm("BatchManagerStatus.highestSequence", "The sequence of the newest message")
+	BatchManagerStatusLag                    = ffm("BatchManagerStatus.lag", "How many sequences the offset is behind the newest message")
+	BatchManagerStatusOpenBatches            = ffm("BatchManagerStatus.openBatches", "The number of batch processors of each dispatcher that hold messages not yet flushed in a batch")
+	BatchManagerStatusOpenBatchTimers        = ffm("BatchManagerStatus.openBatchTimers", "The batches currently being assembled, with the time remaining until each is flushed by its batch timeout")
+	BatchManagerStatusDataCache              = ffm("BatchManagerStatus.dataCache", "The effectiveness of the cache of data shared between the messages of each page assembled, if enabled")
+	BatchManagerStatusDataTiming             = ffm("BatchManagerStatus.dataTiming", "The time taken to retrieve messages with their data during assembly, if timing is enabled")
+	BatchManagerStatusDispatchPaused         = ffm("BatchManagerStatus.dispatchPaused", "Whether dispatch is paused, or resuming while the batches queued when paused are dispatched")
+	BatchManagerStatusQueuedBatches          = ffm("BatchManagerStatus.queuedBatches", "The number of sealed batches queued to be dispatched when dispatch resumes")
+	BatchManagerStatusDispatchBacklog        = ffm("BatchManagerStatus.dispatchBacklog", "The number of sealed batches waiting to be dispatched, or in dispatch")
+	BatchManagerStatusNotificationsCoalesced = ffm("BatchManagerStatus.notificationsCoalesced", "The number of new message notifications merged into another, because the notification buffer was full or the sequence was already buffered")
+	BatchManagerStatusTapDropped             = ffm("BatchManagerStatus.tapDropped", "The number of dispatched batches not delivered to the dispatch tap, because its buffer was full")
+	BatchManagerStatusIntegrityErrors        = ffm("BatchManagerStatus.integrityErrors", "The number of messages skipped because their data did not match the hashes in the message, when data hash verification is enabled")
+
+	// BatchDispatcherDescription field descriptions
+	BatchDispatcherDescriptionName     = ffm("BatchDispatcherDescription.name", "The name of the dispatcher")
+	BatchDispatcherDescriptionEnabled  = ffm("BatchDispatcherDescription.enabled", "Whether the dispatcher is enabled, or its messages are deferred")
+	BatchDispatcherDescriptionNoOp     = ffm("BatchDispatcherDescription.noop", "Whether the dispatcher moves past its messages without assembling them into batches")
+	BatchDispatcherDescriptionHandlers = ffm("BatchDispatcherDescription.handlers", "The number of handlers each batch of the dispatcher is dispatched to")
+	BatchDispatcherDescriptionOptions  = ffm("BatchDispatcherDescription.options", "The options the dispatcher was registered with, that determine the characteristics of its batches. Callbacks are listed by name")
+
+	// BatchDryRunStats field descriptions
+	BatchDryRunStatsDispatcher       = ffm("BatchDryRunStats.dispatcher", "The name of the dispatcher in dry-run mode")
+	BatchDryRunStatsBatches          = ffm("BatchDryRunStats.batches", "The number of batches assembled and sealed without being dispatched")
+	BatchDryRunStatsMessages         = ffm("BatchDryRunStats.messages", "The total number of messages in the batches")
+	BatchDryRunStatsMessagesPerBatch = ffm("BatchDryRunStats.messagesPerBatch", "The distribution of the number of messages in each batch")
+	BatchDryRunStatsBytesPerBatch    = ffm("BatchDryRunStats.bytesPerBatch", "The distribution of the estimated size of each batch in bytes")
+	BatchDryRunStatsAssemblyTime     = ffm("BatchDryRunStats.assemblyTime", "The distribution of the time in milliseconds from the first message being added to each batch, until it was sealed")
+
+	// BatchHistogram field descriptions
+	BatchHistogramBuckets = ffm("BatchHistogram.buckets", "The buckets of the histogram, each counting the observations less than or equal to its upper bound")
+	BatchHistogramCount   = ffm("BatchHistogram.count", "The total number of observations, including those above the upper bound of every bucket")
+	BatchHistogramSum     = ffm("BatchHistogram.sum", "The sum of all the observations")
+
+	// BatchHistogramBucket field descriptions
+	BatchHistogramBucketUpperBound = ffm("BatchHistogramBucket.upperBound", "The inclusive upper bound of the bucket")
+	BatchHistogramBucketCount      = ffm("BatchHistogramBucket.count", "The number of observations less than or equal to the upper bound")
+
+	// BatchDataCacheStatus field descriptions
+	BatchDataCacheStatusHits     = ffm("BatchDataCacheStatus.hits", "The number of data lookups served from the cache")
+	BatchDataCacheStatusMisses   = ffm("BatchDataCacheStatus.misses", "The number of data lookups read from the database")
+	BatchDataCacheStatusHitRatio = ffm("BatchDataCacheStatus.hitRatio", "The proportion of data lookups served from the cache")
+
+	// BatchDataTimingStatus field descriptions
+	BatchDataTimingStatusLastPage = ffm("BatchDataTimingStatus.lastPage", "The data retrievals made while assembling the most recent page of messages")
+	BatchDataTimingStatusTotal    = ffm("BatchDataTimingStatus.total", "The data retrievals made since the batch manager started")
+
+	// BatchDataTiming field descriptions
+	BatchDataTimingCount     = ffm("BatchDataTiming.count", "The number of retrievals of a message with its data")
+	BatchDataTimingTotalTime = ffm("BatchDataTiming.totalTime", "The total time spent on the retrievals")
+	BatchDataTimingMaxTime   = ffm("BatchDataTiming.maxTime", "The longest time taken by a single retrieval")
+
+	// BatchOpenBatchTimer field descriptions
+	BatchOpenBatchTimerDispatcher       = ffm("BatchOpenBatchTimer.dispatcher", "The type of dispatcher assembling the batch")
+	BatchOpenBatchTimerProcessor        = ffm("BatchOpenBatchTimer.processor", "The name of the processor assembling the batch")
+	BatchOpenBatchTimerMessageType      = ffm("BatchOpenBatchTimer.messageType", "The type of the first message in the batch")
+	BatchOpenBatchTimerMessages         = ffm("BatchOpenBatchTimer.messages", "The number of messages in the batch")
+	BatchOpenBatchTimerBytes            = ffm("BatchOpenBatchTimer.bytes", "The estimated size of the batch in bytes")
+	BatchOpenBatchTimerStarted          = ffm("BatchOpenBatchTimer.started", "The time the first message entered the batch")
+	BatchOpenBatchTimerTimeoutRemaining = ffm("BatchOpenBatchTimer.timeoutRemaining", "The time remaining until the batch timeout flushes the batch, which is zero if it is being held past its timeout")
 
 	// BatchProcessorStatus field descriptions
 	BatchProcessorStatusDispatcher = ffm("BatchProcessorStatus.dispatcher", "The type of dispatcher for this processor")
@@ -451,6 +528,8 @@ var (
 	BatchFlushStatusAverageFlushTimeMS   = ffm("BatchFlushStatus.averageFlushTimeMS", "The average amount of time spent flushing each batch")
 	BatchFlushStatusTotalBatches         = ffm("BatchFlushStatus.totalBatches", "The total count of batches flushed by this processor since it started")
 	BatchFlushStatusTotalErrors          = ffm("BatchFlushStatus.totalErrors", "The total count of error flushed encountered by this processor since it started")
+	BatchFlushStatusStalled              = ffm("BatchFlushStatus.stalled", "True if the current batch dispatch has not succeeded within the stall threshold of the dispatcher")
+	BatchFlushStatusTotalStalls          = ffm("BatchFlushStatus.totalStalls", "The total count of stalled dispatches detected by this processor since it started")
 
 	// Pin field descriptions
 	PinSequence       = ffm("Pin.sequence", "The order of the pin in the local FireFly database, which matches the order in which pins were delivered to FireFly by the blockchain connector event stream")
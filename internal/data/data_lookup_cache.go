@@ -0,0 +1,135 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+// DataLookupCache is a small cache of data, shared across the lookups of a set of messages that are likely to reference
+// the same data - such as a page of messages being assembled into batches. It is bounded by entry count, and is
+// intended to be cleared between each set of messages, so it does not need the eviction of the main caches.
+// A cache created with a TTL is instead retained across sets of messages, with each entry expiring once the TTL
+// has passed since it was read, and the least recently used entry evicted to make room for a new one.
+type DataLookupCache struct {
+	mux     sync.Mutex
+	limit   int
+	ttl     time.Duration
+	entries map[fftypes.UUID]*list.Element
+	lru     *list.List
+	hits    int64
+	misses  int64
+}
+
+type dataLookupEntry struct {
+	data  *core.Data
+	added time.Time
+}
+
+type dataLookupCacheKey struct{}
+
+func NewDataLookupCache(limit int) *DataLookupCache {
+	return NewDataLookupCacheWithTTL(limit, 0)
+}
+
+// NewDataLookupCacheWithTTL creates a cache that is retained across sets of messages, with entries that expire after
+// the TTL. A zero TTL creates a cache that is cleared between sets of messages, as NewDataLookupCache.
+func NewDataLookupCacheWithTTL(limit int, ttl time.Duration) *DataLookupCache {
+	return &DataLookupCache{
+		limit:   limit,
+		ttl:     ttl,
+		entries: make(map[fftypes.UUID]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// WithDataLookupCache returns a context in which data manager lookups of message data use the cache
+func WithDataLookupCache(ctx context.Context, cache *DataLookupCache) context.Context {
+	return context.WithValue(ctx, dataLookupCacheKey{}, cache)
+}
+
+func getDataLookupCache(ctx context.Context) *DataLookupCache {
+	cache, _ := ctx.Value(dataLookupCacheKey{}).(*DataLookupCache)
+	return cache
+}
+
+// Retained is true for a cache with a TTL, which should not be cleared between sets of messages
+func (c *DataLookupCache) Retained() bool {
+	return c.ttl > 0
+}
+
+// Clear empties the cache, retaining the counts of hits and misses
+func (c *DataLookupCache) Clear() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.entries = make(map[fftypes.UUID]*list.Element)
+	c.lru.Init()
+}
+
+// Stats returns the counts of lookups that hit and missed the cache, since it was created
+func (c *DataLookupCache) Stats() (hits, misses int64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.hits, c.misses
+}
+
+// get returns the cached data, if it has not expired. Cached data with a different hash to the one expected is
+// invalidated, so it is read again.
+func (c *DataLookupCache) get(id *fftypes.UUID, hash *fftypes.Bytes32) *core.Data {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	elem := c.entries[*id]
+	if elem != nil {
+		entry := elem.Value.(*dataLookupEntry)
+		expired := c.ttl > 0 && time.Since(entry.added) > c.ttl
+		stale := hash != nil && (entry.data.Hash == nil || *entry.data.Hash != *hash)
+		if !expired && !stale {
+			c.hits++
+			c.lru.MoveToFront(elem)
+			return entry.data
+		}
+		c.remove(elem)
+	}
+	c.misses++
+	return nil
+}
+
+func (c *DataLookupCache) add(d *core.Data) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if elem := c.entries[*d.ID]; elem != nil {
+		c.remove(elem)
+	}
+	if len(c.entries) >= c.limit {
+		if c.ttl == 0 || c.lru.Len() == 0 {
+			return
+		}
+		c.remove(c.lru.Back())
+	}
+	c.entries[*d.ID] = c.lru.PushFront(&dataLookupEntry{data: d, added: time.Now()})
+}
+
+func (c *DataLookupCache) remove(elem *list.Element) {
+	delete(c.entries, *elem.Value.(*dataLookupEntry).data.ID)
+	c.lru.Remove(elem)
+}
@@ -0,0 +1,128 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/mocks/databasemocks"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDataLookupCacheSharedAcrossMessages(t *testing.T) {
+	dm, ctx, cancel := newTestDataManager(t)
+	defer cancel()
+	mdi := dm.database.(*databasemocks.Plugin)
+	d := &core.Data{ID: fftypes.NewUUID(), Hash: fftypes.NewRandB32()}
+	mdi.On("GetDataByID", mock.Anything, "ns1", d.ID, true).Return(d, nil).Once()
+
+	cache := NewDataLookupCache(10)
+	ctx = WithDataLookupCache(ctx, cache)
+	for i := 0; i < 3; i++ {
+		data, foundAll, err := dm.GetMessageDataCached(ctx, &core.Message{
+			Header: core.MessageHeader{ID: fftypes.NewUUID()},
+			Data:   core.DataRefs{{ID: d.ID, Hash: d.Hash}},
+		})
+		assert.NoError(t, err)
+		assert.True(t, foundAll)
+		assert.Equal(t, core.DataArray{d}, data)
+	}
+	hits, misses := cache.Stats()
+	assert.Equal(t, int64(2), hits)
+	assert.Equal(t, int64(1), misses)
+	mdi.AssertExpectations(t)
+
+	// Clearing the cache retains the stats
+	cache.Clear()
+	assert.Empty(t, cache.entries)
+	hits, _ = cache.Stats()
+	assert.Equal(t, int64(2), hits)
+}
+
+func TestDataLookupCacheHashMismatch(t *testing.T) {
+	dm, ctx, cancel := newTestDataManager(t)
+	defer cancel()
+	mdi := dm.database.(*databasemocks.Plugin)
+	d := &core.Data{ID: fftypes.NewUUID(), Hash: fftypes.NewRandB32()}
+	mdi.On("GetDataByID", mock.Anything, "ns1", d.ID, true).Return(d, nil).Once()
+	cache := NewDataLookupCache(10)
+	cache.add(d)
+
+	// Cached data with a different hash to the one the message references is invalidated, and read again
+	_, foundAll, err := dm.GetMessageDataCached(WithDataLookupCache(ctx, cache), &core.Message{
+		Header: core.MessageHeader{ID: fftypes.NewUUID()},
+		Data:   core.DataRefs{{ID: d.ID, Hash: fftypes.NewRandB32()}},
+	})
+	assert.NoError(t, err)
+	assert.False(t, foundAll)
+	mdi.AssertExpectations(t)
+}
+
+func TestDataLookupCacheLimit(t *testing.T) {
+	cache := NewDataLookupCache(1)
+	cache.add(&core.Data{ID: fftypes.NewUUID()})
+	cache.add(&core.Data{ID: fftypes.NewUUID()})
+	assert.Len(t, cache.entries, 1)
+}
+
+func TestDataLookupCacheTTLEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewDataLookupCacheWithTTL(2, time.Minute)
+	assert.True(t, cache.Retained())
+	d1 := &core.Data{ID: fftypes.NewUUID()}
+	d2 := &core.Data{ID: fftypes.NewUUID()}
+	d3 := &core.Data{ID: fftypes.NewUUID()}
+	cache.add(d1)
+	cache.add(d2)
+
+	// Reading d1 makes d2 the least recently used, so it is evicted for d3
+	assert.Equal(t, d1, cache.get(d1.ID, nil))
+	cache.add(d3)
+	assert.Len(t, cache.entries, 2)
+	assert.Equal(t, d1, cache.get(d1.ID, nil))
+	assert.Nil(t, cache.get(d2.ID, nil))
+	assert.Equal(t, d3, cache.get(d3.ID, nil))
+
+	// Adding the same data again replaces the entry
+	cache.add(d3)
+	assert.Len(t, cache.entries, 2)
+	assert.Equal(t, 2, cache.lru.Len())
+}
+
+func TestDataLookupCacheTTLExpiry(t *testing.T) {
+	cache := NewDataLookupCacheWithTTL(10, time.Millisecond)
+	d := &core.Data{ID: fftypes.NewUUID()}
+	cache.add(d)
+	cache.entries[*d.ID].Value.(*dataLookupEntry).added = time.Now().Add(-time.Second)
+	assert.Nil(t, cache.get(d.ID, nil))
+	assert.Empty(t, cache.entries)
+	hits, misses := cache.Stats()
+	assert.Equal(t, int64(0), hits)
+	assert.Equal(t, int64(1), misses)
+}
+
+func TestDataLookupCacheNoTTLNotRetained(t *testing.T) {
+	cache := NewDataLookupCache(10)
+	assert.False(t, cache.Retained())
+	d := &core.Data{ID: fftypes.NewUUID()}
+	cache.add(d)
+	cache.entries[*d.ID].Value.(*dataLookupEntry).added = time.Now().Add(-time.Hour)
+	assert.Equal(t, d, cache.get(d.ID, nil))
+}
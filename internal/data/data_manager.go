@@ -338,7 +338,7 @@ func (dm *dataManager) resolveRef(ctx context.Context, dataRef *core.DataRef) (*
 		log.L(ctx).Warnf("data is nil")
 		return nil, nil
 	}
-	d, err := dm.database.GetDataByID(ctx, dm.namespace.Name, dataRef.ID, true)
+	d, err := dm.lookupData(ctx, dataRef.ID, dataRef.Hash)
 	if err != nil {
 		return nil, err
 	}
@@ -354,6 +354,21 @@ func (dm *dataManager) resolveRef(ctx context.Context, dataRef *core.DataRef) (*
 	}
 }
 
+// lookupData reads data by ID, using any lookup cache in the context - unless the cached data does not have the expected hash
+func (dm *dataManager) lookupData(ctx context.Context, id *fftypes.UUID, hash *fftypes.Bytes32) (*core.Data, error) {
+	cache := getDataLookupCache(ctx)
+	if cache != nil {
+		if d := cache.get(id, hash); d != nil {
+			return d, nil
+		}
+	}
+	d, err := dm.database.GetDataByID(ctx, dm.namespace.Name, id, true)
+	if err == nil && d != nil && cache != nil {
+		cache.add(d)
+	}
+	return d, err
+}
+
 func (dm *dataManager) resolveBlob(ctx context.Context, blobRef *core.BlobRef) (*core.Blob, error) {
 	if blobRef != nil && blobRef.Hash != nil {
 		blob, err := dm.database.GetBlobMatchingHash(ctx, blobRef.Hash)
@@ -524,6 +539,11 @@ func (dm *dataManager) WriteNewMessage(ctx context.Context, newMsg *NewMessage)
 		return i18n.NewError(ctx, i18n.MsgNilOrNullObject)
 	}
 
+	// A message re-submitted with the idempotency key of an existing message is not written again
+	if dup, err := dm.resolveIdempotencyKey(ctx, newMsg); err != nil || dup {
+		return err
+	}
+
 	// We add the message to the cache before we write it, because the batch aggregator might
 	// pick up our message from the message-writer before we return. The batch processor
 	// writes a more authoritative cache entry, with pings/batchID etc.
@@ -531,11 +551,35 @@ func (dm *dataManager) WriteNewMessage(ctx context.Context, newMsg *NewMessage)
 
 	err := dm.messageWriter.WriteNewMessage(ctx, newMsg)
 	if err != nil {
+		// A concurrent submission with the same idempotency key might have won the race to insert
+		if dup, _ := dm.resolveIdempotencyKey(ctx, newMsg); dup {
+			return nil
+		}
 		return err
 	}
 	return nil
 }
 
+// resolveIdempotencyKey looks for an existing message with the idempotency key of a new message. If there is one,
+// the new message is replaced with the existing message, and true is returned.
+func (dm *dataManager) resolveIdempotencyKey(ctx context.Context, newMsg *NewMessage) (bool, error) {
+	msg := &newMsg.Message.Message
+	if msg.IdempotencyKey == "" {
+		return false, nil
+	}
+	fb := database.MessageQueryFactory.NewFilter(ctx)
+	existing, _, err := dm.database.GetMessages(ctx, dm.namespace.Name, fb.And(
+		fb.Eq("idempotencykey", msg.IdempotencyKey),
+		fb.Neq("id", msg.Header.ID),
+	).Limit(1))
+	if err != nil || len(existing) == 0 {
+		return false, err
+	}
+	log.L(ctx).Infof("Message %s has the idempotency key '%s' of existing message %s", msg.Header.ID, msg.IdempotencyKey, existing[0].Header.ID)
+	newMsg.Message.Message = *existing[0]
+	return true, nil
+}
+
 func (dm *dataManager) WaitStop() {
 	dm.messageWriter.close()
 }
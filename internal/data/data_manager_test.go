@@ -1169,3 +1169,79 @@ func TestWriteNewMessageFailClosed(t *testing.T) {
 	})
 	assert.Regexp(t, "FF00154", err)
 }
+
+func mockRunAsGroupPassthrough(mdi *databasemocks.Plugin) {
+	rag := mdi.On("RunAsGroup", mock.Anything, mock.Anything)
+	rag.RunFn = func(a mock.Arguments) {
+		fn := a[1].(func(context.Context) error)
+		rag.ReturnArguments = mock.Arguments{fn(a[0].(context.Context))}
+	}
+}
+
+func newTestIdempotentMessage() *NewMessage {
+	return &NewMessage{
+		Message: &core.MessageInOut{
+			Message: core.Message{
+				Header:         core.MessageHeader{ID: fftypes.NewUUID()},
+				IdempotencyKey: "key1",
+			},
+		},
+	}
+}
+
+func TestWriteNewMessageIdempotencyKeyExisting(t *testing.T) {
+	dm, ctx, cancel := newTestDataManager(t)
+	defer cancel()
+
+	existing := &core.Message{Header: core.MessageHeader{ID: fftypes.NewUUID()}, IdempotencyKey: "key1"}
+	mdi := dm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessages", ctx, "ns1", mock.Anything).Return([]*core.Message{existing}, nil, nil)
+
+	newMsg := newTestIdempotentMessage()
+	err := dm.WriteNewMessage(ctx, newMsg)
+	assert.NoError(t, err)
+	assert.Equal(t, existing.Header.ID, newMsg.Message.Header.ID)
+	mdi.AssertNotCalled(t, "InsertMessages", mock.Anything, mock.Anything)
+}
+
+func TestWriteNewMessageIdempotencyKeyLookupFail(t *testing.T) {
+	dm, ctx, cancel := newTestDataManager(t)
+	defer cancel()
+
+	mdi := dm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessages", ctx, "ns1", mock.Anything).Return(nil, nil, fmt.Errorf("pop"))
+
+	err := dm.WriteNewMessage(ctx, newTestIdempotentMessage())
+	assert.Regexp(t, "pop", err)
+}
+
+func TestWriteNewMessageIdempotencyKeyConcurrentInsert(t *testing.T) {
+	dm, ctx, cancel := newTestDataManager(t)
+	defer cancel()
+
+	// The other message is inserted between our check and our insert
+	existing := &core.Message{Header: core.MessageHeader{ID: fftypes.NewUUID()}, IdempotencyKey: "key1"}
+	mdi := dm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessages", ctx, "ns1", mock.Anything).Return([]*core.Message{}, nil, nil).Once()
+	mdi.On("GetMessages", ctx, "ns1", mock.Anything).Return([]*core.Message{existing}, nil, nil).Once()
+	mockRunAsGroupPassthrough(mdi)
+	mdi.On("InsertMessages", mock.Anything, mock.Anything).Return(fmt.Errorf("unique constraint"))
+
+	newMsg := newTestIdempotentMessage()
+	err := dm.WriteNewMessage(ctx, newMsg)
+	assert.NoError(t, err)
+	assert.Equal(t, existing.Header.ID, newMsg.Message.Header.ID)
+}
+
+func TestWriteNewMessageIdempotencyKeyInsertFail(t *testing.T) {
+	dm, ctx, cancel := newTestDataManager(t)
+	defer cancel()
+
+	mdi := dm.database.(*databasemocks.Plugin)
+	mdi.On("GetMessages", ctx, "ns1", mock.Anything).Return([]*core.Message{}, nil, nil)
+	mockRunAsGroupPassthrough(mdi)
+	mdi.On("InsertMessages", mock.Anything, mock.Anything).Return(fmt.Errorf("pop"))
+
+	err := dm.WriteNewMessage(ctx, newTestIdempotentMessage())
+	assert.Regexp(t, "pop", err)
+}
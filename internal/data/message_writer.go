@@ -88,8 +88,10 @@ func newMessageWriter(ctx context.Context, di database.Plugin, conf *messageWrit
 // WriteNewMessage is the external interface, which depending on whether we have a non-zero
 // worker count will dispatch the work to the pool and wait for it to complete on a background
 // transaction, or just run it in-line on the context passed ini.
+// Messages with an idempotency key are always written in-line, as a conflict on the key fails
+// the whole transaction - which must not fail the other messages in a background batch.
 func (mw *messageWriter) WriteNewMessage(ctx context.Context, newMsg *NewMessage) error {
-	if mw.conf.workerCount > 0 {
+	if mw.conf.workerCount > 0 && newMsg.Message.IdempotencyKey == "" {
 		// Dispatch to background worker
 		nmi := &writeRequest{
 			newMessage: &newMsg.Message.Message,
@@ -0,0 +1,174 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlcommon
+
+import (
+	"context"
+	"database/sql"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+)
+
+var (
+	assemblyFailureColumns = []string{
+		"namespace",
+		"message_id",
+		"missing_data",
+		"attempts",
+		"error",
+		"created",
+		"updated",
+	}
+	assemblyFailureFilterFieldMap = map[string]string{
+		"message":     "message_id",
+		"missingdata": "missing_data",
+	}
+)
+
+const assemblyFailuresTable = "assembly_failures"
+
+func (s *SQLCommon) UpsertAssemblyFailure(ctx context.Context, failure *core.AssemblyFailure) (err error) {
+	ctx, tx, autoCommit, err := s.beginOrUseTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.rollbackTx(ctx, tx, autoCommit)
+
+	// Do a select within the transaction to determine if the message already has a failure recorded
+	rows, _, err := s.queryTx(ctx, assemblyFailuresTable, tx,
+		sq.Select(sequenceColumn, "created").
+			From(assemblyFailuresTable).
+			Where(sq.Eq{
+				"namespace":  failure.Namespace,
+				"message_id": failure.Message,
+			}),
+	)
+	if err != nil {
+		return err
+	}
+	existing := rows.Next()
+	if existing {
+		if err := rows.Scan(&failure.RowID, &failure.Created); err != nil {
+			rows.Close()
+			return i18n.WrapError(ctx, err, coremsgs.MsgDBReadErr, assemblyFailuresTable)
+		}
+	}
+	rows.Close()
+
+	if existing {
+		if _, err = s.updateTx(ctx, assemblyFailuresTable, tx,
+			sq.Update(assemblyFailuresTable).
+				Set("missing_data", failure.MissingData).
+				Set("attempts", failure.Attempts).
+				Set("error", failure.Error).
+				Set("updated", failure.Updated).
+				Where(sq.Eq{sequenceColumn: failure.RowID}),
+			nil, // no change events for assembly failures
+		); err != nil {
+			return err
+		}
+	} else {
+		if failure.RowID, err = s.insertTx(ctx, assemblyFailuresTable, tx,
+			sq.Insert(assemblyFailuresTable).
+				Columns(assemblyFailureColumns...).
+				Values(
+					failure.Namespace,
+					failure.Message,
+					failure.MissingData,
+					failure.Attempts,
+					failure.Error,
+					failure.Created,
+					failure.Updated,
+				),
+			nil, // no change events for assembly failures
+		); err != nil {
+			return err
+		}
+	}
+
+	return s.commitTx(ctx, tx, autoCommit)
+}
+
+func (s *SQLCommon) assemblyFailureResult(ctx context.Context, row *sql.Rows) (*core.AssemblyFailure, error) {
+	failure := core.AssemblyFailure{}
+	err := row.Scan(
+		&failure.Namespace,
+		&failure.Message,
+		&failure.MissingData,
+		&failure.Attempts,
+		&failure.Error,
+		&failure.Created,
+		&failure.Updated,
+		&failure.RowID, // must include sequenceColumn in colum list
+	)
+	if err != nil {
+		return nil, i18n.WrapError(ctx, err, coremsgs.MsgDBReadErr, assemblyFailuresTable)
+	}
+	return &failure, nil
+}
+
+func (s *SQLCommon) GetAssemblyFailures(ctx context.Context, namespace string, filter database.Filter) (failures []*core.AssemblyFailure, res *database.FilterResult, err error) {
+
+	cols := append([]string{}, assemblyFailureColumns...)
+	cols = append(cols, sequenceColumn)
+	query, fop, fi, err := s.filterSelect(ctx, "", sq.Select(cols...).From(assemblyFailuresTable), filter, assemblyFailureFilterFieldMap, []interface{}{"sequence"}, sq.Eq{"namespace": namespace})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, tx, err := s.query(ctx, assemblyFailuresTable, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	failures = []*core.AssemblyFailure{}
+	for rows.Next() {
+		failure, err := s.assemblyFailureResult(ctx, rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		failures = append(failures, failure)
+	}
+
+	return failures, s.queryRes(ctx, assemblyFailuresTable, tx, fop, fi), err
+
+}
+
+func (s *SQLCommon) DeleteAssemblyFailure(ctx context.Context, namespace string, msgID *fftypes.UUID) (err error) {
+
+	ctx, tx, autoCommit, err := s.beginOrUseTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.rollbackTx(ctx, tx, autoCommit)
+
+	err = s.deleteTx(ctx, assemblyFailuresTable, tx, sq.Delete(assemblyFailuresTable).Where(sq.Eq{
+		"namespace":  namespace,
+		"message_id": msgID,
+	}), nil /* no change events for assembly failures */)
+	if err != nil {
+		return err
+	}
+
+	return s.commitTx(ctx, tx, autoCommit)
+}
@@ -0,0 +1,202 @@
+// Copyright © 2021 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlcommon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssemblyFailuresE2EWithDB(t *testing.T) {
+	log.SetLevel("debug")
+
+	s, cleanup := newSQLiteTestProvider(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Record a new failure
+	dataID := fftypes.NewUUID()
+	failure := &core.AssemblyFailure{
+		Namespace:   "ns1",
+		Message:     fftypes.NewUUID(),
+		MissingData: core.FFStringArray{dataID.String()},
+		Attempts:    3,
+		Error:       "pop",
+		Created:     fftypes.Now(),
+		Updated:     fftypes.Now(),
+	}
+	err := s.UpsertAssemblyFailure(ctx, failure)
+	assert.NoError(t, err)
+
+	// Query back the failure
+	fb := database.AssemblyFailureQueryFactory.NewFilter(ctx)
+	filter := fb.And(
+		fb.Eq("message", failure.Message),
+		fb.Contains("missingdata", dataID.String()),
+	)
+	failures, res, err := s.GetAssemblyFailures(ctx, "ns1", filter.Count(true))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(failures))
+	assert.Equal(t, int64(1), *res.TotalCount)
+	failureJson, _ := json.Marshal(&failure)
+	failureReadJson, _ := json.Marshal(failures[0])
+	assert.Equal(t, string(failureJson), string(failureReadJson))
+
+	// Update the failure on a further attempt, keeping the time it was first recorded
+	created := failure.Created
+	failureUpdated := &core.AssemblyFailure{
+		Namespace:   "ns1",
+		Message:     failure.Message,
+		MissingData: core.FFStringArray{dataID.String()},
+		Attempts:    4,
+		Error:       "pop again",
+		Created:     fftypes.Now(),
+		Updated:     fftypes.Now(),
+	}
+	err = s.UpsertAssemblyFailure(ctx, failureUpdated)
+	assert.NoError(t, err)
+	assert.Equal(t, failure.RowID, failureUpdated.RowID)
+	failures, _, err = s.GetAssemblyFailures(ctx, "ns1", fb.Gt("attempts", 3))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(failures))
+	assert.Equal(t, int64(4), failures[0].Attempts)
+	assert.Equal(t, "pop again", failures[0].Error)
+	assert.Equal(t, created.String(), failures[0].Created.String())
+
+	// Not visible in another namespace
+	failures, _, err = s.GetAssemblyFailures(ctx, "ns2", filter)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(failures))
+
+	// Test delete
+	err = s.DeleteAssemblyFailure(ctx, "ns1", failure.Message)
+	assert.NoError(t, err)
+	failures, _, err = s.GetAssemblyFailures(ctx, "ns1", filter)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(failures))
+}
+
+func TestUpsertAssemblyFailureFailBegin(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin().WillReturnError(fmt.Errorf("pop"))
+	err := s.UpsertAssemblyFailure(context.Background(), &core.AssemblyFailure{})
+	assert.Regexp(t, "FF10114", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpsertAssemblyFailureFailSelect(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .*").WillReturnError(fmt.Errorf("pop"))
+	mock.ExpectRollback()
+	err := s.UpsertAssemblyFailure(context.Background(), &core.AssemblyFailure{})
+	assert.Regexp(t, "FF10115", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpsertAssemblyFailureFailScan(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows([]string{"seq"}).AddRow("only one"))
+	mock.ExpectRollback()
+	err := s.UpsertAssemblyFailure(context.Background(), &core.AssemblyFailure{})
+	assert.Regexp(t, "FF10121", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpsertAssemblyFailureFailInsert(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows([]string{"seq", "created"}))
+	mock.ExpectExec("INSERT .*").WillReturnError(fmt.Errorf("pop"))
+	mock.ExpectRollback()
+	err := s.UpsertAssemblyFailure(context.Background(), &core.AssemblyFailure{})
+	assert.Regexp(t, "FF10116", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpsertAssemblyFailureFailUpdate(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows([]string{"seq", "created"}).AddRow(1, 12345))
+	mock.ExpectExec("UPDATE .*").WillReturnError(fmt.Errorf("pop"))
+	mock.ExpectRollback()
+	err := s.UpsertAssemblyFailure(context.Background(), &core.AssemblyFailure{})
+	assert.Regexp(t, "FF10117", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpsertAssemblyFailureFailCommit(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows([]string{"seq", "created"}))
+	mock.ExpectExec("INSERT .*").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit().WillReturnError(fmt.Errorf("pop"))
+	err := s.UpsertAssemblyFailure(context.Background(), &core.AssemblyFailure{})
+	assert.Regexp(t, "FF10119", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAssemblyFailuresQueryFail(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectQuery("SELECT .*").WillReturnError(fmt.Errorf("pop"))
+	f := database.AssemblyFailureQueryFactory.NewFilter(context.Background()).Eq("error", "")
+	_, _, err := s.GetAssemblyFailures(context.Background(), "ns1", f)
+	assert.Regexp(t, "FF10115", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAssemblyFailuresBuildQueryFail(t *testing.T) {
+	s, _ := newMockProvider().init()
+	f := database.AssemblyFailureQueryFactory.NewFilter(context.Background()).Eq("error", map[bool]bool{true: false})
+	_, _, err := s.GetAssemblyFailures(context.Background(), "ns1", f)
+	assert.Regexp(t, "FF00143.*type", err)
+}
+
+func TestGetAssemblyFailuresReadFail(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows([]string{"namespace"}).AddRow("only one"))
+	f := database.AssemblyFailureQueryFactory.NewFilter(context.Background()).Eq("error", "")
+	_, _, err := s.GetAssemblyFailures(context.Background(), "ns1", f)
+	assert.Regexp(t, "FF10121", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteAssemblyFailureBeginFail(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin().WillReturnError(fmt.Errorf("pop"))
+	err := s.DeleteAssemblyFailure(context.Background(), "ns1", fftypes.NewUUID())
+	assert.Regexp(t, "FF10114", err)
+}
+
+func TestDeleteAssemblyFailureFail(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE .*").WillReturnError(fmt.Errorf("pop"))
+	mock.ExpectRollback()
+	err := s.DeleteAssemblyFailure(context.Background(), "ns1", fftypes.NewUUID())
+	assert.Regexp(t, "FF10118", err)
+}
@@ -44,13 +44,18 @@ var (
 		"tx_type",
 		"tx_id",
 		"node_id",
+		"correlator",
+		"seal_reason",
+		"annotations",
+		"provenance",
 	}
 	batchFilterFieldMap = map[string]string{
-		"type":    "btype",
-		"tx.type": "tx_type",
-		"tx.id":   "tx_id",
-		"group":   "group_hash",
-		"node":    "node_id",
+		"type":       "btype",
+		"tx.type":    "tx_type",
+		"tx.id":      "tx_id",
+		"group":      "group_hash",
+		"node":       "node_id",
+		"sealreason": "seal_reason",
 	}
 )
 
@@ -101,6 +106,10 @@ func (s *SQLCommon) UpsertBatch(ctx context.Context, batch *core.BatchPersisted)
 				Set("tx_type", batch.TX.Type).
 				Set("tx_id", batch.TX.ID).
 				Set("node_id", batch.Node).
+				Set("correlator", batch.Correlator).
+				Set("seal_reason", batch.SealReason).
+				Set("annotations", batch.Annotations).
+				Set("provenance", batch.Provenance).
 				Where(sq.Eq{"id": batch.ID, "namespace": batch.Namespace}),
 			func() {
 				s.callbacks.UUIDCollectionNSEvent(database.CollectionBatches, core.ChangeEventTypeUpdated, batch.Namespace, batch.ID)
@@ -127,6 +136,10 @@ func (s *SQLCommon) UpsertBatch(ctx context.Context, batch *core.BatchPersisted)
 					batch.TX.Type,
 					batch.TX.ID,
 					batch.Node,
+					batch.Correlator,
+					batch.SealReason,
+					batch.Annotations,
+					batch.Provenance,
 				),
 			func() {
 				s.callbacks.UUIDCollectionNSEvent(database.CollectionBatches, core.ChangeEventTypeCreated, batch.Namespace, batch.ID)
@@ -141,6 +154,7 @@ func (s *SQLCommon) UpsertBatch(ctx context.Context, batch *core.BatchPersisted)
 
 func (s *SQLCommon) batchResult(ctx context.Context, row *sql.Rows) (*core.BatchPersisted, error) {
 	var batch core.BatchPersisted
+	var sealReason sql.NullString
 	err := row.Scan(
 		&batch.ID,
 		&batch.Type,
@@ -155,10 +169,15 @@ func (s *SQLCommon) batchResult(ctx context.Context, row *sql.Rows) (*core.Batch
 		&batch.TX.Type,
 		&batch.TX.ID,
 		&batch.Node,
+		&batch.Correlator,
+		&sealReason,
+		&batch.Annotations,
+		&batch.Provenance,
 	)
 	if err != nil {
 		return nil, i18n.WrapError(ctx, err, coremsgs.MsgDBReadErr, batchesTable)
 	}
+	batch.SealReason = core.BatchSealReason(sealReason.String)
 	return &batch, nil
 }
 
@@ -187,6 +206,33 @@ func (s *SQLCommon) GetBatchByID(ctx context.Context, namespace string, id *ffty
 	return batch, nil
 }
 
+// GetBatchByMessageID joins from the message to its batch, so the lookup is by the unique indexes on both tables
+// rather than a scan of the batch manifests
+func (s *SQLCommon) GetBatchByMessageID(ctx context.Context, namespace string, msgID *fftypes.UUID) (message *core.BatchPersisted, err error) {
+
+	cols := make([]string, len(batchColumns))
+	for i, col := range batchColumns {
+		cols[i] = "b." + col
+	}
+	rows, _, err := s.query(ctx, batchesTable,
+		sq.Select(cols...).
+			From("messages AS m").
+			Join("batches AS b ON b.id = m.batch_id").
+			Where(sq.Eq{"m.id": msgID, "m.namespace_local": namespace, "b.namespace": namespace}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		log.L(ctx).Debugf("Batch for message '%s' not found", msgID)
+		return nil, nil
+	}
+
+	return s.batchResult(ctx, rows)
+}
+
 func (s *SQLCommon) GetBatches(ctx context.Context, namespace string, filter database.Filter) (message []*core.BatchPersisted, res *database.FilterResult, err error) {
 
 	query, fop, fi, err := s.filterSelect(ctx, "", sq.Select(batchColumns...).From(batchesTable), filter, batchFilterFieldMap, []interface{}{"sequence"}, sq.Eq{"namespace": namespace})
@@ -103,7 +103,13 @@ func TestBatch2EWithDB(t *testing.T) {
 				{MessageRef: core.MessageRef{ID: msgID2}},
 			},
 		}).String()),
-		Confirmed: fftypes.Now(),
+		Confirmed:   fftypes.Now(),
+		Correlator:  fftypes.NewUUID(),
+		SealReason:  core.BatchSealReasonTimeout,
+		Annotations: fftypes.JSONObject{"traceId": "trace1"},
+		Provenance: fftypes.JSONObject{
+			msgID1.String(): map[string]interface{}{"source": "did:firefly:org/abcd", "readOffset": float64(1000)},
+		},
 	}
 
 	// Rejects hash change
@@ -128,6 +134,8 @@ func TestBatch2EWithDB(t *testing.T) {
 		fb.Eq("author", batchUpdated.Author),
 		fb.Gt("created", "0"),
 		fb.Gt("confirmed", "0"),
+		fb.Eq("sealreason", core.BatchSealReasonTimeout),
+		fb.Contains("annotations", `"traceId":"trace1"`),
 	)
 	batches, _, err := s.GetBatches(ctx, "ns1", filter)
 	assert.NoError(t, err)
@@ -163,6 +171,63 @@ func TestBatch2EWithDB(t *testing.T) {
 	s.callbacks.AssertExpectations(t)
 }
 
+func TestGetBatchByMessageIDWithDB(t *testing.T) {
+
+	s, cleanup := newSQLiteTestProvider(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	batchID := fftypes.NewUUID()
+	batch := &core.BatchPersisted{
+		BatchHeader: core.BatchHeader{
+			ID:        batchID,
+			Type:      core.BatchTypeBroadcast,
+			Namespace: "ns1",
+			Created:   fftypes.Now(),
+		},
+		Hash: fftypes.NewRandB32(),
+		TX: core.TransactionRef{
+			Type: core.TransactionTypeBatchPin,
+		},
+		Manifest: fftypes.JSONAnyPtr("{}"),
+	}
+	msg := &core.Message{
+		LocalNamespace: "ns1",
+		Header: core.MessageHeader{
+			ID:        fftypes.NewUUID(),
+			Type:      core.MessageTypeBroadcast,
+			Namespace: "ns1",
+			Created:   fftypes.Now(),
+			DataHash:  fftypes.NewRandB32(),
+		},
+		BatchID: batchID,
+		Hash:    fftypes.NewRandB32(),
+	}
+
+	s.callbacks.On("UUIDCollectionNSEvent", database.CollectionBatches, core.ChangeEventTypeCreated, "ns1", batchID, mock.Anything).Return()
+	s.callbacks.On("OrderedUUIDCollectionNSEvent", database.CollectionMessages, core.ChangeEventTypeCreated, "ns1", msg.Header.ID, mock.Anything).Return()
+
+	err := s.UpsertBatch(ctx, batch)
+	assert.NoError(t, err)
+	err = s.UpsertMessage(ctx, msg, database.UpsertOptimizationNew)
+	assert.NoError(t, err)
+
+	batchRead, err := s.GetBatchByMessageID(ctx, "ns1", msg.Header.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, batchID, batchRead.ID)
+	assert.Equal(t, batch.Hash, batchRead.Hash)
+
+	// Not found in another namespace, or for an unknown message
+	batchRead, err = s.GetBatchByMessageID(ctx, "ns2", msg.Header.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, batchRead)
+	batchRead, err = s.GetBatchByMessageID(ctx, "ns1", fftypes.NewUUID())
+	assert.NoError(t, err)
+	assert.Nil(t, batchRead)
+
+	s.callbacks.AssertExpectations(t)
+}
+
 func TestUpsertBatchFailBegin(t *testing.T) {
 	s, mock := newMockProvider().init()
 	mock.ExpectBegin().WillReturnError(fmt.Errorf("pop"))
@@ -247,6 +312,22 @@ func TestGetBatchByIDScanFail(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestGetBatchByMessageIDSelectFail(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectQuery("SELECT .*").WillReturnError(fmt.Errorf("pop"))
+	_, err := s.GetBatchByMessageID(context.Background(), "ns1", fftypes.NewUUID())
+	assert.Regexp(t, "FF10115", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetBatchByMessageIDScanFail(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("only one"))
+	_, err := s.GetBatchByMessageID(context.Background(), "ns1", fftypes.NewUUID())
+	assert.Regexp(t, "FF10121", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestGetBatchesQueryFail(t *testing.T) {
 	s, mock := newMockProvider().init()
 	mock.ExpectQuery("SELECT .*").WillReturnError(fmt.Errorf("pop"))
@@ -0,0 +1,136 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlcommon
+
+import (
+	"context"
+	"database/sql"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+	"github.com/hyperledger/firefly/pkg/core"
+)
+
+var (
+	dispatcherOptionsColumns = []string{
+		"namespace",
+		"dispatcher",
+		"options",
+		"updated",
+	}
+)
+
+const dispatcherOptionsTable = "dispatcher_options"
+
+func (s *SQLCommon) UpsertDispatcherOptions(ctx context.Context, record *core.DispatcherOptionsRecord) (err error) {
+	ctx, tx, autoCommit, err := s.beginOrUseTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.rollbackTx(ctx, tx, autoCommit)
+
+	// Do a select within the transaction to determine if the dispatcher already has a record
+	rows, _, err := s.queryTx(ctx, dispatcherOptionsTable, tx,
+		sq.Select(sequenceColumn).
+			From(dispatcherOptionsTable).
+			Where(sq.Eq{
+				"namespace":  record.Namespace,
+				"dispatcher": record.Dispatcher,
+			}),
+	)
+	if err != nil {
+		return err
+	}
+	existing := rows.Next()
+	if existing {
+		if err := rows.Scan(&record.RowID); err != nil {
+			rows.Close()
+			return i18n.WrapError(ctx, err, coremsgs.MsgDBReadErr, dispatcherOptionsTable)
+		}
+	}
+	rows.Close()
+
+	if existing {
+		if _, err = s.updateTx(ctx, dispatcherOptionsTable, tx,
+			sq.Update(dispatcherOptionsTable).
+				Set("options", record.Options).
+				Set("updated", record.Updated).
+				Where(sq.Eq{sequenceColumn: record.RowID}),
+			nil, // no change events for dispatcher options
+		); err != nil {
+			return err
+		}
+	} else {
+		if record.RowID, err = s.insertTx(ctx, dispatcherOptionsTable, tx,
+			sq.Insert(dispatcherOptionsTable).
+				Columns(dispatcherOptionsColumns...).
+				Values(
+					record.Namespace,
+					record.Dispatcher,
+					record.Options,
+					record.Updated,
+				),
+			nil, // no change events for dispatcher options
+		); err != nil {
+			return err
+		}
+	}
+
+	return s.commitTx(ctx, tx, autoCommit)
+}
+
+func (s *SQLCommon) dispatcherOptionsResult(ctx context.Context, row *sql.Rows) (*core.DispatcherOptionsRecord, error) {
+	record := core.DispatcherOptionsRecord{}
+	err := row.Scan(
+		&record.Namespace,
+		&record.Dispatcher,
+		&record.Options,
+		&record.Updated,
+		&record.RowID, // must include sequenceColumn in colum list
+	)
+	if err != nil {
+		return nil, i18n.WrapError(ctx, err, coremsgs.MsgDBReadErr, dispatcherOptionsTable)
+	}
+	return &record, nil
+}
+
+func (s *SQLCommon) GetDispatcherOptions(ctx context.Context, namespace, dispatcher string) (record *core.DispatcherOptionsRecord, err error) {
+
+	cols := append([]string{}, dispatcherOptionsColumns...)
+	cols = append(cols, sequenceColumn)
+	rows, _, err := s.query(ctx, dispatcherOptionsTable,
+		sq.Select(cols...).
+			From(dispatcherOptionsTable).
+			Where(sq.Eq{
+				"namespace":  namespace,
+				"dispatcher": dispatcher,
+			}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		log.L(ctx).Debugf("Dispatcher options '%s:%s' not found", namespace, dispatcher)
+		return nil, nil
+	}
+
+	return s.dispatcherOptionsResult(ctx, rows)
+}
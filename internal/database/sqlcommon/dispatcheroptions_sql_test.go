@@ -0,0 +1,157 @@
+// Copyright © 2021 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlcommon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatcherOptionsE2EWithDB(t *testing.T) {
+	log.SetLevel("debug")
+
+	s, cleanup := newSQLiteTestProvider(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Not found before the first upsert
+	record, err := s.GetDispatcherOptions(ctx, "ns1", "dispatcher1")
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+
+	// Create the record
+	record = &core.DispatcherOptionsRecord{
+		Namespace:  "ns1",
+		Dispatcher: "dispatcher1",
+		Options:    fftypes.JSONAnyPtr(`{"batchMaxSize":100}`),
+		Updated:    fftypes.Now(),
+	}
+	err = s.UpsertDispatcherOptions(ctx, record)
+	assert.NoError(t, err)
+
+	recordRead, err := s.GetDispatcherOptions(ctx, "ns1", "dispatcher1")
+	assert.NoError(t, err)
+	recordJson, _ := json.Marshal(&record)
+	recordReadJson, _ := json.Marshal(&recordRead)
+	assert.Equal(t, string(recordJson), string(recordReadJson))
+	assert.Equal(t, record.RowID, recordRead.RowID)
+
+	// Update the record
+	updated := &core.DispatcherOptionsRecord{
+		Namespace:  "ns1",
+		Dispatcher: "dispatcher1",
+		Options:    fftypes.JSONAnyPtr(`{"batchMaxSize":200}`),
+		Updated:    fftypes.Now(),
+	}
+	err = s.UpsertDispatcherOptions(ctx, updated)
+	assert.NoError(t, err)
+	assert.Equal(t, record.RowID, updated.RowID)
+
+	recordRead, err = s.GetDispatcherOptions(ctx, "ns1", "dispatcher1")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"batchMaxSize":200}`, recordRead.Options.String())
+
+	// Not visible in another namespace
+	recordRead, err = s.GetDispatcherOptions(ctx, "ns2", "dispatcher1")
+	assert.NoError(t, err)
+	assert.Nil(t, recordRead)
+}
+
+func TestUpsertDispatcherOptionsFailBegin(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin().WillReturnError(fmt.Errorf("pop"))
+	err := s.UpsertDispatcherOptions(context.Background(), &core.DispatcherOptionsRecord{})
+	assert.Regexp(t, "FF10114", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpsertDispatcherOptionsFailSelect(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .*").WillReturnError(fmt.Errorf("pop"))
+	mock.ExpectRollback()
+	err := s.UpsertDispatcherOptions(context.Background(), &core.DispatcherOptionsRecord{})
+	assert.Regexp(t, "FF10115", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpsertDispatcherOptionsFailScan(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows([]string{sequenceColumn}).AddRow("not a number"))
+	mock.ExpectRollback()
+	err := s.UpsertDispatcherOptions(context.Background(), &core.DispatcherOptionsRecord{})
+	assert.Regexp(t, "FF10121", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpsertDispatcherOptionsFailInsert(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows([]string{}))
+	mock.ExpectExec("INSERT .*").WillReturnError(fmt.Errorf("pop"))
+	mock.ExpectRollback()
+	err := s.UpsertDispatcherOptions(context.Background(), &core.DispatcherOptionsRecord{})
+	assert.Regexp(t, "FF10116", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpsertDispatcherOptionsFailUpdate(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows([]string{sequenceColumn}).AddRow(int64(1)))
+	mock.ExpectExec("UPDATE .*").WillReturnError(fmt.Errorf("pop"))
+	mock.ExpectRollback()
+	err := s.UpsertDispatcherOptions(context.Background(), &core.DispatcherOptionsRecord{})
+	assert.Regexp(t, "FF10117", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpsertDispatcherOptionsFailCommit(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows([]string{}))
+	mock.ExpectExec("INSERT .*").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit().WillReturnError(fmt.Errorf("pop"))
+	err := s.UpsertDispatcherOptions(context.Background(), &core.DispatcherOptionsRecord{})
+	assert.Regexp(t, "FF10119", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetDispatcherOptionsQueryFail(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectQuery("SELECT .*").WillReturnError(fmt.Errorf("pop"))
+	_, err := s.GetDispatcherOptions(context.Background(), "ns1", "dispatcher1")
+	assert.Regexp(t, "FF10115", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetDispatcherOptionsReadFail(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows([]string{"namespace"}).AddRow("only one"))
+	_, err := s.GetDispatcherOptions(context.Background(), "ns1", "dispatcher1")
+	assert.Regexp(t, "FF10121", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -36,15 +36,17 @@ var (
 		"namespace",
 		"ref",
 		"cid",
+		"batch_cid",
 		"tx_id",
 		"topic",
 		"created",
 	}
 	eventFilterFieldMap = map[string]string{
-		"type":       "etype",
-		"reference":  "ref",
-		"correlator": "cid",
-		"tx":         "tx_id",
+		"type":            "etype",
+		"reference":       "ref",
+		"correlator":      "cid",
+		"batchcorrelator": "batch_cid",
+		"tx":              "tx_id",
 	}
 )
 
@@ -78,6 +80,7 @@ func (s *SQLCommon) setEventInsertValues(query sq.InsertBuilder, event *core.Eve
 		event.Namespace,
 		event.Reference,
 		event.Correlator,
+		event.BatchCorrelator,
 		event.Transaction,
 		event.Topic,
 		event.Created,
@@ -145,6 +148,7 @@ func (s *SQLCommon) eventResult(ctx context.Context, row *sql.Rows) (*core.Event
 		&event.Namespace,
 		&event.Reference,
 		&event.Correlator,
+		&event.BatchCorrelator,
 		&event.Transaction,
 		&event.Topic,
 		&event.Created,
@@ -40,13 +40,14 @@ func TestEventE2EWithDB(t *testing.T) {
 	// Create a new event entry
 	eventID := fftypes.NewUUID()
 	event := &core.Event{
-		ID:         eventID,
-		Namespace:  "ns1",
-		Type:       core.EventTypeMessageConfirmed,
-		Reference:  fftypes.NewUUID(),
-		Correlator: fftypes.NewUUID(),
-		Topic:      "topic1",
-		Created:    fftypes.Now(),
+		ID:              eventID,
+		Namespace:       "ns1",
+		Type:            core.EventTypeMessageConfirmed,
+		Reference:       fftypes.NewUUID(),
+		Correlator:      fftypes.NewUUID(),
+		BatchCorrelator: fftypes.NewUUID(),
+		Topic:           "topic1",
+		Created:         fftypes.Now(),
 	}
 
 	s.callbacks.On("OrderedUUIDCollectionNSEvent", database.CollectionEvents, core.ChangeEventTypeCreated, "ns1", eventID, mock.Anything).Return()
@@ -69,6 +70,7 @@ func TestEventE2EWithDB(t *testing.T) {
 	filter := fb.And(
 		fb.Eq("id", eventRead.ID.String()),
 		fb.Eq("reference", eventRead.Reference.String()),
+		fb.Eq("batchcorrelator", eventRead.BatchCorrelator.String()),
 	)
 	events, res, err := s.GetEvents(ctx, "ns1", filter.Count(true))
 	assert.NoError(t, err)
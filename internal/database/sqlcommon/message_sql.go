@@ -19,7 +19,9 @@ package sqlcommon
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
 	"fmt"
+	"strconv"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/hyperledger/firefly-common/pkg/fftypes"
@@ -50,12 +52,16 @@ var (
 		"confirmed",
 		"tx_type",
 		"batch_id",
+		"idempotency_key",
+		"priority",
+		"flush",
 	}
 	msgFilterFieldMap = map[string]string{
-		"type":   "mtype",
-		"txtype": "tx_type",
-		"batch":  "batch_id",
-		"group":  "group_hash",
+		"type":           "mtype",
+		"txtype":         "tx_type",
+		"batch":          "batch_id",
+		"group":          "group_hash",
+		"idempotencykey": "idempotency_key",
 	}
 )
 
@@ -80,6 +86,8 @@ func (s *SQLCommon) attemptMessageUpdate(ctx context.Context, tx *txWrapper, mes
 			Set("confirmed", message.Confirmed).
 			Set("tx_type", message.Header.TxType).
 			Set("batch_id", message.BatchID).
+			Set("priority", message.Header.Priority).
+			Set("flush", message.Header.Flush).
 			Where(sq.Eq{
 				"id":              message.Header.ID,
 				"hash":            message.Hash,
@@ -111,9 +119,20 @@ func (s *SQLCommon) setMessageInsertValues(query sq.InsertBuilder, message *core
 		message.Confirmed,
 		message.Header.TxType,
 		message.BatchID,
+		idempotencyKeyValue(message.IdempotencyKey),
+		message.Header.Priority,
+		message.Header.Flush,
 	)
 }
 
+// idempotencyKeyValue stores an empty key as null, as the keys must be unique within a namespace when set
+func idempotencyKeyValue(key string) interface{} {
+	if key == "" {
+		return nil
+	}
+	return key
+}
+
 func (s *SQLCommon) attemptMessageInsert(ctx context.Context, tx *txWrapper, message *core.Message, requestConflictEmptyResult bool) (err error) {
 	message.Sequence, err = s.insertTxExt(ctx, messagesTable, tx,
 		s.setMessageInsertValues(sq.Insert(messagesTable).Columns(msgColumns...), message),
@@ -401,6 +420,8 @@ func (s *SQLCommon) loadDataRefs(ctx context.Context, namespace string, msgs []*
 
 func (s *SQLCommon) msgResult(ctx context.Context, row *sql.Rows) (*core.Message, error) {
 	var msg core.Message
+	var idempotencyKey, priority sql.NullString
+	var flush sql.NullBool
 	err := row.Scan(
 		&msg.Header.ID,
 		&msg.Header.CID,
@@ -420,12 +441,18 @@ func (s *SQLCommon) msgResult(ctx context.Context, row *sql.Rows) (*core.Message
 		&msg.Confirmed,
 		&msg.Header.TxType,
 		&msg.BatchID,
+		&idempotencyKey,
+		&priority,
+		&flush,
 		// Must be added to the list of columns in all selects
 		&msg.Sequence,
 	)
 	if err != nil {
 		return nil, i18n.WrapError(ctx, err, coremsgs.MsgDBReadErr, messagesTable)
 	}
+	msg.IdempotencyKey = idempotencyKey.String
+	msg.Header.Priority = core.MessagePriority(priority.String)
+	msg.Header.Flush = flush.Bool
 	return &msg, nil
 }
 
@@ -565,6 +592,58 @@ func (s *SQLCommon) GetMessages(ctx context.Context, namespace string, filter da
 	return s.getMessagesQuery(ctx, namespace, query, fop, fi, true)
 }
 
+// messageCursor encodes the sequence of the last message returned, as an opaque continuation token
+func messageCursor(sequence int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(sequence, 10)))
+}
+
+func parseMessageCursor(ctx context.Context, cursor string) (int64, error) {
+	if cursor == "" {
+		return -1, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		var sequence int64
+		if sequence, err = strconv.ParseInt(string(b), 10, 64); err == nil {
+			return sequence, nil
+		}
+	}
+	return -1, i18n.NewError(ctx, coremsgs.MsgInvalidMessageCursor, cursor)
+}
+
+func (s *SQLCommon) GetMessagesWithCursor(ctx context.Context, namespace string, filter database.Filter, cursor string) (message []*core.Message, next string, err error) {
+	after, err := parseMessageCursor(ctx, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	fi, err := filter.Finalize()
+	if err != nil {
+		return nil, "", err
+	}
+	// Only messages after the cursor are selected, so gaps in the sequence (from deleted messages) are skipped
+	fop, err := s.filterSelectFinalized(ctx, "", fi, msgFilterFieldMap,
+		sq.Eq{"namespace_local": namespace},
+		sq.Gt{sequenceColumn: after})
+	if err != nil {
+		return nil, "", err
+	}
+	cols := append([]string{}, msgColumns...)
+	cols = append(cols, sequenceColumn)
+	query := sq.Select(cols...).From(messagesTable).Where(fop).OrderBy(sequenceColumn)
+	if fi.Limit > 0 {
+		query = query.Limit(fi.Limit)
+	}
+	msgs, _, err := s.getMessagesQuery(ctx, namespace, query, fop, fi, false)
+	if err != nil {
+		return nil, "", err
+	}
+	next = cursor
+	if len(msgs) > 0 {
+		next = messageCursor(msgs[len(msgs)-1].Sequence)
+	}
+	return msgs, next, nil
+}
+
 func (s *SQLCommon) GetMessagesForData(ctx context.Context, namespace string, dataID *fftypes.UUID, filter database.Filter) (message []*core.Message, fr *database.FilterResult, err error) {
 	cols := make([]string, len(msgColumns)+1)
 	for i, col := range msgColumns {
@@ -588,27 +667,32 @@ func (s *SQLCommon) UpdateMessage(ctx context.Context, namespace string, msgid *
 }
 
 func (s *SQLCommon) UpdateMessages(ctx context.Context, namespace string, filter database.Filter, update database.Update) (err error) {
+	_, err = s.UpdateMessagesCount(ctx, namespace, filter, update)
+	return err
+}
+
+func (s *SQLCommon) UpdateMessagesCount(ctx context.Context, namespace string, filter database.Filter, update database.Update) (count int64, err error) {
 
 	ctx, tx, autoCommit, err := s.beginOrUseTx(ctx)
 	if err != nil {
-		return err
+		return -1, err
 	}
 	defer s.rollbackTx(ctx, tx, autoCommit)
 
 	query, err := s.buildUpdate(sq.Update(messagesTable).Where(sq.Eq{"namespace_local": namespace}), update, msgFilterFieldMap)
 	if err != nil {
-		return err
+		return -1, err
 	}
 
 	query, err = s.filterUpdate(ctx, query, filter, msgFilterFieldMap)
 	if err != nil {
-		return err
+		return -1, err
 	}
 
-	_, err = s.updateTx(ctx, messagesTable, tx, query, nil /* no change events filter based update */)
+	count, err = s.updateTx(ctx, messagesTable, tx, query, nil /* no change events filter based update */)
 	if err != nil {
-		return err
+		return -1, err
 	}
 
-	return s.commitTx(ctx, tx, autoCommit)
+	return count, s.commitTx(ctx, tx, autoCommit)
 }
@@ -106,6 +106,8 @@ func TestUpsertE2EWithDB(t *testing.T) {
 			Namespace: "ns12345",
 			Topics:    []string{"topic1", "topic2"},
 			Tag:       "tag1",
+			Priority:  core.MessagePriorityHigh,
+			Flush:     true,
 			Group:     gid,
 			DataHash:  fftypes.NewRandB32(),
 			TxType:    core.TransactionTypeBatchPin,
@@ -298,6 +300,45 @@ func TestUpsertMessageFailCommit(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestInsertMessagesIdempotencyKeyE2EWithDB(t *testing.T) {
+	s, cleanup := newSQLiteTestProvider(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	newMsg := func(key string) *core.Message {
+		return &core.Message{
+			LocalNamespace: "ns1",
+			Header: core.MessageHeader{
+				ID:        fftypes.NewUUID(),
+				Type:      core.MessageTypeBroadcast,
+				Namespace: "ns1",
+				Created:   fftypes.Now(),
+				DataHash:  fftypes.NewRandB32(),
+			},
+			Hash:           fftypes.NewRandB32(),
+			State:          core.MessageStateReady,
+			IdempotencyKey: key,
+		}
+	}
+	msg1 := newMsg("key1")
+	s.callbacks.On("OrderedUUIDCollectionNSEvent", database.CollectionMessages, core.ChangeEventTypeCreated, "ns1", mock.Anything, mock.Anything).Return()
+
+	// Messages without a key do not conflict with each other
+	err := s.InsertMessages(ctx, []*core.Message{msg1, newMsg(""), newMsg("")})
+	assert.NoError(t, err)
+
+	// A second message with the same key is rejected
+	err = s.InsertMessages(ctx, []*core.Message{newMsg("key1")})
+	assert.Regexp(t, "FF10116", err)
+
+	fb := database.MessageQueryFactory.NewFilter(ctx)
+	msgs, _, err := s.GetMessages(ctx, "ns1", fb.Eq("idempotencykey", "key1"))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, msg1.Header.ID, msgs[0].Header.ID)
+	assert.Equal(t, "key1", msgs[0].IdempotencyKey)
+}
+
 func TestInsertMessagesBeginFail(t *testing.T) {
 	s, mock := newMockProvider().init()
 	mock.ExpectBegin().WillReturnError(fmt.Errorf("pop"))
@@ -548,7 +589,7 @@ func TestGetMessageByIDLoadRefsFail(t *testing.T) {
 	cols := append([]string{}, msgColumns...)
 	cols = append(cols, "id()")
 	mock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows(cols).
-		AddRow(msgID.String(), nil, core.MessageTypeBroadcast, "author1", "0x12345", 0, "ns1", "ns1", "t1", "c1", nil, b32.String(), b32.String(), b32.String(), "confirmed", 0, "pin", nil, 0))
+		AddRow(msgID.String(), nil, core.MessageTypeBroadcast, "author1", "0x12345", 0, "ns1", "ns1", "t1", "c1", nil, b32.String(), b32.String(), b32.String(), "confirmed", 0, "pin", nil, nil, nil, nil, 0))
 	mock.ExpectQuery("SELECT .*").WillReturnError(fmt.Errorf("pop"))
 	_, err := s.GetMessageByID(context.Background(), "ns1", msgID)
 	assert.Regexp(t, "FF10115", err)
@@ -595,7 +636,7 @@ func TestGetMessagesLoadRefsFail(t *testing.T) {
 	cols := append([]string{}, msgColumns...)
 	cols = append(cols, "id()")
 	mock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows(cols).
-		AddRow(msgID.String(), nil, core.MessageTypeBroadcast, "author1", "0x12345", 0, "ns1", "ns1", "t1", "c1", nil, b32.String(), b32.String(), b32.String(), "confirmed", 0, "pin", nil, 0))
+		AddRow(msgID.String(), nil, core.MessageTypeBroadcast, "author1", "0x12345", 0, "ns1", "ns1", "t1", "c1", nil, b32.String(), b32.String(), b32.String(), "confirmed", 0, "pin", nil, nil, nil, nil, 0))
 	mock.ExpectQuery("SELECT .*").WillReturnError(fmt.Errorf("pop"))
 	f := database.MessageQueryFactory.NewFilter(context.Background()).Gt("confirmed", "0")
 	_, _, err := s.GetMessages(context.Background(), "ns1", f)
@@ -654,6 +695,28 @@ func TestMessagesUpdateBuildFilterFail(t *testing.T) {
 	assert.Regexp(t, "FF00143.*id", err)
 }
 
+func TestMessagesUpdateCount(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE .*").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	f := database.MessageQueryFactory.NewFilter(context.Background()).Eq("state", core.MessageStateReady)
+	u := database.MessageQueryFactory.NewUpdate(context.Background()).Set("state", core.MessageStateSent)
+	count, err := s.UpdateMessagesCount(context.Background(), "ns1", f, u)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessagesUpdateCountBeginFail(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin().WillReturnError(fmt.Errorf("pop"))
+	f := database.MessageQueryFactory.NewFilter(context.Background()).Eq("state", core.MessageStateReady)
+	u := database.MessageQueryFactory.NewUpdate(context.Background()).Set("state", core.MessageStateSent)
+	_, err := s.UpdateMessagesCount(context.Background(), "ns1", f, u)
+	assert.Regexp(t, "FF10114", err)
+}
+
 func TestMessageUpdateFail(t *testing.T) {
 	s, mock := newMockProvider().init()
 	mock.ExpectBegin()
@@ -681,3 +744,78 @@ func TestGetBatchIDsForMessagesScanFail(t *testing.T) {
 	assert.Regexp(t, "FF10121", err)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestGetMessagesWithCursorE2EWithDB(t *testing.T) {
+	s, cleanup := newSQLiteTestProvider(t)
+	defer cleanup()
+	ctx := context.Background()
+	s.callbacks.On("OrderedUUIDCollectionNSEvent", database.CollectionMessages, core.ChangeEventTypeCreated, mock.Anything, mock.Anything, mock.Anything).Return()
+
+	insertMessage := func(ns string) *core.Message {
+		msg := &core.Message{
+			LocalNamespace: ns,
+			Header:         core.MessageHeader{ID: fftypes.NewUUID(), Namespace: ns, Type: core.MessageTypeBroadcast, Created: fftypes.Now(), DataHash: fftypes.NewRandB32()},
+			Hash:           fftypes.NewRandB32(),
+			State:          core.MessageStateReady,
+		}
+		err := s.UpsertMessage(ctx, msg, database.UpsertOptimizationNew)
+		assert.NoError(t, err)
+		return msg
+	}
+	msg1 := insertMessage("ns1")
+	insertMessage("ns2") // a gap in the sequence for ns1
+	msg2 := insertMessage("ns1")
+	msg3 := insertMessage("ns1")
+
+	fb := database.MessageQueryFactory.NewFilter(ctx)
+	filter := fb.Eq("state", core.MessageStateReady).Limit(2)
+	msgs, cursor, err := s.GetMessagesWithCursor(ctx, "ns1", filter, "")
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+	assert.Equal(t, *msg1.Header.ID, *msgs[0].Header.ID)
+	assert.Equal(t, *msg2.Header.ID, *msgs[1].Header.ID)
+
+	// A message inserted while iterating is picked up after those already in the table
+	msg4 := insertMessage("ns1")
+	msgs, cursor, err = s.GetMessagesWithCursor(ctx, "ns1", filter, cursor)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+	assert.Equal(t, *msg3.Header.ID, *msgs[0].Header.ID)
+	assert.Equal(t, *msg4.Header.ID, *msgs[1].Header.ID)
+
+	// The cursor is unchanged at the end
+	msgs, next, err := s.GetMessagesWithCursor(ctx, "ns1", filter, cursor)
+	assert.NoError(t, err)
+	assert.Empty(t, msgs)
+	assert.Equal(t, cursor, next)
+}
+
+func TestGetMessagesWithCursorBadCursor(t *testing.T) {
+	s, mock := newMockProvider().init()
+	f := database.MessageQueryFactory.NewFilter(context.Background()).Eq("id", "")
+	_, _, err := s.GetMessagesWithCursor(context.Background(), "ns1", f, "!!!")
+	assert.Regexp(t, "FF10434", err)
+	_, _, err = s.GetMessagesWithCursor(context.Background(), "ns1", f, "YWJj" /* abc */)
+	assert.Regexp(t, "FF10434", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetMessagesWithCursorBadFilter(t *testing.T) {
+	s, mock := newMockProvider().init()
+	f := database.MessageQueryFactory.NewFilter(context.Background()).Eq("!wrong", "")
+	_, _, err := s.GetMessagesWithCursor(context.Background(), "ns1", f, "")
+	assert.Regexp(t, "FF00142", err)
+	f = database.MessageQueryFactory.NewFilter(context.Background()).Eq("id", map[bool]bool{true: false})
+	_, _, err = s.GetMessagesWithCursor(context.Background(), "ns1", f, "")
+	assert.Regexp(t, "FF00143.*id", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetMessagesWithCursorQueryFail(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectQuery("SELECT .*").WillReturnError(fmt.Errorf("pop"))
+	f := database.MessageQueryFactory.NewFilter(context.Background()).Eq("id", "")
+	_, _, err := s.GetMessagesWithCursor(context.Background(), "ns1", f, messageCursor(12345))
+	assert.Regexp(t, "FF10115", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
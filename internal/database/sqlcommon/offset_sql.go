@@ -220,3 +220,25 @@ func (s *SQLCommon) DeleteOffset(ctx context.Context, t core.OffsetType, name st
 
 	return s.commitTx(ctx, tx, autoCommit)
 }
+
+func (s *SQLCommon) DeleteOffsetHistory(ctx context.Context, t core.OffsetType, name string, keepRowID int64) (err error) {
+
+	ctx, tx, autoCommit, err := s.beginOrUseTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.rollbackTx(ctx, tx, autoCommit)
+
+	err = s.deleteTx(ctx, offsetsTable, tx, sq.Delete(offsetsTable).Where(sq.And{
+		sq.Eq{
+			"otype": t,
+			"name":  name,
+		},
+		sq.NotEq{sequenceColumn: keepRowID},
+	}), nil /* offsets do not have change events */)
+	if err != nil && err != database.DeleteRecordNotFound {
+		return err
+	}
+
+	return s.commitTx(ctx, tx, autoCommit)
+}
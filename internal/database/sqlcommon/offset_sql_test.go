@@ -107,6 +107,13 @@ func TestOffsetsE2EWithDB(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(offsets))
 
+	// Test compaction retains the current row
+	err = s.DeleteOffsetHistory(ctx, core.OffsetTypeBatch, offsetUpdated.Name, offsetUpdated.RowID)
+	assert.NoError(t, err)
+	offsets, _, err = s.GetOffsets(ctx, filter)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(offsets))
+
 	// Test delete
 	err = s.DeleteOffset(ctx, core.OffsetTypeBatch, offsetUpdated.Name)
 	assert.NoError(t, err)
@@ -283,3 +290,41 @@ func TestOffsetDeleteFail(t *testing.T) {
 	err := s.DeleteOffset(context.Background(), core.OffsetTypeSubscription, "sub1")
 	assert.Regexp(t, "FF10118", err)
 }
+
+func TestOffsetDeleteHistory(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM offsets WHERE \\(name = .* AND otype = .* AND seq <> .*\\)").
+		WithArgs("ff_batch_ns1", core.OffsetTypeBatch, int64(12345)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	err := s.DeleteOffsetHistory(context.Background(), core.OffsetTypeBatch, "ff_batch_ns1", 12345)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestOffsetDeleteHistoryNoneStale(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE .*").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	err := s.DeleteOffsetHistory(context.Background(), core.OffsetTypeBatch, "ff_batch_ns1", 12345)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestOffsetDeleteHistoryBeginFail(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin().WillReturnError(fmt.Errorf("pop"))
+	err := s.DeleteOffsetHistory(context.Background(), core.OffsetTypeBatch, "ff_batch_ns1", 12345)
+	assert.Regexp(t, "FF10114", err)
+}
+
+func TestOffsetDeleteHistoryFail(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE .*").WillReturnError(fmt.Errorf("pop"))
+	mock.ExpectRollback()
+	err := s.DeleteOffsetHistory(context.Background(), core.OffsetTypeBatch, "ff_batch_ns1", 12345)
+	assert.Regexp(t, "FF10118", err)
+}
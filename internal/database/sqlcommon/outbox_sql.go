@@ -0,0 +1,131 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlcommon
+
+import (
+	"context"
+	"database/sql"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly/internal/coremsgs"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+)
+
+var (
+	outboxColumns = []string{
+		"namespace",
+		"batch_id",
+		"dispatcher",
+		"payload",
+		"created",
+	}
+	outboxFilterFieldMap = map[string]string{
+		"batch": "batch_id",
+	}
+)
+
+const outboxTable = "outbox"
+
+func (s *SQLCommon) InsertOutboxEntry(ctx context.Context, entry *core.OutboxEntry) (err error) {
+	ctx, tx, autoCommit, err := s.beginOrUseTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.rollbackTx(ctx, tx, autoCommit)
+
+	entry.Sequence, err = s.insertTx(ctx, outboxTable, tx,
+		sq.Insert(outboxTable).
+			Columns(outboxColumns...).
+			Values(
+				entry.Namespace,
+				entry.Batch,
+				entry.Dispatcher,
+				entry.Payload,
+				entry.Created,
+			),
+		nil, // no change events for outbox entries
+	)
+	if err != nil {
+		return err
+	}
+
+	return s.commitTx(ctx, tx, autoCommit)
+}
+
+func (s *SQLCommon) outboxResult(ctx context.Context, row *sql.Rows) (*core.OutboxEntry, error) {
+	entry := core.OutboxEntry{}
+	err := row.Scan(
+		&entry.Namespace,
+		&entry.Batch,
+		&entry.Dispatcher,
+		&entry.Payload,
+		&entry.Created,
+		&entry.Sequence,
+	)
+	if err != nil {
+		return nil, i18n.WrapError(ctx, err, coremsgs.MsgDBReadErr, outboxTable)
+	}
+	return &entry, nil
+}
+
+func (s *SQLCommon) GetOutboxEntries(ctx context.Context, namespace string, filter database.Filter) (entries []*core.OutboxEntry, res *database.FilterResult, err error) {
+
+	cols := append([]string{}, outboxColumns...)
+	cols = append(cols, sequenceColumn)
+	query, fop, fi, err := s.filterSelect(ctx, "", sq.Select(cols...).From(outboxTable), filter, outboxFilterFieldMap, []interface{}{"sequence"}, sq.Eq{"namespace": namespace})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, tx, err := s.query(ctx, outboxTable, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	entries = []*core.OutboxEntry{}
+	for rows.Next() {
+		entry, err := s.outboxResult(ctx, rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, s.queryRes(ctx, outboxTable, tx, fop, fi), err
+
+}
+
+func (s *SQLCommon) DeleteOutboxEntry(ctx context.Context, sequence int64) (err error) {
+
+	ctx, tx, autoCommit, err := s.beginOrUseTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.rollbackTx(ctx, tx, autoCommit)
+
+	err = s.deleteTx(ctx, outboxTable, tx, sq.Delete(outboxTable).Where(sq.Eq{
+		sequenceColumn: sequence,
+	}), nil /* no change events for outbox entries */)
+	if err != nil {
+		return err
+	}
+
+	return s.commitTx(ctx, tx, autoCommit)
+}
@@ -0,0 +1,147 @@
+// Copyright © 2021 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlcommon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly/pkg/core"
+	"github.com/hyperledger/firefly/pkg/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboxE2EWithDB(t *testing.T) {
+	log.SetLevel("debug")
+
+	s, cleanup := newSQLiteTestProvider(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Create a new outbox entry
+	entry := &core.OutboxEntry{
+		Namespace:  "ns1",
+		Batch:      fftypes.NewUUID(),
+		Dispatcher: "dispatcher1",
+		Payload:    fftypes.JSONAnyPtr(`{"some":"batch"}`),
+		Created:    fftypes.Now(),
+	}
+	err := s.InsertOutboxEntry(ctx, entry)
+	assert.NoError(t, err)
+
+	// Query back the entry
+	fb := database.OutboxQueryFactory.NewFilter(ctx)
+	filter := fb.And(
+		fb.Eq("batch", entry.Batch),
+		fb.Eq("dispatcher", entry.Dispatcher),
+		fb.Eq("created", entry.Created),
+	)
+	entries, res, err := s.GetOutboxEntries(ctx, "ns1", filter.Count(true))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, int64(1), *res.TotalCount)
+	entryJson, _ := json.Marshal(&entry)
+	entryReadJson, _ := json.Marshal(entries[0])
+	assert.Equal(t, string(entryJson), string(entryReadJson))
+	assert.Equal(t, entry.Sequence, entries[0].Sequence)
+
+	// Not visible in another namespace
+	entries, _, err = s.GetOutboxEntries(ctx, "ns2", filter)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+
+	// Test delete
+	err = s.DeleteOutboxEntry(ctx, entry.Sequence)
+	assert.NoError(t, err)
+	entries, _, err = s.GetOutboxEntries(ctx, "ns1", filter)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+}
+
+func TestInsertOutboxEntryFailBegin(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin().WillReturnError(fmt.Errorf("pop"))
+	err := s.InsertOutboxEntry(context.Background(), &core.OutboxEntry{})
+	assert.Regexp(t, "FF10114", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestInsertOutboxEntryFailInsert(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT .*").WillReturnError(fmt.Errorf("pop"))
+	mock.ExpectRollback()
+	err := s.InsertOutboxEntry(context.Background(), &core.OutboxEntry{})
+	assert.Regexp(t, "FF10116", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestInsertOutboxEntryFailCommit(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT .*").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit().WillReturnError(fmt.Errorf("pop"))
+	err := s.InsertOutboxEntry(context.Background(), &core.OutboxEntry{})
+	assert.Regexp(t, "FF10119", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOutboxEntriesQueryFail(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectQuery("SELECT .*").WillReturnError(fmt.Errorf("pop"))
+	f := database.OutboxQueryFactory.NewFilter(context.Background()).Eq("dispatcher", "")
+	_, _, err := s.GetOutboxEntries(context.Background(), "ns1", f)
+	assert.Regexp(t, "FF10115", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOutboxEntriesBuildQueryFail(t *testing.T) {
+	s, _ := newMockProvider().init()
+	f := database.OutboxQueryFactory.NewFilter(context.Background()).Eq("dispatcher", map[bool]bool{true: false})
+	_, _, err := s.GetOutboxEntries(context.Background(), "ns1", f)
+	assert.Regexp(t, "FF00143.*type", err)
+}
+
+func TestGetOutboxEntriesReadFail(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectQuery("SELECT .*").WillReturnRows(sqlmock.NewRows([]string{"namespace"}).AddRow("only one"))
+	f := database.OutboxQueryFactory.NewFilter(context.Background()).Eq("dispatcher", "")
+	_, _, err := s.GetOutboxEntries(context.Background(), "ns1", f)
+	assert.Regexp(t, "FF10121", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestOutboxEntryDeleteBeginFail(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin().WillReturnError(fmt.Errorf("pop"))
+	err := s.DeleteOutboxEntry(context.Background(), 12345)
+	assert.Regexp(t, "FF10114", err)
+}
+
+func TestOutboxEntryDeleteFail(t *testing.T) {
+	s, mock := newMockProvider().init()
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE .*").WillReturnError(fmt.Errorf("pop"))
+	mock.ExpectRollback()
+	err := s.DeleteOutboxEntry(context.Background(), 12345)
+	assert.Regexp(t, "FF10118", err)
+}
@@ -508,7 +508,7 @@ func (ag *aggregator) processMessage(ctx context.Context, manifest *core.BatchMa
 		}
 
 		l.Debugf("Attempt dispatch msg=%s broadcastContexts=%v privatePins=%v", msg.Header.ID, unmaskedContexts, msg.Pins)
-		newState, dispatched, err = ag.attemptMessageDispatch(ctx, msg, data, manifest.TX.ID, state, pin)
+		newState, dispatched, err = ag.attemptMessageDispatch(ctx, msg, data, manifest.TX.ID, batch.Correlator, state, pin)
 		if err != nil {
 			return err
 		}
@@ -531,7 +531,7 @@ func (ag *aggregator) processMessage(ctx context.Context, manifest *core.BatchMa
 	return nil
 }
 
-func (ag *aggregator) attemptMessageDispatch(ctx context.Context, msg *core.Message, data core.DataArray, tx *fftypes.UUID, state *batchState, pin *core.Pin) (newState core.MessageState, dispatched bool, err error) {
+func (ag *aggregator) attemptMessageDispatch(ctx context.Context, msg *core.Message, data core.DataArray, tx, batchCorrelator *fftypes.UUID, state *batchState, pin *core.Pin) (newState core.MessageState, dispatched bool, err error) {
 	var customCorrelator *fftypes.UUID
 
 	// Check the pin signer is valid for the message
@@ -605,6 +605,7 @@ func (ag *aggregator) attemptMessageDispatch(ctx context.Context, msg *core.Mess
 		for _, topic := range msg.Header.Topics {
 			event := core.NewEvent(eventType, ag.namespace, msg.Header.ID, tx, topic)
 			event.Correlator = msg.Header.CID
+			event.BatchCorrelator = batchCorrelator
 			if customCorrelator != nil {
 				// Definition handlers can set a custom event correlator (such as a token pool ID)
 				event.Correlator = customCorrelator
@@ -1441,7 +1441,7 @@ func TestAttemptMessageDispatchFailValidateData(t *testing.T) {
 		Data: core.DataRefs{
 			{ID: fftypes.NewUUID()},
 		},
-	}, core.DataArray{}, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
+	}, core.DataArray{}, nil, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
 	assert.EqualError(t, err, "pop")
 
 }
@@ -1470,7 +1470,7 @@ func TestAttemptMessageDispatchBadSigner(t *testing.T) {
 			Hash:   blobHash,
 			Public: "public-ref",
 		}},
-	}, nil, bs, &core.Pin{Signer: ""})
+	}, nil, nil, bs, &core.Pin{Signer: ""})
 	assert.NoError(t, err)
 	assert.True(t, dispatched)
 
@@ -1497,7 +1497,7 @@ func TestAttemptMessageDispatchMissingBlobs(t *testing.T) {
 			Hash:   blobHash,
 			Public: "public-ref",
 		}},
-	}, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
+	}, nil, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
 	assert.NoError(t, err)
 	assert.False(t, dispatched)
 
@@ -1522,7 +1522,7 @@ func TestAttemptMessageDispatchMissingTransfers(t *testing.T) {
 		},
 	}
 	msg.Hash = msg.Header.Hash()
-	_, dispatched, err := ag.attemptMessageDispatch(ag.ctx, msg, core.DataArray{}, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
+	_, dispatched, err := ag.attemptMessageDispatch(ag.ctx, msg, core.DataArray{}, nil, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
 	assert.NoError(t, err)
 	assert.False(t, dispatched)
 
@@ -1545,7 +1545,7 @@ func TestAttemptMessageDispatchGetTransfersFail(t *testing.T) {
 		},
 	}
 	msg.Hash = msg.Header.Hash()
-	_, dispatched, err := ag.attemptMessageDispatch(ag.ctx, msg, core.DataArray{}, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
+	_, dispatched, err := ag.attemptMessageDispatch(ag.ctx, msg, core.DataArray{}, nil, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
 	assert.EqualError(t, err, "pop")
 	assert.False(t, dispatched)
 
@@ -1575,7 +1575,7 @@ func TestAttemptMessageDispatchTransferMismatch(t *testing.T) {
 
 	ag.mdi.On("GetTokenTransfers", ag.ctx, "ns1", mock.Anything).Return(transfers, nil, nil)
 
-	_, dispatched, err := ag.attemptMessageDispatch(ag.ctx, msg, core.DataArray{}, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
+	_, dispatched, err := ag.attemptMessageDispatch(ag.ctx, msg, core.DataArray{}, nil, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
 	assert.NoError(t, err)
 	assert.False(t, dispatched)
 
@@ -1610,7 +1610,7 @@ func TestDefinitionBroadcastActionRejectCustomCorrelator(t *testing.T) {
 		Data: core.DataRefs{
 			{ID: fftypes.NewUUID()},
 		},
-	}, core.DataArray{}, nil, bs, &core.Pin{Signer: "0x12345"})
+	}, core.DataArray{}, nil, nil, bs, &core.Pin{Signer: "0x12345"})
 	assert.NoError(t, err)
 	err = bs.RunFinalize(ag.ctx)
 	assert.NoError(t, err)
@@ -1635,7 +1635,7 @@ func TestDefinitionBroadcastInvalidSigner(t *testing.T) {
 		Data: core.DataRefs{
 			{ID: fftypes.NewUUID()},
 		},
-	}, core.DataArray{}, nil, bs, &core.Pin{Signer: "0x12345"})
+	}, core.DataArray{}, nil, nil, bs, &core.Pin{Signer: "0x12345"})
 	assert.NoError(t, err)
 }
 
@@ -1769,7 +1769,7 @@ func TestDefinitionBroadcastActionRetry(t *testing.T) {
 	ag.mdh.On("HandleDefinitionBroadcast", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
 		Return(definitions.HandlerResult{Action: definitions.ActionRetry}, fmt.Errorf("pop"))
 
-	_, _, err := ag.attemptMessageDispatch(ag.ctx, msg1, nil, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
+	_, _, err := ag.attemptMessageDispatch(ag.ctx, msg1, nil, nil, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
 	assert.EqualError(t, err, "pop")
 
 }
@@ -1782,7 +1782,7 @@ func TestDefinitionBroadcastRejectSignerLookupFail(t *testing.T) {
 
 	ag.mim.On("FindIdentityForVerifier", ag.ctx, mock.Anything, mock.Anything).Return(nil, fmt.Errorf("pop"))
 
-	_, valid, err := ag.attemptMessageDispatch(ag.ctx, msg1, nil, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
+	_, valid, err := ag.attemptMessageDispatch(ag.ctx, msg1, nil, nil, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
 	assert.Regexp(t, "pop", err)
 	assert.False(t, valid)
 
@@ -1801,7 +1801,7 @@ func TestDefinitionBroadcastRejectSignerLookupWrongOrg(t *testing.T) {
 		return ev.Type == core.EventTypeMessageRejected
 	})).Return(nil)
 
-	_, valid, err := ag.attemptMessageDispatch(ag.ctx, msg1, nil, nil, bs, &core.Pin{Signer: "0x12345"})
+	_, valid, err := ag.attemptMessageDispatch(ag.ctx, msg1, nil, nil, nil, bs, &core.Pin{Signer: "0x12345"})
 	assert.NoError(t, err)
 	assert.True(t, valid)
 
@@ -1821,7 +1821,7 @@ func TestDefinitionBroadcastParkUnregisteredSignerIdentityClaim(t *testing.T) {
 
 	ag.mdh.On("HandleDefinitionBroadcast", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(definitions.HandlerResult{Action: definitions.ActionWait}, nil)
 
-	newState, valid, err := ag.attemptMessageDispatch(ag.ctx, msg1, nil, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
+	newState, valid, err := ag.attemptMessageDispatch(ag.ctx, msg1, nil, nil, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
 	assert.NoError(t, err)
 	assert.False(t, valid)
 	assert.Empty(t, newState)
@@ -1841,7 +1841,7 @@ func TestDefinitionBroadcastRootUnregistered(t *testing.T) {
 		return ev.Type == core.EventTypeMessageRejected
 	})).Return(nil)
 
-	_, valid, err := ag.attemptMessageDispatch(ag.ctx, msg1, nil, nil, bs, &core.Pin{Signer: "0x12345"})
+	_, valid, err := ag.attemptMessageDispatch(ag.ctx, msg1, nil, nil, nil, bs, &core.Pin{Signer: "0x12345"})
 	assert.NoError(t, err)
 	assert.True(t, valid)
 
@@ -1860,7 +1860,7 @@ func TestDefinitionBroadcastActionWait(t *testing.T) {
 
 	ag.mdh.On("HandleDefinitionBroadcast", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(definitions.HandlerResult{Action: definitions.ActionWait}, nil)
 
-	_, _, err := ag.attemptMessageDispatch(ag.ctx, msg1, nil, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
+	_, _, err := ag.attemptMessageDispatch(ag.ctx, msg1, nil, nil, nil, &batchState{}, &core.Pin{Signer: "0x12345"})
 	assert.NoError(t, err)
 
 }
@@ -1877,7 +1877,7 @@ func TestAttemptMessageDispatchEventFail(t *testing.T) {
 
 	_, _, err := ag.attemptMessageDispatch(ag.ctx, msg1, core.DataArray{
 		&core.Data{ID: msg1.Data[0].ID},
-	}, nil, bs, &core.Pin{Signer: "0x12345"})
+	}, nil, nil, bs, &core.Pin{Signer: "0x12345"})
 	assert.NoError(t, err)
 
 	err = bs.RunFinalize(ag.ctx)
@@ -1885,6 +1885,30 @@ func TestAttemptMessageDispatchEventFail(t *testing.T) {
 
 }
 
+func TestAttemptMessageDispatchBatchCorrelator(t *testing.T) {
+	ag := newTestAggregator()
+	defer ag.cleanup(t)
+	bs := newBatchState(&ag.aggregator)
+	msg1, _, org1, _ := newTestManifest(core.MessageTypeBroadcast, nil)
+	msg1.Header.CID = fftypes.NewUUID()
+	batchCorrelator := fftypes.NewUUID()
+
+	ag.mim.On("FindIdentityForVerifier", ag.ctx, mock.Anything, mock.Anything).Return(org1, nil)
+	ag.mdm.On("ValidateAll", ag.ctx, mock.Anything).Return(true, nil)
+	ag.mdi.On("InsertEvent", ag.ctx, mock.MatchedBy(func(event *core.Event) bool {
+		return event.Correlator.Equals(msg1.Header.CID) && event.BatchCorrelator.Equals(batchCorrelator)
+	})).Return(nil)
+
+	_, _, err := ag.attemptMessageDispatch(ag.ctx, msg1, core.DataArray{
+		&core.Data{ID: msg1.Data[0].ID},
+	}, nil, batchCorrelator, bs, &core.Pin{Signer: "0x12345"})
+	assert.NoError(t, err)
+
+	err = bs.RunFinalize(ag.ctx)
+	assert.NoError(t, err)
+
+}
+
 func TestAttemptMessageDispatchGroupInit(t *testing.T) {
 	ag := newTestAggregator()
 	defer ag.cleanup(t)
@@ -1899,7 +1923,7 @@ func TestAttemptMessageDispatchGroupInit(t *testing.T) {
 			Type:      core.MessageTypeGroupInit,
 			SignerRef: core.SignerRef{Key: "0x12345", Author: org1.DID},
 		},
-	}, nil, nil, bs, &core.Pin{Signer: "0x12345"})
+	}, nil, nil, nil, bs, &core.Pin{Signer: "0x12345"})
 	assert.NoError(t, err)
 
 }
@@ -883,6 +883,7 @@ func (nm *namespaceManager) loadNamespace(ctx context.Context, name string, inde
 
 	config := orchestrator.Config{
 		DefaultKey:          conf.GetString(coreconfig.NamespaceDefaultKey),
+		Batch:               conf.SubSection(coreconfig.NamespaceBatch),
 		TokenBroadcastNames: nm.tokenBroadcastNames,
 		KeyNormalization:    keyNormalization,
 	}
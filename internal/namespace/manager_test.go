@@ -26,6 +26,7 @@ import (
 	"github.com/hyperledger/firefly-common/pkg/auth/authfactory"
 	"github.com/hyperledger/firefly-common/pkg/config"
 	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/internal/batch"
 	"github.com/hyperledger/firefly/internal/blockchain/bifactory"
 	"github.com/hyperledger/firefly/internal/coreconfig"
 	"github.com/hyperledger/firefly/internal/database/difactory"
@@ -1313,6 +1314,33 @@ func TestLoadNamespacesUseDefaults(t *testing.T) {
 	assert.Equal(t, "oldest", nm.namespaces["ns1"].config.Multiparty.Contracts[0].FirstEvent)
 }
 
+func TestLoadNamespacesBatchConfig(t *testing.T) {
+	nm := newTestNamespaceManager(true)
+	defer nm.cleanup(t)
+
+	viper.SetConfigType("yaml")
+	err := viper.ReadConfig(strings.NewReader(`
+  namespaces:
+    default: ns1
+    predefined:
+    - name: ns1
+      batch:
+        readPageSize: 10
+        offsetName: workload1
+    - name: ns2
+  `))
+	assert.NoError(t, err)
+
+	err = nm.loadNamespaces(context.Background())
+	assert.NoError(t, err)
+
+	// Each namespace has its own batch manager tuning, left unset to use the batch.manager config if not given
+	assert.Equal(t, uint(10), nm.namespaces["ns1"].config.Batch.GetUint(batch.ConfigReadPageSize))
+	assert.Equal(t, "workload1", nm.namespaces["ns1"].config.Batch.GetString(batch.ConfigOffsetName))
+	assert.Nil(t, nm.namespaces["ns2"].config.Batch.Get(batch.ConfigReadPageSize))
+	assert.Equal(t, "", nm.namespaces["ns2"].config.Batch.GetString(batch.ConfigOffsetName))
+}
+
 func TestLoadNamespacesNonMultipartyNoDatabase(t *testing.T) {
 	nm := newTestNamespaceManager(true)
 	defer nm.cleanup(t)
@@ -20,6 +20,7 @@ import (
 	"context"
 
 	"github.com/hyperledger/firefly-common/pkg/auth"
+	"github.com/hyperledger/firefly-common/pkg/config"
 	"github.com/hyperledger/firefly-common/pkg/fftypes"
 	"github.com/hyperledger/firefly-common/pkg/i18n"
 	"github.com/hyperledger/firefly-common/pkg/log"
@@ -175,6 +176,7 @@ type Plugins struct {
 
 type Config struct {
 	DefaultKey          string
+	Batch               config.Section
 	KeyNormalization    string
 	Multiparty          multiparty.Config
 	TokenBroadcastNames map[string]string
@@ -401,7 +403,7 @@ func (or *orchestrator) initHandlers(ctx context.Context) (err error) {
 
 func (or *orchestrator) initMultiPartyComponents(ctx context.Context) (err error) {
 	if or.batch == nil {
-		or.batch, err = batch.NewBatchManager(ctx, or.namespace.Name, or.database(), or.data, or.identity, or.txHelper)
+		or.batch, err = batch.NewBatchManagerFromConfig(ctx, or.namespace.Name, or.config.Batch, or.database(), or.data, or.identity, or.txHelper)
 		if err != nil {
 			return err
 		}
@@ -24,7 +24,9 @@ import (
 	"time"
 
 	"github.com/hyperledger/firefly-common/mocks/authmocks"
+	"github.com/hyperledger/firefly-common/pkg/config"
 	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly/internal/batch"
 	"github.com/hyperledger/firefly/internal/cache"
 	"github.com/hyperledger/firefly/internal/coreconfig"
 	"github.com/hyperledger/firefly/internal/identity"
@@ -354,6 +356,8 @@ func TestInitBatchComponentFail(t *testing.T) {
 	defer or.cleanup(t)
 	or.plugins.Database.Plugin = nil
 	or.batch = nil
+	or.config.Batch = config.RootSection("ut.batch")
+	batch.InitConfig(or.config.Batch)
 	or.mmp.On("ConfigureContract", mock.Anything, mock.Anything).Return(nil)
 	err := or.initComponents(context.Background())
 	assert.Regexp(t, "FF10128", err)
@@ -30,7 +30,7 @@ func (or *orchestrator) OrderedUUIDCollectionNSEvent(resType database.OrderedUUI
 	}
 	switch {
 	case eventType == core.ChangeEventTypeCreated && resType == database.CollectionMessages:
-		or.batch.NewMessages() <- sequence
+		or.batch.NotifyNewMessage(sequence)
 	case eventType == core.ChangeEventTypeCreated && resType == database.CollectionEvents:
 		or.events.NewEvents() <- sequence
 	}
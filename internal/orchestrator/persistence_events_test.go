@@ -33,7 +33,7 @@ func TestMessageCreated(t *testing.T) {
 		namespace: &core.Namespace{Name: "ns1", NetworkName: "ns1"},
 		batch:     mb,
 	}
-	mb.On("NewMessages").Return((chan<- int64)(make(chan int64, 1)))
+	mb.On("NotifyNewMessage", int64(12345)).Return()
 	o.OrderedUUIDCollectionNSEvent(database.CollectionMessages, core.ChangeEventTypeCreated, "ns1", fftypes.NewUUID(), 12345)
 	mb.AssertExpectations(t)
 }
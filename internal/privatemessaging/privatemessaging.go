@@ -136,7 +136,7 @@ func NewPrivateMessaging(ctx context.Context, ns *core.Namespace, di database.Pl
 		DisposeTimeout: config.GetDuration(coreconfig.PrivateMessagingBatchAgentTimeout),
 	}
 
-	ba.RegisterDispatcher(pinnedPrivateDispatcherName,
+	err = ba.RegisterDispatcher(pinnedPrivateDispatcherName,
 		core.TransactionTypeBatchPin,
 		[]core.MessageType{
 			core.MessageTypeGroupInit,
@@ -144,13 +144,19 @@ func NewPrivateMessaging(ctx context.Context, ns *core.Namespace, di database.Pl
 			core.MessageTypeTransferPrivate,
 		},
 		pm.dispatchPinnedBatch, bo)
+	if err != nil {
+		return nil, err
+	}
 
-	ba.RegisterDispatcher(unpinnedPrivateDispatcherName,
+	err = ba.RegisterDispatcher(unpinnedPrivateDispatcherName,
 		core.TransactionTypeUnpinned,
 		[]core.MessageType{
 			core.MessageTypePrivate,
 		},
 		pm.dispatchUnpinnedBatch, bo)
+	if err != nil {
+		return nil, err
+	}
 
 	om.RegisterHandler(ctx, pm, []core.OpType{
 		core.OpTypeDataExchangeSendBlob,
@@ -71,14 +71,14 @@ func newTestPrivateMessagingCommon(t *testing.T, metricsEnabled bool) (*privateM
 			core.MessageTypeGroupInit,
 			core.MessageTypePrivate,
 			core.MessageTypeTransferPrivate,
-		}, mock.Anything, mock.Anything).Return()
+		}, mock.Anything, mock.Anything).Return(nil)
 
 	mba.On("RegisterDispatcher",
 		unpinnedPrivateDispatcherName,
 		core.TransactionTypeUnpinned,
 		[]core.MessageType{
 			core.MessageTypePrivate,
-		}, mock.Anything, mock.Anything).Return()
+		}, mock.Anything, mock.Anything).Return(nil)
 	mmi.On("IsMetricsEnabled").Return(metricsEnabled)
 	mom.On("RegisterHandler", mock.Anything, mock.Anything, mock.Anything)
 
@@ -123,6 +123,29 @@ func TestCacheInitFail(t *testing.T) {
 	assert.Equal(t, cacheInitError, err)
 }
 
+func testInitRegisterDispatcherFail(t *testing.T, failDispatcher string) {
+	coreconfig.Reset()
+	mba := &batchmocks.Manager{}
+	cmi := &cachemocks.Manager{}
+	cmi.On("GetCache", mock.Anything).Return(cache.NewUmanagedCache(context.Background(), 100, 5*time.Minute), nil)
+	mba.On("RegisterDispatcher", failDispatcher, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("pop"))
+	mba.On("RegisterDispatcher", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	ns := &core.Namespace{Name: "ns1", NetworkName: "ns1"}
+	_, err := NewPrivateMessaging(context.Background(), ns, &databasemocks.Plugin{}, &dataexchangemocks.Plugin{}, &blockchainmocks.Plugin{},
+		&identitymanagermocks.Manager{}, mba, &datamocks.Manager{}, &syncasyncmocks.Bridge{}, &multipartymocks.Manager{},
+		&metricsmocks.Manager{}, &operationmocks.Manager{}, cmi)
+	assert.Regexp(t, "pop", err)
+}
+
+func TestInitRegisterPinnedDispatcherFail(t *testing.T) {
+	testInitRegisterDispatcherFail(t, pinnedPrivateDispatcherName)
+}
+
+func TestInitRegisterUnpinnedDispatcherFail(t *testing.T) {
+	testInitRegisterDispatcherFail(t, unpinnedPrivateDispatcherName)
+}
+
 func mockRunAsGroupPassthrough(mdi *databasemocks.Plugin) {
 	rag := mdi.On("RunAsGroup", mock.Anything, mock.Anything).Maybe()
 	rag.RunFn = func(a mock.Arguments) {
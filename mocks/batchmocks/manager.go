@@ -3,10 +3,20 @@
 package batchmocks
 
 import (
+	context "context"
+
 	fftypes "github.com/hyperledger/firefly-common/pkg/fftypes"
 	batch "github.com/hyperledger/firefly/internal/batch"
 
+	core "github.com/hyperledger/firefly/pkg/core"
+
+	database "github.com/hyperledger/firefly/pkg/database"
+
 	mock "github.com/stretchr/testify/mock"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+
+	time "time"
 )
 
 // Manager is an autogenerated mock type for the Manager type
@@ -14,11 +24,184 @@ type Manager struct {
 	mock.Mock
 }
 
+// Checkpoints provides a mock function with given fields:
+func (_m *Manager) Checkpoints() <-chan *batch.Checkpoint {
+	ret := _m.Called()
+
+	var r0 <-chan *batch.Checkpoint
+	if rf, ok := ret.Get(0).(func() <-chan *batch.Checkpoint); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan *batch.Checkpoint)
+		}
+	}
+
+	return r0
+}
+
+// ClaimAndDispatch provides a mock function with given fields:
+func (_m *Manager) ClaimAndDispatch() (int, error) {
+	ret := _m.Called()
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func() int); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Close provides a mock function with given fields:
 func (_m *Manager) Close() {
 	_m.Called()
 }
 
+// DescribeDispatchers provides a mock function with given fields:
+func (_m *Manager) DescribeDispatchers() []*batch.DispatcherDescription {
+	ret := _m.Called()
+
+	var r0 []*batch.DispatcherDescription
+	if rf, ok := ret.Get(0).(func() []*batch.DispatcherDescription); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*batch.DispatcherDescription)
+		}
+	}
+
+	return r0
+}
+
+// Dispatchers provides a mock function with given fields:
+func (_m *Manager) Dispatchers() map[fftypes.FFEnum][]batch.DispatcherOptions {
+	ret := _m.Called()
+
+	var r0 map[fftypes.FFEnum][]batch.DispatcherOptions
+	if rf, ok := ret.Get(0).(func() map[fftypes.FFEnum][]batch.DispatcherOptions); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[fftypes.FFEnum][]batch.DispatcherOptions)
+		}
+	}
+
+	return r0
+}
+
+// DispatcherStats provides a mock function with given fields:
+func (_m *Manager) DispatcherStats() map[fftypes.FFEnum]*batch.DispatcherStats {
+	ret := _m.Called()
+
+	var r0 map[fftypes.FFEnum]*batch.DispatcherStats
+	if rf, ok := ret.Get(0).(func() map[fftypes.FFEnum]*batch.DispatcherStats); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[fftypes.FFEnum]*batch.DispatcherStats)
+		}
+	}
+
+	return r0
+}
+
+// DrainAndStop provides a mock function with given fields: ctx
+func (_m *Manager) DrainAndStop(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DryRunStats provides a mock function with given fields:
+func (_m *Manager) DryRunStats() []*batch.DryRunStats {
+	ret := _m.Called()
+
+	var r0 []*batch.DryRunStats
+	if rf, ok := ret.Get(0).(func() []*batch.DryRunStats); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*batch.DryRunStats)
+		}
+	}
+
+	return r0
+}
+
+// EnableDispatcher provides a mock function with given fields: txType, msgType, enabled
+func (_m *Manager) EnableDispatcher(txType fftypes.FFEnum, msgType fftypes.FFEnum, enabled bool) {
+	_m.Called(txType, msgType, enabled)
+}
+
+// GetAssemblyFailures provides a mock function with given fields: ctx, filter
+func (_m *Manager) GetAssemblyFailures(ctx context.Context, filter database.Filter) ([]*core.AssemblyFailure, *database.FilterResult, error) {
+	ret := _m.Called(ctx, filter)
+
+	var r0 []*core.AssemblyFailure
+	if rf, ok := ret.Get(0).(func(context.Context, database.Filter) []*core.AssemblyFailure); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*core.AssemblyFailure)
+		}
+	}
+
+	var r1 *database.FilterResult
+	if rf, ok := ret.Get(1).(func(context.Context, database.Filter) *database.FilterResult); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*database.FilterResult)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, database.Filter) error); ok {
+		r2 = rf(ctx, filter)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// IsHealthy provides a mock function with given fields:
+func (_m *Manager) IsHealthy() (bool, error) {
+	ret := _m.Called()
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func() bool); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // NewMessages provides a mock function with given fields:
 func (_m *Manager) NewMessages() chan<- int64 {
 	ret := _m.Called()
@@ -35,9 +218,174 @@ func (_m *Manager) NewMessages() chan<- int64 {
 	return r0
 }
 
+// NotifyNewMessage provides a mock function with given fields: seq
+func (_m *Manager) NotifyNewMessage(seq int64) {
+	_m.Called(seq)
+}
+
+// OnAssemblyStall provides a mock function with given fields: handler
+func (_m *Manager) OnAssemblyStall(handler batch.AssemblyStallHandler) {
+	_m.Called(handler)
+}
+
+// OnOffsetCommitted provides a mock function with given fields: handler
+func (_m *Manager) OnOffsetCommitted(handler batch.OffsetCommittedHandler) {
+	_m.Called(handler)
+}
+
+// Pause provides a mock function with given fields:
+func (_m *Manager) Pause() chan<- bool {
+	ret := _m.Called()
+
+	var r0 chan<- bool
+	if rf, ok := ret.Get(0).(func() chan<- bool); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(chan<- bool)
+		}
+	}
+
+	return r0
+}
+
+// PauseDispatch provides a mock function with given fields:
+func (_m *Manager) PauseDispatch() {
+	_m.Called()
+}
+
+// RedispatchMessage provides a mock function with given fields: ctx, msgID
+func (_m *Manager) RedispatchMessage(ctx context.Context, msgID *fftypes.UUID) error {
+	ret := _m.Called(ctx, msgID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *fftypes.UUID) error); ok {
+		r0 = rf(ctx, msgID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // RegisterDispatcher provides a mock function with given fields: name, txType, msgTypes, handler, batchOptions
-func (_m *Manager) RegisterDispatcher(name string, txType fftypes.FFEnum, msgTypes []fftypes.FFEnum, handler batch.DispatchHandler, batchOptions batch.DispatcherOptions) {
-	_m.Called(name, txType, msgTypes, handler, batchOptions)
+func (_m *Manager) RegisterDispatcher(name string, txType fftypes.FFEnum, msgTypes []fftypes.FFEnum, handler batch.DispatchHandler, batchOptions batch.DispatcherOptions) error {
+	ret := _m.Called(name, txType, msgTypes, handler, batchOptions)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, fftypes.FFEnum, []fftypes.FFEnum, batch.DispatchHandler, batch.DispatcherOptions) error); ok {
+		r0 = rf(name, txType, msgTypes, handler, batchOptions)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RegisterMetrics provides a mock function with given fields: registry
+func (_m *Manager) RegisterMetrics(registry *prometheus.Registry) {
+	_m.Called(registry)
+}
+
+// RegisterNoOpDispatcher provides a mock function with given fields: name, txType, msgTypes, options
+func (_m *Manager) RegisterNoOpDispatcher(name string, txType fftypes.FFEnum, msgTypes []fftypes.FFEnum, options batch.DispatcherOptions) {
+	_m.Called(name, txType, msgTypes, options)
+}
+
+// ReleaseDeferred provides a mock function with given fields: ctx, msgTypes
+func (_m *Manager) ReleaseDeferred(ctx context.Context, msgTypes []fftypes.FFEnum) error {
+	ret := _m.Called(ctx, msgTypes)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []fftypes.FFEnum) error); ok {
+		r0 = rf(ctx, msgTypes)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ReplayBatches provides a mock function with given fields: ctx, filter, handler
+func (_m *Manager) ReplayBatches(ctx context.Context, filter database.Filter, handler batch.ReplayHandler) error {
+	ret := _m.Called(ctx, filter, handler)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, database.Filter, batch.ReplayHandler) error); ok {
+		r0 = rf(ctx, filter, handler)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ReplayQuarantined provides a mock function with given fields: ctx, batchID, handler
+func (_m *Manager) ReplayQuarantined(ctx context.Context, batchID *fftypes.UUID, handler batch.ReplayHandler) error {
+	ret := _m.Called(ctx, batchID, handler)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *fftypes.UUID, batch.ReplayHandler) error); ok {
+		r0 = rf(ctx, batchID, handler)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ResetDispatcherStats provides a mock function with given fields:
+func (_m *Manager) ResetDispatcherStats() {
+	_m.Called()
+}
+
+// ResumeDispatch provides a mock function with given fields:
+func (_m *Manager) ResumeDispatch() {
+	_m.Called()
+}
+
+// Rewind provides a mock function with given fields: ctx, toSequence
+func (_m *Manager) Rewind(ctx context.Context, toSequence int64) error {
+	ret := _m.Called(ctx, toSequence)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) error); ok {
+		r0 = rf(ctx, toSequence)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetBatchAnnotator provides a mock function with given fields: annotator
+func (_m *Manager) SetBatchAnnotator(annotator batch.BatchAnnotator) {
+	_m.Called(annotator)
+}
+
+// SetBatchIDGenerator provides a mock function with given fields: generator
+func (_m *Manager) SetBatchIDGenerator(generator batch.BatchIDGenerator) {
+	_m.Called(generator)
+}
+
+// SetClock provides a mock function with given fields: clock
+func (_m *Manager) SetClock(clock batch.Clock) {
+	_m.Called(clock)
+}
+
+// SetMessageSource provides a mock function with given fields: source
+func (_m *Manager) SetMessageSource(source batch.MessageSource) {
+	_m.Called(source)
+}
+
+// SetQuarantineStore provides a mock function with given fields: store
+func (_m *Manager) SetQuarantineStore(store batch.QuarantineStore) {
+	_m.Called(store)
+}
+
+// SetRetryableError provides a mock function with given fields: classifier
+func (_m *Manager) SetRetryableError(classifier batch.RetryableErrorClassifier) {
+	_m.Called(classifier)
 }
 
 // Start provides a mock function with given fields:
@@ -70,11 +418,55 @@ func (_m *Manager) Status() *batch.ManagerStatus {
 	return r0
 }
 
+// TapDispatches provides a mock function with given fields:
+func (_m *Manager) TapDispatches() <-chan *core.Batch {
+	ret := _m.Called()
+
+	var r0 <-chan *core.Batch
+	if rf, ok := ret.Get(0).(func() <-chan *core.Batch); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan *core.Batch)
+		}
+	}
+
+	return r0
+}
+
+// UpdateDispatcherOptions provides a mock function with given fields: msgType, options
+func (_m *Manager) UpdateDispatcherOptions(msgType fftypes.FFEnum, options batch.DispatcherOptions) error {
+	ret := _m.Called(msgType, options)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(fftypes.FFEnum, batch.DispatcherOptions) error); ok {
+		r0 = rf(msgType, options)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // WaitStop provides a mock function with given fields:
 func (_m *Manager) WaitStop() {
 	_m.Called()
 }
 
+// WaitStopWithTimeout provides a mock function with given fields: timeout
+func (_m *Manager) WaitStopWithTimeout(timeout time.Duration) error {
+	ret := _m.Called(timeout)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(time.Duration) error); ok {
+		r0 = rf(timeout)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 type mockConstructorTestingTNewManager interface {
 	mock.TestingT
 	Cleanup(func())
@@ -37,6 +37,20 @@ func (_m *Plugin) Capabilities() *database.Capabilities {
 	return r0
 }
 
+// DeleteAssemblyFailure provides a mock function with given fields: ctx, namespace, msgID
+func (_m *Plugin) DeleteAssemblyFailure(ctx context.Context, namespace string, msgID *fftypes.UUID) error {
+	ret := _m.Called(ctx, namespace, msgID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, *fftypes.UUID) error); ok {
+		r0 = rf(ctx, namespace, msgID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteBlob provides a mock function with given fields: ctx, sequence
 func (_m *Plugin) DeleteBlob(ctx context.Context, sequence int64) error {
 	ret := _m.Called(ctx, sequence)
@@ -93,6 +107,34 @@ func (_m *Plugin) DeleteOffset(ctx context.Context, t fftypes.FFEnum, name strin
 	return r0
 }
 
+// DeleteOffsetHistory provides a mock function with given fields: ctx, t, name, keepRowID
+func (_m *Plugin) DeleteOffsetHistory(ctx context.Context, t fftypes.FFEnum, name string, keepRowID int64) error {
+	ret := _m.Called(ctx, t, name, keepRowID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, fftypes.FFEnum, string, int64) error); ok {
+		r0 = rf(ctx, t, name, keepRowID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteOutboxEntry provides a mock function with given fields: ctx, sequence
+func (_m *Plugin) DeleteOutboxEntry(ctx context.Context, sequence int64) error {
+	ret := _m.Called(ctx, sequence)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) error); ok {
+		r0 = rf(ctx, sequence)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteSubscriptionByID provides a mock function with given fields: ctx, namespace, id
 func (_m *Plugin) DeleteSubscriptionByID(ctx context.Context, namespace string, id *fftypes.UUID) error {
 	ret := _m.Called(ctx, namespace, id)
@@ -107,6 +149,38 @@ func (_m *Plugin) DeleteSubscriptionByID(ctx context.Context, namespace string,
 	return r0
 }
 
+// GetAssemblyFailures provides a mock function with given fields: ctx, namespace, filter
+func (_m *Plugin) GetAssemblyFailures(ctx context.Context, namespace string, filter database.Filter) ([]*core.AssemblyFailure, *database.FilterResult, error) {
+	ret := _m.Called(ctx, namespace, filter)
+
+	var r0 []*core.AssemblyFailure
+	if rf, ok := ret.Get(0).(func(context.Context, string, database.Filter) []*core.AssemblyFailure); ok {
+		r0 = rf(ctx, namespace, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*core.AssemblyFailure)
+		}
+	}
+
+	var r1 *database.FilterResult
+	if rf, ok := ret.Get(1).(func(context.Context, string, database.Filter) *database.FilterResult); ok {
+		r1 = rf(ctx, namespace, filter)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*database.FilterResult)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, string, database.Filter) error); ok {
+		r2 = rf(ctx, namespace, filter)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // GetBatchByID provides a mock function with given fields: ctx, namespace, id
 func (_m *Plugin) GetBatchByID(ctx context.Context, namespace string, id *fftypes.UUID) (*core.BatchPersisted, error) {
 	ret := _m.Called(ctx, namespace, id)
@@ -130,6 +204,29 @@ func (_m *Plugin) GetBatchByID(ctx context.Context, namespace string, id *fftype
 	return r0, r1
 }
 
+// GetBatchByMessageID provides a mock function with given fields: ctx, namespace, msgID
+func (_m *Plugin) GetBatchByMessageID(ctx context.Context, namespace string, msgID *fftypes.UUID) (*core.BatchPersisted, error) {
+	ret := _m.Called(ctx, namespace, msgID)
+
+	var r0 *core.BatchPersisted
+	if rf, ok := ret.Get(0).(func(context.Context, string, *fftypes.UUID) *core.BatchPersisted); ok {
+		r0 = rf(ctx, namespace, msgID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*core.BatchPersisted)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, *fftypes.UUID) error); ok {
+		r1 = rf(ctx, namespace, msgID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetBatchIDsForDataAttachments provides a mock function with given fields: ctx, namespace, dataIDs
 func (_m *Plugin) GetBatchIDsForDataAttachments(ctx context.Context, namespace string, dataIDs []*fftypes.UUID) ([]*fftypes.UUID, error) {
 	ret := _m.Called(ctx, namespace, dataIDs)
@@ -708,6 +805,29 @@ func (_m *Plugin) GetDatatypes(ctx context.Context, namespace string, filter dat
 	return r0, r1, r2
 }
 
+// GetDispatcherOptions provides a mock function with given fields: ctx, namespace, dispatcher
+func (_m *Plugin) GetDispatcherOptions(ctx context.Context, namespace string, dispatcher string) (*core.DispatcherOptionsRecord, error) {
+	ret := _m.Called(ctx, namespace, dispatcher)
+
+	var r0 *core.DispatcherOptionsRecord
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *core.DispatcherOptionsRecord); ok {
+		r0 = rf(ctx, namespace, dispatcher)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*core.DispatcherOptionsRecord)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, namespace, dispatcher)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetEventByID provides a mock function with given fields: ctx, namespace, id
 func (_m *Plugin) GetEventByID(ctx context.Context, namespace string, id *fftypes.UUID) (*core.Event, error) {
 	ret := _m.Called(ctx, namespace, id)
@@ -1217,6 +1337,36 @@ func (_m *Plugin) GetMessagesForData(ctx context.Context, namespace string, data
 	return r0, r1, r2
 }
 
+// GetMessagesWithCursor provides a mock function with given fields: ctx, namespace, filter, cursor
+func (_m *Plugin) GetMessagesWithCursor(ctx context.Context, namespace string, filter database.Filter, cursor string) ([]*core.Message, string, error) {
+	ret := _m.Called(ctx, namespace, filter, cursor)
+
+	var r0 []*core.Message
+	if rf, ok := ret.Get(0).(func(context.Context, string, database.Filter, string) []*core.Message); ok {
+		r0 = rf(ctx, namespace, filter, cursor)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*core.Message)
+		}
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(context.Context, string, database.Filter, string) string); ok {
+		r1 = rf(ctx, namespace, filter, cursor)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, string, database.Filter, string) error); ok {
+		r2 = rf(ctx, namespace, filter, cursor)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // GetNamespace provides a mock function with given fields: ctx, name
 func (_m *Plugin) GetNamespace(ctx context.Context, name string) (*core.Namespace, error) {
 	ret := _m.Called(ctx, name)
@@ -1428,6 +1578,38 @@ func (_m *Plugin) GetOperations(ctx context.Context, namespace string, filter da
 	return r0, r1, r2
 }
 
+// GetOutboxEntries provides a mock function with given fields: ctx, namespace, filter
+func (_m *Plugin) GetOutboxEntries(ctx context.Context, namespace string, filter database.Filter) ([]*core.OutboxEntry, *database.FilterResult, error) {
+	ret := _m.Called(ctx, namespace, filter)
+
+	var r0 []*core.OutboxEntry
+	if rf, ok := ret.Get(0).(func(context.Context, string, database.Filter) []*core.OutboxEntry); ok {
+		r0 = rf(ctx, namespace, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*core.OutboxEntry)
+		}
+	}
+
+	var r1 *database.FilterResult
+	if rf, ok := ret.Get(1).(func(context.Context, string, database.Filter) *database.FilterResult); ok {
+		r1 = rf(ctx, namespace, filter)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*database.FilterResult)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, string, database.Filter) error); ok {
+		r2 = rf(ctx, namespace, filter)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // GetPins provides a mock function with given fields: ctx, namespace, filter
 func (_m *Plugin) GetPins(ctx context.Context, namespace string, filter database.Filter) ([]*core.Pin, *database.FilterResult, error) {
 	ret := _m.Called(ctx, namespace, filter)
@@ -2229,6 +2411,20 @@ func (_m *Plugin) InsertOrGetBlockchainEvent(ctx context.Context, event *core.Bl
 	return r0, r1
 }
 
+// InsertOutboxEntry provides a mock function with given fields: ctx, entry
+func (_m *Plugin) InsertOutboxEntry(ctx context.Context, entry *core.OutboxEntry) error {
+	ret := _m.Called(ctx, entry)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *core.OutboxEntry) error); ok {
+		r0 = rf(ctx, entry)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // InsertPins provides a mock function with given fields: ctx, pins
 func (_m *Plugin) InsertPins(ctx context.Context, pins []*core.Pin) error {
 	ret := _m.Called(ctx, pins)
@@ -2360,6 +2556,27 @@ func (_m *Plugin) UpdateMessages(ctx context.Context, namespace string, filter d
 	return r0
 }
 
+// UpdateMessagesCount provides a mock function with given fields: ctx, namespace, filter, update
+func (_m *Plugin) UpdateMessagesCount(ctx context.Context, namespace string, filter database.Filter, update database.Update) (int64, error) {
+	ret := _m.Called(ctx, namespace, filter, update)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, string, database.Filter, database.Update) int64); ok {
+		r0 = rf(ctx, namespace, filter, update)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, database.Filter, database.Update) error); ok {
+		r1 = rf(ctx, namespace, filter, update)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // UpdateNextPin provides a mock function with given fields: ctx, namespace, sequence, update
 func (_m *Plugin) UpdateNextPin(ctx context.Context, namespace string, sequence int64, update database.Update) error {
 	ret := _m.Called(ctx, namespace, sequence, update)
@@ -2486,6 +2703,20 @@ func (_m *Plugin) UpdateTransaction(ctx context.Context, namespace string, id *f
 	return r0
 }
 
+// UpsertAssemblyFailure provides a mock function with given fields: ctx, failure
+func (_m *Plugin) UpsertAssemblyFailure(ctx context.Context, failure *core.AssemblyFailure) error {
+	ret := _m.Called(ctx, failure)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *core.AssemblyFailure) error); ok {
+		r0 = rf(ctx, failure)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpsertBatch provides a mock function with given fields: ctx, data
 func (_m *Plugin) UpsertBatch(ctx context.Context, data *core.BatchPersisted) error {
 	ret := _m.Called(ctx, data)
@@ -2542,6 +2773,20 @@ func (_m *Plugin) UpsertDatatype(ctx context.Context, datadef *core.Datatype, al
 	return r0
 }
 
+// UpsertDispatcherOptions provides a mock function with given fields: ctx, record
+func (_m *Plugin) UpsertDispatcherOptions(ctx context.Context, record *core.DispatcherOptionsRecord) error {
+	ret := _m.Called(ctx, record)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *core.DispatcherOptionsRecord) error); ok {
+		r0 = rf(ctx, record)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpsertFFI provides a mock function with given fields: ctx, cd
 func (_m *Plugin) UpsertFFI(ctx context.Context, cd *fftypes.FFI) error {
 	ret := _m.Called(ctx, cd)
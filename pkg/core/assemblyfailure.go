@@ -0,0 +1,32 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import "github.com/hyperledger/firefly-common/pkg/fftypes"
+
+// AssemblyFailure is a message the batch manager has repeatedly failed to assemble for dispatch, because the message
+// or some of its data could not be found. It is updated on each further attempt, and removed once the message is assembled.
+type AssemblyFailure struct {
+	Namespace   string          `ffstruct:"AssemblyFailure" json:"namespace"`
+	Message     *fftypes.UUID   `ffstruct:"AssemblyFailure" json:"message"`
+	MissingData FFStringArray   `ffstruct:"AssemblyFailure" json:"missingData"`
+	Attempts    int64           `ffstruct:"AssemblyFailure" json:"attempts"`
+	Error       string          `ffstruct:"AssemblyFailure" json:"error,omitempty"`
+	Created     *fftypes.FFTime `ffstruct:"AssemblyFailure" json:"created"`
+	Updated     *fftypes.FFTime `ffstruct:"AssemblyFailure" json:"updated"`
+	RowID       int64           `json:"-"` // Local database sequence
+}
@@ -33,6 +33,24 @@ var (
 	BatchTypePrivate = fftypes.FFEnumValue("batchtype", "private")
 )
 
+// BatchSealReason is the reason the batch manager sealed a batch, ending its assembly
+type BatchSealReason = fftypes.FFEnum
+
+var (
+	// BatchSealReasonSize is a batch that reached the maximum number of messages
+	BatchSealReasonSize = fftypes.FFEnumValue("batchsealreason", "size")
+	// BatchSealReasonBytes is a batch that reached the maximum size in bytes
+	BatchSealReasonBytes = fftypes.FFEnumValue("batchsealreason", "bytes")
+	// BatchSealReasonTimeout is a batch that was open for the batch timeout
+	BatchSealReasonTimeout = fftypes.FFEnumValue("batchsealreason", "timeout")
+	// BatchSealReasonAge is a batch whose first message reached the maximum age
+	BatchSealReasonAge = fftypes.FFEnumValue("batchsealreason", "age")
+	// BatchSealReasonFlush is a batch that was sealed on request, such as by a message that asked for an immediate flush
+	BatchSealReasonFlush = fftypes.FFEnumValue("batchsealreason", "flush")
+	// BatchSealReasonShutdown is a batch sealed with the remaining work of a processor that was shutting down
+	BatchSealReasonShutdown = fftypes.FFEnumValue("batchsealreason", "shutdown")
+)
+
 const (
 	ManifestVersionUnset uint = 0
 	ManifestVersion1     uint = 1
@@ -71,17 +89,23 @@ type BatchManifest struct {
 // Batch is the full payload object used in-flight.
 type Batch struct {
 	BatchHeader
-	Hash    *fftypes.Bytes32 `ffstruct:"Batch" json:"hash"`
-	Payload BatchPayload     `ffstruct:"Batch" json:"payload"`
+	Hash        *fftypes.Bytes32   `ffstruct:"Batch" json:"hash"`
+	Payload     BatchPayload       `ffstruct:"Batch" json:"payload"`
+	SealReason  BatchSealReason    `ffstruct:"Batch" json:"sealReason,omitempty" ffenum:"batchsealreason"`
+	Annotations fftypes.JSONObject `ffstruct:"Batch" json:"annotations,omitempty"`
 }
 
 // BatchPersisted is the structure written to the database
 type BatchPersisted struct {
 	BatchHeader
-	Hash      *fftypes.Bytes32 `ffstruct:"Batch" json:"hash"`
-	Manifest  *fftypes.JSONAny `ffstruct:"Batch" json:"manifest"`
-	TX        TransactionRef   `ffstruct:"Batch" json:"tx"`
-	Confirmed *fftypes.FFTime  `ffstruct:"Batch" json:"confirmed"`
+	Hash        *fftypes.Bytes32   `ffstruct:"Batch" json:"hash"`
+	Manifest    *fftypes.JSONAny   `ffstruct:"Batch" json:"manifest"`
+	TX          TransactionRef     `ffstruct:"Batch" json:"tx"`
+	Confirmed   *fftypes.FFTime    `ffstruct:"Batch" json:"confirmed"`
+	Correlator  *fftypes.UUID      `ffstruct:"Batch" json:"correlator,omitempty"`
+	SealReason  BatchSealReason    `ffstruct:"Batch" json:"sealReason,omitempty" ffenum:"batchsealreason"`
+	Annotations fftypes.JSONObject `ffstruct:"Batch" json:"annotations,omitempty"`
+	Provenance  fftypes.JSONObject `ffstruct:"Batch" json:"provenance,omitempty"`
 }
 
 // BatchPayload contains the full JSON of the messages and data, but
@@ -156,6 +180,8 @@ func (b *BatchPersisted) GenInflight(messages []*Message, data DataArray) *Batch
 			Messages: messages,
 			Data:     data,
 		},
+		SealReason:  b.SealReason,
+		Annotations: b.Annotations,
 	}
 }
 
@@ -169,5 +195,138 @@ func (b *Batch) Confirmed() (*BatchPersisted, *BatchManifest) {
 		TX:          b.Payload.TX,
 		Manifest:    fftypes.JSONAnyPtr(manifestString),
 		Confirmed:   fftypes.Now(),
+		SealReason:  b.SealReason,
+		Annotations: b.Annotations,
 	}, manifest
 }
+
+// Clone returns a deep copy of the batch - the header, and the messages and data in the payload - so that a
+// holder of the copy, such as a dispatch handler that retains it beyond the call, cannot race with the original
+func (b *Batch) Clone() *Batch {
+	if b == nil {
+		return nil
+	}
+	c := &Batch{
+		BatchHeader: b.BatchHeader.clone(),
+		Hash:        cloneBytes32(b.Hash),
+		Payload: BatchPayload{
+			TX: TransactionRef{Type: b.Payload.TX.Type, ID: cloneUUID(b.Payload.TX.ID)},
+		},
+		SealReason:  b.SealReason,
+		Annotations: cloneJSONObject(b.Annotations),
+	}
+	if b.Payload.Messages != nil {
+		c.Payload.Messages = make([]*Message, len(b.Payload.Messages))
+		for i, msg := range b.Payload.Messages {
+			c.Payload.Messages[i] = msg.clone()
+		}
+	}
+	if b.Payload.Data != nil {
+		c.Payload.Data = make(DataArray, len(b.Payload.Data))
+		for i, d := range b.Payload.Data {
+			c.Payload.Data[i] = d.clone()
+		}
+	}
+	return c
+}
+
+func (h BatchHeader) clone() BatchHeader {
+	h.ID = cloneUUID(h.ID)
+	h.Node = cloneUUID(h.Node)
+	h.Group = cloneBytes32(h.Group)
+	h.Created = cloneFFTime(h.Created)
+	return h
+}
+
+func (m *Message) clone() *Message {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	c.Header.ID = cloneUUID(m.Header.ID)
+	c.Header.CID = cloneUUID(m.Header.CID)
+	c.Header.Created = cloneFFTime(m.Header.Created)
+	c.Header.Group = cloneBytes32(m.Header.Group)
+	c.Header.Topics = cloneStringArray(m.Header.Topics)
+	c.Header.DataHash = cloneBytes32(m.Header.DataHash)
+	c.Hash = cloneBytes32(m.Hash)
+	c.BatchID = cloneUUID(m.BatchID)
+	c.Confirmed = cloneFFTime(m.Confirmed)
+	c.Pins = cloneStringArray(m.Pins)
+	if m.Data != nil {
+		c.Data = make(DataRefs, len(m.Data))
+		for i, dr := range m.Data {
+			if dr != nil {
+				c.Data[i] = &DataRef{ID: cloneUUID(dr.ID), Hash: cloneBytes32(dr.Hash), ValueSize: dr.ValueSize}
+			}
+		}
+	}
+	return &c
+}
+
+func (d *Data) clone() *Data {
+	if d == nil {
+		return nil
+	}
+	c := *d
+	c.ID = cloneUUID(d.ID)
+	c.Hash = cloneBytes32(d.Hash)
+	c.Created = cloneFFTime(d.Created)
+	if d.Datatype != nil {
+		datatype := *d.Datatype
+		c.Datatype = &datatype
+	}
+	if d.Value != nil {
+		value := *d.Value
+		c.Value = &value
+	}
+	if d.Blob != nil {
+		blob := *d.Blob
+		blob.Hash = cloneBytes32(d.Blob.Hash)
+		c.Blob = &blob
+	}
+	return &c
+}
+
+func cloneUUID(u *fftypes.UUID) *fftypes.UUID {
+	if u == nil {
+		return nil
+	}
+	c := *u
+	return &c
+}
+
+func cloneBytes32(b *fftypes.Bytes32) *fftypes.Bytes32 {
+	if b == nil {
+		return nil
+	}
+	c := *b
+	return &c
+}
+
+func cloneFFTime(t *fftypes.FFTime) *fftypes.FFTime {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+func cloneStringArray(sa FFStringArray) FFStringArray {
+	if sa == nil {
+		return nil
+	}
+	c := make(FFStringArray, len(sa))
+	copy(c, sa)
+	return c
+}
+
+// cloneJSONObject copies the object through its JSON serialization, so nested objects and arrays are not shared
+func cloneJSONObject(o fftypes.JSONObject) fftypes.JSONObject {
+	if o == nil {
+		return nil
+	}
+	var c fftypes.JSONObject
+	_ = json.Unmarshal([]byte(o.String()), &c)
+	return c
+}
@@ -43,6 +43,8 @@ func TestSQLSerializedManifest(t *testing.T) {
 				{Header: MessageHeader{ID: msgID2}},
 			},
 		},
+		SealReason:  BatchSealReasonTimeout,
+		Annotations: fftypes.JSONObject{"version": "1.0"},
 	}
 
 	bp, manifest := batch.Confirmed()
@@ -51,6 +53,8 @@ func TestSQLSerializedManifest(t *testing.T) {
 	assert.Equal(t, batch.Payload.TX, bp.TX)
 	assert.Equal(t, mfString, bp.Manifest.String())
 	assert.NotNil(t, bp.Confirmed)
+	assert.Equal(t, BatchSealReasonTimeout, bp.SealReason)
+	assert.Equal(t, batch.Annotations, bp.Annotations)
 
 	var mf *BatchManifest
 	err := json.Unmarshal([]byte(mfString), &mf)
@@ -65,3 +69,94 @@ func TestSQLSerializedManifest(t *testing.T) {
 	assert.NotEqual(t, batch.Payload.Hash().String(), hex.EncodeToString(mfHash[:]))
 
 }
+
+func TestBatchClone(t *testing.T) {
+	batch := &Batch{
+		BatchHeader: BatchHeader{
+			ID:      fftypes.NewUUID(),
+			Type:    BatchTypeBroadcast,
+			Node:    fftypes.NewUUID(),
+			Group:   fftypes.NewRandB32(),
+			Created: fftypes.Now(),
+			SignerRef: SignerRef{
+				Author: "did:firefly:org/abcd",
+				Key:    "0x12345",
+			},
+		},
+		Hash: fftypes.NewRandB32(),
+		Payload: BatchPayload{
+			TX: TransactionRef{Type: TransactionTypeBatchPin, ID: fftypes.NewUUID()},
+			Messages: []*Message{
+				{
+					Header: MessageHeader{
+						ID:      fftypes.NewUUID(),
+						CID:     fftypes.NewUUID(),
+						Created: fftypes.Now(),
+						Topics:  FFStringArray{"topic1"},
+					},
+					Hash: fftypes.NewRandB32(),
+					Data: DataRefs{{ID: fftypes.NewUUID(), Hash: fftypes.NewRandB32()}},
+					Pins: FFStringArray{"pin1"},
+				},
+				{Header: MessageHeader{ID: fftypes.NewUUID()}},
+				nil,
+			},
+			Data: DataArray{
+				{
+					ID:       fftypes.NewUUID(),
+					Hash:     fftypes.NewRandB32(),
+					Datatype: &DatatypeRef{Name: "widget", Version: "1.0"},
+					Value:    fftypes.JSONAnyPtr(`{"some":"data"}`),
+					Blob:     &BlobRef{Hash: fftypes.NewRandB32(), Name: "blob"},
+				},
+				nil,
+			},
+		},
+		SealReason:  BatchSealReasonSize,
+		Annotations: fftypes.JSONObject{"trace": map[string]interface{}{"id": "trace1"}},
+	}
+
+	clone := batch.Clone()
+	assert.Equal(t, batch, clone)
+
+	// Nothing is shared with the original
+	assert.NotSame(t, batch.ID, clone.ID)
+	assert.NotSame(t, batch.Hash, clone.Hash)
+	assert.NotSame(t, batch.Payload.TX.ID, clone.Payload.TX.ID)
+	msg, msgClone := batch.Payload.Messages[0], clone.Payload.Messages[0]
+	assert.NotSame(t, msg, msgClone)
+	assert.NotSame(t, msg.Header.ID, msgClone.Header.ID)
+	assert.NotSame(t, msg.Data[0], msgClone.Data[0])
+	msgClone.Header.Topics[0] = "changed"
+	msgClone.Pins[0] = "changed"
+	assert.Equal(t, "topic1", msg.Header.Topics[0])
+	assert.Equal(t, "pin1", msg.Pins[0])
+	data, dataClone := batch.Payload.Data[0], clone.Payload.Data[0]
+	assert.NotSame(t, data, dataClone)
+	assert.NotSame(t, data.Datatype, dataClone.Datatype)
+	assert.NotSame(t, data.Value, dataClone.Value)
+	assert.NotSame(t, data.Blob, dataClone.Blob)
+	assert.NotSame(t, data.Blob.Hash, dataClone.Blob.Hash)
+	clone.Annotations.GetObject("trace")["id"] = "changed"
+	assert.Equal(t, "trace1", batch.Annotations.GetObject("trace").GetString("id"))
+
+	var nilBatch *Batch
+	assert.Nil(t, nilBatch.Clone())
+	assert.Nil(t, (&Batch{}).Clone().Payload.Messages)
+	assert.Nil(t, (&Batch{}).Clone().Annotations)
+}
+
+func TestBatchSealReasonOptional(t *testing.T) {
+	var batch Batch
+	err := json.Unmarshal([]byte(`{"id":"`+fftypes.NewUUID().String()+`","hash":null}`), &batch)
+	assert.NoError(t, err)
+	assert.Equal(t, BatchSealReason(""), batch.SealReason)
+	b, err := json.Marshal(&batch)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "sealReason")
+
+	batch.SealReason = BatchSealReasonBytes
+	b, err = json.Marshal(&batch)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"sealReason":"bytes"`)
+}
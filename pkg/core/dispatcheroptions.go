@@ -0,0 +1,29 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import "github.com/hyperledger/firefly-common/pkg/fftypes"
+
+// DispatcherOptionsRecord is the options a batch dispatcher was last registered with, persisted by the batch manager
+// so that a change in the tuning of batches across a restart can be detected, and audited
+type DispatcherOptionsRecord struct {
+	Namespace  string           `json:"namespace"`
+	Dispatcher string           `json:"dispatcher"`
+	Options    *fftypes.JSONAny `json:"options"`
+	Updated    *fftypes.FFTime  `json:"updated"`
+	RowID      int64            `json:"_"` // Local database sequence
+}
@@ -65,15 +65,16 @@ var (
 
 // Event is an activity in the system, delivered reliably to applications, that indicates something has happened in the network
 type Event struct {
-	ID          *fftypes.UUID   `ffstruct:"Event" json:"id"`
-	Sequence    int64           `ffstruct:"Event" json:"sequence"`
-	Type        EventType       `ffstruct:"Event" json:"type" ffenum:"eventtype"`
-	Namespace   string          `ffstruct:"Event" json:"namespace"`
-	Reference   *fftypes.UUID   `ffstruct:"Event" json:"reference"`
-	Correlator  *fftypes.UUID   `ffstruct:"Event" json:"correlator,omitempty"`
-	Transaction *fftypes.UUID   `ffstruct:"Event" json:"tx,omitempty"`
-	Topic       string          `ffstruct:"Event" json:"topic,omitempty"`
-	Created     *fftypes.FFTime `ffstruct:"Event" json:"created"`
+	ID              *fftypes.UUID   `ffstruct:"Event" json:"id"`
+	Sequence        int64           `ffstruct:"Event" json:"sequence"`
+	Type            EventType       `ffstruct:"Event" json:"type" ffenum:"eventtype"`
+	Namespace       string          `ffstruct:"Event" json:"namespace"`
+	Reference       *fftypes.UUID   `ffstruct:"Event" json:"reference"`
+	Correlator      *fftypes.UUID   `ffstruct:"Event" json:"correlator,omitempty"`
+	BatchCorrelator *fftypes.UUID   `ffstruct:"Event" json:"batchCorrelator,omitempty"`
+	Transaction     *fftypes.UUID   `ffstruct:"Event" json:"tx,omitempty"`
+	Topic           string          `ffstruct:"Event" json:"topic,omitempty"`
+	Created         *fftypes.FFTime `ffstruct:"Event" json:"created"`
 }
 
 // EnrichedEvent adds the referred object to an event
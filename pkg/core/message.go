@@ -48,6 +48,16 @@ var (
 	MessageTypeTransferPrivate = fftypes.FFEnumValue("messagetype", "transfer_private")
 )
 
+// MessagePriority is the priority with which a message is assembled into a batch
+type MessagePriority = fftypes.FFEnum
+
+var (
+	// MessagePriorityNormal is the default priority, where messages are assembled into batches sized for throughput
+	MessagePriorityNormal = fftypes.FFEnumValue("messagepriority", "normal")
+	// MessagePriorityHigh is an urgent message, assembled into a separate small batch that is flushed quickly
+	MessagePriorityHigh = fftypes.FFEnumValue("messagepriority", "high")
+)
+
 // MessageState is the current transmission/confirmation state of a message
 type MessageState = fftypes.FFEnum
 
@@ -56,6 +66,10 @@ var (
 	MessageStateStaged = fftypes.FFEnumValue("messagestate", "staged")
 	// MessageStateReady is a message created locally which is ready to send
 	MessageStateReady = fftypes.FFEnumValue("messagestate", "ready")
+	// MessageStateAssembled is a message created locally which has been sealed into a batch, that is awaiting dispatch by a worker
+	MessageStateAssembled = fftypes.FFEnumValue("messagestate", "assembled")
+	// MessageStateBatching is a message created locally which has been sealed into a batch that is being dispatched
+	MessageStateBatching = fftypes.FFEnumValue("messagestate", "batching")
 	// MessageStateSent is a message created locally which has been sent in a batch
 	MessageStateSent = fftypes.FFEnumValue("messagestate", "sent")
 	// MessageStatePending is a message that has been received but is awaiting aggregation/confirmation
@@ -64,6 +78,16 @@ var (
 	MessageStateConfirmed = fftypes.FFEnumValue("messagestate", "confirmed")
 	// MessageStateRejected is a message that has completed confirmation, but has been rejected by FireFly
 	MessageStateRejected = fftypes.FFEnumValue("messagestate", "rejected")
+	// MessageStateFailed is a message created locally whose batch could not be dispatched, and was handed off to be dead-lettered
+	MessageStateFailed = fftypes.FFEnumValue("messagestate", "failed")
+	// MessageStateDeferred is a message created locally whose type has no transport yet, so it was handled by a no-op dispatcher without being sent
+	MessageStateDeferred = fftypes.FFEnumValue("messagestate", "deferred")
+	// MessageStateDeleted is a message that has been soft-deleted, whose row is retained as a tombstone but which is never sent
+	MessageStateDeleted = fftypes.FFEnumValue("messagestate", "deleted")
+	// MessageStateDuplicate is a message created locally that was skipped without being sent, as it had the same idempotency key as a message already dispatched
+	MessageStateDuplicate = fftypes.FFEnumValue("messagestate", "duplicate")
+	// MessageStateDryRun is a message created locally that was assembled into a batch by a dispatcher in dry-run mode, without being sent. It is transient, being held in memory only and never persisted
+	MessageStateDryRun = fftypes.FFEnumValue("messagestate", "dryrun")
 )
 
 // MessageHeader contains all fields that contribute to the hash
@@ -79,6 +103,8 @@ type MessageHeader struct {
 	Group     *fftypes.Bytes32 `ffstruct:"MessageHeader" json:"group,omitempty" ffexclude:"postNewMessageBroadcast"`
 	Topics    FFStringArray    `ffstruct:"MessageHeader" json:"topics,omitempty"`
 	Tag       string           `ffstruct:"MessageHeader" json:"tag,omitempty"`
+	Priority  MessagePriority  `ffstruct:"MessageHeader" json:"priority,omitempty" ffenum:"messagepriority"`
+	Flush     bool             `ffstruct:"MessageHeader" json:"flush,omitempty"`
 	DataHash  *fftypes.Bytes32 `ffstruct:"MessageHeader" json:"datahash,omitempty" ffexcludeinput:"true"`
 }
 
@@ -94,6 +120,7 @@ type Message struct {
 	Confirmed      *fftypes.FFTime  `ffstruct:"Message" json:"confirmed,omitempty" ffexcludeinput:"true"`
 	Data           DataRefs         `ffstruct:"Message" json:"data" ffexcludeinput:"true"`
 	Pins           FFStringArray    `ffstruct:"Message" json:"pins,omitempty" ffexcludeinput:"true"`
+	IdempotencyKey string           `ffstruct:"Message" json:"idempotencyKey,omitempty"`
 	Sequence       int64            `ffstruct:"Message" json:"-"` // Local database sequence used internally for batch assembly
 }
 
@@ -234,6 +261,11 @@ func (m *Message) VerifyFields(ctx context.Context) error {
 			return err
 		}
 	}
+	if m.Header.Priority != "" {
+		if _, err := fftypes.FFEnumParseString(ctx, "messagepriority", m.Header.Priority.String()); err != nil {
+			return err
+		}
+	}
 	return m.DupDataCheck(ctx)
 }
 
@@ -111,6 +111,17 @@ func TestVerifyBadTagString(t *testing.T) {
 	assert.Regexp(t, `FF00140.*header.tag`, err)
 }
 
+func TestVerifyBadPriority(t *testing.T) {
+	msg := Message{
+		Header: MessageHeader{
+			TxType:   TransactionTypeBatchPin,
+			Priority: "urgent",
+		},
+	}
+	err := msg.Verify(context.Background())
+	assert.Regexp(t, `FF00172.*urgent`, err)
+}
+
 func TestSealNilDataID(t *testing.T) {
 	msg := Message{
 		Header: MessageHeader{
@@ -0,0 +1,30 @@
+// Copyright © 2022 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import "github.com/hyperledger/firefly-common/pkg/fftypes"
+
+// OutboxEntry is a sealed batch written to the outbox, in the same database transaction that marks the messages
+// in the batch as dispatched - for a separate relay to pick up, and hand off to an external system
+type OutboxEntry struct {
+	Namespace  string           `json:"namespace"`
+	Batch      *fftypes.UUID    `json:"batch"`
+	Dispatcher string           `json:"dispatcher"`
+	Payload    *fftypes.JSONAny `json:"payload"`
+	Created    *fftypes.FFTime  `json:"created"`
+	Sequence   int64            `json:"_"` // Local database sequence, used by the relay to delete the entry once handed off
+}
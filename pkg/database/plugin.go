@@ -93,6 +93,10 @@ type iMessageCollection interface {
 	// UpdateMessages - Update messages
 	UpdateMessages(ctx context.Context, namespace string, filter Filter, update Update) (err error)
 
+	// UpdateMessagesCount - Update messages, returning the number of messages updated. As the update is atomic,
+	// this can be used to claim messages in a given state, where multiple processes compete for them.
+	UpdateMessagesCount(ctx context.Context, namespace string, filter Filter, update Update) (count int64, err error)
+
 	// GetMessageByID - Get a message by ID
 	GetMessageByID(ctx context.Context, namespace string, id *fftypes.UUID) (message *core.Message, err error)
 
@@ -102,6 +106,11 @@ type iMessageCollection interface {
 	// GetMessageIDs - Retrieves messages, but only querying the messages ID (no other fields)
 	GetMessageIDs(ctx context.Context, namespace string, filter Filter) (ids []*core.IDAndSequence, err error)
 
+	// GetMessagesWithCursor - List messages in ascending sequence order, after the position of an opaque cursor returned by a
+	// previous call (or from the start, for an empty cursor). Returns the cursor to continue from, which is stable as new
+	// messages are inserted, or messages are deleted. The limit of the filter applies - any sort or skip is ignored.
+	GetMessagesWithCursor(ctx context.Context, namespace string, filter Filter, cursor string) (message []*core.Message, next string, err error)
+
 	// GetMessagesForData - List messages where there is a data reference to the specified ID
 	GetMessagesForData(ctx context.Context, namespace string, dataID *fftypes.UUID, filter Filter) (message []*core.Message, res *FilterResult, err error)
 
@@ -144,6 +153,9 @@ type iBatchCollection interface {
 	// GetBatchByID - Get a batch by ID
 	GetBatchByID(ctx context.Context, namespace string, id *fftypes.UUID) (message *core.BatchPersisted, err error)
 
+	// GetBatchByMessageID - Get the batch a message was assembled into
+	GetBatchByMessageID(ctx context.Context, namespace string, msgID *fftypes.UUID) (message *core.BatchPersisted, err error)
+
 	// GetBatches - Get batches
 	GetBatches(ctx context.Context, namespace string, filter Filter) (message []*core.BatchPersisted, res *FilterResult, err error)
 }
@@ -191,6 +203,9 @@ type iOffsetCollection interface {
 
 	// DeleteOffset - Delete an offset by name
 	DeleteOffset(ctx context.Context, t core.OffsetType, name string) (err error)
+
+	// DeleteOffsetHistory - Delete any historical rows for an offset name, retaining only the current row
+	DeleteOffsetHistory(ctx context.Context, t core.OffsetType, name string, keepRowID int64) (err error)
 }
 
 type iPinCollection interface {
@@ -343,6 +358,36 @@ type iBlobCollection interface {
 	DeleteBlob(ctx context.Context, sequence int64) (err error)
 }
 
+type iOutboxCollection interface {
+	// InsertOutboxEntry - insert an outbox entry
+	InsertOutboxEntry(ctx context.Context, entry *core.OutboxEntry) (err error)
+
+	// GetOutboxEntries - get outbox entries
+	GetOutboxEntries(ctx context.Context, namespace string, filter Filter) (entries []*core.OutboxEntry, res *FilterResult, err error)
+
+	// DeleteOutboxEntry - delete an outbox entry, using its local database ID
+	DeleteOutboxEntry(ctx context.Context, sequence int64) (err error)
+}
+
+type iAssemblyFailureCollection interface {
+	// UpsertAssemblyFailure - upsert the record of repeated failures to assemble a message
+	UpsertAssemblyFailure(ctx context.Context, failure *core.AssemblyFailure) (err error)
+
+	// GetAssemblyFailures - get the messages that are failing to assemble
+	GetAssemblyFailures(ctx context.Context, namespace string, filter Filter) (failures []*core.AssemblyFailure, res *FilterResult, err error)
+
+	// DeleteAssemblyFailure - delete the record of failures to assemble a message, once it has been assembled
+	DeleteAssemblyFailure(ctx context.Context, namespace string, msgID *fftypes.UUID) (err error)
+}
+
+type iDispatcherOptionsCollection interface {
+	// UpsertDispatcherOptions - upsert the options for a batch dispatcher
+	UpsertDispatcherOptions(ctx context.Context, record *core.DispatcherOptionsRecord) (err error)
+
+	// GetDispatcherOptions - get the options last recorded for a batch dispatcher
+	GetDispatcherOptions(ctx context.Context, namespace, dispatcher string) (record *core.DispatcherOptionsRecord, err error)
+}
+
 type iTokenPoolCollection interface {
 	// UpsertTokenPool - Upsert a token pool
 	UpsertTokenPool(ctx context.Context, pool *core.TokenPool) error
@@ -550,6 +595,9 @@ type PersistenceInterface interface {
 	iNonceCollection
 	iNextPinCollection
 	iBlobCollection
+	iOutboxCollection
+	iDispatcherOptionsCollection
+	iAssemblyFailureCollection
 	iTokenPoolCollection
 	iTokenBalanceCollection
 	iTokenTransferCollection
@@ -669,38 +717,44 @@ type Capabilities struct {
 
 // MessageQueryFactory filter fields for messages
 var MessageQueryFactory = &queryFields{
-	"id":        &UUIDField{},
-	"cid":       &UUIDField{},
-	"type":      &StringField{},
-	"author":    &StringField{},
-	"key":       &StringField{},
-	"topics":    &FFStringArrayField{},
-	"tag":       &StringField{},
-	"group":     &Bytes32Field{},
-	"created":   &TimeField{},
-	"hash":      &Bytes32Field{},
-	"pins":      &FFStringArrayField{},
-	"state":     &StringField{},
-	"confirmed": &TimeField{},
-	"sequence":  &Int64Field{},
-	"txtype":    &StringField{},
-	"batch":     &UUIDField{},
+	"id":             &UUIDField{},
+	"cid":            &UUIDField{},
+	"type":           &StringField{},
+	"author":         &StringField{},
+	"key":            &StringField{},
+	"topics":         &FFStringArrayField{},
+	"tag":            &StringField{},
+	"group":          &Bytes32Field{},
+	"created":        &TimeField{},
+	"hash":           &Bytes32Field{},
+	"pins":           &FFStringArrayField{},
+	"state":          &StringField{},
+	"confirmed":      &TimeField{},
+	"sequence":       &Int64Field{},
+	"txtype":         &StringField{},
+	"batch":          &UUIDField{},
+	"idempotencykey": &StringField{},
+	"priority":       &StringField{},
+	"flush":          &BoolField{},
 }
 
 // BatchQueryFactory filter fields for batches
 var BatchQueryFactory = &queryFields{
-	"id":         &UUIDField{},
-	"type":       &StringField{},
-	"author":     &StringField{},
-	"key":        &StringField{},
-	"group":      &Bytes32Field{},
-	"hash":       &Bytes32Field{},
-	"payloadref": &StringField{},
-	"created":    &TimeField{},
-	"confirmed":  &TimeField{},
-	"tx.type":    &StringField{},
-	"tx.id":      &UUIDField{},
-	"node":       &UUIDField{},
+	"id":          &UUIDField{},
+	"type":        &StringField{},
+	"author":      &StringField{},
+	"key":         &StringField{},
+	"group":       &Bytes32Field{},
+	"hash":        &Bytes32Field{},
+	"payloadref":  &StringField{},
+	"created":     &TimeField{},
+	"confirmed":   &TimeField{},
+	"tx.type":     &StringField{},
+	"tx.id":       &UUIDField{},
+	"node":        &UUIDField{},
+	"correlator":  &UUIDField{},
+	"sealreason":  &StringField{},
+	"annotations": &JSONField{},
 }
 
 // TransactionQueryFactory filter fields for transactions
@@ -772,14 +826,15 @@ var SubscriptionQueryFactory = &queryFields{
 
 // EventQueryFactory filter fields for data events
 var EventQueryFactory = &queryFields{
-	"id":         &UUIDField{},
-	"type":       &StringField{},
-	"reference":  &UUIDField{},
-	"correlator": &UUIDField{},
-	"tx":         &UUIDField{},
-	"topic":      &StringField{},
-	"sequence":   &Int64Field{},
-	"created":    &TimeField{},
+	"id":              &UUIDField{},
+	"type":            &StringField{},
+	"reference":       &UUIDField{},
+	"correlator":      &UUIDField{},
+	"batchcorrelator": &UUIDField{},
+	"tx":              &UUIDField{},
+	"topic":           &StringField{},
+	"sequence":        &Int64Field{},
+	"created":         &TimeField{},
 }
 
 // PinQueryFactory filter fields for parked contexts
@@ -849,6 +904,25 @@ var BlobQueryFactory = &queryFields{
 	"created":    &TimeField{},
 }
 
+// OutboxQueryFactory filter fields for outbox entries
+var OutboxQueryFactory = &queryFields{
+	"batch":      &UUIDField{},
+	"dispatcher": &StringField{},
+	"created":    &TimeField{},
+	"sequence":   &Int64Field{},
+}
+
+// AssemblyFailureQueryFactory filter fields for assembly failures
+var AssemblyFailureQueryFactory = &queryFields{
+	"message":     &UUIDField{},
+	"missingdata": &FFStringArrayField{},
+	"attempts":    &Int64Field{},
+	"error":       &StringField{},
+	"created":     &TimeField{},
+	"updated":     &TimeField{},
+	"sequence":    &Int64Field{},
+}
+
 // TokenPoolQueryFactory filter fields for token pools
 var TokenPoolQueryFactory = &queryFields{
 	"id":        &UUIDField{},